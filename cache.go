@@ -1,8 +1,11 @@
 package ggcache
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,7 +13,8 @@ import (
 // Applications can implement this interface to integrate different caching managers.
 type Cacher interface {
 	// Get returns the value associated with the specified key.
-	// If the key is not found or an error occurs, an error object is returned.
+	// If the key is not found, the error wraps ErrKeyNotFound. A value served
+	// although it expired is returned along with an error wrapping ErrStale.
 	Get(key []byte) ([]byte, error)
 
 	// Set adds the value associated with the specified key to the cache with the specified expiration time.
@@ -21,10 +25,128 @@ type Cacher interface {
 	Has(key []byte) bool
 
 	// Delete removes the specified key from the cache.
-	// If the key is not found, an error object is returned.
+	// Deleting a key that is not found is not an error.
 	Delete(key []byte) error
 }
 
+// Toucher is implemented by Cachers that can change the expiration of an
+// existing entry without rewriting its value.
+type Toucher interface {
+	// Touch resets the expiration of the specified key to the specified duration from now.
+	// If the duration is zero, the entry no longer expires.
+	// If the key is not found, the error wraps ErrKeyNotFound.
+	Touch(key []byte, expiration time.Duration) error
+}
+
+// Appender is implemented by Cachers that can append to a value in place of
+// reading and rewriting it.
+type Appender interface {
+	// Append appends data to the value of the specified key, keeping its expiration.
+	// If the key is not found, the error wraps ErrKeyNotFound.
+	Append(key []byte, data []byte) error
+}
+
+// ExpiryGetter is implemented by Cachers that can tell when an entry expires,
+// so it can be stored again with the TTL it has left.
+type ExpiryGetter interface {
+	// Expiry returns when the entry of the specified key expires, the zero Time if it does not.
+	// If the key is not found, the error wraps ErrKeyNotFound.
+	Expiry(key []byte) (time.Time, error)
+}
+
+// RangeGetter is implemented by Cachers that can return part of a value.
+type RangeGetter interface {
+	// GetRange returns up to length bytes of the value of the specified key starting at offset.
+	// The range is clipped to the end of the value, so it is empty if offset is past it.
+	// If the key is not found, the error wraps ErrKeyNotFound. A negative range is an error too.
+	GetRange(key []byte, offset, length int) ([]byte, error)
+}
+
+// ConditionalSetter is implemented by Cachers that can set a value only if
+// the current one matches a Condition, checked atomically with the write.
+type ConditionalSetter interface {
+	// SetIf sets the value like Set if the condition holds for the current value of the specified key.
+	// Otherwise ErrPreconditionFailed is returned and the value is not stored.
+	SetIf(key []byte, value []byte, expiration time.Duration, cond Condition) error
+}
+
+// ConditionalDeleter is implemented by Cachers that can delete a value only
+// if it matches a Condition, checked atomically with the delete, e.g. to
+// release a lock only while still holding it.
+type ConditionalDeleter interface {
+	// DeleteIf deletes the specified key like Delete if the condition holds for its current value.
+	// Otherwise ErrPreconditionFailed is returned and the value is left.
+	DeleteIf(key []byte, cond Condition) error
+}
+
+// Renamer is implemented by Cachers that can move or copy an entry to
+// another key atomically, so a value built under a temporary key can be
+// published in one step.
+type Renamer interface {
+	// Rename moves the value and expiration of oldKey to newKey, replacing any value of newKey.
+	// If oldKey is not found, the error wraps ErrKeyNotFound and newKey is left unchanged.
+	Rename(oldKey, newKey []byte) error
+
+	// Copy stores the value of src under dst with the specified expiration, replacing any value of dst.
+	// If the duration is zero, the copy does not expire.
+	// If src is not found, the error wraps ErrKeyNotFound and dst is left unchanged.
+	Copy(src, dst []byte, expiration time.Duration) error
+}
+
+// Sampler is implemented by Cachers that can pick entries at random, so the
+// memory taken by the cache can be analysed without walking all of it.
+type Sampler interface {
+	// Sample calls fn with the key and value size of up to n entries picked at random.
+	// fn must not call into the cache.
+	Sample(n int, fn func(key []byte, size int))
+}
+
+// Scanner is implemented by Cachers that can walk their keys, so the keys
+// matching a pattern can be found without knowing them in advance.
+type Scanner interface {
+	// Scan calls fn with the keys matching the pattern, as of MatchKey, until fn returns false.
+	// fn must not call into the cache.
+	Scan(pattern []byte, fn func(key []byte) bool)
+}
+
+// CreatedScanner is implemented by Cachers that record when the value of
+// each entry was written, so those written before a time can be found, e.g.
+// to invalidate everything written before a bad deploy.
+type CreatedScanner interface {
+	// ScanCreated calls fn with the keys starting with prefix whose value was written before the time, until fn returns false.
+	// fn must not call into the cache.
+	ScanCreated(prefix []byte, before time.Time, fn func(key []byte) bool)
+}
+
+// StableValues is implemented by Cachers whose Get returns slices that are
+// never modified afterwards, even when the key is overwritten or deleted, so
+// callers can write them out without copying them first.
+type StableValues interface {
+	// StableValues is a marker method; it does nothing.
+	StableValues()
+}
+
+// ErrKeyNotFound is wrapped, along with the key, by the errors of the reads
+// and writes of a key that is not in the cache or has expired, by Cache and
+// the Cachers of the cache packages alike.
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrPersistence is wrapped by the errors of the writes that failed because
+// the Cacher could not persist them, e.g. as its disk is full.
+var ErrPersistence = errors.New("persistence failed")
+
+// ErrCorrupted is wrapped, along with the key, by the errors of the reads of
+// a value that does not match the checksum stored with it, e.g. as it rotted
+// on disk, by the Cachers verifying one instead of serving the value.
+var ErrCorrupted = errors.New("corrupted value")
+
+// PersistenceChecker is implemented by Cachers that persist their writes, so
+// a server can tell when the writes it acknowledges are no longer durable.
+type PersistenceChecker interface {
+	// PersistenceErr returns why the latest writes are not persisted, or nil if they all are.
+	PersistenceErr() error
+}
+
 // Cache is a simple in-memory cache implementation.
 // It utilizes a sync.RWMutex for concurrent read and write safety.
 // The cache stores data as byte slices, using string keys for retrieval.
@@ -32,72 +154,394 @@ type Cache struct {
 	// lock is a sync.RWMutex to ensure concurrent read and write safety.
 	lock sync.RWMutex
 
-	// data is a map that stores byte slices with string keys for retrieval.
-	data map[string][]byte
+	// data is a map that stores the cache entries with string keys for retrieval.
+	// shared is set while a View may hold it, so it is copied before it is
+	// next written, guarded by lock.
+	data   map[string]*entry
+	shared bool
+
+	// bytes is the total size of the stored keys and values, and ttls the
+	// histogram of the TTLs they were set with, both guarded by lock.
+	bytes int
+	ttls  [numTTLBuckets + 1]int
+
+	// stats holds the operation counters reported by Stats.
+	stats counters
+
+	// expiry holds the expirations of the entries stored in bulk and
+	// expiryTimer fires at the earliest of them, both guarded by lock.
+	expiry      expiryHeap
+	expiryTimer *time.Timer
+
+	// timers is the number of entries expiring by their own timer, and
+	// sweeps the runs of the expiry heap reported by ExpiryStats, both
+	// guarded by lock.
+	timers int
+	sweeps sweepStats
+
+	// maxIdle is the time after which an entry that is not accessed is
+	// evicted, zero if they are not. idleTick is the coarse clock their
+	// accesses are recorded with and idleTimer advances it, guarded by lock.
+	maxIdle   time.Duration
+	idleTick  atomic.Int64
+	idleTimer *time.Timer
+
+	// expiredReads is how reads answer for an expired entry that was not
+	// removed yet, and extension the TTL ExpiredExtend stores it again with.
+	expiredReads ExpiredReads
+	extension    time.Duration
+
+	// onRemoval is the fn of WatchRemovals, guarded by lock.
+	onRemoval func(key []byte, reason RemovalReason)
+}
+
+// entry is a value stored in the cache together with its expiration.
+// An entry is never modified once stored; updates replace it, which lets a
+// pending expiration timer tell whether its entry is still the current one.
+type entry struct {
+	// value is the cached byte slice.
+	value []byte
+
+	// expiresAt is the time the entry expires, or the zero time if it does not.
+	// ttlBucket is the bucket of the TTL it was set with in the histogram.
+	expiresAt time.Time
+	ttlBucket int
+
+	// expired is set by the first read that finds the entry expired before
+	// it is removed, which counts it as a lazy expiration.
+	expired atomic.Bool
+
+	// timer removes the entry once it expires, nil if it does not expire or
+	// was stored in bulk, in which case its expiration is in the expiry heap.
+	timer *time.Timer
+
+	// owned reports whether the spare capacity of value belongs to the cache,
+	// so Append can fill it instead of copying the value. Appending past the
+	// length of a value does not change the bytes already returned by Get.
+	owned bool
+
+	// accessed is the idle clock tick of the last access of the entry, only
+	// recorded with a max idle time.
+	accessed atomic.Int64
+
+	// created is when the value was written, in unix nanoseconds. Touch and
+	// Rename keep it, while every write of a value, Append included, sets it.
+	created int64
 }
 
 // New creates and returns a new instance of the Cache with initialized internal data.
 // The Cache is an in-memory cache implementation using a sync.RWMutex for concurrency safety.
-// The internal data is represented as a map with string keys and byte slice values.
-func New() *Cache {
-	return &Cache{
-		data: make(map[string][]byte),
+// The internal data is represented as a map with string keys and entry values.
+// Options such as WithMaxIdle are applied in order.
+func New(opts ...Option) *Cache {
+	c := &Cache{
+		data: make(map[string]*entry),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 // Get retrieves the value associated with the specified key from the cache.
 // It acquires a read lock to ensure concurrent safety during retrieval.
 // If the key is not found, an error is returned indicating the absence of the key.
 // The retrieved value and a nil error are returned if the key is present in the cache.
+// An entry past its expiration is handled as set by WithExpiredReads.
 func (c *Cache) Get(key []byte) ([]byte, error) {
-	// Acquire a read lock to ensure concurrent safety during retrieval.
-	c.lock.RLock()
-	defer c.lock.RUnlock()
+	// Look up the entry, applying the ExpiredReads policy if it expired.
+	e, stale := c.lookup(string(key))
+	if e == nil {
+		c.stats.misses.Add(1)
+		// Return an error if the key is not found.
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	c.stats.hits.Add(1)
+
+	// Return the retrieved value and a nil error if the key is present in the cache.
+	// The capacity is capped so a caller appending to it cannot write into the spare capacity kept for Append.
+	value := e.value[:len(e.value):len(e.value)]
+	if stale {
+		return value, fmt.Errorf("%w: %s", ErrStale, key)
+	}
+	return value, nil
+}
+
+// GetRange retrieves part of the value associated with the specified key.
+// It acquires a read lock to ensure concurrent safety during retrieval.
+// The range is clipped to the end of the value.
+// If the key is not found or the range is negative, an error is returned.
+// An expired entry is handled like Get does.
+func (c *Cache) GetRange(key []byte, offset, length int) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid range %d+%d", offset, length)
+	}
+
+	e, stale := c.lookup(string(key))
+	if e == nil {
+		c.stats.misses.Add(1)
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	c.stats.hits.Add(1)
+
+	start := min(offset, len(e.value))
+	end := start + min(length, len(e.value)-start)
+	if stale {
+		return e.value[start:end:end], fmt.Errorf("%w: %s", ErrStale, key)
+	}
+	return e.value[start:end:end], nil
+}
+
+// Append appends data to the value associated with the specified key.
+// It acquires a write lock to ensure concurrent safety during the update.
+// The entry keeps its expiration. Repeated appends grow the value like the built-in append, so they are not copied every time.
+// If the key is not found, an error is returned indicating the absence of the key.
+func (c *Cache) Append(key, data []byte) error {
+	// Acquire a write lock to ensure concurrent safety during the update.
+	c.lock.Lock()
+	defer c.lock.Unlock()
 
 	// Convert the byte slice key to a string for map lookup.
 	keyStr := string(key)
 
-	// Retrieve the value associated with the key from the internal data map.
-	val, ok := c.data[keyStr]
-	if !ok {
+	e := c.live(keyStr)
+	if e == nil {
 		// Return an error if the key is not found.
-		return nil, fmt.Errorf("key (%s) not found", keyStr)
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, keyStr)
 	}
 
-	// Return the retrieved value and a nil error if the key is present in the cache.
-	return val, nil
+	// The spare capacity of a value stored by Set belongs to the caller, so it is copied on the first append.
+	value := e.value
+	if !e.owned {
+		value = make([]byte, len(e.value), len(e.value)+len(data))
+		copy(value, e.value)
+	}
+	value = append(value, data...)
+
+	var ttl time.Duration
+	if !e.expiresAt.IsZero() {
+		if ttl = time.Until(e.expiresAt); ttl <= 0 {
+			// About to expire anyway; keep it from never expiring.
+			ttl = time.Nanosecond
+		}
+	}
+	c.store(keyStr, value, ttl).owned = true
+	c.stats.sets.Add(1)
+
+	return nil
 }
 
 // Set adds or updates the cache with the specified key-value pair.
 // It acquires a write lock to ensure concurrent safety during insertion.
-// If the time-to-live (TTL) duration is greater than zero, a goroutine is launched to remove the entry after the specified duration.
-// The key-value pair is stored in the cache, and if a TTL is set, the entry is automatically deleted after the specified duration.
+// If the time-to-live (TTL) duration is greater than zero, a timer is started to remove the entry after the specified duration.
+// Overwriting a key replaces its entry, so the timer of the previous value no longer applies.
 // The method returns nil, indicating a successful operation.
 func (c *Cache) Set(key, value []byte, ttl time.Duration) error {
 	// Acquire a write lock to ensure concurrent safety during insertion.
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	// Convert the byte slice key to a string for map storage.
+	// Add or update the cache with the specified key-value pair.
+	c.store(string(key), value, ttl)
+	c.stats.sets.Add(1)
+
+	// Return nil, indicating a successful operation.
+	return nil
+}
+
+// Touch resets the expiration of the specified key without rewriting its value.
+// It acquires a write lock to ensure concurrent safety during the update.
+// If the TTL is zero, the entry no longer expires.
+// If the key is not found, an error is returned indicating the absence of the key.
+func (c *Cache) Touch(key []byte, ttl time.Duration) error {
+	// Acquire a write lock to ensure concurrent safety during the update.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Convert the byte slice key to a string for map lookup.
 	keyStr := string(key)
 
-	// Add or update the cache with the specified key-value pair.
-	c.data[keyStr] = value
+	e := c.live(keyStr)
+	if e == nil {
+		// Return an error if the key is not found.
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, keyStr)
+	}
 
-	// If TTL is greater than zero, launch a goroutine to remove the entry after the specified duration.
-	if ttl > 0 {
-		go func() {
-			<-time.After(ttl)
-			c.lock.Lock()
-			defer c.lock.Unlock()
-			delete(c.data, keyStr)
-		}()
+	// Store the same value again with the new expiration.
+	c.restamp(keyStr, e, ttl)
+
+	return nil
+}
+
+// StableValues marks the values returned by Get as never modified: entries
+// are replaced, not updated in place.
+func (c *Cache) StableValues() {}
+
+// Expiry returns when the entry of the specified key expires.
+// It acquires a read lock to ensure concurrent safety during the lookup.
+// The zero Time is returned for an entry without expiration.
+func (c *Cache) Expiry(key []byte) (time.Time, error) {
+	// Acquire a read lock to ensure concurrent safety during the lookup.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	e, ok := c.data[string(key)]
+	if !ok || c.expiredOnRead(e) {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
 	}
+	return e.expiresAt, nil
+}
+
+// Rename moves the value of oldKey to newKey, keeping its expiration.
+// It acquires a write lock so no reader sees the value under both keys or neither.
+// Renaming a key to itself only checks that it exists.
+// If oldKey is not found, an error is returned indicating the absence of the key.
+func (c *Cache) Rename(oldKey, newKey []byte) error {
+	// Acquire a write lock to ensure concurrent safety during the move.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	oldStr, newStr := string(oldKey), string(newKey)
+	e := c.live(oldStr)
+	if e == nil {
+		// Return an error if the key is not found.
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, oldStr)
+	}
+	if oldStr == newStr {
+		return nil
+	}
+
+	var ttl time.Duration
+	if !e.expiresAt.IsZero() {
+		if ttl = time.Until(e.expiresAt); ttl <= 0 {
+			// About to expire anyway; keep it from never expiring.
+			ttl = time.Nanosecond
+		}
+	}
+	c.remove(oldStr)
+	c.restamp(newStr, e, ttl)
 
-	// Return nil, indicating a successful operation.
 	return nil
 }
 
+// Copy stores the value of src under dst with the specified TTL.
+// It acquires a write lock to ensure concurrent safety during the copy.
+// The value is shared by both keys, as entries are never modified in place.
+// If src is not found, an error is returned indicating the absence of the key.
+func (c *Cache) Copy(src, dst []byte, ttl time.Duration) error {
+	// Acquire a write lock to ensure concurrent safety during the copy.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	srcStr := string(src)
+	e := c.live(srcStr)
+	if e == nil {
+		// Return an error if the key is not found.
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, srcStr)
+	}
+
+	// The spare capacity of the value may be filled by an Append to src, so dst does not own it.
+	c.store(string(dst), e.value[:len(e.value):len(e.value)], ttl)
+	c.stats.sets.Add(1)
+
+	return nil
+}
+
+// SetIf adds or updates the cache with the specified key-value pair if the condition holds for the current value.
+// It acquires a write lock so the condition is checked against the value being replaced.
+// If the condition does not hold, ErrPreconditionFailed is returned.
+func (c *Cache) SetIf(key, value []byte, ttl time.Duration, cond Condition) error {
+	// Acquire a write lock to ensure concurrent safety during the check and insertion.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var current []byte
+	e := c.live(string(key))
+	if e != nil {
+		current = e.value
+	}
+	if !cond.Holds(current, e != nil) {
+		return ErrPreconditionFailed
+	}
+
+	c.store(string(key), value, ttl)
+	c.stats.sets.Add(1)
+
+	return nil
+}
+
+// DeleteIf removes the specified key from the cache if the condition holds for its current value.
+// It acquires a write lock so the condition is checked against the value being removed.
+// If the condition does not hold, ErrPreconditionFailed is returned.
+func (c *Cache) DeleteIf(key []byte, cond Condition) error {
+	// Acquire a write lock to ensure concurrent safety during the check and deletion.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var current []byte
+	e := c.live(string(key))
+	if e != nil {
+		current = e.value
+	}
+	if !cond.Holds(current, e != nil) {
+		return ErrPreconditionFailed
+	}
+
+	if c.remove(string(key)) {
+		c.stats.deletes.Add(1)
+	}
+
+	return nil
+}
+
+// Sample calls fn with up to n entries of the cache.
+// It acquires a read lock for the duration of the walk.
+// Entries are taken in map iteration order, which starts at a random point of the map, so repeated samples differ.
+func (c *Cache) Sample(n int, fn func(key []byte, size int)) {
+	// Acquire a read lock to ensure concurrent safety during the walk.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for key, e := range c.data {
+		if n <= 0 {
+			return
+		}
+		fn([]byte(key), len(e.value))
+		n--
+	}
+}
+
+// Scan calls fn with the keys of the cache matching the pattern, until fn returns false.
+// It acquires a read lock for the duration of the walk, which visits every key.
+func (c *Cache) Scan(pattern []byte, fn func(key []byte) bool) {
+	// Acquire a read lock to ensure concurrent safety during the walk.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for key := range c.data {
+		if MatchKey(pattern, []byte(key)) && !fn([]byte(key)) {
+			return
+		}
+	}
+}
+
+// ScanCreated calls fn with the keys of the cache starting with prefix whose
+// value was written before the time, until fn returns false. The entries
+// loaded by Restore count as written when they were loaded.
+// It acquires a read lock for the duration of the walk, which visits every key.
+func (c *Cache) ScanCreated(prefix []byte, before time.Time, fn func(key []byte) bool) {
+	// Acquire a read lock to ensure concurrent safety during the walk.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	cutoff, p := before.UnixNano(), string(prefix)
+	for key, e := range c.data {
+		if e.created < cutoff && strings.HasPrefix(key, p) && !fn([]byte(key)) {
+			return
+		}
+	}
+}
+
 // Has checks if the specified key exists in the cache.
 // It acquires a read lock to ensure concurrent safety during the lookup.
 // The method returns true if the key is found in the cache, and false otherwise.
@@ -106,11 +550,11 @@ func (c *Cache) Has(key []byte) bool {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 
-	// Check if the key exists in the cache.
-	_, ok := c.data[string(key)]
+	// Check if the key exists in the cache and has not expired.
+	e, ok := c.data[string(key)]
 
 	// Return true if the key is found, and false otherwise.
-	return ok
+	return ok && !c.expiredOnRead(e)
 }
 
 // Delete removes the specified key from the cache.
@@ -122,8 +566,118 @@ func (c *Cache) Delete(key []byte) error {
 	defer c.lock.Unlock()
 
 	// Remove the specified key from the cache.
-	delete(c.data, string(key))
+	if c.remove(string(key)) {
+		c.stats.deletes.Add(1)
+	}
 
 	// Return nil, indicating a successful deletion.
 	return nil
 }
+
+// store replaces the entry of the key with a new one holding the value and
+// schedules its expiration if the TTL is greater than zero.
+// The caller must hold the write lock.
+func (c *Cache) store(key string, value []byte, ttl time.Duration) *entry {
+	c.remove(key)
+
+	now := time.Now()
+	e := &entry{value: value, created: now.UnixNano()}
+	if ttl > 0 {
+		e.expiresAt = now.Add(ttl)
+		e.ttlBucket = ttlBucket(ttl)
+		c.ttls[e.ttlBucket]++
+		e.timer = time.AfterFunc(ttl, func() {
+			c.expire(key, e)
+		})
+		c.timers++
+	}
+	e.accessed.Store(c.idleTick.Load())
+
+	c.unshare()
+	c.data[key] = e
+	c.bytes += len(key) + len(value)
+	c.scheduleIdle()
+	return e
+}
+
+// restamp stores the value of the entry again under the key with the TTL,
+// keeping whether the cache owns it and when it was written.
+// The caller must hold the write lock.
+func (c *Cache) restamp(key string, e *entry, ttl time.Duration) *entry {
+	n := c.store(key, e.value, ttl)
+	n.owned, n.created = e.owned, e.created
+	return n
+}
+
+// expire removes the entry of the key once its TTL ran out, unless it has
+// been replaced or deleted in the meantime.
+func (c *Cache) expire(key string, e *entry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.data[key] != e {
+		return
+	}
+	c.remove(key)
+	c.expired(key, e)
+}
+
+// expiredOnRead reports whether the entry expired before its timer removed
+// it, counting it as a lazy expiration the first time.
+// The caller must hold at least the read lock.
+func (c *Cache) expiredOnRead(e *entry) bool {
+	if !e.pastExpiry(time.Now()) {
+		return false
+	}
+	if e.expired.CompareAndSwap(false, true) {
+		c.stats.lazy.Add(1)
+	}
+	return true
+}
+
+// live returns the entry of the key for a write, nil if there is none. An
+// entry past its expiration is removed first, as Get does by default, so a
+// write never revives it.
+// The caller must hold the write lock.
+func (c *Cache) live(key string) *entry {
+	e, ok := c.data[key]
+	if !ok {
+		return nil
+	}
+	if c.expiredOnRead(e) {
+		c.remove(key)
+		c.removed(key, RemovalExpired)
+		return nil
+	}
+	return e
+}
+
+// expired counts the removal of an expired entry, unless a read already
+// counted it, and reports it. The caller must hold the write lock.
+func (c *Cache) expired(key string, e *entry) {
+	if !e.expired.Load() {
+		c.stats.expirations.Add(1)
+	}
+	c.removed(key, RemovalExpired)
+}
+
+// remove deletes the key from the internal data map, stops its expiration
+// timer and keeps the size accounting in sync. It reports whether the key was present.
+// The caller must hold the write lock.
+func (c *Cache) remove(key string) bool {
+	e, ok := c.data[key]
+	if !ok {
+		return false
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+		c.timers--
+	}
+	if !e.expiresAt.IsZero() {
+		c.ttls[e.ttlBucket]--
+	}
+	c.unshare()
+	delete(c.data, key)
+	c.bytes -= len(key) + len(e.value)
+	return true
+}
@@ -0,0 +1,89 @@
+// Package bigcache adapts a BigCache to ggcache.Cacher, so a node can keep
+// large numbers of entries off the Go heap and out of the way of the garbage
+// collector.
+//
+//	bc, err := bigcache.New(ctx, bigcache.DefaultConfig(24*time.Hour))
+//	cache := ggbigcache.New(bc)
+//
+// The adapter only relies on the method set of *bigcache.BigCache
+// (github.com/allegro/bigcache/v3), described by Engine, so this module does
+// not depend on BigCache itself.
+//
+// BigCache evicts every entry after the same LifeWindow and has no per-entry
+// TTL. The adapter stores the expiration of an entry in front of its value and
+// treats expired entries as missing, so TTLs shorter than the LifeWindow work
+// as they do with the other engines. Longer TTLs are cut short by the
+// LifeWindow.
+package bigcache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// Engine is the subset of *bigcache.BigCache the adapter uses.
+type Engine interface {
+	Get(key string) ([]byte, error)
+	Set(key string, entry []byte) error
+	Delete(key string) error
+}
+
+// Cache implements ggcache.Cacher on top of a BigCache.
+type Cache struct {
+	engine Engine
+}
+
+func New(engine Engine) *Cache {
+	return &Cache{
+		engine: engine,
+	}
+}
+
+func (c *Cache) Get(key []byte) ([]byte, error) {
+	value, ok := c.get(string(key))
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	return value, nil
+}
+
+func (c *Cache) Set(key, value []byte, ttl time.Duration) error {
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).UnixNano()
+	}
+	entry := make([]byte, 8+len(value))
+	binary.LittleEndian.PutUint64(entry, uint64(expiresAt))
+	copy(entry[8:], value)
+
+	return c.engine.Set(string(key), entry)
+}
+
+func (c *Cache) Has(key []byte) bool {
+	_, ok := c.get(string(key))
+	return ok
+}
+
+// Delete removes the key. BigCache reports missing keys as an error, which
+// is ignored to match the in-memory cache.
+func (c *Cache) Delete(key []byte) error {
+	_ = c.engine.Delete(string(key))
+	return nil
+}
+
+// get returns the value of the key, removing it if it has expired.
+func (c *Cache) get(key string) ([]byte, bool) {
+	entry, err := c.engine.Get(key)
+	if err != nil || len(entry) < 8 {
+		return nil, false
+	}
+	expiresAt := int64(binary.LittleEndian.Uint64(entry))
+	if expiresAt != 0 && expiresAt <= time.Now().UnixNano() {
+		_ = c.engine.Delete(key)
+		return nil, false
+	}
+	return entry[8:], true
+}
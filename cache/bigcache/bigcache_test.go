@@ -0,0 +1,77 @@
+package bigcache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ ggcache.Cacher = (*Cache)(nil)
+
+// fakeEngine stands in for *bigcache.BigCache.
+type fakeEngine struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+var errNotFound = errors.New("Entry not found")
+
+func (e *fakeEngine) Get(key string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.data[key]
+	if !ok {
+		return nil, errNotFound
+	}
+	return v, nil
+}
+
+func (e *fakeEngine) Set(key string, entry []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.data[key] = entry
+	return nil
+}
+
+func (e *fakeEngine) Delete(key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.data[key]; !ok {
+		return errNotFound
+	}
+	delete(e.data, key)
+	return nil
+}
+
+func TestCache(t *testing.T) {
+	c := New(&fakeEngine{data: make(map[string][]byte)})
+
+	_, err := c.Get([]byte("foo"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.True(t, c.Has([]byte("foo")))
+
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.False(t, c.Has([]byte("foo")))
+}
+
+func TestCacheTTL(t *testing.T) {
+	engine := &fakeEngine{data: make(map[string][]byte)}
+	c := New(engine)
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 20*time.Millisecond))
+	assert.True(t, c.Has([]byte("foo")))
+
+	time.Sleep(40 * time.Millisecond)
+	assert.False(t, c.Has([]byte("foo")))
+	assert.Empty(t, engine.data)
+}
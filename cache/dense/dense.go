@@ -0,0 +1,596 @@
+// Package dense implements a ggcache.Cacher for caches holding tens of
+// millions of small entries, where the per-entry overhead of a Go map of
+// pointers dominates the memory used.
+//
+// Keys and values are appended to a single byte slab, and an open-addressing
+// hash table with linear probing holds a fixed-size slot per entry pointing
+// into it. Nothing in the table or the slab is a pointer, so the garbage
+// collector does not have to scan them however many entries there are.
+//
+// Overwritten and deleted entries leave their bytes in the slab until it is
+// compacted, which happens once they make up half of it. Expired entries are
+// reported as missing right away and reclaimed when the table grows, when
+// the slab is compacted or when Sweep is called.
+//
+// With a PrefixSeparator, the part of each key up to its last separator is
+// interned: stored once in a table shared by every key with the same
+// prefix, and only the rest of the key is written to the slab.
+//
+// With Checksums, a CRC32C of the key and value follows them in the slab and
+// is verified by every Get, which fails with ggcache.ErrCorrupted rather than
+// return a value that was overwritten in memory.
+package dense
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/maphash"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// Slot hashes 0 and 1 mark empty and deleted slots; real hashes are
+// moved out of that range.
+const (
+	hashEmpty   = 0
+	hashDeleted = 1
+)
+
+// minCompact is the amount of garbage below which the slab is never
+// compacted, so small caches do not rewrite it over and over.
+const minCompact = 1 << 20
+
+// DefaultMaxPrefixes is the number of interned prefixes if MaxPrefixes is
+// not set.
+const DefaultMaxPrefixes = 1 << 16
+
+// checksumSize is the size of the checksum of an entry in the slab.
+const checksumSize = 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configure a Cache.
+type Options struct {
+	// Capacity is the number of entries the table is sized for up front,
+	// avoiding rehashing while it fills up. Zero starts small.
+	Capacity int
+
+	// PrefixSeparator enables prefix interning, splitting keys after their
+	// last occurrence of it, e.g. ':' for keys like tenant:123:user:42.
+	// Zero disables it.
+	PrefixSeparator byte
+
+	// MaxPrefixes bounds the number of interned prefixes. Keys whose prefix
+	// does not fit are stored whole. Zero uses DefaultMaxPrefixes.
+	MaxPrefixes int
+
+	// Checksums stores a CRC32C with every entry, verified on Get at the
+	// cost of 4 bytes per entry and of hashing every value read.
+	Checksums bool
+}
+
+// slot is an entry of the table, 32 bytes. keyLen is the length of the key
+// without its interned prefix.
+type slot struct {
+	hash      uint32
+	keyLen    uint32
+	valueLen  uint32
+	prefix    uint32
+	offset    uint64
+	expiresAt int64
+}
+
+// PrefixStats report the savings of prefix interning.
+type PrefixStats struct {
+	// Prefixes is the number of interned prefixes.
+	Prefixes int
+	// SavedBytes is the number of key bytes not written to the slab, less
+	// the size of the interned prefixes.
+	SavedBytes int
+}
+
+// SlabStats report the occupancy of the slab and of the table.
+type SlabStats struct {
+	// Bytes is the length of the slab, of which Garbage bytes are left by
+	// overwritten, deleted and expired entries until the next compaction.
+	// Capacity is the memory allocated for it.
+	Bytes    int
+	Garbage  int
+	Capacity int
+	// Compactions counts the compactions of the slab.
+	Compactions uint64
+	// Slots is the size of the table, Used the slots holding an entry and
+	// Tombstones those left by deleted ones until the table is rehashed.
+	Slots      int
+	Used       int
+	Tombstones int
+}
+
+// Fragmentation is the share of the slab that is garbage, between 0 and 1.
+func (s SlabStats) Fragmentation() float64 {
+	if s.Bytes == 0 {
+		return 0
+	}
+	return float64(s.Garbage) / float64(s.Bytes)
+}
+
+// prefix is an interned key prefix and the number of slots using it.
+type prefix struct {
+	key  string
+	refs int
+}
+
+func (s *slot) expired(now int64) bool {
+	return s.expiresAt != 0 && s.expiresAt <= now
+}
+
+// Cache is a memory-dense ggcache.Cacher. It is safe for concurrent use.
+type Cache struct {
+	lock sync.RWMutex
+	seed maphash.Seed
+
+	// slots is the table, its length a power of two. count is the number of
+	// live slots and deleted the number of tombstones.
+	slots   []slot
+	count   int
+	deleted int
+
+	// slab holds the key and value of every slot back to back, and garbage
+	// the number of its bytes no slot refers to anymore.
+	slab    []byte
+	garbage int
+
+	// prefixes holds the interned prefixes, slot.prefix-1 indexing it, and
+	// prefixIDs the index of each of them. freePrefixes are the indexes of
+	// unused ones. saved is the number of prefix bytes the slots did not
+	// write to the slab.
+	separator    byte
+	maxPrefixes  int
+	prefixes     []prefix
+	prefixIDs    map[string]uint32
+	freePrefixes []uint32
+	saved        int
+
+	// checksums is set if a checksum follows every entry in the slab.
+	checksums bool
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	sets        atomic.Uint64
+	deletes     atomic.Uint64
+	expirations atomic.Uint64
+	compactions atomic.Uint64
+	corrupted   atomic.Uint64
+}
+
+// New creates an empty Cache.
+func New(opts Options) *Cache {
+	n := 8
+	for n*7/8 < opts.Capacity {
+		n *= 2
+	}
+	if opts.MaxPrefixes <= 0 {
+		opts.MaxPrefixes = DefaultMaxPrefixes
+	}
+	return &Cache{
+		seed:        maphash.MakeSeed(),
+		slots:       make([]slot, n),
+		separator:   opts.PrefixSeparator,
+		maxPrefixes: opts.MaxPrefixes,
+		prefixIDs:   make(map[string]uint32),
+		checksums:   opts.Checksums,
+	}
+}
+
+// Get returns the value of the key. Expired keys are reported as not found.
+// The returned slice is shared with the cache and must not be modified.
+func (c *Cache) Get(key []byte) ([]byte, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	i, ok := c.find(key, c.hash(key))
+	if !ok || c.slots[i].expired(time.Now().UnixNano()) {
+		c.misses.Add(1)
+		return nil, fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+
+	s := &c.slots[i]
+	if c.checksums && !c.verify(s) {
+		c.corrupted.Add(1)
+		return nil, fmt.Errorf("%w: %s", ggcache.ErrCorrupted, key)
+	}
+	c.hits.Add(1)
+
+	start := s.offset + uint64(s.keyLen)
+	end := start + uint64(s.valueLen)
+	return c.slab[start:end:end], nil
+}
+
+// Set stores the value of the key. If the TTL is zero, it does not expire.
+func (c *Cache) Set(key, value []byte, ttl time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).UnixNano()
+	}
+
+	h := c.hash(key)
+	i, ok := c.find(key, h)
+	if ok {
+		c.garbage += c.size(&c.slots[i])
+		c.release(c.slots[i].prefix)
+	} else {
+		if (c.count+c.deleted+1)*8 > len(c.slots)*7 {
+			c.rehash()
+		}
+		i = c.insertAt(h)
+		c.count++
+	}
+
+	id, suffix := c.intern(key)
+	c.slots[i] = slot{
+		hash:      h,
+		keyLen:    uint32(len(suffix)),
+		valueLen:  uint32(len(value)),
+		prefix:    id,
+		offset:    uint64(len(c.slab)),
+		expiresAt: expiresAt,
+	}
+	c.slab = append(c.slab, suffix...)
+	c.slab = append(c.slab, value...)
+	if c.checksums {
+		sum := crc32.Update(crc32.Checksum(suffix, castagnoli), castagnoli, value)
+		c.slab = binary.LittleEndian.AppendUint32(c.slab, sum)
+	}
+	c.sets.Add(1)
+
+	c.maybeCompact()
+	return nil
+}
+
+// Touch resets the expiration of the key to the TTL from now. If the TTL is
+// zero, the key no longer expires.
+func (c *Cache) Touch(key []byte, ttl time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	i, ok := c.find(key, c.hash(key))
+	if !ok || c.slots[i].expired(now.UnixNano()) {
+		return fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+
+	c.slots[i].expiresAt = 0
+	if ttl > 0 {
+		c.slots[i].expiresAt = now.Add(ttl).UnixNano()
+	}
+	return nil
+}
+
+// Expiry returns when the key expires, the zero Time if it does not.
+func (c *Cache) Expiry(key []byte) (time.Time, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	i, ok := c.find(key, c.hash(key))
+	if !ok || c.slots[i].expired(time.Now().UnixNano()) {
+		return time.Time{}, fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	if c.slots[i].expiresAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, c.slots[i].expiresAt), nil
+}
+
+// StableValues marks the values returned by Get as never modified: the slab
+// is only appended to, and compaction copies it into a new one.
+func (c *Cache) StableValues() {}
+
+// Has reports whether the key is present and not expired.
+func (c *Cache) Has(key []byte) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	i, ok := c.find(key, c.hash(key))
+	return ok && !c.slots[i].expired(time.Now().UnixNano())
+}
+
+// Delete removes the key. Deleting a missing key is not an error.
+func (c *Cache) Delete(key []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	i, ok := c.find(key, c.hash(key))
+	if !ok {
+		return nil
+	}
+	if !c.slots[i].expired(time.Now().UnixNano()) {
+		c.deletes.Add(1)
+	} else {
+		c.expirations.Add(1)
+	}
+	c.remove(i)
+
+	c.maybeCompact()
+	return nil
+}
+
+// Sweep removes the expired entries.
+func (c *Cache) Sweep() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sweep(time.Now().UnixNano())
+}
+
+// Stats reports the cache counters. Keys and Bytes include entries that have
+// expired but have not been swept yet. Bytes does not count the interned
+// prefixes of the keys, and counts the checksums.
+func (c *Cache) Stats() ggcache.Stats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return ggcache.Stats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Sets:        c.sets.Load(),
+		Deletes:     c.deletes.Load(),
+		Expirations: c.expirations.Load(),
+		Corrupted:   c.corrupted.Load(),
+		Keys:        c.count,
+		Bytes:       len(c.slab) - c.garbage,
+	}
+}
+
+// Sample calls fn with the key and value size of up to n live entries, read
+// from the table starting at a random slot. fn must not call into the cache.
+func (c *Cache) Sample(n int, fn func(key []byte, size int)) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	now := time.Now().UnixNano()
+	start := rand.Intn(len(c.slots))
+	for j := 0; j < len(c.slots) && n > 0; j++ {
+		s := &c.slots[(start+j)&(len(c.slots)-1)]
+		if s.hash <= hashDeleted || s.expired(now) {
+			continue
+		}
+		var key []byte
+		if s.prefix != 0 {
+			key = append(key, c.prefixes[s.prefix-1].key...)
+		}
+		key = append(key, c.slab[s.offset:s.offset+uint64(s.keyLen)]...)
+		fn(key, int(s.valueLen))
+		n--
+	}
+}
+
+// SlabStats reports the occupancy and fragmentation of the slab and table.
+func (c *Cache) SlabStats() SlabStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return SlabStats{
+		Bytes:       len(c.slab),
+		Garbage:     c.garbage,
+		Capacity:    cap(c.slab),
+		Compactions: c.compactions.Load(),
+		Slots:       len(c.slots),
+		Used:        c.count,
+		Tombstones:  c.deleted,
+	}
+}
+
+// PrefixStats reports the savings of prefix interning.
+func (c *Cache) PrefixStats() PrefixStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	stats := PrefixStats{
+		Prefixes:   len(c.prefixIDs),
+		SavedBytes: c.saved,
+	}
+	for _, p := range c.prefixes {
+		stats.SavedBytes -= len(p.key)
+	}
+	return stats
+}
+
+func (c *Cache) hash(key []byte) uint32 {
+	h := uint32(maphash.Bytes(c.seed, key))
+	if h <= hashDeleted {
+		h += 2
+	}
+	return h
+}
+
+// find returns the slot holding the key.
+// The caller must hold the lock.
+func (c *Cache) find(key []byte, h uint32) (int, bool) {
+	mask := len(c.slots) - 1
+	for i := int(h) & mask; ; i = (i + 1) & mask {
+		s := &c.slots[i]
+		switch {
+		case s.hash == hashEmpty:
+			return 0, false
+		case s.hash == h && c.keyEqual(s, key):
+			return i, true
+		}
+	}
+}
+
+// size returns the number of bytes of the slot in the slab.
+func (c *Cache) size(s *slot) int {
+	n := int(s.keyLen) + int(s.valueLen)
+	if c.checksums {
+		n += checksumSize
+	}
+	return n
+}
+
+// verify reports whether the key and value of the slot match their
+// checksum. The caller must hold the lock.
+func (c *Cache) verify(s *slot) bool {
+	end := s.offset + uint64(s.keyLen) + uint64(s.valueLen)
+	sum := binary.LittleEndian.Uint32(c.slab[end:])
+	return crc32.Checksum(c.slab[s.offset:end], castagnoli) == sum
+}
+
+// keyEqual reports whether the slot holds the key.
+// The caller must hold the lock.
+func (c *Cache) keyEqual(s *slot, key []byte) bool {
+	if s.prefix != 0 {
+		p := c.prefixes[s.prefix-1].key
+		if len(p) > len(key) || p != string(key[:len(p)]) {
+			return false
+		}
+		key = key[len(p):]
+	}
+	return int(s.keyLen) == len(key) &&
+		string(c.slab[s.offset:s.offset+uint64(s.keyLen)]) == string(key)
+}
+
+// intern returns the id of the prefix of the key, interning it if needed,
+// and the rest of the key. The id is 0 if the key is stored whole.
+// The caller must hold the write lock.
+func (c *Cache) intern(key []byte) (uint32, []byte) {
+	if c.separator == 0 {
+		return 0, key
+	}
+	n := bytes.LastIndexByte(key, c.separator) + 1
+	if n == 0 {
+		return 0, key
+	}
+
+	id, ok := c.prefixIDs[string(key[:n])]
+	if !ok {
+		if len(c.prefixIDs) >= c.maxPrefixes {
+			return 0, key
+		}
+		p := prefix{key: string(key[:n])}
+		if last := len(c.freePrefixes) - 1; last >= 0 {
+			id = c.freePrefixes[last]
+			c.freePrefixes = c.freePrefixes[:last]
+			c.prefixes[id-1] = p
+		} else {
+			c.prefixes = append(c.prefixes, p)
+			id = uint32(len(c.prefixes))
+		}
+		c.prefixIDs[p.key] = id
+	}
+	c.prefixes[id-1].refs++
+	c.saved += n
+	return id, key[n:]
+}
+
+// release drops a reference to the prefix, freeing it once unused.
+// The caller must hold the write lock.
+func (c *Cache) release(id uint32) {
+	if id == 0 {
+		return
+	}
+	p := &c.prefixes[id-1]
+	c.saved -= len(p.key)
+	if p.refs--; p.refs > 0 {
+		return
+	}
+	delete(c.prefixIDs, p.key)
+	*p = prefix{}
+	c.freePrefixes = append(c.freePrefixes, id)
+}
+
+// insertAt returns the first free slot for the hash, reusing tombstones.
+// The caller must hold the write lock.
+func (c *Cache) insertAt(h uint32) int {
+	mask := len(c.slots) - 1
+	for i := int(h) & mask; ; i = (i + 1) & mask {
+		switch c.slots[i].hash {
+		case hashDeleted:
+			c.deleted--
+			return i
+		case hashEmpty:
+			return i
+		}
+	}
+}
+
+// remove turns the slot into a tombstone.
+// The caller must hold the write lock.
+func (c *Cache) remove(i int) {
+	c.garbage += c.size(&c.slots[i])
+	c.release(c.slots[i].prefix)
+	c.slots[i] = slot{hash: hashDeleted}
+	c.count--
+	c.deleted++
+}
+
+// sweep removes the expired entries.
+// The caller must hold the write lock.
+func (c *Cache) sweep(now int64) {
+	for i := range c.slots {
+		if c.slots[i].hash > hashDeleted && c.slots[i].expired(now) {
+			c.remove(i)
+			c.expirations.Add(1)
+		}
+	}
+}
+
+// rehash drops expired entries and tombstones, and doubles the table if it
+// is still more than half full.
+// The caller must hold the write lock.
+func (c *Cache) rehash() {
+	c.sweep(time.Now().UnixNano())
+
+	n := len(c.slots)
+	if c.count*2 > n {
+		n *= 2
+	}
+	old := c.slots
+	c.slots = make([]slot, n)
+	c.deleted = 0
+
+	mask := n - 1
+	for _, s := range old {
+		if s.hash <= hashDeleted {
+			continue
+		}
+		i := int(s.hash) & mask
+		for c.slots[i].hash != hashEmpty {
+			i = (i + 1) & mask
+		}
+		c.slots[i] = s
+	}
+}
+
+// maybeCompact compacts the slab once half of it is garbage.
+// The caller must hold the write lock.
+func (c *Cache) maybeCompact() {
+	if c.garbage < minCompact || c.garbage < len(c.slab)/2 {
+		return
+	}
+	c.sweep(time.Now().UnixNano())
+
+	slab := make([]byte, 0, len(c.slab)-c.garbage)
+	for i := range c.slots {
+		s := &c.slots[i]
+		if s.hash <= hashDeleted {
+			continue
+		}
+		offset := uint64(len(slab))
+		slab = append(slab, c.slab[s.offset:s.offset+uint64(c.size(s))]...)
+		s.offset = offset
+	}
+	// Values returned by Get keep referring to the old slab, which is left
+	// untouched.
+	c.slab = slab
+	c.garbage = 0
+	c.compactions.Add(1)
+}
@@ -0,0 +1,254 @@
+package dense
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ ggcache.Cacher        = (*Cache)(nil)
+	_ ggcache.Toucher       = (*Cache)(nil)
+	_ ggcache.StatsProvider = (*Cache)(nil)
+	_ ggcache.StableValues  = (*Cache)(nil)
+	_ ggcache.ExpiryGetter  = (*Cache)(nil)
+)
+
+func TestCache(t *testing.T) {
+	c := New(Options{})
+
+	_, err := c.Get([]byte("foo"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set([]byte("foo"), []byte("baz"), 0))
+	assert.True(t, c.Has([]byte("foo")))
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("baz"), value)
+
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.False(t, c.Has([]byte("foo")))
+	assert.Nil(t, c.Delete([]byte("foo")))
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Sets)
+	assert.Equal(t, uint64(1), stats.Deletes)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 0, stats.Keys)
+	assert.Equal(t, 0, stats.Bytes)
+}
+
+func TestCacheGrowAndReuse(t *testing.T) {
+	c := New(Options{})
+
+	// Enough keys to grow the table several times, then delete half of them
+	// and write them again to go through the tombstones.
+	const n = 10000
+	for i := 0; i < n; i++ {
+		assert.Nil(t, c.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)), 0))
+	}
+	for i := 0; i < n; i += 2 {
+		assert.Nil(t, c.Delete([]byte(fmt.Sprintf("key-%d", i))))
+	}
+	for i := 0; i < n; i += 2 {
+		assert.Nil(t, c.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("again-%d", i)), 0))
+	}
+
+	assert.Equal(t, n, c.Stats().Keys)
+	for i := 0; i < n; i++ {
+		want := fmt.Sprintf("value-%d", i)
+		if i%2 == 0 {
+			want = fmt.Sprintf("again-%d", i)
+		}
+		value, err := c.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, want, string(value))
+	}
+}
+
+func TestCacheCompact(t *testing.T) {
+	c := New(Options{})
+	value := make([]byte, 1024)
+
+	// Overwriting the same key leaves the old values behind as garbage until
+	// the slab is compacted.
+	for i := 0; i < 4096; i++ {
+		assert.Nil(t, c.Set([]byte("foo"), value, 0))
+	}
+	assert.Less(t, len(c.slab), 2*minCompact+2048)
+	assert.Equal(t, len("foo")+len(value), c.Stats().Bytes)
+	slab := c.SlabStats()
+	assert.Greater(t, slab.Compactions, uint64(0))
+	assert.Equal(t, len(c.slab), slab.Bytes)
+	assert.Equal(t, float64(slab.Garbage)/float64(slab.Bytes), slab.Fragmentation())
+	assert.Equal(t, 1, slab.Used)
+
+	// Values returned before a compaction stay intact.
+	assert.Nil(t, c.Set([]byte("bar"), []byte("kept"), 0))
+	kept, err := c.Get([]byte("bar"))
+	assert.Nil(t, err)
+	for i := 0; i < 4096; i++ {
+		assert.Nil(t, c.Set([]byte("foo"), value, 0))
+	}
+	assert.Equal(t, []byte("kept"), kept)
+
+	got, err := c.Get([]byte("bar"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("kept"), got)
+}
+
+func TestCacheChecksums(t *testing.T) {
+	c := New(Options{Checksums: true, PrefixSeparator: ':'})
+	value := make([]byte, 1024)
+
+	// The checksums survive compactions.
+	for i := 0; i < 4096; i++ {
+		assert.Nil(t, c.Set([]byte("users:1"), value, 0))
+	}
+	assert.Nil(t, c.Set([]byte("users:2"), []byte("bob"), 0))
+	assert.Greater(t, c.SlabStats().Compactions, uint64(0))
+	assert.Equal(t, len("1")+len(value)+len("2bob")+2*checksumSize, c.Stats().Bytes)
+
+	got, err := c.Get([]byte("users:2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bob"), got)
+
+	// A value overwritten in the slab is not served.
+	i, ok := c.find([]byte("users:2"), c.hash([]byte("users:2")))
+	assert.True(t, ok)
+	c.slab[c.slots[i].offset+uint64(c.slots[i].keyLen)] ^= 0xff
+	_, err = c.Get([]byte("users:2"))
+	assert.ErrorIs(t, err, ggcache.ErrCorrupted)
+	assert.Equal(t, uint64(1), c.Stats().Corrupted)
+
+	// Setting the key again repairs it.
+	assert.Nil(t, c.Set([]byte("users:2"), []byte("bob"), 0))
+	got, err = c.Get([]byte("users:2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bob"), got)
+}
+
+func TestCacheExpiration(t *testing.T) {
+	c := New(Options{})
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 10*time.Millisecond))
+	assert.Nil(t, c.Set([]byte("baz"), []byte("qux"), 10*time.Millisecond))
+	assert.Nil(t, c.Touch([]byte("baz"), 0))
+	time.Sleep(20 * time.Millisecond)
+
+	assert.False(t, c.Has([]byte("foo")))
+	assert.NotNil(t, c.Touch([]byte("foo"), time.Second))
+	assert.True(t, c.Has([]byte("baz")))
+	_, err := c.Expiry([]byte("foo"))
+	assert.ErrorIs(t, err, ggcache.ErrKeyNotFound)
+	expiry, err := c.Expiry([]byte("baz"))
+	assert.Nil(t, err)
+	assert.True(t, expiry.IsZero())
+
+	c.Sweep()
+	stats := c.Stats()
+	assert.Equal(t, 1, stats.Keys)
+	assert.Equal(t, uint64(1), stats.Expirations)
+}
+
+// BenchmarkMemoryPerEntry reports the heap used per entry by the built-in
+// ggcache.Cache and by this engine, for small keys and values.
+func BenchmarkMemoryPerEntry(b *testing.B) {
+	const n = 1_000_000
+
+	engines := []struct {
+		name string
+		new  func() ggcache.Cacher
+	}{
+		{"map", func() ggcache.Cacher { return ggcache.New() }},
+		{"dense", func() ggcache.Cacher { return New(Options{}) }},
+		{"dense-prefix", func() ggcache.Cacher { return New(Options{PrefixSeparator: ':'}) }},
+	}
+	for _, engine := range engines {
+		b.Run(engine.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				before := heapInUse()
+				c := engine.new()
+				for j := 0; j < n; j++ {
+					_ = c.Set([]byte(fmt.Sprintf("user:%08d", j)), []byte("value-16-bytes!!"), 0)
+				}
+				b.ReportMetric(float64(heapInUse()-before)/n, "B/entry")
+				runtime.KeepAlive(c)
+			}
+		})
+	}
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
+
+func TestCachePrefixes(t *testing.T) {
+	c := New(Options{PrefixSeparator: ':', MaxPrefixes: 2})
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Set([]byte(fmt.Sprintf("tenant:1:user:%d", i)), []byte("a"), 0))
+		assert.Nil(t, c.Set([]byte(fmt.Sprintf("tenant:2:user:%d", i)), []byte("b"), 0))
+	}
+	// Past MaxPrefixes and without a separator keys are stored whole.
+	assert.Nil(t, c.Set([]byte("tenant:3:user:0"), []byte("c"), 0))
+	assert.Nil(t, c.Set([]byte("plain"), []byte("d"), 0))
+
+	stats := c.PrefixStats()
+	assert.Equal(t, 2, stats.Prefixes)
+	assert.Equal(t, 20*len("tenant:1:user:")-2*len("tenant:1:user:"), stats.SavedBytes)
+
+	for i := 0; i < 10; i++ {
+		value, err := c.Get([]byte(fmt.Sprintf("tenant:1:user:%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("a"), value)
+		value, err = c.Get([]byte(fmt.Sprintf("tenant:2:user:%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("b"), value)
+	}
+	value, err := c.Get([]byte("tenant:3:user:0"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), value)
+	assert.True(t, c.Has([]byte("plain")))
+	assert.False(t, c.Has([]byte("tenant:1:user:")))
+	assert.False(t, c.Has([]byte("tenant:1:user:10")))
+
+	// A prefix is freed with its last key and its slot reused.
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Delete([]byte(fmt.Sprintf("tenant:1:user:%d", i))))
+	}
+	assert.Equal(t, 1, c.PrefixStats().Prefixes)
+	assert.Nil(t, c.Set([]byte("tenant:4:user:0"), []byte("e"), 0))
+	assert.Equal(t, PrefixStats{Prefixes: 2, SavedBytes: 9 * len("tenant:2:user:")}, c.PrefixStats())
+	value, err = c.Get([]byte("tenant:4:user:0"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("e"), value)
+}
+
+func TestCacheSample(t *testing.T) {
+	c := New(Options{PrefixSeparator: ':'})
+	assert.Nil(t, c.Set([]byte("users:1"), []byte("alice"), 0))
+	assert.Nil(t, c.Set([]byte("plain"), []byte("value!"), 0))
+	assert.Nil(t, c.Set([]byte("gone"), []byte("x"), time.Nanosecond))
+	time.Sleep(time.Millisecond)
+
+	sizes := make(map[string]int)
+	c.Sample(10, func(key []byte, size int) {
+		sizes[string(key)] = size
+	})
+	assert.Equal(t, map[string]int{"users:1": 5, "plain": 6}, sizes)
+
+	n := 0
+	c.Sample(1, func([]byte, int) { n++ })
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,595 @@
+// Package disk implements a ggcache.Cacher that keeps its values on disk, so
+// a node can hold more data than fits in memory and keeps its data across
+// restarts.
+//
+// The cache is an append-only log: every Set, Touch and Delete appends a
+// record to the file, and only the location of each live value is kept in
+// memory. Reopening the file replays the log to rebuild that index. Records
+// superseded by later writes are reclaimed by compaction, which rewrites the
+// live records into a new file.
+//
+// Writes are not synced to disk individually, so a crash can lose the most
+// recent writes. A torn record at the end of the log is detected by its
+// checksum and dropped when the file is reopened. With VerifyChecksums the
+// checksum is verified by every read as well, which fails with
+// ggcache.ErrCorrupted rather than return a value that rotted on disk.
+//
+// A write that cannot be appended to the log, e.g. because the disk is full,
+// fails with an error wrapping ggcache.ErrPersistence, unless MemoryFallback
+// keeps it in memory instead.
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+const (
+	opSet byte = iota + 1
+	opDel
+)
+
+// memoryOffset is the offset of the values kept in memory as they could not
+// be appended to the log.
+const memoryOffset = -1
+
+// headerSize is the size of a record header: op, expiresAt, key length,
+// value length and the CRC32 of the rest of the record.
+const headerSize = 1 + 8 + 4 + 4 + 4
+
+// Options configure the compaction of the log.
+type Options struct {
+	// CompactThreshold is the amount of superseded data, in bytes, above
+	// which the log is compacted, as long as it also makes up at least half
+	// of the file. It defaults to 64 MiB; a negative value disables automatic
+	// compaction.
+	CompactThreshold int64
+
+	// MemoryFallback keeps the writes that cannot be appended to the log in
+	// memory instead of failing them, so the cache keeps working while the
+	// disk is full. Every later write tries to append them again, and
+	// PersistenceErr reports the failure until it succeeds; they are lost if
+	// the cache is closed before.
+	MemoryFallback bool
+
+	// VerifyChecksums verifies the checksum of the record of every value
+	// read, at the cost of reading and hashing the whole record. Compaction
+	// then drops the records that fail it instead of rewriting them with a
+	// new checksum.
+	VerifyChecksums bool
+}
+
+// Cache is a disk-backed ggcache.Cacher. It is safe for concurrent use.
+type Cache struct {
+	lock sync.RWMutex
+
+	path string
+	opts Options
+	f    *os.File
+
+	// size is the length of the log and the offset of the next record.
+	size int64
+
+	// garbage is the number of bytes in the log taken up by records that
+	// have been superseded.
+	garbage int64
+
+	// index maps every key to the location of its latest value.
+	index map[string]location
+
+	// bytes is the total size of the live keys and values.
+	bytes int
+
+	// memory holds the values that could not be appended to the log, and
+	// the deleted keys whose delete could not be, which are not in the index.
+	// persistErr is the error of the latest write that could not, until the
+	// log is written again and memory is empty.
+	memory     map[string][]byte
+	persistErr error
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	sets        atomic.Uint64
+	deletes     atomic.Uint64
+	expirations atomic.Uint64
+	corrupted   atomic.Uint64
+}
+
+// location is where the record holding the value of a key starts in the log.
+type location struct {
+	offset    int64
+	keyLen    uint32
+	valueLen  uint32
+	expiresAt int64
+}
+
+func (l location) recordSize() int64 {
+	return headerSize + int64(l.keyLen) + int64(l.valueLen)
+}
+
+func (l location) expired(now int64) bool {
+	return l.expiresAt != 0 && l.expiresAt <= now
+}
+
+// Open opens the cache stored at path, creating it if it does not exist.
+func Open(path string, opts Options) (*Cache, error) {
+	if opts.CompactThreshold == 0 {
+		opts.CompactThreshold = 64 << 20
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		path:   path,
+		opts:   opts,
+		f:      f,
+		index:  make(map[string]location),
+		memory: make(map[string][]byte),
+	}
+	if err := c.replay(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("disk: replay [%s]: %w", path, err)
+	}
+	return c, nil
+}
+
+// Get returns the value of the key. Expired keys are reported as not found.
+func (c *Cache) Get(key []byte) ([]byte, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	loc, ok := c.lookup(string(key))
+	if !ok {
+		c.misses.Add(1)
+		return nil, fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+
+	value, err := c.readValue(string(key), loc)
+	if err != nil {
+		return nil, err
+	}
+	c.hits.Add(1)
+	return value, nil
+}
+
+// StableValues marks the values returned by Get as never modified: each Get
+// reads its value into a new slice.
+func (c *Cache) StableValues() {}
+
+// Set stores the value of the key. If the TTL is zero, it does not expire.
+func (c *Cache) Set(key, value []byte, ttl time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.put(key, value, expiresAt(ttl)); err != nil {
+		return err
+	}
+	c.sets.Add(1)
+	c.recover()
+	return c.maybeCompact()
+}
+
+// Touch resets the expiration of the key to the TTL from now by rewriting its
+// value with the new expiration.
+func (c *Cache) Touch(key []byte, ttl time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	loc, ok := c.lookup(string(key))
+	if !ok {
+		return fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	value, err := c.readValue(string(key), loc)
+	if err != nil {
+		return err
+	}
+	if err := c.put(key, value, expiresAt(ttl)); err != nil {
+		return err
+	}
+	c.recover()
+	return c.maybeCompact()
+}
+
+// Expiry returns when the key expires, the zero Time if it does not.
+func (c *Cache) Expiry(key []byte) (time.Time, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	loc, ok := c.lookup(string(key))
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	if loc.expiresAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, loc.expiresAt), nil
+}
+
+// Has reports whether the key is present and not expired.
+func (c *Cache) Has(key []byte) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	_, ok := c.lookup(string(key))
+	return ok
+}
+
+// Delete removes the key. Deleting a missing key is not an error.
+func (c *Cache) Delete(key []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	old, ok := c.index[string(key)]
+	if !ok {
+		return nil
+	}
+
+	rec := encode(opDel, key, nil, 0)
+	err := c.write(rec)
+	if err != nil && !c.opts.MemoryFallback {
+		return err
+	}
+	c.remove(string(key))
+	if err != nil {
+		c.memory[string(key)] = nil
+	} else {
+		c.garbage += int64(len(rec))
+	}
+
+	if !old.expired(time.Now().UnixNano()) {
+		c.deletes.Add(1)
+	}
+	c.recover()
+	return c.maybeCompact()
+}
+
+// Stats reports the cache counters. Keys and Bytes include entries that have
+// expired but have not been compacted away yet.
+func (c *Cache) Stats() ggcache.Stats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return ggcache.Stats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Sets:        c.sets.Load(),
+		Deletes:     c.deletes.Load(),
+		Expirations: c.expirations.Load(),
+		Corrupted:   c.corrupted.Load(),
+		Keys:        len(c.index),
+		Bytes:       c.bytes,
+	}
+}
+
+// PersistenceErr returns the error of the latest write that could not be
+// appended to the log, or nil if every write since was and no value is kept
+// in memory.
+func (c *Cache) PersistenceErr() error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.persistErr
+}
+
+// Compact rewrites the log with only the live, unexpired entries and drops
+// everything else.
+func (c *Cache) Compact() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.compact()
+}
+
+// Close syncs the log to disk and closes it.
+func (c *Cache) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.f.Sync(); err != nil {
+		_ = c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+// lookup returns the location of the key if it is present and not expired.
+// The caller must hold the lock.
+func (c *Cache) lookup(key string) (location, bool) {
+	loc, ok := c.index[key]
+	if !ok || loc.expired(time.Now().UnixNano()) {
+		return location{}, false
+	}
+	return loc, true
+}
+
+// put appends a set record and points the index at it, or keeps the value
+// in memory if the record cannot be appended and MemoryFallback is set.
+// The caller must hold the write lock.
+func (c *Cache) put(key, value []byte, expiresAt int64) error {
+	offset := c.size
+	if err := c.write(encode(opSet, key, value, expiresAt)); err != nil {
+		if !c.opts.MemoryFallback {
+			return err
+		}
+		offset = memoryOffset
+	}
+
+	c.remove(string(key))
+	delete(c.memory, string(key))
+	c.index[string(key)] = location{
+		offset:    offset,
+		keyLen:    uint32(len(key)),
+		valueLen:  uint32(len(value)),
+		expiresAt: expiresAt,
+	}
+	if offset == memoryOffset {
+		c.memory[string(key)] = bytes.Clone(value)
+	}
+	c.bytes += len(key) + len(value)
+	return nil
+}
+
+// write appends a record to the log. If it cannot, the error becomes the
+// PersistenceErr. The caller must hold the write lock.
+func (c *Cache) write(rec []byte) error {
+	if _, err := c.f.WriteAt(rec, c.size); err != nil {
+		c.persistErr = err
+		return fmt.Errorf("disk: %w: %w", ggcache.ErrPersistence, err)
+	}
+	c.size += int64(len(rec))
+	return nil
+}
+
+// remove drops the key from the index, counting its record as garbage.
+// The caller must hold the write lock.
+func (c *Cache) remove(key string) {
+	old, ok := c.index[key]
+	if !ok {
+		return
+	}
+	if old.offset == memoryOffset {
+		delete(c.memory, key)
+	} else {
+		c.garbage += old.recordSize()
+	}
+	c.bytes -= int(old.keyLen) + int(old.valueLen)
+	delete(c.index, key)
+}
+
+// recover appends the values kept in memory to the log after a write to it
+// failed, and clears the PersistenceErr once they all are.
+// The caller must hold the write lock.
+func (c *Cache) recover() {
+	if c.persistErr == nil {
+		return
+	}
+	for key, value := range c.memory {
+		loc, ok := c.index[key]
+		if !ok {
+			rec := encode(opDel, []byte(key), nil, 0)
+			if err := c.write(rec); err != nil {
+				return
+			}
+			c.garbage += int64(len(rec))
+			delete(c.memory, key)
+			continue
+		}
+
+		offset := c.size
+		if err := c.write(encode(opSet, []byte(key), value, loc.expiresAt)); err != nil {
+			return
+		}
+		loc.offset = offset
+		c.index[key] = loc
+		delete(c.memory, key)
+	}
+	c.persistErr = nil
+}
+
+func (c *Cache) readValue(key string, loc location) ([]byte, error) {
+	if loc.offset == memoryOffset {
+		return bytes.Clone(c.memory[key]), nil
+	}
+	if c.opts.VerifyChecksums {
+		return c.readVerified(key, loc)
+	}
+	value := make([]byte, loc.valueLen)
+	if _, err := c.f.ReadAt(value, loc.offset+headerSize+int64(loc.keyLen)); err != nil {
+		return nil, fmt.Errorf("disk: read value: %w", err)
+	}
+	return value, nil
+}
+
+// readVerified reads the whole record of the value and checks it against its
+// checksum.
+func (c *Cache) readVerified(key string, loc location) ([]byte, error) {
+	rec := make([]byte, loc.recordSize())
+	if _, err := c.f.ReadAt(rec, loc.offset); err != nil {
+		return nil, fmt.Errorf("disk: read value: %w", err)
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(rec[:17])
+	crc.Write(rec[headerSize:])
+	if crc.Sum32() != binary.LittleEndian.Uint32(rec[17:]) || string(rec[headerSize:headerSize+loc.keyLen]) != key {
+		c.corrupted.Add(1)
+		return nil, fmt.Errorf("disk: %w: %s", ggcache.ErrCorrupted, key)
+	}
+	return rec[headerSize+loc.keyLen:], nil
+}
+
+// maybeCompact compacts the log once enough of it is garbage.
+// The caller must hold the write lock.
+func (c *Cache) maybeCompact() error {
+	if c.opts.CompactThreshold < 0 || c.garbage < c.opts.CompactThreshold || c.garbage < c.size/2 {
+		return nil
+	}
+	return c.compact()
+}
+
+// compact writes the live records to a temporary file and swaps it in.
+// The caller must hold the write lock.
+func (c *Cache) compact() error {
+	tmpPath := c.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	var (
+		now   = time.Now().UnixNano()
+		w     = bufio.NewWriter(tmp)
+		index = make(map[string]location, len(c.index))
+		size  int64
+		bytes int
+	)
+	for key, loc := range c.index {
+		if loc.expired(now) {
+			c.expirations.Add(1)
+			continue
+		}
+		value, err := c.readValue(key, loc)
+		if errors.Is(err, ggcache.ErrCorrupted) {
+			continue
+		}
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		rec := encode(opSet, []byte(key), value, loc.expiresAt)
+		if _, err := w.Write(rec); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		loc.offset = size
+		index[key] = loc
+		size += int64(len(rec))
+		bytes += len(key) + len(value)
+	}
+
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	_ = c.f.Close()
+	c.f = tmp
+	c.index = index
+	c.size = size
+	c.garbage = 0
+	c.bytes = bytes
+	// The values kept in memory were written out with the others.
+	clear(c.memory)
+	c.persistErr = nil
+	return nil
+}
+
+// replay rebuilds the index from the log. A truncated or corrupt record ends
+// the log; it and everything after it are cut off.
+func (c *Cache) replay() error {
+	fi, err := c.f.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(io.NewSectionReader(c.f, 0, fi.Size()))
+	header := make([]byte, headerSize)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+		var (
+			op     = header[0]
+			exp    = int64(binary.LittleEndian.Uint64(header[1:]))
+			keyLen = binary.LittleEndian.Uint32(header[9:])
+			valLen = binary.LittleEndian.Uint32(header[13:])
+			sum    = binary.LittleEndian.Uint32(header[17:])
+		)
+		if c.size+headerSize+int64(keyLen)+int64(valLen) > fi.Size() {
+			break
+		}
+		body := make([]byte, int(keyLen)+int(valLen))
+		if _, err := io.ReadFull(r, body); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(header[:17])
+		crc.Write(body)
+		if crc.Sum32() != sum || (op != opSet && op != opDel) {
+			break
+		}
+
+		key := string(body[:keyLen])
+		if old, ok := c.index[key]; ok {
+			c.garbage += old.recordSize()
+			c.bytes -= int(old.keyLen) + int(old.valueLen)
+			delete(c.index, key)
+		}
+		size := int64(headerSize + len(body))
+		if op == opSet {
+			c.index[key] = location{
+				offset:    c.size,
+				keyLen:    keyLen,
+				valueLen:  valLen,
+				expiresAt: exp,
+			}
+			c.bytes += len(body)
+		} else {
+			c.garbage += size
+		}
+		c.size += size
+	}
+
+	return c.f.Truncate(c.size)
+}
+
+func encode(op byte, key, value []byte, expiresAt int64) []byte {
+	rec := make([]byte, headerSize+len(key)+len(value))
+	rec[0] = op
+	binary.LittleEndian.PutUint64(rec[1:], uint64(expiresAt))
+	binary.LittleEndian.PutUint32(rec[9:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(rec[13:], uint32(len(value)))
+	copy(rec[headerSize:], key)
+	copy(rec[headerSize+len(key):], value)
+
+	crc := crc32.NewIEEE()
+	crc.Write(rec[:17])
+	crc.Write(rec[headerSize:])
+	binary.LittleEndian.PutUint32(rec[17:], crc.Sum32())
+	return rec
+}
+
+func expiresAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
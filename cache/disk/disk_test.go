@@ -0,0 +1,266 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ ggcache.Cacher        = (*Cache)(nil)
+	_ ggcache.Toucher       = (*Cache)(nil)
+	_ ggcache.StatsProvider = (*Cache)(nil)
+	_ ggcache.StableValues  = (*Cache)(nil)
+	_ ggcache.ExpiryGetter  = (*Cache)(nil)
+
+	_ ggcache.PersistenceChecker = (*Cache)(nil)
+)
+
+func open(t *testing.T, path string, opts Options) *Cache {
+	c, err := Open(path, opts)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestCache(t *testing.T) {
+	c := open(t, filepath.Join(t.TempDir(), "cache.log"), Options{})
+	defer c.Close()
+
+	_, err := c.Get([]byte("foo"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set([]byte("foo"), []byte("baz"), 0))
+	assert.True(t, c.Has([]byte("foo")))
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("baz"), value)
+
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.False(t, c.Has([]byte("foo")))
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Sets)
+	assert.Equal(t, uint64(1), stats.Deletes)
+	assert.Equal(t, 0, stats.Keys)
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := open(t, filepath.Join(t.TempDir(), "cache.log"), Options{})
+	defer c.Close()
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 50*time.Millisecond))
+	assert.Nil(t, c.Set([]byte("touched"), []byte("bar"), 50*time.Millisecond))
+	assert.Nil(t, c.Touch([]byte("touched"), time.Minute))
+	expiry, err := c.Expiry([]byte("touched"))
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expiry, time.Second)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, c.Has([]byte("foo")))
+	assert.True(t, c.Has([]byte("touched")))
+	assert.NotNil(t, c.Touch([]byte("foo"), time.Minute))
+}
+
+func TestCacheReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+
+	c := open(t, path, Options{})
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set([]byte("gone"), []byte("bar"), 0))
+	assert.Nil(t, c.Delete([]byte("gone")))
+	assert.Nil(t, c.Close())
+
+	c = open(t, path, Options{})
+	defer c.Close()
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.False(t, c.Has([]byte("gone")))
+}
+
+func TestCacheTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+
+	c := open(t, path, Options{})
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set([]byte("torn"), []byte("value"), 0))
+	assert.Nil(t, c.Close())
+
+	// Cut the last record in half, as a crash in the middle of a write would.
+	fi, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(path, fi.Size()-3))
+
+	c = open(t, path, Options{})
+	defer c.Close()
+
+	assert.True(t, c.Has([]byte("foo")))
+	assert.False(t, c.Has([]byte("torn")))
+
+	// The log is usable after the torn record has been dropped.
+	assert.Nil(t, c.Set([]byte("torn"), []byte("again"), 0))
+	value, err := c.Get([]byte("torn"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("again"), value)
+}
+
+func TestCacheVerifyChecksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+
+	c := open(t, path, Options{VerifyChecksums: true})
+	defer c.Close()
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set([]byte("rotten"), []byte("value"), 0))
+
+	// Flip a bit of the value of the last record behind the cache's back.
+	fi, err := os.Stat(path)
+	assert.Nil(t, err)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("V"), fi.Size()-int64(len("value")))
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	_, err = c.Get([]byte("rotten"))
+	assert.ErrorIs(t, err, ggcache.ErrCorrupted)
+	assert.Equal(t, uint64(1), c.Stats().Corrupted)
+
+	// Compaction drops the corrupted record.
+	assert.Nil(t, c.Compact())
+	assert.False(t, c.Has([]byte("rotten")))
+	value, err = c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+}
+
+func TestCacheCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+
+	c := open(t, path, Options{CompactThreshold: 1 << 10})
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, c.Set([]byte("foo"), []byte("some value to overwrite"), 0))
+	}
+	assert.Nil(t, c.Set([]byte("expired"), []byte("bar"), time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Nil(t, c.Compact())
+	fi, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(headerSize+len("foo")+len("some value to overwrite")), fi.Size())
+	assert.Equal(t, uint64(1), c.Stats().Expirations)
+	assert.Nil(t, c.Close())
+
+	c = open(t, path, Options{})
+	defer c.Close()
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some value to overwrite"), value)
+	assert.False(t, c.Has([]byte("expired")))
+}
+
+// failWrites makes the writes to the log of the cache fail, as a full disk
+// would, until the returned func is called.
+func failWrites(t *testing.T, c *Cache) func() {
+	ro, err := os.Open(c.path)
+	assert.Nil(t, err)
+
+	c.lock.Lock()
+	rw := c.f
+	c.f = ro
+	c.lock.Unlock()
+
+	return func() {
+		c.lock.Lock()
+		c.f = rw
+		c.lock.Unlock()
+		_ = ro.Close()
+	}
+}
+
+func TestCacheWriteFailure(t *testing.T) {
+	c := open(t, filepath.Join(t.TempDir(), "cache.log"), Options{})
+	defer c.Close()
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	restore := failWrites(t, c)
+
+	err := c.Set([]byte("foo"), []byte("baz"), 0)
+	assert.ErrorIs(t, err, ggcache.ErrPersistence)
+	assert.ErrorIs(t, c.Delete([]byte("foo")), ggcache.ErrPersistence)
+	assert.NotNil(t, c.PersistenceErr())
+
+	// Reads are served and the failed writes left nothing behind.
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	restore()
+	assert.Nil(t, c.Set([]byte("other"), []byte("bar"), 0))
+	assert.Nil(t, c.PersistenceErr())
+}
+
+func TestCacheMemoryFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	c := open(t, path, Options{MemoryFallback: true})
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set([]byte("gone"), []byte("bar"), 0))
+	restore := failWrites(t, c)
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("baz"), 0))
+	assert.Nil(t, c.Set([]byte("new"), []byte("value"), 0))
+	assert.Nil(t, c.Delete([]byte("gone")))
+	assert.NotNil(t, c.PersistenceErr())
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("baz"), value)
+	assert.False(t, c.Has([]byte("gone")))
+	assert.Equal(t, 2, c.Stats().Keys)
+
+	// The next write that reaches the log brings the others along.
+	restore()
+	assert.Nil(t, c.Set([]byte("last"), []byte("value"), 0))
+	assert.Nil(t, c.PersistenceErr())
+	assert.Nil(t, c.Close())
+
+	c = open(t, path, Options{})
+	defer c.Close()
+
+	value, err = c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("baz"), value)
+	assert.True(t, c.Has([]byte("new")))
+	assert.True(t, c.Has([]byte("last")))
+	assert.False(t, c.Has([]byte("gone")))
+}
+
+func TestCacheMemoryFallbackCompact(t *testing.T) {
+	c := open(t, filepath.Join(t.TempDir(), "cache.log"), Options{MemoryFallback: true})
+	defer c.Close()
+
+	restore := failWrites(t, c)
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	restore()
+
+	// Compaction writes the values kept in memory out with the others.
+	assert.Nil(t, c.Compact())
+	assert.Nil(t, c.PersistenceErr())
+	assert.Empty(t, c.memory)
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+}
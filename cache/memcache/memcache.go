@@ -0,0 +1,322 @@
+// Package memcache implements a ggcache.Cacher that stores its entries in an
+// upstream memcached server. Serving it behind a ggcache node turns the node
+// into a proxy that translates the ggcache protocol to memcached, and combined
+// with ggcache.Layered it puts an in-memory L1 in front of memcached.
+//
+// The package speaks the memcached text protocol over a small pool of
+// connections and has no dependencies outside the standard library.
+//
+// memcached expirations have a resolution of one second, so TTLs are rounded
+// up to whole seconds.
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// ErrInvalidKey is returned for keys memcached cannot store: keys longer than
+// 250 bytes or containing whitespace or control characters.
+var ErrInvalidKey = errors.New("memcache: invalid key")
+
+// maxRelativeTTL is the longest expiration memcached accepts as relative to
+// now. Longer expirations must be sent as a unix timestamp.
+const maxRelativeTTL = 30 * 24 * time.Hour
+
+type Options struct {
+	// DialTimeout bounds connecting to the server, 5 seconds if zero.
+	DialTimeout time.Duration
+
+	// Timeout bounds every command, 5 seconds if zero.
+	Timeout time.Duration
+
+	// MaxIdle is the number of idle connections kept open, 8 if zero.
+	MaxIdle int
+}
+
+// Cache is a ggcache.Cacher backed by memcached. It is safe for concurrent use.
+type Cache struct {
+	addr string
+	opts Options
+	idle chan *conn
+}
+
+func New(addr string, opts Options) *Cache {
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxIdle == 0 {
+		opts.MaxIdle = 8
+	}
+	return &Cache{
+		addr: addr,
+		opts: opts,
+		idle: make(chan *conn, opts.MaxIdle),
+	}
+}
+
+func (c *Cache) Get(key []byte) ([]byte, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	var value []byte
+	err := c.with(func(cn *conn) error {
+		if _, err := fmt.Fprintf(cn, "get %s\r\n", key); err != nil {
+			return err
+		}
+		line, err := readLine(cn.r)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(line, []byte("END")) {
+			return nil
+		}
+
+		// VALUE <key> <flags> <bytes>
+		fields := bytes.Fields(line)
+		if len(fields) < 4 || !bytes.Equal(fields[0], []byte("VALUE")) {
+			return replyError(line)
+		}
+		n, err := strconv.Atoi(string(fields[3]))
+		if err != nil {
+			return fmt.Errorf("memcache: invalid value length: %w", err)
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, b); err != nil {
+			return err
+		}
+		if line, err = readLine(cn.r); err != nil {
+			return err
+		}
+		if !bytes.Equal(line, []byte("END")) {
+			return replyError(line)
+		}
+		value = b[:n]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	return value, nil
+}
+
+func (c *Cache) Set(key, value []byte, ttl time.Duration) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	return c.with(func(cn *conn) error {
+		if _, err := fmt.Fprintf(cn, "set %s 0 %d %d\r\n", key, exptime(ttl), len(value)); err != nil {
+			return err
+		}
+		if _, err := cn.Write(value); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(cn, "\r\n"); err != nil {
+			return err
+		}
+		return expect(cn.r, "STORED")
+	})
+}
+
+func (c *Cache) Touch(key []byte, ttl time.Duration) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	var found bool
+	err := c.with(func(cn *conn) error {
+		if _, err := fmt.Fprintf(cn, "touch %s %d\r\n", key, exptime(ttl)); err != nil {
+			return err
+		}
+		line, err := readLine(cn.r)
+		if err != nil {
+			return err
+		}
+		switch string(line) {
+		case "TOUCHED":
+			found = true
+		case "NOT_FOUND":
+		default:
+			return replyError(line)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	return nil
+}
+
+// Has reports whether the key exists. Errors talking to memcached are
+// reported as the key not existing.
+func (c *Cache) Has(key []byte) bool {
+	_, err := c.Get(key)
+	return err == nil
+}
+
+func (c *Cache) Delete(key []byte) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	return c.with(func(cn *conn) error {
+		if _, err := fmt.Fprintf(cn, "delete %s\r\n", key); err != nil {
+			return err
+		}
+		line, err := readLine(cn.r)
+		if err != nil {
+			return err
+		}
+		switch string(line) {
+		case "DELETED", "NOT_FOUND":
+			return nil
+		default:
+			return replyError(line)
+		}
+	})
+}
+
+// Close closes the idle connections.
+func (c *Cache) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			_ = cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Error is an error reply from memcached.
+type Error string
+
+func (e Error) Error() string {
+	return "memcache: " + string(e)
+}
+
+// with runs fn on a pooled connection. A connection that failed for any
+// reason other than an error reply is discarded.
+func (c *Cache) with(fn func(cn *conn) error) error {
+	cn, err := c.conn()
+	if err != nil {
+		return err
+	}
+	if err := cn.SetDeadline(time.Now().Add(c.opts.Timeout)); err != nil {
+		_ = cn.Close()
+		return err
+	}
+
+	err = fn(cn)
+	var merr Error
+	if err != nil && !errors.As(err, &merr) {
+		_ = cn.Close()
+		return err
+	}
+	c.put(cn)
+	return err
+}
+
+func (c *Cache) conn() (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", c.addr, c.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: nc, r: bufio.NewReader(nc)}, nil
+}
+
+func (c *Cache) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.Close()
+	}
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("memcache: malformed reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func expect(r *bufio.Reader, want string) error {
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if string(line) != want {
+		return replyError(line)
+	}
+	return nil
+}
+
+// replyError turns an unexpected reply into an error. ERROR, CLIENT_ERROR
+// and SERVER_ERROR replies leave the connection usable and become an Error;
+// anything else means the stream is out of sync.
+func replyError(line []byte) error {
+	for _, prefix := range []string{"ERROR", "CLIENT_ERROR", "SERVER_ERROR"} {
+		if bytes.HasPrefix(line, []byte(prefix)) {
+			return Error(line)
+		}
+	}
+	return fmt.Errorf("memcache: unexpected reply %q", line)
+}
+
+// exptime converts the TTL to a memcached expiration: zero for none, whole
+// seconds rounded up, or a unix timestamp beyond 30 days.
+func exptime(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl > maxRelativeTTL {
+		return time.Now().Add(ttl).Unix()
+	}
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+func validKey(key []byte) bool {
+	if len(key) == 0 || len(key) > 250 {
+		return false
+	}
+	for _, b := range key {
+		if b <= ' ' || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package memcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ ggcache.Cacher  = (*Cache)(nil)
+	_ ggcache.Toucher = (*Cache)(nil)
+)
+
+// fakeMemcached serves the text protocol commands the Cache sends from a map,
+// recording the exptime of every key.
+type fakeMemcached struct {
+	mu       sync.Mutex
+	data     map[string][]byte
+	exptimes map[string]int64
+}
+
+func startFakeMemcached(t *testing.T) (*fakeMemcached, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	f := &fakeMemcached{data: make(map[string][]byte), exptimes: make(map[string]int64)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		args := strings.Fields(string(line))
+
+		f.mu.Lock()
+		var reply string
+		switch args[0] {
+		case "get":
+			if v, ok := f.data[args[1]]; ok {
+				reply = fmt.Sprintf("VALUE %s 0 %d\r\n%s\r\n", args[1], len(v), v)
+			}
+			reply += "END\r\n"
+		case "set":
+			n, _ := strconv.Atoi(args[4])
+			b := make([]byte, n+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				f.mu.Unlock()
+				return
+			}
+			f.data[args[1]] = b[:n]
+			f.exptimes[args[1]], _ = strconv.ParseInt(args[3], 10, 64)
+			reply = "STORED\r\n"
+		case "touch":
+			if _, ok := f.data[args[1]]; !ok {
+				reply = "NOT_FOUND\r\n"
+			} else {
+				f.exptimes[args[1]], _ = strconv.ParseInt(args[2], 10, 64)
+				reply = "TOUCHED\r\n"
+			}
+		case "delete":
+			if _, ok := f.data[args[1]]; !ok {
+				reply = "NOT_FOUND\r\n"
+			} else {
+				delete(f.data, args[1])
+				reply = "DELETED\r\n"
+			}
+		default:
+			reply = "ERROR\r\n"
+		}
+		f.mu.Unlock()
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	f, addr := startFakeMemcached(t)
+	c := New(addr, Options{})
+	defer c.Close()
+
+	_, err := c.Get([]byte("foo"))
+	assert.NotNil(t, err)
+	assert.False(t, c.Has([]byte("foo")))
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 1500*time.Millisecond))
+	assert.Equal(t, int64(2), f.exptimes["foo"])
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	assert.Nil(t, c.Set([]byte("empty"), nil, 0))
+	value, err = c.Get([]byte("empty"))
+	assert.Nil(t, err)
+	assert.Empty(t, value)
+
+	assert.Nil(t, c.Touch([]byte("foo"), 60*24*time.Hour))
+	assert.Greater(t, f.exptimes["foo"], time.Now().Unix())
+	assert.NotNil(t, c.Touch([]byte("missing"), time.Minute))
+
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.False(t, c.Has([]byte("foo")))
+
+	assert.ErrorIs(t, c.Set([]byte("with space"), []byte("bar"), 0), ErrInvalidKey)
+}
@@ -0,0 +1,314 @@
+// Package rcu implements an experimental ggcache.Cacher whose reads never
+// take a lock, for read-mostly workloads where the read lock of an RWMutex
+// becomes the bottleneck at high core counts.
+//
+// Keys are spread over shards, each holding an immutable map behind an
+// atomic pointer. Readers load the pointer and look the key up. Writers
+// serialize per shard, copy its map, apply the change and publish the copy,
+// so a write costs time proportional to the size of its shard. Use enough
+// shards to keep them small, and prefer the default engine for write-heavy
+// workloads.
+//
+// Expired entries are reported as missing right away and dropped from the
+// next copy of their shard.
+package rcu
+
+import (
+	"fmt"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// Options configure a Cache.
+type Options struct {
+	// Shards is the number of shards, rounded up to a power of two. Zero
+	// uses 256.
+	Shards int
+}
+
+type entry struct {
+	value     []byte
+	expiresAt int64
+}
+
+func (e entry) expired(now int64) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now
+}
+
+// live reports whether the entry has not expired, only reading the clock
+// for entries with a TTL.
+func (e entry) live() bool {
+	return e.expiresAt == 0 || e.expiresAt > time.Now().UnixNano()
+}
+
+type shard struct {
+	// mu serializes the writers of the shard; readers only load data.
+	mu   sync.Mutex
+	data atomic.Pointer[map[string]entry]
+
+	// bytes is the size of the keys and values in data.
+	bytes atomic.Int64
+}
+
+// update publishes a copy of the shard's map changed by fn, leaving out the
+// expired entries. fn returns the change in size it made. update returns the
+// number of entries dropped as expired.
+// The caller must hold mu.
+func (s *shard) update(now int64, fn func(data map[string]entry) int64) (expired int) {
+	old := *s.data.Load()
+	data := make(map[string]entry, len(old)+1)
+	bytes := s.bytes.Load()
+	for key, e := range old {
+		if e.expired(now) {
+			bytes -= int64(len(key) + len(e.value))
+			expired++
+			continue
+		}
+		data[key] = e
+	}
+
+	bytes += fn(data)
+
+	s.data.Store(&data)
+	s.bytes.Store(bytes)
+	return expired
+}
+
+// Cache is a ggcache.Cacher with lock-free reads. It is safe for concurrent
+// use.
+type Cache struct {
+	seed   maphash.Seed
+	shards []shard
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	sets        atomic.Uint64
+	deletes     atomic.Uint64
+	expirations atomic.Uint64
+}
+
+// New creates an empty Cache.
+func New(opts Options) *Cache {
+	n := 1
+	for n < opts.Shards {
+		n *= 2
+	}
+	if opts.Shards == 0 {
+		n = 256
+	}
+
+	c := &Cache{
+		seed:   maphash.MakeSeed(),
+		shards: make([]shard, n),
+	}
+	for i := range c.shards {
+		data := make(map[string]entry)
+		c.shards[i].data.Store(&data)
+	}
+	return c
+}
+
+// Get returns the value of the key without taking a lock. Expired keys are
+// reported as not found. The returned slice is shared with the cache and
+// must not be modified.
+func (c *Cache) Get(key []byte) ([]byte, error) {
+	e, ok := (*c.shard(key).data.Load())[string(key)]
+	if !ok || !e.live() {
+		c.misses.Add(1)
+		return nil, fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	c.hits.Add(1)
+	return e.value, nil
+}
+
+// Set stores the value of the key. If the TTL is zero, it does not expire.
+func (c *Cache) Set(key, value []byte, ttl time.Duration) error {
+	s := c.shard(key)
+	now := time.Now()
+
+	e := entry{value: value}
+	if ttl > 0 {
+		e.expiresAt = now.Add(ttl).UnixNano()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := s.update(now.UnixNano(), func(data map[string]entry) int64 {
+		delta := int64(len(key) + len(value))
+		if old, ok := data[string(key)]; ok {
+			delta -= int64(len(key) + len(old.value))
+		}
+		data[string(key)] = e
+		return delta
+	})
+	c.expirations.Add(uint64(expired))
+	c.sets.Add(1)
+	return nil
+}
+
+// Touch resets the expiration of the key to the TTL from now. If the TTL is
+// zero, the key no longer expires.
+func (c *Cache) Touch(key []byte, ttl time.Duration) error {
+	s := c.shard(key)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := (*s.data.Load())[string(key)]
+	if !ok || e.expired(now.UnixNano()) {
+		return fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	e.expiresAt = 0
+	if ttl > 0 {
+		e.expiresAt = now.Add(ttl).UnixNano()
+	}
+
+	expired := s.update(now.UnixNano(), func(data map[string]entry) int64 {
+		data[string(key)] = e
+		return 0
+	})
+	c.expirations.Add(uint64(expired))
+	return nil
+}
+
+// StableValues marks the values returned by Get as never modified: the maps
+// are copied, not updated in place.
+func (c *Cache) StableValues() {}
+
+// Rename moves the value of oldKey to newKey, keeping its expiration. The
+// shards of both keys are locked in index order, so concurrent renames
+// between the same shards do not deadlock. Across shards, the new key is
+// published before the old one is removed, so readers may briefly see the
+// value under both keys but never under neither.
+func (c *Cache) Rename(oldKey, newKey []byte) error {
+	return c.move(oldKey, newKey, func(e entry, _ int64) (entry, bool) {
+		return e, true
+	})
+}
+
+// Copy stores the value of src under dst with the TTL. The shards of both
+// keys are locked in index order.
+func (c *Cache) Copy(src, dst []byte, ttl time.Duration) error {
+	err := c.move(src, dst, func(e entry, now int64) (entry, bool) {
+		e.expiresAt = 0
+		if ttl > 0 {
+			e.expiresAt = now + int64(ttl)
+		}
+		return e, false
+	})
+	if err == nil {
+		c.sets.Add(1)
+	}
+	return err
+}
+
+// move stores the entry of src, as changed by fn, under dst and removes src
+// if fn says so.
+func (c *Cache) move(src, dst []byte, fn func(e entry, now int64) (entry, bool)) error {
+	i, j := c.shardIndex(src), c.shardIndex(dst)
+	from, to := &c.shards[i], &c.shards[j]
+	now := time.Now().UnixNano()
+
+	c.shards[min(i, j)].mu.Lock()
+	defer c.shards[min(i, j)].mu.Unlock()
+	if i != j {
+		c.shards[max(i, j)].mu.Lock()
+		defer c.shards[max(i, j)].mu.Unlock()
+	}
+
+	e, ok := (*from.data.Load())[string(src)]
+	if !ok || e.expired(now) {
+		return fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, src)
+	}
+	moved, remove := fn(e, now)
+	if string(src) == string(dst) {
+		remove = false
+	}
+
+	expired := to.update(now, func(data map[string]entry) int64 {
+		delta := int64(len(dst) + len(moved.value))
+		if old, ok := data[string(dst)]; ok {
+			delta -= int64(len(dst) + len(old.value))
+		}
+		data[string(dst)] = moved
+		if remove && from == to {
+			delete(data, string(src))
+			delta -= int64(len(src) + len(e.value))
+		}
+		return delta
+	})
+	if remove && from != to {
+		expired += from.update(now, func(data map[string]entry) int64 {
+			delete(data, string(src))
+			return -int64(len(src) + len(e.value))
+		})
+	}
+	c.expirations.Add(uint64(expired))
+	return nil
+}
+
+// Has reports whether the key is present and not expired.
+func (c *Cache) Has(key []byte) bool {
+	e, ok := (*c.shard(key).data.Load())[string(key)]
+	return ok && e.live()
+}
+
+// Delete removes the key. Deleting a missing key is not an error.
+func (c *Cache) Delete(key []byte) error {
+	s := c.shard(key)
+	now := time.Now().UnixNano()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := (*s.data.Load())[string(key)]
+	if !ok {
+		return nil
+	}
+	if e.expired(now) {
+		// Dropped as expired by the update below.
+		c.expirations.Add(uint64(s.update(now, func(map[string]entry) int64 { return 0 })))
+		return nil
+	}
+
+	expired := s.update(now, func(data map[string]entry) int64 {
+		delete(data, string(key))
+		return -int64(len(key) + len(e.value))
+	})
+	c.expirations.Add(uint64(expired))
+	c.deletes.Add(1)
+	return nil
+}
+
+// Stats reports the cache counters. Keys and Bytes include entries that have
+// expired but whose shard has not been written to since.
+func (c *Cache) Stats() ggcache.Stats {
+	stats := ggcache.Stats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Sets:        c.sets.Load(),
+		Deletes:     c.deletes.Load(),
+		Expirations: c.expirations.Load(),
+	}
+	for i := range c.shards {
+		stats.Keys += len(*c.shards[i].data.Load())
+		stats.Bytes += int(c.shards[i].bytes.Load())
+	}
+	return stats
+}
+
+func (c *Cache) shard(key []byte) *shard {
+	return &c.shards[c.shardIndex(key)]
+}
+
+// shardIndex returns the index of the shard of the key, which also orders
+// the locks of the shards taken together.
+func (c *Cache) shardIndex(key []byte) uint64 {
+	return maphash.Bytes(c.seed, key) & uint64(len(c.shards)-1)
+}
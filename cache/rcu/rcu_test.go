@@ -0,0 +1,174 @@
+package rcu
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ ggcache.Cacher        = (*Cache)(nil)
+	_ ggcache.Toucher       = (*Cache)(nil)
+	_ ggcache.StatsProvider = (*Cache)(nil)
+	_ ggcache.StableValues  = (*Cache)(nil)
+	_ ggcache.Renamer       = (*Cache)(nil)
+)
+
+func TestCache(t *testing.T) {
+	c := New(Options{})
+
+	_, err := c.Get([]byte("foo"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set([]byte("foo"), []byte("baz"), 0))
+	assert.True(t, c.Has([]byte("foo")))
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("baz"), value)
+	assert.Equal(t, len("foo")+len("baz"), c.Stats().Bytes)
+
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.False(t, c.Has([]byte("foo")))
+	assert.Nil(t, c.Delete([]byte("foo")))
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Sets)
+	assert.Equal(t, uint64(1), stats.Deletes)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 0, stats.Keys)
+	assert.Equal(t, 0, stats.Bytes)
+}
+
+func TestCacheShards(t *testing.T) {
+	assert.Len(t, New(Options{}).shards, 256)
+	assert.Len(t, New(Options{Shards: 1}).shards, 1)
+	assert.Len(t, New(Options{Shards: 100}).shards, 128)
+}
+
+func TestCacheExpiration(t *testing.T) {
+	c := New(Options{Shards: 1})
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 10*time.Millisecond))
+	assert.Nil(t, c.Set([]byte("baz"), []byte("qux"), 10*time.Millisecond))
+	assert.Nil(t, c.Touch([]byte("baz"), 0))
+	time.Sleep(20 * time.Millisecond)
+
+	assert.False(t, c.Has([]byte("foo")))
+	assert.NotNil(t, c.Touch([]byte("foo"), time.Second))
+	assert.True(t, c.Has([]byte("baz")))
+
+	// The next write to the shard drops the expired entry.
+	assert.Equal(t, 2, c.Stats().Keys)
+	assert.Nil(t, c.Set([]byte("quux"), []byte("corge"), 0))
+	stats := c.Stats()
+	assert.Equal(t, 2, stats.Keys)
+	assert.Equal(t, len("bazqux")+len("quuxcorge"), stats.Bytes)
+	assert.Equal(t, uint64(1), stats.Expirations)
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	c := New(Options{Shards: 4})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", i, j))
+				assert.Nil(t, c.Set(key, key, 0))
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", i, j))
+				if value, err := c.Get(key); err == nil {
+					assert.Equal(t, key, value)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 8*200, c.Stats().Keys)
+}
+
+// BenchmarkParallelGet compares concurrent reads of the built-in
+// ggcache.Cache and of this engine.
+func BenchmarkParallelGet(b *testing.B) {
+	const n = 10000
+
+	engines := []struct {
+		name string
+		new  func() ggcache.Cacher
+	}{
+		{"rwmutex", func() ggcache.Cacher { return ggcache.New() }},
+		{"rcu", func() ggcache.Cacher { return New(Options{}) }},
+	}
+	for _, engine := range engines {
+		b.Run(engine.name, func(b *testing.B) {
+			c := engine.new()
+			keys := make([][]byte, n)
+			for i := range keys {
+				keys[i] = []byte(fmt.Sprintf("key-%d", i))
+				_ = c.Set(keys[i], []byte("value"), 0)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					_, _ = c.Get(keys[i%n])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestCacheRename(t *testing.T) {
+	c := New(Options{Shards: 4})
+
+	assert.NotNil(t, c.Rename([]byte("tmp"), []byte("foo")))
+
+	assert.Nil(t, c.Set([]byte("tmp"), []byte("bar"), time.Hour))
+	assert.Nil(t, c.Set([]byte("foo"), []byte("old"), 0))
+	assert.Nil(t, c.Rename([]byte("tmp"), []byte("foo")))
+	assert.False(t, c.Has([]byte("tmp")))
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.Equal(t, len("foo")+len("bar"), c.Stats().Bytes)
+
+	assert.Nil(t, c.Copy([]byte("foo"), []byte("baz"), 0))
+	value, err = c.Get([]byte("baz"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.True(t, c.Has([]byte("foo")))
+
+	// Renames in both directions between shards do not deadlock.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				a, b := []byte(fmt.Sprint("a", j%4)), []byte(fmt.Sprint("b", j%4))
+				if i%2 == 1 {
+					a, b = b, a
+				}
+				_ = c.Set(a, []byte("x"), 0)
+				_ = c.Rename(a, b)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
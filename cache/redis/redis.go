@@ -0,0 +1,397 @@
+// Package redis implements a ggcache.Cacher that stores its entries in an
+// upstream Redis server. Serving it behind a ggcache node turns the node into
+// a proxy that translates the ggcache protocol to Redis, and combined with
+// ggcache.Layered it puts an in-memory L1 in front of Redis.
+//
+// The package speaks the subset of RESP it needs over a small pool of
+// connections and has no dependencies outside the standard library.
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+type Options struct {
+	// Password is sent with AUTH when a connection is opened, if not empty.
+	Password string
+
+	// DB is selected when a connection is opened, if not zero.
+	DB int
+
+	// DialTimeout bounds connecting to the server, 5 seconds if zero.
+	DialTimeout time.Duration
+
+	// Timeout bounds every command, 5 seconds if zero.
+	Timeout time.Duration
+
+	// MaxIdle is the number of idle connections kept open, 8 if zero.
+	MaxIdle int
+}
+
+// Cache is a ggcache.Cacher backed by Redis. It is safe for concurrent use.
+type Cache struct {
+	addr string
+	opts Options
+	idle chan *conn
+}
+
+func New(addr string, opts Options) *Cache {
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxIdle == 0 {
+		opts.MaxIdle = 8
+	}
+	return &Cache{
+		addr: addr,
+		opts: opts,
+		idle: make(chan *conn, opts.MaxIdle),
+	}
+}
+
+func (c *Cache) Get(key []byte) ([]byte, error) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply to GET: %v", reply)
+	}
+	return value, nil
+}
+
+func (c *Cache) Set(key, value []byte, ttl time.Duration) error {
+	args := [][]byte{key, value}
+	if ttl > 0 {
+		args = append(args, []byte("PX"), []byte(strconv.FormatInt(milliseconds(ttl), 10)))
+	}
+	_, err := c.do("SET", args...)
+	return err
+}
+
+// Touch sets the expiration of the key with PEXPIRE, or removes it with
+// PERSIST if the TTL is zero.
+func (c *Cache) Touch(key []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		// PERSIST replies 0 both for missing keys and keys without a TTL,
+		// so check whether the key exists separately.
+		if _, err := c.do("PERSIST", key); err != nil {
+			return err
+		}
+		if !c.Has(key) {
+			return fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+		}
+		return nil
+	}
+
+	reply, err := c.do("PEXPIRE", key, []byte(strconv.FormatInt(milliseconds(ttl), 10)))
+	if err != nil {
+		return err
+	}
+	if reply != int64(1) {
+		return fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	return nil
+}
+
+// Has reports whether the key exists. Errors talking to Redis are reported
+// as the key not existing.
+func (c *Cache) Has(key []byte) bool {
+	reply, err := c.do("EXISTS", key)
+	return err == nil && reply == int64(1)
+}
+
+func (c *Cache) Delete(key []byte) error {
+	_, err := c.do("DEL", key)
+	return err
+}
+
+// Close closes the idle connections.
+func (c *Cache) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			_ = cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Watch subscribes to the keyspace notifications of the selected DB and
+// calls fn with every key that is written, deleted or expires, until stop is
+// called. It implements ggcache.Watcher.
+//
+// Redis only publishes notifications once they are enabled, for example with
+// CONFIG SET notify-keyspace-events KA. If the subscription drops, it is
+// re-established with a backoff; changes made in the meantime are missed.
+func (c *Cache) Watch(fn func(key []byte)) (stop func()) {
+	var (
+		mu      sync.Mutex
+		current *conn
+		quit    = make(chan struct{})
+	)
+
+	go func() {
+		backoff := 100 * time.Millisecond
+		for {
+			cn, err := c.dial()
+			if err == nil {
+				mu.Lock()
+				select {
+				case <-quit:
+					mu.Unlock()
+					_ = cn.Close()
+					return
+				default:
+					current = cn
+				}
+				mu.Unlock()
+
+				if c.subscribe(cn, fn) {
+					backoff = 100 * time.Millisecond
+				}
+				_ = cn.Close()
+			}
+
+			select {
+			case <-quit:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < 5*time.Second {
+				backoff *= 2
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			close(quit)
+			if current != nil {
+				_ = current.Close()
+			}
+		})
+	}
+}
+
+// subscribe listens for keyspace notifications on the connection until it
+// fails. It reports whether the subscription was established.
+func (c *Cache) subscribe(cn *conn, fn func(key []byte)) bool {
+	prefix := fmt.Sprintf("__keyspace@%d__:", c.opts.DB)
+	reply, err := cn.do(c.opts.Timeout, "PSUBSCRIBE", []byte(prefix+"*"))
+	if err != nil {
+		return false
+	}
+	if msg, ok := reply.([]any); !ok || len(msg) == 0 || !bytes.Equal(asBytes(msg[0]), []byte("psubscribe")) {
+		return false
+	}
+	if err := cn.SetDeadline(time.Time{}); err != nil {
+		return false
+	}
+
+	for {
+		reply, err := readReply(cn.r)
+		if err != nil {
+			return true
+		}
+		// pmessage <pattern> <channel> <event>
+		msg, ok := reply.([]any)
+		if !ok || len(msg) != 4 || !bytes.Equal(asBytes(msg[0]), []byte("pmessage")) {
+			continue
+		}
+		if key, ok := bytes.CutPrefix(asBytes(msg[2]), []byte(prefix)); ok {
+			fn(key)
+		}
+	}
+}
+
+func asBytes(v any) []byte {
+	b, _ := v.([]byte)
+	return b
+}
+
+// Error is an error reply from Redis.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// do runs the command on a pooled connection and returns its reply: nil,
+// string, int64, []byte or []any. A connection that failed is discarded.
+func (c *Cache) do(cmd string, args ...[]byte) (any, error) {
+	cn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(c.opts.Timeout, cmd, args...)
+	var rerr Error
+	if err != nil && !errors.As(err, &rerr) {
+		_ = cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+func (c *Cache) conn() (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+	return c.dial()
+}
+
+// dial opens a new connection, authenticated and with the DB selected.
+func (c *Cache) dial() (*conn, error) {
+	nc, err := net.DialTimeout("tcp", c.addr, c.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if len(c.opts.Password) != 0 {
+		if _, err := cn.do(c.opts.Timeout, "AUTH", []byte(c.opts.Password)); err != nil {
+			_ = cn.Close()
+			return nil, err
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := cn.do(c.opts.Timeout, "SELECT", []byte(strconv.Itoa(c.opts.DB))); err != nil {
+			_ = cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Cache) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.Close()
+	}
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (cn *conn) do(timeout time.Duration, cmd string, args ...[]byte) (any, error) {
+	if err := cn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)+1), 10)
+	buf = append(buf, "\r\n"...)
+	buf = appendBulk(buf, []byte(cmd))
+	for _, arg := range args {
+		buf = appendBulk(buf, arg)
+	}
+	if _, err := cn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return readReply(cn.r)
+}
+
+func appendBulk(buf, b []byte) []byte {
+	buf = append(buf, '$')
+	buf = strconv.AppendInt(buf, int64(len(b)), 10)
+	buf = append(buf, "\r\n"...)
+	buf = append(buf, b...)
+	return append(buf, "\r\n"...)
+}
+
+// readReply reads a simple string, error, integer, bulk string or array reply.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length: %w", err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length: %w", err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		elems := make([]any, n)
+		for i := range elems {
+			if elems[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	default:
+		return nil, fmt.Errorf("redis: unsupported reply %q", line)
+	}
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// milliseconds rounds the TTL up to whole milliseconds, so short TTLs do
+// not become zero.
+func milliseconds(ttl time.Duration) int64 {
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
@@ -0,0 +1,227 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ ggcache.Cacher  = (*Cache)(nil)
+	_ ggcache.Toucher = (*Cache)(nil)
+	_ ggcache.Watcher = (*Cache)(nil)
+)
+
+// fakeRedis serves the commands the Cache sends from a map, recording the
+// TTL of every key.
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string][]byte
+	ttls map[string]int64
+	cmds []string
+	subs []net.Conn
+}
+
+func startFakeRedis(t *testing.T) (*fakeRedis, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	f := &fakeRedis{data: make(map[string][]byte), ttls: make(map[string]int64)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		// Replies are written under the lock so they cannot interleave with
+		// notifications published to the same connection.
+		f.mu.Lock()
+		f.cmds = append(f.cmds, args[0])
+		if args[0] == "PSUBSCRIBE" {
+			f.subs = append(f.subs, conn)
+		}
+		_, err = io.WriteString(conn, f.exec(args))
+		f.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+	_, exists := f.data[key]
+
+	switch args[0] {
+	case "AUTH":
+		if args[1] != "secret" {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		return "+OK\r\n"
+	case "GET":
+		if !exists {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(f.data[key]), f.data[key])
+	case "PSUBSCRIBE":
+		return fmt.Sprintf("*3\r\n$10\r\npsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+	case "SET":
+		f.publish(key, "set")
+		f.data[key] = []byte(args[2])
+		delete(f.ttls, key)
+		if len(args) == 5 && args[3] == "PX" {
+			f.ttls[key], _ = strconv.ParseInt(args[4], 10, 64)
+		}
+		return "+OK\r\n"
+	case "EXISTS":
+		if exists {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "DEL":
+		f.publish(key, "del")
+		delete(f.data, key)
+		return ":1\r\n"
+	case "PEXPIRE":
+		if !exists {
+			return ":0\r\n"
+		}
+		f.ttls[key], _ = strconv.ParseInt(args[2], 10, 64)
+		return ":1\r\n"
+	case "PERSIST":
+		if _, ok := f.ttls[key]; !ok {
+			return ":0\r\n"
+		}
+		delete(f.ttls, key)
+		return ":1\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func (f *fakeRedis) publish(key, event string) {
+	channel := "__keyspace@0__:" + key
+	msg := fmt.Sprintf("*4\r\n$8\r\npmessage\r\n$16\r\n__keyspace@0__:*\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+		len(channel), channel, len(event), event)
+	for _, sub := range f.subs {
+		_, _ = io.WriteString(sub, msg)
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		reply, err := readReply(r)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(reply.([]byte))
+	}
+	return args, nil
+}
+
+func TestCache(t *testing.T) {
+	f, addr := startFakeRedis(t)
+	c := New(addr, Options{})
+	defer c.Close()
+
+	_, err := c.Get([]byte("foo"))
+	assert.NotNil(t, err)
+	assert.False(t, c.Has([]byte("foo")))
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 1500*time.Microsecond))
+	assert.Equal(t, int64(2), f.ttls["foo"])
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.True(t, c.Has([]byte("foo")))
+
+	assert.Nil(t, c.Touch([]byte("foo"), time.Minute))
+	assert.Equal(t, int64(60000), f.ttls["foo"])
+	assert.Nil(t, c.Touch([]byte("foo"), 0))
+	assert.Nil(t, c.Touch([]byte("foo"), 0))
+	assert.NotContains(t, f.ttls, "foo")
+	assert.NotNil(t, c.Touch([]byte("missing"), time.Minute))
+	assert.NotNil(t, c.Touch([]byte("missing"), 0))
+
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.False(t, c.Has([]byte("foo")))
+}
+
+func TestCacheAuth(t *testing.T) {
+	f, addr := startFakeRedis(t)
+
+	c := New(addr, Options{Password: "wrong"})
+	err := c.Set([]byte("foo"), []byte("bar"), 0)
+	assert.ErrorContains(t, err, "WRONGPASS")
+
+	c = New(addr, Options{Password: "secret"})
+	defer c.Close()
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set([]byte("foo"), []byte("baz"), 0))
+
+	// The connection is authenticated once and then reused.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Equal(t, []string{"AUTH", "AUTH", "SET", "SET"}, f.cmds)
+}
+
+func TestCacheWatch(t *testing.T) {
+	f, addr := startFakeRedis(t)
+	c := New(addr, Options{})
+	defer c.Close()
+
+	keys := make(chan string, 8)
+	stop := c.Watch(func(key []byte) {
+		keys <- string(key)
+	})
+
+	assert.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.subs) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.Equal(t, "foo", <-keys)
+	assert.Equal(t, "foo", <-keys)
+
+	stop()
+	stop()
+}
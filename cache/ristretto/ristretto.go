@@ -0,0 +1,79 @@
+// Package ristretto adapts a Ristretto cache to ggcache.Cacher, so a node can
+// serve from an engine with a TinyLFU admission policy and cost-based
+// eviction instead of the unbounded in-memory map.
+//
+//	rc, err := ristretto.NewCache(&ristretto.Config[string, []byte]{
+//		NumCounters: 1e7,
+//		MaxCost:     1 << 30,
+//		BufferItems: 64,
+//	})
+//	cache := ggristretto.New(rc)
+//
+// The adapter only relies on the method set of *ristretto.Cache[string, []byte]
+// (github.com/dgraph-io/ristretto/v2), described by Engine, so this module
+// does not depend on Ristretto itself.
+package ristretto
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// ErrRejected is returned by Set when the admission policy dropped the entry.
+var ErrRejected = errors.New("ristretto: entry rejected by the admission policy")
+
+// Engine is the subset of *ristretto.Cache[string, []byte] the adapter uses.
+type Engine interface {
+	Get(key string) ([]byte, bool)
+	SetWithTTL(key string, value []byte, cost int64, ttl time.Duration) bool
+	Del(key string)
+	Wait()
+}
+
+// Cache implements ggcache.Cacher on top of a Ristretto cache. The cost of an
+// entry is the size of its key and value.
+type Cache struct {
+	engine Engine
+}
+
+func New(engine Engine) *Cache {
+	return &Cache{
+		engine: engine,
+	}
+}
+
+func (c *Cache) Get(key []byte) ([]byte, error) {
+	value, ok := c.engine.Get(string(key))
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ggcache.ErrKeyNotFound, key)
+	}
+	return value, nil
+}
+
+// Set stores the entry and waits for Ristretto to apply it, so a Get that
+// follows sees the new value. Ristretto may still refuse the entry, in which
+// case ErrRejected is returned.
+func (c *Cache) Set(key, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	cost := int64(len(key) + len(value))
+	if !c.engine.SetWithTTL(string(key), value, cost, ttl) {
+		return ErrRejected
+	}
+	c.engine.Wait()
+	return nil
+}
+
+func (c *Cache) Has(key []byte) bool {
+	_, ok := c.engine.Get(string(key))
+	return ok
+}
+
+func (c *Cache) Delete(key []byte) error {
+	c.engine.Del(string(key))
+	return nil
+}
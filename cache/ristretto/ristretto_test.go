@@ -0,0 +1,82 @@
+package ristretto
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ ggcache.Cacher = (*Cache)(nil)
+
+// fakeEngine stands in for *ristretto.Cache, admitting entries up to maxCost.
+type fakeEngine struct {
+	mu      sync.Mutex
+	maxCost int64
+	data    map[string][]byte
+	ttls    map[string]time.Duration
+	waits   int
+}
+
+func newFakeEngine(maxCost int64) *fakeEngine {
+	return &fakeEngine{
+		maxCost: maxCost,
+		data:    make(map[string][]byte),
+		ttls:    make(map[string]time.Duration),
+	}
+}
+
+func (e *fakeEngine) Get(key string) ([]byte, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.data[key]
+	return v, ok
+}
+
+func (e *fakeEngine) SetWithTTL(key string, value []byte, cost int64, ttl time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cost > e.maxCost {
+		return false
+	}
+	e.data[key] = value
+	e.ttls[key] = ttl
+	return true
+}
+
+func (e *fakeEngine) Del(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.data, key)
+}
+
+func (e *fakeEngine) Wait() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.waits++
+}
+
+func TestCache(t *testing.T) {
+	engine := newFakeEngine(16)
+	c := New(engine)
+
+	_, err := c.Get([]byte("foo"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), time.Minute))
+	assert.Equal(t, time.Minute, engine.ttls["foo"])
+	assert.Equal(t, 1, engine.waits)
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.True(t, c.Has([]byte("foo")))
+
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.False(t, c.Has([]byte("foo")))
+
+	assert.ErrorIs(t, c.Set([]byte("big"), make([]byte, 32), 0), ErrRejected)
+	assert.False(t, c.Has([]byte("big")))
+}
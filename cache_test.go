@@ -1,6 +1,10 @@
 package ggcache
 
 import (
+	"errors"
+	"maps"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 )
@@ -11,8 +15,8 @@ func TestCache_Get(t *testing.T) {
 
 	// Test Case 1: Key not found
 	_, err := cache.Get([]byte("nonexistent"))
-	if err == nil {
-		t.Error("Expected error for nonexistent key, but got nil")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for nonexistent key, but got %v", err)
 	}
 
 	// Test Case 2: Key found
@@ -52,6 +56,158 @@ func TestCache_Set(t *testing.T) {
 	}
 }
 
+// TestCache_SetOverwrite tests that overwriting a key discards the TTL of the previous value.
+func TestCache_SetOverwrite(t *testing.T) {
+	cache := New()
+
+	key := []byte("testKey")
+	ttl := time.Millisecond * 50
+	_ = cache.Set(key, []byte("old"), ttl)
+	_ = cache.Set(key, []byte("new"), 0)
+
+	time.Sleep(ttl + time.Millisecond*50)
+
+	if !cache.Has(key) {
+		t.Error("Expected the overwritten key to be present, but it expired with the old value")
+	}
+}
+
+// TestCache_Touch tests the Touch method of the Cache.
+func TestCache_Touch(t *testing.T) {
+	cache := New()
+
+	// Test Case 1: Touch nonexistent key
+	if err := cache.Touch([]byte("nonexistent"), time.Second); err == nil {
+		t.Error("Expected error for nonexistent key, but got nil")
+	}
+
+	// Test Case 2: Touch extends the TTL
+	key := []byte("testKey")
+	ttl := time.Millisecond * 100
+	_ = cache.Set(key, []byte("testValue"), ttl)
+
+	time.Sleep(ttl / 2)
+	if err := cache.Touch(key, ttl); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	time.Sleep(ttl/2 + time.Millisecond*20)
+	if !cache.Has(key) {
+		t.Error("Expected touched key to be present, but it expired")
+	}
+
+	// Test Case 3: Touched key still expires with the new TTL
+	time.Sleep(ttl)
+	if cache.Has(key) {
+		t.Error("Expected key to be expired, but it's still present")
+	}
+}
+
+// TestCache_Append tests the Append method of the Cache.
+func TestCache_Append(t *testing.T) {
+	cache := New()
+
+	// Test Case 1: Append to nonexistent key
+	if err := cache.Append([]byte("nonexistent"), []byte("data")); err == nil {
+		t.Error("Expected error for nonexistent key, but got nil")
+	}
+
+	// Test Case 2: Appends do not touch the caller's buffer or earlier values
+	buf := make([]byte, 3, 16)
+	copy(buf, "foo")
+	key := []byte("testKey")
+	_ = cache.Set(key, buf, 0)
+
+	var values [][]byte
+	for _, data := range []string{"bar", "baz", "qux"} {
+		if err := cache.Append(key, []byte(data)); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		value, _ := cache.Get(key)
+		values = append(values, value)
+	}
+	if string(buf[:cap(buf)][3:6]) != "\x00\x00\x00" {
+		t.Errorf("Expected the caller's buffer to be untouched, got %q", buf[:cap(buf)])
+	}
+	for i, want := range []string{"foobar", "foobarbaz", "foobarbazqux"} {
+		if string(values[i]) != want {
+			t.Errorf("Expected value %q, but got %q", want, values[i])
+		}
+	}
+	if stats := cache.Stats(); stats.Bytes != len(key)+len("foobarbazqux") {
+		t.Errorf("Expected %d bytes, but got %d", len(key)+len("foobarbazqux"), stats.Bytes)
+	}
+
+	// Test Case 3: Appended key keeps its TTL
+	ttl := time.Millisecond * 50
+	_ = cache.Set(key, []byte("foo"), ttl)
+	_ = cache.Append(key, []byte("bar"))
+	time.Sleep(ttl + time.Millisecond*20)
+	if cache.Has(key) {
+		t.Error("Expected appended key to expire, but it's still present")
+	}
+}
+
+// TestCache_GetRange tests the GetRange method of the Cache.
+func TestCache_GetRange(t *testing.T) {
+	cache := New()
+	key := []byte("testKey")
+	_ = cache.Set(key, []byte("0123456789"), 0)
+
+	tests := []struct {
+		offset, length int
+		want           string
+	}{
+		{0, 4, "0123"},
+		{6, 10, "6789"},
+		{10, 1, ""},
+		{20, 1, ""},
+		{3, 0, ""},
+	}
+	for _, tt := range tests {
+		value, err := cache.GetRange(key, tt.offset, tt.length)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if string(value) != tt.want {
+			t.Errorf("Expected %q for range %d+%d, but got %q", tt.want, tt.offset, tt.length, value)
+		}
+	}
+
+	if _, err := cache.GetRange(key, -1, 2); err == nil {
+		t.Error("Expected error for negative offset, but got nil")
+	}
+	if _, err := cache.GetRange([]byte("nonexistent"), 0, 2); err == nil {
+		t.Error("Expected error for nonexistent key, but got nil")
+	}
+}
+
+// TestCache_Sample tests the Sample method of the Cache.
+func TestCache_Sample(t *testing.T) {
+	cache := New()
+	for i := 0; i < 10; i++ {
+		_ = cache.Set([]byte{'k', byte('0' + i)}, make([]byte, i), 0)
+	}
+
+	sizes := make(map[string]int)
+	cache.Sample(4, func(key []byte, size int) {
+		sizes[string(key)] = size
+	})
+	if len(sizes) != 4 {
+		t.Errorf("Expected 4 sampled entries, but got %d", len(sizes))
+	}
+	for key, size := range sizes {
+		if want := int(key[1] - '0'); size != want {
+			t.Errorf("Expected size %d for key %q, but got %d", want, key, size)
+		}
+	}
+
+	n := 0
+	cache.Sample(100, func([]byte, int) { n++ })
+	if n != 10 {
+		t.Errorf("Expected every entry to be sampled, but got %d", n)
+	}
+}
+
 // TestCache_Has tests the Has method of the Cache.
 func TestCache_Has(t *testing.T) {
 	cache := New()
@@ -144,3 +300,378 @@ func TestCacheIntegration(t *testing.T) {
 		t.Error("Expected key to be deleted, but it's still present")
 	}
 }
+
+// TestCache_Stats tests the counters reported by the Stats method of the Cache.
+func TestCache_Stats(t *testing.T) {
+	cache := New()
+
+	// Test Case 1: Hits, misses, sets and size accounting
+	_ = cache.Set([]byte("a"), []byte("123"), 0)
+	_ = cache.Set([]byte("a"), []byte("12"), 0)
+	_, _ = cache.Get([]byte("a"))
+	_, _ = cache.Get([]byte("b"))
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Sets != 2 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+	if stats.Keys != 1 || stats.Bytes != 3 {
+		t.Errorf("Expected 1 key of 3 bytes, but got %d keys of %d bytes", stats.Keys, stats.Bytes)
+	}
+
+	// Test Case 2: Deletes and expirations
+	_ = cache.Delete([]byte("a"))
+	_ = cache.Delete([]byte("a"))
+	_ = cache.Set([]byte("b"), []byte("1"), time.Millisecond*10)
+	time.Sleep(time.Millisecond * 50)
+
+	stats = cache.Stats()
+	if stats.Deletes != 1 || stats.Expirations != 1 {
+		t.Errorf("Expected 1 delete and 1 expiration, but got %+v", stats)
+	}
+	if stats.Keys != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected an empty cache, but got %d keys of %d bytes", stats.Keys, stats.Bytes)
+	}
+}
+
+// TestCache_ExpirationStats tests the lazy expirations and the TTL histogram reported by Stats.
+func TestCache_ExpirationStats(t *testing.T) {
+	cache := New()
+
+	// Test Case 1: Entries are counted in the bucket of their TTL until removed
+	_ = cache.Set([]byte("a"), []byte("1"), time.Second)
+	_ = cache.Set([]byte("b"), []byte("1"), time.Hour)
+	_ = cache.Set([]byte("c"), []byte("1"), 30*24*time.Hour)
+	_ = cache.Set([]byte("d"), []byte("1"), 0)
+
+	stats := cache.Stats()
+	if stats.TTLs != [numTTLBuckets + 1]int{0: 1, 4: 1, numTTLBuckets: 1} {
+		t.Errorf("Unexpected TTL histogram: %v", stats.TTLs)
+	}
+	_ = cache.Set([]byte("b"), []byte("2"), 0)
+	_ = cache.Delete([]byte("c"))
+	if stats := cache.Stats(); stats.TTLs != [numTTLBuckets + 1]int{0: 1} {
+		t.Errorf("Unexpected TTL histogram: %v", stats.TTLs)
+	}
+
+	// Test Case 2: A read of an entry past its expiry before its timer fires
+	// misses and counts a lazy expiration, once
+	cache.lock.Lock()
+	cache.data["a"].expiresAt = time.Now().Add(-time.Millisecond)
+	cache.lock.Unlock()
+	if _, err := cache.Get([]byte("a")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, but got %v", err)
+	}
+	if cache.Has([]byte("a")) {
+		t.Error("Expected the expired key to be missing")
+	}
+	time.Sleep(time.Millisecond * 1100)
+
+	stats = cache.Stats()
+	if stats.Expirations != 1 || stats.LazyExpirations != 1 {
+		t.Errorf("Expected 1 lazy expiration, but got %+v", stats)
+	}
+	if stats.TTLs != [numTTLBuckets + 1]int{} {
+		t.Errorf("Expected an empty TTL histogram, but got %v", stats.TTLs)
+	}
+}
+
+// TestCache_MaxIdle tests the eviction of the entries that are not accessed within the max idle time.
+func TestCache_MaxIdle(t *testing.T) {
+	cache := New(WithMaxIdle(time.Millisecond * 80))
+
+	// Test Case 1: Entries that are read stay, the others are evicted
+	_ = cache.Set([]byte("hot"), []byte("1"), 0)
+	_ = cache.Set([]byte("cold"), []byte("2"), 0)
+	for i := 0; i < 20; i++ {
+		_, _ = cache.Get([]byte("hot"))
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if !cache.Has([]byte("hot")) {
+		t.Error("Expected the read key to be present, but it was evicted")
+	}
+	if cache.Has([]byte("cold")) {
+		t.Error("Expected the idle key to be evicted, but it's still present")
+	}
+
+	// Test Case 2: Once the cache is empty, the idle clock stops
+	time.Sleep(time.Millisecond * 150)
+
+	stats := cache.Stats()
+	if stats.Evictions != 2 || stats.Keys != 0 {
+		t.Errorf("Expected 2 evictions and an empty cache, but got %+v", stats)
+	}
+	cache.lock.RLock()
+	running := cache.idleTimer != nil
+	cache.lock.RUnlock()
+	if running {
+		t.Error("Expected the idle timer to stop on an empty cache")
+	}
+}
+
+// TestCache_WatchRemovals tests that the entries the Cache removes on its own are reported.
+func TestCache_WatchRemovals(t *testing.T) {
+	cache := New(WithMaxIdle(time.Millisecond * 40))
+
+	var (
+		mu      sync.Mutex
+		removed = make(map[string]RemovalReason)
+	)
+	cache.WatchRemovals(func(key []byte, reason RemovalReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		removed[string(key)] = reason
+	})
+	reported := func() map[string]RemovalReason {
+		mu.Lock()
+		defer mu.Unlock()
+		return maps.Clone(removed)
+	}
+
+	// Test Case 1: Expired and idle entries are reported with their reason
+	_ = cache.Set([]byte("short"), []byte("1"), time.Millisecond*10)
+	_ = cache.Set([]byte("idle"), []byte("2"), 0)
+	_ = cache.Set([]byte("deleted"), []byte("3"), 0)
+	_ = cache.Delete([]byte("deleted"))
+	time.Sleep(time.Millisecond * 150)
+
+	want := map[string]RemovalReason{"short": RemovalExpired, "idle": RemovalEvicted}
+	if got := reported(); !maps.Equal(got, want) {
+		t.Errorf("Expected %v to be reported, but got %v", want, got)
+	}
+
+	// Test Case 2: Nothing is reported once the watcher is removed
+	cache.WatchRemovals(nil)
+	_ = cache.Set([]byte("later"), []byte("4"), time.Millisecond*10)
+	time.Sleep(time.Millisecond * 50)
+	if _, ok := reported()["later"]; ok {
+		t.Error("Expected no report after WatchRemovals(nil)")
+	}
+}
+
+// TestCache_Rename tests the Rename and Copy methods of the Cache.
+// TestCache_Expiry tests the Expiry method of the Cache.
+func TestCache_Expiry(t *testing.T) {
+	cache := New()
+
+	// Test Case 1: Key not found
+	if _, err := cache.Expiry([]byte("nonexistent")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for nonexistent key, but got %v", err)
+	}
+
+	// Test Case 2: An entry without TTL does not expire
+	_ = cache.Set([]byte("forever"), []byte("value"), 0)
+	if expiry, err := cache.Expiry([]byte("forever")); err != nil || !expiry.IsZero() {
+		t.Errorf("Expected the zero time, but got %v, %v", expiry, err)
+	}
+
+	// Test Case 3: An entry expires its TTL from when it was set
+	_ = cache.Set([]byte("key"), []byte("value"), time.Hour)
+	expiry, err := cache.Expiry([]byte("key"))
+	if want := time.Now().Add(time.Hour); err != nil || expiry.After(want) || want.Sub(expiry) > time.Second {
+		t.Errorf("Expected an expiry about an hour from now, but got %v, %v", expiry, err)
+	}
+}
+
+// TestCache_ExpiredReads tests the policies of WithExpiredReads for the reads of an entry past its expiry.
+func TestCache_ExpiredReads(t *testing.T) {
+	// expire makes the entry of the key expired without its timer firing.
+	expire := func(cache *Cache, key string) {
+		cache.lock.Lock()
+		cache.data[key].expiresAt = time.Now().Add(-time.Millisecond)
+		cache.lock.Unlock()
+	}
+
+	// Test Case 1: By default the entry is removed on the read and missed
+	cache := New()
+	_ = cache.Set([]byte("key"), []byte("value"), time.Hour)
+	expire(cache, "key")
+	if _, err := cache.GetRange([]byte("key"), 0, 3); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, but got %v", err)
+	}
+	if stats := cache.Stats(); stats.Keys != 0 || stats.LazyExpirations != 1 || stats.Misses != 1 {
+		t.Errorf("Expected the expired key to be removed, but got %+v", stats)
+	}
+
+	// Test Case 2: ExpiredStale serves the value flagged with ErrStale and keeps the entry
+	cache = New(WithExpiredReads(ExpiredStale, 0))
+	_ = cache.Set([]byte("key"), []byte("value"), time.Hour)
+	expire(cache, "key")
+	for i := 0; i < 2; i++ {
+		value, err := cache.Get([]byte("key"))
+		if !errors.Is(err, ErrStale) || string(value) != "value" {
+			t.Errorf("Expected the stale value, but got %q, %v", value, err)
+		}
+	}
+	if cache.Has([]byte("key")) {
+		t.Error("Expected the expired key to be missing")
+	}
+	if stats := cache.Stats(); stats.Keys != 1 || stats.Stale != 2 || stats.Hits != 2 || stats.LazyExpirations != 1 {
+		t.Errorf("Expected 2 stale hits, but got %+v", stats)
+	}
+
+	// Test Case 3: ExpiredExtend stores the value again with the extension
+	cache = New(WithExpiredReads(ExpiredExtend, time.Minute))
+	_ = cache.Set([]byte("key"), []byte("value"), time.Hour)
+	expire(cache, "key")
+	if value, err := cache.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("Expected the value, but got %q, %v", value, err)
+	}
+	expiry, err := cache.Expiry([]byte("key"))
+	if want := time.Now().Add(time.Minute); err != nil || expiry.After(want) || want.Sub(expiry) > time.Second {
+		t.Errorf("Expected an expiry about a minute from now, but got %v, %v", expiry, err)
+	}
+	if stats := cache.Stats(); stats.Extended != 1 || stats.Hits != 1 || stats.LazyExpirations != 0 {
+		t.Errorf("Expected 1 extension, but got %+v", stats)
+	}
+}
+
+// TestCache_WritesSkipExpired tests that the writes on a key treat an entry past its expiry, but not yet removed, as missing.
+func TestCache_WritesSkipExpired(t *testing.T) {
+	// expired returns a cache holding the key with a short TTL that passed, before its timer removes it.
+	expired := func() *Cache {
+		cache := New()
+		_ = cache.Set([]byte("key"), []byte("value"), time.Millisecond*50)
+		cache.lock.Lock()
+		cache.data["key"].expiresAt = time.Now().Add(-time.Millisecond)
+		cache.lock.Unlock()
+		return cache
+	}
+
+	// Test Case 1: Append, Touch, Rename and Copy miss the key and do not revive it
+	writes := map[string]func(*Cache) error{
+		"Append": func(c *Cache) error { return c.Append([]byte("key"), []byte("more")) },
+		"Touch":  func(c *Cache) error { return c.Touch([]byte("key"), time.Hour) },
+		"Rename": func(c *Cache) error { return c.Rename([]byte("key"), []byte("other")) },
+		"Copy":   func(c *Cache) error { return c.Copy([]byte("key"), []byte("other"), time.Hour) },
+	}
+	for name, write := range writes {
+		cache := expired()
+		if err := write(cache); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s: Expected ErrKeyNotFound, but got %v", name, err)
+		}
+		if stats := cache.Stats(); stats.Keys != 0 || stats.LazyExpirations != 1 {
+			t.Errorf("%s: Expected the expired key to be removed, but got %+v", name, stats)
+		}
+	}
+
+	// Test Case 2: SetIf with IfNoneMatch "*" adds the key as Get misses it
+	cache := expired()
+	if err := cache.SetIf([]byte("key"), []byte("new"), 0, Condition{IfNoneMatch: "*"}); err != nil {
+		t.Errorf("Expected the key to be added, but got %v", err)
+	}
+	if value, err := cache.Get([]byte("key")); err != nil || string(value) != "new" {
+		t.Errorf("Expected the new value, but got %q, %v", value, err)
+	}
+
+	// Test Case 3: SetIf and DeleteIf with a match on the expired value fail
+	cache = expired()
+	if err := cache.SetIf([]byte("key"), []byte("new"), 0, Condition{IfMatch: ETag([]byte("value"))}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed, but got %v", err)
+	}
+	cache = expired()
+	if err := cache.DeleteIf([]byte("key"), Condition{IfMatch: "*"}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed, but got %v", err)
+	}
+}
+
+// TestCache_ScanCreated tests the ScanCreated method of the Cache.
+func TestCache_ScanCreated(t *testing.T) {
+	cache := New()
+	_ = cache.Set([]byte("user:1"), []byte("old"), 0)
+	_ = cache.Set([]byte("user:2"), []byte("old"), time.Hour)
+	_ = cache.Set([]byte("order:1"), []byte("old"), 0)
+	time.Sleep(time.Millisecond * 5)
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond * 5)
+
+	// Test Case 1: Touch and Rename keep when the value was written, a write resets it
+	_ = cache.Touch([]byte("user:2"), 0)
+	_ = cache.Rename([]byte("order:1"), []byte("user:3"))
+	_ = cache.Set([]byte("user:1"), []byte("new"), 0)
+
+	var keys []string
+	cache.ScanCreated([]byte("user:"), cutoff, func(key []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "user:2" || keys[1] != "user:3" {
+		t.Errorf("Expected user:2 and user:3, but got %v", keys)
+	}
+
+	// Test Case 2: Keys outside of the prefix are skipped
+	n := 0
+	cache.ScanCreated([]byte("order:"), time.Now(), func(key []byte) bool {
+		n++
+		return true
+	})
+	if n != 0 {
+		t.Errorf("Expected no keys, but got %d", n)
+	}
+}
+
+func TestCache_Rename(t *testing.T) {
+	cache := New()
+
+	// Test Case 1: Key not found
+	if err := cache.Rename([]byte("tmp"), []byte("key")); err == nil {
+		t.Error("Expected error for nonexistent key, but got nil")
+	}
+
+	// Test Case 2: The value moves with its expiration, replacing the new key
+	_ = cache.Set([]byte("tmp"), []byte("value"), time.Millisecond*50)
+	_ = cache.Set([]byte("key"), []byte("old"), 0)
+	if err := cache.Rename([]byte("tmp"), []byte("key")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if cache.Has([]byte("tmp")) {
+		t.Error("Expected the old key to be removed, but it's still present")
+	}
+	if value, _ := cache.Get([]byte("key")); string(value) != "value" {
+		t.Errorf("Expected value %s, but got %s", "value", value)
+	}
+
+	// Test Case 3: A copy has its own TTL and outlives its source
+	if err := cache.Copy([]byte("key"), []byte("copy"), 0); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if cache.Has([]byte("key")) {
+		t.Error("Expected the renamed key to expire, but it's still present")
+	}
+	if value, _ := cache.Get([]byte("copy")); string(value) != "value" {
+		t.Errorf("Expected value %s, but got %s", "value", value)
+	}
+	if stats := cache.Stats(); stats.Keys != 1 || stats.Bytes != len("copy")+len("value") {
+		t.Errorf("Expected 1 key of %d bytes, but got %+v", len("copy")+len("value"), stats)
+	}
+}
+
+func TestCache_WriteMulti(t *testing.T) {
+	cache := New()
+	_ = cache.Set([]byte("{user:1}:cart"), []byte("old"), 0)
+
+	// Test Case 1: The writes are applied in order
+	err := cache.WriteMulti([]Write{
+		{Key: []byte("{user:1}:profile"), Value: []byte("alice"), TTL: time.Hour},
+		{Key: []byte("{user:1}:cart"), Delete: true},
+		{Key: []byte("{user:1}:visits"), Value: []byte("1")},
+		{Key: []byte("{user:1}:visits"), Value: []byte("2")},
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if cache.Has([]byte("{user:1}:cart")) {
+		t.Error("Expected the deleted key to be removed, but it's still present")
+	}
+	if value, _ := cache.Get([]byte("{user:1}:visits")); string(value) != "2" {
+		t.Errorf("Expected value %s, but got %s", "2", value)
+	}
+
+	// Test Case 2: They are counted like single writes
+	if stats := cache.Stats(); stats.Sets != 4 || stats.Deletes != 1 || stats.Keys != 2 {
+		t.Errorf("Expected 4 sets, 1 delete and 2 keys, but got %+v", stats)
+	}
+}
@@ -0,0 +1,225 @@
+package ggcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultChunkSize is the chunk size of a ChunkedCache if ChunkSize is not set.
+const DefaultChunkSize = 1 << 20
+
+// Stored values start with a tag telling whole values from manifests.
+const (
+	chunkedWhole    = 0
+	chunkedManifest = 1
+)
+
+// manifestSize is the size of a manifest: the tag, the generation of its
+// chunks, their number and the total size of the value.
+const manifestSize = 1 + 8 + 4 + 8
+
+// ChunkedOptions configure a ChunkedCache.
+type ChunkedOptions struct {
+	// ChunkSize is the largest value stored as a single entry. Larger values
+	// are split into entries of this size. Zero uses DefaultChunkSize.
+	ChunkSize int
+}
+
+// ChunkedCache is a Cacher that splits values above a threshold into several
+// entries of the Cacher it wraps, so a large value is never stored, copied or
+// sent to a remote engine in one piece and each write holds the engine's lock
+// for one chunk at a time.
+//
+// The key of a large value holds a manifest naming its chunks, which are
+// stored under derived keys. A value is reassembled into a single slice when
+// it is read, as Get has to return one.
+//
+// Every value stored through a ChunkedCache is tagged, so the wrapped Cacher
+// must only be written through it.
+type ChunkedCache struct {
+	// c is the wrapped Cacher holding the manifests and chunks.
+	c Cacher
+
+	// opts are the options the cache was created with.
+	opts ChunkedOptions
+
+	// gen is the generation of the last chunked value, telling apart the
+	// chunks of a value and of the one overwriting it.
+	gen atomic.Uint64
+}
+
+// Chunked creates a ChunkedCache storing its entries in c.
+func Chunked(c Cacher, opts ChunkedOptions) *ChunkedCache {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	cc := &ChunkedCache{c: c, opts: opts}
+	// Start from the clock so a restarted process sharing a remote engine
+	// does not reuse the generations of the previous one.
+	cc.gen.Store(uint64(time.Now().UnixNano()))
+	return cc
+}
+
+// manifest describes a chunked value.
+type manifest struct {
+	gen    uint64
+	chunks int
+	size   int
+}
+
+func (m manifest) bytes() []byte {
+	b := make([]byte, 0, manifestSize)
+	b = append(b, chunkedManifest)
+	b = binary.LittleEndian.AppendUint64(b, m.gen)
+	b = binary.LittleEndian.AppendUint32(b, uint32(m.chunks))
+	return binary.LittleEndian.AppendUint64(b, uint64(m.size))
+}
+
+func parseManifest(b []byte) (manifest, error) {
+	if len(b) != manifestSize {
+		return manifest{}, errors.New("chunked: malformed manifest")
+	}
+	return manifest{
+		gen:    binary.LittleEndian.Uint64(b[1:]),
+		chunks: int(binary.LittleEndian.Uint32(b[9:])),
+		size:   int(binary.LittleEndian.Uint64(b[13:])),
+	}, nil
+}
+
+// Get returns the value of the key, reassembling it from its chunks if it
+// was chunked.
+func (c *ChunkedCache) Get(key []byte) ([]byte, error) {
+	b, err := c.c.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("chunked: key (%s) has no tag", key)
+	}
+	if b[0] == chunkedWhole {
+		return b[1:], nil
+	}
+
+	m, err := parseManifest(b)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, 0, m.size)
+	for i := 0; i < m.chunks; i++ {
+		chunk, err := c.c.Get(c.chunkKey(key, m.gen, i))
+		if err != nil {
+			// The value was overwritten or deleted while being read.
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+		}
+		value = append(value, chunk...)
+	}
+	if len(value) != m.size {
+		return nil, fmt.Errorf("chunked: key (%s) has %d bytes, want %d", key, len(value), m.size)
+	}
+	return value, nil
+}
+
+// Set stores the value of the key, in chunks if it is larger than the chunk
+// size. The chunks are written before the manifest, and the chunks of the
+// previous value are deleted after it, so readers see either value whole.
+func (c *ChunkedCache) Set(key, value []byte, ttl time.Duration) error {
+	old, hasOld := c.manifest(key)
+
+	if len(value) <= c.opts.ChunkSize {
+		b := make([]byte, 0, 1+len(value))
+		b = append(b, chunkedWhole)
+		if err := c.c.Set(key, append(b, value...), ttl); err != nil {
+			return err
+		}
+	} else {
+		m := manifest{
+			gen:    c.gen.Add(1),
+			chunks: (len(value) + c.opts.ChunkSize - 1) / c.opts.ChunkSize,
+			size:   len(value),
+		}
+		for i := 0; i < m.chunks; i++ {
+			chunk := value[i*c.opts.ChunkSize : min((i+1)*c.opts.ChunkSize, len(value))]
+			if err := c.c.Set(c.chunkKey(key, m.gen, i), chunk, ttl); err != nil {
+				c.deleteChunks(key, manifest{gen: m.gen, chunks: i})
+				return err
+			}
+		}
+		if err := c.c.Set(key, m.bytes(), ttl); err != nil {
+			c.deleteChunks(key, m)
+			return err
+		}
+	}
+
+	if hasOld {
+		c.deleteChunks(key, old)
+	}
+	return nil
+}
+
+// Touch resets the expiration of the key and of its chunks. An error is
+// returned if the wrapped Cacher is not a Toucher.
+func (c *ChunkedCache) Touch(key []byte, ttl time.Duration) error {
+	t, ok := c.c.(Toucher)
+	if !ok {
+		return errors.New("chunked: the cache does not support touch")
+	}
+	// The chunks are touched first so they never expire before the
+	// manifest.
+	if m, ok := c.manifest(key); ok {
+		for i := 0; i < m.chunks; i++ {
+			if err := t.Touch(c.chunkKey(key, m.gen, i), ttl); err != nil {
+				return err
+			}
+		}
+	}
+	return t.Touch(key, ttl)
+}
+
+// Has checks whether the key is present.
+func (c *ChunkedCache) Has(key []byte) bool {
+	return c.c.Has(key)
+}
+
+// Delete removes the key and its chunks.
+func (c *ChunkedCache) Delete(key []byte) error {
+	m, ok := c.manifest(key)
+	if err := c.c.Delete(key); err != nil {
+		return err
+	}
+	if ok {
+		c.deleteChunks(key, m)
+	}
+	return nil
+}
+
+// manifest returns the manifest stored under the key, if its value is
+// chunked.
+func (c *ChunkedCache) manifest(key []byte) (manifest, bool) {
+	b, err := c.c.Get(key)
+	if err != nil || len(b) == 0 || b[0] != chunkedManifest {
+		return manifest{}, false
+	}
+	m, err := parseManifest(b)
+	return m, err == nil
+}
+
+func (c *ChunkedCache) deleteChunks(key []byte, m manifest) {
+	for i := 0; i < m.chunks; i++ {
+		_ = c.c.Delete(c.chunkKey(key, m.gen, i))
+	}
+}
+
+// chunkKey returns the key of a chunk. The NUL byte keeps it from clashing
+// with keys written by applications.
+func (c *ChunkedCache) chunkKey(key []byte, gen uint64, i int) []byte {
+	b := make([]byte, 0, len(key)+32)
+	b = append(b, key...)
+	b = append(b, "\x00chunk:"...)
+	b = strconv.AppendUint(b, gen, 36)
+	b = append(b, ':')
+	return strconv.AppendInt(b, int64(i), 10)
+}
@@ -0,0 +1,74 @@
+package ggcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunked(t *testing.T) {
+	inner := New()
+	c := Chunked(inner, ChunkedOptions{ChunkSize: 4})
+
+	// Values up to the chunk size are a single entry.
+	assert.Nil(t, c.Set([]byte("small"), []byte("abcd"), 0))
+	value, err := c.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abcd"), value)
+	assert.Equal(t, 1, inner.Stats().Keys)
+
+	// Larger ones are split into chunks behind a manifest.
+	large := []byte("0123456789")
+	assert.Nil(t, c.Set([]byte("large"), large, 0))
+	assert.Equal(t, 1+1+3, inner.Stats().Keys)
+	value, err = c.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, large, value)
+	assert.True(t, c.Has([]byte("large")))
+
+	// Overwriting a chunked value removes the previous chunks.
+	assert.Nil(t, c.Set([]byte("large"), []byte("abcdefgh"), 0))
+	assert.Equal(t, 1+1+2, inner.Stats().Keys)
+	assert.Nil(t, c.Set([]byte("large"), []byte("ab"), 0))
+	assert.Equal(t, 2, inner.Stats().Keys)
+	value, err = c.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ab"), value)
+
+	assert.Nil(t, c.Set([]byte("large"), large, 0))
+	assert.Nil(t, c.Delete([]byte("large")))
+	assert.False(t, c.Has([]byte("large")))
+	assert.Equal(t, 1, inner.Stats().Keys)
+
+	_, err = c.Get([]byte("missing"))
+	assert.NotNil(t, err)
+}
+
+func TestChunkedMissingChunk(t *testing.T) {
+	inner := New()
+	c := Chunked(inner, ChunkedOptions{ChunkSize: 4})
+
+	assert.Nil(t, c.Set([]byte("large"), []byte("0123456789"), 0))
+	m, ok := c.manifest([]byte("large"))
+	assert.True(t, ok)
+	assert.Nil(t, inner.Delete(c.chunkKey([]byte("large"), m.gen, 1)))
+
+	_, err := c.Get([]byte("large"))
+	assert.NotNil(t, err)
+}
+
+func TestChunkedTouch(t *testing.T) {
+	inner := New()
+	c := Chunked(inner, ChunkedOptions{ChunkSize: 4})
+
+	assert.Nil(t, c.Set([]byte("large"), []byte("0123456789"), 20*time.Millisecond))
+	assert.Nil(t, c.Touch([]byte("large"), 0))
+	time.Sleep(40 * time.Millisecond)
+
+	value, err := c.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("0123456789"), value)
+
+	assert.NotNil(t, Chunked(struct{ Cacher }{inner}, ChunkedOptions{}).Touch([]byte("large"), 0))
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+type OpKind int
+
+const (
+	OpRead OpKind = iota
+	OpWrite
+)
+
+// Op is one completed (or indeterminate) operation in a history. Call and
+// Return are monotonic timestamps in nanoseconds.
+type Op struct {
+	Client int
+	Kind   OpKind
+	Key    string
+	Value  string
+	Found  bool
+	Call   int64
+	Return int64
+
+	// Unknown marks a write whose outcome we never learned, e.g. because the
+	// connection was cut before the response arrived. It may or may not have
+	// taken effect, at any point after its call.
+	Unknown bool
+}
+
+func (op Op) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "client %d ", op.Client)
+	switch op.Kind {
+	case OpRead:
+		if op.Found {
+			fmt.Fprintf(&b, "GET %s -> %s", op.Key, op.Value)
+		} else {
+			fmt.Fprintf(&b, "GET %s -> <not found>", op.Key)
+		}
+	case OpWrite:
+		fmt.Fprintf(&b, "SET %s %s", op.Key, op.Value)
+		if op.Unknown {
+			b.WriteString(" (unknown outcome)")
+		}
+	}
+	ret := "?"
+	if op.Return != math.MaxInt64 {
+		ret = fmt.Sprint(op.Return)
+	}
+	fmt.Fprintf(&b, " [%d, %s]", op.Call, ret)
+	return b.String()
+}
+
+// Model names a consistency model a history can be checked against.
+type Model string
+
+const (
+	// Linearizable requires every key to behave like a single register where
+	// each operation takes effect atomically between its call and return.
+	Linearizable Model = "linearizable"
+
+	// Eventual only requires that reads never observe a value that was not
+	// written before the read completed. This is what followers advertise.
+	Eventual Model = "eventual"
+)
+
+// Violation describes a key whose history does not satisfy the model.
+type Violation struct {
+	Key string
+	Ops []Op
+}
+
+// Check partitions the history by key and checks every key against model.
+func Check(model Model, history []Op) []Violation {
+	byKey := make(map[string][]Op)
+	for _, op := range history {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var violations []Violation
+	for _, key := range keys {
+		ops := byKey[key]
+		var ok bool
+		switch model {
+		case Eventual:
+			ok = checkEventual(ops)
+		default:
+			ok = checkLinearizable(ops)
+		}
+		if !ok {
+			sort.Slice(ops, func(i, j int) bool { return ops[i].Call < ops[j].Call })
+			violations = append(violations, Violation{Key: key, Ops: ops})
+		}
+	}
+	return violations
+}
+
+func checkEventual(ops []Op) bool {
+	for _, read := range ops {
+		if read.Kind != OpRead || !read.Found {
+			continue
+		}
+		written := false
+		for _, write := range ops {
+			if write.Kind == OpWrite && write.Value == read.Value && write.Call <= read.Return {
+				written = true
+				break
+			}
+		}
+		if !written {
+			return false
+		}
+	}
+	return true
+}
+
+type register struct {
+	value  string
+	exists bool
+}
+
+func (r register) step(op Op) (register, bool) {
+	switch op.Kind {
+	case OpWrite:
+		return register{value: op.Value, exists: true}, true
+	default:
+		if op.Found != r.exists {
+			return r, false
+		}
+		return r, !op.Found || op.Value == r.value
+	}
+}
+
+type event struct {
+	op         int
+	isReturn   bool
+	time       int64
+	match      *event
+	prev, next *event
+}
+
+func (e *event) lift() {
+	e.prev.next = e.next
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+func (e *event) unlift() {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	if e.next != nil {
+		e.next.prev = e
+	}
+}
+
+// checkLinearizable runs the Wing & Gong search with memoization of already
+// explored (linearized set, register state) pairs, as described by Lowe in
+// "Testing for linearizability".
+func checkLinearizable(ops []Op) bool {
+	events := make([]*event, 0, len(ops)*2)
+	for i, op := range ops {
+		ret := op.Return
+		if op.Unknown {
+			ret = math.MaxInt64
+		}
+		call := &event{op: i, time: op.Call}
+		retEv := &event{op: i, isReturn: true, time: ret}
+		call.match, retEv.match = retEv, call
+		events = append(events, call, retEv)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].time != events[j].time {
+			return events[i].time < events[j].time
+		}
+		// Calls sort before returns at the same instant so that the
+		// operations are treated as concurrent.
+		return !events[i].isReturn && events[j].isReturn
+	})
+
+	head := &event{}
+	prev := head
+	for _, e := range events {
+		prev.next, e.prev = e, prev
+		prev = e
+	}
+
+	type frame struct {
+		call  *event
+		state register
+	}
+
+	var (
+		state      register
+		stack      []frame
+		linearized = make([]uint64, (len(ops)+63)/64)
+		seen       = make(map[string]struct{})
+		entry      = head.next
+	)
+
+	for head.next != nil {
+		if entry.isReturn && ops[entry.op].Unknown {
+			// Only calls of indeterminate writes are left in front of us, and
+			// those are allowed to never take effect.
+			return true
+		}
+
+		if !entry.isReturn {
+			next, ok := state.step(ops[entry.op])
+			if ok {
+				linearized[entry.op/64] |= 1 << (entry.op % 64)
+				key := memoKey(linearized, next)
+				if _, explored := seen[key]; !explored {
+					seen[key] = struct{}{}
+					stack = append(stack, frame{call: entry, state: state})
+					state = next
+					entry.lift()
+					entry = head.next
+					continue
+				}
+				linearized[entry.op/64] &^= 1 << (entry.op % 64)
+			}
+			entry = entry.next
+			continue
+		}
+
+		// We hit the return of an operation we could not linearize before it
+		// completed, so backtrack.
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		linearized[top.call.op/64] &^= 1 << (top.call.op % 64)
+		top.call.unlift()
+		entry = top.call.next
+	}
+
+	return true
+}
+
+func memoKey(linearized []uint64, state register) string {
+	var b strings.Builder
+	for _, word := range linearized {
+		fmt.Fprintf(&b, "%x.", word)
+	}
+	if state.exists {
+		b.WriteString("=")
+		b.WriteString(state.value)
+	}
+	return b.String()
+}
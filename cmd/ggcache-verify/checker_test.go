@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func write(client int, value string, call, ret int64) Op {
+	return Op{Client: client, Kind: OpWrite, Key: "k", Value: value, Call: call, Return: ret}
+}
+
+func read(client int, value string, call, ret int64) Op {
+	return Op{Client: client, Kind: OpRead, Key: "k", Value: value, Found: len(value) != 0, Call: call, Return: ret}
+}
+
+func TestCheckLinearizableSequential(t *testing.T) {
+	history := []Op{
+		read(0, "", 0, 1),
+		write(0, "a", 2, 3),
+		read(1, "a", 4, 5),
+		write(1, "b", 6, 7),
+		read(0, "b", 8, 9),
+	}
+	assert.Empty(t, Check(Linearizable, history))
+}
+
+func TestCheckLinearizableConcurrent(t *testing.T) {
+	// The two writes overlap, so either order is allowed as long as every
+	// reader agrees with one of them.
+	history := []Op{
+		write(0, "a", 0, 10),
+		write(1, "b", 1, 9),
+		read(2, "a", 11, 12),
+		read(3, "a", 13, 14),
+	}
+	assert.Empty(t, Check(Linearizable, history))
+}
+
+func TestCheckLinearizableStaleRead(t *testing.T) {
+	history := []Op{
+		write(0, "a", 0, 1),
+		write(0, "b", 2, 3),
+		read(1, "a", 4, 5),
+	}
+	violations := Check(Linearizable, history)
+	assert.Len(t, violations, 1)
+	assert.Equal(t, "k", violations[0].Key)
+}
+
+func TestCheckLinearizableUnknownWrite(t *testing.T) {
+	lost := write(0, "b", 2, math.MaxInt64)
+	lost.Unknown = true
+
+	history := []Op{
+		write(0, "a", 0, 1),
+		lost,
+		read(1, "a", 3, 4),
+		read(1, "b", 5, 6),
+	}
+	assert.Empty(t, Check(Linearizable, history))
+
+	// An unknown write may be dropped, but then nobody may observe it.
+	history = []Op{
+		write(0, "a", 0, 1),
+		lost,
+		read(1, "b", 3, 4),
+		read(1, "a", 5, 6),
+	}
+	assert.Len(t, Check(Linearizable, history), 1)
+}
+
+func TestCheckEventual(t *testing.T) {
+	history := []Op{
+		write(0, "a", 0, 1),
+		write(0, "b", 2, 3),
+		read(1, "a", 4, 5),
+	}
+	assert.Empty(t, Check(Eventual, history))
+
+	history = append(history, read(1, "c", 6, 7))
+	assert.Len(t, Check(Eventual, history), 1)
+}
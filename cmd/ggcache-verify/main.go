@@ -0,0 +1,227 @@
+// Command ggcache-verify runs concurrent Get/Set histories against a ggcache
+// cluster while injecting network faults, and checks the recorded history
+// against the consistency model the cluster advertises: linearizable reads
+// and writes on the leader, eventually consistent reads on followers.
+//
+//	ggcache-verify --leader :3000 --followers :4000,:5000 --drop-rate 0.01
+//
+// The protocol has no compare-and-set, so histories are built from Get and
+// Set only. The exit code is 1 if any violation was found.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+func main() {
+	var (
+		leaderAddr = flag.String("leader", ":3000", "address of the leader")
+		followers  = flag.String("followers", "", "comma separated follower addresses to read from")
+		clients    = flag.Int("clients", 4, "number of concurrent clients per node")
+		ops        = flag.Int("ops", 200, "operations per client")
+		keys       = flag.Int("keys", 8, "number of distinct keys")
+		dropRate   = flag.Float64("drop-rate", 0.005, "probability of cutting a connection per forwarded chunk")
+		maxDelay   = flag.Duration("max-delay", time.Millisecond, "maximum delay injected per forwarded chunk")
+		seed       = flag.Int64("seed", time.Now().UnixNano(), "random seed")
+	)
+	flag.Parse()
+
+	log.Printf("seed %d", *seed)
+
+	run := &run{
+		prefix: fmt.Sprintf("verify:%d:", *seed),
+		keys:   *keys,
+		start:  time.Now(),
+	}
+
+	var proxies []*faultProxy
+	defer func() {
+		for _, p := range proxies {
+			_ = p.Close()
+		}
+	}()
+
+	newProxy := func(addr string) *faultProxy {
+		p, err := newFaultProxy(addr, *dropRate, *maxDelay, *seed+int64(len(proxies)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		proxies = append(proxies, p)
+		return p
+	}
+
+	leader := newProxy(*leaderAddr)
+
+	var (
+		wg       sync.WaitGroup
+		leaderCh = make(chan []Op, *clients)
+		eventual = make(chan []Op, 1024)
+	)
+	for i := 0; i < *clients; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			leaderCh <- run.worker(id, leader.Addr(), true, *ops, *seed+int64(id))
+		}(i)
+	}
+
+	if len(*followers) != 0 {
+		for n, addr := range strings.Split(*followers, ",") {
+			p := newProxy(addr)
+			for i := 0; i < *clients; i++ {
+				wg.Add(1)
+				id := (n+1)*1000 + i
+				go func() {
+					defer wg.Done()
+					eventual <- run.worker(id, p.Addr(), false, *ops, *seed+int64(id))
+				}()
+			}
+		}
+	}
+
+	wg.Wait()
+	close(leaderCh)
+	close(eventual)
+
+	var leaderHistory, allHistory []Op
+	for h := range leaderCh {
+		leaderHistory = append(leaderHistory, h...)
+	}
+	allHistory = append(allHistory, leaderHistory...)
+	for h := range eventual {
+		allHistory = append(allHistory, h...)
+	}
+
+	drops := 0
+	for _, p := range proxies {
+		drops += p.Drops()
+	}
+	log.Printf("recorded %d operations (%d on the leader), injected %d connection drops", len(allHistory), len(leaderHistory), drops)
+
+	violations := Check(Linearizable, leaderHistory)
+	violations = append(violations, Check(Eventual, allHistory)...)
+	if len(violations) == 0 {
+		log.Println("history is consistent")
+		return
+	}
+
+	for _, v := range violations {
+		fmt.Printf("violation on key %s:\n", v.Key)
+		for _, op := range v.Ops {
+			fmt.Printf("  %s\n", op)
+		}
+	}
+	os.Exit(1)
+}
+
+type run struct {
+	prefix string
+	keys   int
+	start  time.Time
+}
+
+func (r *run) now() int64 {
+	return int64(time.Since(r.start))
+}
+
+// worker performs ops random operations against addr. Workers that only read
+// are used against followers, which cannot accept writes from clients.
+func (r *run) worker(id int, addr string, writes bool, ops int, seed int64) []Op {
+	var (
+		rnd     = rand.New(rand.NewSource(seed))
+		history = make([]Op, 0, ops)
+		conn    net.Conn
+	)
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	for i := 0; i < ops; i++ {
+		if conn == nil {
+			var err error
+			if conn, err = net.DialTimeout("tcp", addr, time.Second); err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+		}
+
+		op := Op{
+			Client: id,
+			Key:    fmt.Sprintf("%s%d", r.prefix, rnd.Intn(r.keys)),
+		}
+		if writes && rnd.Intn(2) == 0 {
+			op.Kind = OpWrite
+			op.Value = fmt.Sprintf("%d-%d", id, i)
+		}
+
+		op.Call = r.now()
+		err := r.do(conn, &op)
+		op.Return = r.now()
+
+		if err != nil {
+			_ = conn.Close()
+			conn = nil
+			if op.Kind == OpRead {
+				// A failed read tells us nothing.
+				continue
+			}
+			op.Unknown = true
+			op.Return = math.MaxInt64
+		}
+		history = append(history, op)
+	}
+
+	return history
+}
+
+func (r *run) do(conn net.Conn, op *Op) error {
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	switch op.Kind {
+	case OpWrite:
+		cmd := &proto.CommandSet{Key: []byte(op.Key), Value: []byte(op.Value)}
+		if _, err := conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err := proto.ParseSetResponse(conn)
+		if err != nil {
+			return err
+		}
+		if resp.Status != proto.StatusOK {
+			return fmt.Errorf("set responded with status [%s]", resp.Status)
+		}
+	default:
+		cmd := &proto.CommandGet{Key: []byte(op.Key)}
+		if _, err := conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err := proto.ParseGetResponse(conn)
+		if err != nil {
+			return err
+		}
+		switch resp.Status {
+		case proto.StatusOK:
+			op.Found = true
+			op.Value = string(bytes.Clone(resp.Value))
+		case proto.StatusKeyNotFound:
+		default:
+			return fmt.Errorf("get responded with status [%s]", resp.Status)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// faultProxy sits between the verifier's clients and a node and injects
+// faults: it delays traffic and randomly cuts connections in either
+// direction, which leaves some operations with an unknown outcome.
+type faultProxy struct {
+	target    string
+	dropRate  float64
+	maxDelay  time.Duration
+	ln        net.Listener
+	mu        sync.Mutex
+	rnd       *rand.Rand
+	conns     map[net.Conn]struct{}
+	dropCount int
+}
+
+func newFaultProxy(target string, dropRate float64, maxDelay time.Duration, seed int64) (*faultProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &faultProxy{
+		target:   target,
+		dropRate: dropRate,
+		maxDelay: maxDelay,
+		ln:       ln,
+		rnd:      rand.New(rand.NewSource(seed)),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go p.acceptLoop()
+
+	return p, nil
+}
+
+func (p *faultProxy) Addr() string {
+	return p.ln.Addr().String()
+}
+
+func (p *faultProxy) Drops() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.dropCount
+}
+
+func (p *faultProxy) Close() error {
+	err := p.ln.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+	return err
+}
+
+func (p *faultProxy) acceptLoop() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		upstream, err := net.Dial("tcp", p.target)
+		if err != nil {
+			log.Println("proxy dial error:", err)
+			_ = conn.Close()
+			continue
+		}
+
+		p.mu.Lock()
+		p.conns[conn] = struct{}{}
+		p.conns[upstream] = struct{}{}
+		p.mu.Unlock()
+
+		go p.pipe(conn, upstream)
+		go p.pipe(upstream, conn)
+	}
+}
+
+func (p *faultProxy) pipe(dst, src net.Conn) {
+	defer func() {
+		_ = dst.Close()
+		_ = src.Close()
+
+		p.mu.Lock()
+		delete(p.conns, dst)
+		delete(p.conns, src)
+		p.mu.Unlock()
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			delay, drop := p.roll()
+			time.Sleep(delay)
+			if drop {
+				return
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *faultProxy) roll() (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var delay time.Duration
+	if p.maxDelay > 0 {
+		delay = time.Duration(p.rnd.Int63n(int64(p.maxDelay)))
+	}
+	drop := p.rnd.Float64() < p.dropRate
+	if drop {
+		p.dropCount++
+	}
+	return delay, drop
+}
@@ -0,0 +1,48 @@
+package ggcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrPreconditionFailed is returned by a conditional write whose Condition
+// does not hold.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ETag returns the entity tag of a value: a hash of its bytes, so a client
+// holding a value can compute it without asking the cache. It is unquoted;
+// HTTP integrations send it as a strong ETag in double quotes.
+func ETag(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:16])
+}
+
+// Condition restricts a write to the current value of a key, with the
+// semantics of the HTTP If-Match and If-None-Match headers. An empty
+// Condition always holds.
+type Condition struct {
+	// IfMatch requires the key to be present with a value whose ETag is
+	// IfMatch, or present with any value if it is "*".
+	IfMatch string
+
+	// IfNoneMatch requires the key to be missing or have a value whose ETag
+	// is not IfNoneMatch, or to be missing if it is "*".
+	IfNoneMatch string
+}
+
+// Holds reports whether the condition holds for the current value of a key.
+// ok reports whether the key is present.
+func (c Condition) Holds(current []byte, ok bool) bool {
+	if len(c.IfMatch) != 0 {
+		if !ok || (c.IfMatch != "*" && c.IfMatch != ETag(current)) {
+			return false
+		}
+	}
+	if len(c.IfNoneMatch) != 0 && ok {
+		if c.IfNoneMatch == "*" || c.IfNoneMatch == ETag(current) {
+			return false
+		}
+	}
+	return true
+}
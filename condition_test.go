@@ -0,0 +1,71 @@
+package ggcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionHolds(t *testing.T) {
+	foo := ETag([]byte("foo"))
+	assert.Len(t, foo, 32)
+	assert.NotEqual(t, foo, ETag([]byte("bar")))
+
+	tests := []struct {
+		cond    Condition
+		present bool
+		want    bool
+	}{
+		{Condition{}, false, true},
+		{Condition{}, true, true},
+		{Condition{IfMatch: "*"}, false, false},
+		{Condition{IfMatch: "*"}, true, true},
+		{Condition{IfMatch: foo}, true, true},
+		{Condition{IfMatch: ETag([]byte("bar"))}, true, false},
+		{Condition{IfNoneMatch: "*"}, false, true},
+		{Condition{IfNoneMatch: "*"}, true, false},
+		{Condition{IfNoneMatch: foo}, true, false},
+		{Condition{IfNoneMatch: ETag([]byte("bar"))}, true, true},
+	}
+	for _, tt := range tests {
+		var current []byte
+		if tt.present {
+			current = []byte("foo")
+		}
+		assert.Equal(t, tt.want, tt.cond.Holds(current, tt.present), "%+v present=%v", tt.cond, tt.present)
+	}
+}
+
+func TestCache_SetIf(t *testing.T) {
+	c := New()
+	key := []byte("foo")
+
+	assert.Nil(t, c.SetIf(key, []byte("v1"), 0, Condition{IfNoneMatch: "*"}))
+	assert.Equal(t, ErrPreconditionFailed, c.SetIf(key, []byte("v2"), 0, Condition{IfNoneMatch: "*"}))
+
+	// An optimistic update from a client holding v1.
+	etag := ETag([]byte("v1"))
+	assert.Nil(t, c.SetIf(key, []byte("v2"), 0, Condition{IfMatch: etag}))
+	assert.Equal(t, ErrPreconditionFailed, c.SetIf(key, []byte("v3"), 0, Condition{IfMatch: etag}))
+
+	value, err := c.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+	assert.Equal(t, uint64(2), c.Stats().Sets)
+}
+
+func TestCache_DeleteIf(t *testing.T) {
+	c := New()
+	key := []byte("lock")
+	assert.Nil(t, c.Set(key, []byte("owner-1"), 0))
+
+	// Only the owner of the lock releases it.
+	assert.Equal(t, ErrPreconditionFailed, c.DeleteIf(key, Condition{IfMatch: ETag([]byte("owner-2"))}))
+	assert.Nil(t, c.DeleteIf(key, Condition{IfMatch: ETag([]byte("owner-1"))}))
+	_, err := c.Get(key)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// A missing key does not match.
+	assert.Equal(t, ErrPreconditionFailed, c.DeleteIf(key, Condition{IfMatch: "*"}))
+	assert.Equal(t, uint64(1), c.Stats().Deletes)
+}
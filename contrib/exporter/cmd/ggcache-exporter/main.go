@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/anthdm/ggcache/contrib/exporter"
+	"github.com/anthdm/ggcache/example/client"
+)
+
+func main() {
+	var (
+		addr       = flag.String("addr", ":3000", "address of the ggcache node to export")
+		listenAddr = flag.String("listenaddr", ":9390", "listen address of the metrics endpoint")
+	)
+	flag.Parse()
+
+	e := exporter.New(*addr, client.Options{})
+	defer e.Close()
+
+	http.Handle("/metrics", e)
+
+	log.Printf("exporting metrics of [%s] on [%s]\n", *addr, *listenAddr)
+	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+}
@@ -0,0 +1,100 @@
+// Package exporter exposes the metrics of a remote ggcache node in the
+// Prometheus text format. It reads them with the STATS command on every
+// scrape, so it works against any node without enabling anything on it.
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anthdm/ggcache/example/client"
+)
+
+// Exporter is an http.Handler serving the metrics of the node at Addr.
+type Exporter struct {
+	addr      string
+	opts      client.Options
+	namespace string
+
+	mu     sync.Mutex
+	client *client.Client
+}
+
+// New returns an exporter for the node at addr. Metric names are prefixed
+// with "ggcache_".
+func New(addr string, opts client.Options) *Exporter {
+	return &Exporter{
+		addr:      addr,
+		opts:      opts,
+		namespace: "ggcache",
+	}
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats, err := e.scrape(r.Context())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	up := 1
+	if err != nil {
+		up = 0
+	}
+	writeMetric(w, e.namespace+"_up", "gauge", int64(up))
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		typ := "gauge"
+		if strings.HasSuffix(name, "_total") {
+			typ = "counter"
+		}
+		writeMetric(w, e.namespace+"_"+name, typ, stats[name])
+	}
+}
+
+// Close closes the connection to the node, if any.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client == nil {
+		return nil
+	}
+	err := e.client.Close()
+	e.client = nil
+	return err
+}
+
+func (e *Exporter) scrape(ctx context.Context) (map[string]int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client == nil {
+		c, err := client.New(e.addr, e.opts)
+		if err != nil {
+			return nil, err
+		}
+		e.client = c
+	}
+
+	stats, err := e.client.Stats(ctx)
+	if err != nil {
+		// Reconnect on the next scrape, the connection may be broken.
+		_ = e.client.Close()
+		e.client = nil
+		return nil, err
+	}
+	return stats, nil
+}
+
+func writeMetric(w http.ResponseWriter, name, typ string, value int64) {
+	fmt.Fprintf(w, "# TYPE %s %s\n%s %d\n", name, typ, name, value)
+}
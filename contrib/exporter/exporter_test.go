@@ -0,0 +1,39 @@
+package exporter
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/ggcachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExporter(t *testing.T) {
+	node := ggcachetest.StartNode(t)
+	c := node.Client(t)
+	assert.Nil(t, c.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+	_, _ = c.Get(context.Background(), []byte("foo"))
+
+	e := New(node.Addr, client.Options{})
+	defer e.Close()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	assert.Contains(t, body, "ggcache_up 1\n")
+	assert.Contains(t, body, "# TYPE ggcache_cache_hits_total counter\nggcache_cache_hits_total 1\n")
+	assert.Contains(t, body, "# TYPE ggcache_cache_keys gauge\nggcache_cache_keys 1\n")
+	assert.Contains(t, body, "ggcache_server_is_leader 1\n")
+}
+
+func TestExporterDown(t *testing.T) {
+	e := New("127.0.0.1:1", client.Options{})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "# TYPE ggcache_up gauge\nggcache_up 0\n", rec.Body.String())
+}
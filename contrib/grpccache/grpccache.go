@@ -0,0 +1,157 @@
+// Package grpccache caches the responses of deterministic unary gRPC calls in
+// ggcache, keyed by the full method name and a hash of the request.
+//
+// The package does not depend on grpc itself. Interceptor is generic over the
+// invoker type and instantiates to grpc.UnaryClientInterceptor:
+//
+//	c := grpccache.New(store, grpccache.Options{
+//		Codec:   encoding.GetCodec("proto"),
+//		Methods: map[string]time.Duration{"/catalog.Catalog/GetProduct": time.Minute},
+//	})
+//	conn, err := grpc.Dial(addr, grpc.WithUnaryInterceptor(grpccache.Interceptor[grpc.UnaryInvoker](c)))
+package grpccache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Store is the subset of cache operations the interceptor needs. It is
+// implemented by *client.Client.
+type Store interface {
+	Get(ctx context.Context, key []byte) ([]byte, error)
+	Set(ctx context.Context, key, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key []byte) error
+}
+
+// Codec serializes requests for hashing and replies for storage. The codec
+// registered by grpc under "proto" satisfies it.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// Target identifies the cached response of one call.
+type Target struct {
+	Method  string
+	Request any
+}
+
+type Options struct {
+	// Methods lists the full method names whose responses are cached, with
+	// their TTL. A zero TTL falls back to DefaultTTL. Calls to any other
+	// method pass through untouched.
+	Methods    map[string]time.Duration
+	DefaultTTL time.Duration
+
+	// Codec defaults to JSON, which is fine for plain structs but should be
+	// replaced by the proto codec for generated messages.
+	Codec     Codec
+	KeyPrefix string
+
+	// Invalidate is called after every successful call that was not served
+	// from the cache. The cached responses of the returned targets are
+	// removed, e.g. an UpdateProduct call returning the matching GetProduct.
+	Invalidate func(method string, req any) []Target
+}
+
+// Cache holds the configuration shared by the interceptors.
+type Cache struct {
+	store Store
+	opts  Options
+}
+
+func New(store Store, opts Options) *Cache {
+	if opts.Codec == nil {
+		opts.Codec = jsonCodec{}
+	}
+	return &Cache{
+		store: store,
+		opts:  opts,
+	}
+}
+
+// Interceptor returns a unary client interceptor. I is the invoker type,
+// grpc.UnaryInvoker, from which the connection and call option types are
+// inferred.
+func Interceptor[I ~func(context.Context, string, any, any, CC, ...O) error, CC any, O any](c *Cache) func(ctx context.Context, method string, req, reply any, cc CC, invoker I, opts ...O) error {
+	return func(ctx context.Context, method string, req, reply any, cc CC, invoker I, opts ...O) error {
+		return c.Invoke(ctx, method, req, reply, func(ctx context.Context) error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
+
+// Invoke serves the call from the cache if possible and otherwise runs
+// invoke and caches its reply. It is the building block of Interceptor for
+// callers that want to wire it up by hand.
+func (c *Cache) Invoke(ctx context.Context, method string, req, reply any, invoke func(context.Context) error) error {
+	ttl, cached := c.opts.Methods[method]
+	if !cached {
+		if err := invoke(ctx); err != nil {
+			return err
+		}
+		c.invalidateAfter(ctx, method, req)
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = c.opts.DefaultTTL
+	}
+
+	key, err := c.key(method, req)
+	if err != nil {
+		return invoke(ctx)
+	}
+
+	if b, err := c.store.Get(ctx, key); err == nil {
+		if err := c.opts.Codec.Unmarshal(b, reply); err == nil {
+			return nil
+		}
+	}
+
+	if err := invoke(ctx); err != nil {
+		return err
+	}
+
+	if b, err := c.opts.Codec.Marshal(reply); err == nil {
+		_ = c.store.Set(ctx, key, b, ttl)
+	}
+	c.invalidateAfter(ctx, method, req)
+
+	return nil
+}
+
+// Invalidate removes the cached response of a call.
+func (c *Cache) Invalidate(ctx context.Context, method string, req any) error {
+	key, err := c.key(method, req)
+	if err != nil {
+		return err
+	}
+	return c.store.Delete(ctx, key)
+}
+
+func (c *Cache) invalidateAfter(ctx context.Context, method string, req any) {
+	if c.opts.Invalidate == nil {
+		return
+	}
+	for _, target := range c.opts.Invalidate(method, req) {
+		_ = c.Invalidate(ctx, target.Method, target.Request)
+	}
+}
+
+func (c *Cache) key(method string, req any) ([]byte, error) {
+	b, err := c.opts.Codec.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	return []byte(c.opts.KeyPrefix + method + ":" + hex.EncodeToString(sum[:])), nil
+}
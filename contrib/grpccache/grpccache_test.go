@@ -0,0 +1,77 @@
+package grpccache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/ggcachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// These mirror the grpc types so the test proves that Interceptor
+// instantiates to a grpc.UnaryClientInterceptor.
+type (
+	clientConn         struct{}
+	callOption         interface{}
+	unaryInvoker       func(ctx context.Context, method string, req, reply interface{}, cc *clientConn, opts ...callOption) error
+	unaryInterceptorFn func(ctx context.Context, method string, req, reply interface{}, cc *clientConn, invoker unaryInvoker, opts ...callOption) error
+)
+
+type getProduct struct {
+	ID int
+}
+
+type product struct {
+	ID   int
+	Name string
+}
+
+func TestInterceptor(t *testing.T) {
+	store := ggcachetest.StartNode(t).Client(t)
+
+	c := New(store, Options{
+		Methods: map[string]time.Duration{"/catalog/GetProduct": time.Minute},
+		Invalidate: func(method string, req any) []Target {
+			if method == "/catalog/UpdateProduct" {
+				return []Target{{Method: "/catalog/GetProduct", Request: &getProduct{ID: req.(*product).ID}}}
+			}
+			return nil
+		},
+	})
+
+	var interceptor unaryInterceptorFn = Interceptor[unaryInvoker](c)
+
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *clientConn, opts ...callOption) error {
+		calls++
+		if p, ok := reply.(*product); ok {
+			p.ID = req.(*getProduct).ID
+			p.Name = "gopher"
+		}
+		return nil
+	}
+
+	get := func(id int) *product {
+		reply := &product{}
+		err := interceptor(context.Background(), "/catalog/GetProduct", &getProduct{ID: id}, reply, &clientConn{}, invoker)
+		assert.Nil(t, err)
+		return reply
+	}
+
+	assert.Equal(t, &product{ID: 1, Name: "gopher"}, get(1))
+	assert.Equal(t, &product{ID: 1, Name: "gopher"}, get(1))
+	assert.Equal(t, 1, calls)
+
+	get(2)
+	assert.Equal(t, 2, calls)
+
+	// Uncached methods always go through and may invalidate cached ones.
+	err := interceptor(context.Background(), "/catalog/UpdateProduct", &product{ID: 1}, &struct{}{}, &clientConn{}, invoker)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+
+	get(1)
+	get(2)
+	assert.Equal(t, 4, calls)
+}
@@ -0,0 +1,252 @@
+// Package httpcache provides net/http middleware that caches responses to
+// idempotent requests in ggcache.
+//
+//	handler = httpcache.Middleware(c, httpcache.Options{})(handler)
+//
+// where c is either a *client.Client talking to a remote node or any
+// ggcache.Cacher wrapped with FromCacher. Responses are cached for as long
+// as their Cache-Control header allows.
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// Store is the subset of cache operations the middleware needs. It is
+// implemented by *client.Client.
+type Store interface {
+	Get(ctx context.Context, key []byte) ([]byte, error)
+	Set(ctx context.Context, key, value []byte, ttl time.Duration) error
+}
+
+// FromCacher adapts an in-process Cacher to a Store.
+func FromCacher(c ggcache.Cacher) Store {
+	return cacherStore{c}
+}
+
+type cacherStore struct {
+	c ggcache.Cacher
+}
+
+func (s cacherStore) Get(_ context.Context, key []byte) ([]byte, error) {
+	return s.c.Get(key)
+}
+
+func (s cacherStore) Set(_ context.Context, key, value []byte, ttl time.Duration) error {
+	return s.c.Set(key, value, ttl)
+}
+
+// Options configures the middleware. The zero value is ready to use.
+type Options struct {
+	// KeyPrefix is prepended to every cache key.
+	KeyPrefix string
+
+	// Vary lists request headers whose values become part of the cache key,
+	// e.g. Accept-Encoding or Authorization.
+	Vary []string
+
+	// DefaultTTL is used for cacheable responses that carry no max-age. If
+	// zero, such responses are not cached.
+	DefaultTTL time.Duration
+
+	// MaxBodySize is the largest response body that is cached, 1MB if zero.
+	MaxBodySize int
+
+	// FailurePolicy is what the middleware does when the store fails rather
+	// than misses: pass the request to the handler with ggcache.FailOpen, the
+	// default, or answer 503 Service Unavailable with ggcache.FailClosed.
+	FailurePolicy ggcache.FailurePolicy
+}
+
+// Middleware returns a middleware that serves GET and HEAD requests from the
+// store when possible and caches successful responses otherwise.
+func Middleware(store Store, opts Options) func(http.Handler) http.Handler {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (r.Method != http.MethodGet && r.Method != http.MethodHead) || hasDirective(r.Header, "no-store") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := opts.key(r)
+
+			// A request with no-cache wants a fresh response, which we can
+			// still store for the next client.
+			if !hasDirective(r.Header, "no-cache") {
+				b, err := store.Get(r.Context(), key)
+				if err == nil {
+					if resp, err := decode(b); err == nil {
+						resp.write(w)
+						return
+					}
+				} else if opts.FailurePolicy == ggcache.FailClosed && !errors.Is(err, ggcache.ErrKeyNotFound) {
+					http.Error(w, "cache unavailable", http.StatusServiceUnavailable)
+					return
+				}
+			}
+
+			rec := &recorder{ResponseWriter: w, status: http.StatusOK, max: opts.MaxBodySize}
+			next.ServeHTTP(rec, r)
+
+			ttl, ok := cacheTTL(rec.status, w.Header(), opts.DefaultTTL)
+			if !ok || rec.overflow {
+				return
+			}
+			resp := &response{
+				Status: rec.status,
+				Header: w.Header().Clone(),
+				Body:   rec.body.Bytes(),
+				Stored: time.Now(),
+			}
+			if b, err := resp.encode(); err == nil {
+				_ = store.Set(r.Context(), key, b, ttl)
+			}
+		})
+	}
+}
+
+func (o Options) key(r *http.Request) []byte {
+	var b strings.Builder
+	b.WriteString(o.KeyPrefix)
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.String())
+	for _, name := range o.Vary {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return []byte(b.String())
+}
+
+// cacheableStatus are the status codes that are cacheable by default
+// according to RFC 9110 that make sense for a shared response cache.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// cacheTTL decides if a response may be stored and for how long, based on
+// its Cache-Control header. s-maxage takes precedence over max-age since
+// this is a shared cache.
+func cacheTTL(status int, header http.Header, defaultTTL time.Duration) (time.Duration, bool) {
+	if !cacheableStatus[status] || len(header.Values("Set-Cookie")) != 0 {
+		return 0, false
+	}
+
+	directives := parseCacheControl(header)
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[d]; ok {
+			return 0, false
+		}
+	}
+
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[d]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs <= 0 {
+				return 0, false
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	if defaultTTL > 0 {
+		return defaultTTL, true
+	}
+	return 0, false
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+func hasDirective(header http.Header, directive string) bool {
+	_, ok := parseCacheControl(header)[directive]
+	return ok
+}
+
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	max         int
+	overflow    bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if !r.overflow {
+		if r.body.Len()+len(b) > r.max {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+type response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	Stored time.Time
+}
+
+func (r *response) encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(r)
+	return buf.Bytes(), err
+}
+
+func decode(b []byte) (*response, error) {
+	resp := &response{}
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(resp)
+	return resp, err
+}
+
+func (r *response) write(w http.ResponseWriter) {
+	header := w.Header()
+	for name, values := range r.Header {
+		header[name] = values
+	}
+	header.Set("Age", strconv.Itoa(int(time.Since(r.Stored).Seconds())))
+	header.Set("X-Cache", "HIT")
+	w.WriteHeader(r.Status)
+	_, _ = w.Write(r.Body)
+}
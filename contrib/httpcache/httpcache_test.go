@@ -0,0 +1,128 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/ggcachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func countingHandler(calls *int, cacheControl string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if len(cacheControl) != 0 {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		_, _ = w.Write([]byte("hello " + r.Header.Get("Accept-Language")))
+	})
+}
+
+func do(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareCachesWithMaxAge(t *testing.T) {
+	var calls int
+	h := Middleware(FromCacher(ggcache.New()), Options{})(countingHandler(&calls, "public, max-age=60"))
+
+	first := do(h, http.MethodGet, "/foo", nil)
+	second := do(h, http.MethodGet, "/foo", nil)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "hello ", second.Body.String())
+	assert.Equal(t, "", first.Header().Get("X-Cache"))
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Equal(t, "public, max-age=60", second.Header().Get("Cache-Control"))
+
+	// A different URL is a different entry.
+	do(h, http.MethodGet, "/bar", nil)
+	assert.Equal(t, 2, calls)
+}
+
+func TestMiddlewareSkipsUncacheable(t *testing.T) {
+	for _, cacheControl := range []string{"", "private, max-age=60", "no-store", "max-age=0"} {
+		var calls int
+		h := Middleware(FromCacher(ggcache.New()), Options{})(countingHandler(&calls, cacheControl))
+
+		do(h, http.MethodGet, "/foo", nil)
+		do(h, http.MethodGet, "/foo", nil)
+		assert.Equal(t, 2, calls, cacheControl)
+	}
+
+	var calls int
+	h := Middleware(FromCacher(ggcache.New()), Options{})(countingHandler(&calls, "max-age=60"))
+	do(h, http.MethodPost, "/foo", nil)
+	do(h, http.MethodPost, "/foo", nil)
+	assert.Equal(t, 2, calls)
+}
+
+func TestMiddlewareVary(t *testing.T) {
+	var calls int
+	h := Middleware(FromCacher(ggcache.New()), Options{Vary: []string{"Accept-Language"}})(countingHandler(&calls, "max-age=60"))
+
+	en := do(h, http.MethodGet, "/foo", http.Header{"Accept-Language": {"en"}})
+	de := do(h, http.MethodGet, "/foo", http.Header{"Accept-Language": {"de"}})
+	enAgain := do(h, http.MethodGet, "/foo", http.Header{"Accept-Language": {"en"}})
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "hello en", en.Body.String())
+	assert.Equal(t, "hello de", de.Body.String())
+	assert.Equal(t, "hello en", enAgain.Body.String())
+}
+
+func TestMiddlewareRemoteClientTTL(t *testing.T) {
+	c := ggcachetest.StartNode(t).Client(t)
+
+	var calls int
+	h := Middleware(c, Options{DefaultTTL: 50 * time.Millisecond})(countingHandler(&calls, ""))
+
+	do(h, http.MethodGet, "/foo", nil)
+	do(h, http.MethodGet, "/foo", nil)
+	assert.Equal(t, 1, calls)
+
+	time.Sleep(100 * time.Millisecond)
+	do(h, http.MethodGet, "/foo", nil)
+	assert.Equal(t, 2, calls)
+}
+
+// downStore fails every operation, like a cache node that is unreachable.
+type downStore struct{}
+
+func (downStore) Get(context.Context, []byte) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (downStore) Set(context.Context, []byte, []byte, time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func TestMiddlewareFailurePolicy(t *testing.T) {
+	var calls int
+	h := Middleware(downStore{}, Options{})(countingHandler(&calls, "max-age=60"))
+	rec := do(h, http.MethodGet, "/foo", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, calls)
+
+	h = Middleware(downStore{}, Options{FailurePolicy: ggcache.FailClosed})(countingHandler(&calls, "max-age=60"))
+	rec = do(h, http.MethodGet, "/foo", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, 1, calls)
+
+	// A miss is not a failure.
+	h = Middleware(FromCacher(ggcache.New()), Options{FailurePolicy: ggcache.FailClosed})(countingHandler(&calls, "max-age=60"))
+	rec = do(h, http.MethodGet, "/foo", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,160 @@
+// Package sessions stores HTTP sessions in ggcache. Sessions have a sliding
+// expiration: every load pushes the expiration out by the session TTL with a
+// Touch, so active users stay logged in while idle sessions expire.
+//
+//	store := sessions.NewStore(c, sessions.Options{TTL: 30 * time.Minute})
+//
+//	sess, err := store.Get(r)
+//	sess.Values["user_id"] = 42
+//	err = store.Save(w, r, sess)
+//
+// Values are encoded with encoding/gob, so custom types stored in a session
+// must be registered with gob.Register.
+//
+// The package only depends on net/http. Adapters for gorilla/sessions or
+// gin-contrib/sessions can wrap Store without this module depending on them.
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Backend is the subset of the ggcache client the store needs. It is
+// implemented by *client.Client.
+type Backend interface {
+	Get(ctx context.Context, key []byte) ([]byte, error)
+	Set(ctx context.Context, key, value []byte, ttl time.Duration) error
+	Touch(ctx context.Context, key []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key []byte) error
+}
+
+type Options struct {
+	// TTL is the idle time after which a session expires, 30 minutes if zero.
+	TTL time.Duration
+
+	// KeyPrefix is prepended to the session ID to build the cache key,
+	// "session:" if empty.
+	KeyPrefix string
+
+	// Cookie configures the session cookie. Its Name defaults to "session",
+	// and its Value, Expires and MaxAge are managed by the store.
+	Cookie http.Cookie
+}
+
+// Session is the data of one user session.
+type Session struct {
+	ID     string
+	Values map[string]any
+
+	// IsNew is true if the session was created by this request.
+	IsNew bool
+}
+
+type Store struct {
+	backend Backend
+	opts    Options
+}
+
+func NewStore(backend Backend, opts Options) *Store {
+	if opts.TTL <= 0 {
+		opts.TTL = 30 * time.Minute
+	}
+	if len(opts.KeyPrefix) == 0 {
+		opts.KeyPrefix = "session:"
+	}
+	if len(opts.Cookie.Name) == 0 {
+		opts.Cookie.Name = "session"
+	}
+	if len(opts.Cookie.Path) == 0 {
+		opts.Cookie.Path = "/"
+	}
+	opts.Cookie.HttpOnly = true
+	return &Store{
+		backend: backend,
+		opts:    opts,
+	}
+}
+
+// Get returns the session of the request, or a new empty session if the
+// request has none or it expired. Loading a session extends its expiration.
+func (s *Store) Get(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(s.opts.Cookie.Name)
+	if err != nil || len(cookie.Value) == 0 {
+		return s.New()
+	}
+
+	key := s.key(cookie.Value)
+	b, err := s.backend.Get(r.Context(), key)
+	if err != nil {
+		return s.New()
+	}
+
+	sess := &Session{ID: cookie.Value}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&sess.Values); err != nil {
+		return s.New()
+	}
+
+	if err := s.backend.Touch(r.Context(), key, s.opts.TTL); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// New returns a new empty session with a random ID. It is not stored until
+// it is saved.
+func (s *Store) New() (*Session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:     base64.RawURLEncoding.EncodeToString(b),
+		Values: make(map[string]any),
+		IsNew:  true,
+	}, nil
+}
+
+// Save stores the session and sets the session cookie on the response.
+func (s *Store) Save(w http.ResponseWriter, r *http.Request, sess *Session) error {
+	if len(sess.ID) == 0 {
+		return errors.New("sessions: session has no ID")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(sess.Values); err != nil {
+		return err
+	}
+	if err := s.backend.Set(r.Context(), s.key(sess.ID), buf.Bytes(), s.opts.TTL); err != nil {
+		return err
+	}
+
+	cookie := s.opts.Cookie
+	cookie.Value = sess.ID
+	http.SetCookie(w, &cookie)
+
+	return nil
+}
+
+// Destroy deletes the session and expires the session cookie.
+func (s *Store) Destroy(w http.ResponseWriter, r *http.Request, sess *Session) error {
+	if err := s.backend.Delete(r.Context(), s.key(sess.ID)); err != nil {
+		return err
+	}
+
+	cookie := s.opts.Cookie
+	cookie.MaxAge = -1
+	http.SetCookie(w, &cookie)
+
+	return nil
+}
+
+func (s *Store) key(id string) []byte {
+	return []byte(s.opts.KeyPrefix + id)
+}
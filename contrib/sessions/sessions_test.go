@@ -0,0 +1,75 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/ggcachetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func request(cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(ggcachetest.StartNode(t).Client(t), Options{})
+
+	sess, err := store.Get(request(nil))
+	assert.Nil(t, err)
+	assert.True(t, sess.IsNew)
+
+	sess.Values["user_id"] = 42
+	rec := httptest.NewRecorder()
+	assert.Nil(t, store.Save(rec, request(nil), sess))
+
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+
+	loaded, err := store.Get(request(cookies))
+	assert.Nil(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, sess.ID, loaded.ID)
+	assert.Equal(t, 42, loaded.Values["user_id"])
+
+	rec = httptest.NewRecorder()
+	assert.Nil(t, store.Destroy(rec, request(cookies), loaded))
+	assert.Equal(t, -1, rec.Result().Cookies()[0].MaxAge)
+
+	gone, err := store.Get(request(cookies))
+	assert.Nil(t, err)
+	assert.True(t, gone.IsNew)
+}
+
+func TestStoreSlidingTTL(t *testing.T) {
+	ttl := 200 * time.Millisecond
+	store := NewStore(ggcachetest.StartNode(t).Client(t), Options{TTL: ttl})
+
+	sess, err := store.New()
+	assert.Nil(t, err)
+	rec := httptest.NewRecorder()
+	assert.Nil(t, store.Save(rec, request(nil), sess))
+	cookies := rec.Result().Cookies()
+
+	// Keep the session active for longer than its TTL.
+	for i := 0; i < 4; i++ {
+		time.Sleep(ttl / 2)
+		loaded, err := store.Get(request(cookies))
+		assert.Nil(t, err)
+		assert.False(t, loaded.IsNew)
+	}
+
+	// Once idle it expires.
+	time.Sleep(ttl + 50*time.Millisecond)
+	loaded, err := store.Get(request(cookies))
+	assert.Nil(t, err)
+	assert.True(t, loaded.IsNew)
+}
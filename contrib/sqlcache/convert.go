@@ -0,0 +1,117 @@
+package sqlcache
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var errNilDest = errors.New("destination pointer is nil")
+
+// convertAssign is a reduced version of the unexported conversion in
+// database/sql, covering sql.Scanner, *any and pointers to the basic kinds.
+func convertAssign(dest, src any) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(src)
+	}
+
+	switch d := dest.(type) {
+	case *any:
+		if d == nil {
+			return errNilDest
+		}
+		if b, ok := src.([]byte); ok {
+			src = append([]byte(nil), b...)
+		}
+		*d = src
+		return nil
+	case *[]byte:
+		if d == nil {
+			return errNilDest
+		}
+		switch s := src.(type) {
+		case nil:
+			*d = nil
+			return nil
+		case []byte:
+			*d = append([]byte(nil), s...)
+			return nil
+		case string:
+			*d = []byte(s)
+			return nil
+		}
+	case *time.Time:
+		if s, ok := src.(time.Time); ok {
+			*d = s
+			return nil
+		}
+	}
+
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("destination not a non-nil pointer: %T", dest)
+	}
+	if src == nil {
+		return fmt.Errorf("converting NULL to %s is unsupported", rv.Elem().Kind())
+	}
+
+	dv := rv.Elem()
+	text := asString(src)
+
+	switch dv.Kind() {
+	case reflect.String:
+		dv.SetString(text)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(text, 10, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T %q to %s: %w", src, text, dv.Kind(), err)
+		}
+		dv.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(text, 10, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T %q to %s: %w", src, text, dv.Kind(), err)
+		}
+		dv.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("converting %T %q to %s: %w", src, text, dv.Kind(), err)
+		}
+		dv.SetFloat(f)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("converting %T %q to bool: %w", src, text, err)
+		}
+		dv.SetBool(b)
+		return nil
+	}
+
+	return fmt.Errorf("unsupported Scan, storing %T into %T", src, dest)
+}
+
+func asString(src any) string {
+	switch s := src.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case int64:
+		return strconv.FormatInt(s, 10)
+	case float64:
+		return strconv.FormatFloat(s, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(s)
+	case time.Time:
+		return s.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(src)
+}
@@ -0,0 +1,183 @@
+// Package sqlcache provides read-through caching of single-row database/sql
+// queries in ggcache.
+//
+//	loader := ggcache.NewLoader(ggcache.New())
+//
+//	var name string
+//	err := sqlcache.CachedQueryRow(ctx, loader, db, "user:42:name", time.Minute,
+//		"SELECT name FROM users WHERE id = ?", 42).Scan(&name)
+//
+// The row is serialized into the cache on a miss, and concurrent misses for
+// the same key run the query only once.
+package sqlcache
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// Querier is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Row is the result of CachedQueryRow, used like *sql.Row.
+type Row struct {
+	values []value
+	err    error
+}
+
+// CachedQueryRow returns the first row of the query, from the cache if it is
+// there and from the database otherwise. Like QueryRow, errors are deferred
+// until Scan is called, and sql.ErrNoRows is returned if the query selected
+// no rows. Misses are not cached.
+func CachedQueryRow(ctx context.Context, loader *ggcache.Loader, db Querier, key string, ttl time.Duration, query string, args ...any) *Row {
+	b, err := loader.GetOrLoad(ctx, []byte(key), ttl, func(ctx context.Context) ([]byte, error) {
+		values, err := queryRow(ctx, db, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		buf := new(bytes.Buffer)
+		if err := gob.NewEncoder(buf).Encode(values); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		return &Row{err: err}
+	}
+
+	var values []value
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&values); err != nil {
+		return &Row{err: fmt.Errorf("sqlcache: decode cached row [%s]: %w", key, err)}
+	}
+	return &Row{values: values}
+}
+
+// Err returns the error, if any, that was encountered while running the query.
+func (r *Row) Err() error {
+	return r.err
+}
+
+// Scan copies the columns of the row into dest, converting them the same way
+// database/sql does for the common destination types.
+func (r *Row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(dest) != len(r.values) {
+		return fmt.Errorf("sqlcache: expected %d destination arguments in Scan, not %d", len(r.values), len(dest))
+	}
+	for i, d := range dest {
+		if err := convertAssign(d, r.values[i].any()); err != nil {
+			return fmt.Errorf("sqlcache: scan column %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func queryRow(ctx context.Context, db Querier, query string, args ...any) ([]value, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range raw {
+		ptrs[i] = &raw[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+
+	values := make([]value, len(raw))
+	for i, v := range raw {
+		if values[i], err = newValue(v); err != nil {
+			return nil, err
+		}
+	}
+	return values, rows.Close()
+}
+
+type kind uint8
+
+const (
+	kindNull kind = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindBytes
+	kindString
+	kindTime
+)
+
+// value is a serializable driver.Value. It avoids gob's interface encoding,
+// which would require registering every concrete type.
+type value struct {
+	Kind  kind
+	Int   int64
+	Float float64
+	Bool  bool
+	Bytes []byte
+	Str   string
+	Time  time.Time
+}
+
+func newValue(v any) (value, error) {
+	switch v := v.(type) {
+	case nil:
+		return value{Kind: kindNull}, nil
+	case int64:
+		return value{Kind: kindInt, Int: v}, nil
+	case float64:
+		return value{Kind: kindFloat, Float: v}, nil
+	case bool:
+		return value{Kind: kindBool, Bool: v}, nil
+	case []byte:
+		return value{Kind: kindBytes, Bytes: v}, nil
+	case string:
+		return value{Kind: kindString, Str: v}, nil
+	case time.Time:
+		return value{Kind: kindTime, Time: v}, nil
+	default:
+		return value{}, fmt.Errorf("sqlcache: unsupported column type %T", v)
+	}
+}
+
+func (v value) any() any {
+	switch v.Kind {
+	case kindInt:
+		return v.Int
+	case kindFloat:
+		return v.Float
+	case kindBool:
+		return v.Bool
+	case kindBytes:
+		return v.Bytes
+	case kindString:
+		return v.Str
+	case kindTime:
+		return v.Time
+	default:
+		return nil
+	}
+}
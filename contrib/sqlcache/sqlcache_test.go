@@ -0,0 +1,131 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDriver answers every query with the configured rows and counts queries.
+type fakeDriver struct {
+	queries atomic.Int32
+	delay   time.Duration
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (c *fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	c.d.queries.Add(1)
+	time.Sleep(c.d.delay)
+	return &fakeRows{columns: c.d.columns, rows: c.d.rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var driverSeq atomic.Int32
+
+func openFake(t *testing.T, d *fakeDriver) *sql.DB {
+	name := "sqlcache-fake-" + string(rune('a'+driverSeq.Add(1)))
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestCachedQueryRow(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	d := &fakeDriver{
+		columns: []string{"id", "name", "score", "active", "created", "nickname"},
+		rows:    [][]driver.Value{{int64(42), []byte("gopher"), 9.5, true, created, nil}},
+	}
+	db := openFake(t, d)
+	loader := ggcache.NewLoader(ggcache.New())
+
+	for i := 0; i < 2; i++ {
+		var (
+			id       int
+			name     string
+			score    float64
+			active   bool
+			at       time.Time
+			nickname sql.NullString
+		)
+		err := CachedQueryRow(context.Background(), loader, db, "user:42", time.Minute, "SELECT ...").
+			Scan(&id, &name, &score, &active, &at, &nickname)
+		assert.Nil(t, err)
+		assert.Equal(t, 42, id)
+		assert.Equal(t, "gopher", name)
+		assert.Equal(t, 9.5, score)
+		assert.True(t, active)
+		assert.True(t, created.Equal(at))
+		assert.False(t, nickname.Valid)
+	}
+	assert.Equal(t, int32(1), d.queries.Load())
+}
+
+func TestCachedQueryRowNoRows(t *testing.T) {
+	d := &fakeDriver{columns: []string{"id"}}
+	db := openFake(t, d)
+	loader := ggcache.NewLoader(ggcache.New())
+
+	var id int
+	err := CachedQueryRow(context.Background(), loader, db, "user:1", time.Minute, "SELECT ...").Scan(&id)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.False(t, loader.Cacher().Has([]byte("user:1")))
+}
+
+func TestCachedQueryRowSingleflight(t *testing.T) {
+	d := &fakeDriver{
+		delay:   50 * time.Millisecond,
+		columns: []string{"id"},
+		rows:    [][]driver.Value{{int64(1)}},
+	}
+	db := openFake(t, d)
+	loader := ggcache.NewLoader(ggcache.New())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var id int
+			assert.Nil(t, CachedQueryRow(context.Background(), loader, db, "user:1", time.Minute, "SELECT ...").Scan(&id))
+			assert.Equal(t, 1, id)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), d.queries.Load())
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// backupUsage documents the backup subcommand.
+const backupUsage = `usage: ggcache backup verify [-config path] [-restore] [-max-ttl d] file|s3://bucket/path|gs://bucket/path
+
+verify checks that a backup is a whole snapshot: its checksum, its entry
+count, that no key is in it twice and that the expirations are sane. A URL
+naming a prefix verifies the latest backup under it, with the endpoint and
+credentials of the backup section of the config. With -restore the snapshot
+is also loaded into a throwaway in-memory cache, to confirm it restores.`
+
+// defaultMaxBackupTTL is how far in the future an expiration of a backup may
+// be before it is taken for corruption.
+const defaultMaxBackupTTL = 365 * 24 * time.Hour
+
+// backupCommand runs "ggcache backup", which checks backups before they are
+// needed.
+func backupCommand(args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New(backupUsage)
+	}
+
+	fs := flag.NewFlagSet("backup verify", flag.ContinueOnError)
+	var (
+		configFile = fs.String("config", "", "path to a YAML config file with the backup credentials")
+		restore    = fs.Bool("restore", false, "load the snapshot into a throwaway cache")
+		maxTTL     = fs.Duration("max-ttl", defaultMaxBackupTTL, "furthest expiration from now taken as sane")
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(backupUsage)
+	}
+
+	cfg := DefaultConfig()
+	if len(*configFile) != 0 {
+		var err error
+		if cfg, err = LoadConfig(*configFile); err != nil {
+			return err
+		}
+	}
+
+	data, name, err := readBackup(cfg, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	report, err := verifySnapshot(data, time.Now(), *maxTTL, *restore)
+	if err != nil {
+		return fmt.Errorf("backup [%s] is broken: %w", name, err)
+	}
+
+	fmt.Printf("backup [%s] is valid\n", name)
+	fmt.Printf("  entries:    %d\n", report.Entries)
+	fmt.Printf("  bytes:      %d\n", report.Bytes)
+	fmt.Printf("  with ttl:   %d\n", report.WithTTL)
+	fmt.Printf("  expired:    %d\n", report.Expired)
+	if *restore {
+		fmt.Printf("  restored:   %d keys\n", report.Restored)
+	}
+	return nil
+}
+
+// readBackup reads the backup of a local file or, for an s3:// or gs://
+// URL, of the object store, the latest one under a prefix. It returns the
+// snapshot along with the name of the backup.
+func readBackup(cfg *Config, target string) ([]byte, string, error) {
+	if !strings.HasPrefix(target, "s3://") && !strings.HasPrefix(target, "gs://") {
+		data, err := os.ReadFile(target)
+		return data, target, err
+	}
+
+	backups, err := cfg.Backup.Backups(target)
+	if err != nil {
+		return nil, "", err
+	}
+	var name string
+	if strings.HasSuffix(backups.Prefix, ".ggsnap") {
+		name = backups.Prefix
+	}
+	body, name, err := backups.Open(context.Background(), name)
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("download backup [%s]: %w", name, err)
+	}
+	return data, name, nil
+}
+
+// snapshotReport describes a verified snapshot.
+type snapshotReport struct {
+	// Entries is the number of entries, Bytes the size of their keys and
+	// values.
+	Entries int
+	Bytes   int
+	// WithTTL counts the entries that expire, and Expired those of them
+	// that already did, which a restore skips.
+	WithTTL int
+	Expired int
+	// Restored is the number of keys the throwaway cache held after the
+	// restore, zero unless it was asked for.
+	Restored int
+}
+
+// verifySnapshot checks the snapshot as of now and, if restore is set, that
+// it loads into a new cache with every entry that did not expire.
+func verifySnapshot(data []byte, now time.Time, maxTTL time.Duration, restore bool) (*snapshotReport, error) {
+	// ReadSnapshot checks the checksum and that the entry count matches.
+	recs, err := ggcache.ReadSnapshot(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &snapshotReport{Entries: len(recs)}
+	seen := make(map[string]struct{}, len(recs))
+	for _, rec := range recs {
+		if _, ok := seen[string(rec.Key)]; ok {
+			return nil, fmt.Errorf("key [%s] is in the snapshot twice", rec.Key)
+		}
+		seen[string(rec.Key)] = struct{}{}
+		report.Bytes += len(rec.Key) + len(rec.Value)
+
+		switch {
+		case rec.ExpiresAt == 0:
+			continue
+		case rec.ExpiresAt < 0:
+			return nil, fmt.Errorf("key [%s] has the negative expiration %d", rec.Key, rec.ExpiresAt)
+		case maxTTL > 0 && time.Unix(0, rec.ExpiresAt).Sub(now) > maxTTL:
+			return nil, fmt.Errorf("key [%s] expires at %s, more than %s from now", rec.Key, time.Unix(0, rec.ExpiresAt).UTC(), maxTTL)
+		}
+		report.WithTTL++
+		if rec.ExpiresAt <= now.UnixNano() {
+			report.Expired++
+		}
+	}
+
+	if !restore {
+		return report, nil
+	}
+	cache := ggcache.New()
+	if err := cache.Restore(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("restore: %w", err)
+	}
+	// The entries expiring while it restored may or may not be in the cache,
+	// but every other live one must be.
+	report.Restored = cache.Stats().Keys
+	done := time.Now().UnixNano()
+	want := 0
+	for _, rec := range recs {
+		if rec.ExpiresAt == 0 || rec.ExpiresAt > done {
+			want++
+		}
+	}
+	if report.Restored < want {
+		return nil, fmt.Errorf("restore: %d keys restored out of %d live entries", report.Restored, want)
+	}
+	return report, nil
+}
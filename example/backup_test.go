@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifySnapshot(t *testing.T) {
+	cache := ggcache.New()
+	assert.Nil(t, cache.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, cache.Set([]byte("baz"), []byte("qux"), time.Hour))
+	buf := new(bytes.Buffer)
+	assert.Nil(t, cache.Snapshot(buf))
+
+	path := filepath.Join(t.TempDir(), "snapshot.ggsnap")
+	assert.Nil(t, os.WriteFile(path, buf.Bytes(), 0o600))
+	data, name, err := readBackup(DefaultConfig(), path)
+	assert.Nil(t, err)
+	assert.Equal(t, path, name)
+
+	report, err := verifySnapshot(data, time.Now(), defaultMaxBackupTTL, true)
+	assert.Nil(t, err)
+	assert.Equal(t, &snapshotReport{Entries: 2, Bytes: 12, WithTTL: 1, Restored: 2}, report)
+
+	// An expiration too far in the future is taken for corruption.
+	_, err = verifySnapshot(data, time.Now(), time.Minute, false)
+	assert.ErrorContains(t, err, "more than 1m0s from now")
+
+	// So is any flipped byte.
+	corrupt := bytes.Clone(data)
+	corrupt[len(corrupt)/2] ^= 0xff
+	_, err = verifySnapshot(corrupt, time.Now(), defaultMaxBackupTTL, true)
+	assert.ErrorIs(t, err, ggcache.ErrInvalidSnapshot)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// captureUsage documents the capture subcommand.
+const captureUsage = `usage: ggcache capture -admin host:port -id n -frames n [-redact=false] [-file path]
+
+capture records the next frames commands the node reads from the connection
+with the id, as listed on /api/v1/clients, to the file, or to stdout, once
+they are all read or the connection is closed. The values they carry are
+replaced by as many "x" unless -redact=false; auth tokens and cluster
+secrets always are. The file is sent to a test server with ggcache replay.`
+
+// replayUsage documents the replay subcommand.
+const replayUsage = `usage: ggcache replay -addr host:port [-token t] [-timing] [-timeout d] file
+
+replay sends the commands of a capture file to the server, one at a time,
+and prints the status each is answered with. With -timing it waits between
+them as long as the captured connection did. The commands of a session are
+sent without their replication offset, and the captured AUTH commands with
+the token given instead of their redacted one. Replay stops at a SUBSCRIBE,
+after which the connection only carries messages.`
+
+// captureCommand runs "ggcache capture", which records the commands of a
+// connection through the admin API of a running node.
+func captureCommand(args []string) error {
+	fs := flag.NewFlagSet("capture", flag.ContinueOnError)
+	var (
+		admin  = fs.String("admin", "", "admin listen address of the node")
+		id     = fs.Uint64("id", 0, "id of the connection to capture")
+		frames = fs.Int("frames", 0, "number of commands to capture")
+		redact = fs.Bool("redact", true, "replace the values of the commands")
+		file   = fs.String("file", "", "capture file, stdout if empty")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(*admin) == 0 || *id == 0 || *frames <= 0 {
+		return errors.New(captureUsage)
+	}
+	endpoint := fmt.Sprintf("http://%s/api/v1/clients/capture?id=%d&frames=%d&redact=%s",
+		*admin, *id, *frames, strconv.FormatBool(*redact))
+	return download(endpoint, *file)
+}
+
+// replayCommand runs "ggcache replay", which sends a capture file to a
+// server to reproduce what the captured connection did.
+func replayCommand(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	var (
+		addr    = fs.String("addr", "", "listen address of the server")
+		token   = fs.String("token", "", "token sent by the AUTH commands")
+		timing  = fs.Bool("timing", false, "wait between the commands as captured")
+		timeout = fs.Duration("timeout", 10*time.Second, "time to wait for each response")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(*addr) == 0 || fs.NArg() != 1 {
+		return errors.New(replayUsage)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if err := proto.ReadCaptureMagic(r); err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	for i := 1; ; i++ {
+		frame, err := proto.ParseCaptureFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if *timing {
+			time.Sleep(time.Until(start.Add(frame.Elapsed)))
+		}
+
+		cmd := replayable(frame.Command, *token)
+		name := commandName(cmd)
+		if _, ok := cmd.(*proto.CommandJoin); ok {
+			fmt.Printf("%d %s skipped\n", i, name)
+			continue
+		}
+		if err := proto.WriteMessage(conn, cmd); err != nil {
+			return err
+		}
+		if !answered(cmd) {
+			fmt.Printf("%d %s sent\n", i, name)
+			continue
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(*timeout))
+		status, err := readResponse(conn, cmd)
+		var redirect *proto.Redirect
+		switch {
+		case errors.As(err, &redirect):
+			fmt.Printf("%d %s %s\n", i, name, redirect)
+		case err != nil:
+			return fmt.Errorf("response to command %d (%s): %w", i, name, err)
+		default:
+			fmt.Printf("%d %s %s\n", i, name, status)
+		}
+		if _, ok := cmd.(*proto.CommandSubscribe); ok {
+			fmt.Println("stopped at the subscription, the connection now only carries messages")
+			return nil
+		}
+	}
+}
+
+// replayable returns the captured command as it is replayed: without the
+// replication offset of its session, which belongs to the captured node,
+// and with the token for an AUTH.
+func replayable(cmd proto.Appender, token string) proto.Appender {
+	switch v := cmd.(type) {
+	case *proto.CommandDeadline:
+		return &proto.CommandDeadline{Timeout: v.Timeout, Command: replayable(v.Command, token)}
+	case *proto.CommandOffset:
+		return replayable(v.Command, token)
+	case *proto.CommandAuth:
+		return &proto.CommandAuth{Token: []byte(token)}
+	default:
+		return cmd
+	}
+}
+
+// answered reports whether the server answers the command.
+func answered(cmd proto.Appender) bool {
+	switch v := cmd.(type) {
+	case *proto.CommandDeadline:
+		return answered(v.Command)
+	case *proto.CommandNoReply, *proto.CommandCancel:
+		return false
+	default:
+		return true
+	}
+}
+
+// commandName returns the protocol name of a command, e.g. "SET".
+func commandName(cmd proto.Appender) string {
+	if dl, ok := cmd.(*proto.CommandDeadline); ok {
+		cmd = dl.Command
+	}
+	return strings.ToUpper(strings.TrimPrefix(fmt.Sprintf("%T", cmd), "*proto.Command"))
+}
+
+// readResponse reads the response to the command and returns its status.
+func readResponse(r io.Reader, cmd proto.Appender) (proto.Status, error) {
+	switch v := cmd.(type) {
+	case *proto.CommandDeadline:
+		return readResponse(r, v.Command)
+	case *proto.CommandGet, *proto.CommandGetRange, *proto.CommandFill:
+		resp, err := proto.ParseGetResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandDel, *proto.CommandDelIf:
+		resp, err := proto.ParseDeleteResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandTouch:
+		resp, err := proto.ParseTouchResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandAppend:
+		resp, err := proto.ParseAppendResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandMGet:
+		resp, err := proto.ParseMGetResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandGetLease:
+		resp, err := proto.ParseGetLeaseResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandBatch, *proto.CommandMulti:
+		resp, err := proto.ParseBatchResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandFlush, *proto.CommandUndelete, *proto.CommandPurge:
+		resp, err := proto.ParseFlushResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandScan:
+		resp, err := proto.ParseScanResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandXAdd:
+		resp, err := proto.ParseXAddResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandXRange, *proto.CommandXRead:
+		resp, err := proto.ParseXRangeResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandPublish:
+		resp, err := proto.ParsePublishResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandTopology:
+		resp, err := proto.ParseTopologyResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandInfo:
+		resp, err := proto.ParseInfoResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandStats:
+		resp, err := proto.ParseStatsResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandBackup:
+		resp, err := proto.ParseBackupResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	case *proto.CommandAuth:
+		resp, err := proto.ParseAuthResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	default:
+		// SET, SETIF, SETLEASE, RENAME, COPY, PING, CLOCK, SYNC, PROMOTE
+		// and SUBSCRIBE are answered with a ResponseSet.
+		resp, err := proto.ParseSetResponse(r)
+		if err != nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	}
+}
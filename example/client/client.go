@@ -1,43 +1,296 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/anthdm/ggcache"
 	"github.com/anthdm/ggcache/example/proto"
 )
 
-type Options struct{}
+var (
+	// ErrLeaseHeld is returned by GetLease on a miss while another client
+	// holds the lease of the key. The caller should retry shortly.
+	ErrLeaseHeld = errors.New("lease held by another client")
 
+	// ErrLeaseInvalid is returned by SetLease if the lease expired or the key
+	// was written since it was granted.
+	ErrLeaseInvalid = errors.New("lease expired or invalidated")
+
+	// ErrPreconditionFailed is returned by SetIf, DeleteIfValue and
+	// DeleteIfVersion if the condition does not hold for the current value
+	// of the key. It is the ggcache.ErrPreconditionFailed of the caches.
+	ErrPreconditionFailed = ggcache.ErrPreconditionFailed
+
+	// ErrUnauthorized is returned by New if the server rejects the
+	// AuthToken, and by the commands the tenant is not allowed to send.
+	ErrUnauthorized = proto.ErrUnauthorized
+
+	// ErrInvalidValue is returned by the writes whose value the server
+	// rejects, as it does not pass the validators of its namespace.
+	ErrInvalidValue = errors.New("invalid value")
+
+	// ErrPersistence is returned by the writes the server did not store as
+	// it cannot persist them. Reads may still be served. It is the
+	// ggcache.ErrPersistence of the caches.
+	ErrPersistence = ggcache.ErrPersistence
+
+	// ErrKeyNotFound is wrapped, along with the key, by the errors of the
+	// commands on a key the server does not have. It is the
+	// ggcache.ErrKeyNotFound of the caches.
+	ErrKeyNotFound = proto.ErrKeyNotFound
+
+	// ErrStale is wrapped, along with the key, by the errors of Get and
+	// GetRange that return the value of a key past its expiration, which
+	// the server serves if it is set to. It is the ggcache.ErrStale of the
+	// caches.
+	ErrStale = proto.ErrStale
+
+	// ErrTooLarge is returned by UDPClient.Get for a value that does not fit
+	// a datagram, which has to be read over TCP.
+	ErrTooLarge = proto.ErrTooLarge
+
+	// ErrReadOnly is matched by the *proto.Redirect returned for a write sent
+	// to a follower, which names the leader to send it to.
+	ErrReadOnly = proto.ErrReadOnly
+
+	// ErrTimeout is wrapped, along with its cause, by the errors of the
+	// commands whose deadline passed before the server answered, and of
+	// those the server stopped with StatusTimeout as they ran for too long.
+	ErrTimeout = errors.New("timeout")
+
+	// ErrOverloaded is wrapped by the errors of the commands shed without
+	// being sent, as too many were already waiting for the connection or
+	// waited too long for it, and of those the server shed with
+	// StatusOverloaded.
+	ErrOverloaded = errors.New("overloaded")
+
+	// ErrCrossSlot is returned by Multi if its keys do not all have the same
+	// hash tag.
+	ErrCrossSlot = errors.New("keys of a multi in different hash slots")
+
+	// errNoVersion is returned by DeleteIfVersion for an empty version, which
+	// would delete the key whatever its value.
+	errNoVersion = errors.New("a conditional delete requires a version")
+)
+
+type Options struct {
+	TLSConfig *tls.Config
+
+	// AuthToken, if set, authenticates the connection as the tenant the
+	// server binds it to. Its keys are then scoped to the tenant namespace.
+	AuthToken string
+
+	// ClusterSecret, if set, is sent along with the commands that change the
+	// leader of the node, which a node with a ClusterSecret rejects without
+	// it.
+	ClusterSecret string
+
+	// MaxWaiting and MaxWait, if set, shed the commands that would wait for
+	// the connection behind MaxWaiting others, or for longer than MaxWait,
+	// with ErrOverloaded instead of queueing them without bound while the
+	// server is slow. QueueStats reports the commands shed. They bound the
+	// Gets of a Pool waiting for a connection the same way.
+	MaxWaiting int
+	MaxWait    time.Duration
+
+	// Zone, if set, is the availability zone of the client: a Cluster sends
+	// its reads to the replicas of that zone when there are any, saving the
+	// cost of cross-zone traffic.
+	Zone string
+
+	// KeepAlive, if set, makes Peers, Cluster and Pool ping their connections
+	// that were idle for that long, and drop those that do not answer
+	// within PingTimeout, DefaultPingTimeout if zero, so the first command
+	// after an idle period does not wait on a dead connection.
+	KeepAlive   time.Duration
+	PingTimeout time.Duration
+
+	// KeyPrefix, if set, is prepended to the keys, channels and patterns of
+	// every command and stripped from the keys Scan returns, so the apps
+	// sharing a cluster each have a keyspace of their own. It cannot hold
+	// "{" or "}", which would change the HashTag of the keys.
+	KeyPrefix string
+}
+
+// Client is safe for concurrent use; requests on the underlying connection
+// are serialized.
 type Client struct {
+	// sem holds a token while a command uses conn.
+	sem  chan struct{}
 	conn net.Conn
+
+	// maxWaiting and maxWait bound the commands waiting for conn, which
+	// waiting counts and shed the ones that were not sent.
+	maxWaiting int
+	maxWait    time.Duration
+	waiting    atomic.Int64
+	shed       atomic.Uint64
+
+	// ids numbers the commands that can be cancelled.
+	ids atomic.Uint64
+
+	// used is when conn was last released by a command, in unix
+	// nanoseconds.
+	used atomic.Int64
+
+	// health is the proto.Health the node sent with its last response.
+	health atomic.Uint32
+
+	// prefix is the Options.KeyPrefix of the keys sent.
+	prefix []byte
+
+	// clusterSecret is the Options.ClusterSecret sent with a PROMOTE.
+	clusterSecret string
+}
+
+// healthConn is the connection of a Client, which records the Health of the
+// node read with each response.
+type healthConn struct {
+	net.Conn
+	health *atomic.Uint32
+}
+
+func (c healthConn) RecordHealth(h proto.Health) {
+	c.health.Store(uint32(h))
+}
+
+// QueueStats are the commands of a Client waiting for its connection and
+// the ones it shed with ErrOverloaded.
+type QueueStats struct {
+	Waiting int
+	Shed    uint64
 }
 
 func NewFromConn(conn net.Conn) *Client {
-	return &Client{
-		conn: conn,
-	}
+	c := &Client{sem: make(chan struct{}, 1)}
+	c.conn = healthConn{Conn: conn, health: &c.health}
+	c.used.Store(time.Now().UnixNano())
+	return c
 }
 
-func New(endpoint string, _ Options) (*Client, error) {
-	conn, err := net.Dial("tcp", endpoint)
+func New(endpoint string, opts Options) (*Client, error) {
+	if strings.ContainsAny(opts.KeyPrefix, "{}") {
+		return nil, fmt.Errorf("key prefix [%s] contains a hash tag brace", opts.KeyPrefix)
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if opts.TLSConfig != nil {
+		conn, err = tls.Dial("tcp", endpoint, opts.TLSConfig)
+	} else {
+		conn, err = net.Dial("tcp", endpoint)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{
-		conn: conn,
-	}, nil
+	c := NewFromConn(conn)
+	c.maxWaiting, c.maxWait = opts.MaxWaiting, opts.MaxWait
+	c.clusterSecret = opts.ClusterSecret
+	if len(opts.KeyPrefix) != 0 {
+		c.prefix = []byte(opts.KeyPrefix)
+	}
+	if len(opts.AuthToken) != 0 {
+		if err := c.auth(opts.AuthToken); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// lock waits for the connection, or sheds the command if the Client is
+// overloaded.
+func (c *Client) lock() error {
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	default:
+	}
+
+	n := c.waiting.Add(1)
+	defer c.waiting.Add(-1)
+	if c.maxWaiting > 0 && n > int64(c.maxWaiting) {
+		c.shed.Add(1)
+		return fmt.Errorf("%w: %d commands waiting", ErrOverloaded, n-1)
+	}
+	if c.maxWait <= 0 {
+		c.sem <- struct{}{}
+		return nil
+	}
+
+	timer := time.NewTimer(c.maxWait)
+	defer timer.Stop()
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	case <-timer.C:
+		c.shed.Add(1)
+		return fmt.Errorf("%w: waited %s for the connection", ErrOverloaded, c.maxWait)
+	}
+}
+
+func (c *Client) unlock() {
+	c.used.Store(time.Now().UnixNano())
+	<-c.sem
+}
+
+// QueueStats returns the number of commands waiting for the connection and
+// of those shed so far.
+func (c *Client) QueueStats() QueueStats {
+	return QueueStats{
+		Waiting: int(c.waiting.Load()),
+		Shed:    c.shed.Load(),
+	}
+}
+
+// Health returns the degraded states the node reported with its last
+// response, so callers can move their traffic off it before it fails.
+func (c *Client) Health() proto.Health {
+	return proto.Health(c.health.Load())
+}
+
+func (c *Client) auth(token string) error {
+	cmd := &proto.CommandAuth{
+		Token: []byte(token),
+	}
+	if err := proto.WriteMessage(c.conn, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseAuthResponse(c.conn)
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, nil)
+	}
+
+	return nil
 }
 
-func (c *Client) Get(_ context.Context, key []byte) ([]byte, error) {
+func (c *Client) Get(ctx context.Context, key []byte) ([]byte, error) {
 	cmd := &proto.CommandGet{
 		Key: key,
 	}
 
-	_, err := c.conn.Write(cmd.Bytes())
-	if err != nil {
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
 		return nil, err
 	}
 
@@ -45,39 +298,1041 @@ func (c *Client) Get(_ context.Context, key []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	if resp.Status == proto.StatusKeyNotFound {
-		return nil, fmt.Errorf("could not find key (%s)", key)
-	}
-	if resp.Status != proto.StatusOK {
-		return nil, fmt.Errorf("server responded with non OK status [%s]", resp.Status)
+	switch resp.Status {
+	case proto.StatusOK:
+	case proto.StatusStale:
+		return resp.Value, fmt.Errorf("%w: %s", ErrStale, key)
+	default:
+		return nil, statusError(resp.Status, key)
 	}
 
 	return resp.Value, nil
 }
 
-func (c *Client) Set(_ context.Context, key []byte, value []byte, ttl int) error {
+func (c *Client) Set(ctx context.Context, key []byte, value []byte, ttl time.Duration) error {
 	cmd := &proto.CommandSet{
 		Key:   key,
 		Value: value,
-		TTL:   ttl,
+		TTL:   int(ttl.Milliseconds()),
 	}
 
-	_, err := c.conn.Write(cmd.Bytes())
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseSetResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, key)
+	}
+
+	return nil
+}
+
+// SetNoReply sets a key like Set without waiting for the server to answer,
+// which it does not. Only the errors of sending the command are returned:
+// a write the server rejects, e.g. as it is a follower, is lost. The writes
+// do not advance the Session of ctx either.
+func (c *Client) SetNoReply(ctx context.Context, key []byte, value []byte, ttl time.Duration) error {
+	return c.sendNoReply(ctx, &proto.CommandSet{
+		Key:   key,
+		Value: value,
+		TTL:   int(ttl.Milliseconds()),
+	})
+}
+
+// DeleteNoReply deletes a key like Delete without waiting for the server to
+// answer, with the caveats of SetNoReply.
+func (c *Client) DeleteNoReply(ctx context.Context, key []byte) error {
+	return c.sendNoReply(ctx, &proto.CommandDel{Key: key})
+}
+
+func (c *Client) sendNoReply(ctx context.Context, cmd proto.Appender) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	return c.send(ctx, &proto.CommandNoReply{Command: cmd})
+}
+
+func (c *Client) Delete(ctx context.Context, key []byte) error {
+	cmd := &proto.CommandDel{
+		Key: key,
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseDeleteResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, key)
+	}
+
+	return nil
+}
+
+// Touch resets the TTL of an existing key without transferring its value.
+func (c *Client) Touch(ctx context.Context, key []byte, ttl time.Duration) error {
+	cmd := &proto.CommandTouch{
+		Key: key,
+		TTL: int(ttl.Milliseconds()),
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseTouchResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, key)
+	}
+
+	return nil
+}
+
+// Append appends data to the value of an existing key.
+func (c *Client) Append(ctx context.Context, key, data []byte) error {
+	cmd := &proto.CommandAppend{
+		Key:  key,
+		Data: data,
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseAppendResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
 	if err != nil {
 		return err
 	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, key)
+	}
+
+	return nil
+}
+
+// Rename moves the value of oldKey to newKey atomically, keeping its
+// expiration, so a value built under a temporary key can be published in
+// one step.
+func (c *Client) Rename(ctx context.Context, oldKey, newKey []byte) error {
+	cmd := &proto.CommandRename{
+		Key:    oldKey,
+		NewKey: newKey,
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
+	}
 
 	resp, err := proto.ParseSetResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
 	if err != nil {
 		return err
 	}
 	if resp.Status != proto.StatusOK {
-		return fmt.Errorf("server responsed with non OK status [%s]", resp.Status)
+		return statusError(resp.Status, oldKey)
+	}
+	return nil
+}
+
+// Copy stores the value of src under dst atomically with the TTL.
+func (c *Client) Copy(ctx context.Context, src, dst []byte, ttl time.Duration) error {
+	cmd := &proto.CommandCopy{
+		Key: src,
+		Dst: dst,
+		TTL: int(ttl.Milliseconds()),
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
 	}
 
+	resp, err := proto.ParseSetResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, src)
+	}
 	return nil
 }
 
+// statusError returns the error of a response status other than StatusOK to
+// a command on key.
+func statusError(status proto.Status, key []byte) error {
+	switch status {
+	case proto.StatusKeyNotFound:
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	case proto.StatusTooLarge:
+		return ErrTooLarge
+	case proto.StatusUnauthorized:
+		return ErrUnauthorized
+	case proto.StatusLeaseHeld:
+		return ErrLeaseHeld
+	case proto.StatusLeaseInvalid:
+		return ErrLeaseInvalid
+	case proto.StatusPreconditionFailed:
+		return ErrPreconditionFailed
+	case proto.StatusInvalidValue:
+		return ErrInvalidValue
+	case proto.StatusPersistenceError:
+		return ErrPersistence
+	case proto.StatusDeadlineExceeded:
+		return fmt.Errorf("%w: %w", ErrTimeout, context.DeadlineExceeded)
+	case proto.StatusCrossSlot:
+		return ErrCrossSlot
+	case proto.StatusOverloaded:
+		return fmt.Errorf("%w: shed by the server", ErrOverloaded)
+	case proto.StatusTimeout:
+		return fmt.Errorf("%w: stopped by the server", ErrTimeout)
+	default:
+		return fmt.Errorf("server responded with non OK status [%s]", status)
+	}
+}
+
+// send writes the command, carrying the time left before the deadline of
+// ctx if it has one, so the server does not run it once the caller gave up.
+// It returns the error of the deadline without writing anything if the
+// deadline passed. The keys of the command are sent under the KeyPrefix.
+func (c *Client) send(ctx context.Context, cmd proto.Appender) error {
+	cmd = c.scoped(cmd)
+	if sess := sessionFrom(ctx); sess != nil {
+		cmd = &proto.CommandOffset{Offset: sess.Offset(), Command: cmd}
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return proto.WriteMessage(c.conn, cmd)
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return fmt.Errorf("%w: %w", ErrTimeout, context.DeadlineExceeded)
+	}
+	// Rounded up to the millisecond the deadline is sent in.
+	timeout = (timeout + time.Millisecond - 1).Truncate(time.Millisecond)
+	return proto.WriteMessage(c.conn, &proto.CommandDeadline{Timeout: timeout, Command: cmd})
+}
+
+// timeoutError wraps err with ErrTimeout if it is a timeout, such as
+// context.DeadlineExceeded or the read deadline of a connection.
+func timeoutError(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
+
+// GetRange reads up to length bytes of the value of a key starting at offset.
+// The range is clipped to the end of the value.
+func (c *Client) GetRange(ctx context.Context, key []byte, offset, length int) ([]byte, error) {
+	cmd := &proto.CommandGetRange{
+		Key:    key,
+		Offset: offset,
+		Length: length,
+	}
+
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	resp, err := proto.ParseGetResponse(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Status {
+	case proto.StatusOK:
+	case proto.StatusStale:
+		return resp.Value, fmt.Errorf("%w: %s", ErrStale, key)
+	default:
+		return nil, statusError(resp.Status, key)
+	}
+
+	return resp.Value, nil
+}
+
+// MGet reads the values of the keys in a single round trip, in order, nil
+// for the keys not found. If the server ran out of its time budget for the
+// batch, the values of the first keys only are returned and truncated is
+// set; the others can be read with another MGet.
+func (c *Client) MGet(ctx context.Context, keys [][]byte) (values [][]byte, truncated bool, err error) {
+	cmd := &proto.CommandMGet{Keys: keys}
+
+	if err := c.lock(); err != nil {
+		return nil, false, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return nil, false, err
+	}
+
+	resp, err := proto.ParseMGetResponse(c.conn)
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.Status != proto.StatusOK {
+		return nil, false, statusError(resp.Status, nil)
+	}
+
+	return resp.Values, resp.Truncated, nil
+}
+
+// GetLease reads the key like Get. On a miss it returns a non-zero token if
+// the server granted this client the lease to fill the key with SetLease, or
+// ErrLeaseHeld if another client holds it.
+func (c *Client) GetLease(ctx context.Context, key []byte) ([]byte, uint64, error) {
+	cmd := &proto.CommandGetLease{
+		Key: key,
+	}
+
+	if err := c.lock(); err != nil {
+		return nil, 0, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := proto.ParseGetLeaseResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	switch resp.Status {
+	case proto.StatusOK:
+		return resp.Value, 0, nil
+	case proto.StatusLeaseGranted:
+		return nil, resp.Token, nil
+	case proto.StatusLeaseHeld:
+		return nil, 0, ErrLeaseHeld
+	default:
+		return nil, 0, statusError(resp.Status, key)
+	}
+}
+
+// SetLease sets the key with the token returned by GetLease. It returns
+// ErrLeaseInvalid if the lease is no longer valid, in which case the value is
+// not stored.
+func (c *Client) SetLease(ctx context.Context, key, value []byte, ttl time.Duration, token uint64) error {
+	cmd := &proto.CommandSetLease{
+		Key:   key,
+		Value: value,
+		TTL:   int(ttl.Milliseconds()),
+		Token: token,
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseSetResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, key)
+	}
+
+	return nil
+}
+
+// DeleteIfValue deletes the key if it still holds the expected value, e.g.
+// to release a lock only while still holding it. It returns
+// ErrPreconditionFailed if the key holds another value or none.
+func (c *Client) DeleteIfValue(ctx context.Context, key, expected []byte) error {
+	return c.DeleteIfVersion(ctx, key, ggcache.ETag(expected))
+}
+
+// DeleteIfVersion deletes the key if its current value has the ETag
+// version, as computed by ggcache.ETag, or if it holds any value if version
+// is "*". It returns ErrPreconditionFailed if the key is not deleted, and
+// an error without deleting anything if version is empty.
+func (c *Client) DeleteIfVersion(ctx context.Context, key []byte, version string) error {
+	if len(version) == 0 {
+		return errNoVersion
+	}
+	cmd := &proto.CommandDelIf{
+		Key:     key,
+		IfMatch: []byte(version),
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseDeleteResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, key)
+	}
+
+	return nil
+}
+
+// SetIf sets the key if its current value has the ETag ifMatch and not the
+// ETag ifNoneMatch, as computed by ggcache.ETag. "*" matches any value and
+// an empty ETag is ignored, so ifNoneMatch "*" only adds missing keys. It
+// returns ErrPreconditionFailed if the value is not stored.
+func (c *Client) SetIf(ctx context.Context, key, value []byte, ttl time.Duration, ifMatch, ifNoneMatch string) error {
+	cmd := &proto.CommandSetIf{
+		Key:         key,
+		Value:       value,
+		TTL:         int(ttl.Milliseconds()),
+		IfMatch:     []byte(ifMatch),
+		IfNoneMatch: []byte(ifNoneMatch),
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseSetResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, key)
+	}
+
+	return nil
+}
+
+// Batch sends SET, DEL and TOUCH commands in a single frame, applied by the
+// server in order.
+func (c *Client) Batch(ctx context.Context, cmds []proto.Appender) error {
+	cmd := &proto.CommandBatch{
+		Commands: cmds,
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseBatchResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, nil)
+	}
+
+	return nil
+}
+
+// Multi sends SET and DEL commands applied by the server atomically, in
+// order: no reader sees some of them applied and not the others. Their keys
+// must have the same ggcache.HashTag, such as "{user:1}:profile" and
+// "{user:1}:cart", which also places them on the same node of Peers; it
+// returns ErrCrossSlot without sending anything otherwise.
+func (c *Client) Multi(ctx context.Context, cmds []proto.Appender) error {
+	var tag []byte
+	for i, cmd := range cmds {
+		var key []byte
+		switch v := cmd.(type) {
+		case *proto.CommandSet:
+			key = v.Key
+		case *proto.CommandDel:
+			key = v.Key
+		default:
+			return fmt.Errorf("multi only carries SET and DEL commands, not %T", cmd)
+		}
+		if i == 0 {
+			tag = ggcache.HashTag(key)
+		} else if !bytes.Equal(ggcache.HashTag(key), tag) {
+			return ErrCrossSlot
+		}
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, &proto.CommandMulti{Commands: cmds}); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseBatchResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, nil)
+	}
+
+	return nil
+}
+
+// Replicate applies the mutations of the leader like Batch, telling the
+// follower the replication offset they bring it to. The leader forwards its
+// mutations with it.
+func (c *Client) Replicate(_ context.Context, offset uint64, cmds []proto.Appender) error {
+	cmd := &proto.CommandOffset{
+		Offset:  offset,
+		Command: &proto.CommandBatch{Commands: cmds},
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := proto.WriteMessage(c.conn, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseBatchResponse(c.conn)
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, nil)
+	}
+
+	return nil
+}
+
+// Clock sends the time t of the leader and the latest round trip it
+// measured to a follower, which takes its clock offset from them. A zero t
+// only measures the round trip. The leader uses it to time the mutations it
+// replicates.
+func (c *Client) Clock(_ context.Context, t time.Time, rtt time.Duration) error {
+	cmd := &proto.CommandClock{RTT: rtt}
+	if !t.IsZero() {
+		cmd.Time = t.UnixNano()
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := proto.WriteMessage(c.conn, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseSetResponse(c.conn)
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, nil)
+	}
+
+	return nil
+}
+
+// Sync sends the chunk of a snapshot numbered seq to a follower, which
+// restores the snapshot once it gets the final chunk. The leader uses it to
+// sync the followers joining it.
+func (c *Client) Sync(_ context.Context, seq uint64, data []byte, final bool) error {
+	cmd := &proto.CommandSync{
+		Seq:   seq,
+		Data:  data,
+		Final: final,
+	}
+
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := proto.WriteMessage(c.conn, cmd); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseSetResponse(c.conn)
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, nil)
+	}
+
+	return nil
+}
+
+// Fill asks the server for the value of a key it owns in a ggcache.Group,
+// which loads it if it is missing. It implements ggcache.PeerGetter. If ctx
+// is done before the server answers, the server is told to abandon the load.
+func (c *Client) Fill(ctx context.Context, key []byte) ([]byte, error) {
+	cmd := &proto.CommandFill{
+		Key: key,
+		ID:  c.ids.Add(1),
+	}
+
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return nil, err
+	}
+	defer c.cancelOnDone(ctx, cmd.ID)()
+
+	resp, err := proto.ParseGetResponse(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != proto.StatusOK {
+		if ctx.Err() != nil {
+			return nil, timeoutError(ctx.Err())
+		}
+		return nil, statusError(resp.Status, key)
+	}
+
+	return resp.Value, nil
+}
+
+// Backup asks the server to back up its cache and returns the name of the
+// backup object. If ctx is done before the server answers, the server is
+// told to abandon the upload.
+func (c *Client) Backup(ctx context.Context) (string, error) {
+	cmd := &proto.CommandBackup{ID: c.ids.Add(1)}
+
+	if err := c.lock(); err != nil {
+		return "", err
+	}
+	defer c.unlock()
+
+	_, err := c.conn.Write(cmd.Bytes())
+	if err != nil {
+		return "", err
+	}
+	defer c.cancelOnDone(ctx, cmd.ID)()
+
+	resp, err := proto.ParseBackupResponse(c.conn)
+	if err != nil {
+		return "", err
+	}
+	if resp.Status != proto.StatusOK {
+		if ctx.Err() != nil {
+			return "", timeoutError(ctx.Err())
+		}
+		return "", statusError(resp.Status, nil)
+	}
+
+	return resp.Name, nil
+}
+
+// cancelOnDone sends a CANCEL for the command with the ID once ctx is done,
+// until the returned func is called. The cancelled command is still
+// answered, so its response is read as usual and the stream stays in step.
+func (c *Client) cancelOnDone(ctx context.Context, id uint64) func() {
+	stop := context.AfterFunc(ctx, func() {
+		// A late CANCEL for a command that is done is ignored, and writes to
+		// the connection do not interleave, so this needs no lock.
+		_ = proto.WriteMessage(c.conn, &proto.CommandCancel{ID: id})
+	})
+	return func() { stop() }
+}
+
+// Stats returns the named counters and gauges reported by the server.
+func (c *Client) Stats(_ context.Context) (map[string]int64, error) {
+	cmd := &proto.CommandStats{}
+
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+
+	_, err := c.conn.Write(cmd.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := proto.ParseStatsResponse(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != proto.StatusOK {
+		return nil, statusError(resp.Status, nil)
+	}
+
+	stats := make(map[string]int64, len(resp.Stats))
+	for _, stat := range resp.Stats {
+		stats[stat.Name] = stat.Value
+	}
+
+	return stats, nil
+}
+
+// Promote makes the server, a follower, the leader of the cluster and its
+// leader a follower of it that redirects its writes. It is meant for
+// planned maintenance of clusters without Discovery or an Elector, and
+// requires the ClusterSecret of the node if it has one.
+func (c *Client) Promote(_ context.Context) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := proto.WriteMessage(c.conn, &proto.CommandPromote{Secret: c.clusterSecret}); err != nil {
+		return err
+	}
+
+	resp, err := proto.ParseSetResponse(c.conn)
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, nil)
+	}
+
+	return nil
+}
+
+// Flush deletes the keys matching the glob pattern, e.g. "user:*:session",
+// and returns how many there were. With dryRun it only counts them.
+func (c *Client) Flush(ctx context.Context, pattern string, dryRun bool) (int, error) {
+	if err := c.lock(); err != nil {
+		return 0, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, &proto.CommandFlush{Pattern: []byte(pattern), DryRun: dryRun}); err != nil {
+		return 0, err
+	}
+
+	resp, err := proto.ParseFlushResponse(c.conn)
+	if err == nil && !dryRun {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if resp.Status != proto.StatusOK {
+		return 0, statusError(resp.Status, nil)
+	}
+
+	return int(resp.Keys), nil
+}
+
+// Undelete restores the keys matching the glob pattern deleted within the
+// DeleteRetention of the server, with the TTL they had left, and returns how
+// many it restored. The keys set again since they were deleted are kept.
+func (c *Client) Undelete(ctx context.Context, pattern string) (int, error) {
+	if err := c.lock(); err != nil {
+		return 0, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, &proto.CommandUndelete{Pattern: []byte(pattern)}); err != nil {
+		return 0, err
+	}
+
+	resp, err := proto.ParseFlushResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if resp.Status != proto.StatusOK {
+		return 0, statusError(resp.Status, nil)
+	}
+
+	return int(resp.Keys), nil
+}
+
+// Purge deletes the keys starting with prefix whose value was written before
+// the time, e.g. to invalidate everything written before a bad deploy, and
+// returns how many there were. An empty prefix matches every key.
+func (c *Client) Purge(ctx context.Context, before time.Time, prefix string) (int, error) {
+	if err := c.lock(); err != nil {
+		return 0, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, &proto.CommandPurge{Before: before, Prefix: []byte(prefix)}); err != nil {
+		return 0, err
+	}
+
+	resp, err := proto.ParseFlushResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if resp.Status != proto.StatusOK {
+		return 0, statusError(resp.Status, nil)
+	}
+
+	return int(resp.Keys), nil
+}
+
+// Scan returns up to count of the keys matching the glob pattern that sort
+// after after, in order; the server picks the count if it is zero. Passing
+// the last key of a page as the next after walks every matching key, each
+// page visiting every key of the server.
+func (c *Client) Scan(ctx context.Context, pattern string, after []byte, count int) ([][]byte, error) {
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+
+	cmd := &proto.CommandScan{Pattern: []byte(pattern), After: after, Count: uint64(count)}
+	if err := c.send(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	resp, err := proto.ParseScanResponse(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != proto.StatusOK {
+		return nil, statusError(resp.Status, nil)
+	}
+
+	for i, key := range resp.Keys {
+		resp.Keys[i] = c.unscope(key)
+	}
+	return resp.Keys, nil
+}
+
+// XAdd adds an entry holding value to the stream at key, creating it if
+// needed, and returns the ID the server gave it. maxLen, if set, trims the
+// oldest entries beyond it. Like a SET, every add sets the TTL of the
+// stream, zero meaning no expiration.
+func (c *Client) XAdd(ctx context.Context, key, value []byte, maxLen int, ttl time.Duration) (proto.StreamID, error) {
+	cmd := &proto.CommandXAdd{
+		Key:    key,
+		Value:  value,
+		MaxLen: uint64(maxLen),
+		TTL:    int(ttl.Milliseconds()),
+	}
+
+	if err := c.lock(); err != nil {
+		return proto.StreamID{}, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return proto.StreamID{}, err
+	}
+
+	resp, err := proto.ParseXAddResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return proto.StreamID{}, err
+	}
+	if resp.Status != proto.StatusOK {
+		return proto.StreamID{}, statusError(resp.Status, key)
+	}
+
+	return resp.ID, nil
+}
+
+// XRange returns the entries of the stream at key with IDs from start to end
+// included, up to count of them if it is set. proto.StreamID{} and
+// proto.MaxStreamID bound the whole stream.
+func (c *Client) XRange(ctx context.Context, key []byte, start, end proto.StreamID, count int) ([]proto.StreamEntry, error) {
+	cmd := &proto.CommandXRange{Key: key, Start: start, End: end, Count: uint64(count)}
+	return c.xrange(ctx, cmd, key)
+}
+
+// XRead returns the entries of the stream at key with IDs greater than
+// after, up to count of them if it is set, waiting up to block for one to be
+// added if there are none. After proto.MaxStreamID only the entries added
+// from now on are read. The connection is held while it waits, so a
+// consumer tailing a stream should have a Client of its own.
+func (c *Client) XRead(ctx context.Context, key []byte, after proto.StreamID, count int, block time.Duration) ([]proto.StreamEntry, error) {
+	cmd := &proto.CommandXRead{Key: key, After: after, Count: uint64(count), Block: uint64(block.Milliseconds())}
+	return c.xrange(ctx, cmd, key)
+}
+
+func (c *Client) xrange(ctx context.Context, cmd proto.Appender, key []byte) ([]proto.StreamEntry, error) {
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	resp, err := proto.ParseXRangeResponse(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != proto.StatusOK {
+		return nil, statusError(resp.Status, key)
+	}
+
+	return resp.Entries, nil
+}
+
+// Topology returns the address of the leader, which takes the writes, and
+// the read endpoints of the replicas, as seen by the server.
+func (c *Client) Topology(ctx context.Context) (string, []string, error) {
+	resp, err := c.topology(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	return resp.Leader, resp.Replicas, nil
+}
+
+func (c *Client) topology(_ context.Context) (*proto.ResponseTopology, error) {
+	cmd := &proto.CommandTopology{}
+
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+
+	if err := proto.WriteMessage(c.conn, cmd); err != nil {
+		return nil, err
+	}
+
+	resp, err := proto.ParseTopologyResponse(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != proto.StatusOK {
+		return nil, statusError(resp.Status, nil)
+	}
+	return resp, nil
+}
+
+// Info returns the build of the server and the capabilities it has
+// enabled.
+func (c *Client) Info(ctx context.Context) (*proto.ResponseInfo, error) {
+	if err := c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, &proto.CommandInfo{}); err != nil {
+		return nil, err
+	}
+
+	resp, err := proto.ParseInfoResponse(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != proto.StatusOK {
+		return nil, statusError(resp.Status, nil)
+	}
+	return resp, nil
+}
+
+// ServerVersion returns the version the server was built as, "dev" for a
+// build that did not set one, so tooling can gate features on it.
+func (c *Client) ServerVersion(ctx context.Context) (string, error) {
+	info, err := c.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.Version, nil
+}
+
 func (c *Client) Close() error {
 	return c.conn.Close()
 }
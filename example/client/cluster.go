@@ -0,0 +1,456 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// Cluster routes the commands of a leader and its read-only replicas, as
+// advertised by their TOPOLOGY responses: writes go to the leader and reads
+// are spread over the replicas, those in Options.Zone if there are any, or
+// sent to the leader if there are none. Reads are moved off the replicas
+// reporting a degraded proto.Health while others do not, and a replica
+// warming up with a weight under 100% in the topology only gets that share
+// of them, the rest going to the other replicas or the leader. A write
+// answered with StatusMoved refreshes the topology and is sent again to the
+// new leader, and a read is sent again to the leader.
+type Cluster struct {
+	opts Options
+	seed string
+
+	// replicas are the clients of every replica, local those of the replicas
+	// in the zone of the client.
+	mu         sync.Mutex
+	leaderAddr string
+	leader     *Client
+	replicas   []*Client
+	local      []*Client
+	// ramps holds the weight of the replicas warming up.
+	ramps map[*Client]ramp
+
+	next atomic.Uint64
+
+	// quit stops the keepalive pings, nil once closed or without them.
+	quit chan struct{}
+}
+
+// NewCluster connects to the cluster of the node at seed, which may be the
+// leader or any follower.
+func NewCluster(seed string, opts Options) (*Cluster, error) {
+	c := &Cluster{opts: opts, seed: seed}
+	if err := c.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	if opts.KeepAlive > 0 {
+		c.quit = make(chan struct{})
+		go keepAlive(opts, c.list, c.dead, c.quit)
+	}
+	return c, nil
+}
+
+// Refresh reconnects to the leader and replicas currently advertised. The
+// topology is asked of the last known leader, or the seed, and then of the
+// leader it names, which lists every replica.
+func (c *Cluster) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	addr := c.leaderAddr
+	c.mu.Unlock()
+	if len(addr) == 0 {
+		addr = c.seed
+	}
+
+	topo, err := c.topology(ctx, addr)
+	if err != nil && addr != c.seed {
+		topo, err = c.topology(ctx, c.seed)
+	}
+	if err != nil {
+		return err
+	}
+	if leader := topo.Leader; len(leader) != 0 && leader != addr {
+		if topo, err = c.topology(ctx, leader); err != nil {
+			return err
+		}
+	}
+
+	leader := topo.Leader
+	lc, err := New(leader, c.opts)
+	if err != nil {
+		return fmt.Errorf("dial leader [%s]: %w", leader, err)
+	}
+	rcs := make([]*Client, 0, len(topo.Replicas))
+	var local []*Client
+	ramps := make(map[*Client]ramp)
+	now := time.Now()
+	for i, addr := range topo.Replicas {
+		rc, err := New(addr, c.opts)
+		if err != nil {
+			// The replica is skipped until the next refresh.
+			continue
+		}
+		rcs = append(rcs, rc)
+		if len(c.opts.Zone) != 0 && i < len(topo.Zones) && topo.Zones[i] == c.opts.Zone {
+			local = append(local, rc)
+		}
+		if i < len(topo.Weights) && topo.Weights[i] < 100 {
+			ramps[rc] = ramp{from: int(topo.Weights[i]), start: now, end: now.Add(topo.Ramps[i])}
+		}
+	}
+
+	c.mu.Lock()
+	old, oldReplicas := c.leader, c.replicas
+	c.leaderAddr, c.leader, c.replicas, c.local = leader, lc, rcs, local
+	c.ramps = ramps
+	c.mu.Unlock()
+
+	if old != nil {
+		_ = old.Close()
+	}
+	for _, rc := range oldReplicas {
+		_ = rc.Close()
+	}
+	return nil
+}
+
+// topology asks the node at addr for the topology of its cluster.
+func (c *Cluster) topology(ctx context.Context, addr string) (*proto.ResponseTopology, error) {
+	nc, err := New(addr, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	defer nc.Close()
+
+	return nc.topology(ctx)
+}
+
+// list returns the clients of the leader and of every replica.
+func (c *Cluster) list() []*Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*Client{c.leader}, c.replicas...)
+}
+
+// dead drops the client of a replica that no longer answers the keepalive
+// pings until the next refresh, and refreshes the topology if it is the
+// client of the leader.
+func (c *Cluster) dead(cl *Client) {
+	c.mu.Lock()
+	if cl == c.leader {
+		c.mu.Unlock()
+		_ = c.Refresh(context.Background())
+		return
+	}
+	isClient := func(rc *Client) bool { return rc == cl }
+	c.replicas = slices.DeleteFunc(c.replicas, isClient)
+	c.local = slices.DeleteFunc(c.local, isClient)
+	delete(c.ramps, cl)
+	c.mu.Unlock()
+
+	_ = cl.Close()
+}
+
+// degraded is the Health of a replica the reads are moved off of while
+// other replicas are healthy.
+const degraded = proto.HealthSyncing | proto.HealthHighMemory
+
+// ramp is the weight of a replica warming up, which rises linearly from
+// from at start to 100 at end.
+type ramp struct {
+	from       int
+	start, end time.Time
+}
+
+// weight returns the weight of the replica as of now, in percent.
+func (r ramp) weight(now time.Time) int {
+	if !now.Before(r.end) {
+		return 100
+	}
+	elapsed, total := now.Sub(r.start), r.end.Sub(r.start)
+	return r.from + int(int64(100-r.from)*int64(elapsed)/int64(total))
+}
+
+// reader returns the client to send the next read to. The replicas that
+// reported they are syncing or short of memory are skipped, in favor of a
+// replica in another zone if need be, unless all of them did. A replica
+// warming up takes a read with the odds of its weight; if none of them
+// took it, it goes to the leader.
+func (c *Cluster) reader() *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	next := c.next.Add(1)
+	now := time.Now()
+	warming := false
+	for _, replicas := range [][]*Client{c.local, c.replicas} {
+		rc, cold := c.healthy(replicas, next, now)
+		if rc != nil {
+			return rc
+		}
+		warming = warming || cold
+	}
+	if warming {
+		return c.leader
+	}
+
+	replicas := c.local
+	if len(replicas) == 0 {
+		replicas = c.replicas
+	}
+	if len(replicas) == 0 {
+		return c.leader
+	}
+	return replicas[next%uint64(len(replicas))]
+}
+
+// healthy returns the first replica not degraded that takes the read,
+// starting from the next one in turn, or nil if there is none. cold reports
+// whether a replica warming up passed on it.
+func (c *Cluster) healthy(replicas []*Client, next uint64, now time.Time) (rc *Client, cold bool) {
+	for i := range replicas {
+		rc := replicas[(next+uint64(i))%uint64(len(replicas))]
+		if rc.Health()&degraded != 0 {
+			continue
+		}
+		if r, ok := c.ramps[rc]; ok && rand.Intn(100) >= r.weight(now) {
+			cold = true
+			continue
+		}
+		return rc, false
+	}
+	return nil, cold
+}
+
+// writer returns the client of the leader.
+func (c *Cluster) writer() *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.leader
+}
+
+// write sends a write to the leader, and once more to the new leader after a
+// refresh if it answers StatusMoved.
+func (c *Cluster) write(ctx context.Context, fn func(*Client) error) error {
+	err := fn(c.writer())
+
+	var redirect *proto.Redirect
+	if !errors.As(err, &redirect) || redirect.Status != proto.StatusMoved {
+		return err
+	}
+	if err := c.Refresh(ctx); err != nil {
+		return err
+	}
+	return fn(c.writer())
+}
+
+// read sends a read to a replica, and to the leader if the replica answers
+// StatusMoved, as a replica does for a read of a Session it is behind.
+func (c *Cluster) read(fn func(*Client) error) error {
+	err := fn(c.reader())
+
+	var redirect *proto.Redirect
+	if !errors.As(err, &redirect) || redirect.Status != proto.StatusMoved {
+		return err
+	}
+	return fn(c.writer())
+}
+
+func (c *Cluster) Get(ctx context.Context, key []byte) ([]byte, error) {
+	var value []byte
+	err := c.read(func(cl *Client) error {
+		var err error
+		value, err = cl.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+// GetRange reads part of a value like Client.GetRange.
+func (c *Cluster) GetRange(ctx context.Context, key []byte, offset, length int) ([]byte, error) {
+	var value []byte
+	err := c.read(func(cl *Client) error {
+		var err error
+		value, err = cl.GetRange(ctx, key, offset, length)
+		return err
+	})
+	return value, err
+}
+
+// MGet reads the values of the keys like Client.MGet.
+func (c *Cluster) MGet(ctx context.Context, keys [][]byte) ([][]byte, bool, error) {
+	var (
+		values    [][]byte
+		truncated bool
+	)
+	err := c.read(func(cl *Client) error {
+		var err error
+		values, truncated, err = cl.MGet(ctx, keys)
+		return err
+	})
+	return values, truncated, err
+}
+
+func (c *Cluster) Set(ctx context.Context, key []byte, value []byte, ttl time.Duration) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.Set(ctx, key, value, ttl)
+	})
+}
+
+// SetNoReply sets a key on the leader like Client.SetNoReply. Unlike Set it
+// is not sent again after a change of leader, which it does not hear of.
+func (c *Cluster) SetNoReply(ctx context.Context, key []byte, value []byte, ttl time.Duration) error {
+	return c.writer().SetNoReply(ctx, key, value, ttl)
+}
+
+// DeleteNoReply deletes a key on the leader like Client.DeleteNoReply.
+func (c *Cluster) DeleteNoReply(ctx context.Context, key []byte) error {
+	return c.writer().DeleteNoReply(ctx, key)
+}
+
+func (c *Cluster) Delete(ctx context.Context, key []byte) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.Delete(ctx, key)
+	})
+}
+
+// Touch resets the TTL of an existing key like Client.Touch.
+func (c *Cluster) Touch(ctx context.Context, key []byte, ttl time.Duration) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.Touch(ctx, key, ttl)
+	})
+}
+
+// Append appends data to the value of an existing key like Client.Append.
+func (c *Cluster) Append(ctx context.Context, key, data []byte) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.Append(ctx, key, data)
+	})
+}
+
+// SetIf sets the key if the condition holds, like Client.SetIf.
+func (c *Cluster) SetIf(ctx context.Context, key, value []byte, ttl time.Duration, ifMatch, ifNoneMatch string) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.SetIf(ctx, key, value, ttl, ifMatch, ifNoneMatch)
+	})
+}
+
+// DeleteIfValue deletes the key if it holds the expected value, like
+// Client.DeleteIfValue.
+func (c *Cluster) DeleteIfValue(ctx context.Context, key, expected []byte) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.DeleteIfValue(ctx, key, expected)
+	})
+}
+
+// DeleteIfVersion deletes the key if its value has the ETag version, like
+// Client.DeleteIfVersion.
+func (c *Cluster) DeleteIfVersion(ctx context.Context, key []byte, version string) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.DeleteIfVersion(ctx, key, version)
+	})
+}
+
+// Rename moves the value of oldKey to newKey like Client.Rename.
+func (c *Cluster) Rename(ctx context.Context, oldKey, newKey []byte) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.Rename(ctx, oldKey, newKey)
+	})
+}
+
+// Copy stores the value of src under dst like Client.Copy.
+func (c *Cluster) Copy(ctx context.Context, src, dst []byte, ttl time.Duration) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.Copy(ctx, src, dst, ttl)
+	})
+}
+
+// Flush deletes the keys matching the pattern on the leader like
+// Client.Flush, which replicates the deletes.
+func (c *Cluster) Flush(ctx context.Context, pattern string, dryRun bool) (int, error) {
+	var n int
+	err := c.write(ctx, func(cl *Client) error {
+		var err error
+		n, err = cl.Flush(ctx, pattern, dryRun)
+		return err
+	})
+	return n, err
+}
+
+// Undelete restores the deleted keys matching the pattern on the leader like
+// Client.Undelete, which replicates the restores.
+func (c *Cluster) Undelete(ctx context.Context, pattern string) (int, error) {
+	var n int
+	err := c.write(ctx, func(cl *Client) error {
+		var err error
+		n, err = cl.Undelete(ctx, pattern)
+		return err
+	})
+	return n, err
+}
+
+// Purge deletes the keys written before the time on the leader like
+// Client.Purge, which replicates the deletes.
+func (c *Cluster) Purge(ctx context.Context, before time.Time, prefix string) (int, error) {
+	var n int
+	err := c.write(ctx, func(cl *Client) error {
+		var err error
+		n, err = cl.Purge(ctx, before, prefix)
+		return err
+	})
+	return n, err
+}
+
+// Multi applies SET and DEL commands atomically on the leader like
+// Client.Multi.
+func (c *Cluster) Multi(ctx context.Context, cmds []proto.Appender) error {
+	return c.write(ctx, func(cl *Client) error {
+		return cl.Multi(ctx, cmds)
+	})
+}
+
+// XAdd adds an entry to a stream on the leader like Client.XAdd.
+func (c *Cluster) XAdd(ctx context.Context, key, value []byte, maxLen int, ttl time.Duration) (proto.StreamID, error) {
+	var id proto.StreamID
+	err := c.write(ctx, func(cl *Client) error {
+		var err error
+		id, err = cl.XAdd(ctx, key, value, maxLen, ttl)
+		return err
+	})
+	return id, err
+}
+
+// XRange reads the entries of a stream like Client.XRange.
+func (c *Cluster) XRange(ctx context.Context, key []byte, start, end proto.StreamID, count int) ([]proto.StreamEntry, error) {
+	var entries []proto.StreamEntry
+	err := c.read(func(cl *Client) error {
+		var err error
+		entries, err = cl.XRange(ctx, key, start, end, count)
+		return err
+	})
+	return entries, err
+}
+
+// Close closes the connections to the leader and the replicas.
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.quit != nil {
+		close(c.quit)
+		c.quit = nil
+	}
+	err := c.leader.Close()
+	for _, rc := range c.replicas {
+		_ = rc.Close()
+	}
+	c.replicas = nil
+	return err
+}
@@ -0,0 +1,37 @@
+//go:build go1.23
+
+package client
+
+import (
+	"context"
+	"iter"
+)
+
+// ScanIter returns an iterator over every key of the server, in order,
+// fetched a page of Scan at a time as the loop goes. A failed Scan, or the
+// context being done, is yielded as the error of a last iteration.
+func (c *Client) ScanIter(ctx context.Context) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		var after []byte
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			keys, err := c.Scan(ctx, "*", after, 0)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if len(keys) == 0 {
+				return
+			}
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return
+				}
+			}
+			after = keys[len(keys)-1]
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// DefaultPingTimeout is how long a keepalive PING waits for its answer if
+// Options.PingTimeout is not set.
+const DefaultPingTimeout = time.Second
+
+// Ping checks that the server still answers on the connection. It fails
+// with ErrTimeout if it does not before the deadline of ctx, if any, after
+// which the connection is unusable and should be closed.
+func (c *Client) Ping(ctx context.Context) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	// The deadline of the connection bounds the wait on a dead peer, which
+	// would otherwise last until TCP gives up on it.
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+		defer func() { _ = c.conn.SetDeadline(time.Time{}) }()
+	}
+
+	if err := proto.WriteMessage(c.conn, &proto.CommandPing{}); err != nil {
+		return timeoutError(err)
+	}
+	resp, err := proto.ParseSetResponse(c.conn)
+	if err != nil {
+		return timeoutError(err)
+	}
+	if resp.Status != proto.StatusOK {
+		return statusError(resp.Status, nil)
+	}
+	return nil
+}
+
+// idle returns how long the connection has not been used by a command.
+func (c *Client) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, c.used.Load()))
+}
+
+// keepAlive pings the clients listed by clients that were idle for the
+// KeepAlive of opts, every KeepAlive, and hands those that fail to dead
+// until quit is closed.
+func keepAlive(opts Options, clients func() []*Client, dead func(*Client), quit <-chan struct{}) {
+	timeout := opts.PingTimeout
+	if timeout <= 0 {
+		timeout = DefaultPingTimeout
+	}
+	ticker := time.NewTicker(opts.KeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case now := <-ticker.C:
+			for _, c := range clients() {
+				if c.idle(now) < opts.KeepAlive {
+					continue
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				err := c.Ping(ctx)
+				cancel()
+				// A ping shed as commands queued up since is no sign of
+				// a dead connection.
+				if err != nil && !errors.Is(err, ErrOverloaded) {
+					dead(c)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// Key joins the parts of a key with ":", e.g. Key("user", "1", "cart") is
+// "user:1:cart".
+func Key(parts ...string) []byte {
+	return []byte(strings.Join(parts, ":"))
+}
+
+// TaggedKey returns the key of the parts like Key, after the hash tag tag,
+// e.g. TaggedKey("user:1", "cart") is "{user:1}:cart". The keys with the
+// same tag are placed on the same node and can be sent in one Multi. The tag
+// must not contain "}".
+func TaggedKey(tag string, parts ...string) []byte {
+	key := make([]byte, 0, len(tag)+2+len(parts)*8)
+	key = append(key, '{')
+	key = append(key, tag...)
+	key = append(key, '}')
+	for _, part := range parts {
+		key = append(key, ':')
+		key = append(key, part...)
+	}
+	return key
+}
+
+// scope returns the key as sent to the server, with the KeyPrefix of the
+// client.
+func (c *Client) scope(key []byte) []byte {
+	if len(c.prefix) == 0 {
+		return key
+	}
+	scoped := make([]byte, 0, len(c.prefix)+len(key))
+	scoped = append(scoped, c.prefix...)
+	return append(scoped, key...)
+}
+
+// unscope returns the key as known to the caller, without the KeyPrefix of
+// the client.
+func (c *Client) unscope(key []byte) []byte {
+	return bytes.TrimPrefix(key, c.prefix)
+}
+
+// scopePattern returns the glob pattern matching the keys of the pattern
+// under the KeyPrefix of the client, its bytes escaped so they match
+// themselves.
+func (c *Client) scopePattern(pattern []byte) []byte {
+	if len(c.prefix) == 0 {
+		return pattern
+	}
+	scoped := make([]byte, 0, 2*len(c.prefix)+len(pattern))
+	for _, b := range c.prefix {
+		if b == '*' || b == '?' || b == '\\' {
+			scoped = append(scoped, '\\')
+		}
+		scoped = append(scoped, b)
+	}
+	return append(scoped, pattern...)
+}
+
+// scoped returns a copy of the command with its keys, channels and patterns
+// under the KeyPrefix of the client. The command of the caller is left as
+// it is, so it can be sent again.
+func (c *Client) scoped(cmd proto.Appender) proto.Appender {
+	if len(c.prefix) == 0 {
+		return cmd
+	}
+
+	switch v := cmd.(type) {
+	case *proto.CommandSet:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandGet:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandDel:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandDelIf:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandTouch:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandAppend:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandGetRange:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandMGet:
+		keys := make([][]byte, len(v.Keys))
+		for i, key := range v.Keys {
+			keys[i] = c.scope(key)
+		}
+		return &proto.CommandMGet{Keys: keys}
+	case *proto.CommandSetIf:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandGetLease:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandSetLease:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandRename:
+		cp := *v
+		cp.Key, cp.NewKey = c.scope(v.Key), c.scope(v.NewKey)
+		return &cp
+	case *proto.CommandCopy:
+		cp := *v
+		cp.Key, cp.Dst = c.scope(v.Key), c.scope(v.Dst)
+		return &cp
+	case *proto.CommandFill:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandXAdd:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandXRange:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandXRead:
+		cp := *v
+		cp.Key = c.scope(v.Key)
+		return &cp
+	case *proto.CommandPublish:
+		// Channels are scoped like keys.
+		cp := *v
+		cp.Channel = c.scope(v.Channel)
+		return &cp
+	case *proto.CommandSubscribe:
+		channels := make([][]byte, len(v.Channels))
+		for i, channel := range v.Channels {
+			channels[i] = c.scope(channel)
+		}
+		return &proto.CommandSubscribe{Channels: channels}
+	case *proto.CommandFlush:
+		cp := *v
+		cp.Pattern = c.scopePattern(v.Pattern)
+		return &cp
+	case *proto.CommandUndelete:
+		cp := *v
+		cp.Pattern = c.scopePattern(v.Pattern)
+		return &cp
+	case *proto.CommandPurge:
+		cp := *v
+		cp.Prefix = c.scope(v.Prefix)
+		return &cp
+	case *proto.CommandScan:
+		cp := *v
+		cp.Pattern = c.scopePattern(v.Pattern)
+		if len(v.After) != 0 {
+			cp.After = c.scope(v.After)
+		}
+		return &cp
+	case *proto.CommandBatch:
+		return &proto.CommandBatch{Commands: c.scopedAll(v.Commands)}
+	case *proto.CommandMulti:
+		return &proto.CommandMulti{Commands: c.scopedAll(v.Commands)}
+	case *proto.CommandNoReply:
+		return &proto.CommandNoReply{Command: c.scoped(v.Command)}
+	default:
+		// The other commands carry no key.
+		return cmd
+	}
+}
+
+func (c *Client) scopedAll(cmds []proto.Appender) []proto.Appender {
+	scoped := make([]proto.Appender, len(cmds))
+	for i, cmd := range cmds {
+		scoped[i] = c.scoped(cmd)
+	}
+	return scoped
+}
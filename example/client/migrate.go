@@ -0,0 +1,194 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// Store is the subset of the commands of a Client or a Cluster that a
+// Migration sends to either side.
+type Store interface {
+	Get(ctx context.Context, key []byte) ([]byte, error)
+	Set(ctx context.Context, key []byte, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key []byte) error
+}
+
+// CacherStore adapts a ggcache.Cacher, such as the Redis one of the
+// cache/redis package, to a Store, e.g. to migrate away from it.
+func CacherStore(c ggcache.Cacher) Store {
+	return cacherStore{c}
+}
+
+type cacherStore struct {
+	c ggcache.Cacher
+}
+
+func (s cacherStore) Get(_ context.Context, key []byte) ([]byte, error) {
+	return s.c.Get(key)
+}
+
+func (s cacherStore) Set(_ context.Context, key []byte, value []byte, ttl time.Duration) error {
+	return s.c.Set(key, value, ttl)
+}
+
+func (s cacherStore) Delete(_ context.Context, key []byte) error {
+	return s.c.Delete(key)
+}
+
+type MigrationOptions struct {
+	// BackfillTTL, if set, stores the values read from the old side on a
+	// miss of the new one in the new one too, with that TTL, as the TTL
+	// they have left on the old side is not known.
+	BackfillTTL time.Duration
+
+	// CompareEvery, if set, also reads one of every CompareEvery keys found
+	// on the new side from the old one, counting those whose values differ
+	// in MigrationStats.
+	CompareEvery int
+}
+
+// Migration moves the live traffic of a cache from an old cluster to a new
+// one: the writes go to both, the old one first as it stays the source of
+// truth until the migration is done, and the reads go to the new one,
+// falling back to the old one on a miss. A write the old side fails is not
+// sent to the new one; one the new side fails is only counted, as the old
+// side has it. Migration is safe for concurrent use.
+type Migration struct {
+	old, new Store
+	opts     MigrationOptions
+
+	reads     atomic.Uint64
+	fallbacks atomic.Uint64
+	backfills atomic.Uint64
+	compared  atomic.Uint64
+	differed  atomic.Uint64
+	writes    atomic.Uint64
+	diverged  atomic.Uint64
+	newErrors atomic.Uint64
+}
+
+// MigrationStats counts the commands of a Migration.
+type MigrationStats struct {
+	// Reads counts the reads, of which Fallbacks were served by the old
+	// side as the new one missed or failed, Backfills were then stored on
+	// the new side, and Compared were checked against the old side, which
+	// Differed from.
+	Reads     uint64
+	Fallbacks uint64
+	Backfills uint64
+	Compared  uint64
+	Differed  uint64
+
+	// Writes counts the writes, of which Diverged only succeeded on the
+	// old side. NewErrors counts every command the new side failed.
+	Writes    uint64
+	Diverged  uint64
+	NewErrors uint64
+}
+
+// NewMigration creates a Migration from old to new.
+func NewMigration(old, new Store, opts MigrationOptions) *Migration {
+	return &Migration{old: old, new: new, opts: opts}
+}
+
+// Get reads a key from the new side, and from the old one if the new one
+// does not have it or fails.
+func (m *Migration) Get(ctx context.Context, key []byte) ([]byte, error) {
+	n := m.reads.Add(1)
+	value, err := m.new.Get(ctx, key)
+	if err == nil {
+		if every := m.opts.CompareEvery; every > 0 && n%uint64(every) == 0 {
+			m.compare(ctx, key, value)
+		}
+		return value, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		m.newErrors.Add(1)
+	}
+
+	value, err = m.old.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	m.fallbacks.Add(1)
+	if m.opts.BackfillTTL > 0 {
+		if err := m.new.Set(ctx, key, value, m.opts.BackfillTTL); err != nil {
+			m.newErrors.Add(1)
+		} else {
+			m.backfills.Add(1)
+		}
+	}
+	return value, nil
+}
+
+// compare checks the value read from the new side against the old one. A
+// key the old side does not have, e.g. as it expired there first, counts
+// as differing too.
+func (m *Migration) compare(ctx context.Context, key, value []byte) {
+	old, err := m.old.Get(ctx, key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return
+	}
+	m.compared.Add(1)
+	if err != nil || !bytes.Equal(old, value) {
+		m.differed.Add(1)
+	}
+}
+
+// Set writes a key to the old side, and then to the new one.
+func (m *Migration) Set(ctx context.Context, key []byte, value []byte, ttl time.Duration) error {
+	m.writes.Add(1)
+	if err := m.old.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	m.wrote(m.new.Set(ctx, key, value, ttl))
+	return nil
+}
+
+// Delete deletes a key from the old side, and then from the new one. A key
+// only one side has is deleted there.
+func (m *Migration) Delete(ctx context.Context, key []byte) error {
+	m.writes.Add(1)
+	oldErr := m.old.Delete(ctx, key)
+	if oldErr != nil && !errors.Is(oldErr, ErrKeyNotFound) {
+		return oldErr
+	}
+	newErr := m.new.Delete(ctx, key)
+	switch {
+	case newErr == nil:
+		return nil
+	case errors.Is(newErr, ErrKeyNotFound):
+		// The key is only missing if neither side had it.
+		return oldErr
+	default:
+		m.wrote(newErr)
+		return oldErr
+	}
+}
+
+// wrote counts the outcome of a write to the new side the old one took.
+func (m *Migration) wrote(err error) {
+	if err != nil {
+		m.diverged.Add(1)
+		m.newErrors.Add(1)
+	}
+}
+
+// Stats returns the counters of the migration.
+func (m *Migration) Stats() MigrationStats {
+	return MigrationStats{
+		Reads:     m.reads.Load(),
+		Fallbacks: m.fallbacks.Load(),
+		Backfills: m.backfills.Load(),
+		Compared:  m.compared.Load(),
+		Differed:  m.differed.Load(),
+		Writes:    m.writes.Load(),
+		Diverged:  m.diverged.Load(),
+		NewErrors: m.newErrors.Load(),
+	}
+}
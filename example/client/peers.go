@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/anthdm/ggcache"
+)
+
+// Peers is a ggcache.PeerPicker over a fixed set of nodes, assigning keys
+// to them with a ggcache.Router, consistent hashing by default. Connections to peers are opened on first
+// use and reopened after they fail, or after they no longer answer the
+// keepalive pings sent with Options.KeepAlive.
+type Peers struct {
+	self   string
+	opts   Options
+	router ggcache.Router
+
+	mu      sync.Mutex
+	clients map[string]*Client
+
+	// quit stops the keepalive pings, nil once closed or without them.
+	quit chan struct{}
+}
+
+// NewPeers creates a PeerPicker for the nodes at addrs. Self is the address
+// of the local node as it appears in addrs; its keys are loaded locally.
+func NewPeers(self string, addrs []string, opts Options) *Peers {
+	ring := ggcache.NewHashRing(0)
+	ring.Add(addrs...)
+	return NewRoutedPeers(self, ring, opts)
+}
+
+// NewRoutedPeers creates a PeerPicker for the nodes the router assigns the
+// keys to, e.g. a ggcache.Rendezvous, or a ggcache.PrefixRouter pinning
+// prefixes to given nodes. Self is the address of the local node as the
+// router returns it; its keys are loaded locally.
+func NewRoutedPeers(self string, router ggcache.Router, opts Options) *Peers {
+	p := &Peers{
+		self:    self,
+		opts:    opts,
+		router:  router,
+		clients: make(map[string]*Client),
+	}
+	if opts.KeepAlive > 0 {
+		p.quit = make(chan struct{})
+		go keepAlive(opts, p.list, p.dead, p.quit)
+	}
+	return p
+}
+
+// PickPeer returns the client of the node owning the key, or false if the
+// local node owns it or the owner cannot be reached.
+func (p *Peers) PickPeer(key []byte) (ggcache.PeerGetter, bool) {
+	addr := p.router.Get(key)
+	if len(addr) == 0 || addr == p.self {
+		return nil, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.clients[addr]; ok {
+		return peer{p, addr, c}, true
+	}
+	c, err := New(addr, p.opts)
+	if err != nil {
+		return nil, false
+	}
+	p.clients[addr] = c
+	return peer{p, addr, c}, true
+}
+
+// Close closes the connections to every peer.
+func (p *Peers) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.quit != nil {
+		close(p.quit)
+		p.quit = nil
+	}
+	for addr, c := range p.clients {
+		_ = c.Close()
+		delete(p.clients, addr)
+	}
+	return nil
+}
+
+// list returns the clients of the peers currently connected.
+func (p *Peers) list() []*Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clients := make([]*Client, 0, len(p.clients))
+	for _, c := range p.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// dead forgets the client of a peer that no longer answers the keepalive
+// pings, like drop.
+func (p *Peers) dead(c *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, pc := range p.clients {
+		if pc == c {
+			delete(p.clients, addr)
+			_ = c.Close()
+		}
+	}
+}
+
+// drop forgets the client of a peer after it failed, so the next pick dials
+// the peer again.
+func (p *Peers) drop(addr string, c *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.clients[addr] == c {
+		delete(p.clients, addr)
+		_ = c.Close()
+	}
+}
+
+type peer struct {
+	peers *Peers
+	addr  string
+	*Client
+}
+
+func (p peer) Fill(ctx context.Context, key []byte) ([]byte, error) {
+	value, err := p.Client.Fill(ctx, key)
+	if err != nil {
+		p.peers.drop(p.addr, p.Client)
+	}
+	return value, err
+}
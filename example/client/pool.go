@@ -0,0 +1,282 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPoolSize is the most connections a Pool opens if its size is not
+// set.
+const DefaultPoolSize = 8
+
+// ErrDraining is returned by Pool.Get once the pool is drained or closed.
+var ErrDraining = errors.New("pool is draining")
+
+// Pool hands out up to a fixed number of Clients of a node, so the commands
+// of concurrent callers are not serialized on a single connection. The
+// connections are opened on first use and kept once returned with Put.
+//
+// The Options of the pool apply to it as they do to a Client: MaxWaiting
+// and MaxWait shed the Gets that would wait behind that many others, or for
+// that long, with ErrOverloaded, and KeepAlive pings the idle connections,
+// dropping those that do not answer.
+type Pool struct {
+	endpoint string
+	opts     Options
+
+	// slots holds a token for every Client handed out.
+	slots chan struct{}
+
+	mu       sync.Mutex
+	idle     []*Client
+	inUse    int
+	draining bool
+	// quit is closed once draining, which wakes the Gets waiting and stops
+	// the keepalive pings, and drained once no Client is handed out.
+	quit    chan struct{}
+	drained chan struct{}
+
+	waiting  atomic.Int64
+	waits    atomic.Uint64
+	waitTime atomic.Int64
+	shed     atomic.Uint64
+}
+
+// PoolStats describe the connections of a Pool and how long callers waited
+// for them, a measure of the pressure on the pool.
+type PoolStats struct {
+	// InUse are the connections handed out, Idle those kept for the next
+	// Get.
+	InUse int
+	Idle  int
+	// Waiting are the Gets waiting for a connection, as InUse reached the
+	// size of the pool.
+	Waiting int
+	// Waits counts the Gets that had to wait, and WaitTime is the time they
+	// waited in total.
+	Waits    uint64
+	WaitTime time.Duration
+	// Shed counts the Gets that failed with ErrOverloaded.
+	Shed uint64
+}
+
+// NewPool creates a Pool of up to size Clients of the node at endpoint,
+// DefaultPoolSize if size is zero, opened with the opts.
+func NewPool(endpoint string, opts Options, size int) *Pool {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+	p := &Pool{
+		endpoint: endpoint,
+		opts:     opts,
+		slots:    make(chan struct{}, size),
+		quit:     make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+	if opts.KeepAlive > 0 {
+		go keepAlive(opts, p.list, p.dead, p.quit)
+	}
+	return p
+}
+
+// Get returns a Client of the pool, waiting until one is returned if they
+// are all in use, or until ctx is done. It must be handed back with Put, or
+// with Discard if it failed. It returns ErrDraining once the pool is drained
+// or closed, including to the Gets already waiting.
+func (p *Pool) Get(ctx context.Context) (*Client, error) {
+	p.mu.Lock()
+	draining := p.draining
+	p.mu.Unlock()
+	if draining {
+		return nil, ErrDraining
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		if err := p.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	if p.draining {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, ErrDraining
+	}
+	p.inUse++
+	var c *Client
+	if n := len(p.idle); n != 0 {
+		c = p.idle[n-1]
+		p.idle[n-1] = nil
+		p.idle = p.idle[:n-1]
+	}
+	p.mu.Unlock()
+
+	if c != nil {
+		return c, nil
+	}
+	c, err := New(p.endpoint, p.opts)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return c, nil
+}
+
+// wait waits for a slot of the pool, counting the wait, or sheds the Get
+// as bounded by MaxWaiting and MaxWait.
+func (p *Pool) wait(ctx context.Context) error {
+	p.waits.Add(1)
+	n := p.waiting.Add(1)
+	start := time.Now()
+	defer func() {
+		p.waiting.Add(-1)
+		p.waitTime.Add(int64(time.Since(start)))
+	}()
+	if p.opts.MaxWaiting > 0 && n > int64(p.opts.MaxWaiting) {
+		p.shed.Add(1)
+		return fmt.Errorf("%w: %d gets waiting", ErrOverloaded, n-1)
+	}
+
+	var expired <-chan time.Time
+	if p.opts.MaxWait > 0 {
+		timer := time.NewTimer(p.opts.MaxWait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-p.quit:
+		return ErrDraining
+	case <-expired:
+		p.shed.Add(1)
+		return fmt.Errorf("%w: waited %s for a connection", ErrOverloaded, p.opts.MaxWait)
+	case <-ctx.Done():
+		return timeoutError(ctx.Err())
+	}
+}
+
+// Put hands back a Client returned by Get, keeping it for the next Get.
+func (p *Pool) Put(c *Client) {
+	p.mu.Lock()
+	if !p.draining {
+		p.idle = append(p.idle, c)
+		c = nil
+	}
+	p.mu.Unlock()
+
+	if c != nil {
+		_ = c.Close()
+	}
+	p.release()
+}
+
+// Discard hands back a Client returned by Get that failed, closing it, so
+// the next Get opens a new connection.
+func (p *Pool) Discard(c *Client) {
+	_ = c.Close()
+	p.release()
+}
+
+// release frees the slot of a Client handed out.
+func (p *Pool) release() {
+	p.mu.Lock()
+	p.inUse--
+	if p.draining && p.inUse == 0 {
+		close(p.drained)
+	}
+	p.mu.Unlock()
+
+	<-p.slots
+}
+
+// list returns the idle Clients, which the keepalive pings.
+func (p *Pool) list() []*Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*Client(nil), p.idle...)
+}
+
+// dead closes an idle Client that no longer answers the keepalive pings,
+// unless it was handed out since, in which case its caller discards it.
+func (p *Pool) dead(c *Client) {
+	p.mu.Lock()
+	found := false
+	for i, ic := range p.idle {
+		if ic == c {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			found = true
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	if found {
+		_ = c.Close()
+	}
+}
+
+// Stats returns the connections of the pool and the waits for them so far.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return PoolStats{
+		InUse:    p.inUse,
+		Idle:     len(p.idle),
+		Waiting:  int(p.waiting.Load()),
+		Waits:    p.waits.Load(),
+		WaitTime: time.Duration(p.waitTime.Load()),
+		Shed:     p.shed.Load(),
+	}
+}
+
+// Drain stops handing out Clients, fails the Gets waiting with ErrDraining,
+// closes the idle Clients and waits until those in use are handed back,
+// which are then closed, or until ctx is done, so an application can finish
+// its requests before it shuts down.
+func (p *Pool) Drain(ctx context.Context) error {
+	p.stop()
+
+	select {
+	case <-p.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the idle Clients and stops handing out new ones, without
+// waiting for those in use like Drain does; they are closed once handed
+// back.
+func (p *Pool) Close() error {
+	p.stop()
+	return nil
+}
+
+// stop stops handing out Clients and closes the idle ones.
+func (p *Pool) stop() {
+	p.mu.Lock()
+	var idle []*Client
+	if !p.draining {
+		p.draining = true
+		close(p.quit)
+		idle, p.idle = p.idle, nil
+		if p.inUse == 0 {
+			close(p.drained)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		_ = c.Close()
+	}
+}
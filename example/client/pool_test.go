@@ -0,0 +1,121 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	s, c, err := server.StartEmbedded(server.ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	p := client.NewPool(s.Addr().String(), client.Options{}, 2)
+	defer p.Close()
+
+	ctx := context.Background()
+	c1, err := p.Get(ctx)
+	assert.Nil(t, err)
+	c2, err := p.Get(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, c1.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Equal(t, client.PoolStats{InUse: 2}, p.Stats())
+
+	// Every connection is in use, so the next Get waits.
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = p.Get(short)
+	assert.ErrorIs(t, err, client.ErrTimeout)
+	stats := p.Stats()
+	assert.Equal(t, uint64(1), stats.Waits)
+	assert.GreaterOrEqual(t, stats.WaitTime, 20*time.Millisecond)
+
+	// The connections handed back are reused.
+	p.Put(c1)
+	assert.Equal(t, 1, p.Stats().Idle)
+	c3, err := p.Get(ctx)
+	assert.Nil(t, err)
+	assert.Same(t, c1, c3)
+
+	p.Put(c3)
+	p.Put(c2)
+	assert.Equal(t, 2, p.Stats().Idle)
+}
+
+func TestPoolDrain(t *testing.T) {
+	s, c, err := server.StartEmbedded(server.ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	p := client.NewPool(s.Addr().String(), client.Options{}, 1)
+	ctx := context.Background()
+	c1, err := p.Get(ctx)
+	assert.Nil(t, err)
+
+	// A Get waiting for the connection in use is failed by the drain.
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := p.Get(ctx)
+		waiting <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return p.Stats().Waiting == 1
+	}, time.Second, time.Millisecond)
+	drained := make(chan error, 1)
+	go func() { drained <- p.Drain(ctx) }()
+	select {
+	case err := <-waiting:
+		assert.ErrorIs(t, err, client.ErrDraining)
+	case <-time.After(time.Second):
+		t.Fatal("the waiting Get was not woken by the drain")
+	}
+	_, err = p.Get(ctx)
+	assert.ErrorIs(t, err, client.ErrDraining)
+
+	// But the drain waits for the connection in use.
+	select {
+	case <-drained:
+		t.Fatal("drained with a connection in use")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Nil(t, c1.Ping(ctx))
+	p.Put(c1)
+	assert.Nil(t, <-drained)
+	assert.Equal(t, client.PoolStats{Waits: 1, WaitTime: p.Stats().WaitTime}, p.Stats())
+}
+
+func TestPoolOptions(t *testing.T) {
+	s, c, err := server.StartEmbedded(server.ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	p := client.NewPool(s.Addr().String(), client.Options{
+		MaxWaiting: 1,
+		MaxWait:    20 * time.Millisecond,
+		KeepAlive:  10 * time.Millisecond,
+	}, 1)
+	defer p.Close()
+
+	// The Gets over MaxWaiting, or waiting longer than MaxWait, are shed.
+	ctx := context.Background()
+	c1, err := p.Get(ctx)
+	assert.Nil(t, err)
+	_, err = p.Get(ctx)
+	assert.ErrorIs(t, err, client.ErrOverloaded)
+	assert.Equal(t, uint64(1), p.Stats().Shed)
+	p.Put(c1)
+
+	// The idle connections that no longer answer the pings are dropped.
+	assert.Equal(t, 1, p.Stats().Idle)
+	assert.Nil(t, s.Close())
+	assert.Eventually(t, func() bool {
+		return p.Stats().Idle == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// Publish sends message to the subscribers of channel on every member of the
+// cluster and returns how many subscribers of this node it was queued for.
+// Messages are not stored: a subscriber that is not connected misses them.
+func (c *Client) Publish(ctx context.Context, channel, message []byte) (int, error) {
+	cmd := &proto.CommandPublish{Channel: channel, Message: message}
+
+	if err := c.lock(); err != nil {
+		return 0, err
+	}
+	defer c.unlock()
+
+	if err := c.send(ctx, cmd); err != nil {
+		return 0, err
+	}
+
+	resp, err := proto.ParsePublishResponse(c.conn)
+	if err == nil {
+		err = c.readOffset(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if resp.Status != proto.StatusOK {
+		return 0, statusError(resp.Status, nil)
+	}
+
+	return resp.Receivers, nil
+}
+
+// Publish sends a message through the leader like Client.Publish.
+func (c *Cluster) Publish(ctx context.Context, channel, message []byte) (int, error) {
+	var n int
+	err := c.write(ctx, func(cl *Client) error {
+		var err error
+		n, err = cl.Publish(ctx, channel, message)
+		return err
+	})
+	return n, err
+}
+
+// Subscription is a connection receiving the messages published on its
+// channels. It is not safe for concurrent use.
+type Subscription struct {
+	conn net.Conn
+	// prefix is the Options.KeyPrefix stripped from the channels received.
+	prefix []byte
+}
+
+// Subscribe connects to the node at endpoint and subscribes to the channels.
+// As every message is forwarded to every member, any node of a cluster
+// will do.
+func Subscribe(endpoint string, opts Options, channels ...[]byte) (*Subscription, error) {
+	c, err := New(endpoint, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := proto.WriteMessage(c.conn, c.scoped(&proto.CommandSubscribe{Channels: channels})); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	resp, err := proto.ParseSetResponse(c.conn)
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	if resp.Status != proto.StatusOK {
+		_ = c.Close()
+		return nil, statusError(resp.Status, nil)
+	}
+
+	return &Subscription{conn: c.conn, prefix: c.prefix}, nil
+}
+
+// Receive waits for the next message. It fails once the subscription is
+// closed, or once the node dropped it for falling behind, after which
+// Subscribe has to be called again.
+func (s *Subscription) Receive() (*proto.Message, error) {
+	msg, err := proto.ParseMessage(s.conn)
+	if err != nil {
+		return nil, fmt.Errorf("receive: %w", err)
+	}
+	msg.Channel = bytes.TrimPrefix(msg.Channel, s.prefix)
+	return msg, nil
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// Session gives the commands sent with its context read-your-writes: the
+// writes record the replication offset of the leader once they are done,
+// and the reads carry it, so a follower serves them once it applied the
+// writes, or redirects them to the leader. A Session may be shared by
+// several clients, such as those of a Cluster; its zero value is ready to
+// use.
+type Session struct {
+	offset atomic.Uint64
+}
+
+// Offset returns the replication offset of the latest write of the
+// session.
+func (s *Session) Offset() uint64 {
+	return s.offset.Load()
+}
+
+// observe moves the offset of the session up to that of a write.
+func (s *Session) observe(offset uint64) {
+	for {
+		cur := s.offset.Load()
+		if offset <= cur || s.offset.CompareAndSwap(cur, offset) {
+			return
+		}
+	}
+}
+
+type sessionKey struct{}
+
+// WithSession returns a copy of ctx whose commands belong to the session.
+func WithSession(ctx context.Context, sess *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sess)
+}
+
+func sessionFrom(ctx context.Context) *Session {
+	sess, _ := ctx.Value(sessionKey{}).(*Session)
+	return sess
+}
+
+// readOffset reads the offset following the response to a write of the
+// session of ctx, if it has one.
+func (c *Client) readOffset(ctx context.Context) error {
+	sess := sessionFrom(ctx)
+	if sess == nil {
+		return nil
+	}
+	resp, err := proto.ParseOffsetResponse(c.conn)
+	if err != nil {
+		return err
+	}
+	sess.observe(resp.Offset)
+	return nil
+}
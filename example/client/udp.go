@@ -0,0 +1,94 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// defaultUDPTimeout bounds a UDP Get when the context has no deadline.
+const defaultUDPTimeout = 100 * time.Millisecond
+
+// UDPClient talks to the UDP listener of a server. Sets are fire-and-forget
+// and may be lost; Gets only return values up to the server's size cutoff.
+// It is safe for concurrent use.
+type UDPClient struct {
+	endpoint string
+	conn     net.Conn
+}
+
+// NewUDP returns a client for the UDP listener at endpoint.
+func NewUDP(endpoint string) (*UDPClient, error) {
+	conn, err := net.Dial("udp", endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDPClient{
+		endpoint: endpoint,
+		conn:     conn,
+	}, nil
+}
+
+// Set sends the key without waiting for the server. An error only means the
+// datagram could not be sent; a nil error does not mean it arrived.
+func (c *UDPClient) Set(_ context.Context, key []byte, value []byte, ttl time.Duration) error {
+	cmd := &proto.CommandSet{
+		Key:   key,
+		Value: value,
+		TTL:   int(ttl.Milliseconds()),
+	}
+
+	_, err := c.conn.Write(cmd.Bytes())
+	return err
+}
+
+// Get asks for the value of a key, waiting until the context's deadline or
+// 100ms for the answer. Each Get uses its own socket so that a late answer to
+// an earlier Get cannot be mistaken for its own.
+func (c *UDPClient) Get(ctx context.Context, key []byte) ([]byte, error) {
+	cmd := &proto.CommandGet{
+		Key: key,
+	}
+
+	conn, err := net.Dial("udp", c.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultUDPTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(cmd.Bytes()); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65507)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, timeoutError(err)
+	}
+
+	resp, err := proto.ParseGetResponse(bytes.NewReader(buf[:n]))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != proto.StatusOK {
+		return nil, statusError(resp.Status, key)
+	}
+
+	return resp.Value, nil
+}
+
+func (c *UDPClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,1336 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/cache/dense"
+	"github.com/anthdm/ggcache/cache/disk"
+	"github.com/anthdm/ggcache/cache/memcache"
+	"github.com/anthdm/ggcache/cache/rcu"
+	"github.com/anthdm/ggcache/cache/redis"
+	"github.com/anthdm/ggcache/example/server"
+	"gopkg.in/yaml.v3"
+)
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// CAFile enables mutual TLS: the clients must present a certificate
+	// signed by one of its CAs, which the node also verifies its leader
+	// with, presenting its own certificate in turn.
+	CAFile string `yaml:"ca_file,omitempty"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return len(c.CertFile) != 0 || len(c.KeyFile) != 0
+}
+
+type KubernetesConfig struct {
+	Namespace     string `yaml:"namespace,omitempty"`
+	LabelSelector string `yaml:"label_selector"`
+}
+
+type DiscoveryConfig struct {
+	DNS        string            `yaml:"dns,omitempty"`
+	Kubernetes *KubernetesConfig `yaml:"kubernetes,omitempty"`
+	// Port the peers listen on, defaults to the port of listen_addr.
+	Port     string        `yaml:"port,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+func (c DiscoveryConfig) Enabled() bool {
+	return len(c.DNS) != 0 || c.Kubernetes != nil
+}
+
+type ConsulConfig struct {
+	Addr    string `yaml:"addr"`
+	Service string `yaml:"service,omitempty"`
+}
+
+type EtcdConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	Prefix   string `yaml:"prefix,omitempty"`
+	// Election makes the nodes elect their leader through etcd.
+	Election bool `yaml:"election,omitempty"`
+}
+
+type RegistryConfig struct {
+	Consul *ConsulConfig `yaml:"consul,omitempty"`
+	Etcd   *EtcdConfig   `yaml:"etcd,omitempty"`
+}
+
+// StorageConfig selects the engine the node stores its entries in.
+type StorageConfig struct {
+	// Engine is "memory" (the default), "dense", "rcu", "disk", "redis" or
+	// "memcached". Dense is an in-memory engine with less overhead per entry
+	// for caches of many small entries. RCU is an experimental in-memory
+	// engine with lock-free reads for read-mostly workloads.
+	Engine string `yaml:"engine,omitempty"`
+	// Path is the file the disk engine keeps its log in.
+	Path string `yaml:"path,omitempty"`
+	// Addr is the upstream server of the redis and memcached engines.
+	Addr string `yaml:"addr,omitempty"`
+	// Password authenticates with the redis engine.
+	Password string `yaml:"password,omitempty"`
+	// L1TTL puts an in-memory cache in front of the engine, holding entries
+	// for at most this long.
+	L1TTL time.Duration `yaml:"l1_ttl,omitempty"`
+	// ChunkSize splits values larger than this many bytes into several
+	// entries of the engine. Zero stores every value whole.
+	ChunkSize int `yaml:"chunk_size,omitempty"`
+	// PrefixSeparator makes the dense engine intern the part of each key up
+	// to its last occurrence of this character, e.g. ":".
+	PrefixSeparator string `yaml:"prefix_separator,omitempty"`
+	// MaxIdle evicts the entries of the memory engine that are not accessed
+	// for this long, whatever their TTL.
+	MaxIdle time.Duration `yaml:"max_idle,omitempty"`
+	// ExpiredReads is how the memory engine answers the reads of an entry
+	// past its expiration that was not removed yet: "delete" (the default)
+	// misses, "stale" serves the value flagged as stale and "extend" stores
+	// it again for ExpiredExtension, zero keeping it without expiration.
+	ExpiredReads     string        `yaml:"expired_reads,omitempty"`
+	ExpiredExtension time.Duration `yaml:"expired_extension,omitempty"`
+	// Checksums makes the dense and disk engines verify a checksum of every
+	// value read, failing the read rather than serving a corrupted value.
+	Checksums bool `yaml:"checksums,omitempty"`
+}
+
+// BackupConfig uploads snapshots to S3 or Google Cloud Storage.
+type BackupConfig struct {
+	// URL is where backups are written, s3://bucket/prefix or gs://bucket/prefix.
+	URL string `yaml:"url,omitempty"`
+	// Endpoint overrides the service endpoint, e.g. for MinIO.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// Region of the bucket, us-east-1 for s3 and auto for gs if empty.
+	Region string `yaml:"region,omitempty"`
+	// AccessKey and SecretKey are the S3 credentials or GCS HMAC key.
+	AccessKey    string        `yaml:"access_key,omitempty"`
+	SecretKey    string        `yaml:"secret_key,omitempty"`
+	SessionToken string        `yaml:"session_token,omitempty"`
+	Interval     time.Duration `yaml:"interval,omitempty"`
+	Retain       int           `yaml:"retain,omitempty"`
+}
+
+// AdminConfig serves the admin HTTP endpoints.
+type AdminConfig struct {
+	// ListenAddr of the admin HTTP listener, disabled if empty.
+	ListenAddr string `yaml:"listen_addr,omitempty"`
+	// Expvar is the name the stats are published under on /debug/vars.
+	Expvar string `yaml:"expvar,omitempty"`
+	// NamespaceSeparator has /api/v1/stats count the commands per key
+	// prefix up to the separator, e.g. ":".
+	NamespaceSeparator string `yaml:"namespace_separator,omitempty"`
+	// StatsRetention keeps per-minute stats samples for this long, served
+	// on /api/v1/stats/history.
+	StatsRetention time.Duration `yaml:"stats_retention,omitempty"`
+	// RequestLog logs a sample of the commands as lines of JSON.
+	RequestLog RequestLogConfig `yaml:"request_log,omitempty"`
+}
+
+// RequestLogConfig samples the commands logged: one of every Every, or
+// the fraction of Rates for the commands it names, e.g. SET: 0.01.
+type RequestLogConfig struct {
+	Every int                `yaml:"every,omitempty"`
+	Rates map[string]float64 `yaml:"rates,omitempty"`
+}
+
+// OTLPConfig pushes metrics to an OpenTelemetry collector over OTLP/HTTP.
+type OTLPConfig struct {
+	// Endpoint is the base URL of the collector, e.g. http://localhost:4318.
+	Endpoint string            `yaml:"endpoint,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Interval time.Duration     `yaml:"interval,omitempty"`
+}
+
+// RemovalWebhookConfig posts the keys of the given prefixes, all of them if
+// none, to an HTTP endpoint in batches as they expire or are evicted. Only
+// the memory storage engine reports them.
+type RemovalWebhookConfig struct {
+	URL       string            `yaml:"url,omitempty"`
+	Headers   map[string]string `yaml:"headers,omitempty"`
+	Prefixes  []string          `yaml:"prefixes,omitempty"`
+	BatchSize int               `yaml:"batch_size,omitempty"`
+	Interval  time.Duration     `yaml:"interval,omitempty"`
+	// Retries is the number of times a failed post is retried, Backoff
+	// apart and doubling, before its batch is dropped.
+	Retries int           `yaml:"retries,omitempty"`
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+	// Buffer bounds the events waiting to be posted.
+	Buffer int `yaml:"buffer,omitempty"`
+}
+
+// WebSocketConfig serves the WebSocket gateway for browser clients.
+type WebSocketConfig struct {
+	ListenAddr string `yaml:"listen_addr,omitempty"`
+	// AllowedOrigins are the browser origins allowed to connect, "*" allows
+	// any. Empty allows any as well, as non-browser clients send none.
+	AllowedOrigins []string `yaml:"allowed_origins,omitempty"`
+}
+
+// UDPConfig serves best-effort SETs and small GETs over UDP.
+type UDPConfig struct {
+	ListenAddr string `yaml:"listen_addr,omitempty"`
+	// MaxValue is the largest value returned by a GET, 1024 if zero.
+	MaxValue int `yaml:"max_value,omitempty"`
+}
+
+// ReplicationConfig batches the mutations the leader forwards to its members.
+type ReplicationConfig struct {
+	// FlushInterval sends the pending mutations to each member in one frame
+	// this often. Zero forwards every mutation on its own.
+	FlushInterval time.Duration `yaml:"flush_interval,omitempty"`
+	// BatchBytes flushes earlier once this many bytes are pending, 1MiB if
+	// zero.
+	BatchBytes int `yaml:"batch_bytes,omitempty"`
+	// Coalesce forwards only the last SET of a key made within a flush
+	// interval in place of its earlier mutations. It requires
+	// FlushInterval.
+	Coalesce bool `yaml:"coalesce,omitempty"`
+	// RedirectWrites has followers send the clients writing to them to the
+	// leader instead of applying the writes locally.
+	RedirectWrites bool `yaml:"redirect_writes,omitempty"`
+	// ReadAddr makes a follower a read-only replica advertising this address
+	// (host:port) for reads to the cluster-aware clients. It redirects writes
+	// as with RedirectWrites.
+	ReadAddr string `yaml:"read_addr,omitempty"`
+	// FullSync has the leader stream a snapshot of its cache to the members
+	// that join, in chunks of SyncChunkBytes (64KiB if zero) at up to
+	// SyncBandwidth bytes per second (unthrottled if zero).
+	FullSync       bool  `yaml:"full_sync,omitempty"`
+	SyncBandwidth  int64 `yaml:"sync_bandwidth,omitempty"`
+	SyncChunkBytes int   `yaml:"sync_chunk_bytes,omitempty"`
+	// SessionWait is how long a follower holds a read of a session it has
+	// not caught up with before redirecting it to the leader, which it does
+	// right away if zero.
+	SessionWait time.Duration `yaml:"session_wait,omitempty"`
+	// ClockSyncInterval is how often the leader sends its clock to the
+	// members, which expire the entries it replicates by their offset from
+	// it, 10s if zero.
+	ClockSyncInterval time.Duration `yaml:"clock_sync_interval,omitempty"`
+	// SlowStart is how long the weight the leader advertises for a member
+	// that just started serving takes to ramp up to its full share of the
+	// reads of the cluster-aware clients. Zero gives it its full share
+	// right away.
+	SlowStart time.Duration `yaml:"slow_start,omitempty"`
+}
+
+// PersistenceConfig chooses what the node does while its writes cannot be
+// persisted, as the backups fail or the disk engine cannot write its log.
+type PersistenceConfig struct {
+	// OnFailure is "read_only" (the default) to keep serving reads and
+	// reject writes, or "memory_only" to keep accepting writes in memory and
+	// report the node as degraded.
+	OnFailure string `yaml:"on_failure,omitempty"`
+}
+
+// SchedulerConfig bounds the commands handled at once and shares the
+// workers between the classes of the commands queued for them.
+type SchedulerConfig struct {
+	// Workers is the number of commands handled at once. Zero handles every
+	// command as soon as it is read.
+	Workers int `yaml:"workers,omitempty"`
+	// Weights are the shares of the workers of each class while they all
+	// have commands queued.
+	Weights PriorityWeightsConfig `yaml:"weights,omitempty"`
+	// MaxHandlers and MaxInflightBytes shed the commands of the clients
+	// over that many handled or queued at once, or over that many bytes of
+	// requests, with StatusOverloaded. Zero does not bound them.
+	MaxHandlers      int   `yaml:"max_handlers,omitempty"`
+	MaxInflightBytes int64 `yaml:"max_inflight_bytes,omitempty"`
+	// MaxFieldSize is the largest key or value of the commands read, past
+	// which the connection is closed, 512MiB if zero.
+	MaxFieldSize int `yaml:"max_field_size,omitempty"`
+	// MGetBudget bounds the time spent on the keys of an MGET, which past
+	// it returns the values read so far flagged as truncated. Zero does not
+	// bound it.
+	MGetBudget time.Duration `yaml:"mget_budget,omitempty"`
+	// CommandTimeouts bound the time the commands they name, e.g. scan,
+	// mget or flush, run for, past which they are stopped with
+	// StatusTimeout. The commands not named are not bounded.
+	CommandTimeouts map[string]time.Duration `yaml:"command_timeouts,omitempty"`
+}
+
+// PriorityWeightsConfig weighs the mutations forwarded by the leader, the
+// commands of the clients and the background commands (STATS and BACKUP),
+// 8, 4 and 1 if zero.
+type PriorityWeightsConfig struct {
+	Replication int `yaml:"replication,omitempty"`
+	Client      int `yaml:"client,omitempty"`
+	Background  int `yaml:"background,omitempty"`
+}
+
+// LeaseConfig tunes the leases granted on GETLEASE misses.
+type LeaseConfig struct {
+	// TTL is how long a lease is held before another client can get one,
+	// 10s if zero.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+}
+
+// FlushConfig throttles the FLUSH of the keys matching a pattern, and keeps
+// the deleted keys for UNDELETE.
+type FlushConfig struct {
+	// BatchKeys is the number of keys deleted at a time, 1000 if zero.
+	BatchKeys int `yaml:"batch_keys,omitempty"`
+	// BatchInterval is the pause between the batches, 10ms if zero.
+	BatchInterval time.Duration `yaml:"batch_interval,omitempty"`
+	// DeleteRetention is how long the values of the keys deleted by DEL or
+	// FLUSH are kept for UNDELETE, not at all if zero.
+	DeleteRetention time.Duration `yaml:"delete_retention,omitempty"`
+}
+
+// WarmConfig is a source of records set in the cache before the node serves.
+type WarmConfig struct {
+	// File is the path of a local file, URL that of an HTTP endpoint to GET.
+	// Exactly one is required.
+	File string `yaml:"file,omitempty"`
+	URL  string `yaml:"url,omitempty"`
+	// Format is "csv" (key,value[,ttl] lines) or "jsonl" ({"key", "value",
+	// "ttl"} objects), guessed from the extension or Content-Type if empty.
+	Format string `yaml:"format,omitempty"`
+	// TTL is that of the records without one, none if zero.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+}
+
+// NamespaceConfig is the policy applied to the keys of a namespace,
+// whatever the clients writing them send.
+type NamespaceConfig struct {
+	// DefaultTTL expires the entries set without a TTL.
+	DefaultTTL time.Duration `yaml:"default_ttl,omitempty"`
+	// MinTTL and MaxTTL bound the TTLs of the writes, raising the shorter
+	// ones and lowering the longer ones, as well as the writes without a
+	// TTL, unbounded if zero.
+	MinTTL time.Duration `yaml:"min_ttl,omitempty"`
+	MaxTTL time.Duration `yaml:"max_ttl,omitempty"`
+	// MaxBytes bounds the size of the keys and values of the namespace,
+	// unbounded if zero.
+	MaxBytes int64 `yaml:"max_bytes,omitempty"`
+	// Eviction is "lru" (the default) or "lfu".
+	Eviction string `yaml:"eviction,omitempty"`
+}
+
+// TenantConfig binds an auth token to a key namespace and request quota.
+type TenantConfig struct {
+	Token string `yaml:"token,omitempty"`
+	// Tokens are more tokens of the tenant, e.g. the one its clients are
+	// being rotated to. A tenant needs at least one token.
+	Tokens []string `yaml:"tokens,omitempty"`
+	// Namespace prefixes the keys of the tenant. Empty makes it an operator
+	// with access to every key and the cluster commands.
+	Namespace string `yaml:"namespace,omitempty"`
+	// RequestsPerSecond limits the commands of the tenant, unlimited if zero,
+	// with bursts of up to Burst commands.
+	RequestsPerSecond float64 `yaml:"requests_per_second,omitempty"`
+	Burst             int     `yaml:"burst,omitempty"`
+}
+
+// tokens returns Token, if set, and Tokens.
+func (tc TenantConfig) tokens() []string {
+	if len(tc.Token) == 0 {
+		return tc.Tokens
+	}
+	return append([]string{tc.Token}, tc.Tokens...)
+}
+
+// tenants returns a server.Tenant for each token of the tenants.
+func (c *Config) tenants() []server.Tenant {
+	var tenants []server.Tenant
+	for _, tenant := range c.Tenants {
+		for _, token := range tenant.tokens() {
+			tenants = append(tenants, server.Tenant{
+				Token:             token,
+				Namespace:         tenant.Namespace,
+				RequestsPerSecond: tenant.RequestsPerSecond,
+				Burst:             tenant.Burst,
+			})
+		}
+	}
+	return tenants
+}
+
+// AuthConfig picks the provider verifying the auth tokens: signed tokens
+// or an external service. At most one may be set, and not along with
+// tenants.
+type AuthConfig struct {
+	HMAC HMACAuthConfig `yaml:"hmac,omitempty"`
+	HTTP HTTPAuthConfig `yaml:"http,omitempty"`
+}
+
+// HMACAuthConfig accepts the tokens signed with Secret, which name the
+// namespace of the tenant, each with the quota of RequestsPerSecond and
+// Burst.
+type HMACAuthConfig struct {
+	Secret            string  `yaml:"secret,omitempty"`
+	RequestsPerSecond float64 `yaml:"requests_per_second,omitempty"`
+	Burst             int     `yaml:"burst,omitempty"`
+}
+
+// HTTPAuthConfig has the service at URL verify the tokens, waiting for it
+// up to Timeout, 2s if zero.
+type HTTPAuthConfig struct {
+	URL     string        `yaml:"url,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// ValidatorConfig lists the checks the values written to a namespace must
+// pass before they are stored.
+type ValidatorConfig struct {
+	// JSON requires the values to be JSON documents nested at most
+	// JSONMaxDepth deep, any depth if zero.
+	JSON         bool `yaml:"json,omitempty"`
+	JSONMaxDepth int  `yaml:"json_max_depth,omitempty"`
+	// ContentTypes are the sniffed content types allowed, e.g. text/plain or
+	// image/*.
+	ContentTypes []string `yaml:"content_types,omitempty"`
+}
+
+func (c ValidatorConfig) validator() server.Validator {
+	var validators []server.Validator
+	if c.JSON {
+		validators = append(validators, server.JSONValidator{MaxDepth: c.JSONMaxDepth})
+	}
+	if len(c.ContentTypes) != 0 {
+		validators = append(validators, server.ContentTypeValidator{Types: c.ContentTypes})
+	}
+	if len(validators) == 1 {
+		return validators[0]
+	}
+	return server.ValidatorFunc(func(key, value []byte) error {
+		for _, v := range validators {
+			if err := v.Validate(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c NamespaceConfig) policy() (ggcache.NamespacePolicy, error) {
+	policy := ggcache.NamespacePolicy{
+		DefaultTTL: c.DefaultTTL,
+		MinTTL:     c.MinTTL,
+		MaxTTL:     c.MaxTTL,
+		MaxBytes:   c.MaxBytes,
+	}
+	switch c.Eviction {
+	case "", "lru":
+		policy.Eviction = ggcache.EvictLRU
+	case "lfu":
+		policy.Eviction = ggcache.EvictLFU
+	default:
+		return policy, fmt.Errorf("unknown eviction policy [%s]", c.Eviction)
+	}
+	return policy, nil
+}
+
+func (c BackupConfig) Enabled() bool {
+	return len(c.URL) != 0
+}
+
+// Backups returns the backups stored at rawURL, using the endpoint and
+// credentials of the configuration.
+func (c BackupConfig) Backups(rawURL string) (*server.Backups, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &server.S3{
+		Endpoint:     c.Endpoint,
+		Region:       c.Region,
+		Bucket:       u.Host,
+		AccessKey:    c.AccessKey,
+		SecretKey:    c.SecretKey,
+		SessionToken: c.SessionToken,
+	}
+	switch u.Scheme {
+	case "s3":
+		if len(store.Region) == 0 {
+			store.Region = "us-east-1"
+		}
+		if len(store.Endpoint) == 0 {
+			store.Endpoint = "https://s3." + store.Region + ".amazonaws.com"
+		}
+	case "gs":
+		if len(store.Region) == 0 {
+			store.Region = "auto"
+		}
+		if len(store.Endpoint) == 0 {
+			store.Endpoint = "https://storage.googleapis.com"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported backup url [%s], expected s3:// or gs://", rawURL)
+	}
+	if len(store.Bucket) == 0 {
+		return nil, fmt.Errorf("backup url [%s] has no bucket", rawURL)
+	}
+
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if len(prefix) != 0 && !strings.HasSuffix(prefix, "/") && !strings.HasSuffix(prefix, ".ggsnap") {
+		prefix += "/"
+	}
+
+	return &server.Backups{
+		Store:    store,
+		Prefix:   prefix,
+		Interval: c.Interval,
+		Retain:   c.Retain,
+	}, nil
+}
+
+type Config struct {
+	ListenAddr    string            `yaml:"listen_addr"`
+	LeaderAddr    string            `yaml:"leader_addr,omitempty"`
+	AdvertiseAddr string            `yaml:"advertise_addr,omitempty"`
+	AllowCIDRs    []string          `yaml:"allow_cidrs,omitempty"`
+	TLS           TLSConfig         `yaml:"tls,omitempty"`
+	Discovery     DiscoveryConfig   `yaml:"discovery,omitempty"`
+	Registry      RegistryConfig    `yaml:"registry,omitempty"`
+	Storage       StorageConfig     `yaml:"storage,omitempty"`
+	Backup        BackupConfig      `yaml:"backup,omitempty"`
+	Admin         AdminConfig       `yaml:"admin,omitempty"`
+	OTLP          OTLPConfig        `yaml:"otlp,omitempty"`
+	WebSocket     WebSocketConfig   `yaml:"websocket,omitempty"`
+	UDP           UDPConfig         `yaml:"udp,omitempty"`
+	Replication   ReplicationConfig `yaml:"replication,omitempty"`
+	Leases        LeaseConfig       `yaml:"leases,omitempty"`
+	Flush         FlushConfig       `yaml:"flush,omitempty"`
+	Persistence   PersistenceConfig `yaml:"persistence,omitempty"`
+	Scheduler     SchedulerConfig   `yaml:"scheduler,omitempty"`
+	// RemovalWebhook posts the keys that expire or are evicted.
+	RemovalWebhook RemovalWebhookConfig `yaml:"removal_webhook,omitempty"`
+	// Namespaces are the policies of the key namespaces by name, split at
+	// admin.namespace_separator or ":". The keys of a tenant are in the
+	// namespace of the tenant.
+	Namespaces map[string]NamespaceConfig `yaml:"namespaces,omitempty"`
+	// Tenants require clients to authenticate with one of their tokens.
+	// AuthToken is the operator token this node authenticates to its leader
+	// with.
+	Tenants   []TenantConfig `yaml:"tenants,omitempty"`
+	AuthToken string         `yaml:"auth_token,omitempty"`
+	// Auth verifies the tokens with a provider in place of the tenants.
+	Auth AuthConfig `yaml:"auth,omitempty"`
+	// ClusterSecret is required of the followers joining this node and
+	// sent to its leader, the same on every node.
+	ClusterSecret string `yaml:"cluster_secret,omitempty"`
+	// Validators check the values written to the key namespaces by name,
+	// "_default" being that of the keys without a namespace.
+	Validators map[string]ValidatorConfig `yaml:"validators,omitempty"`
+	// Warm fills the cache from the sources in order on startup, so the
+	// node comes up warm.
+	Warm []WarmConfig `yaml:"warm,omitempty"`
+	// AcceptLoops is the number of listeners bound to listen_addr with
+	// SO_REUSEPORT, each accepted from in its own loop, one if zero. Linux,
+	// macOS and FreeBSD only.
+	AcceptLoops int `yaml:"accept_loops,omitempty"`
+	// HandoffPath is the unix socket the node hands its dataset over to the
+	// process replacing it on the same host, and takes it from on startup.
+	HandoffPath string `yaml:"handoff_path,omitempty"`
+	// Zone is the availability zone of the node, reported to the clients in
+	// the topology so they can read from the replicas of their own zone.
+	Zone string `yaml:"zone,omitempty"`
+	// HighMemoryBytes is the size of the heap above which the node tells
+	// the clients, with every response, that it is short of memory.
+	HighMemoryBytes int64 `yaml:"high_memory_bytes,omitempty"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddr: ":3000",
+	}
+}
+
+// LoadConfig reads the YAML file at path on top of the default configuration.
+// Environment variables in the file are expanded, so values such as
+// advertise_addr: ${POD_IP}:3000 can be filled in by the orchestrator.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(b))), cfg); err != nil {
+		return nil, fmt.Errorf("parse config [%s]: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate cross-checks the configuration and reports every problem it finds
+// instead of stopping at the first one.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.ListenAddr) == 0 {
+		errs = append(errs, errors.New("listen_addr is required"))
+	} else if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen_addr: %w", err))
+	}
+
+	if c.AcceptLoops < 0 {
+		errs = append(errs, errors.New("accept_loops cannot be negative"))
+	}
+	if c.HighMemoryBytes < 0 {
+		errs = append(errs, errors.New("high_memory_bytes cannot be negative"))
+	}
+	if engine := c.Storage.Engine; len(c.HandoffPath) != 0 && ((engine != "" && engine != "memory") || c.Storage.L1TTL > 0 || len(c.Namespaces) != 0) {
+		errs = append(errs, errors.New("handoff_path: only the memory storage engine supports snapshots"))
+	}
+
+	if len(c.LeaderAddr) != 0 {
+		if _, _, err := net.SplitHostPort(c.LeaderAddr); err != nil {
+			errs = append(errs, fmt.Errorf("leader_addr: %w", err))
+		} else if sameAddr(c.LeaderAddr, c.ListenAddr) {
+			errs = append(errs, fmt.Errorf("leader_addr [%s] conflicts with listen_addr: a node cannot follow itself", c.LeaderAddr))
+		}
+	}
+
+	if c.Discovery.Enabled() {
+		if len(c.Discovery.DNS) != 0 && c.Discovery.Kubernetes != nil {
+			errs = append(errs, errors.New("discovery: dns and kubernetes are mutually exclusive"))
+		}
+		if c.Discovery.Kubernetes != nil && len(c.Discovery.Kubernetes.LabelSelector) == 0 {
+			errs = append(errs, errors.New("discovery: kubernetes requires a label_selector"))
+		}
+		if len(c.LeaderAddr) != 0 {
+			errs = append(errs, errors.New("discovery: leader_addr cannot be combined with discovery, the leader is elected"))
+		}
+		if len(c.AdvertiseAddr) == 0 {
+			errs = append(errs, errors.New("discovery: advertise_addr is required so the node can find itself among its peers"))
+		} else if _, _, err := net.SplitHostPort(c.AdvertiseAddr); err != nil {
+			errs = append(errs, fmt.Errorf("advertise_addr: %w", err))
+		}
+		if c.Discovery.Interval < 0 {
+			errs = append(errs, errors.New("discovery: interval cannot be negative"))
+		}
+	}
+
+	if c.Registry.Consul != nil && c.Registry.Etcd != nil {
+		errs = append(errs, errors.New("registry: consul and etcd are mutually exclusive"))
+	}
+	if c.Registry.Consul != nil && len(c.Registry.Consul.Addr) == 0 {
+		errs = append(errs, errors.New("registry: consul requires an addr"))
+	}
+	if c.Registry.Etcd != nil {
+		if len(c.Registry.Etcd.Endpoint) == 0 {
+			errs = append(errs, errors.New("registry: etcd requires an endpoint"))
+		}
+		if c.Registry.Etcd.Election {
+			if len(c.LeaderAddr) != 0 {
+				errs = append(errs, errors.New("registry: leader_addr cannot be combined with etcd election"))
+			}
+			if len(c.AdvertiseAddr) == 0 {
+				errs = append(errs, errors.New("registry: etcd election requires advertise_addr"))
+			}
+		}
+	}
+
+	switch c.Storage.Engine {
+	case "", "memory", "dense", "rcu":
+	case "disk":
+		if len(c.Storage.Path) == 0 {
+			errs = append(errs, errors.New("storage: the disk engine requires a path"))
+		}
+	case "redis", "memcached":
+		if len(c.Storage.Addr) == 0 {
+			errs = append(errs, fmt.Errorf("storage: the %s engine requires an addr", c.Storage.Engine))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage: unknown engine [%s]", c.Storage.Engine))
+	}
+	if c.Storage.L1TTL < 0 {
+		errs = append(errs, errors.New("storage: l1_ttl cannot be negative"))
+	}
+	if c.Storage.ChunkSize < 0 {
+		errs = append(errs, errors.New("storage: chunk_size cannot be negative"))
+	}
+	if c.Storage.MaxIdle < 0 {
+		errs = append(errs, errors.New("storage: max_idle cannot be negative"))
+	} else if c.Storage.MaxIdle > 0 && c.Storage.Engine != "" && c.Storage.Engine != "memory" {
+		errs = append(errs, errors.New("storage: max_idle requires the memory engine"))
+	}
+	switch c.Storage.ExpiredReads {
+	case "", "delete", "stale", "extend":
+		if len(c.Storage.ExpiredReads) != 0 && c.Storage.Engine != "" && c.Storage.Engine != "memory" {
+			errs = append(errs, errors.New("storage: expired_reads requires the memory engine"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage: unknown expired_reads policy [%s]", c.Storage.ExpiredReads))
+	}
+	if c.Storage.ExpiredExtension < 0 {
+		errs = append(errs, errors.New("storage: expired_extension cannot be negative"))
+	} else if c.Storage.ExpiredExtension > 0 && c.Storage.ExpiredReads != "extend" {
+		errs = append(errs, errors.New("storage: expired_extension requires the extend expired_reads policy"))
+	}
+	if len(c.Storage.PrefixSeparator) > 0 {
+		if c.Storage.Engine != "dense" {
+			errs = append(errs, errors.New("storage: prefix_separator requires the dense engine"))
+		} else if len(c.Storage.PrefixSeparator) != 1 {
+			errs = append(errs, errors.New("storage: prefix_separator must be a single byte"))
+		}
+	}
+	if c.Storage.Checksums && c.Storage.Engine != "dense" && c.Storage.Engine != "disk" {
+		errs = append(errs, errors.New("storage: checksums requires the dense or disk engine"))
+	}
+
+	if c.Backup.Enabled() {
+		if _, err := c.Backup.Backups(c.Backup.URL); err != nil {
+			errs = append(errs, fmt.Errorf("backup: %w", err))
+		}
+		if len(c.Backup.AccessKey) == 0 || len(c.Backup.SecretKey) == 0 {
+			errs = append(errs, errors.New("backup: access_key and secret_key are required"))
+		}
+		if engine := c.Storage.Engine; (engine != "" && engine != "memory") || c.Storage.L1TTL > 0 || len(c.Namespaces) != 0 {
+			errs = append(errs, errors.New("backup: only the memory storage engine supports snapshots"))
+		}
+		if c.Backup.Interval < 0 || c.Backup.Retain < 0 {
+			errs = append(errs, errors.New("backup: interval and retain cannot be negative"))
+		}
+	}
+
+	if len(c.Admin.ListenAddr) != 0 {
+		if _, _, err := net.SplitHostPort(c.Admin.ListenAddr); err != nil {
+			errs = append(errs, fmt.Errorf("admin: listen_addr: %w", err))
+		} else if sameAddr(c.Admin.ListenAddr, c.ListenAddr) {
+			errs = append(errs, fmt.Errorf("admin: listen_addr [%s] conflicts with listen_addr", c.Admin.ListenAddr))
+		}
+	}
+
+	if c.Admin.StatsRetention < 0 {
+		errs = append(errs, errors.New("admin: stats_retention cannot be negative"))
+	}
+	if c.Admin.RequestLog.Every < 0 {
+		errs = append(errs, errors.New("admin: request_log: every cannot be negative"))
+	}
+	for name, rate := range c.Admin.RequestLog.Rates {
+		if rate < 0 || rate > 1 {
+			errs = append(errs, fmt.Errorf("admin: request_log: rate of %s must be between 0 and 1", name))
+		}
+	}
+
+	if len(c.WebSocket.ListenAddr) != 0 {
+		if _, _, err := net.SplitHostPort(c.WebSocket.ListenAddr); err != nil {
+			errs = append(errs, fmt.Errorf("websocket: listen_addr: %w", err))
+		} else if sameAddr(c.WebSocket.ListenAddr, c.ListenAddr) || sameAddr(c.WebSocket.ListenAddr, c.Admin.ListenAddr) {
+			errs = append(errs, fmt.Errorf("websocket: listen_addr [%s] conflicts with another listener", c.WebSocket.ListenAddr))
+		}
+	}
+
+	if len(c.UDP.ListenAddr) != 0 {
+		if _, _, err := net.SplitHostPort(c.UDP.ListenAddr); err != nil {
+			errs = append(errs, fmt.Errorf("udp: listen_addr: %w", err))
+		}
+		// The value is sent after a status byte and its length.
+		if c.UDP.MaxValue < 0 || c.UDP.MaxValue > 65507-5 {
+			errs = append(errs, errors.New("udp: max_value must be between 0 and 65502"))
+		}
+	}
+
+	if c.Replication.FlushInterval < 0 {
+		errs = append(errs, errors.New("replication: flush_interval cannot be negative"))
+	}
+	if c.Replication.BatchBytes < 0 {
+		errs = append(errs, errors.New("replication: batch_bytes cannot be negative"))
+	}
+	if c.Replication.Coalesce && c.Replication.FlushInterval == 0 {
+		errs = append(errs, errors.New("replication: coalesce requires flush_interval"))
+	}
+	if len(c.Replication.ReadAddr) != 0 {
+		if _, _, err := net.SplitHostPort(c.Replication.ReadAddr); err != nil {
+			errs = append(errs, fmt.Errorf("replication: read_addr: %w", err))
+		}
+	}
+	if c.Replication.SyncBandwidth < 0 {
+		errs = append(errs, errors.New("replication: sync_bandwidth cannot be negative"))
+	}
+	if c.Replication.SyncChunkBytes < 0 {
+		errs = append(errs, errors.New("replication: sync_chunk_bytes cannot be negative"))
+	}
+	if c.Replication.SessionWait < 0 {
+		errs = append(errs, errors.New("replication: session_wait cannot be negative"))
+	}
+	if c.Replication.ClockSyncInterval < 0 {
+		errs = append(errs, errors.New("replication: clock_sync_interval cannot be negative"))
+	}
+	if c.Replication.SlowStart < 0 {
+		errs = append(errs, errors.New("replication: slow_start cannot be negative"))
+	}
+	if c.Leases.TTL < 0 {
+		errs = append(errs, errors.New("leases: ttl cannot be negative"))
+	}
+	if c.Flush.BatchKeys < 0 {
+		errs = append(errs, errors.New("flush: batch_keys cannot be negative"))
+	}
+	if c.Flush.BatchInterval < 0 {
+		errs = append(errs, errors.New("flush: batch_interval cannot be negative"))
+	}
+	if c.Flush.DeleteRetention < 0 {
+		errs = append(errs, errors.New("flush: delete_retention cannot be negative"))
+	}
+	switch server.PersistencePolicy(c.Persistence.OnFailure) {
+	case "", server.PersistenceReadOnly, server.PersistenceMemoryOnly:
+	default:
+		errs = append(errs, fmt.Errorf("persistence: unknown on_failure policy [%s]", c.Persistence.OnFailure))
+	}
+	if c.Scheduler.Workers < 0 {
+		errs = append(errs, errors.New("scheduler: workers cannot be negative"))
+	}
+	if w := c.Scheduler.Weights; w.Replication < 0 || w.Client < 0 || w.Background < 0 {
+		errs = append(errs, errors.New("scheduler: weights cannot be negative"))
+	} else if w != (PriorityWeightsConfig{}) && c.Scheduler.Workers == 0 {
+		errs = append(errs, errors.New("scheduler: weights require workers"))
+	}
+	if c.Scheduler.MaxHandlers < 0 || c.Scheduler.MaxInflightBytes < 0 || c.Scheduler.MaxFieldSize < 0 || c.Scheduler.MGetBudget < 0 {
+		errs = append(errs, errors.New("scheduler: limits cannot be negative"))
+	}
+	for name, timeout := range c.Scheduler.CommandTimeouts {
+		if timeout < 0 {
+			errs = append(errs, fmt.Errorf("scheduler: command_timeouts: %s cannot be negative", name))
+		}
+	}
+
+	if len(c.OTLP.Endpoint) != 0 {
+		if u, err := url.Parse(c.OTLP.Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("otlp: endpoint: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("otlp: endpoint [%s] must be an http or https url", c.OTLP.Endpoint))
+		}
+		if c.OTLP.Interval < 0 {
+			errs = append(errs, errors.New("otlp: interval cannot be negative"))
+		}
+	}
+
+	if hook := c.RemovalWebhook; len(hook.URL) != 0 {
+		if u, err := url.Parse(hook.URL); err != nil {
+			errs = append(errs, fmt.Errorf("removal_webhook: url: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("removal_webhook: url [%s] must be an http or https url", hook.URL))
+		}
+		if hook.BatchSize < 0 || hook.Interval < 0 || hook.Retries < 0 || hook.Backoff < 0 || hook.Buffer < 0 {
+			errs = append(errs, errors.New("removal_webhook: settings cannot be negative"))
+		}
+		if engine := c.Storage.Engine; (engine != "" && engine != "memory") || c.Storage.L1TTL > 0 || c.Storage.ChunkSize > 0 {
+			errs = append(errs, errors.New("removal_webhook: only the memory storage engine reports removals"))
+		}
+	}
+
+	for name, ns := range c.Namespaces {
+		if ns.DefaultTTL < 0 || ns.MaxBytes < 0 {
+			errs = append(errs, fmt.Errorf("namespaces: %s: default_ttl and max_bytes cannot be negative", name))
+		}
+		if ns.MinTTL < 0 || ns.MaxTTL < 0 {
+			errs = append(errs, fmt.Errorf("namespaces: %s: min_ttl and max_ttl cannot be negative", name))
+		}
+		if ns.MaxTTL > 0 && ns.MinTTL > ns.MaxTTL {
+			errs = append(errs, fmt.Errorf("namespaces: %s: min_ttl cannot be above max_ttl", name))
+		}
+		if ns.DefaultTTL > 0 && ((ns.MinTTL > 0 && ns.DefaultTTL < ns.MinTTL) || (ns.MaxTTL > 0 && ns.DefaultTTL > ns.MaxTTL)) {
+			errs = append(errs, fmt.Errorf("namespaces: %s: default_ttl must be between min_ttl and max_ttl", name))
+		}
+		if _, err := ns.policy(); err != nil {
+			errs = append(errs, fmt.Errorf("namespaces: %s: %w", name, err))
+		}
+	}
+
+	for name, v := range c.Validators {
+		if !v.JSON && len(v.ContentTypes) == 0 {
+			errs = append(errs, fmt.Errorf("validators: %s: json or content_types is required", name))
+		}
+		if v.JSONMaxDepth < 0 {
+			errs = append(errs, fmt.Errorf("validators: %s: json_max_depth cannot be negative", name))
+		}
+		for _, t := range v.ContentTypes {
+			if _, _, err := mime.ParseMediaType(t); err != nil && !strings.HasSuffix(t, "/*") {
+				errs = append(errs, fmt.Errorf("validators: %s: invalid content type [%s]", name, t))
+			}
+		}
+	}
+
+	for i, w := range c.Warm {
+		if (len(w.File) == 0) == (len(w.URL) == 0) {
+			errs = append(errs, fmt.Errorf("warm[%d]: exactly one of file and url is required", i))
+		}
+		if len(w.URL) != 0 {
+			if u, err := url.Parse(w.URL); err != nil {
+				errs = append(errs, fmt.Errorf("warm[%d]: url: %w", i, err))
+			} else if u.Scheme != "http" && u.Scheme != "https" {
+				errs = append(errs, fmt.Errorf("warm[%d]: url [%s] must be an http or https url", i, w.URL))
+			}
+		}
+		switch w.Format {
+		case "", server.WarmCSV, server.WarmJSONL:
+		default:
+			errs = append(errs, fmt.Errorf("warm[%d]: unknown format [%s]", i, w.Format))
+		}
+		if w.TTL < 0 {
+			errs = append(errs, fmt.Errorf("warm[%d]: ttl cannot be negative", i))
+		}
+	}
+
+	tokens := make(map[string]bool, len(c.Tenants))
+	for i, tenant := range c.Tenants {
+		if len(tenant.Token) == 0 && len(tenant.Tokens) == 0 {
+			errs = append(errs, fmt.Errorf("tenants[%d]: token is required", i))
+		}
+		for _, token := range tenant.tokens() {
+			if len(token) == 0 {
+				errs = append(errs, fmt.Errorf("tenants[%d]: tokens cannot be empty", i))
+			} else if tokens[token] {
+				errs = append(errs, fmt.Errorf("tenants[%d]: token is not unique", i))
+			}
+			tokens[token] = true
+		}
+		if sep := c.Admin.NamespaceSeparator; len(sep) != 0 && strings.Contains(tenant.Namespace, sep) {
+			errs = append(errs, fmt.Errorf("tenants[%d]: namespace [%s] contains the namespace separator", i, tenant.Namespace))
+		}
+		if tenant.RequestsPerSecond < 0 || tenant.Burst < 0 {
+			errs = append(errs, fmt.Errorf("tenants[%d]: requests_per_second and burst cannot be negative", i))
+		}
+	}
+	providers := 0
+	for _, set := range []bool{len(c.Tenants) != 0, len(c.Auth.HMAC.Secret) != 0, len(c.Auth.HTTP.URL) != 0} {
+		if set {
+			providers++
+		}
+	}
+	if providers > 1 {
+		errs = append(errs, errors.New("auth: only one of tenants, auth.hmac and auth.http can be set"))
+	}
+	if c.Auth.HMAC.RequestsPerSecond < 0 || c.Auth.HMAC.Burst < 0 {
+		errs = append(errs, errors.New("auth: hmac: requests_per_second and burst cannot be negative"))
+	}
+	if len(c.Auth.HTTP.URL) != 0 {
+		if u, err := url.Parse(c.Auth.HTTP.URL); err != nil {
+			errs = append(errs, fmt.Errorf("auth: http: url: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("auth: http: url [%s] must be an http or https url", c.Auth.HTTP.URL))
+		}
+	}
+	if c.Auth.HTTP.Timeout < 0 {
+		errs = append(errs, errors.New("auth: http: timeout cannot be negative"))
+	}
+	if providers != 0 && (len(c.UDP.ListenAddr) != 0 || len(c.WebSocket.ListenAddr) != 0) {
+		errs = append(errs, errors.New("tenants: the udp and websocket listeners do not authenticate and cannot be enabled along with tenants"))
+	}
+
+	for _, cidr := range c.AllowCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("allow_cidrs: %w", err))
+		}
+	}
+
+	if c.TLS.Enabled() {
+		switch {
+		case len(c.TLS.CertFile) == 0:
+			errs = append(errs, errors.New("tls: key_file is set but cert_file is missing"))
+		case len(c.TLS.KeyFile) == 0:
+			errs = append(errs, errors.New("tls: cert_file is set but key_file is missing"))
+		default:
+			if _, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile); err != nil {
+				errs = append(errs, fmt.Errorf("tls: %w", err))
+			}
+		}
+	}
+	if len(c.TLS.CAFile) != 0 {
+		if !c.TLS.Enabled() {
+			errs = append(errs, errors.New("tls: ca_file is set but tls is not enabled"))
+		}
+		if _, err := loadCertPool(c.TLS.CAFile); err != nil {
+			errs = append(errs, fmt.Errorf("tls: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ServerOpts resolves the configuration into the options the server runs with.
+func (c *Config) ServerOpts() (server.ServerOpts, error) {
+	opts := server.ServerOpts{
+		ListenAddr:    c.ListenAddr,
+		IsLeader:      len(c.LeaderAddr) == 0,
+		LeaderAddr:    c.LeaderAddr,
+		AdvertiseAddr: c.AdvertiseAddr,
+		AcceptLoops:   c.AcceptLoops,
+		Zone:          c.Zone,
+		HandoffPath:   c.HandoffPath,
+
+		HighMemoryBytes: c.HighMemoryBytes,
+	}
+
+	if c.Discovery.Enabled() {
+		port := c.Discovery.Port
+		if len(port) == 0 {
+			_, port, _ = net.SplitHostPort(c.ListenAddr)
+		}
+		if len(c.Discovery.DNS) != 0 {
+			opts.Discovery = server.DNSDiscovery{Name: c.Discovery.DNS, Port: port}
+		} else {
+			opts.Discovery = server.KubernetesDiscovery{
+				Namespace:     c.Discovery.Kubernetes.Namespace,
+				LabelSelector: c.Discovery.Kubernetes.LabelSelector,
+				Port:          port,
+			}
+		}
+		opts.DiscoveryInterval = c.Discovery.Interval
+		opts.IsLeader = false
+	}
+
+	switch {
+	case c.Registry.Consul != nil:
+		opts.Registry = &server.ConsulRegistry{
+			Addr:    c.Registry.Consul.Addr,
+			Service: c.Registry.Consul.Service,
+		}
+	case c.Registry.Etcd != nil:
+		etcd := &server.Etcd{
+			Endpoint: c.Registry.Etcd.Endpoint,
+			Prefix:   c.Registry.Etcd.Prefix,
+			Addr:     c.AdvertiseAddr,
+		}
+		opts.Registry = etcd
+		if c.Registry.Etcd.Election {
+			opts.Elector = etcd
+			opts.IsLeader = false
+		}
+	}
+
+	if c.Backup.Enabled() {
+		backups, err := c.Backup.Backups(c.Backup.URL)
+		if err != nil {
+			return opts, err
+		}
+		opts.Backups = backups
+	}
+
+	opts.AdminAddr = c.Admin.ListenAddr
+	opts.ExpvarName = c.Admin.Expvar
+	opts.NamespaceSeparator = c.Admin.NamespaceSeparator
+	opts.StatsRetention = c.Admin.StatsRetention
+	if rl := c.Admin.RequestLog; rl.Every > 0 || len(rl.Rates) != 0 {
+		opts.RequestLog = &server.RequestLog{Every: rl.Every, Rates: rl.Rates}
+	}
+	opts.WebSocketAddr = c.WebSocket.ListenAddr
+	opts.WebSocketOrigins = c.WebSocket.AllowedOrigins
+	opts.UDPAddr = c.UDP.ListenAddr
+	opts.UDPMaxValue = c.UDP.MaxValue
+	opts.ReplicationInterval = c.Replication.FlushInterval
+	opts.ReplicationBatchBytes = c.Replication.BatchBytes
+	opts.ReplicationCoalesce = c.Replication.Coalesce
+	opts.RedirectWrites = c.Replication.RedirectWrites
+	opts.ReadAddr = c.Replication.ReadAddr
+	opts.FullSync = c.Replication.FullSync
+	opts.SyncBandwidth = c.Replication.SyncBandwidth
+	opts.SyncChunkBytes = c.Replication.SyncChunkBytes
+	opts.SessionWait = c.Replication.SessionWait
+	opts.ClockSyncInterval = c.Replication.ClockSyncInterval
+	opts.SlowStart = c.Replication.SlowStart
+	opts.PersistenceFailure = server.PersistencePolicy(c.Persistence.OnFailure)
+	opts.Workers = c.Scheduler.Workers
+	opts.MaxHandlers = c.Scheduler.MaxHandlers
+	opts.MaxInflightBytes = c.Scheduler.MaxInflightBytes
+	opts.MaxFieldSize = c.Scheduler.MaxFieldSize
+	opts.MGetBudget = c.Scheduler.MGetBudget
+	if len(c.Scheduler.CommandTimeouts) != 0 {
+		opts.CommandTimeouts = make(map[string]time.Duration, len(c.Scheduler.CommandTimeouts))
+		for name, timeout := range c.Scheduler.CommandTimeouts {
+			opts.CommandTimeouts[strings.ToUpper(name)] = timeout
+		}
+	}
+	opts.PriorityWeights = server.PriorityWeights{
+		Replication: c.Scheduler.Weights.Replication,
+		Client:      c.Scheduler.Weights.Client,
+		Background:  c.Scheduler.Weights.Background,
+	}
+	opts.LeaseTTL = c.Leases.TTL
+	opts.FlushBatchKeys = c.Flush.BatchKeys
+	opts.FlushBatchInterval = c.Flush.BatchInterval
+	opts.DeleteRetention = c.Flush.DeleteRetention
+	opts.AuthToken = c.AuthToken
+	opts.ClusterSecret = c.ClusterSecret
+	switch {
+	case len(c.Auth.HMAC.Secret) != 0:
+		opts.Authenticator = server.HMACTokens{
+			Secret:            []byte(c.Auth.HMAC.Secret),
+			RequestsPerSecond: c.Auth.HMAC.RequestsPerSecond,
+			Burst:             c.Auth.HMAC.Burst,
+		}
+	case len(c.Auth.HTTP.URL) != 0:
+		opts.Authenticator = server.HTTPAuth{URL: c.Auth.HTTP.URL, Timeout: c.Auth.HTTP.Timeout}
+	}
+	opts.Tenants = c.tenants()
+	if len(c.Validators) != 0 {
+		opts.Validators = make(map[string]server.Validator, len(c.Validators))
+		for name, v := range c.Validators {
+			opts.Validators[name] = v.validator()
+		}
+	}
+	if len(c.Warm) != 0 {
+		warmers := make([]server.Warmer, len(c.Warm))
+		for i, w := range c.Warm {
+			warmers[i] = server.Warmer{Path: w.File, URL: w.URL, Format: w.Format, TTL: w.TTL}
+		}
+		opts.OnReady = server.WarmAll(warmers...)
+	}
+	if len(c.OTLP.Endpoint) != 0 {
+		opts.OTLP = &server.OTLP{
+			Endpoint: c.OTLP.Endpoint,
+			Headers:  c.OTLP.Headers,
+			Interval: c.OTLP.Interval,
+		}
+	}
+	if hook := c.RemovalWebhook; len(hook.URL) != 0 {
+		opts.RemovalWebhook = &server.RemovalWebhook{
+			URL:       hook.URL,
+			Headers:   hook.Headers,
+			Prefixes:  hook.Prefixes,
+			BatchSize: hook.BatchSize,
+			Interval:  hook.Interval,
+			Retries:   hook.Retries,
+			Backoff:   hook.Backoff,
+			Buffer:    hook.Buffer,
+		}
+	}
+
+	for _, cidr := range c.AllowCIDRs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return opts, err
+		}
+		opts.AllowedNets = append(opts.AllowedNets, ipnet)
+	}
+
+	if c.TLS.Enabled() {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		if len(c.TLS.CAFile) != 0 {
+			pool, err := loadCertPool(c.TLS.CAFile)
+			if err != nil {
+				return opts, err
+			}
+			opts.TLSConfig.RootCAs = pool
+			opts.TLSConfig.ClientCAs = pool
+			opts.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return opts, nil
+}
+
+// memoryOptions are the options of the memory engine.
+func (c *Config) memoryOptions() []ggcache.Option {
+	opts := []ggcache.Option{ggcache.WithMaxIdle(c.Storage.MaxIdle)}
+	switch c.Storage.ExpiredReads {
+	case "stale":
+		opts = append(opts, ggcache.WithExpiredReads(ggcache.ExpiredStale, 0))
+	case "extend":
+		opts = append(opts, ggcache.WithExpiredReads(ggcache.ExpiredExtend, c.Storage.ExpiredExtension))
+	}
+	return opts
+}
+
+// Cacher opens the storage engine the node serves its entries from.
+func (c *Config) Cacher() (ggcache.Cacher, error) {
+	var (
+		cache ggcache.Cacher
+		err   error
+	)
+	switch c.Storage.Engine {
+	case "", "memory":
+		if c.Storage.ChunkSize == 0 && len(c.Namespaces) == 0 {
+			return ggcache.New(c.memoryOptions()...), nil
+		}
+		cache = ggcache.New(c.memoryOptions()...)
+	case "dense":
+		opts := dense.Options{Checksums: c.Storage.Checksums}
+		if len(c.Storage.PrefixSeparator) == 1 {
+			opts.PrefixSeparator = c.Storage.PrefixSeparator[0]
+		}
+		cache = dense.New(opts)
+	case "rcu":
+		cache = rcu.New(rcu.Options{})
+	case "disk":
+		cache, err = disk.Open(c.Storage.Path, disk.Options{
+			MemoryFallback:  server.PersistencePolicy(c.Persistence.OnFailure) == server.PersistenceMemoryOnly,
+			VerifyChecksums: c.Storage.Checksums,
+		})
+	case "redis":
+		cache = redis.New(c.Storage.Addr, redis.Options{Password: c.Storage.Password})
+	case "memcached":
+		cache = memcache.New(c.Storage.Addr, memcache.Options{})
+	default:
+		return nil, fmt.Errorf("unknown storage engine [%s]", c.Storage.Engine)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Storage.ChunkSize > 0 {
+		cache = ggcache.Chunked(cache, ggcache.ChunkedOptions{ChunkSize: c.Storage.ChunkSize})
+	}
+	if len(c.Namespaces) != 0 {
+		opts := ggcache.NamespacedOptions{
+			Separator: c.Admin.NamespaceSeparator,
+			Policies:  make(map[string]ggcache.NamespacePolicy, len(c.Namespaces)),
+		}
+		for name, ns := range c.Namespaces {
+			if opts.Policies[name], err = ns.policy(); err != nil {
+				return nil, err
+			}
+		}
+		cache = ggcache.Namespaced(cache, opts)
+	}
+	if c.Storage.L1TTL > 0 {
+		cache = ggcache.Layered(ggcache.New(), cache, ggcache.LayeredOptions{L1TTL: c.Storage.L1TTL})
+	}
+	return cache, nil
+}
+
+// String returns the configuration as YAML, its secrets replaced by
+// "<redacted>" so it can be logged.
+func (c *Config) String() string {
+	r := *c
+	r.Storage.Password = redact(c.Storage.Password)
+	r.Backup.AccessKey = redact(c.Backup.AccessKey)
+	r.Backup.SecretKey = redact(c.Backup.SecretKey)
+	r.Backup.SessionToken = redact(c.Backup.SessionToken)
+	r.OTLP.Headers = redactValues(c.OTLP.Headers)
+	r.RemovalWebhook.Headers = redactValues(c.RemovalWebhook.Headers)
+	r.AuthToken = redact(c.AuthToken)
+	r.Auth.HMAC.Secret = redact(c.Auth.HMAC.Secret)
+	r.ClusterSecret = redact(c.ClusterSecret)
+	r.Tenants = make([]TenantConfig, len(c.Tenants))
+	for i, tenant := range c.Tenants {
+		tenant.Token = redact(tenant.Token)
+		tokens := make([]string, len(tenant.Tokens))
+		for j, token := range tenant.Tokens {
+			tokens[j] = redact(token)
+		}
+		tenant.Tokens = tokens
+		r.Tenants[i] = tenant
+	}
+
+	b, err := yaml.Marshal(&r)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// redact hides a secret of the configuration, if set.
+func redact(secret string) string {
+	if len(secret) == 0 {
+		return secret
+	}
+	return "<redacted>"
+}
+
+// redactValues hides the values of headers, which may carry credentials.
+func redactValues(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	r := make(map[string]string, len(headers))
+	for name, value := range headers {
+		r[name] = redact(value)
+	}
+	return r
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in [%s]", path)
+	}
+	return pool, nil
+}
+
+// sameAddr reports whether the host:port addresses a and b reach the same
+// listener once resolved. A host that is empty or unspecified, as a listener
+// binds, matches every address of this machine.
+func sameAddr(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ahost, aport, err := net.SplitHostPort(a)
+	if err != nil {
+		return false
+	}
+	bhost, bport, err := net.SplitHostPort(b)
+	if err != nil {
+		return false
+	}
+	ap, err := net.LookupPort("tcp", aport)
+	if err != nil {
+		return false
+	}
+	bp, err := net.LookupPort("tcp", bport)
+	if err != nil || ap != bp {
+		return false
+	}
+
+	aips, bips := resolveHost(ahost), resolveHost(bhost)
+	for _, aip := range aips {
+		for _, bip := range bips {
+			switch {
+			case aip.Equal(bip):
+				return true
+			case aip.IsUnspecified() && localIP(bip), bip.IsUnspecified() && localIP(aip):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveHost returns the addresses of the host of a host:port address, the
+// unspecified address if it is empty.
+func resolveHost(host string) []net.IP {
+	if len(host) == 0 {
+		return []net.IP{net.IPv4zero}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil
+	}
+	return ips
+}
+
+// localIP reports whether ip is an address of this machine.
+func localIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
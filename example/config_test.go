@@ -0,0 +1,713 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/cache/dense"
+	"github.com/anthdm/ggcache/cache/disk"
+	"github.com/anthdm/ggcache/cache/rcu"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(body), 0o600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "leader_addr: \":3000\"\nlisten_addr: \":4000\"\nallow_cidrs: [\"10.0.0.0/8\"]\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.False(t, opts.IsLeader)
+	assert.Equal(t, ":3000", opts.LeaderAddr)
+	assert.Len(t, opts.AllowedNets, 1)
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := &Config{
+		ListenAddr: ":3000",
+		LeaderAddr: ":3000",
+		AllowCIDRs: []string{"10.0.0.0/33"},
+		TLS: TLSConfig{
+			CertFile: "missing.pem",
+		},
+	}
+	err := cfg.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "conflicts with listen_addr")
+	assert.Contains(t, err.Error(), "allow_cidrs")
+	assert.Contains(t, err.Error(), "key_file is missing")
+
+	// The addresses are compared once resolved.
+	cfg = DefaultConfig()
+	cfg.LeaderAddr = "127.0.0.1:3000"
+	assert.ErrorContains(t, cfg.Validate(), "conflicts with listen_addr")
+	cfg.LeaderAddr = "localhost:3000"
+	assert.ErrorContains(t, cfg.Validate(), "conflicts with listen_addr")
+	cfg.ListenAddr = "127.0.0.1:3000"
+	cfg.LeaderAddr = ":3000"
+	assert.ErrorContains(t, cfg.Validate(), "conflicts with listen_addr")
+	cfg.LeaderAddr = "127.0.0.1:3001"
+	assert.Nil(t, cfg.Validate())
+	cfg.LeaderAddr = "192.0.2.1:3000"
+	assert.Nil(t, cfg.Validate())
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1, and its key, to
+// PEM files and returns their paths.
+func writeCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestConfigMutualTLS(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	cfg := DefaultConfig()
+	cfg.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: certFile}
+	assert.Nil(t, cfg.Validate())
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, opts.TLSConfig.ClientAuth)
+
+	// The embedded client presents the certificate of the node.
+	opts.ListenAddr = ""
+	s, c, err := server.StartEmbedded(opts, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+	ctx := context.Background()
+	assert.Nil(t, c.Ping(ctx))
+
+	// A client without one is turned away.
+	anon, err := client.New(s.Addr().String(), client.Options{TLSConfig: &tls.Config{RootCAs: opts.TLSConfig.RootCAs}})
+	if err == nil {
+		defer anon.Close()
+		err = anon.Ping(ctx)
+	}
+	assert.NotNil(t, err)
+}
+
+func TestConfigDiscovery(t *testing.T) {
+	t.Setenv("POD_IP", "10.0.0.5")
+	path := writeConfig(t, "advertise_addr: ${POD_IP}:3000\ndiscovery:\n  dns: ggcache.default.svc.cluster.local\n  interval: 5s\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+	assert.Equal(t, "10.0.0.5:3000", cfg.AdvertiseAddr)
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.False(t, opts.IsLeader)
+	assert.Equal(t, server.DNSDiscovery{Name: "ggcache.default.svc.cluster.local", Port: "3000"}, opts.Discovery)
+	assert.Equal(t, 5*time.Second, opts.DiscoveryInterval)
+
+	cfg.LeaderAddr = ":4000"
+	cfg.Discovery.Kubernetes = &KubernetesConfig{}
+	err = cfg.Validate()
+	assert.Contains(t, err.Error(), "mutually exclusive")
+	assert.Contains(t, err.Error(), "label_selector")
+	assert.Contains(t, err.Error(), "leader_addr cannot be combined")
+}
+
+func TestConfigRegistry(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdvertiseAddr = "10.0.0.1:3000"
+	cfg.Registry.Etcd = &EtcdConfig{Endpoint: "http://127.0.0.1:2379", Election: true}
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.False(t, opts.IsLeader)
+	assert.NotNil(t, opts.Elector)
+	assert.Equal(t, opts.Elector, opts.Registry)
+
+	cfg.LeaderAddr = ":4000"
+	cfg.Registry.Consul = &ConsulConfig{}
+	err = cfg.Validate()
+	assert.Contains(t, err.Error(), "mutually exclusive")
+	assert.Contains(t, err.Error(), "consul requires an addr")
+	assert.Contains(t, err.Error(), "cannot be combined with etcd election")
+}
+
+func TestConfigStorage(t *testing.T) {
+	path := writeConfig(t, "storage:\n  engine: disk\n  path: "+filepath.Join(t.TempDir(), "cache.log")+"\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	cache, err := cfg.Cacher()
+	assert.Nil(t, err)
+	assert.IsType(t, &disk.Cache{}, cache)
+	assert.Nil(t, cache.(*disk.Cache).Close())
+
+	cfg.Storage = StorageConfig{Engine: "redis", Addr: "127.0.0.1:6379", L1TTL: time.Second}
+	assert.Nil(t, cfg.Validate())
+	cache, err = cfg.Cacher()
+	assert.Nil(t, err)
+	assert.IsType(t, &ggcache.LayeredCache{}, cache)
+
+	cfg.Storage = StorageConfig{Engine: "dense"}
+	assert.Nil(t, cfg.Validate())
+	cache, err = cfg.Cacher()
+	assert.Nil(t, err)
+	assert.IsType(t, &dense.Cache{}, cache)
+
+	cfg.Storage = StorageConfig{Engine: "rcu"}
+	assert.Nil(t, cfg.Validate())
+	cache, err = cfg.Cacher()
+	assert.Nil(t, err)
+	assert.IsType(t, &rcu.Cache{}, cache)
+
+	cfg.Storage = StorageConfig{Engine: "dense", PrefixSeparator: ":"}
+	assert.Nil(t, cfg.Validate())
+	cache, err = cfg.Cacher()
+	assert.Nil(t, err)
+	assert.Nil(t, cache.Set([]byte("tenant:1:foo"), []byte("bar"), 0))
+	assert.Equal(t, 1, cache.(*dense.Cache).PrefixStats().Prefixes)
+
+	cfg.Storage.PrefixSeparator = "::"
+	assert.Contains(t, cfg.Validate().Error(), "single byte")
+	cfg.Storage.Engine = "memory"
+	assert.Contains(t, cfg.Validate().Error(), "requires the dense engine")
+
+	cfg.Storage = StorageConfig{Engine: "memory", MaxIdle: time.Minute}
+	assert.Nil(t, cfg.Validate())
+	cache, err = cfg.Cacher()
+	assert.Nil(t, err)
+	assert.IsType(t, &ggcache.Cache{}, cache)
+	cfg.Storage.Engine = "dense"
+	assert.Contains(t, cfg.Validate().Error(), "max_idle requires the memory engine")
+
+	cfg.Storage = StorageConfig{ExpiredReads: "extend", ExpiredExtension: time.Minute}
+	assert.Nil(t, cfg.Validate())
+	cfg.Storage.ExpiredReads = "stale"
+	assert.Contains(t, cfg.Validate().Error(), "expired_extension requires the extend expired_reads policy")
+	cfg.Storage = StorageConfig{ExpiredReads: "serve"}
+	assert.Contains(t, cfg.Validate().Error(), "unknown expired_reads policy [serve]")
+	cfg.Storage = StorageConfig{Engine: "dense", ExpiredReads: "stale"}
+	assert.Contains(t, cfg.Validate().Error(), "expired_reads requires the memory engine")
+
+	cfg.Storage = StorageConfig{Engine: "dense", Checksums: true}
+	assert.Nil(t, cfg.Validate())
+	cfg.Storage.Engine = "memory"
+	assert.Contains(t, cfg.Validate().Error(), "checksums requires the dense or disk engine")
+
+	cfg.Storage = StorageConfig{Engine: "dense", ChunkSize: 1 << 20}
+	assert.Nil(t, cfg.Validate())
+	cache, err = cfg.Cacher()
+	assert.Nil(t, err)
+	assert.IsType(t, &ggcache.ChunkedCache{}, cache)
+
+	cfg.Storage = StorageConfig{Engine: "disk"}
+	assert.Contains(t, cfg.Validate().Error(), "requires a path")
+	cfg.Storage.Engine = "memcached"
+	assert.Contains(t, cfg.Validate().Error(), "requires an addr")
+	cfg.Storage.Engine = "badger"
+	assert.Contains(t, cfg.Validate().Error(), "unknown engine")
+}
+
+func TestConfigBackup(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backup = BackupConfig{
+		URL:       "s3://backups/prod/node-1",
+		Region:    "eu-west-1",
+		AccessKey: "key",
+		SecretKey: "secret",
+		Interval:  time.Hour,
+		Retain:    24,
+	}
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, "prod/node-1/", opts.Backups.Prefix)
+	assert.Equal(t, 24, opts.Backups.Retain)
+	store := opts.Backups.Store.(*server.S3)
+	assert.Equal(t, "https://s3.eu-west-1.amazonaws.com", store.Endpoint)
+	assert.Equal(t, "backups", store.Bucket)
+
+	backups, err := cfg.Backup.Backups("gs://other/prod/snapshot-1.ggsnap")
+	assert.Nil(t, err)
+	assert.Equal(t, "prod/snapshot-1.ggsnap", backups.Prefix)
+	assert.Equal(t, "https://storage.googleapis.com", backups.Store.(*server.S3).Endpoint)
+
+	cfg.Backup.URL = "ftp://backups"
+	cfg.Backup.SecretKey = ""
+	cfg.Storage.Engine = "redis"
+	cfg.Storage.Addr = "127.0.0.1:6379"
+	err = cfg.Validate()
+	assert.Contains(t, err.Error(), "unsupported backup url")
+	assert.Contains(t, err.Error(), "secret_key are required")
+	assert.Contains(t, err.Error(), "only the memory storage engine")
+}
+
+func TestConfigAdmin(t *testing.T) {
+	path := writeConfig(t, "admin:\n  listen_addr: 127.0.0.1:9090\n  expvar: ggcache\n  namespace_separator: \":\"\n  stats_retention: 6h\n  request_log:\n    every: 100\n    rates:\n      SET: 0.5\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:9090", opts.AdminAddr)
+	assert.Equal(t, "ggcache", opts.ExpvarName)
+	assert.Equal(t, ":", opts.NamespaceSeparator)
+	assert.Equal(t, 6*time.Hour, opts.StatsRetention)
+	assert.Equal(t, 100, opts.RequestLog.Every)
+	assert.Equal(t, map[string]float64{"SET": 0.5}, opts.RequestLog.Rates)
+
+	cfg.Admin.ListenAddr = cfg.ListenAddr
+	assert.Contains(t, cfg.Validate().Error(), "conflicts with listen_addr")
+	cfg.Admin.ListenAddr = "9090"
+	cfg.Admin.StatsRetention = -time.Hour
+	cfg.Admin.RequestLog.Rates["SET"] = 2
+	err = cfg.Validate()
+	assert.Contains(t, err.Error(), "admin: listen_addr")
+	assert.Contains(t, err.Error(), "stats_retention cannot be negative")
+	assert.Contains(t, err.Error(), "rate of SET must be between 0 and 1")
+}
+
+func TestConfigOTLP(t *testing.T) {
+	path := writeConfig(t, "otlp:\n  endpoint: http://collector:4318\n  interval: 30s\n  headers:\n    authorization: Bearer token\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, "http://collector:4318", opts.OTLP.Endpoint)
+	assert.Equal(t, 30*time.Second, opts.OTLP.Interval)
+	assert.Equal(t, "Bearer token", opts.OTLP.Headers["authorization"])
+
+	cfg.OTLP.Endpoint = "collector:4317"
+	assert.Contains(t, cfg.Validate().Error(), "must be an http or https url")
+}
+
+func TestConfigRemovalWebhook(t *testing.T) {
+	path := writeConfig(t, `removal_webhook:
+  url: https://hooks.example/expired
+  prefixes: [sessions:]
+  interval: 5s
+  retries: 3
+  backoff: 200ms
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, &server.RemovalWebhook{
+		URL:      "https://hooks.example/expired",
+		Prefixes: []string{"sessions:"},
+		Interval: 5 * time.Second,
+		Retries:  3,
+		Backoff:  200 * time.Millisecond,
+	}, opts.RemovalWebhook)
+
+	cfg.Storage.Engine = "redis"
+	cfg.Storage.Addr = "localhost:6379"
+	assert.Contains(t, cfg.Validate().Error(), "only the memory storage engine reports removals")
+	cfg.RemovalWebhook.Retries = -1
+	assert.Contains(t, cfg.Validate().Error(), "cannot be negative")
+}
+
+func TestConfigWebSocket(t *testing.T) {
+	path := writeConfig(t, "websocket:\n  listen_addr: :8080\n  allowed_origins:\n    - https://dashboard.example\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, ":8080", opts.WebSocketAddr)
+	assert.Equal(t, []string{"https://dashboard.example"}, opts.WebSocketOrigins)
+
+	cfg.WebSocket.ListenAddr = cfg.ListenAddr
+	assert.Contains(t, cfg.Validate().Error(), "conflicts with another listener")
+}
+
+func TestConfigUDP(t *testing.T) {
+	path := writeConfig(t, "udp:\n  listen_addr: :3001\n  max_value: 512\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, ":3001", opts.UDPAddr)
+	assert.Equal(t, 512, opts.UDPMaxValue)
+
+	cfg.UDP.MaxValue = 1 << 20
+	assert.Contains(t, cfg.Validate().Error(), "max_value must be between")
+}
+
+func TestConfigReplication(t *testing.T) {
+	path := writeConfig(t, "replication:\n  flush_interval: 5ms\n  batch_bytes: 65536\n  coalesce: true\n  redirect_writes: true\n  read_addr: 10.0.0.2:3000\n  session_wait: 200ms\n  clock_sync_interval: 30s\n  slow_start: 1m\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Millisecond, opts.ReplicationInterval)
+	assert.Equal(t, 65536, opts.ReplicationBatchBytes)
+	assert.True(t, opts.ReplicationCoalesce)
+	assert.True(t, opts.RedirectWrites)
+	assert.Equal(t, "10.0.0.2:3000", opts.ReadAddr)
+	assert.Equal(t, 200*time.Millisecond, opts.SessionWait)
+	assert.Equal(t, 30*time.Second, opts.ClockSyncInterval)
+	assert.Equal(t, time.Minute, opts.SlowStart)
+
+	cfg.Replication.SessionWait = -time.Second
+	assert.Contains(t, cfg.Validate().Error(), "session_wait cannot be negative")
+	cfg.Replication.SessionWait = 0
+
+	cfg.Replication.FlushInterval = -time.Second
+	assert.Contains(t, cfg.Validate().Error(), "flush_interval cannot be negative")
+	cfg.Replication.FlushInterval = 0
+	assert.Contains(t, cfg.Validate().Error(), "coalesce requires flush_interval")
+
+	cfg.Replication = ReplicationConfig{ReadAddr: "10.0.0.2"}
+	assert.Contains(t, cfg.Validate().Error(), "read_addr")
+}
+
+func TestConfigFullSync(t *testing.T) {
+	path := writeConfig(t, "replication:\n  full_sync: true\n  sync_bandwidth: 1048576\n  sync_chunk_bytes: 4096\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.True(t, opts.FullSync)
+	assert.Equal(t, int64(1048576), opts.SyncBandwidth)
+	assert.Equal(t, 4096, opts.SyncChunkBytes)
+
+	cfg.Replication.SyncBandwidth = -1
+	assert.Contains(t, cfg.Validate().Error(), "sync_bandwidth cannot be negative")
+}
+
+func TestConfigHandoff(t *testing.T) {
+	path := writeConfig(t, "handoff_path: /run/ggcache/handoff.sock\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, "/run/ggcache/handoff.sock", opts.HandoffPath)
+
+	cfg.Storage.Engine = "redis"
+	assert.Contains(t, cfg.Validate().Error(), "handoff_path: only the memory storage engine supports snapshots")
+}
+
+func TestConfigLeases(t *testing.T) {
+	path := writeConfig(t, "leases:\n  ttl: 2s\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Second, opts.LeaseTTL)
+
+	cfg.Leases.TTL = -time.Second
+	assert.Contains(t, cfg.Validate().Error(), "ttl cannot be negative")
+}
+
+func TestConfigPersistence(t *testing.T) {
+	path := writeConfig(t, "persistence:\n  on_failure: memory_only\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, server.PersistenceMemoryOnly, opts.PersistenceFailure)
+
+	cfg.Persistence.OnFailure = "panic"
+	assert.Contains(t, cfg.Validate().Error(), "unknown on_failure policy")
+}
+
+func TestConfigFlush(t *testing.T) {
+	path := writeConfig(t, "flush:\n  batch_keys: 500\n  batch_interval: 50ms\n  delete_retention: 10m\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, 500, opts.FlushBatchKeys)
+	assert.Equal(t, 50*time.Millisecond, opts.FlushBatchInterval)
+	assert.Equal(t, 10*time.Minute, opts.DeleteRetention)
+
+	cfg.Flush.BatchKeys = -1
+	assert.Contains(t, cfg.Validate().Error(), "batch_keys cannot be negative")
+
+	cfg.Flush.BatchKeys = 0
+	cfg.Flush.DeleteRetention = -time.Second
+	assert.Contains(t, cfg.Validate().Error(), "delete_retention cannot be negative")
+}
+
+func TestConfigWarm(t *testing.T) {
+	seed := filepath.Join(t.TempDir(), "seed.csv")
+	assert.Nil(t, os.WriteFile(seed, []byte("foo,bar\nbaz,qux,1h\n"), 0o600))
+	path := writeConfig(t, "warm:\n  - file: "+seed+"\n    ttl: 10m\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	c := ggcache.New()
+	assert.Nil(t, opts.OnReady(c))
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", string(value))
+
+	cfg.Warm = append(cfg.Warm, WarmConfig{File: seed, URL: "ftp://example.com/seed", Format: "xml"})
+	err = cfg.Validate()
+	assert.Contains(t, err.Error(), "exactly one of file and url is required")
+	assert.Contains(t, err.Error(), "must be an http or https url")
+	assert.Contains(t, err.Error(), "unknown format [xml]")
+}
+
+func TestConfigScheduler(t *testing.T) {
+	path := writeConfig(t, `scheduler:
+  workers: 16
+  weights:
+    client: 6
+  max_handlers: 1024
+  max_inflight_bytes: 67108864
+  max_field_size: 1048576
+  mget_budget: 5ms
+  command_timeouts:
+    scan: 50ms
+    FLUSH: 2s
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, 16, opts.Workers)
+	assert.Equal(t, server.PriorityWeights{Client: 6}, opts.PriorityWeights)
+	assert.Equal(t, 1024, opts.MaxHandlers)
+	assert.Equal(t, int64(64<<20), opts.MaxInflightBytes)
+	assert.Equal(t, 1<<20, opts.MaxFieldSize)
+	assert.Equal(t, 5*time.Millisecond, opts.MGetBudget)
+	assert.Equal(t, map[string]time.Duration{"SCAN": 50 * time.Millisecond, "FLUSH": 2 * time.Second}, opts.CommandTimeouts)
+
+	cfg.Scheduler.MaxHandlers = -1
+	assert.Contains(t, cfg.Validate().Error(), "limits cannot be negative")
+	cfg.Scheduler.MaxHandlers = 0
+
+	cfg.Scheduler.CommandTimeouts["mget"] = -time.Second
+	assert.Contains(t, cfg.Validate().Error(), "command_timeouts: mget cannot be negative")
+	delete(cfg.Scheduler.CommandTimeouts, "mget")
+
+	cfg.Scheduler.Workers = 0
+	assert.Contains(t, cfg.Validate().Error(), "weights require workers")
+	cfg.Scheduler.Weights.Background = -1
+	assert.Contains(t, cfg.Validate().Error(), "weights cannot be negative")
+}
+
+func TestConfigTenants(t *testing.T) {
+	path := writeConfig(t, `auth_token: op
+cluster_secret: s3cret
+tenants:
+  - token: op
+  - token: a
+    tokens: [a2]
+    namespace: team-a
+    requests_per_second: 100
+    burst: 200
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, "op", opts.AuthToken)
+	assert.Equal(t, "s3cret", opts.ClusterSecret)
+	assert.Equal(t, []server.Tenant{
+		{Token: "op"},
+		{Token: "a", Namespace: "team-a", RequestsPerSecond: 100, Burst: 200},
+		{Token: "a2", Namespace: "team-a", RequestsPerSecond: 100, Burst: 200},
+	}, opts.Tenants)
+
+	cfg.Tenants = append(cfg.Tenants, TenantConfig{Tokens: []string{"a2"}}, TenantConfig{Namespace: "team-b"})
+	cfg.UDP.ListenAddr = ":3001"
+	err = cfg.Validate()
+	assert.Contains(t, err.Error(), "token is not unique")
+	assert.Contains(t, err.Error(), "tenants[3]: token is required")
+	assert.Contains(t, err.Error(), "cannot be enabled along with tenants")
+}
+
+func TestConfigAuth(t *testing.T) {
+	path := writeConfig(t, `auth:
+  hmac:
+    secret: s3cret
+    requests_per_second: 100
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, server.HMACTokens{Secret: []byte("s3cret"), RequestsPerSecond: 100}, opts.Authenticator)
+
+	cfg.Auth.HMAC = HMACAuthConfig{}
+	cfg.Auth.HTTP = HTTPAuthConfig{URL: "https://auth.internal/verify", Timeout: time.Second}
+	opts, err = cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, server.HTTPAuth{URL: "https://auth.internal/verify", Timeout: time.Second}, opts.Authenticator)
+
+	cfg.Tenants = []TenantConfig{{Token: "op"}}
+	cfg.Auth.HTTP.URL = "ftp://auth"
+	cfg.Auth.HTTP.Timeout = -1
+	err = cfg.Validate()
+	assert.Contains(t, err.Error(), "only one of tenants, auth.hmac and auth.http")
+	assert.Contains(t, err.Error(), "must be an http or https url")
+	assert.Contains(t, err.Error(), "timeout cannot be negative")
+}
+
+func TestConfigNamespaces(t *testing.T) {
+	path := writeConfig(t, `namespaces:
+  sessions:
+    default_ttl: 30m
+    max_ttl: 24h
+    max_bytes: 1073741824
+  pages:
+    min_ttl: 1m
+  catalog:
+    max_bytes: 4294967296
+    eviction: lfu
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	cache, err := cfg.Cacher()
+	assert.Nil(t, err)
+	assert.IsType(t, &ggcache.NamespacedCache{}, cache)
+	assert.Equal(t, map[string]uint64{"sessions": 0, "pages": 0}, cache.(*ggcache.NamespacedCache).ClampedTTLs())
+
+	cfg.Namespaces["catalog"] = NamespaceConfig{Eviction: "fifo"}
+	cfg.Namespaces["pages"] = NamespaceConfig{MinTTL: time.Hour, MaxTTL: time.Minute}
+	cfg.Namespaces["sessions"] = NamespaceConfig{DefaultTTL: 48 * time.Hour, MaxTTL: 24 * time.Hour}
+	cfg.Backup = BackupConfig{URL: "s3://bucket", AccessKey: "a", SecretKey: "s"}
+	err = cfg.Validate()
+	assert.Contains(t, err.Error(), "unknown eviction policy [fifo]")
+	assert.Contains(t, err.Error(), "namespaces: pages: min_ttl cannot be above max_ttl")
+	assert.Contains(t, err.Error(), "namespaces: sessions: default_ttl must be between min_ttl and max_ttl")
+	assert.Contains(t, err.Error(), "only the memory storage engine supports snapshots")
+}
+
+func TestConfigValidators(t *testing.T) {
+	path := writeConfig(t, `validators:
+  users:
+    json: true
+    json_max_depth: 8
+  avatars:
+    content_types: [image/*]
+  profiles:
+    json: true
+    content_types: [text/plain]
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, server.JSONValidator{MaxDepth: 8}, opts.Validators["users"])
+	assert.Equal(t, server.ContentTypeValidator{Types: []string{"image/*"}}, opts.Validators["avatars"])
+	assert.Nil(t, opts.Validators["profiles"].Validate(nil, []byte(`{"a": 1}`)))
+	assert.NotNil(t, opts.Validators["profiles"].Validate(nil, []byte(`{"a": 1`)))
+
+	cfg.Validators["users"] = ValidatorConfig{JSONMaxDepth: -1}
+	err = cfg.Validate()
+	assert.Contains(t, err.Error(), "validators: users: json or content_types is required")
+	assert.Contains(t, err.Error(), "validators: users: json_max_depth cannot be negative")
+}
+
+func TestConfigHighMemory(t *testing.T) {
+	path := writeConfig(t, `high_memory_bytes: 1073741824
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1<<30), opts.HighMemoryBytes)
+
+	cfg.HighMemoryBytes = -1
+	assert.Contains(t, cfg.Validate().Error(), "high_memory_bytes cannot be negative")
+}
+
+func TestConfigStringRedacts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Storage.Password = "pw-storage"
+	cfg.Backup = BackupConfig{URL: "s3://bucket", AccessKey: "pw-access", SecretKey: "pw-secret", SessionToken: "pw-session"}
+	cfg.OTLP.Headers = map[string]string{"Authorization": "pw-otlp"}
+	cfg.RemovalWebhook.Headers = map[string]string{"Authorization": "pw-webhook"}
+	cfg.AuthToken = "pw-auth"
+	cfg.Auth.HMAC.Secret = "pw-hmac"
+	cfg.ClusterSecret = "pw-cluster"
+	cfg.Tenants = []TenantConfig{{Token: "pw-tenant", Tokens: []string{"pw-next"}, Namespace: "app"}}
+
+	out := cfg.String()
+	assert.NotContains(t, out, "pw-")
+	assert.Contains(t, out, "secret_key: <redacted>")
+	assert.Contains(t, out, "namespace: app")
+	// The configuration itself is left as it is.
+	assert.Equal(t, "pw-tenant", cfg.Tenants[0].Token)
+	assert.Equal(t, "pw-next", cfg.Tenants[0].Tokens[0])
+	assert.Equal(t, "pw-otlp", cfg.OTLP.Headers["Authorization"])
+}
@@ -5,23 +5,97 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/anthdm/ggcache"
 	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/server"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "namespace" {
+		if err := namespaceCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rdb" {
+		if err := rdbCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "capture" {
+		if err := captureCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replayCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		if err := backupCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var (
 		listenAddr = flag.String("listenaddr", ":3000", "listen address of the server")
 		leaderAddr = flag.String("leaderaddr", "", "listen address of the leader")
+		configFile = flag.String("config", "", "path to a YAML config file")
+		validate   = flag.Bool("validate", false, "validate the config, print the effective config and exit")
+		restore    = flag.String("restore-from", "", "restore the cache from a backup before serving, s3://bucket/path or gs://bucket/path")
+		version    = flag.Bool("version", false, "print the version and exit")
 	)
 	flag.Parse()
 
-	opts := ServerOpts{
-		ListenAddr: *listenAddr,
-		IsLeader:   len(*leaderAddr) == 0,
-		LeaderAddr: *leaderAddr,
+	if *version {
+		fmt.Println(server.Version)
+		return
+	}
+
+	cfg := DefaultConfig()
+	if len(*configFile) != 0 {
+		var err error
+		if cfg, err = LoadConfig(*configFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Flags given explicitly on the command line take precedence over the file.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listenaddr":
+			cfg.ListenAddr = *listenAddr
+		case "leaderaddr":
+			cfg.LeaderAddr = *leaderAddr
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%s\n", err)
+		os.Exit(1)
+	}
+	if *validate {
+		fmt.Print(cfg)
+		return
+	}
+
+	opts, err := cfg.ServerOpts()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	go func() {
@@ -31,8 +105,47 @@ func main() {
 		}
 	}()
 
-	server := NewServer(opts, ggcache.New())
-	_ = server.Start()
+	cache, err := cfg.Cacher()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(*restore) != 0 {
+		if err := restoreFrom(cfg, cache, *restore); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	s := server.NewServer(opts, cache)
+	if len(*configFile) != 0 {
+		go reloadOnHangup(s, *configFile)
+	}
+	_ = s.Start()
+}
+
+// restoreFrom loads a backup into the cache. The URL either names a backup
+// object or a prefix, in which case the latest backup under it is restored.
+func restoreFrom(cfg *Config, cache ggcache.Cacher, rawURL string) error {
+	snap, ok := cache.(ggcache.Snapshotter)
+	if !ok {
+		return fmt.Errorf("restore: the %s storage engine does not support snapshots", cfg.Storage.Engine)
+	}
+	backups, err := cfg.Backup.Backups(rawURL)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	var name string
+	if strings.HasSuffix(backups.Prefix, ".ggsnap") {
+		name = backups.Prefix
+	}
+	name, err = backups.Restore(context.Background(), snap, name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("restored backup [%s]\n", name)
+	return nil
 }
 
 func SendStuff() {
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// namespaceUsage documents the namespace subcommand.
+const namespaceUsage = `usage: ggcache namespace export|import -admin host:port -namespace name [-file path]
+
+export writes the snapshot of the keys of the namespace to the file, or to
+stdout; import stores those of the namespace found in the snapshot read from
+the file, or stdin, on the node, which should be the leader.`
+
+// namespaceCommand runs "ggcache namespace", which exports and imports the
+// snapshot of a namespace through the admin API of a running node.
+func namespaceCommand(args []string) error {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		return errors.New(namespaceUsage)
+	}
+
+	fs := flag.NewFlagSet("namespace "+args[0], flag.ContinueOnError)
+	var (
+		admin     = fs.String("admin", "", "admin listen address of the node")
+		namespace = fs.String("namespace", "", "namespace to export or import")
+		file      = fs.String("file", "", "snapshot file, stdout or stdin if empty")
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if len(*admin) == 0 || len(*namespace) == 0 {
+		return errors.New(namespaceUsage)
+	}
+	endpoint := "http://" + *admin + "/api/v1/namespaces/snapshot?namespace=" + url.QueryEscape(*namespace)
+
+	if args[0] == "export" {
+		return download(endpoint, *file)
+	}
+	return upload(endpoint, *file)
+}
+
+// download writes what a GET of the admin endpoint answers to the file, or
+// to stdout.
+func download(endpoint, file string) error {
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := adminError(resp); err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if len(file) != 0 {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// upload POSTs the file, or stdin, to the admin endpoint and prints what it
+// answers.
+func upload(endpoint, file string) error {
+	r := io.Reader(os.Stdin)
+	if len(file) != 0 {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	resp, err := http.Post(endpoint, "application/octet-stream", r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := adminError(resp); err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+// adminError returns the error answered by the admin API, if any.
+func adminError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,68 @@
+package proto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// CaptureMagic starts a capture file: the commands a node read from a
+// connection, written by the admin API for a bug report to be replayed
+// against a test server.
+const CaptureMagic = "GGCAPTURE1"
+
+// ErrInvalidCapture is returned when reading a file that is not a capture.
+var ErrInvalidCapture = errors.New("invalid capture file")
+
+// CaptureFrame is a command of a capture file, along with when it was read
+// since the capture started.
+type CaptureFrame struct {
+	Elapsed time.Duration
+	Command Appender
+}
+
+func (f *CaptureFrame) Bytes() []byte {
+	return f.AppendBytes(nil)
+}
+
+// AppendBytes appends the frame as the elapsed time in microseconds followed
+// by the command as it is sent.
+func (f *CaptureFrame) AppendBytes(b []byte) []byte {
+	b = appendUint64(b, uint64(f.Elapsed.Microseconds()))
+	return f.Command.AppendBytes(b)
+}
+
+// ReadCaptureMagic reads the start of a capture file, returning
+// ErrInvalidCapture if it is not one.
+func ReadCaptureMagic(r io.Reader) error {
+	b := make([]byte, len(CaptureMagic))
+	if _, err := io.ReadFull(r, b); err != nil || string(b) != CaptureMagic {
+		return ErrInvalidCapture
+	}
+	return nil
+}
+
+// ParseCaptureFrame reads the next frame of a capture file, after its
+// magic. It returns io.EOF at the end of the file.
+func ParseCaptureFrame(r io.Reader) (*CaptureFrame, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	elapsed := d.uint64()
+	switch {
+	case d.err == io.EOF:
+		return nil, io.EOF
+	case d.err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCapture, d.err)
+	}
+	cmd, err := parseCommand(d)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCapture, err)
+	}
+	appender, ok := cmd.(Appender)
+	if !ok {
+		return nil, ErrInvalidCapture
+	}
+	return &CaptureFrame{Elapsed: time.Duration(elapsed) * time.Microsecond, Command: appender}, nil
+}
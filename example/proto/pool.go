@@ -0,0 +1,270 @@
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxPooledBuffer is the largest buffer returned to the pool, so a single
+// large value does not keep a large buffer alive.
+const maxPooledBuffer = 64 << 10
+
+// bufferPool recycles the buffers messages are encoded into and the scratch
+// space fields are decoded from.
+var bufferPool = sync.Pool{
+	New: func() any {
+		poolStats.allocs.Add(1)
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+var poolStats struct {
+	gets   atomic.Uint64
+	allocs atomic.Uint64
+	drops  atomic.Uint64
+}
+
+// PoolStats report the use of the buffer pool shared by the encoders and
+// decoders of the process.
+type PoolStats struct {
+	// Gets is the number of buffers taken from the pool and Allocs the
+	// number of them that had to be allocated.
+	Gets   uint64
+	Allocs uint64
+	// Drops is the number of buffers not returned because they grew above
+	// 64KiB.
+	Drops uint64
+}
+
+// BufferPoolStats returns the stats of the buffer pool.
+func BufferPoolStats() PoolStats {
+	return PoolStats{
+		Gets:   poolStats.gets.Load(),
+		Allocs: poolStats.allocs.Load(),
+		Drops:  poolStats.drops.Load(),
+	}
+}
+
+func getBuffer() *[]byte {
+	poolStats.gets.Add(1)
+	b := bufferPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+func putBuffer(b *[]byte) {
+	if cap(*b) > maxPooledBuffer {
+		poolStats.drops.Add(1)
+		return
+	}
+	bufferPool.Put(b)
+}
+
+// Appender is implemented by the messages that can encode themselves at the
+// end of a buffer. Their Bytes method returns the same encoding.
+type Appender interface {
+	AppendBytes(b []byte) []byte
+}
+
+// WriteMessage encodes the message into a pooled buffer and writes it to w
+// with a single Write, without allocating.
+func WriteMessage(w io.Writer, m Appender) error {
+	buf := getBuffer()
+	*buf = m.AppendBytes(*buf)
+	_, err := w.Write(*buf)
+	putBuffer(buf)
+
+	return err
+}
+
+// minZeroCopy is the smallest value WriteGetResponse writes without copying.
+// Copying smaller values is cheaper than a writev.
+const minZeroCopy = 4 << 10
+
+// WriteGetResponse writes a ResponseGet with the value. Values of 4KiB or
+// more are not copied: the header and the value are written with a single
+// writev on connections that support it. The value must not be modified
+// until WriteGetResponse returns.
+func WriteGetResponse(w io.Writer, status Status, value []byte) error {
+	if hw, ok := w.(*HealthWriter); ok && !hw.stamped {
+		// Stamped here, so the value is still written with a writev.
+		status |= Status(hw.Health)
+		hw.stamped = true
+		w = hw.Conn
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	*buf = append(*buf, byte(status))
+	if len(value) < minZeroCopy {
+		*buf = appendField(*buf, value)
+		_, err := w.Write(*buf)
+		return err
+	}
+
+	*buf = appendInt32(*buf, int32(len(value)))
+	bufs := net.Buffers{*buf, value}
+	_, err := bufs.WriteTo(w)
+	return err
+}
+
+// HealthWriter is the connection a response is written to with the Health
+// of the node: the first byte written, which is the status, is stamped with
+// it. It is meant for a single response.
+type HealthWriter struct {
+	net.Conn
+	Health Health
+
+	stamped bool
+}
+
+func (w *HealthWriter) Write(b []byte) (int, error) {
+	if w.stamped || len(b) == 0 {
+		return w.Conn.Write(b)
+	}
+	w.stamped = true
+	status := b[0]
+	b[0] |= byte(w.Health)
+	n, err := w.Conn.Write(b)
+	b[0] = status
+	return n, err
+}
+
+// decoder reads the fixed-size fields of a message through a pooled scratch
+// buffer, as binary.Read allocates one on every call.
+type decoder struct {
+	r   io.Reader
+	buf *[]byte
+	err error
+}
+
+func newDecoder(r io.Reader) *decoder {
+	buf := getBuffer()
+	*buf = (*buf)[:8]
+	return &decoder{r: r, buf: buf}
+}
+
+// release returns the scratch buffer to the pool.
+func (d *decoder) release() {
+	putBuffer(d.buf)
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	b := (*d.buf)[:n]
+	_, d.err = io.ReadFull(d.r, b)
+	if d.err != nil {
+		return nil
+	}
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.read(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.read(4); b != nil {
+		return int32(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.read(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// status reads the status of a response, stripped of the Health of the node,
+// and the Redirect following it if it carries one.
+func (d *decoder) status() Status {
+	b := d.byte()
+	if hr, ok := d.r.(HealthRecorder); ok && d.err == nil {
+		hr.RecordHealth(Health(b) & healthMask)
+	}
+	s := Status(b &^ byte(healthMask))
+	if d.err == nil && s.HasRedirect() {
+		d.redirect(s)
+	}
+	return s
+}
+
+// redirect reads the Redirect following the status and makes it the error
+// of the decoder, so the rest of the response reads as zero values.
+func (d *decoder) redirect(status Status) {
+	r := &Redirect{Status: status, Addr: string(d.bytes())}
+	r.RetryAfter = time.Duration(d.uint64()) * time.Millisecond
+	if d.err == nil {
+		d.err = r
+	}
+}
+
+// readStatus reads the status of a response that is not read with a
+// decoder, and the Redirect following it, as its error, if it carries one.
+func readStatus(r io.Reader) (Status, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	return d.status(), d.err
+}
+
+// DefaultMaxFieldSize is the largest field, e.g. a key or a value, a message
+// is parsed with, unless its reader is a FieldLimiter.
+const DefaultMaxFieldSize = 512 << 20
+
+// FieldLimiter is implemented by the readers messages are parsed from that
+// bound the size of their fields, e.g. the connections of a server. A field
+// larger than MaxFieldSize, DefaultMaxFieldSize if zero, fails the parse
+// with ErrTooLarge before it is allocated.
+type FieldLimiter interface {
+	MaxFieldSize() int
+}
+
+// bytes reads a length-prefixed field into a new slice, as the cache keeps
+// keys and values after the message is handled.
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if d.err != nil || n <= 0 {
+		return make([]byte, 0)
+	}
+	max := DefaultMaxFieldSize
+	if fl, ok := d.r.(FieldLimiter); ok && fl.MaxFieldSize() > 0 {
+		max = fl.MaxFieldSize()
+	}
+	if int(n) > max {
+		d.err = fmt.Errorf("%w: field of %d bytes", ErrTooLarge, n)
+		return make([]byte, 0)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = err
+	}
+	return b
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	return binary.LittleEndian.AppendUint32(b, uint32(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+func appendField(b, field []byte) []byte {
+	b = appendInt32(b, int32(len(field)))
+	return append(b, field...)
+}
@@ -3,8 +3,16 @@ package proto
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthdm/ggcache"
 )
 
 type Status byte
@@ -17,6 +25,38 @@ func (s Status) String() string {
 		return "OK"
 	case StatusKeyNotFound:
 		return "KEYNOTFOUND"
+	case StatusTooLarge:
+		return "TOOLARGE"
+	case StatusLeaseGranted:
+		return "LEASEGRANTED"
+	case StatusLeaseHeld:
+		return "LEASEHELD"
+	case StatusLeaseInvalid:
+		return "LEASEINVALID"
+	case StatusPreconditionFailed:
+		return "PRECONDITIONFAILED"
+	case StatusUnauthorized:
+		return "UNAUTHORIZED"
+	case StatusQuotaExceeded:
+		return "QUOTAEXCEEDED"
+	case StatusInvalidValue:
+		return "INVALIDVALUE"
+	case StatusMoved:
+		return "MOVED"
+	case StatusRetry:
+		return "RETRY"
+	case StatusPersistenceError:
+		return "PERSISTENCEERROR"
+	case StatusDeadlineExceeded:
+		return "DEADLINEEXCEEDED"
+	case StatusCrossSlot:
+		return "CROSSSLOT"
+	case StatusOverloaded:
+		return "OVERLOADED"
+	case StatusTimeout:
+		return "TIMEOUT"
+	case StatusStale:
+		return "STALE"
 	default:
 		return "NONE"
 	}
@@ -27,8 +67,164 @@ const (
 	StatusOK
 	StatusError
 	StatusKeyNotFound
+	// StatusTooLarge answers a UDP GET whose value does not fit the size
+	// cutoff; the value has to be read over TCP.
+	StatusTooLarge
+	// StatusLeaseGranted answers a GETLEASE miss with a lease token to fill
+	// the key with, StatusLeaseHeld one for a key another client holds the
+	// lease of. StatusLeaseInvalid answers a SETLEASE whose lease expired or
+	// was invalidated by a write to the key.
+	StatusLeaseGranted
+	StatusLeaseHeld
+	StatusLeaseInvalid
+	// StatusPreconditionFailed answers a SETIF whose condition does not hold
+	// for the current value.
+	StatusPreconditionFailed
+	// StatusUnauthorized answers an AUTH with an unknown token, and the
+	// commands of a connection that is not authenticated or whose tenant is
+	// not allowed to send them. StatusQuotaExceeded answers the commands of a
+	// tenant over its request quota, with a Redirect saying when it has
+	// quota again.
+	StatusUnauthorized
+	StatusQuotaExceeded
+	// StatusInvalidValue answers a write whose value is rejected by the
+	// validators of its namespace.
+	StatusInvalidValue
+	// StatusMoved answers a command with the address of the node to send it
+	// to instead, and StatusRetry one the node cannot serve for now with how
+	// long to wait before sending it again. Both carry a Redirect.
+	StatusMoved
+	StatusRetry
+	// StatusPersistenceError answers a write the node did not store as it
+	// cannot persist it, or rejects while its persistence fails.
+	StatusPersistenceError
+	// StatusDeadlineExceeded answers a command carried by a CommandDeadline
+	// that the node did not run, or stopped running, as its deadline passed.
+	StatusDeadlineExceeded
+	// StatusCrossSlot answers a CommandMulti whose keys do not all have the
+	// same hash tag, so a sharded cluster could not apply it on one node.
+	StatusCrossSlot
+	// StatusOverloaded answers a command the node shed without handling it,
+	// as it was already handling as many commands, or as many bytes of
+	// requests, as it is allowed to.
+	StatusOverloaded
+	// StatusTimeout answers a command the node stopped as it ran for longer
+	// than the node lets the commands of its kind run.
+	StatusTimeout
+	// StatusStale answers a GET or GETRANGE with the value of a key past its
+	// expiration that was not removed yet, which the node serves as its
+	// cache is set to with ggcache.ExpiredStale.
+	StatusStale
+)
+
+var (
+	// ErrKeyNotFound is ggcache.ErrKeyNotFound, the error of StatusKeyNotFound.
+	ErrKeyNotFound = ggcache.ErrKeyNotFound
+
+	// ErrStale is ggcache.ErrStale, the error of StatusStale.
+	ErrStale = ggcache.ErrStale
+
+	// ErrTooLarge is the error of StatusTooLarge, and is wrapped by the
+	// errors of the Parse functions for a message with more elements, or
+	// larger fields, than they accept.
+	ErrTooLarge = errors.New("too large")
+
+	// ErrReadOnly is matched by the Redirect of a write sent to a node that
+	// does not take writes.
+	ErrReadOnly = errors.New("read only")
+
+	// ErrUnauthorized is the error of StatusUnauthorized.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Health is the degraded states of a node. It is sent in the top bits of the
+// status of every response, below which the statuses fit, so clients can
+// move their traffic off a node before it fails their commands. The Parse
+// functions strip it from the Status they return and hand it to the reader
+// if it is a HealthRecorder.
+type Health byte
+
+const (
+	// HealthReadOnly is set while the node rejects the writes of clients, as
+	// it cannot persist them.
+	HealthReadOnly Health = 0x80 >> iota
+	// HealthSyncing is set while the node restores a snapshot of its leader,
+	// so its reads may miss keys that are there.
+	HealthSyncing
+	// HealthHighMemory is set while the node uses more memory than it is
+	// meant to.
+	HealthHighMemory
+
+	healthMask = HealthReadOnly | HealthSyncing | HealthHighMemory
 )
 
+func (h Health) String() string {
+	if h == 0 {
+		return "OK"
+	}
+	var flags []string
+	if h&HealthReadOnly != 0 {
+		flags = append(flags, "READONLY")
+	}
+	if h&HealthSyncing != 0 {
+		flags = append(flags, "SYNCING")
+	}
+	if h&HealthHighMemory != 0 {
+		flags = append(flags, "HIGHMEMORY")
+	}
+	return strings.Join(flags, "|")
+}
+
+// HealthRecorder is implemented by the readers responses are parsed from
+// that keep the Health the node sent along with them, e.g. the connection
+// of a client.
+type HealthRecorder interface {
+	RecordHealth(h Health)
+}
+
+// HasRedirect reports whether the status is followed by a Redirect instead
+// of the rest of the response.
+func (s Status) HasRedirect() bool {
+	return s == StatusMoved || s == StatusRetry || s == StatusQuotaExceeded
+}
+
+// Redirect tells a client where or when to send a command again, so clients
+// and proxies can follow a move or back off without parsing error text. It
+// answers any command in place of its response, and is returned as the error
+// of the Parse functions that read it.
+type Redirect struct {
+	Status     Status
+	Addr       string
+	RetryAfter time.Duration
+}
+
+func (r *Redirect) Error() string {
+	switch {
+	case len(r.Addr) != 0:
+		return fmt.Sprintf("%s: retry on [%s]", r.Status, r.Addr)
+	case r.RetryAfter > 0:
+		return fmt.Sprintf("%s: retry after %s", r.Status, r.RetryAfter)
+	default:
+		return r.Status.String()
+	}
+}
+
+// Is makes a Redirect with StatusMoved match ErrReadOnly, as it answers a
+// write sent to a follower.
+func (r *Redirect) Is(target error) bool {
+	return target == ErrReadOnly && r.Status == StatusMoved
+}
+
+func (r *Redirect) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *Redirect) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	b = appendField(b, []byte(r.Addr))
+	return appendUint64(b, uint64(r.RetryAfter.Milliseconds()))
+}
+
 type Command byte
 
 const (
@@ -37,6 +233,42 @@ const (
 	CmdGet
 	CmdDel
 	CmdJoin
+	CmdStats
+	CmdTouch
+	CmdFill
+	CmdBackup
+	CmdBatch
+	CmdGetLease
+	CmdSetLease
+	CmdAppend
+	CmdGetRange
+	CmdSetIf
+	CmdAuth
+	CmdCancel
+	CmdTopology
+	CmdRename
+	CmdCopy
+	CmdSync
+	CmdPromote
+	CmdFlush
+	CmdXAdd
+	CmdXRange
+	CmdXRead
+	CmdPublish
+	CmdSubscribe
+	CmdScan
+	CmdUndelete
+	CmdDeadline
+	CmdOffset
+	CmdPurge
+	CmdPing
+	CmdNoReply
+	CmdMulti
+	CmdMGet
+	CmdInfo
+	CmdAt
+	CmdClock
+	CmdDelIf
 )
 
 type ResponseSet struct {
@@ -44,10 +276,11 @@ type ResponseSet struct {
 }
 
 func (r ResponseSet) Bytes() []byte {
-	buf := new(bytes.Buffer)
-	_ = binary.Write(buf, binary.LittleEndian, r.Status)
+	return r.AppendBytes(nil)
+}
 
-	return buf.Bytes()
+func (r ResponseSet) AppendBytes(b []byte) []byte {
+	return append(b, byte(r.Status))
 }
 
 type ResponseGet struct {
@@ -56,126 +289,1921 @@ type ResponseGet struct {
 }
 
 func (r *ResponseGet) Bytes() []byte {
-	buf := new(bytes.Buffer)
-	_ = binary.Write(buf, binary.LittleEndian, r.Status)
+	return r.AppendBytes(nil)
+}
 
-	valueLen := int32(len(r.Value))
-	_ = binary.Write(buf, binary.LittleEndian, valueLen)
-	_ = binary.Write(buf, binary.LittleEndian, r.Value)
+func (r *ResponseGet) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	return appendField(b, r.Value)
+}
 
-	return buf.Bytes()
+type ResponseDelete struct {
+	Status Status
+}
+
+func (r ResponseDelete) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r ResponseDelete) AppendBytes(b []byte) []byte {
+	return append(b, byte(r.Status))
+}
+
+func ParseDeleteResponse(r io.Reader) (*ResponseDelete, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	return &ResponseDelete{Status: d.status()}, d.err
+}
+
+type ResponseTouch struct {
+	Status Status
+}
+
+func (r ResponseTouch) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r ResponseTouch) AppendBytes(b []byte) []byte {
+	return append(b, byte(r.Status))
+}
+
+func ParseTouchResponse(r io.Reader) (*ResponseTouch, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	return &ResponseTouch{Status: d.status()}, d.err
 }
 
 func ParseSetResponse(r io.Reader) (*ResponseSet, error) {
-	resp := &ResponseSet{}
-	err := binary.Read(r, binary.LittleEndian, &resp.Status)
-	return resp, err
+	d := newDecoder(r)
+	defer d.release()
+
+	return &ResponseSet{Status: d.status()}, d.err
 }
 
 func ParseGetResponse(r io.Reader) (*ResponseGet, error) {
+	d := newDecoder(r)
+	defer d.release()
+
 	resp := &ResponseGet{}
-	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
-		return resp, err
+	resp.Status = d.status()
+	resp.Value = d.bytes()
+
+	return resp, d.err
+}
+
+// CommandJoin makes the connection that of a follower, which the leader
+// forwards its mutations over. ReadAddr is where the follower serves reads,
+// advertised by the leader in its ResponseTopology; empty if it serves none.
+// Zone is the availability zone of the follower, if known. Secret is the
+// cluster secret the leader may require of its followers. Serving is how
+// long the follower has been serving reads, so the leader only ramps up the
+// weight of a cold one in its ResponseTopology.
+type CommandJoin struct {
+	ReadAddr string
+	Zone     string
+	Secret   string
+	Serving  time.Duration
+}
+
+func (c *CommandJoin) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandJoin) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdJoin))
+	b = appendField(b, []byte(c.ReadAddr))
+	b = appendField(b, []byte(c.Zone))
+	b = appendField(b, []byte(c.Secret))
+	return appendUint64(b, uint64(c.Serving.Milliseconds()))
+}
+
+// maxTopologyReplicas bounds the number of replicas in a ResponseTopology.
+const maxTopologyReplicas = 1 << 16
+
+// CommandTopology asks a node where to send writes and reads.
+type CommandTopology struct{}
+
+func (c *CommandTopology) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandTopology) AppendBytes(b []byte) []byte {
+	return append(b, byte(CmdTopology))
+}
+
+// CommandPing checks that a node still answers on the connection, e.g. to
+// tell a dead connection that was idle apart before a command is sent on
+// it. It is answered with a ResponseSet.
+type CommandPing struct{}
+
+func (c *CommandPing) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandPing) AppendBytes(b []byte) []byte {
+	return append(b, byte(CmdPing))
+}
+
+// ResponseTopology carries the address of the leader, which takes the
+// writes, and the read endpoints of the replicas. The leader lists every
+// follower that joined it with one; a follower only lists itself. Zones
+// holds the zone of each replica, empty if unknown.
+//
+// Weights holds the share of a full load of reads each replica is ready
+// for, in percent, 100 if missing. A replica that just started serving is
+// cold: its weight ramps up linearly to 100 in the time left in Ramps, so
+// the clients move the reads to it gradually.
+type ResponseTopology struct {
+	Status   Status
+	Leader   string
+	Replicas []string
+	Zones    []string
+	Weights  []uint8
+	Ramps    []time.Duration
+}
+
+func (r *ResponseTopology) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponseTopology) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	b = appendField(b, []byte(r.Leader))
+	b = appendInt32(b, int32(len(r.Replicas)))
+	for i, addr := range r.Replicas {
+		b = appendField(b, []byte(addr))
+		var zone string
+		if i < len(r.Zones) {
+			zone = r.Zones[i]
+		}
+		b = appendField(b, []byte(zone))
+		weight, ramp := uint8(100), time.Duration(0)
+		if i < len(r.Weights) {
+			weight = r.Weights[i]
+		}
+		if i < len(r.Ramps) {
+			ramp = r.Ramps[i]
+		}
+		b = append(b, weight)
+		b = appendUint64(b, uint64(ramp.Milliseconds()))
+	}
+	return b
+}
+
+func ParseTopologyResponse(r io.Reader) (*ResponseTopology, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseTopology{Status: d.status()}
+	resp.Leader = string(d.bytes())
+	n := d.int32()
+	if d.err != nil {
+		return resp, d.err
+	}
+	if n < 0 {
+		return resp, fmt.Errorf("invalid topology length %d", n)
+	}
+	if n > maxTopologyReplicas {
+		return resp, fmt.Errorf("%w: topology of %d replicas", ErrTooLarge, n)
+	}
+	for i := int32(0); i < n && d.err == nil; i++ {
+		resp.Replicas = append(resp.Replicas, string(d.bytes()))
+		resp.Zones = append(resp.Zones, string(d.bytes()))
+		resp.Weights = append(resp.Weights, d.byte())
+		resp.Ramps = append(resp.Ramps, time.Duration(d.uint64())*time.Millisecond)
+	}
+	return resp, d.err
+}
+
+// maxInfoCapabilities bounds the number of capabilities in a ResponseInfo.
+const maxInfoCapabilities = 1 << 10
+
+// The capabilities a ResponseInfo lists, the optional features enabled on
+// the node.
+const (
+	// CapabilityAuth requires connections to authenticate with AUTH.
+	CapabilityAuth = "auth"
+	// CapabilityPersistence persists the writes, to backups or the engine.
+	CapabilityPersistence = "persistence"
+	// CapabilityFullSync streams a snapshot to the followers that join.
+	CapabilityFullSync = "full_sync"
+	// CapabilityScan serves SCAN and FLUSH of a pattern.
+	CapabilityScan = "scan"
+	// CapabilityMulti serves MULTI.
+	CapabilityMulti = "multi"
+	// CapabilityUndelete keeps the deleted values for UNDELETE.
+	CapabilityUndelete = "undelete"
+)
+
+// CommandInfo asks a node for the build it runs and the capabilities it has
+// enabled, so clients and tooling can gate their features on them. It is
+// answered with a ResponseInfo.
+type CommandInfo struct{}
+
+func (c *CommandInfo) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandInfo) AppendBytes(b []byte) []byte {
+	return append(b, byte(CmdInfo))
+}
+
+// ResponseInfo carries the version and VCS commit the node was built from,
+// either empty if unknown, and its capabilities, sorted.
+type ResponseInfo struct {
+	Status       Status
+	Version      string
+	Commit       string
+	Capabilities []string
+}
+
+// Has reports whether the node has the capability.
+func (r *ResponseInfo) Has(capability string) bool {
+	return slices.Contains(r.Capabilities, capability)
+}
+
+func (r *ResponseInfo) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponseInfo) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	b = appendField(b, []byte(r.Version))
+	b = appendField(b, []byte(r.Commit))
+	b = appendInt32(b, int32(len(r.Capabilities)))
+	for _, capability := range r.Capabilities {
+		b = appendField(b, []byte(capability))
+	}
+	return b
+}
+
+func ParseInfoResponse(r io.Reader) (*ResponseInfo, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseInfo{Status: d.status()}
+	resp.Version = string(d.bytes())
+	resp.Commit = string(d.bytes())
+	n := d.int32()
+	if d.err != nil {
+		return resp, d.err
+	}
+	if n < 0 {
+		return resp, fmt.Errorf("invalid capability count %d", n)
+	}
+	if n > maxInfoCapabilities {
+		return resp, fmt.Errorf("%w: %d capabilities", ErrTooLarge, n)
+	}
+	for i := int32(0); i < n && d.err == nil; i++ {
+		resp.Capabilities = append(resp.Capabilities, string(d.bytes()))
+	}
+	return resp, d.err
+}
+
+type CommandStats struct{}
+
+func (c *CommandStats) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandStats) AppendBytes(b []byte) []byte {
+	return append(b, byte(CmdStats))
+}
+
+// maxStats bounds the number of stats in a ResponseStats.
+const maxStats = 1 << 16
+
+type Stat struct {
+	Name  string
+	Value int64
+}
+
+type ResponseStats struct {
+	Status Status
+	Stats  []Stat
+}
+
+func (r *ResponseStats) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.LittleEndian, r.Status)
+
+	_ = binary.Write(buf, binary.LittleEndian, int32(len(r.Stats)))
+	for _, stat := range r.Stats {
+		_ = binary.Write(buf, binary.LittleEndian, int32(len(stat.Name)))
+		buf.WriteString(stat.Name)
+		_ = binary.Write(buf, binary.LittleEndian, stat.Value)
 	}
 
-	var valueLen int32
-	if err := binary.Read(r, binary.LittleEndian, &valueLen); err != nil {
-		return resp, err
+	return buf.Bytes()
+}
+
+func ParseStatsResponse(r io.Reader) (*ResponseStats, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseStats{Status: d.status()}
+	n := d.int32()
+	if d.err != nil {
+		return resp, d.err
+	}
+	if n < 0 {
+		return resp, fmt.Errorf("invalid stat count %d", n)
+	}
+	if n > maxStats {
+		return resp, fmt.Errorf("%w: %d stats", ErrTooLarge, n)
 	}
 
-	resp.Value = make([]byte, valueLen)
-	if err := binary.Read(r, binary.LittleEndian, &resp.Value); err != nil {
-		return resp, err
+	resp.Stats = make([]Stat, 0, min(n, 1024))
+	for i := int32(0); i < n && d.err == nil; i++ {
+		name := d.bytes()
+		resp.Stats = append(resp.Stats, Stat{Name: string(name), Value: int64(d.uint64())})
+	}
+	if d.err != nil {
+		return resp, d.err
 	}
+	return resp, nil
+}
+
+// CommandBackup asks the node to upload a snapshot of its cache to the
+// configured backup store. A non-zero ID lets the client abandon the upload
+// with CommandCancel.
+type CommandBackup struct {
+	ID uint64
+}
+
+func (c *CommandBackup) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandBackup) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdBackup))
+	return appendUint64(b, c.ID)
+}
+
+// ResponseBackup carries the name of the backup object that was written.
+type ResponseBackup struct {
+	Status Status
+	Name   string
+}
+
+func (r *ResponseBackup) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.LittleEndian, r.Status)
+
+	_ = binary.Write(buf, binary.LittleEndian, int32(len(r.Name)))
+	buf.WriteString(r.Name)
+
+	return buf.Bytes()
+}
 
+func ParseBackupResponse(r io.Reader) (*ResponseBackup, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseBackup{Status: d.status()}
+	name := d.bytes()
+	if d.err != nil {
+		return resp, d.err
+	}
+	resp.Name = string(name)
 	return resp, nil
 }
 
-type CommandJoin struct{}
+// CommandGetLease reads a key like CommandGet. On a miss, the first client
+// is granted a lease to fill the key with CommandSetLease, and the others are
+// told to retry until it is filled or the lease expires.
+type CommandGetLease struct {
+	Key []byte
+}
 
-type CommandSet struct {
+func (c *CommandGetLease) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandGetLease) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdGetLease))
+	return appendField(b, c.Key)
+}
+
+// ResponseGetLease carries the value on a hit, or the lease token if the
+// status is StatusLeaseGranted.
+type ResponseGetLease struct {
+	Status Status
+	Value  []byte
+	Token  uint64
+}
+
+func (r *ResponseGetLease) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponseGetLease) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	b = appendField(b, r.Value)
+	return appendUint64(b, r.Token)
+}
+
+func ParseGetLeaseResponse(r io.Reader) (*ResponseGetLease, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseGetLease{}
+	resp.Status = d.status()
+	resp.Value = d.bytes()
+	resp.Token = d.uint64()
+
+	return resp, d.err
+}
+
+// CommandSetLease sets a key with the token of the lease granted by
+// CommandGetLease. It is answered with a ResponseSet.
+type CommandSetLease struct {
 	Key   []byte
 	Value []byte
+	// TTL in milliseconds, zero means no expiration.
 	TTL   int
+	Token uint64
 }
 
-func (c *CommandSet) Bytes() []byte {
-	buf := new(bytes.Buffer)
-	_ = binary.Write(buf, binary.LittleEndian, CmdSet)
+func (c *CommandSetLease) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
 
-	keyLen := int32(len(c.Key))
-	_ = binary.Write(buf, binary.LittleEndian, keyLen)
-	_ = binary.Write(buf, binary.LittleEndian, c.Key)
+func (c *CommandSetLease) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdSetLease))
+	b = appendField(b, c.Key)
+	b = appendField(b, c.Value)
+	b = appendInt32(b, int32(c.TTL))
+	return appendUint64(b, c.Token)
+}
 
-	valueLen := int32(len(c.Value))
-	_ = binary.Write(buf, binary.LittleEndian, valueLen)
-	_ = binary.Write(buf, binary.LittleEndian, c.Value)
+// CommandAppend appends data to the value of an existing key.
+type CommandAppend struct {
+	Key  []byte
+	Data []byte
+}
 
-	_ = binary.Write(buf, binary.LittleEndian, int32(c.TTL))
+func (c *CommandAppend) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
 
-	return buf.Bytes()
+func (c *CommandAppend) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdAppend))
+	b = appendField(b, c.Key)
+	return appendField(b, c.Data)
 }
 
-type CommandGet struct {
+type ResponseAppend struct {
+	Status Status
+}
+
+func (r ResponseAppend) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r ResponseAppend) AppendBytes(b []byte) []byte {
+	return append(b, byte(r.Status))
+}
+
+func ParseAppendResponse(r io.Reader) (*ResponseAppend, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	return &ResponseAppend{Status: d.status()}, d.err
+}
+
+// CommandRename moves the value of Key to NewKey atomically, keeping its
+// expiration. It is answered with a ResponseSet, StatusKeyNotFound if Key is
+// missing.
+type CommandRename struct {
+	Key    []byte
+	NewKey []byte
+}
+
+func (c *CommandRename) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandRename) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdRename))
+	b = appendField(b, c.Key)
+	return appendField(b, c.NewKey)
+}
+
+// CommandCopy stores the value of Key under Dst atomically. It is answered
+// with a ResponseSet, StatusKeyNotFound if Key is missing.
+type CommandCopy struct {
 	Key []byte
+	Dst []byte
+	// TTL of the copy in milliseconds, zero means no expiration.
+	TTL int
 }
 
-func (c *CommandGet) Bytes() []byte {
-	buf := new(bytes.Buffer)
-	_ = binary.Write(buf, binary.LittleEndian, CmdGet)
+func (c *CommandCopy) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
 
-	keyLen := int32(len(c.Key))
-	_ = binary.Write(buf, binary.LittleEndian, keyLen)
-	_ = binary.Write(buf, binary.LittleEndian, c.Key)
+func (c *CommandCopy) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdCopy))
+	b = appendField(b, c.Key)
+	b = appendField(b, c.Dst)
+	return appendInt32(b, int32(c.TTL))
+}
 
-	return buf.Bytes()
+// CommandGetRange reads up to Length bytes of a value starting at Offset. It
+// is answered with a ResponseGet.
+type CommandGetRange struct {
+	Key    []byte
+	Offset int
+	Length int
 }
 
-func ParseCommand(r io.Reader) (any, error) {
-	var cmd Command
-	if err := binary.Read(r, binary.LittleEndian, &cmd); err != nil {
-		return nil, err
-	}
+func (c *CommandGetRange) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
 
-	switch cmd {
-	case CmdSet:
-		return parseSetCommand(r), nil
-	case CmdGet:
-		return parseGetCommand(r), nil
-	case CmdJoin:
-		return &CommandJoin{}, nil
-	default:
-		return nil, fmt.Errorf("invalid command")
-	}
+func (c *CommandGetRange) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdGetRange))
+	b = appendField(b, c.Key)
+	b = appendInt32(b, int32(c.Offset))
+	return appendInt32(b, int32(c.Length))
+}
+
+// CommandSetIf sets a key if the current value matches the conditions, with
+// the semantics of the HTTP If-Match and If-None-Match headers on the ETag
+// of the value. Empty conditions are ignored. It is answered with a
+// ResponseSet.
+type CommandSetIf struct {
+	Key   []byte
+	Value []byte
+	// TTL in milliseconds, zero means no expiration.
+	TTL         int
+	IfMatch     []byte
+	IfNoneMatch []byte
+}
+
+func (c *CommandSetIf) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandSetIf) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdSetIf))
+	b = appendField(b, c.Key)
+	b = appendField(b, c.Value)
+	b = appendInt32(b, int32(c.TTL))
+	b = appendField(b, c.IfMatch)
+	return appendField(b, c.IfNoneMatch)
+}
+
+// CommandDelIf deletes a key if its current value has the ETag IfMatch, or
+// if it is present with any value if IfMatch is "*", like CommandSetIf. It
+// is answered with a ResponseDelete, with StatusPreconditionFailed if the
+// value does not match.
+type CommandDelIf struct {
+	Key     []byte
+	IfMatch []byte
+}
+
+func (c *CommandDelIf) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandDelIf) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdDelIf))
+	b = appendField(b, c.Key)
+	return appendField(b, c.IfMatch)
+}
+
+// CommandAuth authenticates the connection with the token of a tenant. It
+// must be the first command when the server has tenants configured.
+type CommandAuth struct {
+	Token []byte
+}
+
+func (c *CommandAuth) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandAuth) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdAuth))
+	return appendField(b, c.Token)
+}
+
+type ResponseAuth struct {
+	Status Status
+}
+
+func (r ResponseAuth) Bytes() []byte {
+	return r.AppendBytes(nil)
 }
 
-func parseSetCommand(r io.Reader) *CommandSet {
-	cmd := &CommandSet{}
+func (r ResponseAuth) AppendBytes(b []byte) []byte {
+	return append(b, byte(r.Status))
+}
+
+func ParseAuthResponse(r io.Reader) (*ResponseAuth, error) {
+	d := newDecoder(r)
+	defer d.release()
 
-	var keyLen int32
-	_ = binary.Read(r, binary.LittleEndian, &keyLen)
-	cmd.Key = make([]byte, keyLen)
-	_ = binary.Read(r, binary.LittleEndian, &cmd.Key)
+	return &ResponseAuth{Status: d.status()}, d.err
+}
 
-	var valueLen int32
-	_ = binary.Read(r, binary.LittleEndian, &valueLen)
-	cmd.Value = make([]byte, valueLen)
-	_ = binary.Read(r, binary.LittleEndian, &cmd.Value)
+// maxBatchCommands bounds the number of commands in a CommandBatch.
+const maxBatchCommands = 1 << 20
 
-	var ttl int32
-	_ = binary.Read(r, binary.LittleEndian, &ttl)
-	cmd.TTL = int(ttl)
+// CommandBatch carries SET, DEL, TOUCH, APPEND, RENAME, COPY, XADD, PUBLISH
+// and MULTI commands to be applied in order, the ones with a TTL possibly
+// within a CommandAt.
+// The leader replicates its mutations to the members with it. It is
+// answered with a single ResponseBatch.
+type CommandBatch struct {
+	Commands []Appender
+}
 
-	return cmd
+func (c *CommandBatch) Bytes() []byte {
+	return c.AppendBytes(nil)
 }
 
-func parseGetCommand(r io.Reader) *CommandGet {
-	cmd := &CommandGet{}
+func (c *CommandBatch) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdBatch))
+	b = appendInt32(b, int32(len(c.Commands)))
+	for _, cmd := range c.Commands {
+		b = cmd.AppendBytes(b)
+	}
+	return b
+}
 
-	var keyLen int32
-	_ = binary.Read(r, binary.LittleEndian, &keyLen)
-	cmd.Key = make([]byte, keyLen)
-	_ = binary.Read(r, binary.LittleEndian, &cmd.Key)
+type ResponseBatch struct {
+	Status Status
+}
 
-	return cmd
+func (r ResponseBatch) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r ResponseBatch) AppendBytes(b []byte) []byte {
+	return append(b, byte(r.Status))
+}
+
+func ParseBatchResponse(r io.Reader) (*ResponseBatch, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	return &ResponseBatch{Status: d.status()}, d.err
+}
+
+type CommandSet struct {
+	Key   []byte
+	Value []byte
+	// TTL in milliseconds, zero means no expiration.
+	TTL int
+}
+
+func (c *CommandSet) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandSet) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdSet))
+	b = appendField(b, c.Key)
+	b = appendField(b, c.Value)
+	return appendInt32(b, int32(c.TTL))
+}
+
+type CommandGet struct {
+	Key []byte
+}
+
+func (c *CommandGet) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandGet) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdGet))
+	return appendField(b, c.Key)
+}
+
+type CommandDel struct {
+	Key []byte
+}
+
+func (c *CommandDel) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandDel) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdDel))
+	return appendField(b, c.Key)
+}
+
+type CommandTouch struct {
+	Key []byte
+	// TTL in milliseconds, zero means no expiration.
+	TTL int
+}
+
+func (c *CommandTouch) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandTouch) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdTouch))
+	b = appendField(b, c.Key)
+	return appendInt32(b, int32(c.TTL))
+}
+
+// CommandFill asks the node owning the key for its value, loading it on that
+// node if it is missing. It is answered with a ResponseGet. A non-zero ID
+// lets the client abandon the load with CommandCancel.
+type CommandFill struct {
+	Key []byte
+	ID  uint64
+}
+
+func (c *CommandFill) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandFill) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdFill))
+	b = appendField(b, c.Key)
+	return appendUint64(b, c.ID)
+}
+
+// CommandCancel abandons the command with the ID that the connection sent
+// and that is still in progress, such as a FILL waiting on a slow source. It
+// is not answered: the cancelled command is, usually with StatusError, and
+// cancelling a command that is done does nothing.
+type CommandCancel struct {
+	ID uint64
+}
+
+func (c *CommandCancel) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandCancel) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdCancel))
+	return appendUint64(b, c.ID)
+}
+
+// CommandDeadline carries a command along with the time the client waits
+// for its response, in milliseconds. The node takes the deadline from when
+// it reads the command rather than from the clock of the client, skips the
+// command if it passed before the command is run, and stops the long ones,
+// such as SCAN, at the deadline. Either way the command is answered with
+// StatusDeadlineExceeded. A CommandDeadline cannot carry another one.
+type CommandDeadline struct {
+	Timeout time.Duration
+	Command Appender
+}
+
+func (c *CommandDeadline) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandDeadline) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdDeadline))
+	b = appendUint64(b, uint64(c.Timeout.Milliseconds()))
+	return c.Command.AppendBytes(b)
+}
+
+// CommandOffset carries a command along with a replication offset, which
+// counts the mutations the leader replicated. Sent by a client, a read waits
+// on a follower until it applied the mutations up to the offset, or is
+// answered with a Redirect to the leader, and a write is answered with a
+// ResponseOffset after its response, unless that is a Redirect. Sent by the
+// leader, it tells a follower the offset of the mutations it carries. A
+// CommandOffset can be carried by a CommandDeadline, but not the other way.
+type CommandOffset struct {
+	Offset  uint64
+	Command Appender
+}
+
+func (c *CommandOffset) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandOffset) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdOffset))
+	b = appendUint64(b, c.Offset)
+	return c.Command.AppendBytes(b)
+}
+
+// ResponseOffset follows the response to a write carried by a
+// CommandOffset with the replication offset of the leader once the write is
+// done, which a read of the same session then carries to see it.
+type ResponseOffset struct {
+	Offset uint64
+}
+
+func (r *ResponseOffset) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponseOffset) AppendBytes(b []byte) []byte {
+	return appendUint64(b, r.Offset)
+}
+
+func ParseOffsetResponse(r io.Reader) (*ResponseOffset, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	return &ResponseOffset{Offset: d.uint64()}, d.err
+}
+
+// CommandNoReply carries a SET or a DEL that is not answered at all, not
+// even with a Redirect or the ResponseOffset of a session, so a client can
+// send writes it does not need acknowledged without waiting on each. It can
+// be carried by a CommandDeadline or a CommandOffset, but carries nothing
+// else.
+type CommandNoReply struct {
+	Command Appender
+}
+
+func (c *CommandNoReply) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandNoReply) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdNoReply))
+	return c.Command.AppendBytes(b)
+}
+
+// maxMultiCommands bounds the number of commands in a CommandMulti.
+const maxMultiCommands = 1 << 16
+
+// CommandMulti carries SET and DEL commands applied atomically, in order.
+// Their keys must all have the same hash tag, as keys of different ones may
+// be owned by different nodes. It is answered with a single ResponseBatch,
+// and replicated within a CommandBatch as is.
+type CommandMulti struct {
+	Commands []Appender
+}
+
+func (c *CommandMulti) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandMulti) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdMulti))
+	b = appendInt32(b, int32(len(c.Commands)))
+	for _, cmd := range c.Commands {
+		b = cmd.AppendBytes(b)
+	}
+	return b
+}
+
+// CommandAt carries a SET, TOUCH, COPY, XADD or MULTI replicated by the
+// leader along with the time it was made on the clock of the leader, in
+// unix nanoseconds, so its TTLs are absolute expirations rather than
+// counting from when a member applies it. A member converts them to its own
+// clock with the offset measured by CommandClock. It is only sent within a
+// CommandBatch.
+type CommandAt struct {
+	Time    int64
+	Command Appender
+}
+
+func (c *CommandAt) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandAt) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdAt))
+	b = appendUint64(b, uint64(c.Time))
+	return c.Command.AppendBytes(b)
+}
+
+// CommandClock carries the clock of the leader, in unix nanoseconds, and
+// the latest round trip it measured to the member it is sent to, which
+// takes its clock offset from the leader as the time it reads the command
+// less Time and half of RTT. A zero Time only measures the round trip. It is
+// answered with a ResponseSet.
+type CommandClock struct {
+	Time int64
+	RTT  time.Duration
+}
+
+func (c *CommandClock) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandClock) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdClock))
+	b = appendUint64(b, uint64(c.Time))
+	return appendUint64(b, uint64(c.RTT))
+}
+
+// CommandSync carries a chunk of the snapshot a leader sends a follower that
+// joins it, numbered from zero. The follower acknowledges each chunk with a
+// ResponseSet before the next one is sent, and the one with Final set once
+// the whole snapshot is restored. It is only accepted over the connection
+// the follower joined its leader with.
+type CommandSync struct {
+	Seq   uint64
+	Data  []byte
+	Final bool
+}
+
+func (c *CommandSync) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandSync) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdSync))
+	b = appendUint64(b, c.Seq)
+	b = appendField(b, c.Data)
+	if c.Final {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// CommandPromote changes the leader of the node it is sent to: with an
+// empty Leader it promotes the node, a follower, which first demotes its
+// current leader by sending it a CommandPromote naming itself. With a Leader
+// the node follows it and redirects the writes of its clients there. Secret
+// is the cluster secret the node may require, as it then joins Leader with
+// its own. It is answered with a ResponseSet.
+type CommandPromote struct {
+	Leader string
+	Secret string
+}
+
+func (c *CommandPromote) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandPromote) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdPromote))
+	b = appendField(b, []byte(c.Leader))
+	return appendField(b, []byte(c.Secret))
+}
+
+// CommandFlush deletes the keys matching Pattern, a glob as of
+// ggcache.MatchKey, or only counts them with DryRun. It is answered with a
+// ResponseFlush.
+type CommandFlush struct {
+	Pattern []byte
+	DryRun  bool
+}
+
+func (c *CommandFlush) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandFlush) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdFlush))
+	b = appendField(b, c.Pattern)
+	if c.DryRun {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// ResponseFlush carries the number of keys a CommandFlush deleted, or would
+// have deleted with DryRun.
+type ResponseFlush struct {
+	Status Status
+	Keys   uint64
+}
+
+func (r *ResponseFlush) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponseFlush) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	return appendUint64(b, r.Keys)
+}
+
+func ParseFlushResponse(r io.Reader) (*ResponseFlush, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseFlush{}
+	resp.Status = d.status()
+	resp.Keys = d.uint64()
+
+	return resp, d.err
+}
+
+// CommandUndelete restores the keys matching Pattern, a glob as of
+// ggcache.MatchKey, that were deleted within the delete retention of the
+// node and not set since. It is answered with a ResponseFlush counting the
+// keys restored.
+type CommandUndelete struct {
+	Pattern []byte
+}
+
+func (c *CommandUndelete) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandUndelete) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdUndelete))
+	return appendField(b, c.Pattern)
+}
+
+// CommandPurge deletes the keys starting with Prefix whose value was written
+// before Before, e.g. to invalidate everything written before a bad deploy.
+// It is answered with a ResponseFlush counting the keys deleted.
+type CommandPurge struct {
+	Before time.Time
+	Prefix []byte
+}
+
+func (c *CommandPurge) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandPurge) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdPurge))
+	b = appendUint64(b, uint64(c.Before.UnixNano()))
+	return appendField(b, c.Prefix)
+}
+
+// StreamID identifies an entry of a stream: the unix time in milliseconds it
+// was added at and a sequence number telling apart the entries added within
+// the same millisecond. The IDs of a stream only grow.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MaxStreamID is the largest StreamID, which ends a range of every entry. As
+// the After of a CommandXRead it stands for the last entry of the stream
+// when the command arrives, so that only the entries added later are read.
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+// ParseStreamID parses an ID formatted as ms-seq, or ms for ms-0.
+func ParseStreamID(s string) (StreamID, error) {
+	ms, seq, hasSeq := strings.Cut(s, "-")
+	var (
+		id  StreamID
+		err error
+	)
+	if id.Ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return StreamID{}, fmt.Errorf("invalid stream id [%s]", s)
+	}
+	if hasSeq {
+		if id.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return StreamID{}, fmt.Errorf("invalid stream id [%s]", s)
+		}
+	}
+	return id, nil
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id comes before other.
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// IsZero reports whether id is the zero ID, which no entry has.
+func (id StreamID) IsZero() bool {
+	return id == StreamID{}
+}
+
+func appendStreamID(b []byte, id StreamID) []byte {
+	b = appendUint64(b, id.Ms)
+	return appendUint64(b, id.Seq)
+}
+
+func (d *decoder) streamID() StreamID {
+	return StreamID{Ms: d.uint64(), Seq: d.uint64()}
+}
+
+// StreamEntry is an entry of a stream.
+type StreamEntry struct {
+	ID    StreamID
+	Value []byte
+}
+
+// CommandXAdd adds an entry to the stream at Key, creating it if needed. A
+// zero ID has the server assign one, otherwise it must be greater than the
+// ID of the last entry. MaxLen, if set, trims the oldest entries beyond it.
+// It is answered with a ResponseXAdd.
+type CommandXAdd struct {
+	Key    []byte
+	ID     StreamID
+	Value  []byte
+	MaxLen uint64
+	// TTL of the stream in milliseconds, zero means no expiration.
+	TTL int
+}
+
+func (c *CommandXAdd) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandXAdd) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdXAdd))
+	b = appendField(b, c.Key)
+	b = appendStreamID(b, c.ID)
+	b = appendField(b, c.Value)
+	b = appendUint64(b, c.MaxLen)
+	return appendInt32(b, int32(c.TTL))
+}
+
+func parseXAddCommand(d *decoder) *CommandXAdd {
+	return &CommandXAdd{
+		Key:    d.bytes(),
+		ID:     d.streamID(),
+		Value:  d.bytes(),
+		MaxLen: d.uint64(),
+		TTL:    int(d.int32()),
+	}
+}
+
+// ResponseXAdd carries the ID of the entry a CommandXAdd added.
+type ResponseXAdd struct {
+	Status Status
+	ID     StreamID
+}
+
+func (r *ResponseXAdd) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponseXAdd) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	return appendStreamID(b, r.ID)
+}
+
+func ParseXAddResponse(r io.Reader) (*ResponseXAdd, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseXAdd{}
+	resp.Status = d.status()
+	resp.ID = d.streamID()
+
+	return resp, d.err
+}
+
+// CommandXRange reads the entries of the stream at Key with IDs from Start
+// to End included, up to Count of them if it is set. It is answered with a
+// ResponseXRange, StatusKeyNotFound if there is no stream.
+type CommandXRange struct {
+	Key   []byte
+	Start StreamID
+	End   StreamID
+	Count uint64
+}
+
+func (c *CommandXRange) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandXRange) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdXRange))
+	b = appendField(b, c.Key)
+	b = appendStreamID(b, c.Start)
+	b = appendStreamID(b, c.End)
+	return appendUint64(b, c.Count)
+}
+
+// CommandXRead reads the entries of the stream at Key with IDs greater than
+// After, up to Count of them if it is set. If there are none it waits up to
+// Block milliseconds for one to be added, which a missing stream also
+// waits for. It is answered with a ResponseXRange, without entries if none
+// was added in time.
+type CommandXRead struct {
+	Key   []byte
+	After StreamID
+	Count uint64
+	Block uint64
+}
+
+func (c *CommandXRead) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandXRead) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdXRead))
+	b = appendField(b, c.Key)
+	b = appendStreamID(b, c.After)
+	b = appendUint64(b, c.Count)
+	return appendUint64(b, c.Block)
+}
+
+// maxStreamEntries bounds the number of entries in a ResponseXRange.
+const maxStreamEntries = 1 << 20
+
+// ResponseXRange carries the entries read by a CommandXRange or CommandXRead.
+type ResponseXRange struct {
+	Status  Status
+	Entries []StreamEntry
+}
+
+func (r *ResponseXRange) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponseXRange) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	b = appendInt32(b, int32(len(r.Entries)))
+	for _, e := range r.Entries {
+		b = appendStreamID(b, e.ID)
+		b = appendField(b, e.Value)
+	}
+	return b
+}
+
+func ParseXRangeResponse(r io.Reader) (*ResponseXRange, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseXRange{Status: d.status()}
+	n := d.int32()
+	if d.err != nil {
+		return resp, d.err
+	}
+	if n < 0 {
+		return resp, fmt.Errorf("invalid stream length %d", n)
+	}
+	if n > maxStreamEntries {
+		return resp, fmt.Errorf("%w: stream of %d entries", ErrTooLarge, n)
+	}
+	for i := int32(0); i < n && d.err == nil; i++ {
+		resp.Entries = append(resp.Entries, StreamEntry{ID: d.streamID(), Value: d.bytes()})
+	}
+	return resp, d.err
+}
+
+// CommandPublish sends Message to the connections subscribed to Channel on
+// every member. Channels are independent of the keyspace. It is answered with
+// a ResponsePublish.
+type CommandPublish struct {
+	Channel []byte
+	Message []byte
+}
+
+func (c *CommandPublish) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandPublish) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdPublish))
+	b = appendField(b, c.Channel)
+	return appendField(b, c.Message)
+}
+
+// ResponsePublish carries the number of connections of the node a
+// CommandPublish was sent to that the message was queued for.
+type ResponsePublish struct {
+	Status    Status
+	Receivers int
+}
+
+func (r *ResponsePublish) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponsePublish) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	return appendInt32(b, int32(r.Receivers))
+}
+
+func ParsePublishResponse(r io.Reader) (*ResponsePublish, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponsePublish{}
+	resp.Status = d.status()
+	resp.Receivers = int(d.int32())
+
+	return resp, d.err
+}
+
+// maxSubscribeChannels bounds the number of channels of a CommandSubscribe.
+const maxSubscribeChannels = 1024
+
+// CommandSubscribe subscribes the connection to the Channels. It is answered
+// with a ResponseSet, after which the connection only carries the Messages
+// published on the channels until it is closed.
+type CommandSubscribe struct {
+	Channels [][]byte
+}
+
+func (c *CommandSubscribe) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandSubscribe) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdSubscribe))
+	b = appendInt32(b, int32(len(c.Channels)))
+	for _, channel := range c.Channels {
+		b = appendField(b, channel)
+	}
+	return b
+}
+
+func parseSubscribeCommand(d *decoder) (*CommandSubscribe, error) {
+	n := d.int32()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid channel count %d", n)
+	}
+	if n > maxSubscribeChannels {
+		return nil, fmt.Errorf("%w: subscription to %d channels", ErrTooLarge, n)
+	}
+
+	cmd := &CommandSubscribe{Channels: make([][]byte, 0, n)}
+	for i := int32(0); i < n && d.err == nil; i++ {
+		cmd.Channels = append(cmd.Channels, d.bytes())
+	}
+	return cmd, d.err
+}
+
+// Message is a message published on a channel, pushed to the connections
+// subscribed to it.
+type Message struct {
+	Channel []byte
+	Payload []byte
+}
+
+func (m *Message) Bytes() []byte {
+	return m.AppendBytes(nil)
+}
+
+func (m *Message) AppendBytes(b []byte) []byte {
+	b = appendField(b, m.Channel)
+	return appendField(b, m.Payload)
+}
+
+func ParseMessage(r io.Reader) (*Message, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	msg := &Message{Channel: d.bytes(), Payload: d.bytes()}
+	return msg, d.err
+}
+
+// maxScanKeys bounds the number of keys in a ResponseScan.
+const maxScanKeys = 1 << 20
+
+// CommandScan lists the keys matching Pattern, a glob as of
+// ggcache.MatchKey, that sort after After, up to Count of them in order.
+// Starting from an empty After and passing the last key of each page as the
+// next After walks every key without the server keeping a cursor. It is
+// answered with a ResponseScan.
+type CommandScan struct {
+	Pattern []byte
+	After   []byte
+	Count   uint64
+}
+
+func (c *CommandScan) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandScan) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdScan))
+	b = appendField(b, c.Pattern)
+	b = appendField(b, c.After)
+	return appendUint64(b, c.Count)
+}
+
+// ResponseScan carries a page of the keys listed by a CommandScan, fewer than
+// its Count only on the last page.
+type ResponseScan struct {
+	Status Status
+	Keys   [][]byte
+}
+
+func (r *ResponseScan) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponseScan) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	b = appendInt32(b, int32(len(r.Keys)))
+	for _, key := range r.Keys {
+		b = appendField(b, key)
+	}
+	return b
+}
+
+func ParseScanResponse(r io.Reader) (*ResponseScan, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseScan{Status: d.status()}
+	n := d.int32()
+	if d.err != nil {
+		return resp, d.err
+	}
+	if n < 0 {
+		return resp, fmt.Errorf("invalid key count %d", n)
+	}
+	if n > maxScanKeys {
+		return resp, fmt.Errorf("%w: page of %d keys", ErrTooLarge, n)
+	}
+	for i := int32(0); i < n && d.err == nil; i++ {
+		resp.Keys = append(resp.Keys, d.bytes())
+	}
+	return resp, d.err
+}
+
+// maxMGetKeys bounds the number of keys in a CommandMGet.
+const maxMGetKeys = 1 << 16
+
+// CommandMGet reads the values of Keys. The node stops once it spent its
+// MGET budget on them, answering with the values of the keys read so far.
+// It is answered with a ResponseMGet.
+type CommandMGet struct {
+	Keys [][]byte
+}
+
+func (c *CommandMGet) Bytes() []byte {
+	return c.AppendBytes(nil)
+}
+
+func (c *CommandMGet) AppendBytes(b []byte) []byte {
+	b = append(b, byte(CmdMGet))
+	b = appendInt32(b, int32(len(c.Keys)))
+	for _, key := range c.Keys {
+		b = appendField(b, key)
+	}
+	return b
+}
+
+// ResponseMGet carries the values of the first keys of a CommandMGet, in
+// order, nil for the keys not found. Truncated is set if the node ran out of
+// time before reading all of them, in which case there are fewer Values
+// than keys.
+type ResponseMGet struct {
+	Status    Status
+	Values    [][]byte
+	Truncated bool
+}
+
+func (r *ResponseMGet) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+func (r *ResponseMGet) AppendBytes(b []byte) []byte {
+	b = append(b, byte(r.Status))
+	if r.Truncated {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	b = appendInt32(b, int32(len(r.Values)))
+	for _, value := range r.Values {
+		if value == nil {
+			b = append(b, 0)
+			continue
+		}
+		b = append(b, 1)
+		b = appendField(b, value)
+	}
+	return b
+}
+
+func ParseMGetResponse(r io.Reader) (*ResponseMGet, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	resp := &ResponseMGet{Status: d.status(), Truncated: d.byte() != 0}
+	n := d.int32()
+	if d.err != nil {
+		return resp, d.err
+	}
+	if n < 0 {
+		return resp, fmt.Errorf("invalid value count %d", n)
+	}
+	if n > maxMGetKeys {
+		return resp, fmt.Errorf("%w: %d values", ErrTooLarge, n)
+	}
+	resp.Values = make([][]byte, 0, n)
+	for i := int32(0); i < n && d.err == nil; i++ {
+		var value []byte
+		if d.byte() != 0 {
+			value = d.bytes()
+		}
+		resp.Values = append(resp.Values, value)
+	}
+	return resp, d.err
+}
+
+func ParseCommand(r io.Reader) (any, error) {
+	d := newDecoder(r)
+	defer d.release()
+
+	return parseCommand(d)
+}
+
+func parseCommand(d *decoder) (any, error) {
+	cmd := Command(d.byte())
+	if d.err != nil {
+		return nil, d.err
+	}
+	return parseCommandOf(d, cmd)
+}
+
+// parseCommandOf parses the fields of a command whose opcode was read
+// already, so the wrapper commands can check the opcode of the command they
+// wrap before parsing it.
+func parseCommandOf(d *decoder, cmd Command) (any, error) {
+	switch cmd {
+	case CmdSet:
+		return parseSetCommand(d), d.err
+	case CmdGet:
+		return parseGetCommand(d), d.err
+	case CmdDel:
+		return parseDelCommand(d), d.err
+	case CmdJoin:
+		cmd := &CommandJoin{ReadAddr: string(d.bytes()), Zone: string(d.bytes()), Secret: string(d.bytes())}
+		cmd.Serving = time.Duration(d.uint64()) * time.Millisecond
+		return cmd, d.err
+	case CmdStats:
+		return &CommandStats{}, nil
+	case CmdTouch:
+		return parseTouchCommand(d), d.err
+	case CmdFill:
+		return parseFillCommand(d), d.err
+	case CmdBackup:
+		return &CommandBackup{ID: d.uint64()}, d.err
+	case CmdBatch:
+		return parseBatchCommand(d)
+	case CmdGetLease:
+		return &CommandGetLease{Key: d.bytes()}, d.err
+	case CmdSetLease:
+		return parseSetLeaseCommand(d), d.err
+	case CmdAppend:
+		return parseAppendCommand(d), d.err
+	case CmdGetRange:
+		return &CommandGetRange{
+			Key:    d.bytes(),
+			Offset: int(d.int32()),
+			Length: int(d.int32()),
+		}, d.err
+	case CmdSetIf:
+		return parseSetIfCommand(d), d.err
+	case CmdAuth:
+		return &CommandAuth{Token: d.bytes()}, d.err
+	case CmdCancel:
+		return &CommandCancel{ID: d.uint64()}, d.err
+	case CmdTopology:
+		return &CommandTopology{}, nil
+	case CmdPing:
+		return &CommandPing{}, nil
+	case CmdRename:
+		return parseRenameCommand(d), d.err
+	case CmdCopy:
+		return parseCopyCommand(d), d.err
+	case CmdSync:
+		return &CommandSync{Seq: d.uint64(), Data: d.bytes(), Final: d.byte() != 0}, d.err
+	case CmdPromote:
+		return &CommandPromote{Leader: string(d.bytes()), Secret: string(d.bytes())}, d.err
+	case CmdFlush:
+		return &CommandFlush{Pattern: d.bytes(), DryRun: d.byte() != 0}, d.err
+	case CmdXAdd:
+		return parseXAddCommand(d), d.err
+	case CmdXRange:
+		return &CommandXRange{Key: d.bytes(), Start: d.streamID(), End: d.streamID(), Count: d.uint64()}, d.err
+	case CmdXRead:
+		return &CommandXRead{Key: d.bytes(), After: d.streamID(), Count: d.uint64(), Block: d.uint64()}, d.err
+	case CmdPublish:
+		return &CommandPublish{Channel: d.bytes(), Message: d.bytes()}, d.err
+	case CmdSubscribe:
+		return parseSubscribeCommand(d)
+	case CmdScan:
+		return &CommandScan{Pattern: d.bytes(), After: d.bytes(), Count: d.uint64()}, d.err
+	case CmdUndelete:
+		return &CommandUndelete{Pattern: d.bytes()}, d.err
+	case CmdDeadline:
+		return parseDeadlineCommand(d)
+	case CmdOffset:
+		return parseOffsetCommand(d)
+	case CmdPurge:
+		return &CommandPurge{Before: time.Unix(0, int64(d.uint64())), Prefix: d.bytes()}, d.err
+	case CmdNoReply:
+		return parseNoReplyCommand(d)
+	case CmdMulti:
+		return parseMultiCommand(d)
+	case CmdMGet:
+		return parseMGetCommand(d)
+	case CmdInfo:
+		return &CommandInfo{}, nil
+	case CmdAt:
+		return parseAtCommand(d)
+	case CmdClock:
+		return &CommandClock{Time: int64(d.uint64()), RTT: time.Duration(d.uint64())}, d.err
+	case CmdDelIf:
+		return &CommandDelIf{Key: d.bytes(), IfMatch: d.bytes()}, d.err
+	default:
+		return nil, fmt.Errorf("invalid command")
+	}
+}
+
+// ParseDatagram parses a SET or GET command sent as a single UDP datagram.
+// Unlike ParseCommand it checks the field lengths against the datagram, as
+// datagrams come from unauthenticated and possibly spoofed sources.
+func ParseDatagram(b []byte) (any, error) {
+	if len(b) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	var fields int
+	switch Command(b[0]) {
+	case CmdSet:
+		fields = 2
+	case CmdGet:
+		fields = 1
+	default:
+		return nil, fmt.Errorf("invalid datagram command %d", b[0])
+	}
+
+	rest := b[1:]
+	for i := 0; i < fields; i++ {
+		if len(rest) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		n := int64(int32(binary.LittleEndian.Uint32(rest)))
+		if n < 0 || n > int64(len(rest)-4) {
+			return nil, fmt.Errorf("invalid datagram field length %d", n)
+		}
+		rest = rest[4+n:]
+	}
+	if Command(b[0]) == CmdSet && len(rest) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return ParseCommand(bytes.NewReader(b))
+}
+
+func parseSetCommand(d *decoder) *CommandSet {
+	return &CommandSet{
+		Key:   d.bytes(),
+		Value: d.bytes(),
+		TTL:   int(d.int32()),
+	}
+}
+
+func parseGetCommand(d *decoder) *CommandGet {
+	return &CommandGet{Key: d.bytes()}
+}
+
+func parseDelCommand(d *decoder) *CommandDel {
+	return &CommandDel{Key: d.bytes()}
+}
+
+func parseTouchCommand(d *decoder) *CommandTouch {
+	return &CommandTouch{
+		Key: d.bytes(),
+		TTL: int(d.int32()),
+	}
+}
+
+func parseFillCommand(d *decoder) *CommandFill {
+	return &CommandFill{
+		Key: d.bytes(),
+		ID:  d.uint64(),
+	}
+}
+
+func parseSetLeaseCommand(d *decoder) *CommandSetLease {
+	return &CommandSetLease{
+		Key:   d.bytes(),
+		Value: d.bytes(),
+		TTL:   int(d.int32()),
+		Token: d.uint64(),
+	}
+}
+
+func parseAppendCommand(d *decoder) *CommandAppend {
+	return &CommandAppend{
+		Key:  d.bytes(),
+		Data: d.bytes(),
+	}
+}
+
+func parseRenameCommand(d *decoder) *CommandRename {
+	return &CommandRename{
+		Key:    d.bytes(),
+		NewKey: d.bytes(),
+	}
+}
+
+func parseCopyCommand(d *decoder) *CommandCopy {
+	return &CommandCopy{
+		Key: d.bytes(),
+		Dst: d.bytes(),
+		TTL: int(d.int32()),
+	}
+}
+
+func parseSetIfCommand(d *decoder) *CommandSetIf {
+	return &CommandSetIf{
+		Key:         d.bytes(),
+		Value:       d.bytes(),
+		TTL:         int(d.int32()),
+		IfMatch:     d.bytes(),
+		IfNoneMatch: d.bytes(),
+	}
+}
+
+func parseDeadlineCommand(d *decoder) (*CommandDeadline, error) {
+	timeout := time.Duration(d.uint64()) * time.Millisecond
+	op := Command(d.byte())
+	if d.err != nil {
+		return nil, d.err
+	}
+	// The nested deadlines are rejected before they are parsed, as parsing
+	// them would recurse as deep as the client sends them.
+	if op == CmdDeadline {
+		return nil, errors.New("invalid nested deadline command")
+	}
+	cmd, err := parseCommandOf(d, op)
+	if err != nil {
+		return nil, err
+	}
+	return &CommandDeadline{Timeout: timeout, Command: cmd.(Appender)}, nil
+}
+
+func parseOffsetCommand(d *decoder) (*CommandOffset, error) {
+	offset := d.uint64()
+	op := Command(d.byte())
+	if d.err != nil {
+		return nil, d.err
+	}
+	// As for a deadline, the nested wrappers are rejected before they are
+	// parsed.
+	if op == CmdDeadline || op == CmdOffset {
+		return nil, errors.New("invalid nested offset command")
+	}
+	cmd, err := parseCommandOf(d, op)
+	if err != nil {
+		return nil, err
+	}
+	return &CommandOffset{Offset: offset, Command: cmd.(Appender)}, nil
+}
+
+func parseNoReplyCommand(d *decoder) (*CommandNoReply, error) {
+	var cmd Appender
+	switch op := Command(d.byte()); op {
+	case CmdSet:
+		cmd = parseSetCommand(d)
+	case CmdDel:
+		cmd = parseDelCommand(d)
+	default:
+		if d.err == nil {
+			d.err = errors.New("invalid no-reply command")
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &CommandNoReply{Command: cmd}, nil
+}
+
+func parseMultiCommand(d *decoder) (*CommandMulti, error) {
+	n := d.int32()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid multi length %d", n)
+	}
+	if n > maxMultiCommands {
+		return nil, fmt.Errorf("%w: multi of %d commands", ErrTooLarge, n)
+	}
+
+	multi := &CommandMulti{Commands: make([]Appender, 0, min(n, 1024))}
+	for i := int32(0); i < n; i++ {
+		cmd := Command(d.byte())
+		switch cmd {
+		case CmdSet:
+			multi.Commands = append(multi.Commands, parseSetCommand(d))
+		case CmdDel:
+			multi.Commands = append(multi.Commands, parseDelCommand(d))
+		default:
+			if d.err == nil {
+				d.err = fmt.Errorf("invalid multi command %d", cmd)
+			}
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+	return multi, nil
+}
+
+func parseBatchCommand(d *decoder) (*CommandBatch, error) {
+	n := d.int32()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid batch length %d", n)
+	}
+	if n > maxBatchCommands {
+		return nil, fmt.Errorf("%w: batch of %d commands", ErrTooLarge, n)
+	}
+
+	batch := &CommandBatch{Commands: make([]Appender, 0, min(n, 1024))}
+	for i := int32(0); i < n; i++ {
+		cmd := Command(d.byte())
+		switch cmd {
+		case CmdSet:
+			batch.Commands = append(batch.Commands, parseSetCommand(d))
+		case CmdDel:
+			batch.Commands = append(batch.Commands, parseDelCommand(d))
+		case CmdTouch:
+			batch.Commands = append(batch.Commands, parseTouchCommand(d))
+		case CmdAppend:
+			batch.Commands = append(batch.Commands, parseAppendCommand(d))
+		case CmdRename:
+			batch.Commands = append(batch.Commands, parseRenameCommand(d))
+		case CmdCopy:
+			batch.Commands = append(batch.Commands, parseCopyCommand(d))
+		case CmdXAdd:
+			batch.Commands = append(batch.Commands, parseXAddCommand(d))
+		case CmdPublish:
+			batch.Commands = append(batch.Commands, &CommandPublish{Channel: d.bytes(), Message: d.bytes()})
+		case CmdMulti:
+			multi, err := parseMultiCommand(d)
+			if err != nil {
+				return nil, err
+			}
+			batch.Commands = append(batch.Commands, multi)
+		case CmdAt:
+			at, err := parseAtCommand(d)
+			if err != nil {
+				return nil, err
+			}
+			batch.Commands = append(batch.Commands, at)
+		default:
+			if d.err == nil {
+				d.err = fmt.Errorf("invalid batch command %d", cmd)
+			}
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+	return batch, nil
+}
+
+func parseMGetCommand(d *decoder) (*CommandMGet, error) {
+	n := d.int32()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid mget length %d", n)
+	}
+	if n > maxMGetKeys {
+		return nil, fmt.Errorf("%w: mget of %d keys", ErrTooLarge, n)
+	}
+
+	mget := &CommandMGet{Keys: make([][]byte, 0, min(n, 1024))}
+	for i := int32(0); i < n && d.err == nil; i++ {
+		mget.Keys = append(mget.Keys, d.bytes())
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return mget, nil
+}
+
+func parseAtCommand(d *decoder) (*CommandAt, error) {
+	at := int64(d.uint64())
+	var cmd Appender
+	switch op := Command(d.byte()); op {
+	case CmdSet:
+		cmd = parseSetCommand(d)
+	case CmdTouch:
+		cmd = parseTouchCommand(d)
+	case CmdCopy:
+		cmd = parseCopyCommand(d)
+	case CmdXAdd:
+		cmd = parseXAddCommand(d)
+	case CmdMulti:
+		multi, err := parseMultiCommand(d)
+		if err != nil {
+			return nil, err
+		}
+		cmd = multi
+	default:
+		if d.err == nil {
+			d.err = errors.New("invalid timed command")
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &CommandAt{Time: at, Command: cmd}, nil
 }
@@ -2,7 +2,10 @@ package proto
 
 import (
 	"bytes"
+	"io"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,6 +34,395 @@ func TestParseSetCommand(t *testing.T) {
 	assert.Equal(t, cmd, pcmd)
 }
 
+func TestParseDelCommand(t *testing.T) {
+	cmd := &CommandDel{
+		Key: []byte("Foo"),
+	}
+	r := bytes.NewReader(cmd.Bytes())
+	pcmd, err := ParseCommand(r)
+	assert.Nil(t, err)
+
+	assert.Equal(t, cmd, pcmd)
+}
+
+func TestParseTouchCommand(t *testing.T) {
+	cmd := &CommandTouch{
+		Key: []byte("Foo"),
+		TTL: 2000,
+	}
+	r := bytes.NewReader(cmd.Bytes())
+	pcmd, err := ParseCommand(r)
+	assert.Nil(t, err)
+
+	assert.Equal(t, cmd, pcmd)
+}
+
+func TestParseFillCommand(t *testing.T) {
+	cmd := &CommandFill{
+		Key: []byte("Foo"),
+		ID:  7,
+	}
+	r := bytes.NewReader(cmd.Bytes())
+	pcmd, err := ParseCommand(r)
+	assert.Nil(t, err)
+
+	assert.Equal(t, cmd, pcmd)
+}
+
+func TestParseCancelCommands(t *testing.T) {
+	for _, cmd := range []interface{ Bytes() []byte }{
+		&CommandBackup{ID: 3},
+		&CommandCancel{ID: 3},
+	} {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+}
+
+func TestParseTopology(t *testing.T) {
+	for _, cmd := range []interface{ Bytes() []byte }{
+		&CommandJoin{ReadAddr: "10.0.0.2:3000", Zone: "eu-west-1a", Secret: "s3cret", Serving: 90 * time.Second},
+		&CommandTopology{},
+		&CommandPing{},
+	} {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+
+	resp := &ResponseTopology{
+		Status:   StatusOK,
+		Leader:   "10.0.0.1:3000",
+		Replicas: []string{"10.0.0.2:3000", "10.0.0.3:3000"},
+		Zones:    []string{"eu-west-1a", ""},
+		Weights:  []uint8{100, 40},
+		Ramps:    []time.Duration{0, 18 * time.Second},
+	}
+	presp, err := ParseTopologyResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+
+	b := appendInt32((&ResponseTopology{Status: StatusOK}).Bytes()[:5], -1)
+	_, err = ParseTopologyResponse(bytes.NewReader(b))
+	assert.NotNil(t, err)
+}
+
+func TestParseRenameCommands(t *testing.T) {
+	for _, cmd := range []interface{ Bytes() []byte }{
+		&CommandRename{Key: []byte("tmp"), NewKey: []byte("foo")},
+		&CommandCopy{Key: []byte("foo"), Dst: []byte("bar"), TTL: 2000},
+	} {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+}
+
+func TestParseSyncCommand(t *testing.T) {
+	for _, cmd := range []*CommandSync{
+		{Seq: 0, Data: []byte("GGSNAP")},
+		{Seq: 7, Data: []byte("tail"), Final: true},
+	} {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+}
+
+func TestParsePromoteCommand(t *testing.T) {
+	for _, cmd := range []*CommandPromote{{}, {Leader: "10.0.0.2:3000", Secret: "s3cret"}} {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+}
+
+func TestParseFlush(t *testing.T) {
+	for _, cmd := range []*CommandFlush{
+		{Pattern: []byte("user:*:session"), DryRun: true},
+		{Pattern: []byte("*")},
+	} {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+
+	undelete := &CommandUndelete{Pattern: []byte("user:*")}
+	pcmd, err := ParseCommand(bytes.NewReader(undelete.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, undelete, pcmd)
+
+	purge := &CommandPurge{Before: time.Unix(0, 1700000000123456789), Prefix: []byte("user:")}
+	pcmd, err = ParseCommand(bytes.NewReader(purge.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, purge, pcmd)
+
+	resp := &ResponseFlush{Status: StatusOK, Keys: 42}
+	presp, err := ParseFlushResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+func TestParseStream(t *testing.T) {
+	for _, cmd := range []any{
+		&CommandXAdd{Key: []byte("events"), Value: []byte("created"), MaxLen: 1000, TTL: 60000},
+		&CommandXRange{Key: []byte("events"), Start: StreamID{Ms: 1}, End: MaxStreamID, Count: 10},
+		&CommandXRead{Key: []byte("events"), After: StreamID{Ms: 1, Seq: 2}, Block: 5000},
+	} {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.(Appender).AppendBytes(nil)))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+
+	xadd := &ResponseXAdd{Status: StatusOK, ID: StreamID{Ms: 1700000000000, Seq: 1}}
+	pxadd, err := ParseXAddResponse(bytes.NewReader(xadd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, xadd, pxadd)
+
+	resp := &ResponseXRange{Status: StatusOK, Entries: []StreamEntry{
+		{ID: StreamID{Ms: 1}, Value: []byte("a")},
+		{ID: StreamID{Ms: 1, Seq: 1}, Value: []byte("b")},
+	}}
+	presp, err := ParseXRangeResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+
+	id, err := ParseStreamID("1700000000000-3")
+	assert.Nil(t, err)
+	assert.Equal(t, StreamID{Ms: 1700000000000, Seq: 3}, id)
+	assert.Equal(t, "1700000000000-3", id.String())
+	id, err = ParseStreamID("42")
+	assert.Nil(t, err)
+	assert.Equal(t, StreamID{Ms: 42}, id)
+	_, err = ParseStreamID("1-x")
+	assert.Error(t, err)
+	assert.True(t, StreamID{Ms: 1, Seq: 9}.Less(StreamID{Ms: 2}))
+}
+
+func TestParseScan(t *testing.T) {
+	cmd := &CommandScan{Pattern: []byte("users:*"), After: []byte("users:41"), Count: 100}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	resp := &ResponseScan{Status: StatusOK, Keys: [][]byte{[]byte("users:42"), []byte("users:43")}}
+	presp, err := ParseScanResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+func TestParseDeadline(t *testing.T) {
+	cmd := &CommandDeadline{
+		Timeout: 250 * time.Millisecond,
+		Command: &CommandScan{Pattern: []byte("users:*"), After: []byte("users:41"), Count: 100},
+	}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	nested := &CommandDeadline{Timeout: time.Second, Command: cmd}
+	_, err = ParseCommand(bytes.NewReader(nested.Bytes()))
+	assert.Error(t, err)
+
+	// The nested headers are rejected before they are parsed, however many
+	// a client sends.
+	header := (&CommandDeadline{Timeout: time.Second, Command: &CommandPing{}}).Bytes()
+	headers := bytes.Repeat(header[:len(header)-1], 1<<20)
+	_, err = ParseCommand(bytes.NewReader(headers))
+	assert.ErrorContains(t, err, "invalid nested deadline command")
+}
+
+func TestParseOffset(t *testing.T) {
+	cmd := &CommandDeadline{
+		Timeout: time.Second,
+		Command: &CommandOffset{Offset: 42, Command: &CommandGet{Key: []byte("foo")}},
+	}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	nested := &CommandOffset{Offset: 1, Command: cmd}
+	_, err = ParseCommand(bytes.NewReader(nested.Bytes()))
+	assert.Error(t, err)
+
+	header := (&CommandOffset{Offset: 1, Command: &CommandPing{}}).Bytes()
+	headers := bytes.Repeat(header[:len(header)-1], 1<<20)
+	_, err = ParseCommand(bytes.NewReader(headers))
+	assert.ErrorContains(t, err, "invalid nested offset command")
+
+	resp := &ResponseOffset{Offset: 42}
+	presp, err := ParseOffsetResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+func TestParseNoReply(t *testing.T) {
+	cmd := &CommandOffset{
+		Offset:  42,
+		Command: &CommandNoReply{Command: &CommandSet{Key: []byte("foo"), Value: []byte("bar"), TTL: 2}},
+	}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	get := &CommandNoReply{Command: &CommandGet{Key: []byte("foo")}}
+	_, err = ParseCommand(bytes.NewReader(get.Bytes()))
+	assert.Error(t, err)
+
+	headers := bytes.Repeat([]byte{byte(CmdNoReply)}, 1<<20)
+	_, err = ParseCommand(bytes.NewReader(headers))
+	assert.ErrorContains(t, err, "invalid no-reply command")
+}
+
+// limitedReader bounds the fields of the messages parsed from it.
+type limitedReader struct {
+	io.Reader
+	max int
+}
+
+func (r limitedReader) MaxFieldSize() int {
+	return r.max
+}
+
+func TestParseTruncated(t *testing.T) {
+	for _, cmd := range []Appender{
+		&CommandSet{Key: []byte("foo"), Value: []byte("bar"), TTL: 10},
+		&CommandGet{Key: []byte("foo")},
+		&CommandDel{Key: []byte("foo")},
+		&CommandTouch{Key: []byte("foo"), TTL: 10},
+		&CommandMulti{Commands: []Appender{&CommandDel{Key: []byte("foo")}}},
+		&CommandNoReply{Command: &CommandDel{Key: []byte("foo")}},
+	} {
+		b := cmd.AppendBytes(nil)
+		_, err := ParseCommand(bytes.NewReader(b[:len(b)-1]))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "%T", cmd)
+	}
+
+	// A field larger than the reader allows is not read.
+	set := &CommandSet{Key: []byte("foo"), Value: make([]byte, 1024)}
+	_, err := ParseCommand(limitedReader{Reader: bytes.NewReader(set.Bytes()), max: 1023})
+	assert.ErrorIs(t, err, ErrTooLarge)
+	pcmd, err := ParseCommand(limitedReader{Reader: bytes.NewReader(set.Bytes()), max: 1024})
+	assert.Nil(t, err)
+	assert.Equal(t, set, pcmd)
+
+	huge := appendInt32([]byte{byte(CmdDel)}, DefaultMaxFieldSize+1)
+	_, err = ParseCommand(bytes.NewReader(huge))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestParseMulti(t *testing.T) {
+	multi := &CommandMulti{Commands: []Appender{
+		&CommandSet{Key: []byte("{u1}:name"), Value: []byte("alice"), TTL: 2},
+		&CommandDel{Key: []byte("{u1}:cart")},
+	}}
+	batch := &CommandBatch{Commands: []Appender{multi, &CommandDel{Key: []byte("foo")}}}
+	for _, cmd := range []Appender{multi, batch} {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.AppendBytes(nil)))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+
+	get := &CommandMulti{Commands: []Appender{&CommandGet{Key: []byte("foo")}}}
+	_, err := ParseCommand(bytes.NewReader(get.Bytes()))
+	assert.Error(t, err)
+}
+
+func TestParsePubSub(t *testing.T) {
+	for _, cmd := range []any{
+		&CommandPublish{Channel: []byte("news"), Message: []byte("hello")},
+		&CommandSubscribe{Channels: [][]byte{[]byte("news"), []byte("sports")}},
+	} {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.(Appender).AppendBytes(nil)))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+
+	resp := &ResponsePublish{Status: StatusOK, Receivers: 3}
+	presp, err := ParsePublishResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+
+	msg := &Message{Channel: []byte("news"), Payload: []byte("hello")}
+	pmsg, err := ParseMessage(bytes.NewReader(msg.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, msg, pmsg)
+
+	b := (&CommandSubscribe{}).Bytes()
+	b[1], b[2], b[3], b[4] = 0xff, 0xff, 0xff, 0x7f
+	_, err = ParseCommand(bytes.NewReader(b))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestParseBatchCommand(t *testing.T) {
+	cmd := &CommandBatch{
+		Commands: []Appender{
+			&CommandSet{Key: []byte("Foo"), Value: []byte("Bar"), TTL: 2000},
+			&CommandDel{Key: []byte("Foo")},
+			&CommandTouch{Key: []byte("Baz"), TTL: 0},
+			&CommandRename{Key: []byte("Baz"), NewKey: []byte("Qux")},
+			&CommandCopy{Key: []byte("Qux"), Dst: []byte("Baz"), TTL: 1000},
+			&CommandXAdd{Key: []byte("events"), ID: StreamID{Ms: 1, Seq: 2}, Value: []byte("e"), MaxLen: 100},
+			&CommandPublish{Channel: []byte("news"), Message: []byte("hello")},
+		},
+	}
+	r := bytes.NewReader(cmd.Bytes())
+	pcmd, err := ParseCommand(r)
+	assert.Nil(t, err)
+
+	assert.Equal(t, cmd, pcmd)
+
+	// Only mutations can be batched.
+	b := (&CommandBatch{Commands: []Appender{&CommandGet{Key: []byte("Foo")}}}).Bytes()
+	_, err = ParseCommand(bytes.NewReader(b))
+	assert.NotNil(t, err)
+
+	b = (&CommandBatch{}).Bytes()
+	b[1], b[2], b[3], b[4] = 0xff, 0xff, 0xff, 0xff
+	_, err = ParseCommand(bytes.NewReader(b))
+	assert.NotNil(t, err)
+
+	b[1], b[2], b[3], b[4] = 0xff, 0xff, 0xff, 0x7f
+	_, err = ParseCommand(bytes.NewReader(b))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestParseStatsCommand(t *testing.T) {
+	cmd := &CommandStats{}
+	r := bytes.NewReader(cmd.Bytes())
+	pcmd, err := ParseCommand(r)
+	assert.Nil(t, err)
+
+	assert.Equal(t, cmd, pcmd)
+}
+
+func TestParseStatsResponse(t *testing.T) {
+	resp := &ResponseStats{
+		Status: StatusOK,
+		Stats: []Stat{
+			{Name: "cache_hits_total", Value: 10},
+			{Name: "cache_keys", Value: 2},
+		},
+	}
+	presp, err := ParseStatsResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+
+	assert.Equal(t, resp, presp)
+
+	// The counts and the lengths of the names are checked before they are
+	// allocated.
+	negative := appendInt32([]byte{byte(StatusOK)}, -1)
+	_, err = ParseStatsResponse(bytes.NewReader(negative))
+	assert.ErrorContains(t, err, "invalid stat count")
+	huge := appendInt32([]byte{byte(StatusOK)}, maxStats+1)
+	_, err = ParseStatsResponse(bytes.NewReader(huge))
+	assert.ErrorIs(t, err, ErrTooLarge)
+	name := appendInt32(appendInt32([]byte{byte(StatusOK)}, 1), DefaultMaxFieldSize+1)
+	_, err = ParseStatsResponse(bytes.NewReader(name))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
 func BenchmarkParseCommand(b *testing.B) {
 	cmd := &CommandSet{
 		Key:   []byte("Foo"),
@@ -38,8 +430,294 @@ func BenchmarkParseCommand(b *testing.B) {
 		TTL:   2,
 	}
 
+	buf := cmd.Bytes()
+
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		r := bytes.NewReader(cmd.Bytes())
+		r := bytes.NewReader(buf)
 		_, _ = ParseCommand(r)
 	}
 }
+
+func BenchmarkEncodeGetResponse(b *testing.B) {
+	resp := &ResponseGet{
+		Status: StatusOK,
+		Value:  bytes.Repeat([]byte("x"), 128),
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = WriteMessage(io.Discard, resp)
+	}
+}
+
+func TestParseLeaseCommands(t *testing.T) {
+	cmds := []any{
+		&CommandGetLease{Key: []byte("Foo")},
+		&CommandSetLease{Key: []byte("Foo"), Value: []byte("Bar"), TTL: 2000, Token: 1 << 40},
+	}
+	for _, cmd := range cmds {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.(Appender).AppendBytes(nil)))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+}
+
+func TestParseGetLeaseResponse(t *testing.T) {
+	resp := &ResponseGetLease{
+		Status: StatusLeaseGranted,
+		Value:  []byte{},
+		Token:  42,
+	}
+	presp, err := ParseGetLeaseResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+
+	assert.Equal(t, resp, presp)
+}
+
+func TestParseAppendCommands(t *testing.T) {
+	cmds := []any{
+		&CommandAppend{Key: []byte("Foo"), Data: []byte("Bar")},
+		&CommandGetRange{Key: []byte("Foo"), Offset: 3, Length: 1024},
+		&CommandBatch{Commands: []Appender{&CommandAppend{Key: []byte("Foo"), Data: []byte("Bar")}}},
+	}
+	for _, cmd := range cmds {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.(Appender).AppendBytes(nil)))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+}
+
+func TestParseSetIfCommand(t *testing.T) {
+	cmd := &CommandSetIf{
+		Key:         []byte("Foo"),
+		Value:       []byte("Bar"),
+		TTL:         2000,
+		IfMatch:     []byte("abc"),
+		IfNoneMatch: []byte{},
+	}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+}
+
+func TestParseDelIfCommand(t *testing.T) {
+	cmd := &CommandDelIf{Key: []byte("lock"), IfMatch: []byte("abc")}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+}
+
+func TestParseAuthCommand(t *testing.T) {
+	cmd := &CommandAuth{Token: []byte("s3cret")}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	resp := &ResponseAuth{Status: StatusUnauthorized}
+	presp, err := ParseAuthResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+func TestParseMGet(t *testing.T) {
+	cmd := &CommandMGet{Keys: [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	// A miss stays apart from an empty value.
+	resp := &ResponseMGet{
+		Status:    StatusOK,
+		Values:    [][]byte{[]byte("1"), nil},
+		Truncated: true,
+	}
+	presp, err := ParseMGetResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+
+	resp = &ResponseMGet{Status: StatusOK, Values: [][]byte{{}}}
+	presp, err = ParseMGetResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+func TestParseInfoResponse(t *testing.T) {
+	resp := &ResponseInfo{
+		Status:       StatusOK,
+		Version:      "v1.4.0",
+		Commit:       "3f2a9c1",
+		Capabilities: []string{CapabilityMulti, CapabilityScan},
+	}
+	presp, err := ParseInfoResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+	assert.True(t, presp.Has(CapabilityScan))
+	assert.False(t, presp.Has(CapabilityAuth))
+}
+
+func TestParseClockCommands(t *testing.T) {
+	batch := &CommandBatch{Commands: []Appender{
+		&CommandAt{Time: 1700000000123456789, Command: &CommandSet{Key: []byte("Foo"), Value: []byte("Bar"), TTL: 2000}},
+		&CommandDel{Key: []byte("Foo")},
+	}}
+	pcmd, err := ParseCommand(bytes.NewReader(batch.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, batch, pcmd)
+
+	clock := &CommandClock{Time: 1700000000123456789, RTT: 350 * time.Microsecond}
+	pcmd, err = ParseCommand(bytes.NewReader(clock.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, clock, pcmd)
+
+	// Only the mutations with a TTL are timed.
+	at := &CommandAt{Time: 1, Command: &CommandDel{Key: []byte("Foo")}}
+	_, err = ParseCommand(bytes.NewReader(at.Bytes()))
+	assert.NotNil(t, err)
+
+	header := (&CommandAt{Time: 1, Command: &CommandPing{}}).Bytes()
+	headers := bytes.Repeat(header[:len(header)-1], 1<<20)
+	_, err = ParseCommand(bytes.NewReader(headers))
+	assert.ErrorContains(t, err, "invalid timed command")
+}
+
+func TestParseBackupResponse(t *testing.T) {
+	resp := &ResponseBackup{
+		Status: StatusOK,
+		Name:   "prod/snapshot-20240102T030405.000000000Z.ggsnap",
+	}
+	presp, err := ParseBackupResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+
+	assert.Equal(t, resp, presp)
+
+	huge := appendInt32([]byte{byte(StatusOK)}, DefaultMaxFieldSize+1)
+	_, err = ParseBackupResponse(bytes.NewReader(huge))
+	assert.ErrorIs(t, err, ErrTooLarge)
+	negative := appendInt32([]byte{byte(StatusOK)}, -1)
+	presp, err = ParseBackupResponse(bytes.NewReader(negative))
+	assert.Nil(t, err)
+	assert.Empty(t, presp.Name)
+}
+
+func TestParseRedirect(t *testing.T) {
+	moved := &Redirect{Status: StatusMoved, Addr: "10.0.0.2:3000"}
+	_, err := ParseSetResponse(bytes.NewReader(moved.Bytes()))
+	assert.Equal(t, moved, err)
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	retry := &Redirect{Status: StatusRetry, RetryAfter: 1500 * time.Millisecond}
+	resp, err := ParseGetResponse(bytes.NewReader(retry.Bytes()))
+	assert.Equal(t, retry, err)
+	assert.Equal(t, StatusRetry, resp.Status)
+	assert.Equal(t, "RETRY: retry after 1.5s", err.Error())
+	assert.NotErrorIs(t, err, ErrReadOnly)
+
+	// The responses read without a decoder read it as well, and nothing is
+	// left of the message.
+	r := bytes.NewReader(moved.Bytes())
+	_, err = ParseStatsResponse(r)
+	assert.Equal(t, moved, err)
+	assert.Equal(t, 0, r.Len())
+	_, err = ParseBackupResponse(bytes.NewReader(retry.Bytes()))
+	assert.Equal(t, retry, err)
+}
+
+func TestParseDatagram(t *testing.T) {
+	set := &CommandSet{Key: []byte("foo"), Value: []byte("bar"), TTL: 100}
+	cmd, err := ParseDatagram(set.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, set, cmd)
+
+	get := &CommandGet{Key: []byte("foo")}
+	cmd, err = ParseDatagram(get.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, get, cmd)
+
+	// Truncated, oversized and negative lengths are rejected before parsing.
+	_, err = ParseDatagram(set.Bytes()[:10])
+	assert.NotNil(t, err)
+	_, err = ParseDatagram([]byte{byte(CmdGet), 0xff, 0xff, 0xff, 0x7f})
+	assert.NotNil(t, err)
+	_, err = ParseDatagram([]byte{byte(CmdGet), 0xff, 0xff, 0xff, 0xff})
+	assert.NotNil(t, err)
+	_, err = ParseDatagram((&CommandDel{Key: []byte("foo")}).Bytes())
+	assert.NotNil(t, err)
+}
+
+func TestWriteMessage(t *testing.T) {
+	msgs := []Appender{
+		&CommandSet{Key: []byte("foo"), Value: []byte("bar"), TTL: 10},
+		&CommandGet{Key: []byte("foo")},
+		&CommandTouch{Key: []byte("foo"), TTL: 10},
+		&ResponseGet{Status: StatusOK, Value: []byte("bar")},
+		ResponseSet{Status: StatusError},
+	}
+
+	before := BufferPoolStats()
+	for _, msg := range msgs {
+		buf := new(bytes.Buffer)
+		assert.Nil(t, WriteMessage(buf, msg))
+		assert.Equal(t, msg.(interface{ Bytes() []byte }).Bytes(), buf.Bytes())
+	}
+	assert.Equal(t, before.Gets+uint64(len(msgs)), BufferPoolStats().Gets)
+}
+
+func TestWriteGetResponse(t *testing.T) {
+	for _, size := range []int{0, 16, minZeroCopy, 1 << 20} {
+		value := bytes.Repeat([]byte("x"), size)
+		buf := new(bytes.Buffer)
+		assert.Nil(t, WriteGetResponse(buf, StatusOK, value))
+		assert.Equal(t, (&ResponseGet{Status: StatusOK, Value: value}).Bytes(), buf.Bytes())
+	}
+}
+
+// healthReader records the Health of the responses read from it.
+type healthReader struct {
+	io.Reader
+	health Health
+}
+
+func (r *healthReader) RecordHealth(h Health) {
+	r.health = h
+}
+
+func TestHealth(t *testing.T) {
+	server, conn := net.Pipe()
+	defer server.Close()
+	defer conn.Close()
+
+	value := bytes.Repeat([]byte("x"), minZeroCopy)
+	go func() {
+		w := &HealthWriter{Conn: server, Health: HealthSyncing | HealthHighMemory}
+		_ = WriteMessage(w, &ResponseSet{Status: StatusOK})
+		w = &HealthWriter{Conn: server, Health: HealthReadOnly}
+		_ = WriteGetResponse(w, StatusOK, value)
+		// Only the status of a response is stamped.
+		_ = WriteMessage(w, &ResponseSet{Status: StatusError})
+		w = &HealthWriter{Conn: server}
+		_, _ = w.Write((&ResponseStats{Status: StatusOK}).Bytes())
+	}()
+
+	r := &healthReader{Reader: conn}
+	set, err := ParseSetResponse(r)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusOK, set.Status)
+	assert.Equal(t, HealthSyncing|HealthHighMemory, r.health)
+	assert.Equal(t, "SYNCING|HIGHMEMORY", r.health.String())
+
+	get, err := ParseGetResponse(r)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusOK, get.Status)
+	assert.Equal(t, value, get.Value)
+	assert.Equal(t, HealthReadOnly, r.health)
+	set, err = ParseSetResponse(r)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusError, set.Status)
+	assert.Equal(t, Health(0), r.health)
+
+	stats, err := ParseStatsResponse(r)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusOK, stats.Status)
+	assert.Equal(t, Health(0), r.health)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"flag"
+)
+
+// rdbUsage documents the rdb subcommand.
+const rdbUsage = `usage: ggcache rdb export|import -admin host:port [-file path]
+
+export writes the string keys of the node as an RDB file, as Redis writes
+it, to the file, or to stdout, so the Redis tools can inspect them; import
+stores the string keys of the RDB file read from the file, or stdin, on the
+node, which should be the leader.`
+
+// rdbCommand runs "ggcache rdb", which exports and imports RDB files
+// through the admin API of a running node.
+func rdbCommand(args []string) error {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		return errors.New(rdbUsage)
+	}
+
+	fs := flag.NewFlagSet("rdb "+args[0], flag.ContinueOnError)
+	var (
+		admin = fs.String("admin", "", "admin listen address of the node")
+		file  = fs.String("file", "", "RDB file, stdout or stdin if empty")
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if len(*admin) == 0 {
+		return errors.New(rdbUsage)
+	}
+	endpoint := "http://" + *admin + "/api/v1/rdb"
+
+	if args[0] == "export" {
+		return download(endpoint, *file)
+	}
+	return upload(endpoint, *file)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/anthdm/ggcache/example/server"
+)
+
+// reloadOnHangup reloads the tenants of the config file on every SIGHUP, so
+// their tokens are rotated without a restart. The rest of the config takes
+// effect on the next restart.
+func reloadOnHangup(s *server.Server, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadTenants(s, path); err != nil {
+			log.Println("reload config error:", err)
+			continue
+		}
+		log.Printf("reloaded the tenants of [%s]\n", path)
+	}
+}
+
+// reloadTenants replaces the tokens of the server with those of the tenants
+// of the config file. The tokens added with the admin API since are
+// dropped, as the file is the source of truth.
+func reloadTenants(s *server.Server, path string) error {
+	tokens := s.Tokens()
+	if tokens == nil {
+		return errors.New("the server does not authenticate with the tokens of tenants")
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	tenants := cfg.tenants()
+	if len(tenants) == 0 {
+		// Authentication cannot be turned off while the server runs.
+		return errors.New("the config has no tenants")
+	}
+	tokens.Replace(tenants)
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadTenants(t *testing.T) {
+	path := writeConfig(t, `tenants:
+  - token: op
+  - token: old
+    namespace: team-a
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	opts, err := cfg.ServerOpts()
+	assert.Nil(t, err)
+	opts.ListenAddr, opts.IsLeader, opts.AuthToken = "127.0.0.1:0", true, "op"
+	s, c, err := server.StartEmbedded(opts, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// The new token is rolled out next to the old one, then the old one is
+	// dropped.
+	assert.Nil(t, os.WriteFile(path, []byte(`tenants:
+  - token: op
+  - token: old
+    tokens: [new]
+    namespace: team-a
+`), 0o600))
+	assert.Nil(t, reloadTenants(s, path))
+	for _, token := range []string{"old", "new"} {
+		tc, err := client.New(s.Addr().String(), client.Options{AuthToken: token})
+		assert.Nil(t, err)
+		_ = tc.Close()
+	}
+
+	assert.Nil(t, os.WriteFile(path, []byte(`tenants:
+  - token: op
+  - token: new
+    namespace: team-a
+`), 0o600))
+	assert.Nil(t, reloadTenants(s, path))
+	_, err = client.New(s.Addr().String(), client.Options{AuthToken: "old"})
+	assert.Equal(t, client.ErrUnauthorized, err)
+
+	// A config without tenants is not applied.
+	assert.Nil(t, os.WriteFile(path, []byte("listen_addr: :3000\n"), 0o600))
+	assert.NotNil(t, reloadTenants(s, path))
+	tc, err := client.New(s.Addr().String(), client.Options{AuthToken: "new"})
+	assert.Nil(t, err)
+	_ = tc.Close()
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// AdminHandler returns the handler of the admin HTTP listener. It serves the
+// published expvars on /debug/vars, the StatsReport as JSON on
+// /api/v1/stats, the stats history on /api/v1/stats/history, the memory
+// analysis on /api/v1/memory/usage and /api/v1/memory/doctor, the
+// connections on /api/v1/clients, closed by a POST to /api/v1/clients/kill,
+// the capture of their next commands on /api/v1/clients/capture, sweeps the
+// expired keys on a POST to /api/v1/expiry/sweep, exports and imports the
+// snapshot of a namespace on /api/v1/namespaces/snapshot, the whole cache
+// as an RDB file on /api/v1/rdb, and lists, adds and revokes the auth
+// tokens on /api/v1/auth/tokens.
+// It can be mounted on an existing mux instead of setting AdminAddr.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/api/v1/stats", s.handleStatsAPI)
+	mux.HandleFunc("/api/v1/stats/history", s.handleStatsHistoryAPI)
+	mux.HandleFunc("/api/v1/memory/usage", s.handleMemoryUsageAPI)
+	mux.HandleFunc("/api/v1/memory/doctor", s.handleMemoryDoctorAPI)
+	mux.HandleFunc("/api/v1/clients", s.handleClientsAPI)
+	mux.HandleFunc("/api/v1/clients/kill", s.handleKillClientAPI)
+	mux.HandleFunc("/api/v1/clients/capture", s.handleCaptureAPI)
+	mux.HandleFunc("/api/v1/expiry/sweep", s.handleSweepAPI)
+	mux.HandleFunc("/api/v1/namespaces/snapshot", s.handleNamespaceSnapshotAPI)
+	mux.HandleFunc("/api/v1/rdb", s.handleRDBAPI)
+	mux.HandleFunc("/api/v1/auth/tokens", s.handleTokensAPI)
+	return mux
+}
+
+// PublishExpvar publishes the stats of the server under name, as a map of
+// the STATS counters and gauges plus the role and leader of the node.
+// Since expvar names are global to the process, publishing the same name
+// twice is an error.
+func (s *Server) PublishExpvar(name string) error {
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar [%s] is already published", name)
+	}
+	expvar.Publish(name, expvar.Func(s.expvar))
+	return nil
+}
+
+func (s *Server) expvar() any {
+	vars := make(map[string]any)
+	for _, stat := range s.Stats() {
+		vars[stat.Name] = stat.Value
+	}
+
+	s.mu.Lock()
+	leader := s.leader
+	s.mu.Unlock()
+
+	vars["role"] = s.Role()
+	vars["leader"] = leader
+	if addr := s.Addr(); addr != nil {
+		vars["listen_addr"] = addr.String()
+	}
+	return vars
+}
+
+// serveAdmin starts the admin HTTP listener on AdminAddr. It is closed
+// together with the server.
+func (s *Server) serveAdmin() error {
+	ln, err := net.Listen("tcp", s.AdminAddr)
+	if err != nil {
+		return fmt.Errorf("admin listen error: %s", err)
+	}
+	srv := &http.Server{Handler: s.AdminHandler()}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ln.Close()
+	}
+	s.admin = srv
+	s.mu.Unlock()
+
+	log.Printf("admin listener starting on [%s]\n", ln.Addr())
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("admin serve error:", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/cache/dense"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpvar(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, ExpvarName: "ggcache_test"}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	assert.Nil(t, c.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var vars map[string]json.RawMessage
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &vars))
+
+	var stats map[string]any
+	assert.Nil(t, json.Unmarshal(vars["ggcache_test"], &stats))
+	assert.Equal(t, float64(1), stats["cache_sets_total"])
+	assert.Equal(t, float64(1), stats["cache_keys"])
+	assert.Equal(t, RoleLeader, stats["role"])
+
+	// Names are global to the process.
+	assert.NotNil(t, s.PublishExpvar("ggcache_test"))
+}
+
+func TestStatsAPI(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, NamespaceSeparator: ":"}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("users:1"), []byte("alice"), 0))
+	_, err = c.Get(ctx, []byte("users:1"))
+	assert.Nil(t, err)
+	_, err = c.Get(ctx, []byte("users:2"))
+	assert.NotNil(t, err)
+	assert.Nil(t, c.Set(ctx, []byte("plain"), []byte("value"), time.Minute))
+
+	// Latencies are recorded after the response is written.
+	assert.Eventually(t, func() bool {
+		return s.CommandLatencies()["set"].Count == 2
+	}, time.Second, time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stats", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var report StatsReport
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, StatsAPIVersion, report.Version)
+	assert.Equal(t, s.Addr().String(), report.Node.ListenAddr)
+	assert.Equal(t, 2, report.Node.Cache.Keys)
+	assert.Equal(t, 0.5, report.Node.Cache.HitRatio)
+	assert.Len(t, report.Node.Cache.TTLKeys, len(report.Node.Cache.TTLBoundsSeconds)+1)
+	assert.Equal(t, 1, report.Node.Cache.TTLKeys[2])
+	assert.Equal(t, RoleLeader, report.Cluster.Role)
+	assert.Equal(t, uint64(2), report.Commands["get"].Count)
+	assert.Len(t, report.Commands["get"].Counts, len(report.Commands["get"].BoundsSeconds)+1)
+	assert.Equal(t, NamespaceStats{Hits: 1, Misses: 1, Sets: 1}, report.Namespaces["users"])
+	assert.Equal(t, NamespaceStats{Sets: 1}, report.Namespaces["_default"])
+	assert.Equal(t, uint64(2), report.ValueSizes.Count)
+	assert.Equal(t, uint64(10), report.ValueSizes.SumBytes)
+	assert.Equal(t, 5, report.ValueSizes.MaxBytes)
+	assert.Len(t, report.ValueSizes.Counts, len(report.ValueSizes.BoundsBytes)+1)
+	assert.Equal(t, uint64(1), report.NamespaceValueSizes["users"].Count)
+	assert.Len(t, report.NamespaceValueSizes, 2)
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/stats", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestMemoryAPI(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("users:1"), []byte("alice"), 0))
+	assert.Nil(t, c.Set(ctx, []byte("users:2"), []byte("bob"), 0))
+	assert.Nil(t, c.Set(ctx, []byte("blobs:1"), make([]byte, 5000), 0))
+	assert.Nil(t, c.Set(ctx, []byte("plain"), []byte("value"), 0))
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/memory/usage?key=users:1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"key": "users:1", "bytes": 12}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/memory/usage?key=users:3", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/memory/doctor?top=1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var report MemoryReport
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, 4, report.Sampled)
+	assert.Equal(t, 4, report.Keys)
+	assert.Equal(t, []KeyUsage{{Key: "blobs:1", Bytes: 5007}}, report.Largest)
+	assert.Equal(t, PrefixUsage{Keys: 2, Bytes: 22, Counts: []int{2, 0, 0, 0, 0, 0, 0, 0, 0}, EstimatedBytes: 22}, report.Prefixes["users"])
+	assert.Equal(t, []int{0, 0, 0, 0, 1, 0, 0, 0, 0}, report.Prefixes["blobs"].Counts)
+	assert.Equal(t, 1, report.Prefixes["_default"].Keys)
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/memory/doctor?samples=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestMemoryAPISlab(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, dense.New(dense.Options{}))
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("baz"), 0))
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/memory/doctor", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var report MemoryReport
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, 1, report.Sampled)
+	assert.Equal(t, 12, report.Slab.Bytes)
+	assert.Equal(t, 6, report.Slab.GarbageBytes)
+	assert.Equal(t, 0.5, report.Slab.Fragmentation)
+
+	stats := make(map[string]int64)
+	for _, stat := range s.Stats() {
+		stats[stat.Name] = stat.Value
+	}
+	assert.Equal(t, int64(6), stats["cache_slab_garbage_bytes"])
+}
+
+func TestValueSizes(t *testing.T) {
+	s := NewServer(ServerOpts{NamespaceSeparator: ":"}, nil)
+	s.observeValueSize([]byte("blobs:1"), 64)
+	s.observeValueSize([]byte("blobs:2"), 65)
+	s.observeValueSize([]byte("blobs:3"), 32<<20)
+	s.observeValueSize([]byte("users:1"), 10)
+
+	sizes, namespaces := s.ValueSizes()
+	assert.Equal(t, uint64(4), sizes.Count)
+	assert.Equal(t, 32<<20, sizes.Max)
+	assert.Equal(t, uint64(2), sizes.Counts[0])
+
+	// The blobs are told apart from the small values of the others.
+	blobs := namespaces["blobs"]
+	assert.Equal(t, uint64(3), blobs.Count)
+	assert.Equal(t, uint64(1), blobs.Counts[0])
+	assert.Equal(t, uint64(1), blobs.Counts[1])
+	assert.Equal(t, uint64(1), blobs.Counts[len(blobs.Bounds)])
+	assert.Equal(t, 10, namespaces["users"].Max)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned by an Authenticator for a token that does not
+// authenticate any tenant. The AUTH command is answered with
+// StatusUnauthorized, while other errors are answered with StatusError.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Authenticator verifies the tokens sent in AUTH commands.
+//
+// The tenants it returns that are equal share their state, the quota of
+// commands left in particular, so a tenant whose tokens differ, such as
+// those of HMACTokens, is throttled as a whole.
+type Authenticator interface {
+	// Authenticate returns the tenant the token authenticates as. If it
+	// authenticates none, the error wraps ErrInvalidToken.
+	Authenticate(ctx context.Context, token []byte) (Tenant, error)
+}
+
+// AuthFunc is an Authenticator calling the function, e.g. to check the
+// tokens against a credential system of its own.
+type AuthFunc func(ctx context.Context, token []byte) (Tenant, error)
+
+func (f AuthFunc) Authenticate(ctx context.Context, token []byte) (Tenant, error) {
+	return f(ctx, token)
+}
+
+// StaticTokens authenticates the tenants by their Token, the Authenticator
+// of ServerOpts.Tenants. Every token is compared in constant time.
+type StaticTokens []Tenant
+
+func (st StaticTokens) Authenticate(_ context.Context, token []byte) (Tenant, error) {
+	var (
+		found Tenant
+		ok    bool
+	)
+	for _, t := range st {
+		if subtle.ConstantTimeCompare([]byte(t.Token), token) == 1 {
+			found, ok = t, true
+		}
+	}
+	if !ok {
+		return Tenant{}, ErrInvalidToken
+	}
+	return found, nil
+}
+
+// HMACTokens authenticates tokens signed with Secret by Sign, which carry
+// the namespace of the tenant and when they expire, so they can be issued
+// by another system without configuring the server for each tenant. The
+// tenants all have the quota of RequestsPerSecond and Burst, each its own.
+// A token for the empty namespace is that of an operator.
+type HMACTokens struct {
+	Secret            []byte
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// Sign returns a token for the namespace that expires at the time. It is
+// the namespace, the expiry in unix seconds and the base64url HMAC-SHA256 of
+// both, joined by dots.
+func (h HMACTokens) Sign(namespace string, expiry time.Time) string {
+	payload := namespace + "." + strconv.FormatInt(expiry.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(h.mac(payload))
+}
+
+func (h HMACTokens) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, h.Secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func (h HMACTokens) Authenticate(_ context.Context, token []byte) (Tenant, error) {
+	// The namespace may contain dots, the expiry and signature do not.
+	i := bytes.LastIndexByte(token, '.')
+	if i < 0 {
+		return Tenant{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	payload, sig := string(token[:i]), token[i+1:]
+	want := make([]byte, base64.RawURLEncoding.EncodedLen(sha256.Size))
+	base64.RawURLEncoding.Encode(want, h.mac(payload))
+	if !hmac.Equal(want, sig) {
+		return Tenant{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	j := strings.LastIndexByte(payload, '.')
+	if j < 0 {
+		return Tenant{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	expiry, err := strconv.ParseInt(payload[j+1:], 10, 64)
+	if err != nil {
+		return Tenant{}, fmt.Errorf("%w: malformed expiry", ErrInvalidToken)
+	}
+	if time.Now().Unix() >= expiry {
+		return Tenant{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	return Tenant{
+		Namespace:         payload[:j],
+		RequestsPerSecond: h.RequestsPerSecond,
+		Burst:             h.Burst,
+	}, nil
+}
+
+// DefaultAuthTimeout bounds the requests of HTTPAuth if its Timeout is zero.
+const DefaultAuthTimeout = 2 * time.Second
+
+// HTTPAuth authenticates the tokens with an external service. It POSTs
+// {"token": "..."} to URL, which answers a valid token with 200 and the
+// tenant as {"namespace": "...", "requests_per_second": 100, "burst": 200},
+// and an invalid one with 401 or 403. The token is not passed on to the
+// tenant, so the tenants of the service share their quota by namespace.
+type HTTPAuth struct {
+	URL     string
+	Timeout time.Duration
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// authResponse is the tenant answered by the service of HTTPAuth.
+type authResponse struct {
+	Namespace         string  `json:"namespace"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+func (h HTTPAuth) Authenticate(ctx context.Context, token []byte) (Tenant, error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultAuthTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{"token": string(token)})
+	if err != nil {
+		return Tenant{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return Tenant{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	hc := h.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return Tenant{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return Tenant{}, ErrInvalidToken
+	default:
+		return Tenant{}, fmt.Errorf("auth service answered %s", resp.Status)
+	}
+	var ar authResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
+		return Tenant{}, fmt.Errorf("auth service: %w", err)
+	}
+	return Tenant{
+		Namespace:         ar.Namespace,
+		RequestsPerSecond: ar.RequestsPerSecond,
+		Burst:             ar.Burst,
+	}, nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMACTokens(t *testing.T) {
+	h := HMACTokens{Secret: []byte("secret"), RequestsPerSecond: 10}
+	ctx := context.Background()
+
+	tn, err := h.Authenticate(ctx, []byte(h.Sign("team.a", time.Now().Add(time.Hour))))
+	assert.Nil(t, err)
+	assert.Equal(t, Tenant{Namespace: "team.a", RequestsPerSecond: 10}, tn)
+
+	// Expired, tampered with or signed with another secret.
+	for _, token := range []string{
+		h.Sign("team.a", time.Now().Add(-time.Second)),
+		"team.b" + h.Sign("team.a", time.Now().Add(time.Hour))[len("team.a"):],
+		HMACTokens{Secret: []byte("other")}.Sign("team.a", time.Now().Add(time.Hour)),
+		"garbage",
+	} {
+		_, err = h.Authenticate(ctx, []byte(token))
+		assert.ErrorIs(t, err, ErrInvalidToken, token)
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Token string }
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		switch req.Token {
+		case "good":
+			_, _ = w.Write([]byte(`{"namespace":"team-a","requests_per_second":5}`))
+		case "down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer service.Close()
+
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader:      true,
+		Authenticator: HTTPAuth{URL: service.URL},
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	addr := s.Addr().String()
+	a, err := client.New(addr, client.Options{AuthToken: "good"})
+	assert.Nil(t, err)
+	defer a.Close()
+	assert.Nil(t, a.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+	assert.True(t, s.cache.Has([]byte("team-a:foo")))
+
+	_, err = client.New(addr, client.Options{AuthToken: "bad"})
+	assert.Equal(t, client.ErrUnauthorized, err)
+	_, err = client.New(addr, client.Options{AuthToken: "down"})
+	assert.NotNil(t, err)
+	assert.NotEqual(t, client.ErrUnauthorized, err)
+}
+
+func TestAuthFuncSharedQuota(t *testing.T) {
+	// Every token authenticates the same tenant, which has a single quota.
+	auth := AuthFunc(func(_ context.Context, token []byte) (Tenant, error) {
+		return Tenant{Namespace: "team-a", RequestsPerSecond: 0.1, Burst: 1}, nil
+	})
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, Authenticator: auth}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	a, err := client.New(s.Addr().String(), client.Options{AuthToken: "one"})
+	assert.Nil(t, err)
+	defer a.Close()
+	b, err := client.New(s.Addr().String(), client.Options{AuthToken: "two"})
+	assert.Nil(t, err)
+	defer b.Close()
+
+	assert.Nil(t, a.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.NotNil(t, b.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Equal(t, uint64(1), s.tenants.throttled.Load())
+}
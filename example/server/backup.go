@@ -0,0 +1,405 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// ObjectStore is the blob storage snapshots are backed up to.
+type ObjectStore interface {
+	Put(ctx context.Context, name string, body []byte) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns the names of the objects starting with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Backups uploads snapshots of the cache to an ObjectStore and restores them.
+// The cache must implement ggcache.Snapshotter.
+type Backups struct {
+	Store ObjectStore
+	// Prefix is prepended to the object names, e.g. "prod/node-1/".
+	Prefix string
+	// Interval between automatic backups, zero only backs up on request.
+	Interval time.Duration
+	// Retain is the number of backups kept. Older ones are deleted after
+	// every backup; zero keeps all of them.
+	Retain int
+}
+
+// backupSuffix ends the name of every backup object.
+const backupSuffix = ".ggsnap"
+
+// Backup uploads a snapshot of the cache and prunes the backups beyond
+// Retain. It returns the name of the new object.
+func (b *Backups) Backup(ctx context.Context, s ggcache.Snapshotter) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := s.Snapshot(buf); err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
+
+	// The timestamp makes names sort in the order the backups were taken.
+	name := b.Prefix + "snapshot-" + time.Now().UTC().Format("20060102T150405.000000000Z") + backupSuffix
+	if err := b.Store.Put(ctx, name, buf.Bytes()); err != nil {
+		return "", fmt.Errorf("upload backup [%s]: %w", name, err)
+	}
+
+	if b.Retain > 0 {
+		names, err := b.List(ctx)
+		if err != nil {
+			return name, fmt.Errorf("prune backups: %w", err)
+		}
+		for len(names) > b.Retain {
+			if err := b.Store.Delete(ctx, names[0]); err != nil {
+				return name, fmt.Errorf("prune backup [%s]: %w", names[0], err)
+			}
+			names = names[1:]
+		}
+	}
+
+	return name, nil
+}
+
+// List returns the names of the backups, oldest first.
+func (b *Backups) List(ctx context.Context) ([]string, error) {
+	all, err := b.Store.List(ctx, b.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range all {
+		if strings.HasSuffix(name, backupSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Restore loads the named backup into the cache, or the latest one if name
+// is empty. It returns the name of the restored object.
+func (b *Backups) Restore(ctx context.Context, s ggcache.Snapshotter, name string) (string, error) {
+	body, name, err := b.Open(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	if err := s.Restore(body); err != nil {
+		return "", fmt.Errorf("restore backup [%s]: %w", name, err)
+	}
+	return name, nil
+}
+
+// Open downloads the named backup, or the latest one if name is empty, e.g.
+// to verify it. It returns the snapshot along with the name of the object.
+func (b *Backups) Open(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	if len(name) == 0 {
+		names, err := b.List(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(names) == 0 {
+			return nil, "", fmt.Errorf("no backups found under [%s]", b.Prefix)
+		}
+		name = names[len(names)-1]
+	}
+
+	body, err := b.Store.Get(ctx, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("download backup [%s]: %w", name, err)
+	}
+	return body, name, nil
+}
+
+// backupLoop takes a backup every Backups.Interval until the server is closed.
+func (s *Server) backupLoop() {
+	ticker := time.NewTicker(s.Backups.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case <-ticker.C:
+			if name, err := s.backup(context.Background()); err != nil {
+				log.Println("backup error:", err)
+			} else {
+				log.Printf("backup written to [%s]\n", name)
+			}
+		}
+	}
+}
+
+func (s *Server) backup(ctx context.Context) (string, error) {
+	if s.Backups == nil {
+		return "", errors.New("backups are not configured")
+	}
+	snap, ok := s.cache.(ggcache.Snapshotter)
+	if !ok {
+		return "", errors.New("cache does not support snapshots")
+	}
+	name, err := s.Backups.Backup(ctx, snap)
+	s.recordBackup(err)
+	return name, err
+}
+
+func (s *Server) handleBackupCommand(ctx context.Context, conn net.Conn, _ *proto.CommandBackup) error {
+	resp := proto.ResponseBackup{}
+
+	name, err := s.backup(ctx)
+	if err != nil {
+		log.Println("backup error:", err)
+		resp.Status = proto.StatusError
+		_, err := conn.Write(resp.Bytes())
+		return err
+	}
+
+	resp.Status = proto.StatusOK
+	resp.Name = name
+	_, err = conn.Write(resp.Bytes())
+
+	return err
+}
+
+// S3 is an ObjectStore for Amazon S3 and S3-compatible services, including
+// MinIO and Google Cloud Storage through its XML API with HMAC keys.
+// Requests use path-style addressing and are signed with Signature V4.
+type S3 struct {
+	// Endpoint, e.g. https://s3.eu-west-1.amazonaws.com or
+	// https://storage.googleapis.com.
+	Endpoint string
+	// Region the bucket is in, "auto" for Google Cloud Storage.
+	Region       string
+	Bucket       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+func (s *S3) Put(ctx context.Context, name string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, name, nil, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	var (
+		names []string
+		token string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if len(token) != 0 {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: decode list: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			names = append(names, c.Key)
+		}
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends a signed request for the object, or for the bucket if name is
+// empty. Responses other than 2xx are turned into errors.
+func (s *S3) do(ctx context.Context, method, name string, query url.Values, body []byte) (*http.Response, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3: endpoint: %w", err)
+	}
+
+	path := "/" + s.Bucket
+	if len(name) != 0 {
+		path += "/" + name
+	}
+	rawPath := s3Escape(path, false)
+	rawQuery := s3Query(query)
+
+	target := endpoint.Scheme + "://" + endpoint.Host + rawPath
+	if len(rawQuery) != 0 {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, rawPath, rawQuery, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3: %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(b))
+	}
+	return resp, nil
+}
+
+// sign adds the Signature V4 headers to the request.
+func (s *S3) sign(req *http.Request, rawPath, rawQuery string, body []byte, now time.Time) {
+	var (
+		amzDate     = now.Format("20060102T150405Z")
+		date        = now.Format("20060102")
+		payloadHash = sha256Hex(body)
+		scope       = date + "/" + s.Region + "/s3/aws4_request"
+	)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if len(s.SessionToken) != 0 {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		rawPath,
+		rawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, and
+// slashes unless encodeSlash is set, as Signature V4 requires.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes the query sorted by name, as Signature V4 requires.
+func s3Query(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
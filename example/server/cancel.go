@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// inflightTable holds the cancel functions of the commands in progress that
+// their connection may abandon with a CANCEL command.
+type inflightTable struct {
+	mu    sync.Mutex
+	calls map[inflightKey]inflightCall
+
+	// cancelled counts the commands abandoned by a CANCEL, expired those
+	// skipped or stopped as their deadline passed, and timedOut those
+	// stopped as they ran past their CommandTimeouts.
+	cancelled atomic.Uint64
+	expired   atomic.Uint64
+	timedOut  atomic.Uint64
+}
+
+// errCommandTimeout is the cause of the context of a command that ran past
+// its CommandTimeouts.
+var errCommandTimeout = errors.New("command timeout")
+
+// inflightKey scopes request IDs to their connection, so a client can only
+// cancel its own commands.
+type inflightKey struct {
+	conn net.Conn
+	id   uint64
+}
+
+type inflightCall struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// requestID returns the ID the command can be cancelled by, zero if it
+// cannot be.
+func requestID(cmd any) uint64 {
+	switch v := cmd.(type) {
+	case *proto.CommandFill:
+		return v.ID
+	case *proto.CommandBackup:
+		return v.ID
+	default:
+		return 0
+	}
+}
+
+// start returns the context of the command with the ID, cancelled by a
+// CANCEL from the connection or once the connection is closed, and the func
+// to call when the command is done. A command reusing the ID of one still in
+// progress replaces it, leaving the first one uncancellable.
+func (t *inflightTable) start(conn net.Conn, id uint64) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	key := inflightKey{conn, id}
+
+	t.mu.Lock()
+	if t.calls == nil {
+		t.calls = make(map[inflightKey]inflightCall)
+	}
+	t.calls[key] = inflightCall{ctx, cancel}
+	t.mu.Unlock()
+
+	return ctx, func() {
+		t.mu.Lock()
+		if t.calls[key].ctx == ctx {
+			delete(t.calls, key)
+		}
+		t.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel abandons the command with the ID sent by the connection, if it is
+// still in progress.
+func (t *inflightTable) cancel(conn net.Conn, id uint64) {
+	key := inflightKey{conn, id}
+
+	t.mu.Lock()
+	call, ok := t.calls[key]
+	delete(t.calls, key)
+	t.mu.Unlock()
+
+	if ok {
+		t.cancelled.Add(1)
+		call.cancel()
+	}
+}
+
+// expire reports whether err is that of a command with the context ctx
+// stopped at its deadline or its CommandTimeouts, and counts it if it is.
+func (t *inflightTable) expire(ctx context.Context, err error) bool {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(context.Cause(ctx), errCommandTimeout) {
+		t.timedOut.Add(1)
+	} else {
+		t.expired.Add(1)
+	}
+	return true
+}
+
+// expiredStatus returns the status answering a command with the context
+// ctx that expire reported: StatusTimeout if it ran past its
+// CommandTimeouts, StatusDeadlineExceeded if the client gave up on it.
+func expiredStatus(ctx context.Context) proto.Status {
+	if errors.Is(context.Cause(ctx), errCommandTimeout) {
+		return proto.StatusTimeout
+	}
+	return proto.StatusDeadlineExceeded
+}
+
+// cancelConn abandons every command in progress of a closed connection.
+func (t *inflightTable) cancelConn(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, call := range t.calls {
+		if key.conn == conn {
+			delete(t.calls, key)
+			call.cancel()
+		}
+	}
+}
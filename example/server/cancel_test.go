@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingFiller loads values only once unblocked, reporting the loads that
+// were abandoned.
+type blockingFiller struct {
+	started   chan struct{}
+	abandoned chan struct{}
+}
+
+func (f *blockingFiller) Fill(ctx context.Context, key []byte) ([]byte, error) {
+	f.started <- struct{}{}
+	<-ctx.Done()
+	close(f.abandoned)
+	return nil, ctx.Err()
+}
+
+func TestCancelFill(t *testing.T) {
+	filler := &blockingFiller{started: make(chan struct{}, 1), abandoned: make(chan struct{})}
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, Filler: filler}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-filler.started
+		cancel()
+	}()
+	_, err = c.Fill(ctx, []byte("foo"))
+	assert.Equal(t, context.Canceled, err)
+
+	select {
+	case <-filler.abandoned:
+	case <-time.After(time.Second):
+		t.Fatal("the fill was not abandoned")
+	}
+	assert.Equal(t, uint64(1), s.inflight.cancelled.Load())
+
+	// The connection is still in step.
+	ctx = context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	value, err := c.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// maxCaptureFrames bounds the commands a single capture records.
+const maxCaptureFrames = 100000
+
+var (
+	// ErrNoSuchClient is returned by Capture for a connection the server
+	// does not serve.
+	ErrNoSuchClient = errors.New("no such connection")
+
+	// ErrCapturing is returned by Capture for a connection already being
+	// captured.
+	ErrCapturing = errors.New("the connection is already being captured")
+)
+
+// Capture records the commands read from a connection as a capture file,
+// which "ggcache replay" sends to another server to reproduce what the
+// connection did.
+type Capture struct {
+	ci     *connInfo
+	start  time.Time
+	redact bool
+
+	mu     sync.Mutex
+	left   int
+	done   bool
+	frames chan []byte
+}
+
+// Capture starts recording the next frames commands read from the
+// connection with the ID, as listed by Clients. With redact, the values
+// they carry are replaced by as many "x", so the capture reproduces their
+// sizes without revealing them; auth tokens and cluster secrets are always
+// redacted. Only the connections of the binary protocol are captured.
+func (s *Server) Capture(id uint64, frames int, redact bool) (*Capture, error) {
+	if frames <= 0 || frames > maxCaptureFrames {
+		return nil, fmt.Errorf("frames must be between 1 and %d", maxCaptureFrames)
+	}
+
+	ci := s.client(id)
+	if ci == nil || ci.protocol != "binary" {
+		return nil, ErrNoSuchClient
+	}
+	c := &Capture{
+		ci:     ci,
+		start:  time.Now(),
+		redact: redact,
+		left:   frames,
+		// Room for every frame, so the connection never waits on the
+		// reader of the capture.
+		frames: make(chan []byte, frames),
+	}
+	if !ci.capture.CompareAndSwap(nil, c) {
+		return nil, ErrCapturing
+	}
+	return c, nil
+}
+
+// WriteTo writes the capture file to w as the commands are read, and
+// returns once they are all recorded, the connection is closed or Stop is
+// called.
+func (c *Capture) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, proto.CaptureMagic)
+	written := int64(n)
+	if err != nil {
+		c.Stop()
+		return written, err
+	}
+	for frame := range c.frames {
+		n, err := w.Write(frame)
+		written += int64(n)
+		if err != nil {
+			c.Stop()
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Stop ends the capture before all its commands are recorded.
+func (c *Capture) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.end()
+}
+
+// end ends the capture. c.mu must be held.
+func (c *Capture) end() {
+	if c.done {
+		return
+	}
+	c.done = true
+	close(c.frames)
+	c.ci.capture.CompareAndSwap(c, nil)
+}
+
+// record adds the command just read to the capture.
+func (c *Capture) record(cmd any) {
+	appender, ok := cmd.(proto.Appender)
+	if !ok {
+		return
+	}
+	appender = redacted(appender, c.redact)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return
+	}
+	frame := &proto.CaptureFrame{Elapsed: time.Since(c.start), Command: appender}
+	c.frames <- frame.Bytes()
+	if c.left--; c.left == 0 {
+		c.end()
+	}
+}
+
+// captured records the command just read from the connection if it is
+// being captured.
+func (ci *connInfo) captured(cmd any) {
+	if c := ci.capture.Load(); c != nil {
+		c.record(cmd)
+	}
+}
+
+// endCapture ends the capture of a closed connection.
+func (ci *connInfo) endCapture() {
+	if c := ci.capture.Load(); c != nil {
+		c.Stop()
+	}
+}
+
+// redacted returns a copy of the command without its secrets and, with
+// values, without the values it carries.
+func redacted(cmd proto.Appender, values bool) proto.Appender {
+	redact := func(b []byte) []byte {
+		if !values {
+			return b
+		}
+		return bytes.Repeat([]byte("x"), len(b))
+	}
+
+	switch v := cmd.(type) {
+	case *proto.CommandAuth:
+		return &proto.CommandAuth{Token: bytes.Repeat([]byte("x"), len(v.Token))}
+	case *proto.CommandJoin:
+		cp := *v
+		cp.Secret = ""
+		return &cp
+	case *proto.CommandPromote:
+		cp := *v
+		cp.Secret = ""
+		return &cp
+	case *proto.CommandSet:
+		cp := *v
+		cp.Value = redact(v.Value)
+		return &cp
+	case *proto.CommandSetIf:
+		cp := *v
+		cp.Value = redact(v.Value)
+		return &cp
+	case *proto.CommandSetLease:
+		cp := *v
+		cp.Value = redact(v.Value)
+		return &cp
+	case *proto.CommandAppend:
+		cp := *v
+		cp.Data = redact(v.Data)
+		return &cp
+	case *proto.CommandXAdd:
+		cp := *v
+		cp.Value = redact(v.Value)
+		return &cp
+	case *proto.CommandPublish:
+		cp := *v
+		cp.Message = redact(v.Message)
+		return &cp
+	case *proto.CommandSync:
+		cp := *v
+		cp.Data = redact(v.Data)
+		return &cp
+	case *proto.CommandBatch:
+		return &proto.CommandBatch{Commands: redactedAll(v.Commands, values)}
+	case *proto.CommandMulti:
+		return &proto.CommandMulti{Commands: redactedAll(v.Commands, values)}
+	case *proto.CommandDeadline:
+		return &proto.CommandDeadline{Timeout: v.Timeout, Command: redacted(v.Command, values)}
+	case *proto.CommandOffset:
+		return &proto.CommandOffset{Offset: v.Offset, Command: redacted(v.Command, values)}
+	case *proto.CommandNoReply:
+		return &proto.CommandNoReply{Command: redacted(v.Command, values)}
+	case *proto.CommandAt:
+		return &proto.CommandAt{Time: v.Time, Command: redacted(v.Command, values)}
+	default:
+		return cmd
+	}
+}
+
+func redactedAll(cmds []proto.Appender, values bool) []proto.Appender {
+	out := make([]proto.Appender, len(cmds))
+	for i, cmd := range cmds {
+		out[i] = redacted(cmd, values)
+	}
+	return out
+}
+
+// handleCaptureAPI captures the next commands of the connection with the id
+// parameter on a GET to /api/v1/clients/capture, answering with the
+// capture file once the number of the frames parameter are recorded, the
+// connection is closed or the request is abandoned. The values are
+// redacted unless the redact parameter is false.
+func (s *Server) handleCaptureAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	id, err := strconv.ParseUint(query.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id: expected the id of a connection", http.StatusBadRequest)
+		return
+	}
+	frames, err := strconv.Atoi(query.Get("frames"))
+	if err != nil {
+		http.Error(w, "invalid frames: expected a number of commands", http.StatusBadRequest)
+		return
+	}
+	redact := true
+	if v := query.Get("redact"); len(v) != 0 {
+		if redact, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid redact: expected true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, err := s.Capture(id, frames, redact)
+	switch {
+	case errors.Is(err, ErrNoSuchClient):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrCapturing):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			c.Stop()
+		case <-stop:
+		}
+	}()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"capture-%d.ggc\"", id))
+	_, _ = c.WriteTo(w)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapture(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	c2, err := client.New(s.Addr().String(), client.Options{})
+	assert.Nil(t, err)
+	defer c2.Close()
+	// The connection is served once it answered a command.
+	ctx := context.Background()
+	assert.Nil(t, c2.Ping(ctx))
+	clients := s.Clients()
+	id := clients[len(clients)-1].ID
+
+	capture, err := s.Capture(id, 3, true)
+	assert.Nil(t, err)
+	_, err = s.Capture(id, 3, true)
+	assert.ErrorIs(t, err, ErrCapturing)
+	_, err = s.Capture(id+1, 3, true)
+	assert.ErrorIs(t, err, ErrNoSuchClient)
+
+	assert.Nil(t, c2.Set(ctx, []byte("foo"), []byte("secret"), 0))
+	_, err = c2.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Nil(t, c2.Batch(ctx, []proto.Appender{&proto.CommandSet{Key: []byte("bar"), Value: []byte("hidden")}}))
+	// Past the frames of the capture.
+	assert.Nil(t, c2.Delete(ctx, []byte("foo")))
+
+	var buf bytes.Buffer
+	_, err = capture.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	// The values are redacted, keeping their sizes.
+	r := bytes.NewReader(buf.Bytes())
+	assert.Nil(t, proto.ReadCaptureMagic(r))
+	var cmds []proto.Appender
+	for {
+		frame, err := proto.ParseCaptureFrame(r)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		cmds = append(cmds, frame.Command)
+	}
+	assert.Equal(t, []proto.Appender{
+		&proto.CommandSet{Key: []byte("foo"), Value: []byte("xxxxxx")},
+		&proto.CommandGet{Key: []byte("foo")},
+		&proto.CommandBatch{Commands: []proto.Appender{&proto.CommandSet{Key: []byte("bar"), Value: []byte("xxxxxx")}}},
+	}, cmds)
+
+	// A capture ends when its connection is closed.
+	capture, err = s.Capture(id, 10, false)
+	assert.Nil(t, err)
+	assert.Nil(t, c2.Close())
+	buf.Reset()
+	_, err = capture.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, proto.CaptureMagic, buf.String())
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/clients/capture?id=999&frames=1", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/clients/capture?id=1&frames=0", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// connInfo is what the server knows of a connection it serves.
+type connInfo struct {
+	id       uint64
+	conn     net.Conn
+	protocol string
+	started  time.Time
+
+	mu          sync.Mutex
+	tenant      *tenant
+	lastCommand string
+	lastActive  time.Time
+
+	// pending is the size of the commands read and not yet answered.
+	pending atomic.Int64
+	// capture records the commands read, if set.
+	capture atomic.Pointer[Capture]
+}
+
+// ClientInfo describes a connection served by the server, as listed on
+// /api/v1/clients.
+type ClientInfo struct {
+	ID       uint64 `json:"id"`
+	Addr     string `json:"addr"`
+	Protocol string `json:"protocol"`
+	// Identity is "operator", "leader" for the connection to our leader,
+	// "anonymous" before a required AUTH, or "tenant:" and the namespace.
+	Identity    string `json:"identity"`
+	AgeSeconds  int64  `json:"age_seconds"`
+	IdleSeconds int64  `json:"idle_seconds"`
+	LastCommand string `json:"last_command,omitempty"`
+	// PendingBytes is the size of the commands received and not answered
+	// yet, which grows on a connection that sends faster than it is served.
+	PendingBytes int64 `json:"pending_bytes"`
+}
+
+// observe records the command just read from the connection.
+func (ci *connInfo) observe(cmd any) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	ci.lastCommand = commandName(cmd)
+	ci.lastActive = time.Now()
+}
+
+// authenticated records the identity of the connection.
+func (ci *connInfo) authenticated(t *tenant) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	ci.tenant = t
+}
+
+func (ci *connInfo) info(now time.Time) ClientInfo {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	info := ClientInfo{
+		ID:           ci.id,
+		Addr:         ci.conn.RemoteAddr().String(),
+		Protocol:     ci.protocol,
+		AgeSeconds:   int64(now.Sub(ci.started).Seconds()),
+		IdleSeconds:  int64(now.Sub(ci.lastActive).Seconds()),
+		LastCommand:  ci.lastCommand,
+		PendingBytes: ci.pending.Load(),
+	}
+	switch {
+	case ci.tenant == nil:
+		info.Identity = "anonymous"
+	case ci.tenant == upstream:
+		info.Identity = "leader"
+	case len(ci.tenant.Namespace) == 0:
+		info.Identity = "operator"
+	default:
+		info.Identity = "tenant:" + ci.tenant.Namespace
+	}
+	return info
+}
+
+// commandName returns the protocol name of a command.
+func commandName(cmd any) string {
+	switch cmd.(type) {
+	case *proto.CommandSet:
+		return "SET"
+	case *proto.CommandGet:
+		return "GET"
+	case *proto.CommandDel:
+		return "DEL"
+	case *proto.CommandDelIf:
+		return "DELIF"
+	case *proto.CommandJoin:
+		return "JOIN"
+	case *proto.CommandStats:
+		return "STATS"
+	case *proto.CommandTouch:
+		return "TOUCH"
+	case *proto.CommandFill:
+		return "FILL"
+	case *proto.CommandBackup:
+		return "BACKUP"
+	case *proto.CommandBatch:
+		return "BATCH"
+	case *proto.CommandGetLease:
+		return "GETLEASE"
+	case *proto.CommandSetLease:
+		return "SETLEASE"
+	case *proto.CommandAppend:
+		return "APPEND"
+	case *proto.CommandGetRange:
+		return "GETRANGE"
+	case *proto.CommandSetIf:
+		return "SETIF"
+	case *proto.CommandAuth:
+		return "AUTH"
+	case *proto.CommandCancel:
+		return "CANCEL"
+	case *proto.CommandTopology:
+		return "TOPOLOGY"
+	case *proto.CommandPing:
+		return "PING"
+	case *proto.CommandRename:
+		return "RENAME"
+	case *proto.CommandCopy:
+		return "COPY"
+	case *proto.CommandSync:
+		return "SYNC"
+	case *proto.CommandPromote:
+		return "PROMOTE"
+	case *proto.CommandFlush:
+		return "FLUSH"
+	case *proto.CommandXAdd:
+		return "XADD"
+	case *proto.CommandXRange:
+		return "XRANGE"
+	case *proto.CommandXRead:
+		return "XREAD"
+	case *proto.CommandPublish:
+		return "PUBLISH"
+	case *proto.CommandSubscribe:
+		return "SUBSCRIBE"
+	case *proto.CommandScan:
+		return "SCAN"
+	case *proto.CommandUndelete:
+		return "UNDELETE"
+	case *proto.CommandPurge:
+		return "PURGE"
+	case *proto.CommandMulti:
+		return "MULTI"
+	case *proto.CommandMGet:
+		return "MGET"
+	case *proto.CommandInfo:
+		return "INFO"
+	case *proto.CommandClock:
+		return "CLOCK"
+	default:
+		return ""
+	}
+}
+
+// countingReader counts the bytes read through it, and bounds the fields of
+// the commands parsed from it.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	max int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// MaxFieldSize bounds the fields of the commands read, as of
+// proto.FieldLimiter.
+func (r *countingReader) MaxFieldSize() int {
+	return r.max
+}
+
+// Clients returns the connections served by the server, oldest first. The
+// connections of the followers that joined it are not listed.
+func (s *Server) Clients() []ClientInfo {
+	s.mu.Lock()
+	infos := make([]*connInfo, 0, len(s.conns))
+	for _, ci := range s.conns {
+		infos = append(infos, ci)
+	}
+	s.mu.Unlock()
+
+	now := time.Now()
+	clients := make([]ClientInfo, 0, len(infos))
+	for _, ci := range infos {
+		clients = append(clients, ci.info(now))
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// KillClient closes the connection with the ID, reporting whether it was
+// served by the server. Its commands in progress are abandoned.
+func (s *Server) KillClient(id uint64) bool {
+	ci := s.client(id)
+	if ci == nil {
+		return false
+	}
+	_ = ci.conn.Close()
+	return true
+}
+
+// client returns the connection with the ID, nil if it is not served by the
+// server.
+func (s *Server) client(id uint64) *connInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ci := range s.conns {
+		if ci.id == id {
+			return ci
+		}
+	}
+	return nil
+}
+
+// handleClientsAPI lists the connections on /api/v1/clients.
+func (s *Server) handleClientsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := json.Marshal(s.Clients())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(b)
+}
+
+// handleKillClientAPI closes the connection with the id parameter on a POST
+// to /api/v1/clients/kill.
+func (s *Server) handleKillClientAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id: expected the id of a connection", http.StatusBadRequest)
+		return
+	}
+	if !s.KillClient(id) {
+		http.Error(w, "no such connection", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClients(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader: true,
+		Tenants: []Tenant{
+			{Token: "op"},
+			{Token: "team-a", Namespace: "a"},
+		},
+		AuthToken: "op",
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	tc, err := client.New(s.Addr().String(), client.Options{AuthToken: "team-a"})
+	assert.Nil(t, err)
+	defer tc.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, tc.Set(ctx, []byte("foo"), []byte("bar"), 0))
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/clients", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var clients []ClientInfo
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &clients))
+	assert.Len(t, clients, 2)
+	assert.Equal(t, "operator", clients[0].Identity)
+	assert.Equal(t, "AUTH", clients[0].LastCommand)
+	assert.Equal(t, "tenant:a", clients[1].Identity)
+	assert.Equal(t, "SET", clients[1].LastCommand)
+	assert.Equal(t, "binary", clients[1].Protocol)
+
+	// Killing the connection of the tenant leaves the other one alone.
+	id := strconv.FormatUint(clients[1].ID, 10)
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/clients/kill?id="+id, nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	assert.NotNil(t, tc.Set(ctx, []byte("foo"), []byte("baz"), 0))
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("baz"), 0))
+	assert.Eventually(t, func() bool {
+		return len(s.Clients()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/clients/kill?id="+id, nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/clients/kill?id="+id, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
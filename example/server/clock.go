@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// DefaultClockSyncInterval is how often the leader sends its clock to the
+// members if ClockSyncInterval is not set.
+const DefaultClockSyncInterval = 10 * time.Second
+
+// clockState is the offset of the clock of a follower from that of its
+// leader, which the mutations it replicates are timed by.
+type clockState struct {
+	// offset is the local clock less that of the leader, in nanoseconds,
+	// zero until the leader sent its clock.
+	offset atomic.Int64
+
+	// clamped counts the TTLs of replicated mutations that were out of
+	// bounds once converted to the local clock.
+	clamped atomic.Uint64
+}
+
+// ClockOffset returns how far the clock of the server is ahead of that of
+// its leader, as last measured, zero on the leader.
+func (s *Server) ClockOffset() time.Duration {
+	return time.Duration(s.clock.offset.Load())
+}
+
+func (s *Server) handleClockCommand(conn net.Conn, cmd *proto.CommandClock) error {
+	if cmd.Time != 0 {
+		offset := time.Now().UnixNano() - cmd.Time - int64(cmd.RTT/2)
+		s.clock.offset.Store(offset)
+	}
+	return proto.WriteMessage(conn, &proto.ResponseSet{Status: proto.StatusOK})
+}
+
+// clockLoop sends the clock of the server to its members every
+// ClockSyncInterval until the server is closed.
+func (s *Server) clockLoop() {
+	interval := s.ClockSyncInterval
+	if interval <= 0 {
+		interval = DefaultClockSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		members := make([]*client.Client, 0, len(s.members))
+		for member := range s.members {
+			members = append(members, member)
+		}
+		s.mu.Unlock()
+
+		for _, member := range members {
+			if err := s.sendClock(member); err != nil {
+				log.Println("send clock to member error:", err)
+			}
+		}
+	}
+}
+
+// sendClock measures the round trip to the member, then sends it the clock
+// along with it.
+func (s *Server) sendClock(member *client.Client) error {
+	ctx := context.TODO()
+	start := time.Now()
+	if err := member.Clock(ctx, time.Time{}, 0); err != nil {
+		return err
+	}
+	rtt := time.Since(start)
+	return member.Clock(ctx, time.Now(), rtt)
+}
+
+// timed wraps a mutation with a TTL in a CommandAt of the time it was made,
+// so the members expire it when this node does rather than counting its TTL
+// from when they apply it. The other mutations are returned as they are.
+func timed(cmd proto.Appender, now time.Time) proto.Appender {
+	hasTTL := false
+	switch v := cmd.(type) {
+	case *proto.CommandSet:
+		hasTTL = v.TTL > 0
+	case *proto.CommandTouch:
+		hasTTL = v.TTL > 0
+	case *proto.CommandCopy:
+		hasTTL = v.TTL > 0
+	case *proto.CommandXAdd:
+		hasTTL = v.TTL > 0
+	case *proto.CommandMulti:
+		for _, c := range v.Commands {
+			if set, ok := c.(*proto.CommandSet); ok && set.TTL > 0 {
+				hasTTL = true
+			}
+		}
+	}
+	if !hasTTL {
+		return cmd
+	}
+	return &proto.CommandAt{Time: now.UnixNano(), Command: cmd}
+}
+
+// untimed returns the mutation of a CommandAt with its TTLs converted to
+// count from now on the local clock.
+func (s *Server) untimed(cmd *proto.CommandAt, now time.Time) proto.Appender {
+	switch v := cmd.Command.(type) {
+	case *proto.CommandSet:
+		v.TTL = s.localTTL(cmd.Time, v.TTL, now)
+	case *proto.CommandTouch:
+		v.TTL = s.localTTL(cmd.Time, v.TTL, now)
+	case *proto.CommandCopy:
+		v.TTL = s.localTTL(cmd.Time, v.TTL, now)
+	case *proto.CommandXAdd:
+		v.TTL = s.localTTL(cmd.Time, v.TTL, now)
+	case *proto.CommandMulti:
+		for _, c := range v.Commands {
+			if set, ok := c.(*proto.CommandSet); ok {
+				set.TTL = s.localTTL(cmd.Time, set.TTL, now)
+			}
+		}
+	}
+	return cmd.Command
+}
+
+// localTTL returns the TTL in milliseconds, counting from now, that expires
+// an entry set at the time at on the clock of the leader with the TTL ttl
+// when the leader expires it. It is clamped between a millisecond, as an
+// entry the leader already expired must not be stored without a TTL, and
+// ttl, so a wrong offset cannot keep the entry longer than it was set for.
+func (s *Server) localTTL(at int64, ttl int, now time.Time) int {
+	if ttl <= 0 {
+		return ttl
+	}
+	expiresAt := at + int64(ttl)*int64(time.Millisecond) + s.clock.offset.Load()
+	left := (time.Duration(expiresAt-now.UnixNano()) + time.Millisecond - 1).Milliseconds()
+	switch {
+	case left < 1:
+		s.clock.clamped.Add(1)
+		return 1
+	case left > int64(ttl):
+		s.clock.clamped.Add(1)
+		return ttl
+	default:
+		return int(left)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalTTL(t *testing.T) {
+	s := &Server{}
+	now := time.Now()
+
+	// The follower is 5s ahead of the leader, which set the entry a second
+	// ago with a TTL of 3s.
+	s.clock.offset.Store(int64(5 * time.Second))
+	at := now.Add(-6 * time.Second).UnixNano()
+	assert.Equal(t, 2000, s.localTTL(at, 3000, now))
+	assert.Equal(t, uint64(0), s.clock.clamped.Load())
+
+	// An entry the leader already expired expires right away, and one past
+	// its TTL is kept for its TTL at most.
+	assert.Equal(t, 1, s.localTTL(at, 500, now))
+	s.clock.offset.Store(int64(time.Hour))
+	assert.Equal(t, 3000, s.localTTL(at, 3000, now))
+	assert.Equal(t, uint64(2), s.clock.clamped.Load())
+
+	// Only the mutations with a TTL are timed.
+	set := &proto.CommandSet{Key: []byte("foo")}
+	assert.Same(t, set, timed(set, now))
+	set.TTL = 1000
+	assert.Equal(t, &proto.CommandAt{Time: now.UnixNano(), Command: set}, timed(set, now))
+}
+
+func TestReplicationClock(t *testing.T) {
+	leader, c, err := StartEmbedded(ServerOpts{
+		IsLeader:            true,
+		ReplicationInterval: 300 * time.Millisecond,
+		ClockSyncInterval:   10 * time.Millisecond,
+	}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1 && follower.ClockOffset() != 0
+	}, time.Second, 10*time.Millisecond)
+	// Both run on the same clock.
+	assert.Less(t, follower.ClockOffset().Abs(), 50*time.Millisecond)
+
+	// The TTL counts from the SET on the leader, not from when the batch
+	// reaches the follower.
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), time.Minute))
+	expiresAt := time.Now().Add(time.Minute)
+	assert.Eventually(t, func() bool {
+		return cache.Has([]byte("foo"))
+	}, time.Second, 10*time.Millisecond)
+	expiry, err := cache.Expiry([]byte("foo"))
+	assert.Nil(t, err)
+	assert.WithinDuration(t, expiresAt, expiry, 60*time.Millisecond)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// gateFiller loads values once its gate is closed.
+type gateFiller struct {
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (f *gateFiller) Fill(ctx context.Context, key []byte) ([]byte, error) {
+	f.started <- struct{}{}
+	<-f.gate
+	return key, nil
+}
+
+func TestDeadlineQueued(t *testing.T) {
+	filler := &gateFiller{started: make(chan struct{}, 1), gate: make(chan struct{})}
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, Workers: 1, Filler: filler}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	c2, err := client.New(s.Addr().String(), client.Options{})
+	assert.Nil(t, err)
+	defer c2.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c2.Set(ctx, []byte("foo"), []byte("bar"), 0))
+
+	// The only worker is busy with the fill until the gate is closed.
+	filled := make(chan error, 1)
+	go func() {
+		_, err := c.Fill(ctx, []byte("slow"))
+		filled <- err
+	}()
+	<-filler.started
+
+	// The GET is queued past its deadline, and skipped.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(filler.gate)
+	}()
+	dctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = c2.Get(dctx, []byte("foo"))
+	assert.ErrorIs(t, err, client.ErrTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, <-filled)
+	assert.Equal(t, int64(1), stat(s, "server_deadline_exceeded_total"))
+
+	// A command sent within its deadline runs as usual.
+	dctx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	value, err := c2.Get(dctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	// One whose deadline passed is not even sent.
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = c2.Get(expired, []byte("foo"))
+	assert.ErrorIs(t, err, client.ErrTimeout)
+	assert.Equal(t, int64(1), stat(s, "server_deadline_exceeded_total"))
+}
+
+func TestDeadlineScan(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 2*scanCheckKeys; i++ {
+		assert.Nil(t, c.Set(ctx, []byte(fmt.Sprintf("key:%d", i)), []byte("x"), 0))
+	}
+
+	// A scan stops at its deadline.
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = s.scan(expired, []byte("*"), nil, 10)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	keys, err := s.scan(ctx, []byte("*"), nil, 10)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 10)
+}
+
+func TestCommandTimeouts(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader:        true,
+		CommandTimeouts: map[string]time.Duration{"FLUSH": time.Nanosecond},
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 2*scanCheckKeys; i++ {
+		assert.Nil(t, c.Set(ctx, []byte(fmt.Sprintf("key:%d", i)), []byte("x"), 0))
+	}
+
+	// The flush stops once it runs past its timeout, before deleting a key.
+	_, err = c.Flush(ctx, "key:*", false)
+	assert.ErrorIs(t, err, client.ErrTimeout)
+	assert.Equal(t, int64(1), stat(s, "server_command_timeouts_total"))
+	assert.Equal(t, int64(0), stat(s, "server_deadline_exceeded_total"))
+	_, err = c.Get(ctx, []byte("key:0"))
+	assert.Nil(t, err)
+
+	// The other commands run as long as they need.
+	keys, err := c.Scan(ctx, "key:*", nil, 10)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 10)
+}
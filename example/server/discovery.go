@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+)
+
+const defaultDiscoveryInterval = 10 * time.Second
+
+// Discovery returns the addresses (host:port) of every node of the cluster,
+// including the calling node itself.
+type Discovery interface {
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// DiscoveryFunc adapts an ordinary function to the Discovery interface.
+type DiscoveryFunc func(ctx context.Context) ([]string, error)
+
+func (f DiscoveryFunc) Peers(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// DNSDiscovery resolves the peers from a DNS name that returns one record per
+// node, such as the headless Service of a Kubernetes StatefulSet.
+type DNSDiscovery struct {
+	Name     string
+	Port     string
+	Resolver *net.Resolver
+}
+
+func (d DNSDiscovery) Peers(ctx context.Context) ([]string, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	hosts, err := resolver.LookupHost(ctx, d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("resolve peers [%s]: %w", d.Name, err)
+	}
+
+	peers := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		peers = append(peers, net.JoinHostPort(host, d.Port))
+	}
+	return peers, nil
+}
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesDiscovery lists the running pods matching LabelSelector through
+// the Kubernetes API, using the in-cluster service account credentials.
+type KubernetesDiscovery struct {
+	Namespace     string
+	LabelSelector string
+	Port          string
+
+	// APIServer defaults to the in-cluster address of the API server.
+	APIServer string
+	Client    *http.Client
+}
+
+func (d KubernetesDiscovery) Peers(ctx context.Context) ([]string, error) {
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes discovery: %w", err)
+	}
+
+	namespace := d.Namespace
+	if len(namespace) == 0 {
+		b, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes discovery: %w", err)
+		}
+		namespace = string(b)
+	}
+
+	apiServer := d.APIServer
+	if len(apiServer) == 0 {
+		apiServer = "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	}
+
+	httpClient := d.Client
+	if httpClient == nil {
+		if httpClient, err = inClusterHTTPClient(); err != nil {
+			return nil, err
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/namespaces/%s/pods?labelSelector=%s",
+		apiServer, url.PathEscape(namespace), url.QueryEscape(d.LabelSelector))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(token))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes discovery: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes discovery: api server responded with [%s]", resp.Status)
+	}
+
+	var pods struct {
+		Items []struct {
+			Status struct {
+				Phase string `json:"phase"`
+				PodIP string `json:"podIP"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, fmt.Errorf("kubernetes discovery: %w", err)
+	}
+
+	var peers []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != "Running" || len(pod.Status.PodIP) == 0 {
+			continue
+		}
+		peers = append(peers, net.JoinHostPort(pod.Status.PodIP, d.Port))
+	}
+	return peers, nil
+}
+
+func inClusterHTTPClient() (*http.Client, error) {
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes discovery: %w", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}, nil
+}
+
+// electLeader deterministically picks the leader every node agrees on as long
+// as they see the same peers: the lowest address.
+func electLeader(peers []string) string {
+	if len(peers) == 0 {
+		return ""
+	}
+	sorted := append([]string(nil), peers...)
+	sort.Strings(sorted)
+	return sorted[0]
+}
+
+// membershipLoop periodically re-evaluates who the leader is and refreshes
+// the registration of this node until the server is closed.
+func (s *Server) membershipLoop() {
+	interval := s.DiscoveryInterval
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if s.Elector != nil || s.Discovery != nil {
+			s.elect()
+		}
+		if s.Registry != nil {
+			s.register()
+		}
+
+		select {
+		case <-s.quitch:
+			if s.Registry != nil {
+				s.deregister()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) elect() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var leader string
+	if s.Elector != nil {
+		var err error
+		if leader, err = s.Elector.Leader(ctx); err != nil {
+			log.Println("election error:", err)
+			return
+		}
+	} else {
+		peers, err := s.Discovery.Peers(ctx)
+		if err != nil {
+			log.Println("discovery error:", err)
+			return
+		}
+		leader = electLeader(peers)
+	}
+
+	if len(leader) == 0 {
+		return
+	}
+	if leader == s.AdvertiseAddr {
+		leader = ""
+	}
+	s.follow(leader)
+}
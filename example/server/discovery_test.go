@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElectLeader(t *testing.T) {
+	assert.Equal(t, "", electLeader(nil))
+	assert.Equal(t, "10.0.0.1:3000", electLeader([]string{"10.0.0.2:3000", "10.0.0.1:3000"}))
+}
+
+func TestDiscoveryFollowsElectedLeader(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		peers []string
+	)
+	discovery := DiscoveryFunc(func(context.Context) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), peers...), nil
+	})
+
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		listeners = append(listeners, ln)
+		peers = append(peers, ln.Addr().String())
+	}
+
+	var servers []*Server
+	for _, ln := range listeners {
+		s := NewServer(ServerOpts{
+			Discovery:         discovery,
+			DiscoveryInterval: 10 * time.Millisecond,
+			AdvertiseAddr:     ln.Addr().String(),
+		}, ggcache.New())
+		defer s.Close()
+		go s.Serve(ln)
+
+		servers = append(servers, s)
+	}
+
+	leader, follower := servers[0], servers[1]
+	if electLeader(discoveredPeers(t, discovery)) != leader.AdvertiseAddr {
+		leader, follower = follower, leader
+	}
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The leader disappears from the peer list, so the follower takes over.
+	mu.Lock()
+	peers = []string{follower.AdvertiseAddr}
+	mu.Unlock()
+	_ = leader.Close()
+
+	assert.Eventually(t, func() bool {
+		follower.mu.Lock()
+		defer follower.mu.Unlock()
+		return follower.leader == ""
+	}, time.Second, 10*time.Millisecond)
+}
+
+func discoveredPeers(t *testing.T, d Discovery) []string {
+	peers, err := d.Peers(context.Background())
+	assert.Nil(t, err)
+	return peers
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+)
+
+// StartEmbedded runs a server inside the current process and returns it
+// together with a client connected to it over loopback. If opts.ListenAddr is
+// empty the server binds a random port on 127.0.0.1, and if c is nil a new
+// ggcache.Cache is used. The client authenticates with opts.AuthToken and
+// sends opts.ClusterSecret with its PROMOTEs.
+//
+// Because the returned client talks the regular protocol, code written
+// against it can later be pointed at a remote cluster with client.New.
+func StartEmbedded(opts ServerOpts, c ggcache.Cacher) (*Server, *client.Client, error) {
+	if len(opts.ListenAddr) == 0 {
+		opts.ListenAddr = "127.0.0.1:0"
+	}
+	if c == nil {
+		c = ggcache.New()
+	}
+
+	ln, err := net.Listen("tcp", opts.ListenAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := NewServer(opts, c)
+	go func() {
+		_ = s.Serve(ln)
+	}()
+
+	cl, err := client.New(ln.Addr().String(), client.Options{TLSConfig: opts.TLSConfig, AuthToken: opts.AuthToken, ClusterSecret: opts.ClusterSecret})
+	if err != nil {
+		_ = s.Close()
+		return nil, nil, err
+	}
+
+	return s, cl, nil
+}
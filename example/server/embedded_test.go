@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartEmbedded(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	assert.Nil(t, c.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+
+	value, err := c.Get(context.Background(), []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	_, err = c.Get(context.Background(), []byte("baz"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, c.Delete(context.Background(), []byte("foo")))
+	_, err = c.Get(context.Background(), []byte("foo"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// errNoSweep is returned by Sweep if the cache is not a ggcache.Sweeper.
+var errNoSweep = errors.New("the cache does not support sweeps")
+
+// ExpiryReport describes the removal of the expired keys of the cache.
+type ExpiryReport struct {
+	Heap    int `json:"heap"`
+	Timers  int `json:"timers"`
+	Backlog int `json:"backlog"`
+	// NextExpiry is the earliest expiration of the expiry heap, omitted if
+	// it is empty.
+	NextExpiry       *time.Time `json:"next_expiry,omitempty"`
+	Sweeps           uint64     `json:"sweeps"`
+	Swept            uint64     `json:"swept"`
+	SweepSeconds     float64    `json:"sweep_seconds"`
+	LastSweepSeconds float64    `json:"last_sweep_seconds"`
+}
+
+func newExpiryReport(es ggcache.ExpiryStats) *ExpiryReport {
+	report := &ExpiryReport{
+		Heap:             es.Heap,
+		Timers:           es.Timers,
+		Backlog:          es.Backlog,
+		Sweeps:           es.Sweeps,
+		Swept:            es.Swept,
+		SweepSeconds:     es.SweepTime.Seconds(),
+		LastSweepSeconds: es.LastSweep.Seconds(),
+	}
+	if !es.NextExpiry.IsZero() {
+		next := es.NextExpiry.UTC()
+		report.NextExpiry = &next
+	}
+	return report
+}
+
+// expiryStats returns the STATS of the expiration of the cache, none if it
+// is not a ggcache.Sweeper. The next expiry is in milliseconds from now,
+// zero if there is none or it is overdue, which the backlog tells.
+func (s *Server) expiryStats() []proto.Stat {
+	sw, ok := s.cache.(ggcache.Sweeper)
+	if !ok {
+		return nil
+	}
+	es := sw.ExpiryStats()
+	next := int64(0)
+	if !es.NextExpiry.IsZero() {
+		next = max(time.Until(es.NextExpiry).Milliseconds(), 0)
+	}
+	return []proto.Stat{
+		{Name: "cache_expiry_heap", Value: int64(es.Heap)},
+		{Name: "cache_expiry_timers", Value: int64(es.Timers)},
+		{Name: "cache_expiry_backlog", Value: int64(es.Backlog)},
+		{Name: "cache_expiry_next_ms", Value: next},
+		{Name: "cache_expiry_sweeps_total", Value: int64(es.Sweeps)},
+		{Name: "cache_expiry_swept_total", Value: int64(es.Swept)},
+		{Name: "cache_expiry_sweep_us_total", Value: es.SweepTime.Microseconds()},
+		{Name: "cache_expiry_last_sweep_us", Value: es.LastSweep.Microseconds()},
+	}
+}
+
+// Sweep removes the expired keys of the cache now rather than when it gets
+// to them, and returns how many it removed. The removals are not replicated,
+// as the keys expire on the followers as well.
+func (s *Server) Sweep() (int, error) {
+	sw, ok := s.cache.(ggcache.Sweeper)
+	if !ok {
+		return 0, errNoSweep
+	}
+	return sw.Sweep(), nil
+}
+
+// handleSweepAPI sweeps the expired keys on a POST to /api/v1/expiry/sweep,
+// answering with the number removed.
+func (s *Server) handleSweepAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	n, err := s.Sweep()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	b, err := json.Marshal(struct {
+		Removed int `json:"removed"`
+	}{n})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
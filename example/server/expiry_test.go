@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/cache/dense"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryStats(t *testing.T) {
+	src := ggcache.New()
+	assert.Nil(t, src.Set([]byte("a"), []byte("1"), 200*time.Millisecond))
+	assert.Nil(t, src.Set([]byte("b"), []byte("2"), 200*time.Millisecond))
+	assert.Nil(t, src.Set([]byte("c"), []byte("3"), time.Hour))
+	buf := new(bytes.Buffer)
+	assert.Nil(t, src.Snapshot(buf))
+
+	cache := ggcache.New()
+	assert.Nil(t, cache.Restore(buf))
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, cache)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	assert.Equal(t, int64(3), stat(s, "cache_expiry_heap"))
+	assert.Greater(t, stat(s, "cache_expiry_next_ms"), int64(0))
+
+	// The restored keys expire together in a sweep of the heap.
+	assert.Eventually(t, func() bool {
+		return stat(s, "cache_expiry_swept_total") == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), stat(s, "cache_expiry_heap"))
+	assert.Equal(t, int64(0), stat(s, "cache_expiry_backlog"))
+	assert.Equal(t, int64(1), stat(s, "cache_expiry_sweeps_total"))
+
+	report := s.StatsReport()
+	assert.NotNil(t, report.Node.Expiry)
+	assert.Equal(t, 1, report.Node.Expiry.Heap)
+	assert.NotNil(t, report.Node.Expiry.NextExpiry)
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/expiry/sweep", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp struct{ Removed int }
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, 0, resp.Removed)
+	assert.Equal(t, int64(2), stat(s, "cache_expiry_sweeps_total"))
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/expiry/sweep", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestExpiryStatsUnsupported(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, dense.New(dense.Options{}))
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	assert.Nil(t, s.StatsReport().Node.Expiry)
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/expiry/sweep", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
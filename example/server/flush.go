@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+const (
+	// DefaultFlushBatchKeys is the number of keys a FLUSH deletes at a time
+	// if FlushBatchKeys is not set.
+	DefaultFlushBatchKeys = 1000
+
+	// DefaultFlushBatchInterval is the pause between the batches of a FLUSH
+	// if FlushBatchInterval is not set.
+	DefaultFlushBatchInterval = 10 * time.Millisecond
+)
+
+// errNoScan is returned by flush if the cache is not a ggcache.Scanner.
+var errNoScan = errors.New("the cache does not support scans")
+
+// errNoCreated is returned by purge if the cache is not a
+// ggcache.CreatedScanner.
+var errNoCreated = errors.New("the cache does not record when values are written")
+
+func (s *Server) handleFlushCommand(ctx context.Context, conn net.Conn, cmd *proto.CommandFlush) error {
+	resp := proto.ResponseFlush{Status: proto.StatusOK}
+	n, err := s.flush(ctx, cmd.Pattern, cmd.DryRun)
+	resp.Keys = uint64(n)
+	switch {
+	case err == nil:
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+	case s.inflight.expire(ctx, err):
+		resp.Status = expiredStatus(ctx)
+	default:
+		log.Println("flush error:", err)
+		resp.Status = proto.StatusError
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+// flush deletes the keys matching the pattern in batches, replicating the
+// deletes like those of DEL, and returns how many it deleted. With dryRun
+// it returns how many match instead. The keys set while it runs are left.
+// It stops with the error of ctx once ctx is done.
+func (s *Server) flush(ctx context.Context, pattern []byte, dryRun bool) (int, error) {
+	scanner, ok := s.cache.(ggcache.Scanner)
+	if !ok {
+		return 0, errNoScan
+	}
+
+	var (
+		matched int
+		keys    [][]byte
+		err     error
+	)
+	scanner.Scan(pattern, func(key []byte) bool {
+		if matched++; matched%scanCheckKeys == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		if !dryRun {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+		return true
+	})
+	switch {
+	case err != nil:
+		return 0, err
+	case dryRun:
+		return matched, nil
+	}
+	return s.deleteBatched(ctx, keys)
+}
+
+func (s *Server) handlePurgeCommand(ctx context.Context, conn net.Conn, cmd *proto.CommandPurge) error {
+	resp := proto.ResponseFlush{Status: proto.StatusOK}
+	n, err := s.purge(ctx, cmd.Prefix, cmd.Before)
+	resp.Keys = uint64(n)
+	switch {
+	case err == nil:
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+	case s.inflight.expire(ctx, err):
+		resp.Status = expiredStatus(ctx)
+	default:
+		log.Println("purge error:", err)
+		resp.Status = proto.StatusError
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+// purge deletes the keys starting with prefix whose value was written before
+// the time like flush does, and returns how many it deleted. It stops with
+// the error of ctx once ctx is done.
+func (s *Server) purge(ctx context.Context, prefix []byte, before time.Time) (int, error) {
+	scanner, ok := s.cache.(ggcache.CreatedScanner)
+	if !ok {
+		return 0, errNoCreated
+	}
+
+	var (
+		keys [][]byte
+		err  error
+	)
+	scanner.ScanCreated(prefix, before, func(key []byte) bool {
+		if len(keys) != 0 && len(keys)%scanCheckKeys == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		keys = append(keys, append([]byte(nil), key...))
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return s.deleteBatched(ctx, keys)
+}
+
+// deleteBatched deletes the keys FlushBatchKeys at a time, pausing for
+// FlushBatchInterval in between, and returns how many it deleted. The keys
+// no longer present are skipped.
+func (s *Server) deleteBatched(ctx context.Context, keys [][]byte) (int, error) {
+	batchKeys := s.FlushBatchKeys
+	if batchKeys <= 0 {
+		batchKeys = DefaultFlushBatchKeys
+	}
+	interval := s.FlushBatchInterval
+	if interval <= 0 {
+		interval = DefaultFlushBatchInterval
+	}
+
+	deleted := 0
+	for i, key := range keys {
+		if i > 0 && i%batchKeys == 0 {
+			select {
+			case <-ctx.Done():
+				return deleted, ctx.Err()
+			case <-s.quitch:
+				return deleted, net.ErrClosed
+			case <-time.After(interval):
+			}
+		}
+		err := s.del(key)
+		if errors.Is(err, ggcache.ErrKeyNotFound) {
+			// Deleted or expired since the scan.
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
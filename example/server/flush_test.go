@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlush(t *testing.T) {
+	leader, c, err := StartEmbedded(ServerOpts{
+		IsLeader:           true,
+		FlushBatchKeys:     10,
+		FlushBatchInterval: 5 * time.Millisecond,
+	}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, c.Set(ctx, []byte(fmt.Sprintf("user:%d:session", i)), []byte("s"), 0))
+		assert.Nil(t, c.Set(ctx, []byte(fmt.Sprintf("user:%d:profile", i)), []byte("p"), 0))
+	}
+	assert.Eventually(t, func() bool {
+		return cache.Stats().Keys == 100
+	}, time.Second, 10*time.Millisecond)
+
+	// A dry run only counts the keys.
+	n, err := c.Flush(ctx, "user:*:session", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, n)
+	assert.True(t, cache.Has([]byte("user:7:session")))
+
+	// The deletes are paced in batches and replicated.
+	start := time.Now()
+	n, err = c.Flush(ctx, "user:*:session", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, n)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	_, err = c.Get(ctx, []byte("user:7:session"))
+	assert.ErrorIs(t, err, ggcache.ErrKeyNotFound)
+	assert.True(t, cache.Has([]byte("user:7:profile")))
+	assert.Eventually(t, func() bool {
+		return cache.Stats().Keys == 50
+	}, time.Second, 10*time.Millisecond)
+
+	n, err = c.Flush(ctx, "user:*:session", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestPurge(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		assert.Nil(t, c.Set(ctx, []byte(key), []byte("old"), 0))
+	}
+	time.Sleep(5 * time.Millisecond)
+	deploy := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, c.Set(ctx, []byte("user:2"), []byte("new"), 0))
+	assert.Nil(t, c.Set(ctx, []byte("user:3"), []byte("new"), 0))
+
+	// Only the keys of the prefix written before the deploy are deleted.
+	n, err := c.Purge(ctx, deploy, "user:")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, err = c.Get(ctx, []byte("user:1"))
+	assert.ErrorIs(t, err, ggcache.ErrKeyNotFound)
+	value, err := c.Get(ctx, []byte("user:2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), value)
+
+	// Without a prefix it goes through every key.
+	n, err = c.Purge(ctx, deploy, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.False(t, s.cache.Has([]byte("order:1")))
+	assert.True(t, s.cache.Has([]byte("user:3")))
+}
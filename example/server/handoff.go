@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// handoffDialTimeout bounds the dial of the process being replaced, which
+// answers right away if it is there at all.
+const handoffDialTimeout = time.Second
+
+// receiveHandoff restores the snapshot of the process being replaced on the
+// same host, if one listens on HandoffPath, before this one binds its
+// listeners. The other process stops serving before it sends the snapshot,
+// so no write is lost in between. It returns nil if there is none.
+func (s *Server) receiveHandoff() error {
+	if len(s.HandoffPath) == 0 {
+		return nil
+	}
+	snap, ok := s.cache.(ggcache.Snapshotter)
+	if !ok {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", s.HandoffPath, handoffDialTimeout)
+	if err != nil {
+		// Nothing to take over: a cold start.
+		return nil
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if err := snap.Restore(conn); err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	log.Printf("took over the dataset of the previous process in %s\n", time.Since(start))
+	return nil
+}
+
+// serveHandoff listens on HandoffPath for the process replacing this one.
+// A stale socket left by a previous process is replaced.
+func (s *Server) serveHandoff() error {
+	if _, ok := s.cache.(ggcache.Snapshotter); !ok {
+		return errors.New("handoff: the cache does not support snapshots")
+	}
+	if err := os.Remove(s.HandoffPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: s.HandoffPath, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("handoff listen error: %s", err)
+	}
+	// The path belongs to the process taking over by the time this one
+	// closes the listener.
+	ln.SetUnlinkOnClose(false)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ln.Close()
+	}
+	s.handoff = ln
+	s.mu.Unlock()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.handingOff.Add(1)
+		defer s.handingOff.Done()
+		s.handOff(conn)
+	}()
+	return nil
+}
+
+// handOff closes the server, so it no longer takes writes, and sends the
+// snapshot of the cache to the process taking over.
+func (s *Server) handOff(conn net.Conn) {
+	defer conn.Close()
+
+	log.Println("handing off the dataset to the next process")
+	_ = s.Close()
+
+	w := &countingWriter{w: conn}
+	if err := s.cache.(ggcache.Snapshotter).Snapshot(w); err != nil {
+		log.Println("handoff error:", err)
+		return
+	}
+	log.Printf("handed off %d bytes\n", w.n)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandoff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ggcache.sock")
+
+	old, oc, err := StartEmbedded(ServerOpts{IsLeader: true, HandoffPath: path}, nil)
+	assert.Nil(t, err)
+	defer old.Close()
+	defer oc.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, oc.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, oc.Set(ctx, []byte("session"), []byte("s"), time.Hour))
+
+	// The new process takes over the dataset, and the old one closes.
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, HandoffPath: path}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	value, err := c.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.True(t, s.cache.Has([]byte("session")))
+	assert.NotNil(t, oc.Set(ctx, []byte("foo"), []byte("lost"), 0))
+
+	// It listens for the next one in turn.
+	next, nc, err := StartEmbedded(ServerOpts{IsLeader: true, HandoffPath: path}, nil)
+	assert.Nil(t, err)
+	defer next.Close()
+	defer nc.Close()
+	value, err = nc.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	// Without a process to take over from, a node starts cold.
+	cold, cc, err := StartEmbedded(ServerOpts{IsLeader: true, HandoffPath: filepath.Join(t.TempDir(), "none.sock")}, nil)
+	assert.Nil(t, err)
+	defer cold.Close()
+	defer cc.Close()
+	_, err = cc.Get(ctx, []byte("foo"))
+	assert.NotNil(t, err)
+}
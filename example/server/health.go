@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net"
+	"runtime/metrics"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// healthInterval is how often the Health of the server is refreshed.
+const healthInterval = time.Second
+
+// heapMetric is the memory taken by the live and not yet swept objects of
+// the heap, which HighMemoryBytes is compared with.
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// Health returns the degraded states of the server, as last refreshed, which
+// every response carries.
+func (s *Server) Health() proto.Health {
+	return proto.Health(s.health.Load())
+}
+
+// healthLoop refreshes the Health of the server every healthInterval until
+// the server is closed.
+func (s *Server) healthLoop() {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case <-ticker.C:
+			s.refreshHealth()
+		}
+	}
+}
+
+// refreshHealth works out the degraded states of the server.
+func (s *Server) refreshHealth() {
+	var h proto.Health
+	if s.persistencePolicy() == PersistenceReadOnly && s.PersistenceReport().Degraded {
+		h |= proto.HealthReadOnly
+	}
+	if s.syncs.restoring.Load() {
+		h |= proto.HealthSyncing
+	}
+	if s.HighMemoryBytes > 0 {
+		sample := []metrics.Sample{{Name: heapMetric}}
+		metrics.Read(sample)
+		if sample[0].Value.Kind() == metrics.KindUint64 && sample[0].Value.Uint64() >= uint64(s.HighMemoryBytes) {
+			h |= proto.HealthHighMemory
+		}
+	}
+	s.health.Store(uint32(h))
+}
+
+// withHealth returns the connection to write a response to, which stamps it
+// with the Health of the server.
+func (s *Server) withHealth(conn net.Conn) net.Conn {
+	return &proto.HealthWriter{Conn: conn, Health: s.Health()}
+}
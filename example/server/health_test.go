@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	store := &fullStore{}
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, Backups: &Backups{Store: store}, HighMemoryBytes: 1}, ggcache.New())
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// Any heap is above a byte.
+	ctx := context.Background()
+	value := bytes.Repeat([]byte("x"), 8<<10)
+	assert.Nil(t, c.Set(ctx, []byte("foo"), value, 0))
+	assert.Equal(t, proto.HealthHighMemory, c.Health())
+
+	store.full.Store(true)
+	_, err = c.Backup(ctx)
+	assert.NotNil(t, err)
+	s.refreshHealth()
+	assert.Equal(t, proto.HealthReadOnly|proto.HealthHighMemory, s.Health())
+
+	// The values written without a copy carry it as well.
+	got, err := c.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, value, got)
+	assert.Equal(t, proto.HealthReadOnly|proto.HealthHighMemory, c.Health())
+
+	store.full.Store(false)
+	_, err = c.Backup(ctx)
+	assert.Nil(t, err)
+	s.refreshHealth()
+	assert.Nil(t, c.Ping(ctx))
+	assert.Equal(t, proto.HealthHighMemory, c.Health())
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// historyInterval is the resolution of the stats history.
+const historyInterval = time.Minute
+
+// StatsSample is the state of the STATS counters and gauges at a point in
+// time, along with the number of commands served by name.
+type StatsSample struct {
+	Time     time.Time         `json:"time"`
+	Stats    map[string]int64  `json:"stats"`
+	Commands map[string]uint64 `json:"commands"`
+}
+
+// statsHistory is a ring buffer of the samples taken over the retention.
+type statsHistory struct {
+	mu      sync.Mutex
+	samples []StatsSample
+	// next is the index the next sample is written to, and full reports
+	// whether the buffer has wrapped around.
+	next int
+	full bool
+}
+
+func (h *statsHistory) add(sample StatsSample, capacity int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.samples == nil {
+		h.samples = make([]StatsSample, capacity)
+	}
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// since returns the samples taken at or after t, oldest first.
+func (h *statsHistory) since(t time.Time) []StatsSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ordered := h.samples[:h.next]
+	if h.full {
+		ordered = append(append([]StatsSample(nil), h.samples[h.next:]...), h.samples[:h.next]...)
+	}
+
+	samples := []StatsSample{}
+	for _, sample := range ordered {
+		if !sample.Time.Before(t) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// historyLoop samples the stats every historyInterval until the server is
+// closed.
+func (s *Server) historyLoop() {
+	ticker := time.NewTicker(historyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case now := <-ticker.C:
+			s.recordStats(now)
+		}
+	}
+}
+
+// recordStats adds a sample of the current stats to the history.
+func (s *Server) recordStats(now time.Time) {
+	sample := StatsSample{
+		Time:     now.UTC().Truncate(time.Second),
+		Stats:    make(map[string]int64),
+		Commands: make(map[string]uint64),
+	}
+	for _, stat := range s.Stats() {
+		sample.Stats[stat.Name] = stat.Value
+	}
+	for name, h := range s.CommandLatencies() {
+		sample.Commands[name] = h.Count
+	}
+
+	capacity := int(s.StatsRetention / historyInterval)
+	if capacity < 1 {
+		capacity = 1
+	}
+	s.history.add(sample, capacity)
+}
+
+// StatsHistory returns the stats samples taken at or after since, oldest
+// first. It is empty unless StatsRetention is set.
+func (s *Server) StatsHistory(since time.Time) []StatsSample {
+	return s.history.since(since)
+}
+
+// handleStatsHistoryAPI serves the history on /api/v1/stats/history. The
+// since parameter limits it to the samples taken after a RFC 3339 time or
+// within a duration such as 90m; it defaults to the whole retention.
+func (s *Server) handleStatsHistoryAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since time.Time
+	if param := r.URL.Query().Get("since"); len(param) != 0 {
+		if d, err := time.ParseDuration(param); err == nil {
+			since = time.Now().Add(-d)
+		} else if since, err = time.Parse(time.RFC3339, param); err != nil {
+			http.Error(w, fmt.Sprintf("invalid since [%s]: expected a RFC 3339 time or a duration", param), http.StatusBadRequest)
+			return
+		}
+	}
+
+	b, err := json.Marshal(struct {
+		IntervalSeconds int           `json:"interval_seconds"`
+		Samples         []StatsSample `json:"samples"`
+	}{
+		IntervalSeconds: int(historyInterval.Seconds()),
+		Samples:         s.StatsHistory(since),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(b)
+}
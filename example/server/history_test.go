@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsHistory(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, StatsRetention: 3 * time.Minute}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	start := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, c.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+		s.recordStats(start.Add(time.Duration(i) * time.Minute))
+	}
+
+	// Only the last three minutes are retained.
+	samples := s.StatsHistory(time.Time{})
+	assert.Len(t, samples, 3)
+	assert.Equal(t, start.Add(2*time.Minute), samples[0].Time)
+	assert.Equal(t, int64(5), samples[2].Stats["cache_sets_total"])
+
+	rec := httptest.NewRecorder()
+	target := "/api/v1/stats/history?since=" + start.Add(3*time.Minute).Format(time.RFC3339)
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var history struct {
+		IntervalSeconds int           `json:"interval_seconds"`
+		Samples         []StatsSample `json:"samples"`
+	}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	assert.Equal(t, 60, history.IntervalSeconds)
+	assert.Len(t, history.Samples, 2)
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stats/history?since=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
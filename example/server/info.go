@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net"
+	"runtime/debug"
+	"sort"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// Version and Commit identify the build of the server, reported by INFO and
+// the stats API. They are set at link time, e.g.
+//
+//	go build -ldflags "-X github.com/anthdm/ggcache/example/server.Version=v1.4.0 -X github.com/anthdm/ggcache/example/server.Commit=3f2a9c1"
+//
+// Commit defaults to the VCS revision the go command stamps the binary with.
+var (
+	Version = "dev"
+	Commit  string
+)
+
+// buildCommit returns Commit, or the VCS revision of the binary if it is
+// not set.
+func buildCommit() string {
+	if len(Commit) != 0 {
+		return Commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// Capabilities returns the optional features enabled on the server, sorted,
+// as listed by INFO.
+func (s *Server) Capabilities() []string {
+	var caps []string
+	if s.tenants.auth != nil {
+		caps = append(caps, proto.CapabilityAuth)
+	}
+	if _, ok := s.cache.(ggcache.PersistenceChecker); ok || s.Backups != nil {
+		caps = append(caps, proto.CapabilityPersistence)
+	}
+	if s.FullSync {
+		caps = append(caps, proto.CapabilityFullSync)
+	}
+	if _, ok := s.cache.(ggcache.Scanner); ok {
+		caps = append(caps, proto.CapabilityScan)
+	}
+	if _, ok := s.cache.(ggcache.MultiWriter); ok {
+		caps = append(caps, proto.CapabilityMulti)
+	}
+	if s.DeleteRetention > 0 {
+		caps = append(caps, proto.CapabilityUndelete)
+	}
+	sort.Strings(caps)
+	return caps
+}
+
+func (s *Server) handleInfoCommand(conn net.Conn, _ *proto.CommandInfo) error {
+	return proto.WriteMessage(conn, &proto.ResponseInfo{
+		Status:       proto.StatusOK,
+		Version:      Version,
+		Commit:       buildCommit(),
+		Capabilities: s.Capabilities(),
+	})
+}
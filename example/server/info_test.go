@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfo(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, DeleteRetention: 1}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	info, err := c.Info(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, Version, info.Version)
+	assert.Equal(t, []string{proto.CapabilityMulti, proto.CapabilityScan, proto.CapabilityUndelete}, info.Capabilities)
+	assert.False(t, info.Has(proto.CapabilityAuth))
+
+	version, err := c.ServerVersion(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "dev", version)
+
+	report := s.StatsReport()
+	assert.Equal(t, info.Capabilities, report.Node.Capabilities)
+}
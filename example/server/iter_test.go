@@ -0,0 +1,47 @@
+//go:build go1.23
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanIter(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < DefaultScanKeys+10; i++ {
+		assert.Nil(t, c.Set(ctx, []byte(fmt.Sprintf("key:%04d", i)), []byte("x"), 0))
+	}
+
+	n := 0
+	for key, err := range c.ScanIter(ctx) {
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("key:%04d", n), string(key))
+		n++
+	}
+	assert.Equal(t, DefaultScanKeys+10, n)
+
+	// Breaking out of the loop stops it, with a page left unread.
+	n = 0
+	for range c.ScanIter(ctx) {
+		if n++; n == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 3, n)
+	assert.Nil(t, c.Set(ctx, []byte("after"), []byte("break"), 0))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for _, err := range c.ScanIter(cancelled) {
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+}
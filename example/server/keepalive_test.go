@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPing(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	assert.Nil(t, c.Ping(context.Background()))
+
+	// A node that does not answer fails the ping at its deadline.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			if _, err := ln.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	dead, err := client.New(ln.Addr().String(), client.Options{})
+	assert.Nil(t, err)
+	defer dead.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, dead.Ping(ctx), client.ErrTimeout)
+}
+
+func TestKeepAlive(t *testing.T) {
+	opts := client.Options{KeepAlive: 20 * time.Millisecond, PingTimeout: 20 * time.Millisecond}
+
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// The idle connection to a live peer is kept.
+	peers := client.NewPeers("self", []string{s.Addr().String()}, opts)
+	defer peers.Close()
+	live, ok := peers.PickPeer([]byte("foo"))
+	assert.True(t, ok)
+	time.Sleep(100 * time.Millisecond)
+	again, _ := peers.PickPeer([]byte("foo"))
+	assert.Equal(t, live, again)
+
+	// The one to a peer that stopped answering is dropped, so the next pick
+	// dials it again.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			if _, err := ln.Accept(); err != nil {
+				return
+			}
+			accepted.Add(1)
+		}
+	}()
+
+	peers = client.NewPeers("self", []string{ln.Addr().String()}, opts)
+	defer peers.Close()
+	dead, ok := peers.PickPeer([]byte("foo"))
+	assert.True(t, ok)
+	assert.Eventually(t, func() bool {
+		redialed, _ := peers.PickPeer([]byte("foo"))
+		return redialed != dead
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return accepted.Load() == 2
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	s, raw, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer raw.Close()
+
+	addr := s.Addr().String()
+	_, err = client.New(addr, client.Options{KeyPrefix: "{app}:"})
+	assert.NotNil(t, err)
+
+	app1, err := client.New(addr, client.Options{KeyPrefix: "app1:"})
+	assert.Nil(t, err)
+	defer app1.Close()
+	app2, err := client.New(addr, client.Options{KeyPrefix: "app2:"})
+	assert.Nil(t, err)
+	defer app2.Close()
+
+	assert.Equal(t, []byte("user:1:cart"), client.Key("user", "1", "cart"))
+	assert.Equal(t, []byte("{user:1}:cart"), client.TaggedKey("user:1", "cart"))
+
+	// The keys of each app are stored under its prefix.
+	ctx := context.Background()
+	key := client.Key("user", "1")
+	assert.Nil(t, app1.Set(ctx, key, []byte("a"), 0))
+	assert.Nil(t, app2.Set(ctx, key, []byte("b"), 0))
+	value, err := app1.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+	value, err = raw.Get(ctx, []byte("app2:user:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), value)
+	assert.Equal(t, []byte("user:1"), key)
+
+	// The commands of the caller are not changed, so they can be sent again.
+	cmds := []proto.Appender{
+		&proto.CommandSet{Key: client.TaggedKey("user:2", "profile"), Value: []byte("p")},
+		&proto.CommandSet{Key: client.TaggedKey("user:2", "cart"), Value: []byte("c")},
+	}
+	assert.Nil(t, app1.Multi(ctx, cmds))
+	assert.Equal(t, []byte("{user:2}:profile"), cmds[0].(*proto.CommandSet).Key)
+	assert.Nil(t, app2.Multi(ctx, cmds))
+
+	// Scan only sees the keys of the app, without the prefix.
+	keys, err := app1.Scan(ctx, "*", nil, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user:1"), []byte("{user:2}:cart"), []byte("{user:2}:profile")}, keys)
+	keys, err = app1.Scan(ctx, "*", []byte("user:1"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("{user:2}:cart"), []byte("{user:2}:profile")}, keys)
+
+	// Flush only deletes the keys of the app.
+	n, err := app1.Flush(ctx, "*", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	_, err = app1.Get(ctx, key)
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+	value, err = app2.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), value)
+}
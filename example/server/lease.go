@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// DefaultLeaseTTL is how long a lease is held if LeaseTTL is not set.
+const DefaultLeaseTTL = 10 * time.Second
+
+// leaseSweepEvery is the number of grants between sweeps of the expired
+// leases of keys nobody asked for again.
+const leaseSweepEvery = 1024
+
+type lease struct {
+	token     uint64
+	expiresAt time.Time
+}
+
+// leaseTable holds the leases granted on GETLEASE misses. A key has at most
+// one lease; writing or deleting the key by other means invalidates it, so a
+// client filling it from a stale read cannot overwrite a newer value.
+type leaseTable struct {
+	mu     sync.Mutex
+	leases map[string]lease
+	next   uint64
+	grants int
+
+	// granted and held count the leases granted and the requests told to
+	// retry because another client held the lease.
+	granted atomic.Uint64
+	held    atomic.Uint64
+}
+
+// acquire grants a lease on the key unless an unexpired one is held. It
+// returns the token of the new lease, or zero if one is held.
+func (t *leaseTable) acquire(key []byte, now time.Time, ttl time.Duration) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l, ok := t.leases[string(key)]; ok && now.Before(l.expiresAt) {
+		t.held.Add(1)
+		return 0
+	}
+
+	if t.leases == nil {
+		t.leases = make(map[string]lease)
+		// Tokens start at a random point so they are not reused across
+		// restarts.
+		var b [8]byte
+		_, _ = rand.Read(b[:])
+		t.next = binary.LittleEndian.Uint64(b[:]) >> 1
+	}
+	if t.grants++; t.grants%leaseSweepEvery == 0 {
+		for k, l := range t.leases {
+			if !now.Before(l.expiresAt) {
+				delete(t.leases, k)
+			}
+		}
+	}
+
+	t.next++
+	if t.next == 0 {
+		t.next++
+	}
+	t.leases[string(key)] = lease{token: t.next, expiresAt: now.Add(ttl)}
+	t.granted.Add(1)
+	return t.next
+}
+
+// release reports whether the token is the unexpired lease of the key, and
+// drops the lease.
+func (t *leaseTable) release(key []byte, token uint64, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.leases[string(key)]
+	if !ok || l.token != token {
+		return false
+	}
+	delete(t.leases, string(key))
+	return now.Before(l.expiresAt)
+}
+
+// invalidate drops the lease of the key, if any.
+func (t *leaseTable) invalidate(key []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.leases, string(key))
+}
+
+func (s *Server) leaseTTL() time.Duration {
+	if s.LeaseTTL > 0 {
+		return s.LeaseTTL
+	}
+	return DefaultLeaseTTL
+}
+
+func (s *Server) handleGetLeaseCommand(conn net.Conn, cmd *proto.CommandGetLease) error {
+	resp := proto.ResponseGetLease{}
+	value, err := s.cache.Get(cmd.Key)
+	s.countNamespace(cmd.Key, func(ns *NamespaceStats) {
+		if err != nil {
+			ns.Misses++
+		} else {
+			ns.Hits++
+		}
+	})
+	if err == nil {
+		resp.Status = proto.StatusOK
+		resp.Value = value
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	if resp.Token = s.leases.acquire(cmd.Key, time.Now(), s.leaseTTL()); resp.Token == 0 {
+		resp.Status = proto.StatusLeaseHeld
+	} else {
+		resp.Status = proto.StatusLeaseGranted
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+func (s *Server) handleSetLeaseCommand(conn net.Conn, cmd *proto.CommandSetLease) error {
+	resp := proto.ResponseSet{}
+	if !s.leases.release(cmd.Key, cmd.Token, time.Now()) {
+		resp.Status = proto.StatusLeaseInvalid
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	err := s.set(cmd.Key, cmd.Value, time.Duration(cmd.TTL)*time.Millisecond)
+	switch {
+	case errors.Is(err, errInvalidValue):
+		resp.Status = proto.StatusInvalidValue
+		return proto.WriteMessage(conn, &resp)
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+		return proto.WriteMessage(conn, &resp)
+	case err != nil:
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	resp.Status = proto.StatusOK
+	return proto.WriteMessage(conn, &resp)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeases(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, LeaseTTL: 50 * time.Millisecond}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	other, err := client.New(s.Addr().String(), client.Options{})
+	assert.Nil(t, err)
+	defer other.Close()
+
+	ctx := context.Background()
+	key := []byte("foo")
+
+	// The first client to miss gets the lease, the others wait for it.
+	_, token, err := c.GetLease(ctx, key)
+	assert.Nil(t, err)
+	assert.NotZero(t, token)
+	_, _, err = other.GetLease(ctx, key)
+	assert.Equal(t, client.ErrLeaseHeld, err)
+
+	assert.Equal(t, client.ErrLeaseInvalid, other.SetLease(ctx, key, []byte("bar"), 0, token+1))
+	assert.Nil(t, c.SetLease(ctx, key, []byte("bar"), 0, token))
+	assert.Equal(t, client.ErrLeaseInvalid, c.SetLease(ctx, key, []byte("bar"), 0, token))
+
+	value, token, err := other.GetLease(ctx, key)
+	assert.Nil(t, err)
+	assert.Zero(t, token)
+	assert.Equal(t, []byte("bar"), value)
+
+	// A write by other means invalidates the lease.
+	assert.Nil(t, c.Delete(ctx, key))
+	_, token, err = c.GetLease(ctx, key)
+	assert.Nil(t, err)
+	assert.Nil(t, other.Set(ctx, key, []byte("newer"), 0))
+	assert.Equal(t, client.ErrLeaseInvalid, c.SetLease(ctx, key, []byte("stale"), 0, token))
+	value, err = c.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("newer"), value)
+
+	// An expired lease is granted to the next client.
+	_, token, err = c.GetLease(ctx, []byte("baz"))
+	assert.Nil(t, err)
+	time.Sleep(60 * time.Millisecond)
+	_, otherToken, err := other.GetLease(ctx, []byte("baz"))
+	assert.Nil(t, err)
+	assert.NotZero(t, otherToken)
+	assert.Equal(t, client.ErrLeaseInvalid, c.SetLease(ctx, []byte("baz"), []byte("late"), 0, token))
+	assert.Nil(t, other.SetLease(ctx, []byte("baz"), []byte("filled"), 0, otherToken))
+
+	assert.Equal(t, uint64(4), s.leases.granted.Load())
+	assert.Equal(t, uint64(1), s.leases.held.Load())
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/cache/dense"
+)
+
+const (
+	// defaultMemorySamples and maxMemorySamples are the default and largest
+	// number of entries sampled by the memory doctor.
+	defaultMemorySamples = 1000
+	maxMemorySamples     = 100000
+
+	// defaultMemoryTop is the default number of largest keys reported.
+	defaultMemoryTop = 10
+)
+
+// memorySizeBounds are the upper bounds of the entry size histogram buckets.
+var memorySizeBounds = []int{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// KeyUsage is the memory taken by a key and its value.
+type KeyUsage struct {
+	Key   string `json:"key"`
+	Bytes int    `json:"bytes"`
+}
+
+// PrefixUsage is the memory taken by the sampled keys of a namespace. Counts
+// has one more element than MemoryReport.BoundsBytes, the last one counting
+// the entries above.
+type PrefixUsage struct {
+	Keys   int   `json:"keys"`
+	Bytes  int   `json:"bytes"`
+	Counts []int `json:"counts"`
+	// EstimatedBytes extrapolates Bytes to the whole cache, zero if the
+	// Cacher does not implement ggcache.StatsProvider.
+	EstimatedBytes int64 `json:"estimated_bytes,omitempty"`
+}
+
+// MemoryReport is what a sample of the cache says about its memory: the
+// largest keys sampled and the size distribution of the entries of each
+// namespace.
+type MemoryReport struct {
+	// Sampled is the number of entries sampled out of Keys, which is zero if
+	// the Cacher does not implement ggcache.StatsProvider.
+	Sampled     int                    `json:"sampled"`
+	Keys        int                    `json:"keys,omitempty"`
+	Largest     []KeyUsage             `json:"largest"`
+	BoundsBytes []int                  `json:"bounds_bytes"`
+	Prefixes    map[string]PrefixUsage `json:"prefixes"`
+	// Slab is set if the Cacher stores its entries in a slab, as the dense
+	// engine does.
+	Slab *SlabReport `json:"slab,omitempty"`
+}
+
+// SlabReport is the occupancy of the slab and table of the dense engine.
+// Fragmentation is the share of the slab taken by garbage, which the next
+// compaction reclaims, and Load the share of the table slots in use.
+type SlabReport struct {
+	Bytes         int     `json:"bytes"`
+	GarbageBytes  int     `json:"garbage_bytes"`
+	CapacityBytes int     `json:"capacity_bytes"`
+	Fragmentation float64 `json:"fragmentation"`
+	Compactions   uint64  `json:"compactions"`
+	Slots         int     `json:"slots"`
+	Tombstones    int     `json:"tombstones"`
+	Load          float64 `json:"load"`
+}
+
+// MemoryUsage returns the bytes taken by the key and its value, and false if
+// the key is not found.
+func (s *Server) MemoryUsage(key []byte) (int, bool) {
+	value, err := s.cache.Get(key)
+	if err != nil {
+		return 0, false
+	}
+	return len(key) + len(value), true
+}
+
+// MemoryReport samples up to samples entries of the cache and reports the
+// top largest of them along with the size histogram of each namespace. It
+// returns false if the Cacher does not implement ggcache.Sampler.
+func (s *Server) MemoryReport(samples, top int) (MemoryReport, bool) {
+	sampler, ok := s.cache.(ggcache.Sampler)
+	if !ok {
+		return MemoryReport{}, false
+	}
+
+	report := MemoryReport{
+		BoundsBytes: memorySizeBounds,
+		Prefixes:    make(map[string]PrefixUsage),
+	}
+	sampled := []KeyUsage{}
+	sampler.Sample(samples, func(key []byte, size int) {
+		size += len(key)
+		sampled = append(sampled, KeyUsage{Key: string(key), Bytes: size})
+
+		prefix := s.prefix(key)
+		usage := report.Prefixes[prefix]
+		if usage.Counts == nil {
+			usage.Counts = make([]int, len(memorySizeBounds)+1)
+		}
+		usage.Keys++
+		usage.Bytes += size
+		usage.Counts[sort.SearchInts(memorySizeBounds, size)]++
+		report.Prefixes[prefix] = usage
+	})
+	report.Sampled = len(sampled)
+
+	sort.Slice(sampled, func(i, j int) bool { return sampled[i].Bytes > sampled[j].Bytes })
+	report.Largest = sampled[:min(top, len(sampled))]
+
+	if p, ok := s.cache.(ggcache.StatsProvider); ok && report.Sampled > 0 {
+		report.Keys = p.Stats().Keys
+		for prefix, usage := range report.Prefixes {
+			usage.EstimatedBytes = int64(usage.Bytes) * int64(report.Keys) / int64(report.Sampled)
+			report.Prefixes[prefix] = usage
+		}
+	}
+	if p, ok := s.cache.(interface{ SlabStats() dense.SlabStats }); ok {
+		ss := p.SlabStats()
+		report.Slab = &SlabReport{
+			Bytes:         ss.Bytes,
+			GarbageBytes:  ss.Garbage,
+			CapacityBytes: ss.Capacity,
+			Fragmentation: ss.Fragmentation(),
+			Compactions:   ss.Compactions,
+			Slots:         ss.Slots,
+			Tombstones:    ss.Tombstones,
+			Load:          float64(ss.Used) / float64(ss.Slots),
+		}
+	}
+	return report, true
+}
+
+// handleMemoryUsageAPI serves the memory taken by the key parameter on
+// /api/v1/memory/usage.
+func (s *Server) handleMemoryUsageAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	size, ok := s.MemoryUsage([]byte(key))
+	if !ok {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, KeyUsage{Key: key, Bytes: size})
+}
+
+// handleMemoryDoctorAPI serves the MemoryReport on /api/v1/memory/doctor.
+// The samples and top parameters default to 1000 and 10.
+func (s *Server) handleMemoryDoctorAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	samples, err := intParam(r, "samples", defaultMemorySamples)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	top, err := intParam(r, "top", defaultMemoryTop)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	samples = min(samples, maxMemorySamples)
+
+	report, ok := s.MemoryReport(samples, top)
+	if !ok {
+		http.Error(w, "the cache does not support sampling", http.StatusNotImplemented)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// intParam returns the non-negative integer query parameter name, or def if
+// it is not set.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	param := r.URL.Query().Get(name)
+	if len(param) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(param)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s [%s]", name, param)
+	}
+	return n, nil
+}
+
+// writeJSON writes v as an uncached JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(b)
+}
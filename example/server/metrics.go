@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBounds are the upper bounds, in seconds, of the buckets of the
+// command latency histograms.
+var latencyBounds = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Histogram is a snapshot of a cumulative latency histogram.
+type Histogram struct {
+	// Bounds are the upper bounds of the buckets in seconds. Counts has one
+	// more element than Bounds, the last one counting everything above.
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	// Sum of the observed latencies in seconds.
+	Sum float64
+}
+
+// commandMetrics records the latency of the commands by name.
+type commandMetrics struct {
+	mu       sync.Mutex
+	commands map[string]*Histogram
+}
+
+func (m *commandMetrics) observe(name string, d time.Duration) {
+	seconds := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.commands == nil {
+		m.commands = make(map[string]*Histogram)
+	}
+	h, ok := m.commands[name]
+	if !ok {
+		h = &Histogram{Bounds: latencyBounds, Counts: make([]uint64, len(latencyBounds)+1)}
+		m.commands[name] = h
+	}
+	h.Counts[sort.SearchFloat64s(latencyBounds, seconds)]++
+	h.Count++
+	h.Sum += seconds
+}
+
+// CommandLatencies returns the latency histograms of the commands served
+// since the server started, keyed by lowercase command name.
+func (s *Server) CommandLatencies() map[string]Histogram {
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+
+	latencies := make(map[string]Histogram, len(s.metrics.commands))
+	for name, h := range s.metrics.commands {
+		c := *h
+		c.Counts = append([]uint64(nil), h.Counts...)
+		latencies[name] = c
+	}
+	return latencies
+}
+
+// maxNamespaces bounds the number of namespaces tracked, further ones are
+// counted under otherNamespace.
+const maxNamespaces = 256
+
+const (
+	defaultNamespace = "_default"
+	otherNamespace   = "_other"
+)
+
+// NamespaceStats are the commands served for the keys of a namespace.
+type NamespaceStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Sets    uint64 `json:"sets"`
+	Deletes uint64 `json:"deletes"`
+	// TTLClamped counts the writes whose TTL the cache corrected to the
+	// bounds of the namespace, as ggcache.NamespacedCache does.
+	TTLClamped uint64 `json:"ttl_clamped,omitempty"`
+}
+
+// ttlClamper is implemented by the caches correcting the TTLs of the writes
+// to the bounds of their namespace, such as ggcache.NamespacedCache.
+type ttlClamper interface {
+	ClampedTTLs() map[string]uint64
+}
+
+// sizeBounds are the upper bounds, in bytes, of the buckets of the value
+// size histograms, growing fourfold from 64B to 16MiB.
+var sizeBounds = []int{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+// SizeHistogram is a snapshot of a cumulative histogram of the sizes of the
+// values written.
+type SizeHistogram struct {
+	// Bounds are the upper bounds of the buckets in bytes. Counts has one
+	// more element than Bounds, the last one counting everything above.
+	Bounds []int
+	Counts []uint64
+	Count  uint64
+	// Sum and Max of the observed sizes in bytes.
+	Sum uint64
+	Max int
+}
+
+func (h *SizeHistogram) observe(size int) {
+	if h.Counts == nil {
+		h.Bounds = sizeBounds
+		h.Counts = make([]uint64, len(sizeBounds)+1)
+	}
+	h.Counts[sort.SearchInts(sizeBounds, size)]++
+	h.Count++
+	h.Sum += uint64(size)
+	h.Max = max(h.Max, size)
+}
+
+// copy returns a copy of h that does not share its counts.
+func (h *SizeHistogram) copy() SizeHistogram {
+	c := *h
+	c.Bounds = sizeBounds
+	c.Counts = make([]uint64, len(sizeBounds)+1)
+	copy(c.Counts, h.Counts)
+	return c
+}
+
+// namespaceMetrics counts the commands by key namespace, and records the
+// sizes of the values written to each and overall.
+type namespaceMetrics struct {
+	mu         sync.Mutex
+	namespaces map[string]*namespaceEntry
+	sizes      SizeHistogram
+}
+
+type namespaceEntry struct {
+	stats NamespaceStats
+	sizes SizeHistogram
+}
+
+// namespace returns the metrics of the namespace the key belongs to, or nil
+// if NamespaceSeparator is not set. The caller must hold s.namespaces.mu.
+func (s *Server) namespace(key []byte) *namespaceEntry {
+	if len(s.NamespaceSeparator) == 0 {
+		return nil
+	}
+
+	name := defaultNamespace
+	if i := bytes.Index(key, []byte(s.NamespaceSeparator)); i > 0 {
+		name = string(key[:i])
+	}
+
+	m := &s.namespaces
+	if m.namespaces == nil {
+		m.namespaces = make(map[string]*namespaceEntry)
+	}
+	ns, ok := m.namespaces[name]
+	if !ok {
+		if len(m.namespaces) >= maxNamespaces {
+			name = otherNamespace
+			if ns, ok = m.namespaces[name]; ok {
+				return ns
+			}
+		}
+		ns = &namespaceEntry{}
+		m.namespaces[name] = ns
+	}
+	return ns
+}
+
+// countNamespace applies fn to the stats of the namespace of the key.
+func (s *Server) countNamespace(key []byte, fn func(ns *NamespaceStats)) {
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+
+	if ns := s.namespace(key); ns != nil {
+		fn(&ns.stats)
+	}
+}
+
+// observeValueSize records the size of a value written to the key, overall
+// and in its namespace.
+func (s *Server) observeValueSize(key []byte, size int) {
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+
+	s.namespaces.sizes.observe(size)
+	if ns := s.namespace(key); ns != nil {
+		ns.sizes.observe(size)
+	}
+}
+
+// NamespaceStats returns the commands served per key namespace, empty unless
+// NamespaceSeparator is set, along with the TTLs the cache clamped in the
+// namespaces it bounds them in.
+func (s *Server) NamespaceStats() map[string]NamespaceStats {
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+
+	stats := make(map[string]NamespaceStats, len(s.namespaces.namespaces))
+	for name, ns := range s.namespaces.namespaces {
+		stats[name] = ns.stats
+	}
+	if p, ok := s.cache.(ttlClamper); ok {
+		for name, n := range p.ClampedTTLs() {
+			ns := stats[name]
+			ns.TTLClamped = n
+			stats[name] = ns
+		}
+	}
+	return stats
+}
+
+// ValueSizes returns the histogram of the sizes of the values written since
+// the server started, and those of each key namespace, empty unless
+// NamespaceSeparator is set.
+func (s *Server) ValueSizes() (SizeHistogram, map[string]SizeHistogram) {
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+
+	namespaces := make(map[string]SizeHistogram, len(s.namespaces.namespaces))
+	for name, ns := range s.namespaces.namespaces {
+		if ns.sizes.Count != 0 {
+			namespaces[name] = ns.sizes.copy()
+		}
+	}
+	return s.namespaces.sizes.copy(), namespaces
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// mgetCheckKeys is the number of keys an MGET reads between checks of its
+// budget and deadline.
+const mgetCheckKeys = 64
+
+func (s *Server) handleMGetCommand(ctx context.Context, conn net.Conn, cmd *proto.CommandMGet) error {
+	resp := proto.ResponseMGet{Status: proto.StatusOK}
+	values, truncated, err := s.mget(ctx, cmd.Keys)
+	if err != nil {
+		resp.Status = proto.StatusError
+		if s.inflight.expire(ctx, err) {
+			resp.Status = expiredStatus(ctx)
+		}
+		return proto.WriteMessage(conn, &resp)
+	}
+	resp.Values, resp.Truncated = values, truncated
+	return proto.WriteMessage(conn, &resp)
+}
+
+// mget returns the values of the keys in order, nil for the misses. Once
+// MGetBudget is spent it returns the values read so far and true. It stops
+// with the error of ctx once ctx is done.
+func (s *Server) mget(ctx context.Context, keys [][]byte) ([][]byte, bool, error) {
+	// The values are held until all of them are read, so they are copied
+	// unless the engine never modifies them.
+	_, stable := s.cache.(ggcache.StableValues)
+
+	start := time.Now()
+	values := make([][]byte, 0, len(keys))
+	for i, key := range keys {
+		if i != 0 && i%mgetCheckKeys == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, false, err
+			}
+			if s.MGetBudget > 0 && time.Since(start) >= s.MGetBudget {
+				return values, true, nil
+			}
+		}
+
+		value, err := s.cache.Get(key)
+		s.countNamespace(key, func(ns *NamespaceStats) {
+			if err != nil {
+				ns.Misses++
+			} else {
+				ns.Hits++
+			}
+		})
+		switch {
+		case err != nil:
+			value = nil
+		case !stable:
+			value = bytes.Clone(value)
+		}
+		if err == nil && value == nil {
+			// A nil value would read as a miss.
+			value = []byte{}
+		}
+		values = append(values, value)
+	}
+	return values, false, nil
+}
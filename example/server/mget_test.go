@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMGet(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("1"), 0))
+	assert.Nil(t, c.Set(ctx, []byte("empty"), []byte{}, 0))
+
+	values, truncated, err := c.MGet(ctx, [][]byte{[]byte("foo"), []byte("missing"), []byte("empty")})
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, [][]byte{[]byte("1"), nil, {}}, values)
+}
+
+func TestMGetBudget(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, MGetBudget: time.Nanosecond}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	keys := make([][]byte, 3*mgetCheckKeys)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key:%03d", i))
+		assert.Nil(t, c.Set(ctx, keys[i], keys[i], 0))
+	}
+
+	// The budget is spent by the first check, so only the keys read before
+	// it are returned.
+	values, truncated, err := c.MGet(ctx, keys)
+	assert.Nil(t, err)
+	assert.True(t, truncated)
+	assert.Len(t, values, mgetCheckKeys)
+	for i, value := range values {
+		assert.Equal(t, keys[i], value)
+	}
+
+	// The rest is read by the next MGETs.
+	values, _, err = c.MGet(ctx, keys[len(values):])
+	assert.Nil(t, err)
+	assert.Equal(t, keys[mgetCheckKeys], values[0])
+}
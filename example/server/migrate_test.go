@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigration(t *testing.T) {
+	old := ggcache.New()
+	assert.Nil(t, old.Set([]byte("user:1"), []byte("alice"), 0))
+	assert.Nil(t, old.Set([]byte("user:2"), []byte("bob"), 0))
+
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	m := client.NewMigration(client.CacherStore(old), c, client.MigrationOptions{
+		BackfillTTL:  time.Hour,
+		CompareEvery: 1,
+	})
+	ctx := context.Background()
+
+	// A key the new side misses is read from the old one and backfilled.
+	value, err := m.Get(ctx, []byte("user:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("alice"), value)
+	value, err = c.Get(ctx, []byte("user:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("alice"), value)
+
+	// The writes go to both sides.
+	assert.Nil(t, m.Set(ctx, []byte("user:3"), []byte("carol"), 0))
+	assert.True(t, old.Has([]byte("user:3")))
+	assert.Nil(t, m.Delete(ctx, []byte("user:1")))
+	assert.False(t, old.Has([]byte("user:1")))
+	_, err = m.Get(ctx, []byte("user:1"))
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+
+	// The reads served by the new side are compared with the old one.
+	assert.Nil(t, c.Set(ctx, []byte("user:2"), []byte("robert"), 0))
+	value, err = m.Get(ctx, []byte("user:2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("robert"), value)
+
+	// A write the new side fails is kept on the old one, and counted.
+	assert.Nil(t, c.Close())
+	assert.Nil(t, m.Set(ctx, []byte("user:4"), []byte("dave"), 0))
+	assert.True(t, old.Has([]byte("user:4")))
+	value, err = m.Get(ctx, []byte("user:4"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("dave"), value)
+
+	assert.Equal(t, client.MigrationStats{
+		Reads:     4,
+		Fallbacks: 2,
+		Backfills: 1,
+		Compared:  1,
+		Differed:  1,
+		Writes:    3,
+		Diverged:  1,
+		NewErrors: 3,
+	}, m.Stats())
+}
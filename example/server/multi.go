@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+var (
+	// errNoMulti is returned by multi if the cache is not a
+	// ggcache.MultiWriter.
+	errNoMulti = errors.New("the cache does not support atomic writes")
+
+	// errCrossSlot is returned by multi if the keys do not all have the same
+	// hash tag.
+	errCrossSlot = errors.New("the keys of the multi do not have the same hash tag")
+)
+
+func (s *Server) handleMultiCommand(conn net.Conn, cmd *proto.CommandMulti) error {
+	resp := proto.ResponseBatch{Status: proto.StatusOK}
+	err := s.multi(cmd)
+	switch {
+	case errors.Is(err, errCrossSlot):
+		resp.Status = proto.StatusCrossSlot
+	case errors.Is(err, errInvalidValue):
+		resp.Status = proto.StatusInvalidValue
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+	case err != nil:
+		resp.Status = proto.StatusError
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+// multi applies the SETs and DELs of the command atomically, forwards it to
+// the members as a whole and publishes the events. Nothing is written unless
+// the keys have the same hash tag and every value is valid.
+func (s *Server) multi(cmd *proto.CommandMulti) error {
+	mw, ok := s.cache.(ggcache.MultiWriter)
+	if !ok {
+		return errNoMulti
+	}
+
+	var tag []byte
+	writes := make([]ggcache.Write, 0, len(cmd.Commands))
+	for i, c := range cmd.Commands {
+		var w ggcache.Write
+		switch v := c.(type) {
+		case *proto.CommandSet:
+			if err := s.validate(v.Key, v.Value); err != nil {
+				return err
+			}
+			w = ggcache.Write{Key: v.Key, Value: v.Value, TTL: time.Duration(v.TTL) * time.Millisecond}
+		case *proto.CommandDel:
+			w = ggcache.Write{Key: v.Key, Delete: true}
+		}
+		if i == 0 {
+			tag = ggcache.HashTag(w.Key)
+		} else if !bytes.Equal(ggcache.HashTag(w.Key), tag) {
+			return errCrossSlot
+		}
+		writes = append(writes, w)
+	}
+
+	s.replicate(cmd)
+	for _, w := range writes {
+		s.leases.invalidate(w.Key)
+		if w.Delete {
+			s.countNamespace(w.Key, func(ns *NamespaceStats) { ns.Deletes++ })
+			s.tombstone(w.Key)
+			continue
+		}
+		s.countNamespace(w.Key, func(ns *NamespaceStats) { ns.Sets++ })
+		s.observeValueSize(w.Key, len(w.Value))
+	}
+
+	if err := mw.WriteMulti(writes); err != nil {
+		return err
+	}
+	for _, w := range writes {
+		if w.Delete {
+			s.events.publish(KeyspaceEvent{Op: "del", Key: w.Key})
+		} else {
+			s.events.publish(KeyspaceEvent{Op: "set", Key: w.Key, Value: w.Value})
+		}
+	}
+	return nil
+}
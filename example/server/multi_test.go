@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMulti(t *testing.T) {
+	leader, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("{user:1}:cart"), []byte("3 items"), 0))
+	err = c.Multi(ctx, []proto.Appender{
+		&proto.CommandSet{Key: []byte("{user:1}:order"), Value: []byte("3 items")},
+		&proto.CommandDel{Key: []byte("{user:1}:cart")},
+	})
+	assert.Nil(t, err)
+	_, err = c.Get(ctx, []byte("{user:1}:cart"))
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+	value, err := c.Get(ctx, []byte("{user:1}:order"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3 items"), value)
+
+	// The follower applies it as a whole.
+	assert.Eventually(t, func() bool {
+		return cache.Has([]byte("{user:1}:order")) && !cache.Has([]byte("{user:1}:cart"))
+	}, time.Second, 10*time.Millisecond)
+
+	// Keys of different hash tags are refused, by the client and the server.
+	cross := []proto.Appender{
+		&proto.CommandSet{Key: []byte("{user:1}:order"), Value: []byte("x")},
+		&proto.CommandSet{Key: []byte("{user:2}:order"), Value: []byte("x")},
+	}
+	assert.ErrorIs(t, c.Multi(ctx, cross), client.ErrCrossSlot)
+	assert.ErrorIs(t, leader.multi(&proto.CommandMulti{Commands: cross}), errCrossSlot)
+	assert.False(t, leader.cache.Has([]byte("{user:2}:order")))
+}
+
+func TestMultiInvalid(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader:           true,
+		NamespaceSeparator: ":",
+		Validators:         map[string]Validator{"json": JSONValidator{}},
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// Nothing is written if one of the values is invalid.
+	ctx := context.Background()
+	err = c.Multi(ctx, []proto.Appender{
+		&proto.CommandSet{Key: []byte("json:{a}"), Value: []byte(`{"ok":true}`)},
+		&proto.CommandSet{Key: []byte("json:{a}:bad"), Value: []byte("not json")},
+	})
+	assert.ErrorIs(t, err, client.ErrInvalidValue)
+	assert.False(t, s.cache.Has([]byte("json:{a}")))
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// errNoPrefixSnapshot is returned by ExportNamespace if the cache is not a
+// ggcache.PrefixSnapshotter.
+var errNoPrefixSnapshot = errors.New("the cache does not support namespace snapshots")
+
+// namespacePrefix returns the prefix of the keys of the namespace, which
+// are split at NamespaceSeparator, ":" if it is not set.
+func (s *Server) namespacePrefix(namespace string) []byte {
+	separator := s.NamespaceSeparator
+	if len(separator) == 0 {
+		separator = defaultTenantSeparator
+	}
+	return []byte(namespace + separator)
+}
+
+// ExportNamespace writes a snapshot of the keys of the namespace to w, in
+// the format of ggcache.Snapshotter, e.g. to move a tenant to another node
+// with ImportNamespace.
+func (s *Server) ExportNamespace(w io.Writer, namespace string) error {
+	ps, ok := s.cache.(ggcache.PrefixSnapshotter)
+	if !ok {
+		return errNoPrefixSnapshot
+	}
+	return ps.SnapshotPrefix(w, s.namespacePrefix(namespace))
+}
+
+// ImportNamespace stores the keys of the namespace found in the snapshot
+// read from r with the TTL they have left, and returns how many it stored.
+// The snapshot is verified before any key is stored. The keys of other
+// namespaces, and those that expired, are skipped, and the writes are
+// replicated like SETs, so it is meant for the leader. The keys of the
+// namespace missing from the snapshot are left alone.
+func (s *Server) ImportNamespace(r io.Reader, namespace string) (int, error) {
+	recs, err := ggcache.ReadSnapshot(r)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := s.namespacePrefix(namespace)
+	now := time.Now()
+	imported := 0
+	for _, rec := range recs {
+		if !bytes.HasPrefix(rec.Key, prefix) || rec.Flags&ggcache.RecordDeleted != 0 {
+			continue
+		}
+		ttl := rec.TTL(now)
+		if ttl < 0 {
+			continue
+		}
+		if err := s.set(rec.Key, rec.Value, ttl); err != nil {
+			return imported, fmt.Errorf("import [%s]: %w", rec.Key, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// handleNamespaceSnapshotAPI exports the namespace named by the namespace
+// query parameter on a GET, and imports the snapshot in the body into it on
+// a POST.
+func (s *Server) handleNamespaceSnapshotAPI(w http.ResponseWriter, r *http.Request) {
+	namespace := r.URL.Query().Get("namespace")
+	if len(namespace) == 0 {
+		http.Error(w, "missing namespace", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		buf := new(bytes.Buffer)
+		if err := s.ExportNamespace(buf, namespace); err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(buf.Bytes())
+	case http.MethodPost:
+		n, err := s.ImportNamespace(r.Body, namespace)
+		switch {
+		case errors.Is(err, ggcache.ErrInvalidSnapshot):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(struct {
+			Imported int `json:"imported"`
+		}{n})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
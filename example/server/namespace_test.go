@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceSnapshot(t *testing.T) {
+	src, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer src.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("users:1"), []byte("alice"), 0))
+	assert.Nil(t, c.Set(ctx, []byte("users:2"), []byte("bob"), time.Hour))
+	assert.Nil(t, c.Set(ctx, []byte("orders:1"), []byte("book"), 0))
+
+	rec := httptest.NewRecorder()
+	src.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/snapshot?namespace=users", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	snapshot := rec.Body.Bytes()
+
+	// The namespace is imported into another node, and replicated by it.
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	rec = httptest.NewRecorder()
+	leader.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/namespaces/snapshot?namespace=users", bytes.NewReader(snapshot)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp struct{ Imported int }
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Imported)
+
+	value, err := lc.Get(ctx, []byte("users:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("alice"), value)
+	_, err = lc.Get(ctx, []byte("orders:1"))
+	assert.ErrorIs(t, err, ggcache.ErrKeyNotFound)
+	assert.Eventually(t, func() bool {
+		return cache.Has([]byte("users:2"))
+	}, time.Second, 10*time.Millisecond)
+
+	// The keys of other namespaces in a snapshot are not imported.
+	n, err := leader.ImportNamespace(bytes.NewReader(snapshot), "orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	rec = httptest.NewRecorder()
+	leader.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/namespaces/snapshot?namespace=users", bytes.NewReader([]byte("garbage"))))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestNamespaceTTLBounds(t *testing.T) {
+	cache := ggcache.Namespaced(ggcache.New(), ggcache.NamespacedOptions{
+		Policies: map[string]ggcache.NamespacePolicy{
+			"pages": {MinTTL: time.Minute, MaxTTL: time.Hour},
+		},
+	})
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, NamespaceSeparator: ":"}, cache)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("pages:1"), []byte("a"), time.Millisecond))
+	assert.Nil(t, c.Set(ctx, []byte("pages:2"), []byte("a"), 0))
+	assert.Nil(t, c.Set(ctx, []byte("pages:3"), []byte("a"), 10*time.Minute))
+	assert.Nil(t, c.Set(ctx, []byte("users:1"), []byte("a"), time.Millisecond))
+
+	// The TTL of a millisecond was raised to a minute.
+	time.Sleep(5 * time.Millisecond)
+	value, err := c.Get(ctx, []byte("pages:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+
+	assert.Equal(t, uint64(2), s.NamespaceStats()["pages"].TTLClamped)
+	assert.Zero(t, s.NamespaceStats()["users"].TTLClamped)
+	assert.Contains(t, s.Stats(), proto.Stat{Name: "cache_ttl_clamped_total", Value: 2})
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// noReplyStats counts the writes carried by a CommandNoReply, and those of
+// them that failed, which nobody hears of otherwise.
+type noReplyStats struct {
+	total  atomic.Uint64
+	failed atomic.Uint64
+}
+
+// noReplyConn is the connection a command carried by a CommandNoReply is
+// answered on: it drops the responses, counting those with a failure status,
+// Redirects included.
+type noReplyConn struct {
+	net.Conn
+	stats *noReplyStats
+}
+
+func (c noReplyConn) Write(b []byte) (int, error) {
+	if len(b) != 0 && proto.Status(b[0]) != proto.StatusOK {
+		c.stats.failed.Add(1)
+	}
+	return len(b), nil
+}
+
+// noReply unwraps a CommandNoReply, returning the command it carries and the
+// connection to answer it on.
+func (s *Server) noReply(conn net.Conn, cmd any) (any, net.Conn) {
+	nr, ok := cmd.(*proto.CommandNoReply)
+	if !ok {
+		return cmd, conn
+	}
+	s.noReplies.total.Add(1)
+	return nr.Command, noReplyConn{Conn: conn, stats: &s.noReplies}
+}
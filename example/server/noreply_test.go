@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoReply(t *testing.T) {
+	leader, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, c.SetNoReply(ctx, []byte(fmt.Sprintf("metric:%d", i)), []byte("1"), 0))
+	}
+	assert.Nil(t, c.DeleteNoReply(ctx, []byte("metric:0")))
+
+	// Nothing is left to read on the connection once they are done.
+	assert.Eventually(t, func() bool {
+		return stat(leader, "server_noreply_total") == 101
+	}, time.Second, 10*time.Millisecond)
+	value, err := c.Get(ctx, []byte("metric:99"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+	_, err = c.Get(ctx, []byte("metric:0"))
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+	assert.Equal(t, int64(0), stat(leader, "server_noreply_failed_total"))
+
+	// A follower drops the redirect of a write sent to it, and counts it.
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String(), RedirectWrites: true}, nil)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Nil(t, fc.SetNoReply(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Eventually(t, func() bool {
+		return stat(follower, "server_noreply_failed_total") == 1
+	}, time.Second, 10*time.Millisecond)
+	_, err = fc.Get(ctx, []byte("foo"))
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OTLP pushes the server metrics to an OpenTelemetry collector with the
+// OTLP/HTTP protocol in its JSON encoding. The STATS counters are exported
+// as cumulative sums, the gauges as gauges and the command latencies as the
+// ggcache.command.duration histogram with a command attribute.
+type OTLP struct {
+	// Endpoint is the base URL of the collector, e.g. http://localhost:4318.
+	// Metrics are posted to its /v1/metrics path.
+	Endpoint string
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string
+	// Interval between exports, zero uses one minute.
+	Interval time.Duration
+	Client   *http.Client
+}
+
+// otlpLoop exports the metrics every OTLP.Interval until the server is closed.
+func (s *Server) otlpLoop() {
+	interval := s.OTLP.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case <-ticker.C:
+			if err := s.OTLP.Export(context.Background(), s); err != nil {
+				log.Println("otlp export error:", err)
+			}
+		}
+	}
+}
+
+// Export sends the current metrics of the server to the collector.
+func (o *OTLP) Export(ctx context.Context, s *Server) error {
+	body, err := json.Marshal(s.otlpMetrics(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(o.Endpoint, "/")+"/v1/metrics", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range o.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("otlp: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	return nil
+}
+
+// The types below are the subset of the OTLP metrics protobuf messages in
+// their JSON mapping, where 64-bit integers are encoded as strings.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Unit      string         `json:"unit,omitempty"`
+	Sum       *otlpSum       `json:"sum,omitempty"`
+	Gauge     *otlpGauge     `json:"gauge,omitempty"`
+	Histogram *otlpHistogram `json:"histogram,omitempty"`
+}
+
+// otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpCumulative = 2
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpNumberPoint struct {
+	StartTimeUnixNano int64 `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      int64 `json:"timeUnixNano,string"`
+	AsInt             int64 `json:"asInt,string"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpAttribute `json:"attributes"`
+	StartTimeUnixNano int64           `json:"startTimeUnixNano,string"`
+	TimeUnixNano      int64           `json:"timeUnixNano,string"`
+	Count             uint64          `json:"count,string"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	attr := otlpAttribute{Key: key}
+	attr.Value.StringValue = value
+	return attr
+}
+
+// otlpMetrics builds the export request for the current metrics.
+func (s *Server) otlpMetrics(now time.Time) otlpRequest {
+	var (
+		start   = s.started.UnixNano()
+		ts      = now.UnixNano()
+		metrics []otlpMetric
+	)
+
+	for _, stat := range s.Stats() {
+		point := otlpNumberPoint{TimeUnixNano: ts, AsInt: stat.Value}
+		if name, ok := strings.CutSuffix(stat.Name, "_total"); ok {
+			point.StartTimeUnixNano = start
+			metrics = append(metrics, otlpMetric{
+				Name: "ggcache." + name,
+				Sum: &otlpSum{
+					DataPoints:             []otlpNumberPoint{point},
+					AggregationTemporality: otlpCumulative,
+					IsMonotonic:            true,
+				},
+			})
+			continue
+		}
+		metrics = append(metrics, otlpMetric{
+			Name:  "ggcache." + stat.Name,
+			Gauge: &otlpGauge{DataPoints: []otlpNumberPoint{point}},
+		})
+	}
+
+	latencies := s.CommandLatencies()
+	if len(latencies) != 0 {
+		names := make([]string, 0, len(latencies))
+		for name := range latencies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		hist := &otlpHistogram{AggregationTemporality: otlpCumulative}
+		for _, name := range names {
+			h := latencies[name]
+			counts := make([]string, len(h.Counts))
+			for i, n := range h.Counts {
+				counts[i] = fmt.Sprint(n)
+			}
+			hist.DataPoints = append(hist.DataPoints, otlpHistogramPoint{
+				Attributes:        []otlpAttribute{otlpString("command", name)},
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				Count:             h.Count,
+				Sum:               h.Sum,
+				BucketCounts:      counts,
+				ExplicitBounds:    h.Bounds,
+			})
+		}
+		metrics = append(metrics, otlpMetric{Name: "ggcache.command.duration", Unit: "s", Histogram: hist})
+	}
+
+	// The value sizes are broken down by namespace if they are tracked, so
+	// the points add up to the overall histogram rather than double it.
+	sizes, namespaces := s.ValueSizes()
+	if sizes.Count != 0 {
+		points := map[string]SizeHistogram{"": sizes}
+		if len(namespaces) != 0 {
+			points = namespaces
+		}
+		names := make([]string, 0, len(points))
+		for name := range points {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		hist := &otlpHistogram{AggregationTemporality: otlpCumulative}
+		for _, name := range names {
+			h := points[name]
+			counts := make([]string, len(h.Counts))
+			for i, n := range h.Counts {
+				counts[i] = fmt.Sprint(n)
+			}
+			bounds := make([]float64, len(h.Bounds))
+			for i, b := range h.Bounds {
+				bounds[i] = float64(b)
+			}
+			attrs := []otlpAttribute{}
+			if len(name) != 0 {
+				attrs = append(attrs, otlpString("namespace", name))
+			}
+			hist.DataPoints = append(hist.DataPoints, otlpHistogramPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				Count:             h.Count,
+				Sum:               float64(h.Sum),
+				BucketCounts:      counts,
+				ExplicitBounds:    bounds,
+			})
+		}
+		metrics = append(metrics, otlpMetric{Name: "ggcache.value.size", Unit: "By", Histogram: hist})
+	}
+
+	instance := s.AdvertiseAddr
+	if len(instance) == 0 {
+		if addr := s.Addr(); addr != nil {
+			instance = addr.String()
+		}
+	}
+
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			otlpString("service.name", "ggcache"),
+			otlpString("service.instance.id", instance),
+		}},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "github.com/anthdm/ggcache"},
+			Metrics: metrics,
+		}},
+	}}}
+}
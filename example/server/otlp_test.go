@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOTLPExport(t *testing.T) {
+	var (
+		got    otlpRequest
+		header http.Header
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		header = r.Header
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer collector.Close()
+
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	assert.Nil(t, c.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+	_, err = c.Get(context.Background(), []byte("foo"))
+	assert.Nil(t, err)
+
+	// Latencies are recorded after the response is written.
+	assert.Eventually(t, func() bool {
+		return len(s.CommandLatencies()) == 2
+	}, time.Second, time.Millisecond)
+
+	o := &OTLP{Endpoint: collector.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+	assert.Nil(t, o.Export(context.Background(), s))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+
+	metrics := make(map[string]otlpMetric)
+	for _, m := range got.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	sets := metrics["ggcache.cache_sets"]
+	assert.NotNil(t, sets.Sum)
+	assert.True(t, sets.Sum.IsMonotonic)
+	assert.Equal(t, int64(1), sets.Sum.DataPoints[0].AsInt)
+	assert.NotNil(t, metrics["ggcache.cache_keys"].Gauge)
+
+	duration := metrics["ggcache.command.duration"]
+	assert.Equal(t, "s", duration.Unit)
+	assert.Len(t, duration.Histogram.DataPoints, 2)
+	point := duration.Histogram.DataPoints[0]
+	assert.Equal(t, "get", point.Attributes[0].Value.StringValue)
+	assert.Equal(t, uint64(1), point.Count)
+	assert.Len(t, point.BucketCounts, len(point.ExplicitBounds)+1)
+
+	sizes := metrics["ggcache.value.size"]
+	assert.Equal(t, "By", sizes.Unit)
+	assert.Len(t, sizes.Histogram.DataPoints, 1)
+	assert.Equal(t, "1", sizes.Histogram.DataPoints[0].BucketCounts[0])
+	assert.Equal(t, float64(3), sizes.Histogram.DataPoints[0].Sum)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// PersistencePolicy is what a server does while its writes cannot be
+// persisted: the backups fail, or the Cacher reports a
+// ggcache.PersistenceChecker error.
+type PersistencePolicy string
+
+const (
+	// PersistenceReadOnly keeps serving reads and answers writes with
+	// StatusPersistenceError: those the Cacher fails to persist, and all of
+	// them while the backups fail, until one succeeds. It is the default.
+	PersistenceReadOnly PersistencePolicy = "read_only"
+
+	// PersistenceMemoryOnly keeps accepting writes, reporting the node as
+	// degraded in its stats. The Cacher should keep the writes it cannot
+	// persist rather than fail them, as the disk engine does with
+	// MemoryFallback.
+	PersistenceMemoryOnly PersistencePolicy = "memory_only"
+)
+
+// persistenceState tracks the failures of the backups.
+type persistenceState struct {
+	mu        sync.Mutex
+	backupErr error
+	since     time.Time
+
+	// rejected counts the writes rejected while the backups fail.
+	rejected atomic.Uint64
+}
+
+// PersistenceReport tells whether the writes of the node are persisted.
+type PersistenceReport struct {
+	Policy   PersistencePolicy `json:"policy"`
+	Degraded bool              `json:"degraded"`
+	// Error is why the writes are not persisted, and Since when the backups
+	// started failing if they are the cause.
+	Error string     `json:"error,omitempty"`
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// persistencePolicy returns the PersistenceFailure policy, defaulted.
+func (s *Server) persistencePolicy() PersistencePolicy {
+	if len(s.PersistenceFailure) == 0 {
+		return PersistenceReadOnly
+	}
+	return s.PersistenceFailure
+}
+
+// recordBackup records the outcome of a backup. A failure degrades the
+// node until a backup succeeds; a cancelled backup says nothing either way.
+func (s *Server) recordBackup(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	s.persistence.mu.Lock()
+	defer s.persistence.mu.Unlock()
+
+	switch {
+	case err == nil && s.persistence.backupErr != nil:
+		log.Println("backups succeed again, persistence restored")
+		s.persistence.backupErr = nil
+	case err != nil && s.persistence.backupErr == nil:
+		log.Printf("backups fail, persistence degraded (%s): %s\n", s.persistencePolicy(), err)
+		s.persistence.backupErr = err
+		s.persistence.since = time.Now()
+	case err != nil:
+		s.persistence.backupErr = err
+	}
+}
+
+// PersistenceReport returns whether the writes of the node are persisted.
+func (s *Server) PersistenceReport() PersistenceReport {
+	report := PersistenceReport{Policy: s.persistencePolicy()}
+
+	s.persistence.mu.Lock()
+	if err := s.persistence.backupErr; err != nil {
+		since := s.persistence.since.UTC()
+		report.Degraded, report.Error, report.Since = true, err.Error(), &since
+	}
+	s.persistence.mu.Unlock()
+
+	if c, ok := s.cache.(ggcache.PersistenceChecker); ok && !report.Degraded {
+		if err := c.PersistenceErr(); err != nil {
+			report.Degraded, report.Error = true, err.Error()
+		}
+	}
+	return report
+}
+
+// writable returns StatusPersistenceError for the writes of the clients
+// while the backups fail under PersistenceReadOnly, and StatusOK otherwise.
+// The writes forwarded by our leader are applied, as it acknowledged them.
+func (s *Server) writable(t *tenant, cmd any) proto.Status {
+	if s.persistencePolicy() != PersistenceReadOnly || t == upstream || !isWrite(cmd) {
+		return proto.StatusOK
+	}
+	if _, ok := cmd.(*proto.CommandGetLease); ok {
+		// A hit is a read, and the SETLEASE of a miss is rejected anyway.
+		return proto.StatusOK
+	}
+
+	s.persistence.mu.Lock()
+	failing := s.persistence.backupErr != nil
+	s.persistence.mu.Unlock()
+
+	if failing {
+		s.persistence.rejected.Add(1)
+		return proto.StatusPersistenceError
+	}
+	return proto.StatusOK
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// fullStore is an ObjectStore whose uploads fail while full is set.
+type fullStore struct {
+	full atomic.Bool
+}
+
+func (s *fullStore) Put(_ context.Context, _ string, _ []byte) error {
+	if s.full.Load() {
+		return errors.New("no space left on device")
+	}
+	return nil
+}
+
+func (s *fullStore) Get(context.Context, string) (io.ReadCloser, error) {
+	return nil, errors.New("not found")
+}
+
+func (s *fullStore) List(context.Context, string) ([]string, error) { return nil, nil }
+
+func (s *fullStore) Delete(context.Context, string) error { return nil }
+
+func TestPersistenceReadOnly(t *testing.T) {
+	store := &fullStore{}
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, Backups: &Backups{Store: store}}, ggcache.New())
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+
+	store.full.Store(true)
+	_, err = c.Backup(ctx)
+	assert.NotNil(t, err)
+	assert.True(t, s.PersistenceReport().Degraded)
+	assert.Contains(t, s.PersistenceReport().Error, "no space left on device")
+
+	// Reads are served, writes are rejected until a backup succeeds.
+	value, err := c.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.Equal(t, client.ErrPersistence, c.Set(ctx, []byte("foo"), []byte("baz"), 0))
+	assert.ErrorIs(t, c.Delete(ctx, []byte("foo")), ggcache.ErrPersistence)
+	assert.Equal(t, uint64(2), s.persistence.rejected.Load())
+
+	store.full.Store(false)
+	_, err = c.Backup(ctx)
+	assert.Nil(t, err)
+	assert.False(t, s.PersistenceReport().Degraded)
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("baz"), 0))
+}
+
+func TestPersistenceMemoryOnly(t *testing.T) {
+	store := &fullStore{}
+	store.full.Store(true)
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader:           true,
+		Backups:            &Backups{Store: store},
+		PersistenceFailure: PersistenceMemoryOnly,
+	}, ggcache.New())
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	_, err = c.Backup(ctx)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	report := s.PersistenceReport()
+	assert.True(t, report.Degraded)
+	assert.Equal(t, PersistenceMemoryOnly, report.Policy)
+
+	stats, err := c.Stats(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), stats["server_persistence_degraded"])
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+var (
+	// errElected is returned by a PROMOTE sent to a node that elects its
+	// leader, which would undo it on the next election.
+	errElected = errors.New("the leader is elected, it cannot be promoted by hand")
+
+	// errNotFollower is returned by a PROMOTE sent to a node without a
+	// leader to take over from.
+	errNotFollower = errors.New("the node does not follow a leader")
+)
+
+func (s *Server) handlePromoteCommand(conn net.Conn, cmd *proto.CommandPromote) error {
+	var err error
+	if len(cmd.Leader) == 0 {
+		err = s.Promote()
+	} else {
+		err = s.demote(cmd.Leader)
+	}
+
+	resp := proto.ResponseSet{Status: proto.StatusOK}
+	if err != nil {
+		log.Println("promote error:", err)
+		resp.Status = proto.StatusError
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+// Promote makes this follower the leader and its leader a follower of it,
+// which redirects the writes of its clients here and relays the mutations
+// to its own followers. If the old leader cannot be reached the node is
+// promoted all the same and the error returned. It is meant for planned
+// maintenance, and is rejected if Discovery or an Elector is set.
+func (s *Server) Promote() error {
+	if s.Discovery != nil || s.Elector != nil {
+		return errElected
+	}
+
+	s.mu.Lock()
+	leader := s.leader
+	if len(leader) != 0 {
+		s.promoted = true
+	}
+	s.mu.Unlock()
+	if len(leader) == 0 {
+		return errNotFollower
+	}
+
+	// Stop following first, so the old leader joins us rather than both
+	// forwarding their mutations to each other.
+	s.demoted.Store(false)
+	s.follow("")
+	log.Printf("promoted to leader, demoting [%s]\n", leader)
+
+	conn, err := s.dial(leader)
+	if err != nil {
+		return fmt.Errorf("failed to dial leader [%s]", leader)
+	}
+	defer conn.Close()
+
+	if err := s.authenticate(conn, leader); err != nil {
+		return err
+	}
+	if err := proto.WriteMessage(conn, &proto.CommandPromote{Leader: s.advertisedAddr(), Secret: s.ClusterSecret}); err != nil {
+		return err
+	}
+	resp, err := proto.ParseSetResponse(conn)
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return fmt.Errorf("leader [%s] refused to step down: %s", leader, resp.Status)
+	}
+	return nil
+}
+
+// demote makes this node a follower of leader that redirects the writes of
+// its clients there.
+func (s *Server) demote(leader string) error {
+	if s.Discovery != nil || s.Elector != nil {
+		return errElected
+	}
+
+	s.mu.Lock()
+	s.promoted = false
+	s.mu.Unlock()
+
+	s.demoted.Store(true)
+	s.follow(leader)
+	log.Printf("demoted, following [%s]\n", leader)
+	return nil
+}
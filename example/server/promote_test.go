@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromote(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, nil)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	assert.Nil(t, fc.Promote(ctx))
+	assert.Equal(t, RoleLeader, follower.Role())
+	assert.Equal(t, RoleFollower, leader.Role())
+
+	// The old leader joins the new one and redirects its writes there.
+	assert.Eventually(t, func() bool {
+		return follower.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+	var redirect *proto.Redirect
+	assert.ErrorAs(t, lc.Set(ctx, []byte("foo"), []byte("bar"), 0), &redirect)
+	assert.Equal(t, follower.Addr().String(), redirect.Addr)
+	assert.ErrorIs(t, lc.Delete(ctx, []byte("foo")), client.ErrReadOnly)
+
+	assert.Nil(t, fc.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Eventually(t, func() bool {
+		value, err := lc.Get(ctx, []byte("foo"))
+		return err == nil && string(value) == "bar"
+	}, time.Second, 10*time.Millisecond)
+
+	// And can be promoted back.
+	assert.Nil(t, lc.Promote(ctx))
+	assert.Equal(t, RoleLeader, leader.Role())
+	assert.Equal(t, RoleFollower, follower.Role())
+	assert.Nil(t, lc.Set(ctx, []byte("foo"), []byte("baz"), 0))
+
+	// The leader has no leader to take over from.
+	assert.Error(t, lc.Promote(ctx))
+}
+
+func TestPromoteElected(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{
+		Discovery: DiscoveryFunc(func(context.Context) ([]string, error) { return nil, nil }),
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	assert.Error(t, c.Promote(context.Background()))
+}
+
+func TestPromoteClusterSecret(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true, ClusterSecret: "s3cret"}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String(), ClusterSecret: "s3cret"}, nil)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// A PROMOTE without the secret cannot make a node follow, and join,
+	// another leader.
+	intruder, err := client.New(follower.Addr().String(), client.Options{})
+	assert.Nil(t, err)
+	defer intruder.Close()
+	assert.ErrorIs(t, intruder.Promote(context.Background()), client.ErrUnauthorized)
+	conn, err := net.Dial("tcp", leader.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Nil(t, proto.WriteMessage(conn, &proto.CommandPromote{Leader: "127.0.0.1:1"}))
+	resp, err := proto.ParseSetResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, proto.StatusUnauthorized, resp.Status)
+	assert.Equal(t, RoleLeader, leader.Role())
+	assert.Equal(t, RoleFollower, follower.Role())
+
+	operator, err := client.New(follower.Addr().String(), client.Options{ClusterSecret: "s3cret"})
+	assert.Nil(t, err)
+	defer operator.Close()
+	assert.Nil(t, operator.Promote(context.Background()))
+	assert.Equal(t, RoleLeader, follower.Role())
+	assert.Equal(t, RoleFollower, leader.Role())
+}
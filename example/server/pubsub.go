@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// pubsubBuffer is the number of messages queued for a slow subscriber.
+const pubsubBuffer = 256
+
+// errSlowSubscriber ends the subscription of a connection that fell behind by
+// more than pubsubBuffer messages.
+var errSlowSubscriber = errors.New("subscriber fell behind")
+
+// channelTable fans the messages published on the channels out to the
+// connections subscribed to them. Unlike the keyspace events, the messages
+// are not tied to a key and are forwarded to the members.
+type channelTable struct {
+	mu   sync.Mutex
+	subs map[string]map[*subscriber]struct{}
+
+	// published counts the messages published through this node or
+	// forwarded to it, delivered the messages queued for subscribers and
+	// dropped the subscribers disconnected for falling behind.
+	published atomic.Uint64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// subscriber is a connection subscribed to channels.
+type subscriber struct {
+	channels [][]byte
+	ch       chan *proto.Message
+}
+
+func (t *channelTable) subscribe(channels [][]byte) *subscriber {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.subs == nil {
+		t.subs = make(map[string]map[*subscriber]struct{})
+	}
+	sub := &subscriber{channels: channels, ch: make(chan *proto.Message, pubsubBuffer)}
+	for _, channel := range channels {
+		subs, ok := t.subs[string(channel)]
+		if !ok {
+			subs = make(map[*subscriber]struct{})
+			t.subs[string(channel)] = subs
+		}
+		subs[sub] = struct{}{}
+	}
+	return sub
+}
+
+// unsubscribe removes the subscriber and closes its channel, unless publish
+// already did because it fell behind.
+func (t *channelTable) unsubscribe(sub *subscriber) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.remove(sub) {
+		close(sub.ch)
+	}
+}
+
+// remove removes the subscriber from its channels, reporting whether it was
+// still subscribed. The caller must hold mu.
+func (t *channelTable) remove(sub *subscriber) bool {
+	found := false
+	for _, channel := range sub.channels {
+		subs := t.subs[string(channel)]
+		if _, ok := subs[sub]; !ok {
+			continue
+		}
+		found = true
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(t.subs, string(channel))
+		}
+	}
+	return found
+}
+
+// publish queues the message for the subscribers of its channel and returns
+// how many it was queued for.
+func (t *channelTable) publish(msg *proto.Message) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.published.Add(1)
+	n := 0
+	for sub := range t.subs[string(msg.Channel)] {
+		select {
+		case sub.ch <- msg:
+			n++
+		default:
+			// Closing tells the subscriber it missed messages.
+			t.remove(sub)
+			close(sub.ch)
+			t.dropped.Add(1)
+		}
+	}
+	t.delivered.Add(uint64(n))
+	return n
+}
+
+// subscribers returns the number of subscriptions to the channels.
+func (t *channelTable) subscribers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, subs := range t.subs {
+		n += len(subs)
+	}
+	return n
+}
+
+func (s *Server) handlePublishCommand(conn net.Conn, cmd *proto.CommandPublish) error {
+	n := s.publish(cmd)
+	return proto.WriteMessage(conn, &proto.ResponsePublish{Status: proto.StatusOK, Receivers: n})
+}
+
+// publish delivers the message to the subscribers of this node and forwards
+// it to the members, returning how many subscribers it was queued for.
+func (s *Server) publish(cmd *proto.CommandPublish) int {
+	s.replicate(cmd)
+	return s.channels.publish(&proto.Message{Channel: cmd.Channel, Payload: cmd.Message})
+}
+
+// handleSubscribeCommand pushes the messages of the channels to the
+// connection until it is closed, the server closes or the connection falls
+// behind. The channels of a tenant are scoped like its keys, which is undone
+// for the messages pushed.
+func (s *Server) handleSubscribeCommand(conn net.Conn, t *tenant, cmd *proto.CommandSubscribe) error {
+	sub := s.channels.subscribe(cmd.Channels)
+	defer s.channels.unsubscribe(sub)
+
+	if err := proto.WriteMessage(conn, &proto.ResponseSet{Status: proto.StatusOK}); err != nil {
+		return err
+	}
+
+	// The client sends nothing more; a read returns once it closes the
+	// connection, or once we do.
+	gone := make(chan struct{})
+	go func() {
+		_, _ = conn.Read(make([]byte, 1))
+		close(gone)
+	}()
+
+	for {
+		select {
+		case msg, ok := <-sub.ch:
+			if !ok {
+				return errSlowSubscriber
+			}
+			if t.prefix != nil {
+				msg = &proto.Message{Channel: bytes.TrimPrefix(msg.Channel, t.prefix), Payload: msg.Payload}
+			}
+			if err := proto.WriteMessage(conn, msg); err != nil {
+				return err
+			}
+		case <-gone:
+			return nil
+		case <-s.quitch:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubSub(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, nil)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ls, err := client.Subscribe(leader.Addr().String(), client.Options{}, []byte("news"), []byte("sports"))
+	assert.Nil(t, err)
+	defer ls.Close()
+	fs, err := client.Subscribe(follower.Addr().String(), client.Options{}, []byte("news"))
+	assert.Nil(t, err)
+	defer fs.Close()
+
+	ctx := context.Background()
+	n, err := lc.Publish(ctx, []byte("news"), []byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	n, err = lc.Publish(ctx, []byte("sports"), []byte("goal"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	n, err = lc.Publish(ctx, []byte("weather"), []byte("rain"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// The subscribers of the follower get the messages of the leader.
+	for _, want := range []*proto.Message{
+		{Channel: []byte("news"), Payload: []byte("hello")},
+		{Channel: []byte("sports"), Payload: []byte("goal")},
+	} {
+		msg, err := ls.Receive()
+		assert.Nil(t, err)
+		assert.Equal(t, want, msg)
+	}
+	msg, err := fs.Receive()
+	assert.Nil(t, err)
+	assert.Equal(t, &proto.Message{Channel: []byte("news"), Payload: []byte("hello")}, msg)
+
+	// The keyspace is left alone.
+	_, err = lc.Get(ctx, []byte("news"))
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+
+	// A closed subscription is forgotten.
+	assert.Nil(t, fs.Close())
+	assert.Eventually(t, func() bool {
+		return follower.channels.subscribers() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestPubSubSlowSubscriber(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	sub := s.channels.subscribe([][]byte{[]byte("news"), []byte("sports")})
+	for i := 0; i < pubsubBuffer; i++ {
+		assert.Equal(t, 1, s.publish(&proto.CommandPublish{Channel: []byte("news"), Message: []byte("hello")}))
+	}
+
+	// A subscriber that falls behind is dropped from all its channels.
+	assert.Equal(t, 0, s.publish(&proto.CommandPublish{Channel: []byte("news"), Message: []byte("hello")}))
+	assert.Equal(t, 0, s.channels.subscribers())
+	assert.Equal(t, uint64(1), s.channels.dropped.Load())
+	s.channels.unsubscribe(sub)
+}
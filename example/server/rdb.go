@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// ExportRDB writes the live keys of the cache to w as an RDB file, in the
+// format of ggcache.WriteRDB, so the Redis tools can inspect them.
+func (s *Server) ExportRDB(w io.Writer) error {
+	snap, ok := s.cache.(ggcache.Snapshotter)
+	if !ok {
+		return errNoSnapshot
+	}
+	buf := new(bytes.Buffer)
+	if err := snap.Snapshot(buf); err != nil {
+		return err
+	}
+	recs, err := ggcache.ReadSnapshot(buf)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	live := recs[:0]
+	for _, rec := range recs {
+		if rec.TTL(now) >= 0 {
+			live = append(live, rec)
+		}
+	}
+	return ggcache.WriteRDB(w, live)
+}
+
+// ImportRDB stores the string keys of the RDB file read from r with the TTL
+// they have left, and returns how many it stored. The file is read whole
+// before any key is stored, and the writes are replicated like SETs, so it
+// is meant for the leader. The keys that expired are skipped.
+func (s *Server) ImportRDB(r io.Reader) (int, error) {
+	recs, err := ggcache.ReadRDB(r)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	imported := 0
+	for _, rec := range recs {
+		ttl := rec.TTL(now)
+		if ttl < 0 {
+			continue
+		}
+		if err := s.set(rec.Key, rec.Value, ttl); err != nil {
+			return imported, fmt.Errorf("import [%s]: %w", rec.Key, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// handleRDBAPI exports the cache as an RDB file on a GET to /api/v1/rdb,
+// and imports the RDB file in the body on a POST.
+func (s *Server) handleRDBAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		buf := new(bytes.Buffer)
+		if err := s.ExportRDB(buf); err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(buf.Bytes())
+	case http.MethodPost:
+		n, err := s.ImportRDB(r.Body)
+		switch {
+		case errors.Is(err, ggcache.ErrInvalidRDB):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(struct {
+			Imported int `json:"imported"`
+		}{n})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRDB(t *testing.T) {
+	src, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer src.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("users:1"), []byte("alice"), 0))
+	assert.Nil(t, c.Set(ctx, []byte("users:2"), []byte("bob"), time.Hour))
+
+	rec := httptest.NewRecorder()
+	src.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/rdb", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	dump := rec.Body.Bytes()
+	recs, err := ggcache.ReadRDB(bytes.NewReader(dump))
+	assert.Nil(t, err)
+	assert.Len(t, recs, 2)
+
+	dst, dc, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer dst.Close()
+	defer dc.Close()
+
+	rec = httptest.NewRecorder()
+	dst.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/rdb", bytes.NewReader(dump)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp struct{ Imported int }
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Imported)
+
+	value, err := dc.Get(ctx, []byte("users:2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bob"), value)
+	expiry, err := dst.cache.(*ggcache.Cache).Expiry([]byte("users:2"))
+	assert.Nil(t, err)
+	assert.InDelta(t, time.Hour, time.Until(expiry), float64(time.Minute))
+
+	rec = httptest.NewRecorder()
+	dst.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/rdb", bytes.NewReader([]byte("garbage"))))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
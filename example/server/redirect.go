@@ -0,0 +1,41 @@
+package server
+
+import (
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// leaderRetryAfter is how long the clients of a follower connecting to its
+// leader are told to wait before retrying a write.
+const leaderRetryAfter = 500 * time.Millisecond
+
+// redirect returns the Redirect answering the command of the tenant in place
+// of its response, or nil if it is served here. With RedirectWrites, on a
+// read-only replica advertising a ReadAddr, or on a demoted leader, the
+// writes sent to a follower by anyone but its leader go to the leader.
+func (s *Server) redirect(t *tenant, cmd any) *proto.Redirect {
+	if !s.RedirectWrites && len(s.ReadAddr) == 0 && !s.demoted.Load() || t == upstream || !isWrite(cmd) {
+		return nil
+	}
+
+	return s.leaderRedirect()
+}
+
+// isWrite reports whether the command writes to the cache. GETLEASE counts
+// as one, as the lease it grants is for a write, FLUSH unless it is a dry
+// run, UNDELETE, PURGE, and PUBLISH, which only the leader forwards to every
+// member.
+func isWrite(cmd any) bool {
+	switch v := cmd.(type) {
+	case *proto.CommandSet, *proto.CommandDel, *proto.CommandDelIf, *proto.CommandTouch, *proto.CommandAppend,
+		*proto.CommandSetIf, *proto.CommandGetLease, *proto.CommandSetLease, *proto.CommandRename,
+		*proto.CommandCopy, *proto.CommandXAdd, *proto.CommandPublish, *proto.CommandUndelete,
+		*proto.CommandPurge, *proto.CommandMulti:
+		return true
+	case *proto.CommandFlush:
+		return !v.DryRun
+	default:
+		return false
+	}
+}
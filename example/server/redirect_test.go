@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirectWrites(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	follower, fc, err := StartEmbedded(ServerOpts{
+		LeaderAddr:     leader.Addr().String(),
+		RedirectWrites: true,
+	}, nil)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	var redirect *proto.Redirect
+	assert.ErrorAs(t, fc.Set(ctx, []byte("foo"), []byte("bar"), 0), &redirect)
+	assert.Equal(t, &proto.Redirect{Status: proto.StatusMoved, Addr: leader.Addr().String()}, redirect)
+	assert.ErrorAs(t, fc.Delete(ctx, []byte("foo")), &redirect)
+
+	// The writes of the leader are applied, and reads are served.
+	assert.Nil(t, lc.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Eventually(t, func() bool {
+		value, err := fc.Get(ctx, []byte("foo"))
+		return err == nil && string(value) == "bar"
+	}, time.Second, 10*time.Millisecond)
+
+	// Until it is connected to its leader, a follower asks to retry.
+	follower.mu.Lock()
+	follower.leaderConn = nil
+	follower.mu.Unlock()
+	assert.ErrorAs(t, fc.Set(ctx, []byte("foo"), []byte("baz"), 0), &redirect)
+	assert.Equal(t, &proto.Redirect{Status: proto.StatusRetry, RetryAfter: leaderRetryAfter}, redirect)
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+)
+
+// Registration is what a node publishes about itself in a service registry.
+type Registration struct {
+	ID   string
+	Addr string
+	Role string
+	// Healthy is false while the node is degraded, as reported by its Health
+	// with every response, so the catalog steers clients to the other nodes.
+	Healthy bool
+}
+
+// Registry publishes nodes in an external service catalog.
+type Registry interface {
+	Register(ctx context.Context, reg Registration) error
+	Deregister(ctx context.Context, id string) error
+}
+
+// Elector returns the address of the current leader, campaigning for
+// leadership on behalf of this node if the position is vacant.
+type Elector interface {
+	Leader(ctx context.Context) (string, error)
+}
+
+// Role reports whether the server currently acts as leader or follower.
+func (s *Server) Role() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.leader) != 0 {
+		return RoleFollower
+	}
+	if s.Discovery == nil && s.Elector == nil && !s.IsLeader && len(s.LeaderAddr) != 0 && !s.promoted {
+		// A static follower that lost its leader is still a follower.
+		return RoleFollower
+	}
+	return RoleLeader
+}
+
+func (s *Server) registration() Registration {
+	addr := s.AdvertiseAddr
+	if len(addr) == 0 {
+		addr = s.ListenAddr
+	}
+	return Registration{
+		ID:      "ggcache-" + addr,
+		Addr:    addr,
+		Role:    s.Role(),
+		Healthy: s.Health() == 0,
+	}
+}
+
+func (s *Server) register() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Registry.Register(ctx, s.registration()); err != nil {
+		log.Println("registry error:", err)
+	}
+}
+
+func (s *Server) deregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Registry.Deregister(ctx, s.registration().ID); err != nil {
+		log.Println("registry error:", err)
+	}
+}
+
+// ConsulRegistry registers nodes with the local Consul agent, using a TTL
+// health check that is passed on every registration refresh.
+type ConsulRegistry struct {
+	// Addr of the agent's HTTP API, e.g. http://127.0.0.1:8500.
+	Addr    string
+	Service string
+	// CheckTTL should be larger than the server's DiscoveryInterval.
+	CheckTTL time.Duration
+	Client   *http.Client
+
+	mu    sync.Mutex
+	roles map[string]string
+}
+
+func (r *ConsulRegistry) Register(ctx context.Context, reg Registration) error {
+	r.mu.Lock()
+	if r.roles == nil {
+		r.roles = make(map[string]string)
+	}
+	changed := r.roles[reg.ID] != reg.Role
+	r.mu.Unlock()
+
+	// Registering is only needed when the role tag changes, otherwise
+	// passing the TTL check keeps the service healthy.
+	if changed {
+		if err := r.register(ctx, reg); err != nil {
+			return err
+		}
+	}
+
+	status := "pass"
+	if !reg.Healthy {
+		status = "fail"
+	}
+	path := "/v1/agent/check/" + status + "/service:" + reg.ID
+	err := r.do(ctx, path, nil)
+	if err == nil || changed {
+		return err
+	}
+	// The agent lost the service, e.g. as it restarted or reaped it once its
+	// check was critical for too long, so it is registered again.
+	r.mu.Lock()
+	delete(r.roles, reg.ID)
+	r.mu.Unlock()
+	if err := r.register(ctx, reg); err != nil {
+		return err
+	}
+	return r.do(ctx, path, nil)
+}
+
+// register registers the service of the node with its role tag and TTL
+// check.
+func (r *ConsulRegistry) register(ctx context.Context, reg Registration) error {
+	host, portStr, err := net.SplitHostPort(reg.Addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	ttl := r.CheckTTL
+	if ttl <= 0 {
+		ttl = 3 * defaultDiscoveryInterval
+	}
+	body := map[string]any{
+		"ID":      reg.ID,
+		"Name":    r.service(),
+		"Address": host,
+		"Port":    port,
+		"Tags":    []string{reg.Role},
+		"Check": map[string]any{
+			"CheckID":                        "service:" + reg.ID,
+			"TTL":                            ttl.String(),
+			"DeregisterCriticalServiceAfter": (10 * ttl).String(),
+		},
+	}
+	if err := r.do(ctx, "/v1/agent/service/register", body); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.roles[reg.ID] = reg.Role
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *ConsulRegistry) Deregister(ctx context.Context, id string) error {
+	r.mu.Lock()
+	delete(r.roles, id)
+	r.mu.Unlock()
+
+	return r.do(ctx, "/v1/agent/service/deregister/"+id, nil)
+}
+
+func (r *ConsulRegistry) service() string {
+	if len(r.Service) == 0 {
+		return "ggcache"
+	}
+	return r.Service
+}
+
+func (r *ConsulRegistry) do(ctx context.Context, path string, body any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(r.Addr, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("consul: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul: %s responded with [%s]", path, resp.Status)
+	}
+	return nil
+}
+
+// Etcd registers nodes under Prefix in etcd and can elect a leader through a
+// key bound to the node's lease, as a lighter alternative to running a
+// consensus protocol inside ggcache. It talks to the v3 JSON gateway.
+//
+// Everything this node writes is attached to a single lease that is kept
+// alive on every call, so a node that dies loses its registration and, if it
+// was the leader, its leadership once the lease expires.
+type Etcd struct {
+	// Endpoint of the etcd HTTP gateway, e.g. http://127.0.0.1:2379.
+	Endpoint string
+	Prefix   string
+	// Addr is the address this node campaigns with, usually AdvertiseAddr.
+	Addr     string
+	LeaseTTL time.Duration
+	Client   *http.Client
+
+	mu    sync.Mutex
+	lease string
+}
+
+func (e *Etcd) Register(ctx context.Context, reg Registration) error {
+	lease, err := e.keepAlive(ctx)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return e.call(ctx, "/v3/kv/put", map[string]any{
+		"key":   b64(e.key("nodes/" + reg.ID)),
+		"value": b64(string(value)),
+		"lease": lease,
+	}, nil)
+}
+
+func (e *Etcd) Deregister(ctx context.Context, _ string) error {
+	e.mu.Lock()
+	lease := e.lease
+	e.lease = ""
+	e.mu.Unlock()
+
+	if len(lease) == 0 {
+		return nil
+	}
+	// Revoking the lease removes the registration and gives up leadership.
+	return e.call(ctx, "/v3/lease/revoke", map[string]any{"ID": lease}, nil)
+}
+
+func (e *Etcd) Leader(ctx context.Context) (string, error) {
+	lease, err := e.keepAlive(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	key := b64(e.key("leader"))
+	req := map[string]any{
+		"compare": []map[string]any{{
+			"key":             key,
+			"target":          "CREATE",
+			"create_revision": "0",
+		}},
+		"success": []map[string]any{{
+			"requestPut": map[string]any{"key": key, "value": b64(e.Addr), "lease": lease},
+		}},
+		"failure": []map[string]any{{
+			"requestRange": map[string]any{"key": key},
+		}},
+	}
+
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+		Responses []struct {
+			ResponseRange struct {
+				Kvs []struct {
+					Value string `json:"value"`
+				} `json:"kvs"`
+			} `json:"response_range"`
+		} `json:"responses"`
+	}
+	if err := e.call(ctx, "/v3/kv/txn", req, &resp); err != nil {
+		return "", err
+	}
+
+	if resp.Succeeded {
+		return e.Addr, nil
+	}
+	if len(resp.Responses) == 0 || len(resp.Responses[0].ResponseRange.Kvs) == 0 {
+		// The key expired between the compare and the range, try next round.
+		return "", errors.New("etcd: leader key vanished during election")
+	}
+	leader, err := base64.StdEncoding.DecodeString(resp.Responses[0].ResponseRange.Kvs[0].Value)
+	if err != nil {
+		return "", err
+	}
+	return string(leader), nil
+}
+
+// keepAlive returns the node's lease, granting a new one if there is none or
+// the current one expired.
+func (e *Etcd) keepAlive(ctx context.Context) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.lease) != 0 {
+		var resp struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		if err := e.call(ctx, "/v3/lease/keepalive", map[string]any{"ID": e.lease}, &resp); err != nil {
+			return "", err
+		}
+		// A TTL of zero (omitted) means the lease is gone.
+		if ttl, _ := strconv.Atoi(resp.Result.TTL); ttl > 0 {
+			return e.lease, nil
+		}
+		e.lease = ""
+	}
+
+	ttl := e.LeaseTTL
+	if ttl <= 0 {
+		ttl = 3 * defaultDiscoveryInterval
+	}
+	var resp struct {
+		ID string `json:"ID"`
+	}
+	if err := e.call(ctx, "/v3/lease/grant", map[string]any{"TTL": int(ttl.Seconds())}, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.ID) == 0 {
+		return "", errors.New("etcd: lease grant returned no ID")
+	}
+	e.lease = resp.ID
+
+	return e.lease, nil
+}
+
+func (e *Etcd) key(name string) string {
+	prefix := e.Prefix
+	if len(prefix) == 0 {
+		prefix = "/ggcache"
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + name
+}
+
+func (e *Etcd) call(ctx context.Context, path string, body any, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.Endpoint, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("etcd: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd: %s responded with [%s]", path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationHealth(t *testing.T) {
+	store := &fullStore{}
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, Backups: &Backups{Store: store}}, ggcache.New())
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+	assert.True(t, s.registration().Healthy)
+
+	// A node that rejects the writes is registered as unhealthy.
+	store.full.Store(true)
+	_, err = c.Backup(context.Background())
+	assert.NotNil(t, err)
+	s.refreshHealth()
+	assert.False(t, s.registration().Healthy)
+
+	store.full.Store(false)
+	_, err = c.Backup(context.Background())
+	assert.Nil(t, err)
+	s.refreshHealth()
+	assert.True(t, s.registration().Healthy)
+}
+
+func TestConsulRegistry(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+		body  map[string]any
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/v1/agent/service/register" {
+			_ = json.NewDecoder(r.Body).Decode(&body)
+		}
+	}))
+	defer srv.Close()
+
+	r := &ConsulRegistry{Addr: srv.URL}
+	reg := Registration{ID: "ggcache-10.0.0.1:3000", Addr: "10.0.0.1:3000", Role: RoleLeader, Healthy: true}
+	assert.Nil(t, r.Register(context.Background(), reg))
+	assert.Nil(t, r.Register(context.Background(), reg))
+	assert.Nil(t, r.Deregister(context.Background(), reg.ID))
+
+	assert.Equal(t, []string{
+		"/v1/agent/service/register",
+		"/v1/agent/check/pass/service:ggcache-10.0.0.1:3000",
+		"/v1/agent/check/pass/service:ggcache-10.0.0.1:3000",
+		"/v1/agent/service/deregister/ggcache-10.0.0.1:3000",
+	}, paths)
+	assert.Equal(t, "ggcache", body["Name"])
+	assert.Equal(t, "10.0.0.1", body["Address"])
+	assert.Equal(t, []any{RoleLeader}, body["Tags"])
+}
+
+func TestConsulRegistryReregisters(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		registered bool
+		paths      []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		switch {
+		case r.URL.Path == "/v1/agent/service/register":
+			registered = true
+		case !registered:
+			// As the agent answers the checks of a service it does not know.
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	r := &ConsulRegistry{Addr: srv.URL}
+	reg := Registration{ID: "ggcache-10.0.0.1:3000", Addr: "10.0.0.1:3000", Role: RoleLeader, Healthy: true}
+	assert.Nil(t, r.Register(context.Background(), reg))
+
+	// The agent restarted and lost the service.
+	mu.Lock()
+	registered = false
+	paths = nil
+	mu.Unlock()
+	assert.Nil(t, r.Register(context.Background(), reg))
+	assert.Equal(t, []string{
+		"/v1/agent/check/pass/service:ggcache-10.0.0.1:3000",
+		"/v1/agent/service/register",
+		"/v1/agent/check/pass/service:ggcache-10.0.0.1:3000",
+	}, paths)
+}
+
+// fakeEtcd implements just enough of the v3 JSON gateway for the election.
+func fakeEtcd(t *testing.T) *httptest.Server {
+	var (
+		mu     sync.Mutex
+		leader string
+	)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		switch r.URL.Path {
+		case "/v3/lease/grant":
+			_, _ = w.Write([]byte(`{"ID":"42","TTL":"30"}`))
+		case "/v3/lease/keepalive":
+			_, _ = w.Write([]byte(`{"result":{"ID":"42","TTL":"30"}}`))
+		case "/v3/lease/revoke":
+			leader = ""
+		case "/v3/kv/put":
+		case "/v3/kv/txn":
+			if len(leader) == 0 {
+				success := req["success"].([]any)[0].(map[string]any)
+				leader = success["requestPut"].(map[string]any)["value"].(string)
+				_, _ = w.Write([]byte(`{"succeeded":true}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"succeeded":false,"responses":[{"response_range":{"kvs":[{"value":"` + leader + `"}]}}]}`))
+		default:
+			t.Errorf("unexpected etcd call %s", r.URL.Path)
+		}
+	}))
+}
+
+func TestEtcdElection(t *testing.T) {
+	srv := fakeEtcd(t)
+	defer srv.Close()
+
+	a := &Etcd{Endpoint: srv.URL, Addr: "10.0.0.1:3000"}
+	b := &Etcd{Endpoint: srv.URL, Addr: "10.0.0.2:3000"}
+
+	leader, err := a.Leader(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:3000", leader)
+
+	leader, err = b.Leader(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:3000", leader)
+
+	assert.Nil(t, b.Register(context.Background(), Registration{ID: "b", Addr: b.Addr, Role: RoleFollower}))
+
+	// The leader resigns by revoking its lease and b takes over.
+	assert.Nil(t, a.Deregister(context.Background(), "a"))
+	leader, err = b.Leader(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2:3000", leader)
+}
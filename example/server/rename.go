@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// errNoRename is returned by rename and copy if the cache is not a
+// ggcache.Renamer.
+var errNoRename = errors.New("the cache does not support rename")
+
+func (s *Server) handleRenameCommand(conn net.Conn, cmd *proto.CommandRename) error {
+	return s.writeRenameResponse(conn, s.rename(cmd.Key, cmd.NewKey))
+}
+
+func (s *Server) handleCopyCommand(conn net.Conn, cmd *proto.CommandCopy) error {
+	return s.writeRenameResponse(conn, s.copy(cmd.Key, cmd.Dst, time.Duration(cmd.TTL)*time.Millisecond))
+}
+
+// writeRenameResponse answers a RENAME or COPY with the status of its error.
+func (s *Server) writeRenameResponse(conn net.Conn, err error) error {
+	resp := proto.ResponseSet{Status: proto.StatusOK}
+	switch {
+	case err == nil:
+	case errors.Is(err, errNoRename):
+		resp.Status = proto.StatusError
+	case errors.Is(err, errInvalidValue):
+		resp.Status = proto.StatusInvalidValue
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+	default:
+		resp.Status = proto.StatusKeyNotFound
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+// rename moves the value of oldKey to newKey, forwards it to the members and
+// publishes a del of oldKey and a rename of newKey.
+func (s *Server) rename(oldKey, newKey []byte) error {
+	renamer, ok := s.cache.(ggcache.Renamer)
+	if !ok {
+		return errNoRename
+	}
+	if err := s.validateMove(oldKey, newKey); err != nil {
+		return err
+	}
+
+	if err := renamer.Rename(oldKey, newKey); err != nil {
+		return err
+	}
+
+	s.replicate(&proto.CommandRename{Key: oldKey, NewKey: newKey})
+	s.leases.invalidate(oldKey)
+	s.leases.invalidate(newKey)
+
+	s.countNamespace(oldKey, func(ns *NamespaceStats) { ns.Deletes++ })
+	s.countNamespace(newKey, func(ns *NamespaceStats) { ns.Sets++ })
+
+	s.events.publish(KeyspaceEvent{Op: "del", Key: oldKey})
+	s.events.publish(KeyspaceEvent{Op: "rename", Key: newKey})
+	return nil
+}
+
+// copy stores the value of src under dst, forwards it to the members and
+// publishes a copy of dst.
+func (s *Server) copy(src, dst []byte, ttl time.Duration) error {
+	renamer, ok := s.cache.(ggcache.Renamer)
+	if !ok {
+		return errNoRename
+	}
+	if err := s.validateMove(src, dst); err != nil {
+		return err
+	}
+
+	if err := renamer.Copy(src, dst, ttl); err != nil {
+		return err
+	}
+
+	s.replicate(&proto.CommandCopy{Key: src, Dst: dst, TTL: int(ttl.Milliseconds())})
+	s.leases.invalidate(dst)
+
+	s.countNamespace(dst, func(ns *NamespaceStats) { ns.Sets++ })
+
+	s.events.publish(KeyspaceEvent{Op: "copy", Key: dst})
+	return nil
+}
+
+// validateMove checks the value of src against the validator of dst when
+// it moves to another namespace, as it was only validated for its own. The
+// value is read ahead of the move, so a write racing with it is not checked.
+func (s *Server) validateMove(src, dst []byte) error {
+	if s.validator(dst) == nil || s.prefix(src) == s.prefix(dst) {
+		return nil
+	}
+	value, err := s.cache.Get(src)
+	if err != nil {
+		return err
+	}
+	return s.validate(dst, value)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRename(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{
+		IsLeader:   true,
+		Validators: map[string]Validator{"users": JSONValidator{}},
+	}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, nil)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	assert.NotNil(t, lc.Rename(ctx, []byte("tmp:1"), []byte("report")))
+
+	// A value built under a temporary key is published in one step.
+	assert.Nil(t, lc.Set(ctx, []byte("tmp:1"), []byte("part 1"), 0))
+	assert.Nil(t, lc.Append(ctx, []byte("tmp:1"), []byte(", part 2")))
+	assert.Nil(t, lc.Rename(ctx, []byte("tmp:1"), []byte("report")))
+	assert.Nil(t, lc.Copy(ctx, []byte("report"), []byte("report:backup"), time.Hour))
+
+	for _, c := range []*client.Client{lc, fc} {
+		assert.Eventually(t, func() bool {
+			value, err := c.Get(ctx, []byte("report:backup"))
+			return err == nil && string(value) == "part 1, part 2"
+		}, time.Second, 10*time.Millisecond)
+		value, err := c.Get(ctx, []byte("report"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("part 1, part 2"), value)
+		_, err = c.Get(ctx, []byte("tmp:1"))
+		assert.NotNil(t, err)
+	}
+
+	// Moving a value into a validated namespace validates it.
+	assert.Equal(t, client.ErrInvalidValue, lc.Copy(ctx, []byte("report"), []byte("users:1"), 0))
+	assert.Nil(t, lc.Set(ctx, []byte("tmp:2"), []byte(`{"name": "alice"}`), 0))
+	assert.Nil(t, lc.Rename(ctx, []byte("tmp:2"), []byte("users:1")))
+}
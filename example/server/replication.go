@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// DefaultReplicationBatchBytes is the size of the pending mutations that
+// triggers a flush if ReplicationBatchBytes is not set.
+const DefaultReplicationBatchBytes = 1 << 20
+
+// replicationQueue collects the mutations to forward to the members until
+// the next flush.
+type replicationQueue struct {
+	mu    sync.Mutex
+	cmds  []proto.Appender
+	sizes []int
+	bytes int
+
+	// pending holds the indexes in cmds of the mutations of each key that a
+	// SET of the key replaces when coalescing.
+	pending map[string][]int
+
+	// offset is the replication offset of the latest pending mutation.
+	offset uint64
+
+	// full is signalled once the pending mutations reach the batch size.
+	full chan struct{}
+
+	// batches and commands count the batches flushed and the mutations they
+	// carried, and coalesced the mutations replaced by a later SET.
+	batches   atomic.Uint64
+	commands  atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+// push queues a mutation of about size bytes, taking the next offset. With
+// coalesce, a SET replaces the pending mutations of its key.
+func (q *replicationQueue) push(cmd proto.Appender, size, batchBytes int, coalesce bool, offsets *offsetTracker) {
+	q.mu.Lock()
+	// The offset is taken under the lock, so a batch never carries the
+	// offset of a mutation that is not in it.
+	q.offset = offsets.next()
+	if coalesce {
+		q.coalesce(cmd)
+	}
+	q.cmds = append(q.cmds, cmd)
+	q.sizes = append(q.sizes, size)
+	q.bytes += size
+	full := q.bytes >= batchBytes
+	q.mu.Unlock()
+
+	if full {
+		select {
+		case q.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take returns the pending mutations and the offset of the latest of them,
+// and empties the queue.
+func (q *replicationQueue) take() ([]proto.Appender, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cmds := q.cmds[:0]
+	for _, cmd := range q.cmds {
+		if cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+	}
+	q.cmds, q.sizes, q.bytes = nil, nil, 0
+	clear(q.pending)
+	return cmds, q.offset
+}
+
+// coalesce drops the pending mutations of the key of a SET, which is then
+// the one pending. A RENAME or COPY of a key keeps the mutations before it.
+// The caller must hold q.mu.
+func (q *replicationQueue) coalesce(cmd proto.Appender) {
+	if q.pending == nil {
+		q.pending = make(map[string][]int)
+	}
+
+	inner := cmd
+	if at, ok := cmd.(*proto.CommandAt); ok {
+		inner = at.Command
+	}
+
+	var key []byte
+	switch v := inner.(type) {
+	case *proto.CommandSet:
+		for _, i := range q.pending[string(v.Key)] {
+			q.bytes -= q.sizes[i]
+			q.cmds[i] = nil
+			q.coalesced.Add(1)
+		}
+		q.pending[string(v.Key)] = append(q.pending[string(v.Key)][:0], len(q.cmds))
+		return
+	case *proto.CommandDel:
+		key = v.Key
+	case *proto.CommandTouch:
+		key = v.Key
+	case *proto.CommandAppend:
+		key = v.Key
+	case *proto.CommandXAdd:
+		key = v.Key
+	case *proto.CommandRename:
+		delete(q.pending, string(v.Key))
+		delete(q.pending, string(v.NewKey))
+		return
+	case *proto.CommandCopy:
+		delete(q.pending, string(v.Key))
+		delete(q.pending, string(v.Dst))
+		return
+	default:
+		return
+	}
+	q.pending[string(key)] = append(q.pending[string(key)], len(q.cmds))
+}
+
+// replicate forwards a mutation to the members, timed if it has a TTL:
+// batched with the others of the flush interval if ReplicationInterval is
+// set, on its own otherwise.
+// Either way it takes the next replication offset, unless there are no
+// members, which keeps a follower from counting the mutations it applies.
+func (s *Server) replicate(cmd proto.Appender) {
+	if s.MemberCount() == 0 {
+		return
+	}
+	cmd = timed(cmd, time.Now())
+	if s.ReplicationInterval <= 0 {
+		go s.forward(cmd, s.offsets.next())
+		return
+	}
+
+	// The size of the key and value plus the command byte, lengths and TTL.
+	size := 13
+	inner := cmd
+	if at, ok := cmd.(*proto.CommandAt); ok {
+		size += 9
+		inner = at.Command
+	}
+	switch v := inner.(type) {
+	case *proto.CommandSet:
+		size += len(v.Key) + len(v.Value)
+	case *proto.CommandDel:
+		size += len(v.Key)
+	case *proto.CommandTouch:
+		size += len(v.Key)
+	case *proto.CommandAppend:
+		size += len(v.Key) + len(v.Data)
+	case *proto.CommandRename:
+		size += len(v.Key) + len(v.NewKey)
+	case *proto.CommandCopy:
+		size += len(v.Key) + len(v.Dst)
+	case *proto.CommandXAdd:
+		size += len(v.Key) + len(v.Value) + 24
+	case *proto.CommandPublish:
+		size += len(v.Channel) + len(v.Message)
+	case *proto.CommandMulti:
+		for _, c := range v.Commands {
+			switch c := c.(type) {
+			case *proto.CommandSet:
+				size += 13 + len(c.Key) + len(c.Value)
+			case *proto.CommandDel:
+				size += 13 + len(c.Key)
+			}
+		}
+	}
+
+	batchBytes := s.ReplicationBatchBytes
+	if batchBytes <= 0 {
+		batchBytes = DefaultReplicationBatchBytes
+	}
+	s.replication.push(cmd, size, batchBytes, s.ReplicationCoalesce, &s.offsets)
+}
+
+// forward sends a single mutation with its offset to every member, as a
+// batch of one, dropping the members that fail. A member that does not have
+// the key of a TOUCH, APPEND, RENAME or COPY, or has the entry of an XADD
+// already, applies the batch without failing.
+func (s *Server) forward(cmd proto.Appender, offset uint64) {
+	for _, member := range s.replicaMembers(cmd) {
+		if err := member.Replicate(context.TODO(), offset, []proto.Appender{cmd}); err != nil {
+			log.Println("forward to member error:", err)
+			s.removeMember(member)
+		}
+	}
+}
+
+// replicationLoop flushes the pending mutations every ReplicationInterval,
+// or earlier once they reach the batch size, until the server is closed.
+func (s *Server) replicationLoop() {
+	ticker := time.NewTicker(s.ReplicationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case <-ticker.C:
+		case <-s.replication.full:
+		}
+		s.flushReplication()
+	}
+}
+
+// flushReplication sends the pending mutations to every member in a single
+// batch and waits for all of them to answer, so the batches reach each
+// member in order. Mutations made in the meantime make up the next batch,
+// which grows with the round trip to the slowest member.
+func (s *Server) flushReplication() {
+	cmds, offset := s.replication.take()
+	if len(cmds) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, member := range s.replicaMembers(cmds...) {
+		wg.Add(1)
+		go func(member *client.Client) {
+			defer wg.Done()
+			if err := member.Replicate(context.TODO(), offset, cmds); err != nil {
+				log.Println("replicate to member error:", err)
+				s.removeMember(member)
+			}
+		}(member)
+	}
+	wg.Wait()
+
+	s.replication.batches.Add(1)
+	s.replication.commands.Add(uint64(len(cmds)))
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicationBatching(t *testing.T) {
+	leader, c, err := StartEmbedded(ServerOpts{
+		IsLeader:            true,
+		ReplicationInterval: 50 * time.Millisecond,
+	}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		assert.Nil(t, c.Set(ctx, key, key, 0))
+	}
+	assert.Nil(t, c.Delete(ctx, []byte("key_0")))
+	assert.Nil(t, c.Touch(ctx, []byte("key_1"), time.Hour))
+	assert.Nil(t, c.Set(ctx, []byte("key_2"), []byte("last"), 0))
+	assert.Nil(t, c.Append(ctx, []byte("key_3"), []byte("+more")))
+
+	assert.Eventually(t, func() bool {
+		return leader.replication.commands.Load() == 104
+	}, time.Second, 10*time.Millisecond)
+	assert.Less(t, leader.replication.batches.Load(), uint64(10))
+
+	// The follower applied the mutations in order.
+	assert.False(t, cache.Has([]byte("key_0")))
+	value, err := cache.Get([]byte("key_2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("last"), value)
+	value, err = cache.Get([]byte("key_3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key_3+more"), value)
+	assert.Equal(t, 99, cache.Stats().Keys)
+}
+
+func TestReplicationBatchBytes(t *testing.T) {
+	leader, c, err := StartEmbedded(ServerOpts{
+		IsLeader:              true,
+		ReplicationInterval:   time.Hour,
+		ReplicationBatchBytes: 64,
+	}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// A batch is flushed once it reaches the size, long before the interval.
+	assert.Nil(t, c.Set(context.Background(), []byte("foo"), make([]byte, 64), 0))
+	assert.Eventually(t, func() bool {
+		return cache.Has([]byte("foo"))
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestReplicationCoalesce(t *testing.T) {
+	var (
+		q       replicationQueue
+		offsets offsetTracker
+	)
+	push := func(cmd proto.Appender) { q.push(cmd, 10, 1<<20, true, &offsets) }
+
+	push(&proto.CommandSet{Key: []byte("hot"), Value: []byte("1")})
+	push(&proto.CommandAppend{Key: []byte("hot"), Data: []byte("2")})
+	push(&proto.CommandSet{Key: []byte("cold"), Value: []byte("1")})
+	push(&proto.CommandSet{Key: []byte("hot"), Value: []byte("3")})
+	// A SET does not replace the mutations before a RENAME of its key.
+	push(&proto.CommandRename{Key: []byte("cold"), NewKey: []byte("moved")})
+	push(&proto.CommandSet{Key: []byte("cold"), Value: []byte("2")})
+	push(&proto.CommandSet{Key: []byte("hot"), Value: []byte("4")})
+
+	cmds, offset := q.take()
+	assert.Equal(t, []proto.Appender{
+		&proto.CommandSet{Key: []byte("cold"), Value: []byte("1")},
+		&proto.CommandRename{Key: []byte("cold"), NewKey: []byte("moved")},
+		&proto.CommandSet{Key: []byte("cold"), Value: []byte("2")},
+		&proto.CommandSet{Key: []byte("hot"), Value: []byte("4")},
+	}, cmds)
+	// The batch carries the offset of the latest mutation, coalesced or not.
+	assert.Equal(t, uint64(7), offset)
+	assert.Equal(t, uint64(3), q.coalesced.Load())
+	assert.Zero(t, q.bytes)
+
+	// Coalescing is per flush.
+	push(&proto.CommandSet{Key: []byte("hot"), Value: []byte("5")})
+	cmds, offset = q.take()
+	assert.Len(t, cmds, 1)
+	assert.Equal(t, uint64(8), offset)
+}
+
+func TestReplicationCoalesceHotKey(t *testing.T) {
+	leader, c, err := StartEmbedded(ServerOpts{
+		IsLeader:            true,
+		ReplicationInterval: 50 * time.Millisecond,
+		ReplicationCoalesce: true,
+	}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	for i := 0; i <= 100; i++ {
+		assert.Nil(t, c.Set(ctx, []byte("counter"), []byte(fmt.Sprint(i)), 0))
+	}
+
+	assert.Eventually(t, func() bool {
+		value, err := cache.Get([]byte("counter"))
+		return err == nil && string(value) == "100"
+	}, time.Second, 10*time.Millisecond)
+	assert.Greater(t, leader.replication.coalesced.Load(), uint64(50))
+	assert.Less(t, leader.replication.commands.Load(), uint64(50))
+}
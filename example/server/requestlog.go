@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// RequestLog samples the commands of the clients and logs each one sampled
+// as a line of JSON with its latency, sizes, status and client, for a view
+// of the traffic short of logging every command.
+type RequestLog struct {
+	// Every logs one of every Every commands, none if zero.
+	Every int
+	// Rates overrides Every for the commands it names, e.g. "SET", with the
+	// fraction of them logged, picked at random: 1 logs all of them and 0
+	// none.
+	Rates map[string]float64
+	// Writer receives the lines, the output of the standard logger if nil.
+	Writer io.Writer
+
+	n  atomic.Uint64
+	mu sync.Mutex
+}
+
+// RequestLogEntry is a line of the RequestLog.
+type RequestLogEntry struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Status  string    `json:"status"`
+	// LatencyMicros runs from when the command was read to when it was
+	// answered, including the time it was queued.
+	LatencyMicros int64  `json:"latency_us"`
+	RequestBytes  int64  `json:"request_bytes"`
+	ResponseBytes int64  `json:"response_bytes"`
+	ClientID      uint64 `json:"client_id"`
+	Addr          string `json:"addr"`
+	// Identity is that of ClientInfo.
+	Identity string `json:"identity"`
+}
+
+// sample reports whether the command of the name is logged.
+func (l *RequestLog) sample(name string) bool {
+	if rate, ok := l.Rates[name]; ok {
+		return rate > 0 && rand.Float64() < rate
+	}
+	return l.Every > 0 && l.n.Add(1)%uint64(l.Every) == 0
+}
+
+func (l *RequestLog) write(entry RequestLogEntry) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	w := l.Writer
+	if w == nil {
+		w = log.Writer()
+	}
+	// Lines of concurrent commands are not interleaved.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = w.Write(b)
+}
+
+// loggedConn is the connection a sampled command is answered on: it records
+// the size and the status of the response.
+type loggedConn struct {
+	net.Conn
+	bytes  int64
+	status proto.Status
+}
+
+func (c *loggedConn) Write(b []byte) (int, error) {
+	if c.bytes == 0 && len(b) != 0 {
+		c.status = proto.Status(b[0])
+	}
+	n, err := c.Conn.Write(b)
+	c.bytes += int64(n)
+	return n, err
+}
+
+// logRequest returns the connection to answer the command read at start on,
+// and the function logging it once answered, if the RequestLog samples it.
+// Otherwise it returns out as is and a nil function.
+func (s *Server) logRequest(ci *connInfo, out net.Conn, cmd any, start time.Time, size int64) (net.Conn, func()) {
+	if s.RequestLog == nil {
+		return out, nil
+	}
+	name := commandName(cmd)
+	if !s.RequestLog.sample(name) {
+		return out, nil
+	}
+
+	lc := &loggedConn{Conn: out}
+	return lc, func() {
+		info := ci.info(time.Now())
+		s.RequestLog.write(RequestLogEntry{
+			Time:          start,
+			Command:       name,
+			Status:        lc.status.String(),
+			LatencyMicros: time.Since(start).Microseconds(),
+			RequestBytes:  size,
+			ResponseBytes: lc.bytes,
+			ClientID:      info.ID,
+			Addr:          info.Addr,
+			Identity:      info.Identity,
+		})
+	}
+}
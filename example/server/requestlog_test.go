@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// entries returns the lines written so far.
+func (b *syncBuffer) entries(t *testing.T) []RequestLogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var entries []RequestLogEntry
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		var entry RequestLogEntry
+		assert.Nil(t, json.Unmarshal(sc.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRequestLog(t *testing.T) {
+	out := &syncBuffer{}
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader:   true,
+		RequestLog: &RequestLog{Every: 2, Rates: map[string]float64{"SET": 1, "DEL": 0}, Writer: out},
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// Every SET is logged, no DEL, and one GET in two.
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	for i := 0; i < 4; i++ {
+		_, _ = c.Get(ctx, []byte("foo"))
+	}
+	_, _ = c.Get(ctx, []byte("missing"))
+	_, _ = c.Get(ctx, []byte("missing"))
+	assert.Nil(t, c.Delete(ctx, []byte("foo")))
+
+	var entries []RequestLogEntry
+	assert.Eventually(t, func() bool {
+		entries = out.entries(t)
+		return len(entries) == 4
+	}, time.Second, 10*time.Millisecond)
+
+	set := entries[0]
+	assert.Equal(t, "SET", set.Command)
+	assert.Equal(t, "OK", set.Status)
+	assert.Equal(t, "operator", set.Identity)
+	assert.Greater(t, set.RequestBytes, int64(6))
+	assert.Equal(t, int64(1), set.ResponseBytes)
+	assert.NotEmpty(t, set.Addr)
+
+	assert.Equal(t, "GET", entries[1].Command)
+	assert.Greater(t, entries[1].ResponseBytes, int64(3))
+	assert.Equal(t, "KEYNOTFOUND", entries[3].Status)
+}
@@ -0,0 +1,39 @@
+//go:build linux || darwin || freebsd
+
+package server
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// listenReusePort binds n listeners to addr with SO_REUSEPORT. If addr has
+// port 0, the others bind the port the first one got.
+func listenReusePort(addr string, n int) ([]net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+
+	lns := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		ln, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			closeListeners(lns)
+			return nil, err
+		}
+		if i == 0 {
+			addr = ln.Addr().String()
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
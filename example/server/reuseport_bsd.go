@@ -0,0 +1,7 @@
+//go:build darwin || freebsd || (linux && (mips || mipsle || mips64 || mips64le))
+
+package server
+
+import "syscall"
+
+const soReusePort = syscall.SO_REUSEPORT
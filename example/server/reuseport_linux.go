@@ -0,0 +1,7 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package server
+
+// soReusePort is SO_REUSEPORT, which syscall does not define on every Linux
+// architecture.
+const soReusePort = 0xf
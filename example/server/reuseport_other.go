@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd)
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+func listenReusePort(string, int) ([]net.Listener, error) {
+	return nil, errors.New("accept loops require SO_REUSEPORT, which is not supported on this platform")
+}
@@ -0,0 +1,43 @@
+//go:build linux || darwin || freebsd
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptLoops(t *testing.T) {
+	s := NewServer(ServerOpts{ListenAddr: "127.0.0.1:0", IsLeader: true, AcceptLoops: 4}, ggcache.New())
+	go func() {
+		_ = s.Start()
+	}()
+	defer s.Close()
+
+	assert.Eventually(t, func() bool {
+		return s.Addr() != nil
+	}, time.Second, time.Millisecond)
+	s.mu.Lock()
+	assert.Len(t, s.lns, 3)
+	for _, ln := range s.lns {
+		assert.Equal(t, s.ln.Addr(), ln.Addr())
+	}
+	s.mu.Unlock()
+
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		c, err := client.New(s.Addr().String(), client.Options{})
+		assert.Nil(t, err)
+		assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+		assert.Nil(t, c.Close())
+	}
+
+	assert.Nil(t, s.Close())
+	_, err := client.New(s.Addr().String(), client.Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"sort"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+const (
+	// DefaultScanKeys is the number of keys of a page of SCAN if the command
+	// does not set it.
+	DefaultScanKeys = 1000
+
+	// maxScanKeys bounds the number of keys of a page of SCAN.
+	maxScanKeys = 100_000
+
+	// scanCheckKeys is the number of keys a SCAN visits between checks of
+	// its deadline.
+	scanCheckKeys = 1024
+)
+
+func (s *Server) handleScanCommand(ctx context.Context, conn net.Conn, cmd *proto.CommandScan) error {
+	resp := proto.ResponseScan{Status: proto.StatusOK}
+	count := int(min(cmd.Count, maxScanKeys))
+	if count == 0 {
+		count = DefaultScanKeys
+	}
+	keys, err := s.scan(ctx, cmd.Pattern, cmd.After, count)
+	if err != nil {
+		resp.Status = proto.StatusError
+		if s.inflight.expire(ctx, err) {
+			resp.Status = expiredStatus(ctx)
+		} else {
+			log.Println("scan error:", err)
+		}
+		return proto.WriteMessage(conn, &resp)
+	}
+	resp.Keys = keys
+	return proto.WriteMessage(conn, &resp)
+}
+
+// scan returns the first count keys matching the pattern that sort after
+// after. Every key is visited, but only twice count of them are held at a
+// time. It stops with the error of ctx once ctx is done.
+func (s *Server) scan(ctx context.Context, pattern, after []byte, count int) ([][]byte, error) {
+	scanner, ok := s.cache.(ggcache.Scanner)
+	if !ok {
+		return nil, errNoScan
+	}
+
+	// Once a page is full, bound is its last key, and only the keys before
+	// it can make it into the page.
+	var keys [][]byte
+	var bound []byte
+	trim := func() {
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		if len(keys) > count {
+			keys = keys[:count]
+			bound = keys[count-1]
+		}
+	}
+	var (
+		visited int
+		err     error
+	)
+	scanner.Scan(pattern, func(key []byte) bool {
+		if visited++; visited%scanCheckKeys == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		if bytes.Compare(key, after) <= 0 || bound != nil && bytes.Compare(key, bound) >= 0 {
+			return true
+		}
+		keys = append(keys, bytes.Clone(key))
+		if len(keys) == 2*count {
+			trim()
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	trim()
+	return keys, nil
+}
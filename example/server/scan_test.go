@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScan(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, c.Set(ctx, []byte(fmt.Sprintf("users:%02d", i)), []byte("x"), 0))
+		assert.Nil(t, c.Set(ctx, []byte(fmt.Sprintf("orders:%02d", i)), []byte("x"), 0))
+	}
+
+	// The pages walk the matching keys in order.
+	var (
+		got   []string
+		after []byte
+	)
+	for {
+		keys, err := c.Scan(ctx, "users:*", after, 7)
+		assert.Nil(t, err)
+		if len(keys) == 0 {
+			break
+		}
+		assert.LessOrEqual(t, len(keys), 7)
+		for _, key := range keys {
+			got = append(got, string(key))
+		}
+		after = keys[len(keys)-1]
+	}
+	assert.Len(t, got, 50)
+	for i, key := range got {
+		assert.Equal(t, fmt.Sprintf("users:%02d", i), key)
+	}
+
+	keys, err := c.Scan(ctx, "*", nil, 0)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 100)
+	assert.Equal(t, []byte("orders:00"), keys[0])
+}
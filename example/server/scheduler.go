@@ -0,0 +1,192 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// Priority is the class a command is queued under when the server runs a
+// fixed number of Workers.
+type Priority int
+
+const (
+	// PriorityReplication is the class of the mutations forwarded by the
+	// leader, which keep the follower in step with it.
+	PriorityReplication Priority = iota
+	// PriorityClient is the class of the reads and writes of the clients.
+	PriorityClient
+	// PriorityBackground is the class of the commands no client waits on
+	// for its latency: STATS, BACKUP, FLUSH, SCAN, UNDELETE and PURGE.
+	PriorityBackground
+
+	numPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityReplication:
+		return "replication"
+	case PriorityClient:
+		return "client"
+	case PriorityBackground:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+// PriorityWeights are the shares of the workers each class gets while all
+// of them have commands queued. A class without queued commands leaves its
+// share to the others. Zero weights default to DefaultPriorityWeights.
+type PriorityWeights struct {
+	Replication int
+	Client      int
+	Background  int
+}
+
+// DefaultPriorityWeights has the workers serve 8 forwarded mutations and 4
+// client commands for every background command.
+var DefaultPriorityWeights = PriorityWeights{Replication: 8, Client: 4, Background: 1}
+
+// priority returns the class of a command of the tenant.
+func priority(t *tenant, cmd any) Priority {
+	if t == upstream {
+		return PriorityReplication
+	}
+	switch cmd.(type) {
+	case *proto.CommandStats, *proto.CommandBackup, *proto.CommandFlush, *proto.CommandScan,
+		*proto.CommandUndelete, *proto.CommandPurge:
+		return PriorityBackground
+	default:
+		return PriorityClient
+	}
+}
+
+// scheduler queues the commands per class for the workers, which take them
+// by smooth weighted round robin: every pick credits each class with queued
+// commands its weight and takes from the one with the most credit, which
+// then pays back the weights of all of them. Classes are served in
+// proportion to their weights, interleaved rather than in runs.
+type scheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	weights [numPriorities]int
+	credit  [numPriorities]int
+	queues  [numPriorities][]func()
+	closed  bool
+}
+
+func newScheduler(weights PriorityWeights) *scheduler {
+	q := &scheduler{
+		weights: [numPriorities]int{weights.Replication, weights.Client, weights.Background},
+	}
+	defaults := [numPriorities]int{
+		DefaultPriorityWeights.Replication,
+		DefaultPriorityWeights.Client,
+		DefaultPriorityWeights.Background,
+	}
+	for p, w := range q.weights {
+		if w <= 0 {
+			q.weights[p] = defaults[p]
+		}
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues the job under the class. Jobs pushed after close are run by
+// the workers still draining the queues, or not at all.
+func (q *scheduler) push(p Priority, job func()) {
+	q.mu.Lock()
+	q.queues[p] = append(q.queues[p], job)
+	q.mu.Unlock()
+
+	q.cond.Signal()
+}
+
+// next waits for a job and returns it, or false once the scheduler is closed
+// and the queues are drained.
+func (q *scheduler) next() (func(), bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if job := q.pick(); job != nil {
+			return job, true
+		}
+		if q.closed {
+			return nil, false
+		}
+		q.cond.Wait()
+	}
+}
+
+// pick dequeues the next job, nil if the queues are empty.
+// The caller must hold q.mu.
+func (q *scheduler) pick() func() {
+	best, total := -1, 0
+	for p := range q.queues {
+		if len(q.queues[p]) == 0 {
+			// Credit only carries over while a class is backlogged.
+			q.credit[p] = 0
+			continue
+		}
+		q.credit[p] += q.weights[p]
+		total += q.weights[p]
+		if best < 0 || q.credit[p] > q.credit[best] {
+			best = p
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	q.credit[best] -= total
+
+	job := q.queues[best][0]
+	q.queues[best][0] = nil
+	q.queues[best] = q.queues[best][1:]
+	return job
+}
+
+// queued returns the number of jobs waiting per class.
+func (q *scheduler) queued() [numPriorities]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n [numPriorities]int
+	for p := range q.queues {
+		n[p] = len(q.queues[p])
+	}
+	return n
+}
+
+// close has the workers return once the queues are drained.
+func (q *scheduler) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	q.cond.Broadcast()
+}
+
+// work runs the queued jobs until the scheduler is closed.
+func (q *scheduler) work() {
+	for {
+		job, ok := q.next()
+		if !ok {
+			return
+		}
+		job()
+	}
+}
+
+// dispatch runs the command job in its own goroutine, or queues it for the
+// workers under the class of the command if Workers is set.
+func (s *Server) dispatch(p Priority, job func()) {
+	if s.scheduler == nil {
+		go job()
+		return
+	}
+	s.scheduler.push(p, job)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerWeights(t *testing.T) {
+	q := newScheduler(PriorityWeights{})
+
+	var served []Priority
+	for p := Priority(0); p < numPriorities; p++ {
+		for i := 0; i < 20; i++ {
+			p := p
+			q.push(p, func() { served = append(served, p) })
+		}
+	}
+
+	// While every class is backlogged they are served 8:4:1, interleaved.
+	for i := 0; i < 13; i++ {
+		job, ok := q.next()
+		assert.True(t, ok)
+		job()
+	}
+	counts := map[Priority]int{}
+	for _, p := range served {
+		counts[p]++
+	}
+	assert.Equal(t, map[Priority]int{PriorityReplication: 8, PriorityClient: 4, PriorityBackground: 1}, counts)
+	assert.NotEqual(t, []Priority{0, 0, 0, 0, 0, 0, 0, 0}, served[:8])
+
+	// The background commands take the whole share of a drained class, and
+	// are all served once the scheduler is closed.
+	q.close()
+	for {
+		job, ok := q.next()
+		if !ok {
+			break
+		}
+		job()
+	}
+	assert.Len(t, served, 60)
+	assert.Equal(t, [numPriorities]int{}, q.queued())
+}
+
+func TestWorkers(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, Workers: 1}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		assert.Nil(t, c.Set(ctx, key, key, time.Minute))
+		value, err := c.Get(ctx, key)
+		assert.Nil(t, err)
+		assert.Equal(t, key, value)
+	}
+
+	stats, err := c.Stats(ctx)
+	assert.Nil(t, err)
+	assert.Contains(t, stats, "server_queued_client_commands")
+	assert.Contains(t, stats, "server_queued_background_commands")
+}
@@ -0,0 +1,1549 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+type ServerOpts struct {
+	ListenAddr  string
+	IsLeader    bool
+	LeaderAddr  string
+	TLSConfig   *tls.Config
+	AllowedNets []*net.IPNet
+
+	// Dial, if set, opens the connections to the leader in place of
+	// net.Dialer.DialContext, e.g. through a proxy or over an in-memory
+	// network in tests. They are wrapped in TLS if TLSConfig is set.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Discovery, if set, replaces the static IsLeader/LeaderAddr setup: the
+	// server periodically resolves its peers, treats the lowest address as
+	// the leader and follows it. An Elector takes precedence over the lowest
+	// address rule. AdvertiseAddr is how peers reach this node and is used to
+	// recognise itself as the leader.
+	Discovery         Discovery
+	Elector           Elector
+	DiscoveryInterval time.Duration
+	AdvertiseAddr     string
+
+	// Registry, if set, has this node (re-)registered with its current role
+	// every DiscoveryInterval and deregistered when the server is closed.
+	Registry Registry
+
+	// Filler, if set, answers FILL requests from peers, usually a
+	// *ggcache.Group.
+	Filler Filler
+
+	// Backups, if set, uploads snapshots of the cache every Backups.Interval
+	// and on BACKUP requests.
+	Backups *Backups
+
+	// AdminAddr, if set, is the listen address of the admin HTTP listener
+	// serving AdminHandler. ExpvarName, if set, publishes the server stats
+	// as an expvar under that name.
+	AdminAddr  string
+	ExpvarName string
+
+	// OTLP, if set, pushes the server stats and command latencies to an
+	// OpenTelemetry collector every OTLP.Interval.
+	OTLP *OTLP
+
+	// RemovalWebhook, if set, posts the keys the cache expires or evicts to
+	// an HTTP endpoint.
+	RemovalWebhook *RemovalWebhook
+
+	// RequestLog, if set, logs a sample of the commands served.
+	RequestLog *RequestLog
+
+	// NamespaceSeparator, if set, splits keys into a namespace and a name at
+	// its first occurrence, e.g. ":" for "users:42", and has the commands
+	// counted per namespace in the stats API.
+	NamespaceSeparator string
+
+	// StatsRetention, if set, has the stats sampled every minute and kept
+	// for that long, queryable on the admin listener.
+	StatsRetention time.Duration
+
+	// WebSocketAddr, if set, is the listen address of the WebSocket gateway
+	// serving WebSocketHandler. WebSocketOrigins restricts the browser
+	// origins allowed to connect, "*" allows any.
+	WebSocketAddr    string
+	WebSocketOrigins []string
+
+	// UDPAddr, if set, is the listen address of the UDP listener taking
+	// best-effort SETs without a response and GETs of values up to
+	// UDPMaxValue bytes, DefaultUDPMaxValue if zero.
+	UDPAddr     string
+	UDPMaxValue int
+
+	// ReplicationInterval, if set, batches the mutations forwarded to the
+	// members: they are sent in one frame per member every
+	// ReplicationInterval, or as soon as ReplicationBatchBytes of them are
+	// pending, DefaultReplicationBatchBytes if zero. Otherwise each mutation
+	// is forwarded on its own.
+	ReplicationInterval   time.Duration
+	ReplicationBatchBytes int
+
+	// ReplicationCoalesce has the batches forward only the last SET of a key
+	// made within a ReplicationInterval, in place of the earlier mutations
+	// of the key, saving the bandwidth of hot keys rewritten many times
+	// between flushes. The writes are still applied locally one by one.
+	ReplicationCoalesce bool
+
+	// LeaseTTL is how long a lease granted on a GETLEASE miss is held before
+	// another client can be granted one, DefaultLeaseTTL if zero.
+	LeaseTTL time.Duration
+
+	// Tenants, if set, requires every connection to authenticate as one of
+	// them with an AUTH command before sending any other, and scopes its keys
+	// to the namespace of the tenant. AuthToken is the token this node
+	// authenticates to its leader with, which must be that of a tenant
+	// without a namespace. The UDP and WebSocket listeners do not
+	// authenticate and should not be enabled along with tenants. The tokens
+	// are held by the TokenStore returned by Tokens, so they can be rotated
+	// while the server runs.
+	Tenants   []Tenant
+	AuthToken string
+
+	// Authenticator, if set, verifies the AUTH tokens in place of the tokens
+	// of Tenants, e.g. HMACTokens or HTTPAuth, and every connection must
+	// authenticate as with Tenants.
+	Authenticator Authenticator
+
+	// ClusterSecret, if set, is required of the followers joining this
+	// node, which is sent along with their JOIN, so a client that is not a
+	// node of the cluster cannot receive its replication stream, and of
+	// the PROMOTEs that change its leader. Unlike the tokens of the tenants
+	// it only authenticates nodes to each other, and must be the same on
+	// every node.
+	ClusterSecret string
+
+	// RedirectWrites makes a follower answer the writes of its clients with
+	// StatusMoved and the address of its leader, or StatusRetry while it is
+	// connecting to it, instead of applying them locally where they are not
+	// replicated.
+	RedirectWrites bool
+
+	// ReadAddr, if set, makes a follower a read-only replica: it redirects
+	// writes like RedirectWrites and advertises ReadAddr as its read endpoint
+	// to its leader, which lists it in its TOPOLOGY responses so clients
+	// route their reads to it.
+	ReadAddr string
+
+	// Zone, if set, is the availability zone of the node, which a follower
+	// reports to its leader so that the TOPOLOGY responses list the zone of
+	// each replica and clients can prefer the replicas of their own zone.
+	Zone string
+
+	// PersistenceFailure is what the server does while its backups fail or
+	// its Cacher cannot persist its writes, PersistenceReadOnly if empty.
+	PersistenceFailure PersistencePolicy
+
+	// Workers, if set, is the number of commands handled at once. The others
+	// are queued by Priority, and the workers take them in proportion to
+	// PriorityWeights so that background commands cannot starve the clients
+	// or replication, whatever their volume. A command holds its worker
+	// until it is answered, including a FILL waiting on its loader or a
+	// BACKUP uploading. Otherwise every command is handled in its own
+	// goroutine as soon as it is read.
+	Workers         int
+	PriorityWeights PriorityWeights
+
+	// MaxHandlers and MaxInflightBytes, if set, bound the commands of the
+	// clients handled or queued at once, and the size of their requests.
+	// The commands over either are answered with StatusOverloaded without
+	// being handled, so the node degrades predictably under load it cannot
+	// take instead of running out of goroutines or memory. The mutations
+	// forwarded by the leader and PINGs are never shed.
+	MaxHandlers      int
+	MaxInflightBytes int64
+
+	// MaxFieldSize, if set, is the largest key or value of the commands
+	// read, proto.DefaultMaxFieldSize if zero. The connection sending a
+	// larger one is closed before the field is allocated, as the bytes of
+	// a command only count towards MaxInflightBytes once it is read.
+	MaxFieldSize int
+
+	// MGetBudget, if set, bounds the time spent reading the keys of an MGET.
+	// Past it the node answers with the values of the keys read so far,
+	// flagged as truncated, so a huge batch read cannot hold a handler for
+	// long. The client can read the other keys with another MGET.
+	MGetBudget time.Duration
+
+	// CommandTimeouts, if set, bound the time the commands named by the
+	// keys, as listed by the clients API (e.g. "SCAN"), run for once they
+	// start. Past it the node stops the command and answers it with
+	// StatusTimeout, releasing the locks it held, so a pathological request
+	// cannot hold the node. Only the commands that walk many keys or wait
+	// on others stop early: SCAN, MGET, FLUSH, PURGE and FILL.
+	CommandTimeouts map[string]time.Duration
+
+	// Validators, if set, check the values written to the namespaces they
+	// are keyed by before they are stored, rejecting the others with
+	// StatusInvalidValue. Keys are split into namespaces at
+	// NamespaceSeparator, ":" if it is not set, and keys without one fall
+	// under "_default". Appends to a validated namespace are rejected.
+	Validators map[string]Validator
+
+	// FullSync has the leader stream a snapshot of its cache to the members
+	// that join, in chunks of SyncChunkBytes, DefaultSyncChunkBytes if zero,
+	// at up to SyncBandwidth bytes per second, unthrottled if zero. The
+	// mutations made in the meantime are held and forwarded once the member
+	// restored it. Otherwise a member only gets the mutations made after it
+	// joined. The cache of both must be a ggcache.Snapshotter.
+	FullSync       bool
+	SyncBandwidth  int64
+	SyncChunkBytes int
+
+	// FlushBatchKeys and FlushBatchInterval throttle the FLUSH of a pattern:
+	// its keys are deleted FlushBatchKeys at a time, DefaultFlushBatchKeys if
+	// zero, with a pause of FlushBatchInterval between the batches,
+	// DefaultFlushBatchInterval if zero.
+	FlushBatchKeys     int
+	FlushBatchInterval time.Duration
+
+	// DeleteRetention, if set, keeps the values of the keys deleted by DEL
+	// and FLUSH, or by the replicated deletes of a follower, as tombstones
+	// for that long, so UNDELETE can restore them. They take memory on top
+	// of the cache until then.
+	DeleteRetention time.Duration
+
+	// SessionWait is how long a follower holds a read of a session, sent
+	// with the replication offset of its latest write, until it applied the
+	// mutations up to it. A read it did not catch up with in time is
+	// redirected to the leader, right away if SessionWait is zero. The
+	// guarantee is only exact with ReplicationInterval set, as the
+	// mutations are otherwise forwarded concurrently and may be applied out
+	// of order.
+	SessionWait time.Duration
+
+	// ClockSyncInterval is how often the leader sends its clock to the
+	// members, DefaultClockSyncInterval if zero. The mutations it replicates
+	// carry the time they were made on its clock, and each member expires
+	// them by its offset from that clock, so entries expire at about the
+	// same time on every node even if their clocks drift apart.
+	ClockSyncInterval time.Duration
+
+	// HighMemoryBytes, if set, is the size of the heap above which the node
+	// reports proto.HealthHighMemory with its responses, so clients move
+	// their reads elsewhere before it runs out of memory.
+	HighMemoryBytes int64
+
+	// SlowStart, if set, is how long the weight the leader advertises in
+	// its TOPOLOGY for a member that just started serving takes to ramp up
+	// from 0 to 100%, so the Cluster clients move the reads to a cold node
+	// gradually rather than hitting it with its full share of misses at
+	// once. The members rejoining after a change of leader keep the time
+	// they have been serving.
+	SlowStart time.Duration
+
+	// OnReady, if set, is called with the cache once the listeners are up
+	// and before the server accepts connections or follows its leader, e.g.
+	// to fill it with WarmAll so the node comes up warm. The server does not
+	// start if it returns an error. Its writes are not replicated: every node
+	// is expected to warm itself.
+	OnReady func(c ggcache.Cacher) error
+
+	// AcceptLoops, if more than one, has Start bind that many listeners to
+	// ListenAddr with SO_REUSEPORT, each accepted from in its own goroutine,
+	// so the kernel spreads the incoming connections over them. It is only
+	// supported on Linux, macOS and FreeBSD, and ignored by Serve.
+	AcceptLoops int
+
+	// HandoffPath, if set, is the path of a unix socket this node hands its
+	// dataset over to the process replacing it on the same host, e.g. on a
+	// deploy, instead of having it resync over the network. A starting node
+	// takes the dataset of the process listening there, if any, which then
+	// closes. The cache must implement ggcache.Snapshotter.
+	HandoffPath string
+}
+
+// Filler returns the value of a key this node owns, loading it if needed.
+type Filler interface {
+	Fill(ctx context.Context, key []byte) ([]byte, error)
+}
+
+type Server struct {
+	ServerOpts
+
+	mu      sync.Mutex
+	ln      net.Listener
+	conns   map[net.Conn]*connInfo
+	connIDs uint64
+	members map[*client.Client]string
+	zones   map[*client.Client]string
+	// serving is when each member started serving reads.
+	serving map[*client.Client]time.Time
+	closed  bool
+	quitch  chan struct{}
+	started time.Time
+
+	// lns are the listeners of the other accept loops when AcceptLoops is
+	// set, ln being the first one.
+	lns []net.Listener
+
+	// leader is the address of the node we currently follow and leaderConn
+	// our connection to it. Both are empty on the leader itself.
+	leader     string
+	leaderConn net.Conn
+
+	// admin is the admin HTTP server, nil unless AdminAddr is set.
+	admin *http.Server
+
+	// metrics records the latency of the commands served and namespaces
+	// counts them by key namespace.
+	metrics    commandMetrics
+	namespaces namespaceMetrics
+
+	// history holds the stats samples taken over StatsRetention.
+	history statsHistory
+
+	// websocket is the WebSocket gateway, nil unless WebSocketAddr is set,
+	// and events feeds its subscribers.
+	websocket *http.Server
+	events    keyspaceEvents
+
+	// udp is the UDP listener, nil unless UDPAddr is set.
+	udp net.PacketConn
+
+	// handoff listens for the process replacing this one, nil unless
+	// HandoffPath is set. handingOff holds serve until the dataset is sent.
+	handoff    *net.UnixListener
+	handingOff sync.WaitGroup
+
+	// replication holds the mutations waiting to be forwarded to the members
+	// when ReplicationInterval is set.
+	replication replicationQueue
+
+	// leases holds the leases granted on GETLEASE misses.
+	leases leaseTable
+
+	// tenants holds the tenants connections authenticate as.
+	tenants tenantTable
+
+	// inflight holds the commands in progress that can be cancelled.
+	inflight inflightTable
+
+	// persistence tracks the failures of the backups.
+	persistence persistenceState
+
+	// scheduler queues the commands for the workers, nil unless Workers is
+	// set.
+	scheduler *scheduler
+
+	// limits sheds the commands over MaxHandlers and MaxInflightBytes.
+	limits loadLimits
+
+	// syncing holds the mutations for the members that are being sent a
+	// snapshot, and syncs the snapshot this node is receiving.
+	syncing map[*client.Client][]proto.Appender
+	syncs   syncState
+
+	// streams serializes the writes of streams and holds the blocked XREADs.
+	streams streamTable
+
+	// channels holds the connections subscribed to pub/sub channels.
+	channels channelTable
+
+	// tombstones holds the deleted values kept for DeleteRetention.
+	tombstones tombstoneTable
+
+	// offsets is the replication offset the sessions read at.
+	offsets offsetTracker
+
+	// noReplies counts the writes sent without asking for a response.
+	noReplies noReplyStats
+
+	// clock is the offset of the clock of a follower from its leader.
+	clock clockState
+
+	// health is the proto.Health of the server, refreshed by healthLoop.
+	health atomic.Uint32
+
+	// webhook queues the removals for RemovalWebhook.
+	webhook webhookState
+
+	// promoted is set on a follower promoted to leader, guarded by mu, and
+	// demoted on a leader demoted to follower, which redirects its writes.
+	promoted bool
+	demoted  atomic.Bool
+
+	cache ggcache.Cacher
+}
+
+func NewServer(opts ServerOpts, c ggcache.Cacher) *Server {
+	s := &Server{
+		ServerOpts: opts,
+		cache:      c,
+		conns:      make(map[net.Conn]*connInfo),
+		members:    make(map[*client.Client]string),
+		zones:      make(map[*client.Client]string),
+		serving:    make(map[*client.Client]time.Time),
+		syncing:    make(map[*client.Client][]proto.Appender),
+		quitch:     make(chan struct{}),
+		started:    time.Now(),
+		replication: replicationQueue{
+			full: make(chan struct{}, 1),
+		},
+		tenants: newTenantTable(opts.Authenticator, opts.Tenants, opts.NamespaceSeparator),
+		limits: loadLimits{
+			maxHandlers: int64(opts.MaxHandlers),
+			maxBytes:    opts.MaxInflightBytes,
+		},
+	}
+	if opts.Workers > 0 {
+		s.scheduler = newScheduler(opts.PriorityWeights)
+	}
+	return s
+}
+
+func (s *Server) Start() error {
+	if err := s.receiveHandoff(); err != nil {
+		return err
+	}
+
+	if s.AcceptLoops > 1 {
+		lns, err := listenReusePort(s.ListenAddr, s.AcceptLoops)
+		if err != nil {
+			return fmt.Errorf("listen error: %s", err)
+		}
+
+		log.Printf("server starting on port [%s] with %d accept loops\n", s.ListenAddr, len(lns))
+
+		return s.serve(lns)
+	}
+
+	ln, err := net.Listen("tcp", s.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("listen error: %s", err)
+	}
+
+	log.Printf("server starting on port [%s]\n", s.ListenAddr)
+
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until the server is closed. It can be used
+// instead of Start when the caller owns the listener, e.g. to bind port 0.
+func (s *Server) Serve(ln net.Listener) error {
+	if err := s.receiveHandoff(); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	return s.serve([]net.Listener{ln})
+}
+
+// serve accepts connections on every listener, each in its own loop, until
+// the server is closed.
+func (s *Server) serve(lns []net.Listener) error {
+	if s.TLSConfig != nil {
+		for i, ln := range lns {
+			lns[i] = tls.NewListener(ln, s.TLSConfig)
+		}
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		closeListeners(lns)
+		return net.ErrClosed
+	}
+	s.ln, s.lns = lns[0], lns[1:]
+	s.mu.Unlock()
+
+	if len(s.ExpvarName) != 0 {
+		if err := s.PublishExpvar(s.ExpvarName); err != nil {
+			log.Println(err)
+		}
+	}
+	if len(s.AdminAddr) != 0 {
+		if err := s.serveAdmin(); err != nil {
+			closeListeners(lns)
+			return err
+		}
+	}
+	if len(s.WebSocketAddr) != 0 {
+		if err := s.serveWebSocket(); err != nil {
+			closeListeners(lns)
+			return err
+		}
+	}
+	if len(s.UDPAddr) != 0 {
+		if err := s.serveUDP(); err != nil {
+			closeListeners(lns)
+			return err
+		}
+	}
+
+	if len(s.HandoffPath) != 0 {
+		if err := s.serveHandoff(); err != nil {
+			closeListeners(lns)
+			return err
+		}
+	}
+	if s.RemovalWebhook != nil {
+		if err := s.watchRemovals(); err != nil {
+			closeListeners(lns)
+			return err
+		}
+	}
+
+	if s.OnReady != nil {
+		if err := s.OnReady(s.cache); err != nil {
+			closeListeners(lns)
+			return fmt.Errorf("on ready: %w", err)
+		}
+	}
+
+	if s.Discovery == nil && s.Elector == nil && !s.IsLeader && len(s.LeaderAddr) != 0 {
+		s.follow(s.LeaderAddr)
+	}
+	if s.Discovery != nil || s.Elector != nil || s.Registry != nil {
+		go s.membershipLoop()
+	}
+	if s.Backups != nil && s.Backups.Interval > 0 {
+		go s.backupLoop()
+	}
+	if s.OTLP != nil {
+		go s.otlpLoop()
+	}
+	if s.StatsRetention > 0 {
+		go s.historyLoop()
+	}
+	if s.ReplicationInterval > 0 {
+		go s.replicationLoop()
+	}
+	go s.clockLoop()
+	s.refreshHealth()
+	go s.healthLoop()
+	if s.scheduler != nil {
+		for i := 0; i < s.Workers; i++ {
+			go s.scheduler.work()
+		}
+	}
+
+	for _, ln := range lns[1:] {
+		go s.accept(ln)
+	}
+	err := s.accept(lns[0])
+	// The process must not exit before it handed off its dataset.
+	s.handingOff.Wait()
+	return err
+}
+
+// accept accepts connections on ln until it is closed.
+func (s *Server) accept(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("accept error: %s\n", err)
+			continue
+		}
+		if !s.isAllowed(conn.RemoteAddr()) {
+			log.Printf("rejected connection from [%s]\n", conn.RemoteAddr())
+			_ = conn.Close()
+			continue
+		}
+		go s.handleConn(conn, s.anonymous())
+	}
+}
+
+func closeListeners(lns []net.Listener) {
+	for _, ln := range lns {
+		_ = ln.Close()
+	}
+}
+
+// Addr returns the address the server is listening on, or nil if it is not
+// serving yet.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Close stops accepting connections and closes every open connection,
+// including the ones to cluster members.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.quitch)
+
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	closeListeners(s.lns)
+	if s.admin != nil {
+		_ = s.admin.Close()
+	}
+	if s.websocket != nil {
+		_ = s.websocket.Close()
+	}
+	if s.udp != nil {
+		_ = s.udp.Close()
+	}
+	if s.handoff != nil {
+		_ = s.handoff.Close()
+	}
+	if s.scheduler != nil {
+		s.scheduler.close()
+	}
+	s.tombstones.stop()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	for member := range s.members {
+		_ = member.Close()
+	}
+
+	return err
+}
+
+// trackConn registers a connection served over the protocol as the tenant,
+// returning nil if the server is closed.
+func (s *Server) trackConn(conn net.Conn, protocol string, t *tenant) *connInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.connIDs++
+	now := time.Now()
+	ci := &connInfo{
+		id:         s.connIDs,
+		conn:       conn,
+		protocol:   protocol,
+		started:    now,
+		tenant:     t,
+		lastActive: now,
+	}
+	s.conns[conn] = ci
+	return ci
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+func (s *Server) isAllowed(addr net.Addr) bool {
+	if len(s.AllowedNets) == 0 {
+		return true
+	}
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return false
+	}
+	for _, ipnet := range s.AllowedNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// follow makes the server a follower of the leader at addr, dropping the
+// connection to any previous leader. An empty addr makes the server the
+// leader itself.
+func (s *Server) follow(addr string) {
+	s.mu.Lock()
+	if s.closed || s.leader == addr {
+		s.mu.Unlock()
+		return
+	}
+	s.leader = addr
+	if s.leaderConn != nil {
+		_ = s.leaderConn.Close()
+		s.leaderConn = nil
+	}
+	s.mu.Unlock()
+
+	if len(addr) == 0 {
+		log.Println("acting as leader")
+		return
+	}
+
+	go func() {
+		if err := s.dialLeader(addr); err != nil {
+			log.Println(err)
+		}
+	}()
+}
+
+// dial opens a connection to the node at addr with Dial, or net.Dial.
+func (s *Server) dial(addr string) (net.Conn, error) {
+	if s.Dial == nil {
+		if s.TLSConfig != nil {
+			return tls.Dial("tcp", addr, s.TLSConfig)
+		}
+		return net.Dial("tcp", addr)
+	}
+
+	conn, err := s.Dial(context.Background(), "tcp", addr)
+	if err != nil || s.TLSConfig == nil {
+		return conn, err
+	}
+	config := s.TLSConfig
+	if len(config.ServerName) == 0 {
+		// As tls.Dial does, verify the name the node was dialed by.
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	return tls.Client(conn, config), nil
+}
+
+// authenticate authenticates the connection to the node at addr with
+// AuthToken, if set.
+func (s *Server) authenticate(conn net.Conn, addr string) error {
+	if len(s.AuthToken) == 0 {
+		return nil
+	}
+	if err := proto.WriteMessage(conn, &proto.CommandAuth{Token: []byte(s.AuthToken)}); err != nil {
+		return err
+	}
+	resp, err := proto.ParseAuthResponse(conn)
+	if err != nil {
+		return err
+	}
+	if resp.Status != proto.StatusOK {
+		return fmt.Errorf("node [%s] rejected the auth token: %s", addr, resp.Status)
+	}
+	return nil
+}
+
+func (s *Server) dialLeader(addr string) error {
+	conn, err := s.dial(addr)
+	if err != nil {
+		return fmt.Errorf("failed to dial leader [%s]", addr)
+	}
+
+	s.mu.Lock()
+	if s.closed || s.leader != addr {
+		s.mu.Unlock()
+		_ = conn.Close()
+		return nil
+	}
+	s.leaderConn = conn
+	s.mu.Unlock()
+
+	log.Println("connected to leader:", addr)
+
+	if err = s.authenticate(conn, addr); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	if err = proto.WriteMessage(conn, &proto.CommandJoin{ReadAddr: s.ReadAddr, Zone: s.Zone, Secret: s.ClusterSecret, Serving: time.Since(s.started)}); err != nil {
+		return err
+	}
+
+	// The leader forwards its mutations over this connection, so they are
+	// trusted without authentication.
+	s.handleConn(conn, upstream)
+
+	// Forget the leader so the next discovery pass dials it again.
+	s.mu.Lock()
+	if s.leaderConn == conn {
+		s.leader = ""
+		s.leaderConn = nil
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// handleConn serves the commands of the connection, authenticated as t until
+// it sends an AUTH command.
+func (s *Server) handleConn(conn net.Conn, t *tenant) {
+	ci := s.trackConn(conn, "binary", t)
+	if ci == nil {
+		_ = conn.Close()
+		return
+	}
+	joined := false
+	defer func(conn net.Conn) {
+		s.inflight.cancelConn(conn)
+		s.untrackConn(conn)
+		ci.endCapture()
+		if !joined {
+			_ = conn.Close()
+		}
+	}(conn)
+
+	//fmt.Println("connection made:", conn.RemoteAddr())
+
+	r := &countingReader{r: conn, max: s.MaxFieldSize}
+	for {
+		read := r.n
+		cmd, err := proto.ParseCommand(r)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			log.Println("parse command error:", err)
+			break
+		}
+		ci.captured(cmd)
+		readAt := time.Now()
+		var deadline time.Time
+		if dl, ok := cmd.(*proto.CommandDeadline); ok {
+			// The deadline runs from now rather than by the clock of the
+			// client.
+			cmd, deadline = dl.Command, readAt.Add(dl.Timeout)
+		}
+		var (
+			session bool
+			offset  uint64
+		)
+		if oc, ok := cmd.(*proto.CommandOffset); ok {
+			cmd, offset, session = oc.Command, oc.Offset, true
+		}
+		// The responses to a no-reply write, whatever they are, are dropped.
+		cmd, out := s.noReply(conn, cmd)
+		inline := out != conn
+		if !inline {
+			// The response carries the Health of the node in its status.
+			out = s.withHealth(conn)
+		}
+		ci.observe(cmd)
+		if auth, ok := cmd.(*proto.CommandAuth); ok {
+			t = s.handleAuthCommand(conn, auth, t)
+			ci.authenticated(t)
+			continue
+		}
+		if cancel, ok := cmd.(*proto.CommandCancel); ok {
+			// A connection may only cancel its own commands, so this needs
+			// no authorization.
+			s.inflight.cancel(conn, cancel.ID)
+			continue
+		}
+		if status := s.authorize(t, cmd); status != proto.StatusOK {
+			if _, ok := cmd.(*proto.CommandJoin); ok {
+				log.Printf("rejected join from [%s]\n", conn.RemoteAddr())
+				break
+			}
+			_ = s.reject(out, t, cmd, status)
+			if session {
+				s.writeOffset(out, cmd, status)
+			}
+			continue
+		}
+		if redirect := s.redirect(t, cmd); redirect != nil {
+			_ = proto.WriteMessage(out, redirect)
+			continue
+		}
+		if status := s.writable(t, cmd); status != proto.StatusOK {
+			_ = s.reject(out, t, cmd, status)
+			if session {
+				s.writeOffset(out, cmd, status)
+			}
+			continue
+		}
+		if join, ok := cmd.(*proto.CommandJoin); ok {
+			if !s.joinAllowed(join) {
+				log.Printf("rejected join with a wrong cluster secret from [%s]\n", conn.RemoteAddr())
+				break
+			}
+			// The connection now belongs to the member client, which reads the
+			// responses to the commands we forward. Reading from it here as
+			// well would steal those responses.
+			joined = s.handleJoinCommand(conn, join) == nil
+			return
+		}
+		if sub, ok := cmd.(*proto.CommandSubscribe); ok {
+			// The connection now only carries the messages of the channels.
+			_ = s.handleSubscribeCommand(conn, t, sub)
+			return
+		}
+		// The command is pending until it is answered.
+		n := r.n - read
+		if !s.limits.admit(t, cmd, n) {
+			_ = s.reject(out, t, cmd, proto.StatusOverloaded)
+			if session {
+				s.writeOffset(out, cmd, proto.StatusOverloaded)
+			}
+			continue
+		}
+		ci.pending.Add(n)
+		ctx, done := context.Background(), func() {}
+		if id := requestID(cmd); id != 0 {
+			// The command is registered before the next one is read, so a
+			// CANCEL right behind it finds it, even while it is queued.
+			ctx, done = s.inflight.start(conn, id)
+		}
+		out, logged := s.logRequest(ci, out, cmd, readAt, n)
+		job := func() {
+			if logged != nil {
+				defer logged()
+			}
+			defer s.limits.release(n)
+			defer ci.pending.Add(-n)
+			defer done()
+			if !deadline.IsZero() {
+				if !time.Now().Before(deadline) {
+					// The client gave up on the command while it was queued.
+					s.inflight.expired.Add(1)
+					_ = s.reject(out, t, cmd, proto.StatusDeadlineExceeded)
+					if session {
+						s.writeOffset(out, cmd, proto.StatusDeadlineExceeded)
+					}
+					return
+				}
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, deadline)
+				defer cancel()
+			}
+			if timeout := s.CommandTimeouts[commandName(cmd)]; timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeoutCause(ctx, timeout, errCommandTimeout)
+				defer cancel()
+			}
+			switch {
+			case !session:
+				s.handleCommand(ctx, out, cmd)
+			case t == upstream:
+				// The leader tells the offset the mutations bring us to.
+				s.handleCommand(ctx, out, cmd)
+				s.offsets.advance(offset)
+			default:
+				s.session(ctx, out, cmd, offset)
+			}
+		}
+		if inline {
+			// The client does not wait for each no-reply write, so they
+			// are run here, in the order they were sent.
+			job()
+			continue
+		}
+		s.dispatch(priority(t, cmd), job)
+	}
+
+	// fmt.Println("connection closed:", conn.RemoteAddr())
+}
+
+func (s *Server) handleCommand(ctx context.Context, conn net.Conn, cmd any) {
+	var (
+		start = time.Now()
+		name  string
+	)
+	switch v := cmd.(type) {
+	case *proto.CommandSet:
+		name = "set"
+		_ = s.handleSetCommand(conn, v)
+	case *proto.CommandGet:
+		name = "get"
+		_ = s.handleGetCommand(conn, v)
+	case *proto.CommandDel:
+		name = "del"
+		_ = s.handleDelCommand(conn, v)
+	case *proto.CommandDelIf:
+		name = "del_if"
+		_ = s.handleDelIfCommand(conn, v)
+	case *proto.CommandTouch:
+		name = "touch"
+		_ = s.handleTouchCommand(conn, v)
+	case *proto.CommandStats:
+		name = "stats"
+		_ = s.handleStatsCommand(conn, v)
+	case *proto.CommandFill:
+		name = "fill"
+		_ = s.handleFillCommand(ctx, conn, v)
+	case *proto.CommandBackup:
+		name = "backup"
+		_ = s.handleBackupCommand(ctx, conn, v)
+	case *proto.CommandBatch:
+		name = "batch"
+		_ = s.handleBatchCommand(conn, v)
+	case *proto.CommandAppend:
+		name = "append"
+		_ = s.handleAppendCommand(conn, v)
+	case *proto.CommandGetRange:
+		name = "get_range"
+		_ = s.handleGetRangeCommand(conn, v)
+	case *proto.CommandSetIf:
+		name = "set_if"
+		_ = s.handleSetIfCommand(conn, v)
+	case *proto.CommandGetLease:
+		name = "get_lease"
+		_ = s.handleGetLeaseCommand(conn, v)
+	case *proto.CommandSetLease:
+		name = "set_lease"
+		_ = s.handleSetLeaseCommand(conn, v)
+	case *proto.CommandTopology:
+		name = "topology"
+		_ = s.handleTopologyCommand(conn, v)
+	case *proto.CommandPing:
+		name = "ping"
+		_ = proto.WriteMessage(conn, &proto.ResponseSet{Status: proto.StatusOK})
+	case *proto.CommandRename:
+		name = "rename"
+		_ = s.handleRenameCommand(conn, v)
+	case *proto.CommandCopy:
+		name = "copy"
+		_ = s.handleCopyCommand(conn, v)
+	case *proto.CommandSync:
+		name = "sync"
+		_ = s.handleSyncCommand(conn, v)
+	case *proto.CommandPromote:
+		name = "promote"
+		_ = s.handlePromoteCommand(conn, v)
+	case *proto.CommandFlush:
+		name = "flush"
+		_ = s.handleFlushCommand(ctx, conn, v)
+	case *proto.CommandXAdd:
+		name = "xadd"
+		_ = s.handleXAddCommand(conn, v)
+	case *proto.CommandXRange:
+		name = "xrange"
+		_ = s.handleXRangeCommand(conn, v)
+	case *proto.CommandXRead:
+		name = "xread"
+		_ = s.handleXReadCommand(conn, v)
+	case *proto.CommandPublish:
+		name = "publish"
+		_ = s.handlePublishCommand(conn, v)
+	case *proto.CommandScan:
+		name = "scan"
+		_ = s.handleScanCommand(ctx, conn, v)
+	case *proto.CommandUndelete:
+		name = "undelete"
+		_ = s.handleUndeleteCommand(conn, v)
+	case *proto.CommandPurge:
+		name = "purge"
+		_ = s.handlePurgeCommand(ctx, conn, v)
+	case *proto.CommandMulti:
+		name = "multi"
+		_ = s.handleMultiCommand(conn, v)
+	case *proto.CommandMGet:
+		name = "mget"
+		_ = s.handleMGetCommand(ctx, conn, v)
+	case *proto.CommandInfo:
+		name = "info"
+		_ = s.handleInfoCommand(conn, v)
+	case *proto.CommandClock:
+		name = "clock"
+		_ = s.handleClockCommand(conn, v)
+	default:
+		return
+	}
+	s.metrics.observe(name, time.Since(start))
+}
+
+func (s *Server) handleJoinCommand(conn net.Conn, cmd *proto.CommandJoin) error {
+	fmt.Println("member just joined the cluster:", conn.RemoteAddr())
+
+	member := client.NewFromConn(conn)
+	s.mu.Lock()
+	s.members[member] = cmd.ReadAddr
+	if len(cmd.Zone) != 0 {
+		s.zones[member] = cmd.Zone
+	}
+	s.serving[member] = time.Now().Add(-cmd.Serving)
+	if s.FullSync {
+		s.syncing[member] = nil
+	}
+	s.mu.Unlock()
+
+	if s.FullSync {
+		go s.syncMember(member)
+	}
+	return nil
+}
+
+func (s *Server) handleGetCommand(conn net.Conn, cmd *proto.CommandGet) error {
+	// log.Printf("GET %s", cmd.Key)
+
+	resp := proto.ResponseGet{}
+	value, err := s.cache.Get(cmd.Key)
+	status, err := readStatus(err)
+	s.countNamespace(cmd.Key, func(ns *NamespaceStats) {
+		if err != nil {
+			ns.Misses++
+		} else {
+			ns.Hits++
+		}
+	})
+	if err != nil {
+		resp.Status = proto.StatusKeyNotFound
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	// The value is only written straight from the cache if the engine
+	// guarantees it is not modified while the write is in progress.
+	if _, ok := s.cache.(ggcache.StableValues); ok {
+		return proto.WriteGetResponse(conn, status, value)
+	}
+	resp.Status = status
+	resp.Value = value
+	return proto.WriteMessage(conn, &resp)
+}
+
+func (s *Server) handleSetCommand(conn net.Conn, cmd *proto.CommandSet) error {
+	log.Printf("SET %s to %s", cmd.Key, cmd.Value)
+
+	resp := proto.ResponseSet{}
+	err := s.set(cmd.Key, cmd.Value, time.Duration(cmd.TTL)*time.Millisecond)
+	switch {
+	case errors.Is(err, errInvalidValue):
+		resp.Status = proto.StatusInvalidValue
+		return proto.WriteMessage(conn, &resp)
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+		return proto.WriteMessage(conn, &resp)
+	case err != nil:
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	resp.Status = proto.StatusOK
+	return proto.WriteMessage(conn, &resp)
+}
+
+// set stores the key, forwards it to the members and publishes the event.
+func (s *Server) set(key, value []byte, ttl time.Duration) error {
+	if err := s.validate(key, value); err != nil {
+		return err
+	}
+
+	s.replicate(&proto.CommandSet{Key: key, Value: value, TTL: int(ttl.Milliseconds())})
+	s.leases.invalidate(key)
+
+	s.countNamespace(key, func(ns *NamespaceStats) { ns.Sets++ })
+	s.observeValueSize(key, len(value))
+
+	if err := s.cache.Set(key, value, ttl); err != nil {
+		return err
+	}
+	s.events.publish(KeyspaceEvent{Op: "set", Key: key, Value: value})
+	return nil
+}
+
+func (s *Server) handleSetIfCommand(conn net.Conn, cmd *proto.CommandSetIf) error {
+	resp := proto.ResponseSet{}
+	cond := ggcache.Condition{IfMatch: string(cmd.IfMatch), IfNoneMatch: string(cmd.IfNoneMatch)}
+	err := s.setIf(cmd.Key, cmd.Value, time.Duration(cmd.TTL)*time.Millisecond, cond)
+	switch {
+	case errors.Is(err, ggcache.ErrPreconditionFailed):
+		resp.Status = proto.StatusPreconditionFailed
+		return proto.WriteMessage(conn, &resp)
+	case errors.Is(err, errInvalidValue):
+		resp.Status = proto.StatusInvalidValue
+		return proto.WriteMessage(conn, &resp)
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+		return proto.WriteMessage(conn, &resp)
+	case err != nil:
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	resp.Status = proto.StatusOK
+	return proto.WriteMessage(conn, &resp)
+}
+
+// errNoSetIf is returned by setIf if the cache is not a
+// ggcache.ConditionalSetter.
+var errNoSetIf = errors.New("the cache does not support conditional set")
+
+// setIf stores the key if the condition holds, forwards it to the members as
+// a plain set and publishes the event. Unlike set it only replicates once the
+// write succeeded, as the members may not agree on the current value.
+func (s *Server) setIf(key, value []byte, ttl time.Duration, cond ggcache.Condition) error {
+	setter, ok := s.cache.(ggcache.ConditionalSetter)
+	if !ok {
+		return errNoSetIf
+	}
+	if err := s.validate(key, value); err != nil {
+		return err
+	}
+
+	if err := setter.SetIf(key, value, ttl, cond); err != nil {
+		return err
+	}
+
+	s.replicate(&proto.CommandSet{Key: key, Value: value, TTL: int(ttl.Milliseconds())})
+	s.leases.invalidate(key)
+
+	s.countNamespace(key, func(ns *NamespaceStats) { ns.Sets++ })
+	s.observeValueSize(key, len(value))
+
+	s.events.publish(KeyspaceEvent{Op: "set", Key: key, Value: value})
+	return nil
+}
+
+func (s *Server) handleDelCommand(conn net.Conn, cmd *proto.CommandDel) error {
+	resp := proto.ResponseDelete{}
+	err := s.del(cmd.Key)
+	switch {
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+		return proto.WriteMessage(conn, &resp)
+	case err != nil:
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	resp.Status = proto.StatusOK
+	return proto.WriteMessage(conn, &resp)
+}
+
+// del removes the key, forwards it to the members and publishes the event.
+func (s *Server) del(key []byte) error {
+	s.replicate(&proto.CommandDel{Key: key})
+	s.leases.invalidate(key)
+
+	s.countNamespace(key, func(ns *NamespaceStats) { ns.Deletes++ })
+
+	s.tombstone(key)
+	if err := s.cache.Delete(key); err != nil {
+		return err
+	}
+	s.events.publish(KeyspaceEvent{Op: "del", Key: key})
+	return nil
+}
+
+func (s *Server) handleDelIfCommand(conn net.Conn, cmd *proto.CommandDelIf) error {
+	resp := proto.ResponseDelete{}
+	err := s.delIf(cmd.Key, ggcache.Condition{IfMatch: string(cmd.IfMatch)})
+	switch {
+	case errors.Is(err, ggcache.ErrPreconditionFailed):
+		resp.Status = proto.StatusPreconditionFailed
+		return proto.WriteMessage(conn, &resp)
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+		return proto.WriteMessage(conn, &resp)
+	case err != nil:
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	resp.Status = proto.StatusOK
+	return proto.WriteMessage(conn, &resp)
+}
+
+var (
+	// errNoDeleteIf is returned by delIf if the cache is not a
+	// ggcache.ConditionalDeleter.
+	errNoDeleteIf = errors.New("the cache does not support conditional delete")
+
+	// errNoVersion is returned by delIf for a condition without IfMatch,
+	// which would always hold.
+	errNoVersion = errors.New("a conditional delete requires a version")
+)
+
+// maxDelIfAttempts bounds the times a conditional delete reads the value it
+// keeps again, as writes kept changing it, after which it is deleted without
+// being kept.
+const maxDelIfAttempts = 3
+
+// delIf removes the key if the condition holds, forwards it to the members
+// as a plain delete and publishes the event. Like setIf it only replicates
+// once the delete succeeded.
+func (s *Server) delIf(key []byte, cond ggcache.Condition) error {
+	if len(cond.IfMatch) == 0 {
+		return errNoVersion
+	}
+	deleter, ok := s.cache.(ggcache.ConditionalDeleter)
+	if !ok {
+		return errNoDeleteIf
+	}
+
+	// The value is only kept once it is deleted, and only if it is the one
+	// deleted: the condition is narrowed to the version read, so a write
+	// landing between the read and the delete fails it, and the value is
+	// read again.
+	for attempt := 1; ; attempt++ {
+		stone, kept := s.stone(key)
+		narrowed := cond
+		switch {
+		case !kept || attempt > maxDelIfAttempts:
+			kept = false
+		case cond.IfMatch == "*":
+			narrowed.IfMatch = ggcache.ETag(stone.value)
+		default:
+			kept = cond.IfMatch == ggcache.ETag(stone.value)
+		}
+		err := deleter.DeleteIf(key, narrowed)
+		if errors.Is(err, ggcache.ErrPreconditionFailed) && narrowed != cond {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if kept {
+			s.tombstones.add(key, stone, s.DeleteRetention)
+		}
+		break
+	}
+
+	s.replicate(&proto.CommandDel{Key: key})
+	s.leases.invalidate(key)
+
+	s.countNamespace(key, func(ns *NamespaceStats) { ns.Deletes++ })
+
+	s.events.publish(KeyspaceEvent{Op: "del", Key: key})
+	return nil
+}
+
+func (s *Server) handleTouchCommand(conn net.Conn, cmd *proto.CommandTouch) error {
+	resp := proto.ResponseTouch{}
+	err := s.touch(cmd.Key, time.Duration(cmd.TTL)*time.Millisecond)
+	switch {
+	case errors.Is(err, errNoTouch):
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+		return proto.WriteMessage(conn, &resp)
+	case err != nil:
+		resp.Status = proto.StatusKeyNotFound
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	resp.Status = proto.StatusOK
+	return proto.WriteMessage(conn, &resp)
+}
+
+// errNoTouch is returned by touch if the cache is not a ggcache.Toucher.
+var errNoTouch = errors.New("the cache does not support touch")
+
+// touch resets the expiration of the key, forwards it to the members and
+// publishes the event.
+func (s *Server) touch(key []byte, ttl time.Duration) error {
+	toucher, ok := s.cache.(ggcache.Toucher)
+	if !ok {
+		return errNoTouch
+	}
+
+	s.replicate(&proto.CommandTouch{Key: key, TTL: int(ttl.Milliseconds())})
+
+	if err := toucher.Touch(key, ttl); err != nil {
+		return err
+	}
+	s.events.publish(KeyspaceEvent{Op: "touch", Key: key})
+	return nil
+}
+
+func (s *Server) handleAppendCommand(conn net.Conn, cmd *proto.CommandAppend) error {
+	resp := proto.ResponseAppend{}
+	err := s.append(cmd.Key, cmd.Data)
+	switch {
+	case errors.Is(err, errNoAppend):
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	case errors.Is(err, errInvalidValue):
+		resp.Status = proto.StatusInvalidValue
+		return proto.WriteMessage(conn, &resp)
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+		return proto.WriteMessage(conn, &resp)
+	case err != nil:
+		resp.Status = proto.StatusKeyNotFound
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	resp.Status = proto.StatusOK
+	return proto.WriteMessage(conn, &resp)
+}
+
+// errNoAppend is returned by append if the cache is not a ggcache.Appender.
+var errNoAppend = errors.New("the cache does not support append")
+
+// append appends to the value of the key, forwards it to the members and
+// publishes the event with the appended data.
+func (s *Server) append(key, data []byte) error {
+	appender, ok := s.cache.(ggcache.Appender)
+	if !ok {
+		return errNoAppend
+	}
+	if s.validator(key) != nil {
+		// A fragment cannot be validated on its own.
+		return fmt.Errorf("%w: the namespace does not allow appends", errInvalidValue)
+	}
+
+	s.replicate(&proto.CommandAppend{Key: key, Data: data})
+	s.leases.invalidate(key)
+
+	s.countNamespace(key, func(ns *NamespaceStats) { ns.Sets++ })
+
+	if err := appender.Append(key, data); err != nil {
+		return err
+	}
+	s.events.publish(KeyspaceEvent{Op: "append", Key: key, Value: data})
+	return nil
+}
+
+// readStatus returns the status a read that failed with err is answered
+// with if it still has a value: StatusStale for a value served past its
+// expiration, which is then not an error.
+func readStatus(err error) (proto.Status, error) {
+	if errors.Is(err, ggcache.ErrStale) {
+		return proto.StatusStale, nil
+	}
+	return proto.StatusOK, err
+}
+
+// handleGetRangeCommand answers with part of a value. Engines that cannot
+// read a range have the whole value read and cut here, which still saves
+// sending it.
+func (s *Server) handleGetRangeCommand(conn net.Conn, cmd *proto.CommandGetRange) error {
+	resp := proto.ResponseGet{}
+	if cmd.Offset < 0 || cmd.Length < 0 {
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	var (
+		value []byte
+		err   error
+	)
+	if r, ok := s.cache.(ggcache.RangeGetter); ok {
+		value, err = r.GetRange(cmd.Key, cmd.Offset, cmd.Length)
+	} else if value, err = s.cache.Get(cmd.Key); err == nil || errors.Is(err, ggcache.ErrStale) {
+		start := min(cmd.Offset, len(value))
+		value = value[start : start+min(cmd.Length, len(value)-start)]
+	}
+	status, err := readStatus(err)
+	s.countNamespace(cmd.Key, func(ns *NamespaceStats) {
+		if err != nil {
+			ns.Misses++
+		} else {
+			ns.Hits++
+		}
+	})
+	if err != nil {
+		resp.Status = proto.StatusKeyNotFound
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	if _, ok := s.cache.(ggcache.StableValues); ok {
+		return proto.WriteGetResponse(conn, status, value)
+	}
+	resp.Status = status
+	resp.Value = value
+	return proto.WriteMessage(conn, &resp)
+}
+
+// handleBatchCommand applies the mutations replicated by the leader in
+// order. A TOUCH, APPEND, RENAME or COPY of a key this node does not have is
+// not an error, as it may have expired here first.
+func (s *Server) handleBatchCommand(conn net.Conn, cmd *proto.CommandBatch) error {
+	resp := proto.ResponseBatch{Status: proto.StatusOK}
+	for _, c := range cmd.Commands {
+		if at, ok := c.(*proto.CommandAt); ok {
+			c = s.untimed(at, time.Now())
+		}
+		var err error
+		switch v := c.(type) {
+		case *proto.CommandSet:
+			err = s.set(v.Key, v.Value, time.Duration(v.TTL)*time.Millisecond)
+		case *proto.CommandDel:
+			err = s.del(v.Key)
+		case *proto.CommandTouch:
+			if err = s.touch(v.Key, time.Duration(v.TTL)*time.Millisecond); !errors.Is(err, errNoTouch) {
+				err = nil
+			}
+		case *proto.CommandAppend:
+			if err = s.append(v.Key, v.Data); !errors.Is(err, errNoAppend) {
+				err = nil
+			}
+		case *proto.CommandRename:
+			if err = s.rename(v.Key, v.NewKey); !errors.Is(err, errNoRename) {
+				err = nil
+			}
+		case *proto.CommandCopy:
+			if err = s.copy(v.Key, v.Dst, time.Duration(v.TTL)*time.Millisecond); !errors.Is(err, errNoRename) {
+				err = nil
+			}
+		case *proto.CommandXAdd:
+			_, err = s.xadd(v, true)
+		case *proto.CommandPublish:
+			s.publish(v)
+		case *proto.CommandMulti:
+			err = s.multi(v)
+		}
+		if err != nil {
+			log.Println("batch error:", err)
+			resp.Status = proto.StatusError
+		}
+	}
+
+	return proto.WriteMessage(conn, &resp)
+}
+
+func (s *Server) handleFillCommand(ctx context.Context, conn net.Conn, cmd *proto.CommandFill) error {
+	resp := proto.ResponseGet{}
+	if s.Filler == nil {
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	value, err := s.Filler.Fill(ctx, cmd.Key)
+	if err != nil {
+		resp.Status = proto.StatusError
+		if s.inflight.expire(ctx, err) {
+			resp.Status = expiredStatus(ctx)
+		} else {
+			log.Println("fill error:", err)
+		}
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	resp.Status = proto.StatusOK
+	resp.Value = value
+	return proto.WriteMessage(conn, &resp)
+}
+
+// MemberCount returns the number of followers that joined this server.
+func (s *Server) MemberCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.members)
+}
+
+func (s *Server) removeMember(member *client.Client) {
+	s.mu.Lock()
+	delete(s.members, member)
+	delete(s.zones, member)
+	delete(s.serving, member)
+	delete(s.syncing, member)
+	s.mu.Unlock()
+
+	_ = member.Close()
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFill(t *testing.T) {
+	var (
+		loads atomic.Int32
+		lns   []net.Listener
+		addrs []string
+	)
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		lns = append(lns, ln)
+		addrs = append(addrs, ln.Addr().String())
+	}
+
+	getter := func(_ context.Context, key []byte) ([]byte, error) {
+		loads.Add(1)
+		return append([]byte("value of "), key...), nil
+	}
+	var groups []*ggcache.Group
+	for i, ln := range lns {
+		peers := client.NewPeers(addrs[i], addrs, client.Options{})
+		defer peers.Close()
+
+		g := ggcache.NewGroup(ggcache.New(), getter, peers, ggcache.GroupOptions{HotFraction: -1})
+		groups = append(groups, g)
+
+		s := NewServer(ServerOpts{IsLeader: true, Filler: g}, ggcache.New())
+		go func(ln net.Listener) {
+			_ = s.Serve(ln)
+		}(ln)
+		defer s.Close()
+	}
+
+	// Both nodes read every key, but each key is only loaded by its owner.
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		for _, g := range groups {
+			value, err := g.Get(context.Background(), key)
+			assert.Nil(t, err)
+			assert.Equal(t, append([]byte("value of "), key...), value)
+		}
+	}
+	assert.Equal(t, int32(20), loads.Load())
+}
+
+func TestFillWithoutFiller(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	_, err = c.Fill(context.Background(), []byte("foo"))
+	assert.NotNil(t, err)
+}
+
+func TestGetLarge(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// Large enough to be written straight from the cache.
+	value := bytes.Repeat([]byte("0123456789"), 100_000)
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), value, 0))
+
+	got, err := c.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, value, got)
+}
+
+func TestAppendGetRange(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	key := []byte("log")
+	assert.NotNil(t, c.Append(ctx, key, []byte("line 1\n")))
+
+	assert.Nil(t, c.Set(ctx, key, []byte("line 1\n"), 0))
+	assert.Nil(t, c.Append(ctx, key, []byte("line 2\n")))
+	assert.Nil(t, c.Append(ctx, key, []byte("line 3\n")))
+
+	value, err := c.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("line 1\nline 2\nline 3\n"), value)
+
+	value, err = c.GetRange(ctx, key, 7, 7)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("line 2\n"), value)
+	value, err = c.GetRange(ctx, key, 14, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("line 3\n"), value)
+
+	_, err = c.GetRange(ctx, []byte("missing"), 0, 1)
+	assert.NotNil(t, err)
+	_, err = c.GetRange(ctx, key, -1, 1)
+	assert.NotNil(t, err)
+}
+
+func TestSetIf(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	key := []byte("foo")
+	assert.Nil(t, c.SetIf(ctx, key, []byte("v1"), 0, "", "*"))
+	assert.Equal(t, client.ErrPreconditionFailed, c.SetIf(ctx, key, []byte("v2"), 0, "", "*"))
+
+	assert.Nil(t, c.SetIf(ctx, key, []byte("v2"), 0, ggcache.ETag([]byte("v1")), ""))
+	// The error is that of the caches.
+	assert.ErrorIs(t, c.SetIf(ctx, key, []byte("v3"), 0, ggcache.ETag([]byte("v1")), ""), ggcache.ErrPreconditionFailed)
+
+	value, err := c.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+}
+
+func TestDeleteIf(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, DeleteRetention: time.Minute}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// A lock is only released by its owner.
+	ctx := context.Background()
+	key := []byte("lock")
+	assert.Nil(t, c.SetIf(ctx, key, []byte("owner-1"), 0, "", "*"))
+	assert.Equal(t, client.ErrPreconditionFailed, c.DeleteIfValue(ctx, key, []byte("owner-2")))
+	value, err := c.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("owner-1"), value)
+	assert.Nil(t, c.DeleteIfValue(ctx, key, []byte("owner-1")))
+	_, err = c.Get(ctx, key)
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+
+	// A missing key matches no version.
+	assert.Equal(t, client.ErrPreconditionFailed, c.DeleteIfVersion(ctx, key, "*"))
+	assert.Nil(t, c.Set(ctx, key, []byte("owner-2"), 0))
+	// Nor does an empty one, which would otherwise always hold.
+	assert.Error(t, c.DeleteIfVersion(ctx, key, ""))
+	conn, err := net.Dial("tcp", s.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Nil(t, proto.WriteMessage(conn, &proto.CommandDelIf{Key: key}))
+	resp, err := proto.ParseDeleteResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, proto.StatusError, resp.Status)
+	value, err = c.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("owner-2"), value)
+	assert.Nil(t, c.DeleteIfVersion(ctx, key, ggcache.ETag([]byte("owner-2"))))
+
+	// The deleted values are kept like those of DEL.
+	n, err := c.Undelete(ctx, "lock")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	value, err = c.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("owner-2"), value)
+}
+
+// racingCache sets the key to value before its next DeleteIf, as a client
+// writing it between the read of the value the delete keeps and the delete.
+type racingCache struct {
+	*ggcache.Cache
+	value []byte
+	raced atomic.Bool
+}
+
+func (c *racingCache) DeleteIf(key []byte, cond ggcache.Condition) error {
+	if !c.raced.Swap(true) {
+		if err := c.Cache.Set(key, c.value, 0); err != nil {
+			return err
+		}
+	}
+	return c.Cache.DeleteIf(key, cond)
+}
+
+func TestDeleteIfKeepsDeletedValue(t *testing.T) {
+	cache := &racingCache{Cache: ggcache.New(), value: []byte("v2")}
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, DeleteRetention: time.Minute}, cache)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// The value written in between is the one deleted, and kept.
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("v1"), 0))
+	assert.Nil(t, c.DeleteIfVersion(ctx, []byte("foo"), "*"))
+	n, err := c.Undelete(ctx, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	value, err := c.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+
+	// Unless the version matches it but not the value read, which is not
+	// kept in its place.
+	cache.raced.Store(false)
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("v1"), 0))
+	assert.Nil(t, c.DeleteIfVersion(ctx, []byte("foo"), ggcache.ETag([]byte("v2"))))
+	n, err = c.Undelete(ctx, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	_, err = c.Get(ctx, []byte("foo"))
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+}
+
+// staleCache serves every value it has as if it expired, as a cache set to
+// ggcache.ExpiredStale does for those its timers did not remove yet.
+type staleCache struct {
+	ggcache.Cacher
+}
+
+func (c staleCache) Get(key []byte) ([]byte, error) {
+	value, err := c.Cacher.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return value, fmt.Errorf("%w: %s", ggcache.ErrStale, key)
+}
+
+func TestStaleReads(t *testing.T) {
+	cache := ggcache.New()
+	assert.Nil(t, cache.Set([]byte("foo"), []byte("bar"), 0))
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, staleCache{cache})
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// The value is returned, flagged as stale.
+	ctx := context.Background()
+	value, err := c.Get(ctx, []byte("foo"))
+	assert.ErrorIs(t, err, client.ErrStale)
+	assert.ErrorIs(t, err, ggcache.ErrStale)
+	assert.Equal(t, []byte("bar"), value)
+	value, err = c.GetRange(ctx, []byte("foo"), 1, 2)
+	assert.ErrorIs(t, err, client.ErrStale)
+	assert.Equal(t, []byte("ar"), value)
+
+	_, err = c.Get(ctx, []byte("baz"))
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+}
+
+// BenchmarkServerGetSet measures the allocations of a SET and GET round trip,
+// client and server included.
+func BenchmarkServerGetSet(b *testing.B) {
+	benchmarkServerGetSet(b, 128)
+}
+
+// BenchmarkServerGetSetLarge measures the round trip of a value large enough
+// to be written without copying.
+func BenchmarkServerGetSetLarge(b *testing.B) {
+	benchmarkServerGetSet(b, 1<<20)
+}
+
+func benchmarkServerGetSet(b *testing.B, size int) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	key, value := []byte("foo"), make([]byte, size)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	for i := 0; i < b.N; i++ {
+		if err := c.Set(ctx, key, value, 0); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := c.Get(ctx, key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
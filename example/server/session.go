@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// offsetTracker holds the replication offset of the node: on the leader the
+// number of mutations it replicated, on a follower the offset of the latest
+// mutations it applied.
+type offsetTracker struct {
+	mu     sync.Mutex
+	offset uint64
+	// advanced is closed, and replaced, whenever the offset advances.
+	advanced chan struct{}
+
+	// waited counts the session reads held until the node caught up, and
+	// redirected those sent to the leader as it did not in time.
+	waited     atomic.Uint64
+	redirected atomic.Uint64
+}
+
+// next advances the offset past a mutation and returns it.
+func (o *offsetTracker) next() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.offset++
+	o.broadcast()
+	return o.offset
+}
+
+// advance moves the offset up to that of the mutations applied, if it is
+// ahead.
+func (o *offsetTracker) advance(offset uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if offset > o.offset {
+		o.offset = offset
+		o.broadcast()
+	}
+}
+
+// broadcast wakes up the reads waiting on the offset.
+// The caller must hold o.mu.
+func (o *offsetTracker) broadcast() {
+	if o.advanced != nil {
+		close(o.advanced)
+		o.advanced = nil
+	}
+}
+
+func (o *offsetTracker) load() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.offset
+}
+
+// wait waits for the offset to reach min until ctx is done or the timeout
+// runs out, and reports whether it did.
+func (o *offsetTracker) wait(ctx context.Context, min uint64, timeout time.Duration) bool {
+	var timer <-chan time.Time
+	for {
+		o.mu.Lock()
+		if o.offset >= min {
+			o.mu.Unlock()
+			return true
+		}
+		if o.advanced == nil {
+			o.advanced = make(chan struct{})
+		}
+		advanced := o.advanced
+		o.mu.Unlock()
+
+		if timeout <= 0 {
+			return false
+		}
+		if timer == nil {
+			t := time.NewTimer(timeout)
+			defer t.Stop()
+			timer = t.C
+		}
+		select {
+		case <-advanced:
+		case <-timer:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// session runs the command of a client carried by a CommandOffset. A read
+// is held for up to SessionWait until the node applied the mutations up to
+// the offset, and redirected to the leader if it did not, and a write is
+// followed by the offset of the leader once it is done.
+func (s *Server) session(ctx context.Context, conn net.Conn, cmd any, offset uint64) {
+	if isWrite(cmd) {
+		s.handleCommand(ctx, conn, cmd)
+		s.writeOffset(conn, cmd, proto.StatusOK)
+		return
+	}
+
+	// The leader serves every read, as nothing is newer, even if the offset
+	// was handed out by a leader before it.
+	if redirect := s.leaderRedirect(); redirect != nil && s.offsets.load() < offset {
+		s.offsets.waited.Add(1)
+		if !s.offsets.wait(ctx, offset, s.SessionWait) {
+			s.offsets.redirected.Add(1)
+			_ = proto.WriteMessage(conn, redirect)
+			return
+		}
+	}
+	s.handleCommand(ctx, conn, cmd)
+}
+
+// writeOffset follows the response to a write of a session, answered with
+// the status, with the offset of the node, unless the response was a
+// Redirect. A handled write passes StatusOK, whatever it answered.
+func (s *Server) writeOffset(conn net.Conn, cmd any, status proto.Status) {
+	if !isWrite(cmd) || status.HasRedirect() {
+		return
+	}
+	_ = proto.WriteMessage(conn, &proto.ResponseOffset{Offset: s.offsets.load()})
+}
+
+// leaderRedirect returns the Redirect sending a command to the leader, nil
+// on the leader itself.
+func (s *Server) leaderRedirect() *proto.Redirect {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case len(s.leader) == 0:
+		// We are the leader, or lost ours and serve until we find one.
+		return nil
+	case s.leaderConn == nil:
+		return &proto.Redirect{Status: proto.StatusRetry, RetryAfter: leaderRetryAfter}
+	default:
+		return &proto.Redirect{Status: proto.StatusMoved, Addr: s.leader}
+	}
+}
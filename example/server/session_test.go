@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession(t *testing.T) {
+	// The mutations are only replicated when the test flushes them.
+	leader, c, err := StartEmbedded(ServerOpts{IsLeader: true, ReplicationInterval: time.Hour}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	addr := freeAddr(t)
+	replica, rc, err := StartEmbedded(ServerOpts{
+		ListenAddr: addr,
+		LeaderAddr: leader.Addr().String(),
+		ReadAddr:   addr,
+	}, nil)
+	assert.Nil(t, err)
+	defer replica.Close()
+	defer rc.Close()
+
+	waiting, wc, err := StartEmbedded(ServerOpts{
+		LeaderAddr:  leader.Addr().String(),
+		SessionWait: 5 * time.Second,
+	}, nil)
+	assert.Nil(t, err)
+	defer waiting.Close()
+	defer wc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// The writes of a session record the offset of the leader.
+	var sess client.Session
+	ctx := client.WithSession(context.Background(), &sess)
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Equal(t, uint64(1), sess.Offset())
+	assert.Nil(t, c.Set(ctx, []byte("baz"), []byte("qux"), 0))
+	assert.Equal(t, uint64(2), sess.Offset())
+
+	// A replica that is behind redirects the reads of the session to the
+	// leader, which serves them.
+	_, err = rc.Get(ctx, []byte("foo"))
+	var redirect *proto.Redirect
+	assert.True(t, errors.As(err, &redirect))
+	assert.Equal(t, proto.StatusMoved, redirect.Status)
+	assert.Equal(t, leader.Addr().String(), redirect.Addr)
+	value, err := c.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	cluster, err := client.NewCluster(addr, client.Options{})
+	assert.Nil(t, err)
+	defer cluster.Close()
+	value, err = cluster.Get(ctx, []byte("baz"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("qux"), value)
+
+	// One with SessionWait holds them until it caught up.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		leader.flushReplication()
+	}()
+	value, err = wc.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.Equal(t, int64(1), stat(waiting, "server_session_waits_total"))
+	assert.Equal(t, int64(2), stat(waiting, "server_replication_offset"))
+
+	// Caught up, the replica serves them too.
+	assert.Eventually(t, func() bool {
+		return stat(replica, "server_replication_offset") == 2
+	}, time.Second, 10*time.Millisecond)
+	value, err = rc.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.Equal(t, int64(2), stat(replica, "server_session_redirects_total"))
+
+	// The reads outside of a session are served as they are.
+	_, err = rc.Get(context.Background(), []byte("foo"))
+	assert.Nil(t, err)
+}
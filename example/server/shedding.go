@@ -0,0 +1,60 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// loadLimits bounds the commands the server handles at once, so that under
+// more load than it can take it answers the excess with StatusOverloaded
+// instead of growing its goroutines and buffers until it falls over.
+type loadLimits struct {
+	maxHandlers int64
+	maxBytes    int64
+
+	// handlers and bytes are the commands admitted and not yet answered,
+	// and the size of their requests.
+	handlers atomic.Int64
+	bytes    atomic.Int64
+
+	// shedHandlers and shedBytes count the commands shed as they would
+	// have gone over MaxHandlers and MaxInflightBytes.
+	shedHandlers atomic.Uint64
+	shedBytes    atomic.Uint64
+}
+
+// admit takes the room for a command of the tenant whose request is n
+// bytes, reporting false if it has to be shed. The forwarded mutations and
+// the PINGs keeping connections alive are never shed: dropping the former
+// would leave the follower out of step with its leader, and the latter are
+// what tell a client the node is still there. An admitted command gives its
+// room back with release.
+func (l *loadLimits) admit(t *tenant, cmd any, n int64) bool {
+	l.handlers.Add(1)
+	l.bytes.Add(n)
+	if t == upstream {
+		return true
+	}
+	if _, ok := cmd.(*proto.CommandPing); ok {
+		return true
+	}
+	if l.maxHandlers > 0 && l.handlers.Load() > l.maxHandlers {
+		l.release(n)
+		l.shedHandlers.Add(1)
+		return false
+	}
+	// A single request larger than the limit is let through while nothing
+	// else is in flight, or it could never be handled.
+	if b := l.bytes.Load(); l.maxBytes > 0 && b > l.maxBytes && b != n {
+		l.release(n)
+		l.shedBytes.Add(1)
+		return false
+	}
+	return true
+}
+
+func (l *loadLimits) release(n int64) {
+	l.handlers.Add(-1)
+	l.bytes.Add(-n)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientLoadShedding(t *testing.T) {
+	// A server that never answers, so the first command holds the
+	// connection while the others queue behind it.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c, err := client.New(ln.Addr().String(), client.Options{MaxWaiting: 1, MaxWait: 50 * time.Millisecond})
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	for _, key := range []string{"stuck", "waiting"} {
+		key := key
+		go func() { _, _ = c.Get(ctx, []byte(key)) }()
+	}
+	assert.Eventually(t, func() bool {
+		return c.QueueStats().Waiting == 1
+	}, time.Second, time.Millisecond)
+
+	// Past MaxWaiting a command is shed at once.
+	start := time.Now()
+	_, err = c.Get(ctx, []byte("foo"))
+	assert.ErrorIs(t, err, client.ErrOverloaded)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// And a command waiting for longer than MaxWait is shed too.
+	assert.Eventually(t, func() bool {
+		return c.QueueStats() == client.QueueStats{Shed: 2}
+	}, time.Second, 10*time.Millisecond)
+
+	_ = c.Close()
+}
+
+func TestServerLoadShedding(t *testing.T) {
+	filler := &gateFiller{started: make(chan struct{}, 1), gate: make(chan struct{})}
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader:         true,
+		Filler:           filler,
+		MaxHandlers:      1,
+		MaxInflightBytes: 256,
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	c2, err := client.New(s.Addr().String(), client.Options{})
+	assert.Nil(t, err)
+	defer c2.Close()
+
+	// A single request over MaxInflightBytes is handled while nothing else
+	// is in flight.
+	ctx := context.Background()
+	big := make([]byte, 512)
+	assert.Nil(t, c2.Set(ctx, []byte("big"), big, 0))
+
+	// The fill is the only command handled until the gate is closed.
+	filled := make(chan error, 1)
+	go func() {
+		_, err := c.Fill(ctx, []byte("slow"))
+		filled <- err
+	}()
+	<-filler.started
+	assert.Equal(t, int64(1), stat(s, "server_handlers"))
+
+	// The others are shed, but for PINGs.
+	_, err = c2.Get(ctx, []byte("big"))
+	assert.ErrorIs(t, err, client.ErrOverloaded)
+	assert.Nil(t, c2.Ping(ctx))
+	assert.Equal(t, int64(1), stat(s, "server_shed_handlers_total"))
+
+	close(filler.gate)
+	assert.Nil(t, <-filled)
+	assert.Eventually(t, func() bool {
+		return stat(s, "server_handlers") == 0 && stat(s, "server_inflight_bytes") == 0
+	}, time.Second, time.Millisecond)
+
+	value, err := c2.Get(ctx, []byte("big"))
+	assert.Nil(t, err)
+	assert.Equal(t, big, value)
+}
+
+func TestLoadLimitsBytes(t *testing.T) {
+	l := loadLimits{maxBytes: 100}
+	get := &proto.CommandGet{Key: []byte("foo")}
+
+	assert.True(t, l.admit(nil, get, 60))
+	assert.False(t, l.admit(nil, get, 60))
+	assert.True(t, l.admit(nil, get, 40))
+	// The mutations forwarded by the leader are never shed.
+	assert.True(t, l.admit(upstream, get, 60))
+	assert.Equal(t, uint64(1), l.shedBytes.Load())
+
+	l.release(60)
+	l.release(40)
+	l.release(60)
+	assert.Equal(t, int64(0), l.bytes.Load())
+	assert.Equal(t, int64(0), l.handlers.Load())
+}
@@ -0,0 +1,593 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+var simSeed = flag.Int64("sim.seed", 0, "seed of the cluster simulations, random if zero")
+
+// simAddr is the address of a node on a simNet.
+type simAddr string
+
+func (a simAddr) Network() string { return "sim" }
+func (a simAddr) String() string  { return string(a) }
+
+// simPacket is a write in flight to the reading end of a connection.
+type simPacket struct {
+	at   time.Time
+	seq  uint64
+	to   *simConn
+	data []byte
+	fin  bool
+}
+
+// simNet is an in-memory network with a fake clock. Every write is delivered
+// to the peer after a latency drawn from a seeded source once the clock is
+// advanced past it, in order per connection, so a simulation replayed with
+// the same seed sees the same latencies and the same interleaving of packets.
+// Nodes on either side of a partition cannot dial each other, and their open
+// connections are reset.
+type simNet struct {
+	mu         sync.Mutex
+	rng        *rand.Rand
+	now        time.Time
+	maxLatency time.Duration
+	seq        uint64
+	inflight   []simPacket
+	listeners  map[string]*simListener
+	conns      map[*simConn]struct{}
+	cut        map[[2]string]bool
+
+	// trace records every delivery, to compare the runs of a seed.
+	trace []string
+}
+
+func newSimNet(seed int64, maxLatency time.Duration) *simNet {
+	return &simNet{
+		rng:        rand.New(rand.NewSource(seed)),
+		now:        time.Unix(0, 0),
+		maxLatency: maxLatency,
+		listeners:  make(map[string]*simListener),
+		conns:      make(map[*simConn]struct{}),
+		cut:        make(map[[2]string]bool),
+	}
+}
+
+// link returns the key of the link between two nodes, in either direction.
+func link(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// Now returns the time of the fake clock.
+func (n *simNet) Now() time.Time {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.now
+}
+
+// Listen listens for the connections dialed to addr.
+func (n *simNet) Listen(addr string) *simListener {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ln := &simListener{net: n, addr: simAddr(addr), accept: make(chan *simConn, 64), done: make(chan struct{})}
+	n.listeners[addr] = ln
+	return ln
+}
+
+// Dialer returns the dial function of the node at addr.
+func (n *simNet) Dialer(from string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(_ context.Context, network, addr string) (net.Conn, error) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		ln := n.listeners[addr]
+		if ln == nil || n.cut[link(from, addr)] {
+			return nil, &net.OpError{Op: "dial", Net: network, Addr: simAddr(addr), Err: syscall.ECONNREFUSED}
+		}
+
+		local := newSimConn(n, simAddr(from), simAddr(addr))
+		remote := newSimConn(n, simAddr(addr), simAddr(from))
+		local.peer, remote.peer = remote, local
+		n.conns[local] = struct{}{}
+		n.conns[remote] = struct{}{}
+
+		select {
+		case ln.accept <- remote:
+			return local, nil
+		default:
+			return nil, &net.OpError{Op: "dial", Net: network, Addr: simAddr(addr), Err: syscall.ECONNREFUSED}
+		}
+	}
+}
+
+// Partition cuts the link between the nodes at a and b.
+func (n *simNet) Partition(a, b string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.cut[link(a, b)] = true
+	for c := range n.conns {
+		if link(c.local.String(), c.remote.String()) == link(a, b) {
+			c.reset()
+			delete(n.conns, c)
+		}
+	}
+}
+
+// Heal restores the link between the nodes at a and b.
+func (n *simNet) Heal(a, b string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.cut, link(a, b))
+}
+
+// Reachable reports whether the nodes at a and b can reach each other.
+func (n *simNet) Reachable(a, b string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return !n.cut[link(a, b)]
+}
+
+// Advance moves the clock forward by d, delivering the packets due by then.
+func (n *simNet) Advance(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	until := n.now.Add(d)
+	for len(n.inflight) != 0 && !n.inflight[0].at.After(until) {
+		p := n.inflight[0]
+		n.inflight = n.inflight[1:]
+		n.now = p.at
+		n.trace = append(n.trace, fmt.Sprintf("%d %s->%s %d %t",
+			p.at.UnixNano(), p.to.remote, p.to.local, len(p.data), p.fin))
+		p.to.receive(p.data, p.fin)
+	}
+	n.now = until
+}
+
+// send schedules a packet to the peer of c after a random latency, but never
+// ahead of the previous packet on c.
+// The caller must hold n.mu.
+func (n *simNet) send(c *simConn, data []byte, fin bool) {
+	at := n.now.Add(time.Duration(1 + n.rng.Int63n(int64(n.maxLatency))))
+	if at.Before(c.lastAt) {
+		at = c.lastAt
+	}
+	c.lastAt = at
+
+	n.seq++
+	p := simPacket{at: at, seq: n.seq, to: c.peer, data: data, fin: fin}
+	i := sort.Search(len(n.inflight), func(i int) bool {
+		q := n.inflight[i]
+		return q.at.After(p.at) || (q.at.Equal(p.at) && q.seq > p.seq)
+	})
+	n.inflight = append(n.inflight, simPacket{})
+	copy(n.inflight[i+1:], n.inflight[i:])
+	n.inflight[i] = p
+}
+
+// simListener accepts the connections dialed to its address.
+type simListener struct {
+	net    *simNet
+	addr   simAddr
+	accept chan *simConn
+	once   sync.Once
+	done   chan struct{}
+}
+
+func (l *simListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *simListener) Close() error {
+	l.once.Do(func() {
+		l.net.mu.Lock()
+		if l.net.listeners[string(l.addr)] == l {
+			delete(l.net.listeners, string(l.addr))
+		}
+		l.net.mu.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *simListener) Addr() net.Addr { return l.addr }
+
+// simConn is one end of a connection on a simNet. Deadlines are not
+// supported, as the server does not set any.
+type simConn struct {
+	net           *simNet
+	local, remote simAddr
+	peer          *simConn
+	lastAt        time.Time // guarded by net.mu
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	eof    bool
+	closed bool
+	broken bool
+}
+
+func newSimConn(n *simNet, local, remote simAddr) *simConn {
+	c := &simConn{net: n, local: local, remote: remote}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// receive appends data delivered by the network, or marks the end of the
+// stream.
+func (c *simConn) receive(data []byte, fin bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.broken {
+		return
+	}
+	c.buf = append(c.buf, data...)
+	c.eof = c.eof || fin
+	c.cond.Broadcast()
+}
+
+// reset breaks the connection, failing its pending and future reads and
+// writes.
+func (c *simConn) reset() {
+	for _, end := range []*simConn{c, c.peer} {
+		end.mu.Lock()
+		end.broken = true
+		end.buf = nil
+		end.cond.Broadcast()
+		end.mu.Unlock()
+	}
+}
+
+func (c *simConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.buf) == 0 && !c.eof && !c.closed && !c.broken {
+		c.cond.Wait()
+	}
+	switch {
+	case c.closed:
+		return 0, net.ErrClosed
+	case c.broken:
+		return 0, syscall.ECONNRESET
+	case len(c.buf) == 0:
+		return 0, io.EOF
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *simConn) Write(b []byte) (int, error) {
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+
+	c.mu.Lock()
+	closed, broken := c.closed, c.broken
+	c.mu.Unlock()
+	switch {
+	case closed:
+		return 0, net.ErrClosed
+	case broken:
+		return 0, syscall.ECONNRESET
+	}
+
+	c.net.send(c, append([]byte(nil), b...), false)
+	return len(b), nil
+}
+
+func (c *simConn) Close() error {
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	broken := c.broken
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	if !broken {
+		c.net.send(c, nil, true)
+	}
+	delete(c.net.conns, c)
+	return nil
+}
+
+func (c *simConn) LocalAddr() net.Addr                { return c.local }
+func (c *simConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *simConn) SetDeadline(t time.Time) error      { return nil }
+func (c *simConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *simConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// simCluster runs servers electing their leader by discovery on a simNet.
+// Discovery lists the running nodes a node can reach, and every node runs an
+// election every electEvery of simulated time.
+type simCluster struct {
+	t          *testing.T
+	seed       int64
+	net        *simNet
+	addrs      []string
+	electEvery time.Duration
+	elected    time.Time
+
+	mu    sync.Mutex
+	nodes map[string]*Server
+}
+
+// newSimCluster starts n nodes, node-1 to node-n, seeded by -sim.seed or a
+// random seed, which is logged so a failing run can be replayed.
+func newSimCluster(t *testing.T, n int) *simCluster {
+	seed := *simSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("simulation seed %d", seed)
+
+	c := &simCluster{
+		t:          t,
+		seed:       seed,
+		net:        newSimNet(seed, 20*time.Millisecond),
+		nodes:      make(map[string]*Server),
+		electEvery: time.Second,
+	}
+	for i := 1; i <= n; i++ {
+		c.addrs = append(c.addrs, fmt.Sprintf("node-%d:3000", i))
+	}
+	for _, addr := range c.addrs {
+		c.Start(addr)
+	}
+	t.Cleanup(func() {
+		for _, addr := range c.addrs {
+			c.Stop(addr)
+		}
+	})
+	return c
+}
+
+// Start starts the node at addr with an empty cache.
+func (c *simCluster) Start(addr string) {
+	s := NewServer(ServerOpts{
+		ListenAddr:    addr,
+		AdvertiseAddr: addr,
+		Discovery: DiscoveryFunc(func(context.Context) ([]string, error) {
+			return c.peers(addr), nil
+		}),
+		// The simulation runs the elections on its own clock.
+		DiscoveryInterval: 24 * time.Hour,
+		Dial:              c.net.Dialer(addr),
+	}, ggcache.New())
+	c.mu.Lock()
+	c.nodes[addr] = s
+	c.mu.Unlock()
+
+	ln := c.net.Listen(addr)
+	go func() {
+		_ = s.Serve(ln)
+	}()
+}
+
+// Stop closes the node at addr.
+func (c *simCluster) Stop(addr string) {
+	c.mu.Lock()
+	s := c.nodes[addr]
+	delete(c.nodes, addr)
+	c.mu.Unlock()
+
+	if s != nil {
+		_ = s.Close()
+	}
+}
+
+// Node returns the running node at addr, or nil.
+func (c *simCluster) Node(addr string) *Server {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.nodes[addr]
+}
+
+// running returns the running nodes in address order.
+func (c *simCluster) running() []*Server {
+	var nodes []*Server
+	for _, addr := range c.addrs {
+		if s := c.Node(addr); s != nil {
+			nodes = append(nodes, s)
+		}
+	}
+	return nodes
+}
+
+// peers returns the running nodes the node at addr can reach.
+func (c *simCluster) peers(addr string) []string {
+	var peers []string
+	for _, peer := range c.addrs {
+		if c.Node(peer) != nil && c.net.Reachable(addr, peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// Run advances the simulation in steps of 5ms until cond holds, failing the
+// test if it does not within a minute of simulated time.
+func (c *simCluster) Run(cond func() bool) {
+	c.t.Helper()
+
+	deadline := c.net.Now().Add(time.Minute)
+	for !cond() {
+		now := c.net.Now()
+		if now.After(deadline) {
+			c.t.Fatalf("condition not met after a minute of simulated time (-sim.seed=%d)", c.seed)
+		}
+		if now.Sub(c.elected) >= c.electEvery {
+			c.elected = now
+			for _, s := range c.running() {
+				s.elect()
+			}
+		}
+		c.net.Advance(5 * time.Millisecond)
+		// Let the nodes process what was delivered.
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+// Call runs fn, which may wait on the network, while advancing the
+// simulation.
+func (c *simCluster) Call(fn func() error) error {
+	c.t.Helper()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	var err error
+	c.Run(func() bool {
+		select {
+		case err = <-done:
+			return true
+		default:
+			return false
+		}
+	})
+	return err
+}
+
+// Follows reports whether every running node but leader follows it, and
+// leader has all of them as members.
+func (c *simCluster) Follows(leader string) bool {
+	nodes := c.running()
+	if s := c.Node(leader); s == nil || s.MemberCount() != len(nodes)-1 {
+		return false
+	}
+	for _, s := range nodes {
+		if got, _ := s.Topology(); got != leader {
+			return false
+		}
+	}
+	return true
+}
+
+// Has reports whether the node at addr has value under key.
+func (c *simCluster) Has(addr, key, value string) bool {
+	got, err := c.Node(addr).cache.Get([]byte(key))
+	return err == nil && string(got) == value
+}
+
+func TestSimJoin(t *testing.T) {
+	c := newSimCluster(t, 3)
+	c.Run(func() bool { return c.Follows("node-1:3000") })
+
+	assert.Nil(t, c.Node("node-1:3000").set([]byte("foo"), []byte("bar"), 0))
+	c.Run(func() bool {
+		return c.Has("node-2:3000", "foo", "bar") && c.Has("node-3:3000", "foo", "bar")
+	})
+}
+
+func TestSimFailover(t *testing.T) {
+	c := newSimCluster(t, 3)
+	c.Run(func() bool { return c.Follows("node-1:3000") })
+
+	// The next lowest address takes over and replicates its writes.
+	c.Stop("node-1:3000")
+	c.Run(func() bool { return c.Follows("node-2:3000") })
+
+	assert.Nil(t, c.Node("node-2:3000").set([]byte("foo"), []byte("bar"), 0))
+	c.Run(func() bool { return c.Has("node-3:3000", "foo", "bar") })
+
+	// The old leader comes back empty and leads again.
+	c.Start("node-1:3000")
+	c.Run(func() bool { return c.Follows("node-1:3000") })
+}
+
+func TestSimPartition(t *testing.T) {
+	c := newSimCluster(t, 3)
+	c.Run(func() bool { return c.Follows("node-1:3000") })
+
+	// Cut off from the leader, node-3 follows node-2, which still relays the
+	// writes of node-1.
+	c.net.Partition("node-1:3000", "node-3:3000")
+	c.Run(func() bool {
+		leader, _ := c.Node("node-3:3000").Topology()
+		return leader == "node-2:3000" && c.Node("node-2:3000").MemberCount() == 1
+	})
+
+	assert.Nil(t, c.Node("node-1:3000").set([]byte("foo"), []byte("bar"), 0))
+	c.Run(func() bool { return c.Has("node-3:3000", "foo", "bar") })
+
+	// Once healed, node-3 rejoins the leader directly. node-2 drops it once
+	// a forward to the closed connection fails.
+	c.net.Heal("node-1:3000", "node-3:3000")
+	c.Run(func() bool {
+		leader, _ := c.Node("node-3:3000").Topology()
+		return leader == "node-1:3000" && c.Node("node-1:3000").MemberCount() == 2
+	})
+
+	assert.Nil(t, c.Node("node-1:3000").set([]byte("foo"), []byte("baz"), 0))
+	c.Run(func() bool {
+		return c.Has("node-2:3000", "foo", "baz") && c.Has("node-3:3000", "foo", "baz") &&
+			c.Node("node-2:3000").MemberCount() == 0
+	})
+}
+
+func TestSimNetDeterministic(t *testing.T) {
+	// The same seed delivers the writes of concurrent connections at the
+	// same simulated times.
+	trace := func(seed int64) []string {
+		n := newSimNet(seed, 20*time.Millisecond)
+		ln := n.Listen("b")
+		defer ln.Close()
+
+		for _, from := range []string{"a", "c"} {
+			conn, err := n.Dialer(from)(context.Background(), "tcp", "b")
+			assert.Nil(t, err)
+			for i := 0; i < 5; i++ {
+				_, err = conn.Write([]byte(from))
+				assert.Nil(t, err)
+			}
+			assert.Nil(t, conn.Close())
+		}
+		n.Advance(time.Second)
+		return n.trace
+	}
+	assert.Equal(t, trace(42), trace(42))
+	assert.Len(t, trace(42), 12)
+
+	n := newSimNet(42, time.Millisecond)
+	n.Listen("b")
+	n.Partition("a", "b")
+	_, err := n.Dialer("a")(context.Background(), "tcp", "b")
+	assert.True(t, errors.Is(err, syscall.ECONNREFUSED))
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/cache/dense"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// Stats returns the server and cache metrics reported by the STATS command.
+// Names ending in _total are monotonically increasing counters, all others
+// are gauges.
+func (s *Server) Stats() []proto.Stat {
+	var stats []proto.Stat
+
+	if p, ok := s.cache.(ggcache.StatsProvider); ok {
+		cs := p.Stats()
+		stats = append(stats,
+			proto.Stat{Name: "cache_hits_total", Value: int64(cs.Hits)},
+			proto.Stat{Name: "cache_misses_total", Value: int64(cs.Misses)},
+			proto.Stat{Name: "cache_sets_total", Value: int64(cs.Sets)},
+			proto.Stat{Name: "cache_deletes_total", Value: int64(cs.Deletes)},
+			proto.Stat{Name: "cache_expirations_total", Value: int64(cs.Expirations)},
+			proto.Stat{Name: "cache_lazy_expirations_total", Value: int64(cs.LazyExpirations)},
+			proto.Stat{Name: "cache_evictions_total", Value: int64(cs.Evictions)},
+			proto.Stat{Name: "cache_corrupted_total", Value: int64(cs.Corrupted)},
+			proto.Stat{Name: "cache_keys", Value: int64(cs.Keys)},
+			proto.Stat{Name: "cache_bytes", Value: int64(cs.Bytes)},
+		)
+		for i, n := range cs.TTLs {
+			stats = append(stats, proto.Stat{Name: "cache_ttl_keys_le_" + ttlLabels[i], Value: int64(n)})
+		}
+	}
+	stats = append(stats, s.expiryStats()...)
+	if p, ok := s.cache.(interface{ PrefixStats() dense.PrefixStats }); ok {
+		ps := p.PrefixStats()
+		stats = append(stats,
+			proto.Stat{Name: "cache_key_prefixes", Value: int64(ps.Prefixes)},
+			proto.Stat{Name: "cache_key_prefix_saved_bytes", Value: int64(ps.SavedBytes)},
+		)
+	}
+	if p, ok := s.cache.(interface{ SlabStats() dense.SlabStats }); ok {
+		ss := p.SlabStats()
+		stats = append(stats,
+			proto.Stat{Name: "cache_slab_bytes", Value: int64(ss.Bytes)},
+			proto.Stat{Name: "cache_slab_garbage_bytes", Value: int64(ss.Garbage)},
+			proto.Stat{Name: "cache_slab_capacity_bytes", Value: int64(ss.Capacity)},
+			proto.Stat{Name: "cache_slab_compactions_total", Value: int64(ss.Compactions)},
+			proto.Stat{Name: "cache_table_slots", Value: int64(ss.Slots)},
+			proto.Stat{Name: "cache_table_tombstones", Value: int64(ss.Tombstones)},
+		)
+	}
+	if p, ok := s.cache.(ttlClamper); ok {
+		var clamped uint64
+		for _, n := range p.ClampedTTLs() {
+			clamped += n
+		}
+		stats = append(stats, proto.Stat{Name: "cache_ttl_clamped_total", Value: int64(clamped)})
+	}
+
+	s.mu.Lock()
+	conns, members := len(s.conns), len(s.members)
+	s.mu.Unlock()
+
+	isLeader := int64(0)
+	if s.Role() == RoleLeader {
+		isLeader = 1
+	}
+	tombstones, tombstoneBytes := s.tombstones.stats()
+	degraded := int64(0)
+	if s.PersistenceReport().Degraded {
+		degraded = 1
+	}
+
+	stats = append(stats,
+		proto.Stat{Name: "server_connections", Value: int64(conns)},
+		proto.Stat{Name: "server_members", Value: int64(members)},
+		proto.Stat{Name: "server_is_leader", Value: isLeader},
+		proto.Stat{Name: "server_uptime_seconds", Value: int64(time.Since(s.started).Seconds())},
+		proto.Stat{Name: "server_replication_batches_total", Value: int64(s.replication.batches.Load())},
+		proto.Stat{Name: "server_replication_commands_total", Value: int64(s.replication.commands.Load())},
+		proto.Stat{Name: "server_replication_coalesced_total", Value: int64(s.replication.coalesced.Load())},
+		proto.Stat{Name: "server_replication_offset", Value: int64(s.offsets.load())},
+		proto.Stat{Name: "server_session_waits_total", Value: int64(s.offsets.waited.Load())},
+		proto.Stat{Name: "server_session_redirects_total", Value: int64(s.offsets.redirected.Load())},
+		proto.Stat{Name: "server_clock_offset_milliseconds", Value: s.ClockOffset().Milliseconds()},
+		proto.Stat{Name: "server_replication_ttl_clamped_total", Value: int64(s.clock.clamped.Load())},
+		proto.Stat{Name: "server_leases_granted_total", Value: int64(s.leases.granted.Load())},
+		proto.Stat{Name: "server_leases_held_total", Value: int64(s.leases.held.Load())},
+		proto.Stat{Name: "server_unauthorized_total", Value: int64(s.tenants.unauthorized.Load())},
+		proto.Stat{Name: "server_quota_exceeded_total", Value: int64(s.tenants.throttled.Load())},
+		proto.Stat{Name: "server_joins_rejected_total", Value: int64(s.tenants.rejectedJoins.Load())},
+		proto.Stat{Name: "server_cancelled_total", Value: int64(s.inflight.cancelled.Load())},
+		proto.Stat{Name: "server_deadline_exceeded_total", Value: int64(s.inflight.expired.Load())},
+		proto.Stat{Name: "server_command_timeouts_total", Value: int64(s.inflight.timedOut.Load())},
+		proto.Stat{Name: "server_handlers", Value: s.limits.handlers.Load()},
+		proto.Stat{Name: "server_inflight_bytes", Value: s.limits.bytes.Load()},
+		proto.Stat{Name: "server_shed_handlers_total", Value: int64(s.limits.shedHandlers.Load())},
+		proto.Stat{Name: "server_shed_bytes_total", Value: int64(s.limits.shedBytes.Load())},
+		proto.Stat{Name: "server_noreply_total", Value: int64(s.noReplies.total.Load())},
+		proto.Stat{Name: "server_noreply_failed_total", Value: int64(s.noReplies.failed.Load())},
+		proto.Stat{Name: "server_persistence_degraded", Value: degraded},
+		proto.Stat{Name: "server_health", Value: int64(s.Health())},
+		proto.Stat{Name: "server_persistence_rejected_total", Value: int64(s.persistence.rejected.Load())},
+		proto.Stat{Name: "server_syncs_total", Value: int64(s.syncs.sent.Load())},
+		proto.Stat{Name: "server_sync_bytes_total", Value: int64(s.syncs.bytes.Load())},
+		proto.Stat{Name: "server_tombstones", Value: int64(tombstones)},
+		proto.Stat{Name: "server_tombstone_bytes", Value: int64(tombstoneBytes)},
+		proto.Stat{Name: "server_undeleted_total", Value: int64(s.tombstones.restored.Load())},
+		proto.Stat{Name: "server_tombstones_purged_total", Value: int64(s.tombstones.purged.Load())},
+		proto.Stat{Name: "server_webhook_events_total", Value: int64(s.webhook.sent.Load())},
+		proto.Stat{Name: "server_webhook_failed_total", Value: int64(s.webhook.failed.Load())},
+		proto.Stat{Name: "server_webhook_dropped_total", Value: int64(s.webhook.dropped.Load())},
+		proto.Stat{Name: "server_pubsub_subscribers", Value: int64(s.channels.subscribers())},
+		proto.Stat{Name: "server_pubsub_published_total", Value: int64(s.channels.published.Load())},
+		proto.Stat{Name: "server_pubsub_delivered_total", Value: int64(s.channels.delivered.Load())},
+		proto.Stat{Name: "server_pubsub_dropped_subscribers_total", Value: int64(s.channels.dropped.Load())},
+	)
+	if s.scheduler != nil {
+		for p, n := range s.scheduler.queued() {
+			stats = append(stats, proto.Stat{
+				Name:  "server_queued_" + Priority(p).String() + "_commands",
+				Value: int64(n),
+			})
+		}
+	}
+
+	// The buffer pool is shared by every server and client in the process.
+	ps := proto.BufferPoolStats()
+	stats = append(stats,
+		proto.Stat{Name: "proto_buffer_gets_total", Value: int64(ps.Gets)},
+		proto.Stat{Name: "proto_buffer_allocs_total", Value: int64(ps.Allocs)},
+		proto.Stat{Name: "proto_buffer_drops_total", Value: int64(ps.Drops)},
+	)
+
+	return stats
+}
+
+func (s *Server) handleStatsCommand(conn net.Conn, _ *proto.CommandStats) error {
+	resp := proto.ResponseStats{
+		Status: proto.StatusOK,
+		Stats:  s.Stats(),
+	}
+	_, err := conn.Write(resp.Bytes())
+
+	return err
+}
+
+// ttlLabels name the buckets of ggcache.Stats.TTLs in the cache_ttl_keys_le_
+// gauges.
+var ttlLabels = [len(ggcache.TTLBuckets) + 1]string{"1s", "10s", "1m", "10m", "1h", "6h", "1d", "7d", "inf"}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// StatsAPIVersion is the version of the StatsReport schema. Fields are only
+// ever added within a version.
+const StatsAPIVersion = 1
+
+// StatsReport is the document served on /api/v1/stats of the admin listener.
+type StatsReport struct {
+	Version int `json:"version"`
+	// Time the report was taken.
+	Time       time.Time                 `json:"time"`
+	Node       NodeReport                `json:"node"`
+	Cluster    ClusterReport             `json:"cluster"`
+	Commands   map[string]CommandReport  `json:"commands"`
+	Namespaces map[string]NamespaceStats `json:"namespaces"`
+	// ValueSizes is the histogram of the sizes of the values written, and
+	// NamespaceValueSizes those of each namespace written to.
+	ValueSizes          SizeReport            `json:"value_sizes"`
+	NamespaceValueSizes map[string]SizeReport `json:"namespace_value_sizes"`
+}
+
+// NodeReport describes this node. Cache is omitted if the Cacher does not
+// implement ggcache.StatsProvider.
+type NodeReport struct {
+	ListenAddr    string       `json:"listen_addr"`
+	AdvertiseAddr string       `json:"advertise_addr,omitempty"`
+	Zone          string       `json:"zone,omitempty"`
+	UptimeSeconds int64        `json:"uptime_seconds"`
+	Connections   int          `json:"connections"`
+	Cache         *CacheReport `json:"cache,omitempty"`
+	// Expiry is omitted if the Cacher does not implement ggcache.Sweeper.
+	Expiry *ExpiryReport `json:"expiry,omitempty"`
+	// Persistence flags the node as degraded while its writes are not
+	// persisted.
+	Persistence PersistenceReport `json:"persistence"`
+	// Version and Commit identify the build of the node, and Capabilities
+	// are its optional features, as reported by INFO.
+	Version      string   `json:"version"`
+	Commit       string   `json:"commit,omitempty"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// CacheReport holds the counters of the cache since the node started.
+type CacheReport struct {
+	Hits            uint64 `json:"hits"`
+	Misses          uint64 `json:"misses"`
+	Sets            uint64 `json:"sets"`
+	Deletes         uint64 `json:"deletes"`
+	Expirations     uint64 `json:"expirations"`
+	LazyExpirations uint64 `json:"lazy_expirations"`
+	Evictions       uint64 `json:"evictions"`
+	Corrupted       uint64 `json:"corrupted"`
+	Keys            int    `json:"keys"`
+	Bytes           int    `json:"bytes"`
+	// TTLKeys is the histogram of the TTLs of the keys stored with one.
+	// It has one more element than TTLBoundsSeconds, the last one counting
+	// everything above.
+	TTLBoundsSeconds []float64 `json:"ttl_bounds_seconds"`
+	TTLKeys          []int     `json:"ttl_keys"`
+	// HitRatio is Hits over Hits and Misses, zero before the first read.
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// ClusterReport describes the cluster as seen from this node.
+type ClusterReport struct {
+	Role string `json:"role"`
+	// Leader is the address of the leader, empty on the leader itself.
+	Leader  string `json:"leader,omitempty"`
+	Members int    `json:"members"`
+	// Replicas are the read endpoints the node answers TOPOLOGY with.
+	Replicas []string `json:"replicas,omitempty"`
+}
+
+// CommandReport is the latency histogram of a command. Counts has one more
+// element than BoundsSeconds, the last one counting everything above.
+type CommandReport struct {
+	Count         uint64    `json:"count"`
+	SumSeconds    float64   `json:"sum_seconds"`
+	BoundsSeconds []float64 `json:"bounds_seconds"`
+	Counts        []uint64  `json:"counts"`
+}
+
+// SizeReport is the histogram of the sizes of values. Counts has one more
+// element than BoundsBytes, the last one counting everything above.
+type SizeReport struct {
+	Count       uint64   `json:"count"`
+	SumBytes    uint64   `json:"sum_bytes"`
+	MaxBytes    int      `json:"max_bytes"`
+	BoundsBytes []int    `json:"bounds_bytes"`
+	Counts      []uint64 `json:"counts"`
+}
+
+func newSizeReport(h SizeHistogram) SizeReport {
+	return SizeReport{
+		Count:       h.Count,
+		SumBytes:    h.Sum,
+		MaxBytes:    h.Max,
+		BoundsBytes: h.Bounds,
+		Counts:      h.Counts,
+	}
+}
+
+// StatsReport returns the stats of the server served by the stats API.
+func (s *Server) StatsReport() StatsReport {
+	s.mu.Lock()
+	conns, members, leader := len(s.conns), len(s.members), s.leader
+	s.mu.Unlock()
+
+	report := StatsReport{
+		Version: StatsAPIVersion,
+		Time:    time.Now().UTC(),
+		Node: NodeReport{
+			Version:       Version,
+			Commit:        buildCommit(),
+			Capabilities:  s.Capabilities(),
+			ListenAddr:    s.ListenAddr,
+			AdvertiseAddr: s.AdvertiseAddr,
+			Zone:          s.Zone,
+			UptimeSeconds: int64(time.Since(s.started).Seconds()),
+			Connections:   conns,
+			Persistence:   s.PersistenceReport(),
+		},
+		Cluster: ClusterReport{
+			Role:    s.Role(),
+			Leader:  leader,
+			Members: members,
+		},
+		Commands:   make(map[string]CommandReport),
+		Namespaces: s.NamespaceStats(),
+	}
+	if addr := s.Addr(); addr != nil {
+		report.Node.ListenAddr = addr.String()
+	}
+	_, report.Cluster.Replicas = s.Topology()
+
+	if p, ok := s.cache.(ggcache.StatsProvider); ok {
+		cs := p.Stats()
+		report.Node.Cache = &CacheReport{
+			Hits:            cs.Hits,
+			Misses:          cs.Misses,
+			Sets:            cs.Sets,
+			Deletes:         cs.Deletes,
+			Expirations:     cs.Expirations,
+			LazyExpirations: cs.LazyExpirations,
+			Evictions:       cs.Evictions,
+			Corrupted:       cs.Corrupted,
+			Keys:            cs.Keys,
+			Bytes:           cs.Bytes,
+			TTLKeys:         cs.TTLs[:],
+		}
+		for _, bound := range ggcache.TTLBuckets {
+			report.Node.Cache.TTLBoundsSeconds = append(report.Node.Cache.TTLBoundsSeconds, bound.Seconds())
+		}
+		if reads := cs.Hits + cs.Misses; reads > 0 {
+			report.Node.Cache.HitRatio = float64(cs.Hits) / float64(reads)
+		}
+	}
+
+	if sw, ok := s.cache.(ggcache.Sweeper); ok {
+		report.Node.Expiry = newExpiryReport(sw.ExpiryStats())
+	}
+
+	for name, h := range s.CommandLatencies() {
+		report.Commands[name] = CommandReport{
+			Count:         h.Count,
+			SumSeconds:    h.Sum,
+			BoundsSeconds: h.Bounds,
+			Counts:        h.Counts,
+		}
+	}
+
+	sizes, namespaces := s.ValueSizes()
+	report.ValueSizes = newSizeReport(sizes)
+	report.NamespaceValueSizes = make(map[string]SizeReport, len(namespaces))
+	for name, h := range namespaces {
+		report.NamespaceValueSizes[name] = newSizeReport(h)
+	}
+
+	return report
+}
+
+func (s *Server) handleStatsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := json.Marshal(s.StatsReport())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// streamMagic starts the values holding a stream, which are otherwise
+// stored like any other value and so replicated, snapshotted and expired
+// with the rest of the cache.
+const streamMagic = "ggstream1"
+
+// streamEntryHeader is the size of the ID and value length of an entry.
+const streamEntryHeader = 8 + 8 + 4
+
+var (
+	// errNotStream is returned for a key holding a value that is not a
+	// stream.
+	errNotStream = errors.New("the value is not a stream")
+
+	// errStreamID is returned by an XADD whose ID is not greater than the ID
+	// of the last entry of the stream.
+	errStreamID = errors.New("the stream id is not greater than the last one")
+)
+
+// streamTable serializes the writes of streams, which rewrite their value,
+// and wakes up the XREADs waiting for an entry.
+type streamTable struct {
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
+}
+
+// wait returns a channel closed once an entry is added to the stream at key.
+// The caller must hold mu.
+func (t *streamTable) wait(key []byte) chan struct{} {
+	if t.waiters == nil {
+		t.waiters = make(map[string][]chan struct{})
+	}
+	ch := make(chan struct{})
+	t.waiters[string(key)] = append(t.waiters[string(key)], ch)
+	return ch
+}
+
+// cancel forgets a channel returned by wait that was not closed.
+func (t *streamTable) cancel(key []byte, ch chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	waiters := t.waiters[string(key)]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(t.waiters, string(key))
+	} else {
+		t.waiters[string(key)] = waiters
+	}
+}
+
+// notify wakes up the waiters of the stream at key.
+// The caller must hold mu.
+func (t *streamTable) notify(key []byte) {
+	for _, ch := range t.waiters[string(key)] {
+		close(ch)
+	}
+	delete(t.waiters, string(key))
+}
+
+// decodeStream returns the entries of a stream value, sharing its memory.
+func decodeStream(b []byte) ([]proto.StreamEntry, error) {
+	if !bytes.HasPrefix(b, []byte(streamMagic)) {
+		return nil, errNotStream
+	}
+	b = b[len(streamMagic):]
+
+	var entries []proto.StreamEntry
+	for len(b) > 0 {
+		if len(b) < streamEntryHeader {
+			return nil, errNotStream
+		}
+		id := proto.StreamID{
+			Ms:  binary.LittleEndian.Uint64(b),
+			Seq: binary.LittleEndian.Uint64(b[8:]),
+		}
+		n := int(binary.LittleEndian.Uint32(b[16:]))
+		b = b[streamEntryHeader:]
+		if len(b) < n {
+			return nil, errNotStream
+		}
+		entries = append(entries, proto.StreamEntry{ID: id, Value: b[:n:n]})
+		b = b[n:]
+	}
+	return entries, nil
+}
+
+func encodeStream(entries []proto.StreamEntry) []byte {
+	size := len(streamMagic)
+	for _, e := range entries {
+		size += streamEntryHeader + len(e.Value)
+	}
+	b := make([]byte, 0, size)
+	b = append(b, streamMagic...)
+	for _, e := range entries {
+		b = binary.LittleEndian.AppendUint64(b, e.ID.Ms)
+		b = binary.LittleEndian.AppendUint64(b, e.ID.Seq)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(e.Value)))
+		b = append(b, e.Value...)
+	}
+	return b
+}
+
+// readStream returns the entries of the stream at key, none if it is
+// missing.
+func (s *Server) readStream(key []byte) ([]proto.StreamEntry, error) {
+	value, err := s.cache.Get(key)
+	if errors.Is(err, ggcache.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeStream(value)
+}
+
+func (s *Server) handleXAddCommand(conn net.Conn, cmd *proto.CommandXAdd) error {
+	resp := proto.ResponseXAdd{Status: proto.StatusOK}
+	id, err := s.xadd(cmd, false)
+	switch {
+	case err == nil:
+		resp.ID = id
+	case errors.Is(err, errInvalidValue):
+		resp.Status = proto.StatusInvalidValue
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+	default:
+		resp.Status = proto.StatusError
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+// xadd adds the entry of the command to its stream, forwards it to the
+// members with its ID and publishes an xadd event with the value. The
+// entries replicated from the leader may arrive out of order, so they are
+// inserted in order of their ID and skipped if already there.
+func (s *Server) xadd(cmd *proto.CommandXAdd, replicated bool) (proto.StreamID, error) {
+	if s.validator(cmd.Key) != nil {
+		return proto.StreamID{}, fmt.Errorf("%w: the namespace does not allow streams", errInvalidValue)
+	}
+
+	s.streams.mu.Lock()
+	defer s.streams.mu.Unlock()
+
+	entries, err := s.readStream(cmd.Key)
+	if err != nil {
+		return proto.StreamID{}, err
+	}
+
+	id := cmd.ID
+	var last proto.StreamID
+	if len(entries) != 0 {
+		last = entries[len(entries)-1].ID
+	}
+	switch {
+	case id.IsZero():
+		id = proto.StreamID{Ms: uint64(time.Now().UnixMilli())}
+		if !last.Less(id) {
+			id = proto.StreamID{Ms: last.Ms, Seq: last.Seq + 1}
+		}
+		entries = append(entries, proto.StreamEntry{ID: id, Value: cmd.Value})
+	case last.Less(id):
+		entries = append(entries, proto.StreamEntry{ID: id, Value: cmd.Value})
+	case !replicated:
+		return proto.StreamID{}, errStreamID
+	default:
+		i := sort.Search(len(entries), func(i int) bool { return !entries[i].ID.Less(id) })
+		if entries[i].ID == id {
+			return id, nil
+		}
+		entries = append(entries[:i], append([]proto.StreamEntry{{ID: id, Value: cmd.Value}}, entries[i:]...)...)
+	}
+	if cmd.MaxLen > 0 && uint64(len(entries)) > cmd.MaxLen {
+		entries = entries[uint64(len(entries))-cmd.MaxLen:]
+	}
+
+	ttl := time.Duration(cmd.TTL) * time.Millisecond
+	if err := s.cache.Set(cmd.Key, encodeStream(entries), ttl); err != nil {
+		return proto.StreamID{}, err
+	}
+
+	s.replicate(&proto.CommandXAdd{Key: cmd.Key, ID: id, Value: cmd.Value, MaxLen: cmd.MaxLen, TTL: cmd.TTL})
+	s.leases.invalidate(cmd.Key)
+
+	s.countNamespace(cmd.Key, func(ns *NamespaceStats) { ns.Sets++ })
+
+	s.events.publish(KeyspaceEvent{Op: "xadd", Key: cmd.Key, Value: cmd.Value})
+	s.streams.notify(cmd.Key)
+	return id, nil
+}
+
+func (s *Server) handleXRangeCommand(conn net.Conn, cmd *proto.CommandXRange) error {
+	resp := proto.ResponseXRange{Status: proto.StatusOK}
+	value, err := s.cache.Get(cmd.Key)
+	s.countNamespace(cmd.Key, func(ns *NamespaceStats) {
+		if err != nil {
+			ns.Misses++
+		} else {
+			ns.Hits++
+		}
+	})
+	if err != nil {
+		resp.Status = proto.StatusKeyNotFound
+		return proto.WriteMessage(conn, &resp)
+	}
+	entries, err := decodeStream(value)
+	if err != nil {
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	}
+
+	i := sort.Search(len(entries), func(i int) bool { return !entries[i].ID.Less(cmd.Start) })
+	for ; i < len(entries) && !cmd.End.Less(entries[i].ID); i++ {
+		if cmd.Count > 0 && uint64(len(resp.Entries)) == cmd.Count {
+			break
+		}
+		resp.Entries = append(resp.Entries, entries[i])
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+func (s *Server) handleXReadCommand(conn net.Conn, cmd *proto.CommandXRead) error {
+	resp := proto.ResponseXRange{Status: proto.StatusOK}
+	entries, err := s.xread(cmd)
+	if err != nil {
+		resp.Status = proto.StatusError
+		return proto.WriteMessage(conn, &resp)
+	}
+	resp.Entries = entries
+	return proto.WriteMessage(conn, &resp)
+}
+
+// xread returns the entries of the stream after cmd.After, waiting up to
+// cmd.Block for one to be added if there are none yet.
+func (s *Server) xread(cmd *proto.CommandXRead) ([]proto.StreamEntry, error) {
+	after := cmd.After
+	deadline := time.Now().Add(time.Duration(cmd.Block) * time.Millisecond)
+	for {
+		s.streams.mu.Lock()
+		entries, err := s.readStream(cmd.Key)
+		if err != nil {
+			s.streams.mu.Unlock()
+			return nil, err
+		}
+		if after == proto.MaxStreamID {
+			// The entries added from now on are read.
+			after = proto.StreamID{}
+			if len(entries) != 0 {
+				after = entries[len(entries)-1].ID
+			}
+		}
+
+		i := sort.Search(len(entries), func(i int) bool { return after.Less(entries[i].ID) })
+		if i < len(entries) {
+			s.streams.mu.Unlock()
+			entries = entries[i:]
+			if cmd.Count > 0 && uint64(len(entries)) > cmd.Count {
+				entries = entries[:cmd.Count]
+			}
+			return entries, nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			s.streams.mu.Unlock()
+			return nil, nil
+		}
+		ch := s.streams.wait(cmd.Key)
+		s.streams.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ch:
+			timer.Stop()
+		case <-timer.C:
+			s.streams.cancel(cmd.Key, ch)
+			return nil, nil
+		case <-s.quitch:
+			timer.Stop()
+			s.streams.cancel(cmd.Key, ch)
+			return nil, nil
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, nil)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	key := []byte("events")
+	_, err = lc.XRange(ctx, key, proto.StreamID{}, proto.MaxStreamID, 0)
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+
+	var ids []proto.StreamID
+	for _, value := range []string{"a", "b", "c", "d"} {
+		id, err := lc.XAdd(ctx, key, []byte(value), 3, 0)
+		assert.Nil(t, err)
+		if len(ids) != 0 {
+			assert.True(t, ids[len(ids)-1].Less(id))
+		}
+		ids = append(ids, id)
+	}
+
+	// The oldest entry was trimmed.
+	entries, err := lc.XRange(ctx, key, proto.StreamID{}, proto.MaxStreamID, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []proto.StreamEntry{
+		{ID: ids[1], Value: []byte("b")},
+		{ID: ids[2], Value: []byte("c")},
+		{ID: ids[3], Value: []byte("d")},
+	}, entries)
+
+	// The entries are replicated with the IDs of the leader.
+	assert.Eventually(t, func() bool {
+		replicated, err := fc.XRange(ctx, key, proto.StreamID{}, proto.MaxStreamID, 0)
+		return err == nil && assert.ObjectsAreEqual(entries, replicated)
+	}, time.Second, 10*time.Millisecond)
+
+	entries, err = lc.XRange(ctx, key, ids[2], proto.MaxStreamID, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []proto.StreamEntry{{ID: ids[2], Value: []byte("c")}}, entries)
+
+	entries, err = lc.XRead(ctx, key, ids[2], 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []proto.StreamEntry{{ID: ids[3], Value: []byte("d")}}, entries)
+
+	// Nothing is added before the block runs out.
+	start := time.Now()
+	entries, err = lc.XRead(ctx, key, ids[3], 0, 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// A blocked XREAD returns the entry another client adds.
+	other, err := client.New(leader.Addr().String(), client.Options{})
+	assert.Nil(t, err)
+	defer other.Close()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		other.XAdd(ctx, key, []byte("e"), 0, 0)
+	}()
+	entries, err = lc.XRead(ctx, key, proto.MaxStreamID, 0, 5*time.Second)
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, []byte("e"), entries[0].Value)
+		assert.True(t, ids[3].Less(entries[0].ID))
+	}
+
+	// A stream is not a plain value and the other way round.
+	assert.Nil(t, lc.Set(ctx, []byte("plain"), []byte("value"), 0))
+	_, err = lc.XAdd(ctx, []byte("plain"), []byte("a"), 0, 0)
+	assert.NotNil(t, err)
+	_, err = lc.XRange(ctx, []byte("plain"), proto.StreamID{}, proto.MaxStreamID, 0)
+	assert.NotNil(t, err)
+}
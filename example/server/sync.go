@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// DefaultSyncChunkBytes is the size of the snapshot chunks sent to a
+// syncing follower if SyncChunkBytes is not set.
+const DefaultSyncChunkBytes = 64 << 10
+
+var (
+	// errNoSnapshot is returned by a sync, or by ExportRDB, if the cache
+	// is not a ggcache.Snapshotter.
+	errNoSnapshot = errors.New("the cache does not support snapshots")
+
+	// errSyncRestarted aborts the restore of a snapshot when the leader
+	// starts sending another one.
+	errSyncRestarted = errors.New("sync restarted")
+)
+
+// syncState is the snapshot a follower is receiving from its leader, piped
+// into the restore of the cache as the chunks arrive.
+type syncState struct {
+	mu   sync.Mutex
+	seq  uint64
+	w    *io.PipeWriter
+	done chan error
+
+	// restoring is set while a snapshot is being restored, as the reads
+	// miss the keys it did not restore yet.
+	restoring atomic.Bool
+
+	// sent and bytes count the snapshots this node sent in full to its
+	// members and the bytes of the chunks.
+	sent  atomic.Uint64
+	bytes atomic.Uint64
+}
+
+// abort stops the restore in progress, if any.
+// The caller must hold st.mu.
+func (st *syncState) abort(err error) {
+	if st.w == nil {
+		return
+	}
+	_ = st.w.CloseWithError(err)
+	<-st.done
+	st.w, st.done = nil, nil
+	st.restoring.Store(false)
+}
+
+func (s *Server) handleSyncCommand(conn net.Conn, cmd *proto.CommandSync) error {
+	resp := proto.ResponseSet{Status: proto.StatusOK}
+	if err := s.receiveSync(cmd); err != nil {
+		log.Println("sync error:", err)
+		resp.Status = proto.StatusError
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+// receiveSync feeds a chunk of the snapshot to its restore, which the first
+// chunk starts, and waits for the restore to complete on the final one.
+func (s *Server) receiveSync(cmd *proto.CommandSync) error {
+	st := &s.syncs
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if cmd.Seq == 0 {
+		snap, ok := s.cache.(ggcache.Snapshotter)
+		if !ok {
+			return errNoSnapshot
+		}
+		st.abort(errSyncRestarted)
+
+		r, w := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			err := snap.Restore(r)
+			// Fail the writes of the chunks left if the snapshot is invalid.
+			_ = r.CloseWithError(err)
+			done <- err
+		}()
+		st.seq, st.w, st.done = 0, w, done
+		st.restoring.Store(true)
+	}
+	if st.w == nil || cmd.Seq != st.seq {
+		st.abort(errSyncRestarted)
+		return fmt.Errorf("unexpected snapshot chunk %d", cmd.Seq)
+	}
+
+	if len(cmd.Data) != 0 {
+		if _, err := st.w.Write(cmd.Data); err != nil {
+			st.abort(err)
+			return err
+		}
+	}
+	st.seq++
+	if !cmd.Final {
+		return nil
+	}
+
+	_ = st.w.Close()
+	err := <-st.done
+	st.w, st.done = nil, nil
+	st.restoring.Store(false)
+	return err
+}
+
+// syncMember sends a snapshot of the cache to a member that just joined and
+// then the mutations held for it in the meantime, after which it is
+// forwarded the mutations like the other members. A member that cannot
+// restore the snapshot is still kept, without the data set before it joined.
+func (s *Server) syncMember(member *client.Client) {
+	if err := s.sendSnapshot(member); err != nil {
+		log.Println("sync member error:", err)
+	}
+
+	for {
+		s.mu.Lock()
+		held, ok := s.syncing[member]
+		if !ok || len(held) == 0 {
+			delete(s.syncing, member)
+			s.mu.Unlock()
+			return
+		}
+		s.syncing[member] = nil
+		s.mu.Unlock()
+
+		if err := member.Batch(context.TODO(), held); err != nil {
+			log.Println("replicate to member error:", err)
+			s.removeMember(member)
+			return
+		}
+	}
+}
+
+// sendSnapshot streams a snapshot of the cache to the member in chunks, each
+// acknowledged before the next is sent, at up to SyncBandwidth bytes per
+// second.
+func (s *Server) sendSnapshot(member *client.Client) error {
+	snap, ok := s.cache.(ggcache.Snapshotter)
+	if !ok {
+		return errNoSnapshot
+	}
+	buf := new(bytes.Buffer)
+	if err := snap.Snapshot(buf); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	chunkBytes := s.SyncChunkBytes
+	if chunkBytes <= 0 {
+		chunkBytes = DefaultSyncChunkBytes
+	}
+
+	var (
+		start = time.Now()
+		sent  int
+	)
+	for seq := uint64(0); ; seq++ {
+		if s.SyncBandwidth > 0 {
+			// A chunk is not sent before the bandwidth allows for the bytes
+			// sent ahead of it.
+			due := start.Add(time.Duration(float64(sent) / float64(s.SyncBandwidth) * float64(time.Second)))
+			select {
+			case <-s.quitch:
+				return net.ErrClosed
+			case <-time.After(time.Until(due)):
+			}
+		}
+
+		data := buf.Next(chunkBytes)
+		final := buf.Len() == 0
+		if err := member.Sync(context.TODO(), seq, data, final); err != nil {
+			return fmt.Errorf("send snapshot chunk %d: %w", seq, err)
+		}
+		sent += len(data)
+		s.syncs.bytes.Add(uint64(len(data)))
+		if final {
+			s.syncs.sent.Add(1)
+			return nil
+		}
+	}
+}
+
+// replicaMembers returns the members to forward the mutations to, and holds
+// them for the members that are syncing, which get them once they restored
+// the snapshot. Mutations made while the snapshot was taken may be applied
+// twice by these members.
+func (s *Server) replicaMembers(cmds ...proto.Appender) []*client.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := make([]*client.Client, 0, len(s.members))
+	for member := range s.members {
+		if held, ok := s.syncing[member]; ok {
+			s.syncing[member] = append(held, cmds...)
+			continue
+		}
+		members = append(members, member)
+	}
+	return members
+}
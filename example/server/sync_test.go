@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFullSync(t *testing.T) {
+	data := ggcache.New()
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		assert.Nil(t, data.Set(key, make([]byte, 100), time.Hour))
+	}
+
+	leader, c, err := StartEmbedded(ServerOpts{
+		IsLeader:       true,
+		FullSync:       true,
+		SyncChunkBytes: 1024,
+	}, data)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	// The keys set before the follower joined are restored, in several chunks.
+	assert.Eventually(t, func() bool {
+		return leader.syncs.sent.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 100, cache.Stats().Keys)
+	assert.Greater(t, leader.syncs.bytes.Load(), uint64(10*1024))
+
+	// And the member is forwarded the mutations once synced.
+	assert.Nil(t, c.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+	assert.Eventually(t, func() bool {
+		return cache.Has([]byte("foo"))
+	}, time.Second, 10*time.Millisecond)
+
+	stats, err := c.Stats(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), stats["server_syncs_total"])
+}
+
+func TestFullSyncBandwidth(t *testing.T) {
+	data := ggcache.New()
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		assert.Nil(t, data.Set(key, make([]byte, 1000), time.Hour))
+	}
+
+	leader, c, err := StartEmbedded(ServerOpts{
+		IsLeader:       true,
+		FullSync:       true,
+		SyncBandwidth:  20 << 10,
+		SyncChunkBytes: 2 << 10,
+	}, data)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	start := time.Now()
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	// A mutation made while the snapshot is streamed is held until the end.
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Nil(t, c.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+
+	// About 10KB at 20KB/s: the last chunk is not sent before 400ms.
+	assert.Eventually(t, func() bool {
+		return leader.syncs.sent.Load() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return cache.Has([]byte("foo"))
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 11, cache.Stats().Keys)
+}
+
+func TestSyncOnlyFromLeader(t *testing.T) {
+	cache := ggcache.New()
+	assert.Nil(t, cache.Set([]byte("foo"), []byte("bar"), 0))
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true}, cache)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// A client cannot replace the cache with a snapshot of its own.
+	var buf bytes.Buffer
+	assert.Nil(t, ggcache.New().Snapshot(&buf))
+	assert.ErrorIs(t, c.Sync(context.Background(), 0, buf.Bytes(), true), client.ErrUnauthorized)
+	assert.True(t, cache.Has([]byte("foo")))
+}
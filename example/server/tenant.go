@@ -0,0 +1,350 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// defaultTenantSeparator joins the namespace of a tenant to its keys if
+// NamespaceSeparator is not set.
+const defaultTenantSeparator = ":"
+
+// Tenant is a client of a server shared by several teams, authenticated by
+// the token it sends in an AUTH command.
+type Tenant struct {
+	// Token is that of ServerOpts.Tenants. The tenants returned by an
+	// Authenticator may leave it empty.
+	Token string
+
+	// Namespace scopes the keys of the tenant: they are stored prefixed with
+	// the namespace and NamespaceSeparator, ":" if it is not set, so a tenant
+	// cannot read or write the keys of another one and is counted under its
+	// own namespace in the stats. A tenant without a namespace operates the
+	// cluster: its keys are not scoped and it may send the cluster commands
+	// JOIN, BATCH, FILL, STATS and BACKUP. Any tenant may send TOPOLOGY.
+	Namespace string
+
+	// RequestsPerSecond, if set, is the quota of commands the tenant may send
+	// across all its connections, with bursts of up to Burst commands, or
+	// one second worth of them if Burst is zero. Commands over the quota are
+	// answered with StatusQuotaExceeded and how long until the next one is
+	// allowed.
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// tenant is the identity a connection is authenticated as.
+type tenant struct {
+	Tenant
+
+	// prefix is prepended to the keys of the tenant, nil for operators.
+	prefix []byte
+
+	// tokens is the number of commands the tenant may still send, refilled
+	// at RequestsPerSecond since last.
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// operator is the identity of the connections that need no authentication
+// if no tenants are configured. upstream is that of the connection to our
+// leader, which forwards its mutations over it and is trusted as an operator.
+var (
+	operator = &tenant{}
+	upstream = &tenant{}
+)
+
+// allow takes a command from the quota of the tenant, reporting whether it
+// had any left.
+func (t *tenant) allow(now time.Time) bool {
+	if t.RequestsPerSecond <= 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	burst := float64(t.Burst)
+	if burst <= 0 {
+		burst = max(t.RequestsPerSecond, 1)
+	}
+	if t.last.IsZero() {
+		t.tokens = burst
+	} else {
+		t.tokens = min(burst, t.tokens+now.Sub(t.last).Seconds()*t.RequestsPerSecond)
+	}
+	t.last = now
+
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+// retryAfter returns how long until the tenant has quota for a command
+// again, rounded up to the millisecond.
+func (t *tenant) retryAfter() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.RequestsPerSecond <= 0 || t.tokens >= 1 {
+		return 0
+	}
+	ms := math.Ceil((1 - t.tokens) / t.RequestsPerSecond * 1000)
+	return time.Duration(ms) * time.Millisecond
+}
+
+// scope returns the key as stored for the tenant.
+func (t *tenant) scope(key []byte) []byte {
+	scoped := make([]byte, 0, len(t.prefix)+len(key))
+	scoped = append(scoped, t.prefix...)
+	return append(scoped, key...)
+}
+
+// tenantTable holds the tenants connections authenticate as.
+type tenantTable struct {
+	// auth verifies the AUTH tokens, nil if connections need none.
+	auth      Authenticator
+	separator string
+
+	// resolved holds the tenants authenticated so far, so the equal ones
+	// share their quota.
+	mu       sync.Mutex
+	resolved map[Tenant]*tenant
+
+	// unauthorized and throttled count the commands rejected because the
+	// connection was not allowed to send them or was over its quota.
+	unauthorized atomic.Uint64
+	throttled    atomic.Uint64
+
+	// rejectedJoins counts the JOINs without the ClusterSecret.
+	rejectedJoins atomic.Uint64
+}
+
+// newTenantTable makes the table of the tenants authenticated by auth, or
+// by their token if auth is nil.
+func newTenantTable(auth Authenticator, tenants []Tenant, separator string) tenantTable {
+	if auth == nil && len(tenants) != 0 {
+		auth = NewTokenStore(tenants)
+	}
+	if len(separator) == 0 {
+		separator = defaultTenantSeparator
+	}
+	return tenantTable{auth: auth, separator: separator}
+}
+
+// resolve returns the identity of the tenant, shared by the connections
+// authenticated as an equal one.
+func (tt *tenantTable) resolve(t Tenant) *tenant {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	if nt, ok := tt.resolved[t]; ok {
+		return nt
+	}
+	if tt.resolved == nil {
+		tt.resolved = make(map[Tenant]*tenant)
+	}
+	nt := &tenant{Tenant: t}
+	if len(t.Namespace) != 0 {
+		nt.prefix = []byte(t.Namespace + tt.separator)
+	}
+	tt.resolved[t] = nt
+	return nt
+}
+
+// anonymous returns the identity of a connection that has not sent an AUTH
+// command: operator if no authentication is configured, nil otherwise.
+func (s *Server) anonymous() *tenant {
+	if s.tenants.auth == nil {
+		return operator
+	}
+	return nil
+}
+
+// joinAllowed reports whether the follower sending the JOIN may receive the
+// replication stream, which requires the ClusterSecret if there is one. The
+// secret is compared in constant time.
+func (s *Server) joinAllowed(cmd *proto.CommandJoin) bool {
+	if s.hasClusterSecret(cmd.Secret) {
+		return true
+	}
+	s.tenants.rejectedJoins.Add(1)
+	return false
+}
+
+// hasClusterSecret reports whether secret is the ClusterSecret, if there is
+// one, compared in constant time.
+func (s *Server) hasClusterSecret(secret string) bool {
+	if len(s.ClusterSecret) == 0 {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(s.ClusterSecret), []byte(secret)) == 1
+}
+
+// handleAuthCommand answers an AUTH command and returns the identity of the
+// connection from now on. A rejected token leaves it unchanged.
+func (s *Server) handleAuthCommand(conn net.Conn, cmd *proto.CommandAuth, current *tenant) *tenant {
+	resp := proto.ResponseAuth{Status: proto.StatusOK}
+	t := current
+	if s.tenants.auth != nil {
+		tn, err := s.tenants.auth.Authenticate(context.Background(), cmd.Token)
+		switch {
+		case errors.Is(err, ErrInvalidToken):
+			log.Printf("rejected auth token from [%s]: %s\n", conn.RemoteAddr(), err)
+			resp.Status = proto.StatusUnauthorized
+		case err != nil:
+			log.Printf("auth error for [%s]: %s\n", conn.RemoteAddr(), err)
+			resp.Status = proto.StatusError
+		default:
+			t = s.tenants.resolve(tn)
+		}
+	}
+	_ = proto.WriteMessage(conn, &resp)
+	return t
+}
+
+// authorize checks that the tenant may send the command and has quota left,
+// and scopes its key to the tenant namespace. A nil tenant is a connection
+// that has not authenticated.
+func (s *Server) authorize(t *tenant, cmd any) proto.Status {
+	if t == nil {
+		s.tenants.unauthorized.Add(1)
+		return proto.StatusUnauthorized
+	}
+
+	switch v := cmd.(type) {
+	case *proto.CommandPromote:
+		// The node joins the leader a PROMOTE names, sending it the
+		// ClusterSecret, so only the nodes of the cluster may send one.
+		if !s.hasClusterSecret(v.Secret) {
+			s.tenants.unauthorized.Add(1)
+			return proto.StatusUnauthorized
+		}
+	case *proto.CommandSync:
+		// A SYNC replaces the whole cache, so it is only taken from the
+		// leader this node joined.
+		if t != upstream {
+			s.tenants.unauthorized.Add(1)
+			return proto.StatusUnauthorized
+		}
+	}
+
+	if t.prefix != nil {
+		switch v := cmd.(type) {
+		case *proto.CommandSet:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandGet:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandDel:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandDelIf:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandTouch:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandAppend:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandGetRange:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandMGet:
+			for i, key := range v.Keys {
+				v.Keys[i] = t.scope(key)
+			}
+		case *proto.CommandSetIf:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandGetLease:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandSetLease:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandRename:
+			v.Key, v.NewKey = t.scope(v.Key), t.scope(v.NewKey)
+		case *proto.CommandCopy:
+			v.Key, v.Dst = t.scope(v.Key), t.scope(v.Dst)
+		case *proto.CommandXAdd:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandXRange:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandXRead:
+			v.Key = t.scope(v.Key)
+		case *proto.CommandPublish:
+			// Channels are scoped like keys.
+			v.Channel = t.scope(v.Channel)
+		case *proto.CommandSubscribe:
+			for i, channel := range v.Channels {
+				v.Channels[i] = t.scope(channel)
+			}
+		case *proto.CommandMulti:
+			for _, c := range v.Commands {
+				switch c := c.(type) {
+				case *proto.CommandSet:
+					c.Key = t.scope(c.Key)
+				case *proto.CommandDel:
+					c.Key = t.scope(c.Key)
+				}
+			}
+		case *proto.CommandTopology, *proto.CommandPing, *proto.CommandInfo:
+			// Every client needs them to route its commands, keep its
+			// connections alive and gate its features.
+		default:
+			s.tenants.unauthorized.Add(1)
+			return proto.StatusUnauthorized
+		}
+	}
+
+	if !t.allow(time.Now()) {
+		s.tenants.throttled.Add(1)
+		return proto.StatusQuotaExceeded
+	}
+	return proto.StatusOK
+}
+
+// reject answers a command of the tenant with the status in the response it
+// expects, or with a Redirect if the status carries one.
+func (s *Server) reject(conn net.Conn, t *tenant, cmd any, status proto.Status) error {
+	if status.HasRedirect() {
+		return proto.WriteMessage(conn, &proto.Redirect{Status: status, RetryAfter: t.retryAfter()})
+	}
+
+	switch cmd.(type) {
+	case *proto.CommandGet, *proto.CommandGetRange, *proto.CommandFill:
+		return proto.WriteMessage(conn, &proto.ResponseGet{Status: status})
+	case *proto.CommandGetLease:
+		return proto.WriteMessage(conn, &proto.ResponseGetLease{Status: status})
+	case *proto.CommandStats:
+		_, err := conn.Write((&proto.ResponseStats{Status: status}).Bytes())
+		return err
+	case *proto.CommandBackup:
+		_, err := conn.Write((&proto.ResponseBackup{Status: status}).Bytes())
+		return err
+	case *proto.CommandTopology:
+		return proto.WriteMessage(conn, &proto.ResponseTopology{Status: status})
+	case *proto.CommandFlush, *proto.CommandUndelete, *proto.CommandPurge:
+		return proto.WriteMessage(conn, &proto.ResponseFlush{Status: status})
+	case *proto.CommandXAdd:
+		return proto.WriteMessage(conn, &proto.ResponseXAdd{Status: status})
+	case *proto.CommandXRange, *proto.CommandXRead:
+		return proto.WriteMessage(conn, &proto.ResponseXRange{Status: status})
+	case *proto.CommandPublish:
+		return proto.WriteMessage(conn, &proto.ResponsePublish{Status: status})
+	case *proto.CommandScan:
+		return proto.WriteMessage(conn, &proto.ResponseScan{Status: status})
+	case *proto.CommandMGet:
+		return proto.WriteMessage(conn, &proto.ResponseMGet{Status: status})
+	case *proto.CommandInfo:
+		return proto.WriteMessage(conn, &proto.ResponseInfo{Status: status})
+	default:
+		// The other responses are a single status byte.
+		return proto.WriteMessage(conn, &proto.ResponseSet{Status: status})
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenants(t *testing.T) {
+	s, op, err := StartEmbedded(ServerOpts{
+		IsLeader: true,
+		Tenants: []Tenant{
+			{Token: "op"},
+			{Token: "a", Namespace: "team-a"},
+			{Token: "b", Namespace: "team-b", RequestsPerSecond: 0.1, Burst: 2},
+		},
+		AuthToken: "op",
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer op.Close()
+
+	addr := s.Addr().String()
+	_, err = client.New(addr, client.Options{AuthToken: "nope"})
+	assert.Equal(t, client.ErrUnauthorized, err)
+
+	anon, err := client.New(addr, client.Options{})
+	assert.Nil(t, err)
+	defer anon.Close()
+	a, err := client.New(addr, client.Options{AuthToken: "a"})
+	assert.Nil(t, err)
+	defer a.Close()
+	b, err := client.New(addr, client.Options{AuthToken: "b"})
+	assert.Nil(t, err)
+	defer b.Close()
+
+	ctx := context.Background()
+	assert.NotNil(t, anon.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	_, err = anon.Get(ctx, []byte("foo"))
+	assert.NotNil(t, err)
+
+	// The keys of a tenant are scoped to its namespace.
+	assert.Nil(t, a.Set(ctx, []byte("foo"), []byte("a"), 0))
+	value, err := a.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+	_, err = b.Get(ctx, []byte("foo"))
+	assert.NotNil(t, err)
+	value, err = op.Get(ctx, []byte("team-a:foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+
+	// Cluster commands are reserved to operators.
+	_, err = a.Stats(ctx)
+	assert.NotNil(t, err)
+	_, err = op.Stats(ctx)
+	assert.Nil(t, err)
+
+	// b used one command of its burst of two.
+	assert.Nil(t, b.Set(ctx, []byte("foo"), []byte("b"), 0))
+	err = b.Set(ctx, []byte("foo"), []byte("b"), 0)
+	var redirect *proto.Redirect
+	assert.ErrorAs(t, err, &redirect)
+	assert.Equal(t, proto.StatusQuotaExceeded, redirect.Status)
+	assert.InDelta(t, 10*time.Second, redirect.RetryAfter, float64(100*time.Millisecond))
+
+	assert.Equal(t, uint64(3), s.tenants.unauthorized.Load())
+	assert.Equal(t, uint64(1), s.tenants.throttled.Load())
+}
+
+func TestTenantQuota(t *testing.T) {
+	tn := &tenant{Tenant: Tenant{RequestsPerSecond: 10}}
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, tn.allow(now))
+	}
+	assert.False(t, tn.allow(now))
+	assert.True(t, tn.allow(now.Add(100*time.Millisecond)))
+	assert.False(t, tn.allow(now.Add(100*time.Millisecond)))
+
+	// The quota refills up to the burst only.
+	now = now.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		assert.True(t, tn.allow(now))
+	}
+	assert.False(t, tn.allow(now))
+	assert.Equal(t, 100*time.Millisecond, tn.retryAfter())
+}
+
+func TestClusterSecret(t *testing.T) {
+	leader, c, err := StartEmbedded(ServerOpts{IsLeader: true, ClusterSecret: "s3cret"}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	// A follower without the secret is not sent the mutations.
+	intruder, ic, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, nil)
+	assert.Nil(t, err)
+	defer intruder.Close()
+	defer ic.Close()
+	assert.Eventually(t, func() bool {
+		return stat(leader, "server_joins_rejected_total") > 0
+	}, time.Second, 10*time.Millisecond)
+
+	follower, fc, err := StartEmbedded(ServerOpts{
+		LeaderAddr:    leader.Addr().String(),
+		ClusterSecret: "s3cret",
+	}, nil)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Eventually(t, func() bool {
+		value, err := fc.Get(ctx, []byte("foo"))
+		return err == nil && string(value) == "bar"
+	}, time.Second, 10*time.Millisecond)
+	_, err = ic.Get(ctx, []byte("foo"))
+	assert.NotNil(t, err)
+}
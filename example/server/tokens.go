@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrTokenExists is returned by TokenStore.Add for a token it already holds.
+var ErrTokenExists = errors.New("token already exists")
+
+// TokenStore authenticates the tenants by their Token like StaticTokens,
+// but its tokens can be added and revoked while the server runs, from the
+// admin API or on a config reload. A tenant may have several tokens, so
+// they are rotated without a restart: add the new token, move the clients
+// over to it, then revoke the old one. The tenants it returns have no
+// Token, so the tokens of a tenant share its quota.
+//
+// It is the Authenticator of ServerOpts.Tenants, and is safe for concurrent
+// use. A revoked token no longer authenticates new connections; those
+// already authenticated with it keep their tenant until they close.
+type TokenStore struct {
+	mu      sync.RWMutex
+	tenants []Tenant
+}
+
+// TokenInfo describes a token of a TokenStore without revealing it.
+type TokenInfo struct {
+	// Fingerprint identifies the token, as returned by TokenFingerprint.
+	Fingerprint       string  `json:"fingerprint"`
+	Namespace         string  `json:"namespace"`
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	Burst             int     `json:"burst,omitempty"`
+}
+
+// TokenFingerprint returns the first 8 bytes of the SHA-256 of the token in
+// hex, which the admin API lists and revokes the tokens by.
+func TokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewTokenStore creates a TokenStore holding the tokens of the tenants.
+func NewTokenStore(tenants []Tenant) *TokenStore {
+	ts := &TokenStore{}
+	ts.Replace(tenants)
+	return ts
+}
+
+func (ts *TokenStore) Authenticate(ctx context.Context, token []byte) (Tenant, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	found, err := StaticTokens(ts.tenants).Authenticate(ctx, token)
+	if err != nil {
+		return Tenant{}, err
+	}
+	found.Token = ""
+	return found, nil
+}
+
+// Add adds the token of the tenant.
+func (ts *TokenStore) Add(t Tenant) error {
+	if len(t.Token) == 0 {
+		return errors.New("missing token")
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, held := range ts.tenants {
+		if subtle.ConstantTimeCompare([]byte(held.Token), []byte(t.Token)) == 1 {
+			return ErrTokenExists
+		}
+	}
+	ts.tenants = append(ts.tenants, t)
+	return nil
+}
+
+// Revoke removes the tokens with the fingerprint, reporting whether there
+// were any.
+func (ts *TokenStore) Revoke(fingerprint string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	revoked := false
+	tenants := ts.tenants[:0]
+	for _, t := range ts.tenants {
+		if TokenFingerprint(t.Token) == fingerprint {
+			revoked = true
+			continue
+		}
+		tenants = append(tenants, t)
+	}
+	// The revoked tokens are not kept in the spare capacity.
+	clear(ts.tenants[len(tenants):])
+	ts.tenants = tenants
+	return revoked
+}
+
+// Replace replaces every token with those of the tenants, e.g. those of a
+// reloaded config.
+func (ts *TokenStore) Replace(tenants []Tenant) {
+	held := append([]Tenant(nil), tenants...)
+
+	ts.mu.Lock()
+	ts.tenants = held
+	ts.mu.Unlock()
+}
+
+// Tokens describes the tokens held, in the order they were added.
+func (ts *TokenStore) Tokens() []TokenInfo {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	infos := make([]TokenInfo, len(ts.tenants))
+	for i, t := range ts.tenants {
+		infos[i] = TokenInfo{
+			Fingerprint:       TokenFingerprint(t.Token),
+			Namespace:         t.Namespace,
+			RequestsPerSecond: t.RequestsPerSecond,
+			Burst:             t.Burst,
+		}
+	}
+	return infos
+}
+
+// Tokens returns the TokenStore authenticating the connections, nil if they
+// are authenticated by another Authenticator or not at all.
+func (s *Server) Tokens() *TokenStore {
+	ts, _ := s.tenants.auth.(*TokenStore)
+	return ts
+}
+
+// tokenRequest is the body of a POST to /api/v1/auth/tokens.
+type tokenRequest struct {
+	Token             string  `json:"token"`
+	Namespace         string  `json:"namespace"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+// handleTokensAPI lists the tokens on a GET to /api/v1/auth/tokens, adds the
+// token of the tenant in the body on a POST, and revokes the token with the
+// fingerprint parameter on a DELETE.
+func (s *Server) handleTokensAPI(w http.ResponseWriter, r *http.Request) {
+	ts := s.Tokens()
+	if ts == nil {
+		http.Error(w, "the tokens are not managed by the server", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := json.Marshal(ts.Tokens())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(b)
+	case http.MethodPost:
+		var req tokenRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid body: %s", err), http.StatusBadRequest)
+			return
+		}
+		if req.RequestsPerSecond < 0 || req.Burst < 0 {
+			http.Error(w, "requests_per_second and burst cannot be negative", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(req.Namespace, s.tenants.separator) {
+			http.Error(w, fmt.Sprintf("namespace [%s] contains the namespace separator", req.Namespace), http.StatusBadRequest)
+			return
+		}
+		err := ts.Add(Tenant{
+			Token:             req.Token,
+			Namespace:         req.Namespace,
+			RequestsPerSecond: req.RequestsPerSecond,
+			Burst:             req.Burst,
+		})
+		switch {
+		case errors.Is(err, ErrTokenExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if !ts.Revoke(r.URL.Query().Get("fingerprint")) {
+			http.Error(w, "no such token", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenRotation(t *testing.T) {
+	s, op, err := StartEmbedded(ServerOpts{
+		IsLeader: true,
+		Tenants: []Tenant{
+			{Token: "op"},
+			{Token: "old", Namespace: "team-a"},
+		},
+		AuthToken: "op",
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer op.Close()
+
+	addr := s.Addr().String()
+	old, err := client.New(addr, client.Options{AuthToken: "old"})
+	assert.Nil(t, err)
+	defer old.Close()
+
+	admin := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	// The new token is added along with the old one.
+	rec := admin(http.MethodPost, "/api/v1/auth/tokens", `{"token": "new", "namespace": "team-a"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = admin(http.MethodPost, "/api/v1/auth/tokens", `{"token": "new", "namespace": "team-b"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = admin(http.MethodPost, "/api/v1/auth/tokens", `{"token": "x", "namespace": "team:b"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = admin(http.MethodGet, "/api/v1/auth/tokens", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), `"old"`)
+	var tokens []TokenInfo
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+	assert.Equal(t, []TokenInfo{
+		{Fingerprint: TokenFingerprint("op")},
+		{Fingerprint: TokenFingerprint("old"), Namespace: "team-a"},
+		{Fingerprint: TokenFingerprint("new"), Namespace: "team-a"},
+	}, tokens)
+
+	// Both tokens are the same tenant.
+	ctx := context.Background()
+	rotated, err := client.New(addr, client.Options{AuthToken: "new"})
+	assert.Nil(t, err)
+	defer rotated.Close()
+	assert.Nil(t, old.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	value, err := rotated.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	// Once revoked, the old token no longer authenticates.
+	rec = admin(http.MethodDelete, "/api/v1/auth/tokens?fingerprint="+TokenFingerprint("old"), "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = admin(http.MethodDelete, "/api/v1/auth/tokens?fingerprint="+TokenFingerprint("old"), "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	_, err = client.New(addr, client.Options{AuthToken: "old"})
+	assert.Equal(t, client.ErrUnauthorized, err)
+
+	// A reload replaces the tokens.
+	s.Tokens().Replace([]Tenant{{Token: "op"}, {Token: "newer", Namespace: "team-a"}})
+	_, err = client.New(addr, client.Options{AuthToken: "new"})
+	assert.Equal(t, client.ErrUnauthorized, err)
+	newer, err := client.New(addr, client.Options{AuthToken: "newer"})
+	assert.Nil(t, err)
+	defer newer.Close()
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// tombstoneTable keeps the values of the keys deleted within the last
+// DeleteRetention, so an accidental DEL or FLUSH can be undone.
+type tombstoneTable struct {
+	mu      sync.Mutex
+	entries map[string]tombstone
+	bytes   int
+
+	// queue holds the keys in the order they were deleted, which is the
+	// order their tombstones expire in, as they are all kept as long. A key
+	// deleted again is queued again, its older place skipped by purge.
+	queue []queuedTombstone
+	timer *time.Timer
+
+	// restored counts the keys restored by UNDELETE, and purged the
+	// tombstones dropped at the end of the retention.
+	restored atomic.Uint64
+	purged   atomic.Uint64
+}
+
+// tombstone is the value of a deleted key, and when the key would have
+// expired, the zero Time if it would not have.
+type tombstone struct {
+	value     []byte
+	expiresAt time.Time
+	deletedAt time.Time
+}
+
+type queuedTombstone struct {
+	key       string
+	deletedAt time.Time
+}
+
+// add keeps the value of the deleted key for the retention.
+func (t *tombstoneTable) add(key []byte, stone tombstone, retention time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.entries == nil {
+		t.entries = make(map[string]tombstone)
+	}
+	if old, ok := t.entries[string(key)]; ok {
+		t.bytes -= len(key) + len(old.value)
+	}
+	t.entries[string(key)] = stone
+	t.bytes += len(key) + len(stone.value)
+	t.queue = append(t.queue, queuedTombstone{key: string(key), deletedAt: stone.deletedAt})
+
+	if t.timer == nil {
+		t.timer = time.AfterFunc(retention, func() { t.purge(retention) })
+	}
+}
+
+// purge drops the tombstones older than the retention, and arms the timer
+// for the next one to expire.
+func (t *tombstoneTable) purge(retention time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for len(t.queue) != 0 && now.Sub(t.queue[0].deletedAt) >= retention {
+		q := t.queue[0]
+		t.queue = t.queue[1:]
+		if stone, ok := t.entries[q.key]; ok && stone.deletedAt.Equal(q.deletedAt) {
+			delete(t.entries, q.key)
+			t.bytes -= len(q.key) + len(stone.value)
+			t.purged.Add(1)
+		}
+	}
+
+	if len(t.queue) == 0 {
+		t.queue, t.timer = nil, nil
+		return
+	}
+	t.timer.Reset(retention - now.Sub(t.queue[0].deletedAt))
+}
+
+// take removes and returns the tombstones of the keys matching the pattern.
+func (t *tombstoneTable) take(pattern []byte) map[string]tombstone {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stones := make(map[string]tombstone)
+	for key, stone := range t.entries {
+		if ggcache.MatchKey(pattern, []byte(key)) {
+			stones[key] = stone
+			delete(t.entries, key)
+			t.bytes -= len(key) + len(stone.value)
+		}
+	}
+	return stones
+}
+
+// put puts back a tombstone returned by take. Its place in the queue was
+// kept, so it is purged when it would have been.
+func (t *tombstoneTable) put(key string, stone tombstone) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.entries[key]; ok {
+		// The key was deleted again in the meantime.
+		return
+	}
+	t.entries[key] = stone
+	t.bytes += len(key) + len(stone.value)
+}
+
+// stats returns the number of tombstones and the size of their keys and
+// values.
+func (t *tombstoneTable) stats() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.entries), t.bytes
+}
+
+func (t *tombstoneTable) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// tombstone keeps the current value of a key about to be deleted if
+// DeleteRetention is set. Without a ggcache.ExpiryGetter cache, the value
+// is kept as if it did not expire.
+func (s *Server) tombstone(key []byte) {
+	if stone, ok := s.stone(key); ok {
+		s.tombstones.add(key, stone, s.DeleteRetention)
+	}
+}
+
+// stone returns the tombstone of the current value of a key, if
+// DeleteRetention is set and the key is present.
+func (s *Server) stone(key []byte) (tombstone, bool) {
+	if s.DeleteRetention <= 0 {
+		return tombstone{}, false
+	}
+	value, err := s.cache.Get(key)
+	if err != nil {
+		return tombstone{}, false
+	}
+	stone := tombstone{value: bytes.Clone(value), deletedAt: time.Now()}
+	if eg, ok := s.cache.(ggcache.ExpiryGetter); ok {
+		if stone.expiresAt, err = eg.Expiry(key); err != nil {
+			return tombstone{}, false
+		}
+	}
+	return stone, true
+}
+
+func (s *Server) handleUndeleteCommand(conn net.Conn, cmd *proto.CommandUndelete) error {
+	resp := proto.ResponseFlush{Status: proto.StatusOK}
+	n, err := s.undelete(cmd.Pattern)
+	resp.Keys = uint64(n)
+	switch {
+	case err == nil:
+	case errors.Is(err, ggcache.ErrPersistence):
+		resp.Status = proto.StatusPersistenceError
+	default:
+		log.Println("undelete error:", err)
+		resp.Status = proto.StatusError
+	}
+	return proto.WriteMessage(conn, &resp)
+}
+
+// undelete sets the keys matching the pattern back to the values of their
+// tombstones, with the TTL they had left, and returns how many it restored.
+// The keys set since they were deleted, and those that would have expired,
+// are left alone. The restores are replicated like SETs; the tombstones of
+// those that fail are kept, and the first error is returned.
+func (s *Server) undelete(pattern []byte) (int, error) {
+	var (
+		restored int
+		firstErr error
+	)
+	for key, stone := range s.tombstones.take(pattern) {
+		var ttl time.Duration
+		if !stone.expiresAt.IsZero() {
+			if ttl = time.Until(stone.expiresAt); ttl <= 0 {
+				continue
+			}
+		}
+		if s.cache.Has([]byte(key)) {
+			continue
+		}
+		if err := s.set([]byte(key), stone.value, ttl); err != nil {
+			s.tombstones.put(key, stone)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		restored++
+		s.tombstones.restored.Add(1)
+	}
+	return restored, firstErr
+}
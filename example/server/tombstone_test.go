@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUndelete(t *testing.T) {
+	leader, c, err := StartEmbedded(ServerOpts{IsLeader: true, DeleteRetention: time.Minute}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer c.Close()
+
+	cache := ggcache.New()
+	follower, fc, err := StartEmbedded(ServerOpts{
+		LeaderAddr:      leader.Addr().String(),
+		DeleteRetention: time.Minute,
+	}, cache)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Set(ctx, []byte(fmt.Sprintf("user:%d", i)), []byte("v"), 0))
+	}
+	assert.Nil(t, c.Set(ctx, []byte("session"), []byte("s"), time.Hour))
+
+	// A DEL and a FLUSH are both undone, the TTL kept.
+	assert.Nil(t, c.Delete(ctx, []byte("session")))
+	n, err := c.Flush(ctx, "user:*", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	assert.Eventually(t, func() bool {
+		return !cache.Has([]byte("session")) && !cache.Has([]byte("user:3"))
+	}, time.Second, 10*time.Millisecond)
+
+	// The follower keeps the tombstones of the replicated deletes.
+	assert.Equal(t, int64(11), stat(follower, "server_tombstones"))
+
+	// A key set since it was deleted is kept.
+	assert.Nil(t, c.Set(ctx, []byte("user:3"), []byte("new"), 0))
+
+	n, err = c.Undelete(ctx, "user:*")
+	assert.Nil(t, err)
+	assert.Equal(t, 9, n)
+	value, err := c.Get(ctx, []byte("user:5"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+	value, err = c.Get(ctx, []byte("user:3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), value)
+
+	n, err = c.Undelete(ctx, "session")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	expiry, err := leader.cache.(ggcache.ExpiryGetter).Expiry([]byte("session"))
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiry, time.Minute)
+
+	// The restores are replicated.
+	assert.Eventually(t, func() bool {
+		return cache.Has([]byte("session")) && cache.Has([]byte("user:5"))
+	}, time.Second, 10*time.Millisecond)
+
+	n, err = c.Undelete(ctx, "*")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestUndeleteRetention(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, DeleteRetention: 50 * time.Millisecond}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Delete(ctx, []byte("foo")))
+
+	// The tombstone is purged at the end of the retention.
+	assert.Eventually(t, func() bool {
+		return stat(s, "server_tombstones") == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), stat(s, "server_tombstones_purged_total"))
+
+	n, err := c.Undelete(ctx, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+// stat returns the value of the named stat of the server.
+func stat(s *Server, name string) int64 {
+	for _, stat := range s.Stats() {
+		if stat.Name == name {
+			return stat.Value
+		}
+	}
+	return 0
+}
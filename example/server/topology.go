@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// advertisedAddr returns the address the other nodes and the clients reach
+// this node at: AdvertiseAddr, or the address it listens on.
+func (s *Server) advertisedAddr() string {
+	if len(s.AdvertiseAddr) != 0 {
+		return s.AdvertiseAddr
+	}
+	if addr := s.Addr(); addr != nil {
+		return addr.String()
+	}
+	return s.ListenAddr
+}
+
+// readAddr returns the read endpoint this node advertises as a follower:
+// ReadAddr, or the address it is reached at.
+func (s *Server) readAddr() string {
+	if len(s.ReadAddr) != 0 {
+		return s.ReadAddr
+	}
+	return s.advertisedAddr()
+}
+
+// Topology returns the address of the leader and the read endpoints of the
+// replicas, sorted. A follower lists itself as the only replica, and reports
+// itself as the leader while it has none, as it then takes writes.
+func (s *Server) Topology() (string, []string) {
+	topo := s.topology(time.Now())
+	return topo.Leader, topo.Replicas
+}
+
+// topology is Topology along with the zone of each replica, and its weight
+// as of now.
+func (s *Server) topology(now time.Time) *proto.ResponseTopology {
+	type replica struct {
+		addr, zone string
+		serving    time.Time
+	}
+
+	s.mu.Lock()
+	leader := s.leader
+	var replicas []replica
+	if len(leader) == 0 {
+		for member, addr := range s.members {
+			// A member is listed once it restored the snapshot.
+			if _, ok := s.syncing[member]; len(addr) != 0 && !ok {
+				replicas = append(replicas, replica{addr, s.zones[member], s.serving[member]})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if len(leader) != 0 {
+		weight, ramp := s.warmth(s.started, now)
+		return &proto.ResponseTopology{
+			Leader:   leader,
+			Replicas: []string{s.readAddr()},
+			Zones:    []string{s.Zone},
+			Weights:  []uint8{weight},
+			Ramps:    []time.Duration{ramp},
+		}
+	}
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].addr < replicas[j].addr })
+	topo := &proto.ResponseTopology{
+		Leader:   s.advertisedAddr(),
+		Replicas: make([]string, len(replicas)),
+		Zones:    make([]string, len(replicas)),
+		Weights:  make([]uint8, len(replicas)),
+		Ramps:    make([]time.Duration, len(replicas)),
+	}
+	for i, r := range replicas {
+		topo.Replicas[i], topo.Zones[i] = r.addr, r.zone
+		topo.Weights[i], topo.Ramps[i] = s.warmth(r.serving, now)
+	}
+	return topo
+}
+
+// warmth returns the weight, in percent, of a replica that started serving
+// at since, and the time left until SlowStart ramps it up to 100.
+func (s *Server) warmth(since, now time.Time) (uint8, time.Duration) {
+	elapsed := max(now.Sub(since), 0)
+	if s.SlowStart <= 0 || elapsed >= s.SlowStart {
+		return 100, 0
+	}
+	return uint8(100 * elapsed / s.SlowStart), s.SlowStart - elapsed
+}
+
+func (s *Server) handleTopologyCommand(conn net.Conn, _ *proto.CommandTopology) error {
+	resp := s.topology(time.Now())
+	resp.Status = proto.StatusOK
+	return proto.WriteMessage(conn, resp)
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// freeAddr returns a loopback address with a port nothing listens on.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestTopology(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	addr := freeAddr(t)
+	replica, rc, err := StartEmbedded(ServerOpts{
+		ListenAddr: addr,
+		LeaderAddr: leader.Addr().String(),
+		ReadAddr:   addr,
+	}, nil)
+	assert.Nil(t, err)
+	defer replica.Close()
+	defer rc.Close()
+
+	// A follower without a ReadAddr is not advertised.
+	follower, fc, err := StartEmbedded(ServerOpts{LeaderAddr: leader.Addr().String()}, nil)
+	assert.Nil(t, err)
+	defer follower.Close()
+	defer fc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	leaderAddr, replicas, err := lc.Topology(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, leader.Addr().String(), leaderAddr)
+	assert.Equal(t, []string{addr}, replicas)
+
+	leaderAddr, replicas, err = rc.Topology(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, leader.Addr().String(), leaderAddr)
+	assert.Equal(t, []string{addr}, replicas)
+
+	// The replica redirects writes without RedirectWrites.
+	var redirect *proto.Redirect
+	assert.ErrorAs(t, rc.Set(ctx, []byte("foo"), []byte("bar"), 0), &redirect)
+	assert.Equal(t, proto.StatusMoved, redirect.Status)
+	assert.ErrorIs(t, rc.Set(ctx, []byte("foo"), []byte("bar"), 0), client.ErrReadOnly)
+}
+
+func TestClusterClient(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	addr := freeAddr(t)
+	replica, rc, err := StartEmbedded(ServerOpts{
+		ListenAddr: addr,
+		LeaderAddr: leader.Addr().String(),
+		ReadAddr:   addr,
+	}, nil)
+	assert.Nil(t, err)
+	defer replica.Close()
+	defer rc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Seeded with the replica, the client finds the leader.
+	c, err := client.NewCluster(addr, client.Options{})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("foo"), []byte("bar"), 0))
+	value, err := lc.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	// Reads go to the replica: a key only it has is found.
+	assert.Nil(t, replica.cache.Set([]byte("local"), []byte("1"), 0))
+	value, err = c.Get(ctx, []byte("local"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+}
+
+func TestClusterClientZone(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true, Zone: "a"}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	replicas := make(map[string]*Server)
+	for _, zone := range []string{"a", "b"} {
+		addr := freeAddr(t)
+		replica, rc, err := StartEmbedded(ServerOpts{
+			ListenAddr: addr,
+			LeaderAddr: leader.Addr().String(),
+			ReadAddr:   addr,
+			Zone:       zone,
+		}, nil)
+		assert.Nil(t, err)
+		defer replica.Close()
+		defer rc.Close()
+		replicas[zone] = replica
+	}
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	topo := leader.topology(time.Now())
+	assert.Len(t, topo.Replicas, 2)
+	for i, addr := range topo.Replicas {
+		assert.Equal(t, replicas[topo.Zones[i]].Addr().String(), addr)
+	}
+
+	// Every read goes to the replica of the zone of the client.
+	c, err := client.NewCluster(leader.Addr().String(), client.Options{Zone: "b"})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, replicas["b"].cache.Set([]byte("local"), []byte("1"), 0))
+	for i := 0; i < 4; i++ {
+		value, err := c.Get(ctx, []byte("local"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("1"), value)
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	leader, lc, err := StartEmbedded(ServerOpts{IsLeader: true, SlowStart: time.Hour}, nil)
+	assert.Nil(t, err)
+	defer leader.Close()
+	defer lc.Close()
+
+	addr := freeAddr(t)
+	replica, rc, err := StartEmbedded(ServerOpts{
+		ListenAddr: addr,
+		LeaderAddr: leader.Addr().String(),
+		ReadAddr:   addr,
+	}, nil)
+	assert.Nil(t, err)
+	defer replica.Close()
+	defer rc.Close()
+
+	assert.Eventually(t, func() bool {
+		return leader.MemberCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The replica that just started serving is advertised cold.
+	now := time.Now()
+	topo := leader.topology(now)
+	assert.Equal(t, []uint8{0}, topo.Weights)
+	assert.InDelta(t, time.Hour, topo.Ramps[0], float64(time.Second))
+
+	weight, ramp := leader.warmth(now.Add(-15*time.Minute), now)
+	assert.Equal(t, uint8(25), weight)
+	assert.Equal(t, 45*time.Minute, ramp)
+	weight, ramp = leader.warmth(now.Add(-2*time.Hour), now)
+	assert.Equal(t, uint8(100), weight)
+	assert.Zero(t, ramp)
+
+	// The reads go to the leader until the replica warmed up.
+	c, err := client.NewCluster(leader.Addr().String(), client.Options{})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, leader.cache.Set([]byte("local"), []byte("leader"), 0))
+	assert.Nil(t, replica.cache.Set([]byte("local"), []byte("replica"), 0))
+	for i := 0; i < 8; i++ {
+		value, err := c.Get(ctx, []byte("local"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("leader"), value)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/anthdm/ggcache/example/proto"
+)
+
+// DefaultUDPMaxValue is the largest value a UDP GET returns if UDPMaxValue
+// is not set. It keeps responses within a single unfragmented packet on
+// common networks.
+const DefaultUDPMaxValue = 1024
+
+// udpMaxDatagram is the largest payload of a UDP datagram over IPv4.
+const udpMaxDatagram = 65507
+
+// serveUDP starts the UDP listener on UDPAddr. It is closed together with
+// the server.
+func (s *Server) serveUDP() error {
+	pc, err := net.ListenPacket("udp", s.UDPAddr)
+	if err != nil {
+		return fmt.Errorf("udp listen error: %s", err)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return pc.Close()
+	}
+	s.udp = pc
+	s.mu.Unlock()
+
+	log.Printf("udp listener starting on [%s]\n", pc.LocalAddr())
+
+	go s.udpLoop(pc)
+	return nil
+}
+
+// udpLoop serves the datagrams until the listener is closed. SETs are
+// applied without a response, GETs are answered with a ResponseGet unless
+// the source is not allowed.
+func (s *Server) udpLoop(pc net.PacketConn) {
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println("udp read error:", err)
+			continue
+		}
+		if !s.isAllowed(addr) {
+			continue
+		}
+
+		start := time.Now()
+		cmd, err := proto.ParseDatagram(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch v := cmd.(type) {
+		case *proto.CommandSet:
+			if err := s.set(v.Key, v.Value, time.Duration(v.TTL)*time.Millisecond); err != nil {
+				log.Println("udp set error:", err)
+			}
+			s.metrics.observe("udp_set", time.Since(start))
+		case *proto.CommandGet:
+			resp := s.udpGet(v.Key)
+			if _, err := pc.WriteTo(resp.Bytes(), addr); err != nil {
+				log.Println("udp write error:", err)
+			}
+			s.metrics.observe("udp_get", time.Since(start))
+		}
+	}
+}
+
+func (s *Server) udpGet(key []byte) *proto.ResponseGet {
+	value, err := s.cache.Get(key)
+	s.countNamespace(key, func(ns *NamespaceStats) {
+		if err != nil {
+			ns.Misses++
+		} else {
+			ns.Hits++
+		}
+	})
+	if err != nil {
+		return &proto.ResponseGet{Status: proto.StatusKeyNotFound}
+	}
+
+	max := s.UDPMaxValue
+	if max <= 0 {
+		max = DefaultUDPMaxValue
+	}
+	// The value follows a status byte and its length.
+	if max > udpMaxDatagram-5 {
+		max = udpMaxDatagram - 5
+	}
+	if len(value) > max {
+		return &proto.ResponseGet{Status: proto.StatusTooLarge}
+	}
+	return &proto.ResponseGet{Status: proto.StatusOK, Value: value}
+}
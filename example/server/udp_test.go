@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDP(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, UDPAddr: "127.0.0.1:0", UDPMaxValue: 8}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// The UDP listener is started by Serve, which runs in the background.
+	var addr net.Addr
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.udp != nil {
+			addr = s.udp.LocalAddr()
+		}
+		return addr != nil
+	}, time.Second, time.Millisecond)
+
+	udp, err := client.NewUDP(addr.String())
+	assert.Nil(t, err)
+	defer udp.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, udp.Set(ctx, []byte("cpu"), []byte("42"), time.Minute))
+	assert.Eventually(t, func() bool {
+		value, err := c.Get(ctx, []byte("cpu"))
+		return err == nil && bytes.Equal(value, []byte("42"))
+	}, time.Second, time.Millisecond)
+
+	value, err := udp.Get(ctx, []byte("cpu"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("42"), value)
+
+	_, err = udp.Get(ctx, []byte("missing"))
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+
+	// Values above the cutoff have to be read over TCP.
+	assert.Nil(t, c.Set(ctx, []byte("big"), []byte("0123456789"), 0))
+	_, err = udp.Get(ctx, []byte("big"))
+	assert.ErrorIs(t, err, client.ErrTooLarge)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// errInvalidValue is wrapped by the errors of writes whose value does not
+// pass the validators of its namespace.
+var errInvalidValue = errors.New("invalid value")
+
+// Validator checks a value before it is stored. A non-nil error rejects the
+// write with StatusInvalidValue.
+type Validator interface {
+	Validate(key, value []byte) error
+}
+
+// ValidatorFunc adapts a function to a Validator.
+type ValidatorFunc func(key, value []byte) error
+
+func (f ValidatorFunc) Validate(key, value []byte) error {
+	return f(key, value)
+}
+
+// JSONValidator accepts values that are a single well-formed JSON document
+// nested at most MaxDepth objects and arrays deep, any depth if zero.
+type JSONValidator struct {
+	MaxDepth int
+}
+
+func (v JSONValidator) Validate(_, value []byte) error {
+	if !json.Valid(value) {
+		return errors.New("not a JSON document")
+	}
+	if v.MaxDepth <= 0 {
+		return nil
+	}
+
+	// The document is valid, so brackets only need to be told apart from
+	// the ones in strings.
+	var (
+		depth    int
+		inString bool
+		escaped  bool
+	)
+	for _, b := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch b {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case b == '"':
+			inString = true
+		case b == '{' || b == '[':
+			if depth++; depth > v.MaxDepth {
+				return fmt.Errorf("JSON nested deeper than %d", v.MaxDepth)
+			}
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// ContentTypeValidator accepts values whose content type, as sniffed by
+// http.DetectContentType, is one of Types. A type may end in /* to accept
+// any subtype, e.g. image/*. Parameters such as the charset are ignored.
+type ContentTypeValidator struct {
+	Types []string
+}
+
+func (v ContentTypeValidator) Validate(_, value []byte) error {
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(value))
+	for _, t := range v.Types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("content type %s is not allowed", mediaType)
+}
+
+// validator returns the validator of the namespace of the key, or nil if it
+// has none.
+func (s *Server) validator(key []byte) Validator {
+	if len(s.Validators) == 0 {
+		return nil
+	}
+	return s.Validators[s.prefix(key)]
+}
+
+// prefix returns the namespace of the key split at NamespaceSeparator, ":"
+// if it is not set, or "_default" if the key has none.
+func (s *Server) prefix(key []byte) string {
+	separator := s.NamespaceSeparator
+	if len(separator) == 0 {
+		separator = defaultTenantSeparator
+	}
+	if i := bytes.Index(key, []byte(separator)); i > 0 {
+		return string(key[:i])
+	}
+	return defaultNamespace
+}
+
+// validate checks the value against the validator of the namespace of the
+// key.
+func (s *Server) validate(key, value []byte) error {
+	v := s.validator(key)
+	if v == nil {
+		return nil
+	}
+	if err := v.Validate(key, value); err != nil {
+		return fmt.Errorf("%w: %s", errInvalidValue, err)
+	}
+	return nil
+}
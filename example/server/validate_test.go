@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONValidator(t *testing.T) {
+	v := JSONValidator{MaxDepth: 2}
+	assert.Nil(t, v.Validate(nil, []byte(`{"a": [1, 2]}`)))
+	assert.Nil(t, v.Validate(nil, []byte(`{"a": "[[[{{{"}`)))
+	assert.Nil(t, v.Validate(nil, []byte(`{"a": "\"[["}`)))
+	assert.NotNil(t, v.Validate(nil, []byte(`{"a": [[1]]}`)))
+	assert.NotNil(t, v.Validate(nil, []byte(`{"a": 1`)))
+	assert.NotNil(t, v.Validate(nil, []byte(`{} {}`)))
+
+	assert.Nil(t, JSONValidator{}.Validate(nil, []byte(`[[[[[[1]]]]]]`)))
+}
+
+func TestContentTypeValidator(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	v := ContentTypeValidator{Types: []string{"image/*", "text/plain"}}
+	assert.Nil(t, v.Validate(nil, png))
+	assert.Nil(t, v.Validate(nil, []byte("hello")))
+	assert.NotNil(t, v.Validate(nil, []byte("<html><body></body></html>")))
+	assert.NotNil(t, v.Validate(nil, []byte{0x00, 0x01, 0x02}))
+
+	assert.NotNil(t, ContentTypeValidator{Types: []string{"image/jpeg"}}.Validate(nil, png))
+}
+
+func TestValidators(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader: true,
+		Validators: map[string]Validator{
+			"users":          JSONValidator{MaxDepth: 1},
+			defaultNamespace: ContentTypeValidator{Types: []string{"text/plain"}},
+		},
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("users:1"), []byte(`{"name": "alice"}`), 0))
+	assert.Equal(t, client.ErrInvalidValue, c.Set(ctx, []byte("users:1"), []byte(`{"name": {}}`), 0))
+	assert.Equal(t, client.ErrInvalidValue, c.SetIf(ctx, []byte("users:2"), []byte("alice"), 0, "", "*"))
+	assert.Equal(t, client.ErrInvalidValue, c.Append(ctx, []byte("users:1"), []byte(" ")))
+
+	// The rejected writes left the stored value alone.
+	value, err := c.Get(ctx, []byte("users:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"name": "alice"}`), value)
+
+	// Keys without a namespace fall under the default one.
+	assert.Nil(t, c.Set(ctx, []byte("motd"), []byte("hello"), 0))
+	assert.Equal(t, client.ErrInvalidValue, c.Set(ctx, []byte("motd"), []byte{0x00, 0x01}, 0))
+
+	// Other namespaces are not validated.
+	assert.Nil(t, c.Set(ctx, []byte("blobs:1"), []byte{0x00, 0x01}, 0))
+	assert.Nil(t, c.Append(ctx, []byte("blobs:1"), []byte{0x02}))
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// The formats of the records a Warmer reads.
+const (
+	// WarmCSV records are key,value[,ttl] lines without a header.
+	WarmCSV = "csv"
+	// WarmJSONL records are {"key": ..., "value": ..., "ttl": ...} objects,
+	// one per line.
+	WarmJSONL = "jsonl"
+)
+
+// Warmer fills the cache with the records of a local file or of the
+// response to a GET of an HTTP endpoint before the server accepts
+// connections. It is meant as an OnReady hook, or one of those WarmAll
+// chains.
+type Warmer struct {
+	// Path of the file, or URL of the endpoint. Exactly one is set.
+	Path string
+	URL  string
+
+	// Format is WarmCSV or WarmJSONL, guessed from the extension of Path or
+	// URL, or the Content-Type of the response, if empty.
+	Format string
+
+	// TTL is the TTL of the records that do not carry one, none if zero.
+	TTL time.Duration
+
+	Client *http.Client
+}
+
+// warmRecord is a WarmJSONL record. TTL is a time.Duration string such as
+// "10m".
+type warmRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// Warm sets the records in c, stopping at the first one that cannot be read
+// or set.
+func (w Warmer) Warm(c ggcache.Cacher) error {
+	source, format := w.Path, w.Format
+	var r io.Reader
+	if len(w.URL) != 0 {
+		source = w.URL
+		resp, err := w.get()
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if len(format) == 0 {
+			format = formatOf(w.URL)
+		}
+		if len(format) == 0 {
+			if t, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); t == "text/csv" {
+				format = WarmCSV
+			}
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(w.Path)
+		if err != nil {
+			return fmt.Errorf("warm: %w", err)
+		}
+		defer f.Close()
+		if len(format) == 0 {
+			format = formatOf(w.Path)
+		}
+		r = f
+	}
+
+	var (
+		n   int
+		err error
+	)
+	switch format {
+	case WarmCSV:
+		n, err = w.warmCSV(c, r)
+	case WarmJSONL:
+		n, err = w.warmJSONL(c, r)
+	default:
+		return fmt.Errorf("warm [%s]: unknown format [%s]", source, format)
+	}
+	if err != nil {
+		return fmt.Errorf("warm [%s]: %w", source, err)
+	}
+
+	log.Printf("warmed %d keys from [%s]\n", n, source)
+	return nil
+}
+
+func (w Warmer) get() (*http.Response, error) {
+	httpClient := w.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.URL, nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("warm: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("warm: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("warm [%s]: unexpected status %s", w.URL, resp.Status)
+	}
+	resp.Body = cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelBody releases the context of a request once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (w Warmer) warmCSV(c ggcache.Cacher, r io.Reader) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	n := 0
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if len(rec) < 2 || len(rec) > 3 {
+			line, _ := cr.FieldPos(0)
+			return n, fmt.Errorf("line %d: expected key,value[,ttl]", line)
+		}
+		ttl := ""
+		if len(rec) == 3 {
+			ttl = rec[2]
+		}
+		if err := w.set(c, rec[0], rec[1], ttl); err != nil {
+			line, _ := cr.FieldPos(0)
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		n++
+	}
+}
+
+func (w Warmer) warmJSONL(c ggcache.Cacher, r io.Reader) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	n := 0
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var rec warmRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := w.set(c, rec.Key, rec.Value, rec.TTL); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		n++
+	}
+	return n, sc.Err()
+}
+
+func (w Warmer) set(c ggcache.Cacher, key, value, ttlStr string) error {
+	if len(key) == 0 {
+		return errors.New("empty key")
+	}
+	ttl := w.TTL
+	if len(ttlStr) != 0 {
+		var err error
+		if ttl, err = time.ParseDuration(ttlStr); err != nil {
+			return err
+		}
+	}
+	return c.Set([]byte(key), []byte(value), ttl)
+}
+
+// formatOf guesses the format of the records from the extension of a path
+// or URL, returning "" if it cannot.
+func formatOf(name string) string {
+	if u, err := url.Parse(name); err == nil && len(u.Scheme) != 0 {
+		name = u.Path
+	}
+	switch path.Ext(name) {
+	case ".csv":
+		return WarmCSV
+	case ".jsonl", ".ndjson":
+		return WarmJSONL
+	}
+	return ""
+}
+
+// WarmAll returns an OnReady hook running the warmers in order, stopping at
+// the first that fails.
+func WarmAll(warmers ...Warmer) func(ggcache.Cacher) error {
+	return func(c ggcache.Cacher) error {
+		for _, w := range warmers {
+			if err := w.Warm(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
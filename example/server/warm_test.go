@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmer(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "seed.csv")
+	assert.Nil(t, os.WriteFile(csvPath, []byte("foo,bar\n\"a,b\",c,1h\n"), 0o600))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"key": "baz", "value": "qux"}` + "\n\n" + `{"key": "tmp", "value": "1", "ttl": "10ms"}` + "\n"))
+	}))
+	defer srv.Close()
+
+	c := ggcache.New()
+	assert.Nil(t, WarmAll(
+		Warmer{Path: csvPath},
+		Warmer{URL: srv.URL + "/export", Format: WarmJSONL},
+	)(c))
+
+	for key, value := range map[string]string{"foo": "bar", "a,b": "c", "baz": "qux"} {
+		v, err := c.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, string(v))
+	}
+	assert.Eventually(t, func() bool {
+		return !c.Has([]byte("tmp"))
+	}, time.Second, 10*time.Millisecond)
+
+	// The format cannot be guessed from the URL nor the Content-Type.
+	assert.Error(t, Warmer{URL: srv.URL}.Warm(c))
+	assert.Error(t, Warmer{Path: filepath.Join(dir, "missing.csv")}.Warm(c))
+
+	bad := filepath.Join(dir, "bad.jsonl")
+	assert.Nil(t, os.WriteFile(bad, []byte(`{"key": "x", "value": "y", "ttl": "soon"}`), 0o600))
+	assert.ErrorContains(t, Warmer{Path: bad}.Warm(c), "line 1")
+}
+
+func TestOnReady(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader: true,
+		OnReady: func(c ggcache.Cacher) error {
+			return c.Set([]byte("foo"), []byte("bar"), 0)
+		},
+	}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	// The connection is only served once the cache is warm.
+	value, err := c.Get(context.Background(), []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", string(value))
+
+	s = NewServer(ServerOpts{
+		ListenAddr: "127.0.0.1:0",
+		OnReady:    func(ggcache.Cacher) error { return errors.New("no seed") },
+	}, ggcache.New())
+	assert.ErrorContains(t, s.Start(), "no seed")
+}
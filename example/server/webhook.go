@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+const (
+	// DefaultWebhookBatchSize is the number of events posted at once if
+	// RemovalWebhook.BatchSize is zero.
+	DefaultWebhookBatchSize = 100
+	// DefaultWebhookBuffer is the number of events waiting to be posted if
+	// RemovalWebhook.Buffer is zero.
+	DefaultWebhookBuffer = 10000
+	// DefaultWebhookBackoff is the wait before the first retry of a failed
+	// post if RemovalWebhook.Backoff is zero.
+	DefaultWebhookBackoff = 100 * time.Millisecond
+)
+
+// RemovalWebhook posts the keys the cache expires or evicts to an HTTP
+// endpoint in batches, so external systems can react to the end of the life
+// of the entries without holding a subscriber connection. The cache must be
+// a ggcache.RemovalWatcher. A batch is posted as a JSON object:
+//
+//	{"events": [{"key": "sessions:42", "reason": "expired", "time": "2024-01-02T15:04:05.123Z"}]}
+//
+// The reason is expired or evicted. Events are only reported by the node
+// removing the entry, and are lost if the node stops before posting them.
+type RemovalWebhook struct {
+	// URL is where the batches are posted.
+	URL string
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string
+	// Prefixes are the prefixes of the keys to report, all of them if empty.
+	Prefixes []string
+
+	// BatchSize bounds the events of a batch, DefaultWebhookBatchSize if
+	// zero. A batch is posted once full, or Interval, a second if zero,
+	// after its first event.
+	BatchSize int
+	Interval  time.Duration
+
+	// Retries is the number of times a failed post is retried before its
+	// batch is dropped, waiting Backoff, DefaultWebhookBackoff if zero,
+	// before the first retry and twice as long before each next one.
+	Retries int
+	Backoff time.Duration
+
+	// Buffer bounds the events waiting to be posted, DefaultWebhookBuffer if
+	// zero. The events removed while it is full are dropped.
+	Buffer int
+	Client *http.Client
+}
+
+// removalEvent is an entry removal as posted to the webhook.
+type removalEvent struct {
+	Key    string    `json:"key"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// webhookState holds the removals waiting to be posted to the webhook.
+type webhookState struct {
+	events chan removalEvent
+
+	// sent counts the events posted, failed those of the batches dropped
+	// after their last retry, and dropped those that did not fit the buffer.
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// watchRemovals has the removals of the cache queued for the webhook and
+// starts posting them until the server is closed.
+func (s *Server) watchRemovals() error {
+	w, ok := s.cache.(ggcache.RemovalWatcher)
+	if !ok {
+		return errors.New("removal webhook: the cache is not a ggcache.RemovalWatcher")
+	}
+	size := s.RemovalWebhook.Buffer
+	if size <= 0 {
+		size = DefaultWebhookBuffer
+	}
+	s.webhook.events = make(chan removalEvent, size)
+	w.WatchRemovals(s.queueRemoval)
+	go s.webhookLoop(w)
+	return nil
+}
+
+// queueRemoval queues the removal of the key if it has one of the prefixes.
+// It is called with the cache locked, so it never waits for the buffer.
+func (s *Server) queueRemoval(key []byte, reason ggcache.RemovalReason) {
+	if !s.RemovalWebhook.matches(key) {
+		return
+	}
+	select {
+	case s.webhook.events <- removalEvent{Key: string(key), Reason: reason.String(), Time: time.Now()}:
+	default:
+		s.webhook.dropped.Add(1)
+	}
+}
+
+// matches reports whether the removals of the key are reported.
+func (h *RemovalWebhook) matches(key []byte) bool {
+	if len(h.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range h.Prefixes {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookLoop posts the queued removals in batches until the server is
+// closed, then posts the batch it holds one last time.
+func (s *Server) webhookLoop(w ggcache.RemovalWatcher) {
+	defer w.WatchRemovals(nil)
+
+	h := s.RemovalWebhook
+	size := h.BatchSize
+	if size <= 0 {
+		size = DefaultWebhookBatchSize
+	}
+	interval := h.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	var (
+		batch   []removalEvent
+		timer   = time.NewTimer(interval)
+		flushch <-chan time.Time
+	)
+	timer.Stop()
+	flush := func() {
+		s.postRemovals(batch)
+		batch = nil
+		flushch = nil
+	}
+	for {
+		select {
+		case <-s.quitch:
+			if len(batch) != 0 {
+				flush()
+			}
+			return
+		case event := <-s.webhook.events:
+			if len(batch) == 0 {
+				timer.Reset(interval)
+				flushch = timer.C
+			}
+			batch = append(batch, event)
+			if len(batch) >= size {
+				timer.Stop()
+				flush()
+			}
+		case <-flushch:
+			flush()
+		}
+	}
+}
+
+// postRemovals posts the batch, retrying with backoff until it succeeds, it
+// ran out of retries or the server is closed.
+func (s *Server) postRemovals(batch []removalEvent) {
+	h := s.RemovalWebhook
+	backoff := h.Backoff
+	if backoff <= 0 {
+		backoff = DefaultWebhookBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		err := h.post(context.Background(), batch)
+		if err == nil {
+			s.webhook.sent.Add(uint64(len(batch)))
+			return
+		}
+		if attempt >= h.Retries {
+			log.Printf("removal webhook: dropped %d events: %s\n", len(batch), err)
+			s.webhook.failed.Add(uint64(len(batch)))
+			return
+		}
+		select {
+		case <-s.quitch:
+			s.webhook.failed.Add(uint64(len(batch)))
+			return
+		case <-time.After(backoff << attempt):
+		}
+	}
+}
+
+// post sends the events to the webhook once.
+func (h *RemovalWebhook) post(ctx context.Context, events []removalEvent) error {
+	body, err := json.Marshal(struct {
+		Events []removalEvent `json:"events"`
+	}{events})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
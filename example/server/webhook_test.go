@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemovalWebhook(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		events   []removalEvent
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		// The first post fails, and is retried.
+		if attempts++; attempts == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var batch struct {
+			Events []removalEvent `json:"events"`
+		}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&batch))
+		events = append(events, batch.Events...)
+	}))
+	defer hook.Close()
+
+	s, c, err := StartEmbedded(ServerOpts{
+		IsLeader: true,
+		RemovalWebhook: &RemovalWebhook{
+			URL:      hook.URL,
+			Headers:  map[string]string{"Authorization": "secret"},
+			Prefixes: []string{"sessions:"},
+			Interval: 20 * time.Millisecond,
+			Retries:  2,
+			Backoff:  10 * time.Millisecond,
+		},
+	}, ggcache.New())
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, []byte("sessions:1"), []byte("a"), 10*time.Millisecond))
+	assert.Nil(t, c.Set(ctx, []byte("sessions:2"), []byte("b"), 10*time.Millisecond))
+	assert.Nil(t, c.Set(ctx, []byte("users:1"), []byte("c"), 10*time.Millisecond))
+
+	assert.Eventually(t, func() bool {
+		return stat(s, "server_webhook_events_total") == 2
+	}, time.Second, 5*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, attempts)
+	keys := make(map[string]string)
+	for _, event := range events {
+		keys[event.Key] = event.Reason
+		assert.False(t, event.Time.IsZero())
+	}
+	assert.Equal(t, map[string]string{"sessions:1": "expired", "sessions:2": "expired"}, keys)
+	assert.Zero(t, stat(s, "server_webhook_failed_total"))
+}
+
+func TestRemovalWebhookUnsupported(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	// Only the Cacher methods of the cache are visible.
+	c := struct{ ggcache.Cacher }{ggcache.New()}
+	s := NewServer(ServerOpts{IsLeader: true, RemovalWebhook: &RemovalWebhook{URL: "http://127.0.0.1:1"}}, c)
+	defer s.Close()
+	assert.ErrorContains(t, s.Serve(ln), "ggcache.RemovalWatcher")
+}
@@ -0,0 +1,524 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthdm/ggcache"
+)
+
+// The WebSocket gateway lets browsers talk to the cache with JSON messages
+// over RFC 6455 text frames. Every request carries an op and an optional id
+// that is echoed in its response:
+//
+//	{"id": 1, "op": "get", "key": "users:1"}
+//	{"id": 2, "op": "set", "key": "users:1", "value": "alice", "ttl_ms": 60000}
+//	{"id": 3, "op": "subscribe", "prefix": "users:"}
+//	{"id": 4, "op": "unsubscribe"}
+//
+// Responses have a status of ok, not_found, precondition_failed, invalid or
+// error. A set is invalid if its value does not pass the validators of its
+// namespace, the error saying why.
+// A get answers with the value and its ETag:
+//
+//	{"id": 1, "status": "ok", "value": "alice", "etag": "1d1a..."}
+//
+// A set with if_match only succeeds if the key holds a value with that ETag,
+// one with if_none_match only if it does not; "*" matches any value. This
+// lets clients update a value optimistically without a version:
+//
+//	{"id": 5, "op": "set", "key": "users:1", "value": "bob", "if_match": "1d1a..."}
+//
+// Once subscribed, the keys set, deleted, touched or appended to through this
+// node whose name starts with the prefix are pushed as set, del, touch and
+// append events, with the value for sets and the appended data for appends.
+// A rename is pushed as a del of the old key and a rename of the new one, a
+// copy as a copy of the destination, both without the value:
+//
+//	{"event": "set", "key": "users:1", "value": "alice"}
+//
+// Keys and values are exchanged as JSON strings, so binary values are not
+// preserved. A subscriber that falls behind by more than wsEventBuffer
+// events is disconnected and has to subscribe again.
+
+// wsGUID is appended to the client key to compute the handshake accept key.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessage bounds the size of a message read from a client.
+const wsMaxMessage = 1 << 20
+
+// wsEventBuffer is the number of events queued for a slow subscriber.
+const wsEventBuffer = 256
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// KeyspaceEvent is a change made to a key through this node.
+type KeyspaceEvent struct {
+	Op    string
+	Key   []byte
+	Value []byte
+}
+
+// keyspaceEvents fans the keyspace events out to the subscribers.
+type keyspaceEvents struct {
+	mu   sync.Mutex
+	subs map[chan KeyspaceEvent]struct{}
+}
+
+func (e *keyspaceEvents) subscribe() chan KeyspaceEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.subs == nil {
+		e.subs = make(map[chan KeyspaceEvent]struct{})
+	}
+	ch := make(chan KeyspaceEvent, wsEventBuffer)
+	e.subs[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes the subscriber and closes its channel, unless publish
+// already did because it fell behind.
+func (e *keyspaceEvents) unsubscribe(ch chan KeyspaceEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.subs[ch]; ok {
+		delete(e.subs, ch)
+		close(ch)
+	}
+}
+
+func (e *keyspaceEvents) publish(event KeyspaceEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subs {
+		select {
+		case ch <- event:
+		default:
+			// Closing tells the subscriber it missed events.
+			delete(e.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// serveWebSocket starts the WebSocket gateway on WebSocketAddr. It is closed
+// together with the server.
+func (s *Server) serveWebSocket() error {
+	ln, err := net.Listen("tcp", s.WebSocketAddr)
+	if err != nil {
+		return fmt.Errorf("websocket listen error: %s", err)
+	}
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+	srv := &http.Server{Handler: s.WebSocketHandler()}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ln.Close()
+	}
+	s.websocket = srv
+	s.mu.Unlock()
+
+	log.Printf("websocket gateway starting on [%s]\n", ln.Addr())
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("websocket serve error:", err)
+		}
+	}()
+	return nil
+}
+
+// WebSocketHandler returns the handler of the WebSocket gateway, which can
+// be mounted on an existing mux instead of setting WebSocketAddr.
+func (s *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(s.handleWebSocket)
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err != nil || !s.isAllowed(net.TCPAddrFromAddrPort(addr)) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if !s.allowedOrigin(r.Header.Get("Origin")) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		len(key) == 0 {
+		http.Error(w, "expected a websocket upgrade", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		log.Println("websocket hijack error:", err)
+		return
+	}
+	if s.trackConn(conn, "websocket", operator) == nil {
+		_ = conn.Close()
+		return
+	}
+	defer func() {
+		s.untrackConn(conn)
+		_ = conn.Close()
+	}()
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ws := &wsConn{conn: conn, r: rw.Reader}
+	s.serveWebSocketConn(ws)
+}
+
+// allowedOrigin reports whether a browser on origin may connect. Requests
+// without an Origin header do not come from a browser and are allowed.
+func (s *Server) allowedOrigin(origin string) bool {
+	if len(s.WebSocketOrigins) == 0 || len(origin) == 0 {
+		return true
+	}
+	for _, allowed := range s.WebSocketOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsRequest is a message sent by a WebSocket client.
+type wsRequest struct {
+	ID     int64  `json:"id,omitempty"`
+	Op     string `json:"op"`
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+	TTLMs  int64  `json:"ttl_ms,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+
+	IfMatch     string `json:"if_match,omitempty"`
+	IfNoneMatch string `json:"if_none_match,omitempty"`
+}
+
+// wsResponse is the answer to a wsRequest.
+type wsResponse struct {
+	ID     int64   `json:"id,omitempty"`
+	Status string  `json:"status"`
+	Value  *string `json:"value,omitempty"`
+	ETag   string  `json:"etag,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// wsEvent is a keyspace event pushed to a subscriber.
+type wsEvent struct {
+	Event string  `json:"event"`
+	Key   string  `json:"key"`
+	Value *string `json:"value,omitempty"`
+}
+
+func (s *Server) serveWebSocketConn(ws *wsConn) {
+	var (
+		events chan KeyspaceEvent
+		stop   chan struct{}
+	)
+	unsubscribe := func() {
+		if events != nil {
+			close(stop)
+			s.events.unsubscribe(events)
+			events, stop = nil, nil
+		}
+	}
+	defer unsubscribe()
+
+	for {
+		msg, err := ws.readMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Println("websocket read error:", err)
+			}
+			return
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			_ = ws.writeJSON(wsResponse{Status: "error", Error: "invalid message: " + err.Error()})
+			continue
+		}
+
+		resp := wsResponse{ID: req.ID, Status: "ok"}
+		switch req.Op {
+		case "get":
+			value, err := s.cache.Get([]byte(req.Key))
+			if err != nil {
+				resp.Status = "not_found"
+				break
+			}
+			v := string(value)
+			resp.Value = &v
+			resp.ETag = ggcache.ETag(value)
+		case "set":
+			var (
+				key = []byte(req.Key)
+				ttl = time.Duration(req.TTLMs) * time.Millisecond
+				err error
+			)
+			if req.IfMatch != "" || req.IfNoneMatch != "" {
+				cond := ggcache.Condition{IfMatch: req.IfMatch, IfNoneMatch: req.IfNoneMatch}
+				err = s.setIf(key, []byte(req.Value), ttl, cond)
+			} else {
+				err = s.set(key, []byte(req.Value), ttl)
+			}
+			switch {
+			case errors.Is(err, ggcache.ErrPreconditionFailed):
+				resp.Status = "precondition_failed"
+			case errors.Is(err, errInvalidValue):
+				resp.Status = "invalid"
+				resp.Error = err.Error()
+			case err != nil:
+				resp.Status = "error"
+				resp.Error = err.Error()
+			}
+		case "subscribe":
+			unsubscribe()
+			events, stop = s.events.subscribe(), make(chan struct{})
+			go forwardEvents(ws, events, req.Prefix, stop)
+		case "unsubscribe":
+			unsubscribe()
+		default:
+			resp.Status = "error"
+			resp.Error = fmt.Sprintf("unknown op [%s]", req.Op)
+		}
+		if err := ws.writeJSON(resp); err != nil {
+			return
+		}
+	}
+}
+
+// forwardEvents writes the events matching prefix to the client until stop
+// is closed. If publish closes the events instead, the client fell behind
+// and the connection is closed.
+func forwardEvents(ws *wsConn, events chan KeyspaceEvent, prefix string, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case event, ok := <-events:
+			if !ok {
+				select {
+				case <-stop:
+				default:
+					_ = ws.close(1008, "subscriber too slow")
+				}
+				return
+			}
+			if !bytes.HasPrefix(event.Key, []byte(prefix)) {
+				continue
+			}
+			msg := wsEvent{Event: event.Op, Key: string(event.Key)}
+			if event.Value != nil {
+				v := string(event.Value)
+				msg.Value = &v
+			}
+			if err := ws.writeJSON(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// wsConn is the server side of a WebSocket connection. Reads happen on a
+// single goroutine; writes are serialized by mu.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// readMessage returns the payload of the next text or binary message,
+// answering pings and reassembling fragments on the way.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			_ = c.close(1000, "")
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			if len(msg)+len(payload) > wsMaxMessage {
+				_ = c.close(1009, "message too big")
+				return nil, errors.New("websocket message too big")
+			}
+			msg = append(msg, payload...)
+			if fin {
+				return msg, nil
+			}
+		default:
+			_ = c.close(1002, "unknown opcode")
+			return nil, fmt.Errorf("websocket: unknown opcode %d", op)
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		_ = c.close(1002, "client frames must be masked")
+		return false, 0, nil, errors.New("websocket: unmasked client frame")
+	}
+
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage {
+		_ = c.close(1009, "message too big")
+		return false, 0, nil, errors.New("websocket message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+func (c *wsConn) writeJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, b)
+}
+
+// writeFrame writes a single unmasked frame, as servers must.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.writeFrameLocked(op, payload)
+}
+
+func (c *wsConn) writeFrameLocked(op byte, payload []byte) error {
+	header := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+// close sends a close frame with the status code and closes the connection.
+func (c *wsConn) close(code uint16, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	_ = c.writeFrameLocked(wsOpClose, append(payload, reason...))
+	return c.conn.Close()
+}
+
+// headerContains reports whether the comma separated header contains the
+// token, ignoring case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
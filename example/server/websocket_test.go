@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthdm/ggcache"
+	"github.com/stretchr/testify/assert"
+)
+
+// wsTestClient is a minimal WebSocket client sending masked text frames.
+type wsTestClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, addr, origin string) (*wsTestClient, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\nOrigin: %s\r\n\r\n",
+		addr, base64.StdEncoding.EncodeToString(key), origin)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	assert.Nil(t, err)
+	return &wsTestClient{conn: conn, r: r}, resp
+}
+
+func (c *wsTestClient) send(t *testing.T, v any) {
+	payload, err := json.Marshal(v)
+	assert.Nil(t, err)
+
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | wsOpText, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err = c.conn.Write(frame)
+	assert.Nil(t, err)
+}
+
+func (c *wsTestClient) recv(t *testing.T) map[string]any {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(c.r, header)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0x80|wsOpText), header[0])
+
+	n := int(header[1])
+	if n == 126 {
+		ext := make([]byte, 2)
+		_, _ = io.ReadFull(c.r, ext)
+		n = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, n)
+	_, err = io.ReadFull(c.r, payload)
+	assert.Nil(t, err)
+
+	var msg map[string]any
+	assert.Nil(t, json.Unmarshal(payload, &msg))
+	return msg
+}
+
+func TestWebSocketGateway(t *testing.T) {
+	s, c, err := StartEmbedded(ServerOpts{IsLeader: true, WebSocketOrigins: []string{"https://dashboard.example"}}, nil)
+	assert.Nil(t, err)
+	defer s.Close()
+	defer c.Close()
+
+	gw := httptest.NewServer(s.WebSocketHandler())
+	defer gw.Close()
+	addr := gw.Listener.Addr().String()
+
+	_, resp := dialWebSocket(t, addr, "https://evil.example")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	ws, resp := dialWebSocket(t, addr, "https://dashboard.example")
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	defer ws.conn.Close()
+
+	ws.send(t, map[string]any{"id": 1, "op": "set", "key": "users:1", "value": "alice"})
+	assert.Equal(t, map[string]any{"id": float64(1), "status": "ok"}, ws.recv(t))
+
+	ws.send(t, map[string]any{"id": 2, "op": "get", "key": "users:1"})
+	etag := ggcache.ETag([]byte("alice"))
+	assert.Equal(t, map[string]any{"id": float64(2), "status": "ok", "value": "alice", "etag": etag}, ws.recv(t))
+
+	ws.send(t, map[string]any{"op": "set", "key": "users:1", "value": "carol", "if_match": etag})
+	assert.Equal(t, "ok", ws.recv(t)["status"])
+	ws.send(t, map[string]any{"op": "set", "key": "users:1", "value": "dave", "if_match": etag})
+	assert.Equal(t, "precondition_failed", ws.recv(t)["status"])
+
+	ws.send(t, map[string]any{"id": 3, "op": "get", "key": "users:2"})
+	assert.Equal(t, "not_found", ws.recv(t)["status"])
+
+	ws.send(t, map[string]any{"id": 4, "op": "subscribe", "prefix": "users:"})
+	assert.Equal(t, "ok", ws.recv(t)["status"])
+
+	// Writes through the binary protocol are pushed to the subscriber.
+	assert.Nil(t, c.Set(context.Background(), []byte("orders:1"), []byte("ignored"), 0))
+	assert.Nil(t, c.Set(context.Background(), []byte("users:2"), []byte("bob"), 0))
+	assert.Equal(t, map[string]any{"event": "set", "key": "users:2", "value": "bob"}, ws.recv(t))
+	assert.Nil(t, c.Delete(context.Background(), []byte("users:2")))
+	assert.Equal(t, map[string]any{"event": "del", "key": "users:2"}, ws.recv(t))
+
+	ws.send(t, map[string]any{"id": 5, "op": "flush"})
+	assert.Equal(t, "unknown op [flush]", ws.recv(t)["error"])
+}
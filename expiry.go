@@ -0,0 +1,228 @@
+package ggcache
+
+import (
+	"container/heap"
+	"slices"
+	"time"
+)
+
+// expiryHeap holds the expirations of the entries stored in bulk, earliest
+// first. They share a single timer instead of having one each, which would
+// make a bulk load of millions of entries with a TTL spend most of its time
+// in the runtime timer heap.
+//
+// An item is not removed when its entry is replaced or deleted: expireBulk
+// skips the items whose entry is no longer current, so they only take up
+// memory until the time their entry would have expired.
+type expiryHeap []expiryItem
+
+type expiryItem struct {
+	key string
+	e   *entry
+}
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].e.expiresAt.Before(h[j].e.expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) { *h = append(*h, x.(expiryItem)) }
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old) - 1
+	item := old[n]
+	old[n] = expiryItem{}
+	*h = old[:n]
+	return item
+}
+
+// storeBulk stores the records of a bulk load such as Restore. The entries
+// with a TTL are appended to the expiry heap, which is then fixed up once,
+// instead of each starting a timer. Records that are deleted or already
+// expired are skipped. The caller must hold the write lock.
+func (c *Cache) storeBulk(recs []*Record, now time.Time) {
+	if len(c.data) == 0 {
+		// Size the map up front rather than growing it step by step.
+		c.data = make(map[string]*entry, len(recs))
+		c.shared = false
+	}
+	c.expiry = slices.Grow(c.expiry, len(recs))
+
+	for _, rec := range recs {
+		ttl := rec.TTL(now)
+		if ttl < 0 || rec.Flags&RecordDeleted != 0 {
+			continue
+		}
+
+		key := string(rec.Key)
+		c.remove(key)
+
+		e := &entry{value: rec.Value, created: now.UnixNano()}
+		if ttl > 0 {
+			e.expiresAt = now.Add(ttl)
+			e.ttlBucket = ttlBucket(ttl)
+			c.ttls[e.ttlBucket]++
+			c.expiry = append(c.expiry, expiryItem{key: key, e: e})
+		}
+		e.accessed.Store(c.idleTick.Load())
+		c.unshare()
+		c.data[key] = e
+		c.bytes += len(key) + len(rec.Value)
+	}
+
+	heap.Init(&c.expiry)
+	c.scheduleExpiry(now)
+	c.scheduleIdle()
+}
+
+// scheduleExpiry arms the expiry timer for the earliest entry of the expiry
+// heap. The caller must hold the write lock.
+func (c *Cache) scheduleExpiry(now time.Time) {
+	if len(c.expiry) == 0 {
+		return
+	}
+	d := c.expiry[0].e.expiresAt.Sub(now)
+	if c.expiryTimer == nil {
+		c.expiryTimer = time.AfterFunc(d, c.expireBulk)
+		return
+	}
+	c.expiryTimer.Reset(d)
+}
+
+// expireBulk removes the entries of the expiry heap whose TTL ran out and
+// rearms the timer for the next one.
+func (c *Cache) expireBulk() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	c.sweeps.record(now, c.sweep(now))
+}
+
+// sweep removes the entries of the expiry heap whose TTL ran out, rearms
+// the timer for the next one and returns how many it removed.
+// The caller must hold the write lock.
+func (c *Cache) sweep(now time.Time) int {
+	removed := 0
+	for len(c.expiry) > 0 && !c.expiry[0].e.expiresAt.After(now) {
+		item := heap.Pop(&c.expiry).(expiryItem)
+		if c.data[item.key] != item.e {
+			continue
+		}
+		c.remove(item.key)
+		c.expired(item.key, item.e)
+		removed++
+	}
+	if len(c.expiry) == 0 {
+		// Release the backing array of a large bulk load.
+		c.expiry = nil
+	}
+	c.scheduleExpiry(now)
+	return removed
+}
+
+// Sweeper is implemented by Cachers that remove their expired entries in
+// the background, so their backlog can be watched and worked off on demand.
+type Sweeper interface {
+	// ExpiryStats reports on the removal of the expired entries.
+	ExpiryStats() ExpiryStats
+	// Sweep removes every expired entry now and returns how many there were.
+	Sweep() int
+}
+
+// ExpiryStats is a point-in-time snapshot of the expiration of a Cache.
+type ExpiryStats struct {
+	// Heap is the number of items of the expiry heap, which holds the
+	// expirations of the entries stored in bulk, including those of the
+	// entries replaced or deleted since. Timers is the number of entries
+	// expiring by their own timer instead.
+	Heap   int
+	Timers int
+
+	// NextExpiry is the earliest expiration of the heap, the zero Time if
+	// it is empty. Backlog is the number of entries of the heap that
+	// expired but are not removed yet.
+	NextExpiry time.Time
+	Backlog    int
+
+	// Sweeps counts the runs of the heap, which removed Swept entries in
+	// SweepTime overall, the latest in LastSweep.
+	Sweeps    uint64
+	Swept     uint64
+	SweepTime time.Duration
+	LastSweep time.Duration
+}
+
+type sweepStats struct {
+	runs    uint64
+	removed uint64
+	total   time.Duration
+	last    time.Duration
+}
+
+// record counts a sweep started at start that removed n entries.
+func (s *sweepStats) record(start time.Time, n int) {
+	s.runs++
+	s.removed += uint64(n)
+	s.last = time.Since(start)
+	s.total += s.last
+}
+
+// ExpiryStats returns a snapshot of the expiry heap and of its sweeps.
+func (c *Cache) ExpiryStats() ExpiryStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	stats := ExpiryStats{
+		Heap:      len(c.expiry),
+		Timers:    c.timers,
+		Backlog:   c.backlog(0, time.Now()),
+		Sweeps:    c.sweeps.runs,
+		Swept:     c.sweeps.removed,
+		SweepTime: c.sweeps.total,
+		LastSweep: c.sweeps.last,
+	}
+	if len(c.expiry) != 0 {
+		stats.NextExpiry = c.expiry[0].e.expiresAt
+	}
+	return stats
+}
+
+// backlog counts the current entries of the subtree of the heap at i that
+// expired by now. Only the expired items and their children are visited,
+// as the items below one that did not expire did not either.
+// The caller must hold at least the read lock.
+func (c *Cache) backlog(i int, now time.Time) int {
+	if i >= len(c.expiry) || c.expiry[i].e.expiresAt.After(now) {
+		return 0
+	}
+	n := 0
+	if item := c.expiry[i]; c.data[item.key] == item.e {
+		n++
+	}
+	return n + c.backlog(2*i+1, now) + c.backlog(2*i+2, now)
+}
+
+// Sweep removes the expired entries of the expiry heap, along with those
+// whose timer did not fire yet, and returns how many it removed. The
+// expirations are counted in Stats as usual.
+func (c *Cache) Sweep() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	removed := c.sweep(now)
+	if c.timers != 0 {
+		for key, e := range c.data {
+			if e.timer != nil && !e.expiresAt.After(now) {
+				c.remove(key)
+				c.expired(key, e)
+				removed++
+			}
+		}
+	}
+	c.sweeps.record(now, removed)
+	return removed
+}
@@ -0,0 +1,151 @@
+// Package ggcachetest spins up ephemeral ggcache nodes and clusters on random
+// loopback ports so downstream projects can integration-test against a real
+// server from within go test.
+//
+//	func TestMyService(t *testing.T) {
+//		cluster := ggcachetest.StartCluster(t, 3)
+//		c := cluster.Leader.Client(t)
+//		...
+//	}
+//
+// Every node and client is torn down automatically when the test finishes.
+package ggcachetest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anthdm/ggcache"
+	"github.com/anthdm/ggcache/example/client"
+	"github.com/anthdm/ggcache/example/server"
+)
+
+// ReadyTimeout is how long StartNode and StartCluster wait for the nodes to
+// become ready before failing the test.
+var ReadyTimeout = 5 * time.Second
+
+// Node is a single running ggcache server.
+type Node struct {
+	Server *server.Server
+	Cache  ggcache.Cacher
+	Addr   string
+}
+
+// Client returns a new client connected to the node. It is closed when the
+// test finishes.
+func (n *Node) Client(t testing.TB) *client.Client {
+	t.Helper()
+
+	c, err := client.New(n.Addr, client.Options{})
+	if err != nil {
+		t.Fatalf("ggcachetest: dial node [%s]: %s", n.Addr, err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+
+	return c
+}
+
+// Cluster is a leader with its followers.
+type Cluster struct {
+	Leader    *Node
+	Followers []*Node
+}
+
+// Nodes returns the leader followed by the followers.
+func (c *Cluster) Nodes() []*Node {
+	return append([]*Node{c.Leader}, c.Followers...)
+}
+
+// StartNode starts a single leader node on a random port.
+func StartNode(t testing.TB) *Node {
+	t.Helper()
+
+	return startNode(t, server.ServerOpts{IsLeader: true})
+}
+
+// StartCluster starts a leader and size-1 followers and waits until every
+// follower has joined the leader.
+func StartCluster(t testing.TB, size int) *Cluster {
+	t.Helper()
+
+	if size < 1 {
+		t.Fatalf("ggcachetest: cluster size must be at least 1, got %d", size)
+	}
+
+	cluster := &Cluster{
+		Leader: StartNode(t),
+	}
+	for i := 1; i < size; i++ {
+		follower := startNode(t, server.ServerOpts{
+			LeaderAddr: cluster.Leader.Addr,
+		})
+		cluster.Followers = append(cluster.Followers, follower)
+	}
+
+	waitFor(t, func() bool {
+		return cluster.Leader.Server.MemberCount() == size-1
+	}, "followers to join the leader")
+
+	return cluster
+}
+
+func startNode(t testing.TB, opts server.ServerOpts) *Node {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ggcachetest: listen: %s", err)
+	}
+
+	opts.ListenAddr = ln.Addr().String()
+	node := &Node{
+		Cache: ggcache.New(),
+		Addr:  opts.ListenAddr,
+	}
+	node.Server = server.NewServer(opts, node.Cache)
+
+	go func() {
+		_ = node.Server.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = node.Server.Close()
+	})
+
+	// The listener is bound already, so only an answered PING shows the
+	// server is serving it.
+	waitFor(t, func() bool {
+		return ping(node.Addr) == nil
+	}, "node to answer a PING")
+
+	return node
+}
+
+// ping sends a PING to the node at addr over a new connection.
+func ping(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, err := client.New(addr, client.Options{})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.Ping(ctx)
+}
+
+func waitFor(t testing.TB, ready func() bool, what string) {
+	t.Helper()
+
+	deadline := time.Now().Add(ReadyTimeout)
+	for !ready() {
+		if time.Now().After(deadline) {
+			t.Fatalf("ggcachetest: timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
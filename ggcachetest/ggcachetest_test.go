@@ -0,0 +1,55 @@
+package ggcachetest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartNode(t *testing.T) {
+	node := StartNode(t)
+	c := node.Client(t)
+
+	assert.Nil(t, c.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+
+	value, err := c.Get(context.Background(), []byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+}
+
+func TestStartCluster(t *testing.T) {
+	cluster := StartCluster(t, 3)
+	assert.Len(t, cluster.Nodes(), 3)
+
+	c := cluster.Leader.Client(t)
+	assert.Nil(t, c.Set(context.Background(), []byte("foo"), []byte("bar"), 0))
+
+	for _, follower := range cluster.Followers {
+		waitFor(t, func() bool {
+			value, err := follower.Cache.Get([]byte("foo"))
+			return err == nil && string(value) == "bar"
+		}, "write to replicate")
+	}
+}
+
+func TestStartClusterTeardown(t *testing.T) {
+	var addr string
+	t.Run("cluster", func(t *testing.T) {
+		addr = StartCluster(t, 2).Leader.Addr
+	})
+
+	_, err := net.DialTimeout("tcp", addr, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestPingUnserved(t *testing.T) {
+	// A bound listener accepts connections before the server serves them.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	assert.NotNil(t, ping(ln.Addr().String()))
+}
@@ -0,0 +1,163 @@
+package ggcache
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Getter loads the value of a key from the system of record. In a Group the
+// value of a key must never change, so that every node loading it ends up
+// with the same bytes.
+type Getter func(ctx context.Context, key []byte) ([]byte, error)
+
+// PeerGetter fetches a key from the peer owning it, which loads it if needed.
+type PeerGetter interface {
+	Fill(ctx context.Context, key []byte) ([]byte, error)
+}
+
+// PeerPicker picks the peer owning a key.
+type PeerPicker interface {
+	// PickPeer returns the owner of the key, or false if this node owns it.
+	PickPeer(key []byte) (PeerGetter, bool)
+}
+
+// GroupOptions configure a Group.
+type GroupOptions struct {
+	// TTL is the expiration of loaded values, zero keeps them until evicted.
+	TTL time.Duration
+
+	// HotFraction is the fraction of values fetched from peers that are also
+	// kept locally in an in-memory Cache, so that keys read on every node are
+	// eventually served without a network hop. Zero uses 0.1, a negative
+	// value disables it.
+	HotFraction float64
+
+	// HotTTL is the expiration of hot copies, zero uses TTL.
+	HotTTL time.Duration
+}
+
+// Group fills the cache of a cluster groupcache-style, for immutable data.
+// Every key is owned by one node, picked by the PeerPicker. A miss on a node
+// is filled from the owner, and only the owner runs the Getter. Concurrent
+// misses for a key are coalesced on every node, so a key is loaded once
+// across the cluster however many nodes ask for it at the same time.
+type Group struct {
+	// cache holds the keys this node owns and hot holds copies of popular
+	// keys owned by peers.
+	cache Cacher
+	hot   Cacher
+
+	// getter loads the keys this node owns.
+	getter Getter
+
+	// peers picks the owner of a key, nil if this node owns every key.
+	peers PeerPicker
+
+	// opts are the options the group was created with.
+	opts GroupOptions
+
+	// lock guards calls.
+	lock sync.Mutex
+
+	// calls tracks the fills in flight, keyed by cache key.
+	calls map[string]*call
+}
+
+// NewGroup creates a Group storing the keys it owns in the specified Cacher
+// and loading them with the specified Getter. Peers may be nil for a single node.
+func NewGroup(c Cacher, getter Getter, peers PeerPicker, opts GroupOptions) *Group {
+	if opts.HotFraction == 0 {
+		opts.HotFraction = 0.1
+	}
+	if opts.HotTTL == 0 {
+		opts.HotTTL = opts.TTL
+	}
+	return &Group{
+		cache:  c,
+		hot:    New(),
+		getter: getter,
+		peers:  peers,
+		opts:   opts,
+		calls:  make(map[string]*call),
+	}
+}
+
+// Get returns the value of the key from the local caches, the owning peer or
+// the Getter, in that order. If the owner cannot be reached the key is loaded
+// locally instead.
+func (g *Group) Get(ctx context.Context, key []byte) ([]byte, error) {
+	if value, err := g.cache.Get(key); err == nil {
+		return value, nil
+	}
+	if value, err := g.hot.Get(key); err == nil {
+		return value, nil
+	}
+
+	return g.do(ctx, key, func() ([]byte, error) {
+		if g.peers != nil {
+			if peer, ok := g.peers.PickPeer(key); ok {
+				value, err := peer.Fill(ctx, key)
+				if err == nil {
+					if g.opts.HotFraction > 0 && rand.Float64() < g.opts.HotFraction {
+						_ = g.hot.Set(key, value, g.opts.HotTTL)
+					}
+					return value, nil
+				}
+			}
+		}
+		return g.load(ctx, key)
+	})
+}
+
+// Fill returns the value of a key this node owns, loading it if it is missing.
+// It is what a peer's PeerGetter ends up calling, and unlike Get it never
+// asks other peers, so nodes that disagree on the owner cannot loop.
+func (g *Group) Fill(ctx context.Context, key []byte) ([]byte, error) {
+	if value, err := g.cache.Get(key); err == nil {
+		return value, nil
+	}
+	return g.do(ctx, key, func() ([]byte, error) {
+		return g.load(ctx, key)
+	})
+}
+
+// load runs the Getter and stores the value.
+func (g *Group) load(ctx context.Context, key []byte) ([]byte, error) {
+	value, err := g.getter(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	_ = g.cache.Set(key, value, g.opts.TTL)
+	return value, nil
+}
+
+// do runs fn for the key unless a call for it is already in flight, in which
+// case it waits for that call's result instead.
+func (g *Group) do(ctx context.Context, key []byte, fn func() ([]byte, error)) ([]byte, error) {
+	keyStr := string(key)
+
+	g.lock.Lock()
+	if c, ok := g.calls[keyStr]; ok {
+		g.lock.Unlock()
+		select {
+		case <-c.done:
+			return c.value, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[keyStr] = c
+	g.lock.Unlock()
+
+	c.value, c.err = fn()
+
+	g.lock.Lock()
+	delete(g.calls, keyStr)
+	g.lock.Unlock()
+	close(c.done)
+
+	return c.value, c.err
+}
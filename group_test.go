@@ -0,0 +1,174 @@
+package ggcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// groupCluster wires groups together in-process, routing keys with a HashRing.
+type groupCluster struct {
+	ring   *HashRing
+	groups map[string]*Group
+	down   map[string]bool
+}
+
+type groupPicker struct {
+	cluster *groupCluster
+	self    string
+}
+
+func (p groupPicker) PickPeer(key []byte) (PeerGetter, bool) {
+	owner := p.cluster.ring.Get(key)
+	if owner == p.self || p.cluster.down[owner] {
+		return nil, false
+	}
+	return p.cluster.groups[owner], true
+}
+
+func newGroupCluster(nodes []string, getter Getter, opts GroupOptions) *groupCluster {
+	cluster := &groupCluster{
+		ring:   NewHashRing(0),
+		groups: make(map[string]*Group),
+		down:   make(map[string]bool),
+	}
+	cluster.ring.Add(nodes...)
+	for _, node := range nodes {
+		cluster.groups[node] = NewGroup(New(), getter, groupPicker{cluster, node}, opts)
+	}
+	return cluster
+}
+
+func TestGroup(t *testing.T) {
+	var loads atomic.Int32
+	getter := func(_ context.Context, key []byte) ([]byte, error) {
+		loads.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return append([]byte("value of "), key...), nil
+	}
+	nodes := []string{"a", "b", "c"}
+	cluster := newGroupCluster(nodes, getter, GroupOptions{HotFraction: -1})
+
+	// Every node asks for every key at the same time, yet each key is
+	// loaded once, by its owner.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		for _, node := range nodes {
+			wg.Add(1)
+			go func(g *Group) {
+				defer wg.Done()
+				value, err := g.Get(context.Background(), key)
+				assert.Nil(t, err)
+				assert.Equal(t, append([]byte("value of "), key...), value)
+			}(cluster.groups[node])
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, int32(10), loads.Load())
+
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		for _, node := range nodes {
+			owned := cluster.ring.Get(key) == node
+			assert.Equal(t, owned, cluster.groups[node].cache.Has(key))
+			assert.False(t, cluster.groups[node].hot.Has(key))
+		}
+	}
+}
+
+func TestGroupHotKeys(t *testing.T) {
+	getter := func(_ context.Context, key []byte) ([]byte, error) {
+		return key, nil
+	}
+	cluster := newGroupCluster([]string{"a", "b"}, getter, GroupOptions{HotFraction: 1})
+
+	key := []byte("hot")
+	var reader *Group
+	for node, g := range cluster.groups {
+		if cluster.ring.Get(key) != node {
+			reader = g
+		}
+	}
+
+	_, err := reader.Get(context.Background(), key)
+	assert.Nil(t, err)
+	assert.False(t, reader.cache.Has(key))
+	assert.True(t, reader.hot.Has(key))
+}
+
+func TestGroupPeerDown(t *testing.T) {
+	getter := func(_ context.Context, key []byte) ([]byte, error) {
+		if string(key) == "bad" {
+			return nil, errors.New("boom")
+		}
+		return key, nil
+	}
+	cluster := newGroupCluster([]string{"a", "b"}, getter, GroupOptions{})
+
+	key := []byte("foo")
+	owner := cluster.ring.Get(key)
+	cluster.down[owner] = true
+
+	// With the owner down the key is loaded locally.
+	for node, g := range cluster.groups {
+		if node == owner {
+			continue
+		}
+		value, err := g.Get(context.Background(), key)
+		assert.Nil(t, err)
+		assert.Equal(t, key, value)
+
+		_, err = g.Get(context.Background(), []byte("bad"))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestHashRing(t *testing.T) {
+	r := NewHashRing(0)
+	assert.Equal(t, "", r.Get([]byte("foo")))
+
+	r.Add("a", "b", "c")
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		owners[key] = r.Get([]byte(key))
+		counts[owners[key]]++
+	}
+	for _, node := range []string{"a", "b", "c"} {
+		assert.Greater(t, counts[node], 100)
+	}
+
+	// Adding a node only moves keys to the new node.
+	r.Add("d")
+	for key, owner := range owners {
+		if now := r.Get([]byte(key)); now != owner {
+			assert.Equal(t, "d", now)
+		}
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	assert.Equal(t, []byte("user:1"), HashTag([]byte("{user:1}:profile")))
+	assert.Equal(t, []byte("user:1"), HashTag([]byte("cart:{user:1}")))
+	assert.Equal(t, []byte("a"), HashTag([]byte("{a}{b}")))
+	assert.Equal(t, []byte("{a"), HashTag([]byte("{{a}}")))
+	assert.Equal(t, []byte("{}:key"), HashTag([]byte("{}:key")))
+	assert.Equal(t, []byte("{open"), HashTag([]byte("{open")))
+	assert.Equal(t, []byte("plain"), HashTag([]byte("plain")))
+
+	// Keys with the same tag are owned by the same node.
+	r := NewHashRing(0)
+	r.Add("a", "b", "c")
+	owner := r.Get([]byte("{user:1}:profile"))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, owner, r.Get([]byte(fmt.Sprintf("{user:1}:%d", i))))
+	}
+}
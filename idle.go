@@ -0,0 +1,66 @@
+package ggcache
+
+import "time"
+
+// idleGranules is the number of ticks of the idle clock in the max idle
+// time. Entries are evicted up to one tick past it.
+const idleGranules = 8
+
+// Option configures a Cache created with New.
+type Option func(*Cache)
+
+// WithMaxIdle makes the entries that are neither read nor written for d
+// eligible for eviction, whatever their TTL. Accesses are recorded as ticks
+// of a coarse clock advancing every d/8, so a read only writes to its entry
+// on the first access of a tick, and idle entries are evicted within d/8
+// past d. Evictions are counted in Stats.
+func WithMaxIdle(d time.Duration) Option {
+	return func(c *Cache) {
+		c.maxIdle = d
+	}
+}
+
+// accessed records a read of the entry. It only writes to the entry if the
+// idle clock ticked since its last access, so concurrent readers of a hot
+// entry do not keep writing to the same cache line.
+// The caller must hold at least the read lock.
+func (c *Cache) accessed(e *entry) {
+	if c.maxIdle <= 0 {
+		return
+	}
+	if tick := c.idleTick.Load(); e.accessed.Load() != tick {
+		e.accessed.Store(tick)
+	}
+}
+
+// scheduleIdle arms the idle timer if it is not running. It stops once the
+// cache is empty, so an unused cache does not keep a timer going.
+// The caller must hold the write lock.
+func (c *Cache) scheduleIdle() {
+	if c.maxIdle <= 0 || c.idleTimer != nil {
+		return
+	}
+	c.idleTimer = time.AfterFunc(c.maxIdle/idleGranules, c.evictIdle)
+}
+
+// evictIdle advances the idle clock and removes the entries not accessed for
+// more than the max idle time.
+func (c *Cache) evictIdle() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tick := c.idleTick.Add(1)
+	for key, e := range c.data {
+		if tick-e.accessed.Load() > idleGranules {
+			c.remove(key)
+			c.stats.evictions.Add(1)
+			c.removed(key, RemovalEvicted)
+		}
+	}
+
+	if len(c.data) == 0 {
+		c.idleTimer = nil
+		return
+	}
+	c.idleTimer.Reset(c.maxIdle / idleGranules)
+}
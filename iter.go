@@ -0,0 +1,62 @@
+//go:build go1.23
+
+package ggcache
+
+import "iter"
+
+// All returns an iterator over the live entries of the cache. The keys are
+// collected up front under a read lock, and the value of each is read as it
+// is visited, so the loop body may call into the cache: keys deleted or
+// expired in the meantime are skipped, and keys set in the meantime may not
+// be visited. Like Scan, it does not count hits or mark entries accessed.
+func (c *Cache) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for _, key := range c.keys() {
+			c.lock.RLock()
+			e, ok := c.data[key]
+			live := ok && !c.expiredOnRead(e)
+			var value []byte
+			if live {
+				value = e.value[:len(e.value):len(e.value)]
+			}
+			c.lock.RUnlock()
+			if live && !yield([]byte(key), value) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns an iterator over the keys of the live entries of the cache,
+// collected up front under a read lock like those of All.
+func (c *Cache) Keys() iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		for _, key := range c.keys() {
+			if !yield([]byte(key)) {
+				return
+			}
+		}
+	}
+}
+
+// keys returns the keys of the live entries.
+func (c *Cache) keys() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	keys := make([]string, 0, len(c.data))
+	for key, e := range c.data {
+		if !c.expiredOnRead(e) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// All returns an iterator over the entries of the view, in no particular
+// order, like Range.
+func (v View) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		v.Range(yield)
+	}
+}
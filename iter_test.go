@@ -0,0 +1,56 @@
+//go:build go1.23
+
+package ggcache
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCache_All tests the All and Keys iterators of the Cache.
+func TestCache_All(t *testing.T) {
+	cache := New()
+	_ = cache.Set([]byte("a"), []byte("1"), 0)
+	_ = cache.Set([]byte("b"), []byte("2"), 0)
+	_ = cache.Set([]byte("c"), []byte("3"), 0)
+	_ = cache.Set([]byte("expired"), []byte("4"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	// Test Case 1: Every live entry is visited
+	got := make(map[string]string)
+	for key, value := range cache.All() {
+		got[string(key)] = string(value)
+	}
+	if len(got) != 3 || got["a"] != "1" || got["b"] != "2" || got["c"] != "3" {
+		t.Errorf("Expected the 3 live entries, but got %v", got)
+	}
+
+	// Test Case 2: Breaking out of the loop stops the iteration
+	n := 0
+	for range cache.Keys() {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 key before the break, but got %d", n)
+	}
+
+	// Test Case 3: The loop body may write to the cache, and deleted keys
+	// are skipped
+	n = 0
+	for key := range cache.All() {
+		n++
+		for _, other := range []string{"a", "b", "c"} {
+			if other != string(key) {
+				_ = cache.Delete([]byte(other))
+			}
+		}
+	}
+	left := 0
+	for range cache.Keys() {
+		left++
+	}
+	if n != 1 || left != 1 {
+		t.Errorf("Expected 1 key visited and left, but got %d visited and %d left", n, left)
+	}
+}
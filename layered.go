@@ -0,0 +1,126 @@
+package ggcache
+
+import (
+	"errors"
+	"time"
+)
+
+// Watcher is implemented by Cachers that can report keys changed by other
+// writers, such as Redis keyspace notifications. Layered uses it to
+// invalidate its L1 when the L2 changes underneath it.
+type Watcher interface {
+	// Watch calls fn with every key that is set, deleted or expires until
+	// the returned stop function is called.
+	Watch(fn func(key []byte)) (stop func())
+}
+
+// LayeredOptions configure a LayeredCache.
+type LayeredOptions struct {
+	// L1TTL is the longest an entry is held in L1. It bounds how stale L1
+	// can get when the L2 cannot be watched or notifications are lost.
+	// Zero holds entries in L1 for as long as their TTL.
+	L1TTL time.Duration
+}
+
+// LayeredCache is a two-level Cacher: a small, fast L1, usually the
+// in-process Cache, in front of a larger or shared L2 such as a remote node,
+// Redis or memcached.
+type LayeredCache struct {
+	// l1 and l2 are the near and far levels.
+	l1 Cacher
+	l2 Cacher
+
+	// opts are the options the cache was created with.
+	opts LayeredOptions
+
+	// stop ends the watch on l2, nil if l2 is not a Watcher.
+	stop func()
+}
+
+// Layered creates a LayeredCache reading through l1 to l2.
+// Reads are served from L1 when possible and populate it from L2 on a miss.
+// Writes go to L2 first and then to L1. If l2 implements Watcher, keys
+// changed in L2 are invalidated in L1.
+func Layered(l1, l2 Cacher, opts LayeredOptions) *LayeredCache {
+	c := &LayeredCache{
+		l1:   l1,
+		l2:   l2,
+		opts: opts,
+	}
+	if w, ok := l2.(Watcher); ok {
+		c.stop = w.Watch(func(key []byte) {
+			_ = l1.Delete(key)
+		})
+	}
+	return c
+}
+
+// Get returns the value from L1, or from L2 and stores it in L1.
+func (c *LayeredCache) Get(key []byte) ([]byte, error) {
+	if value, err := c.l1.Get(key); err == nil {
+		return value, nil
+	}
+
+	value, err := c.l2.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	// The TTL left in L2 is unknown, so the L1 copy lives for the L1 TTL.
+	_ = c.l1.Set(key, value, c.opts.L1TTL)
+	return value, nil
+}
+
+// Set writes the value to L2 and then to L1.
+// If the write to L2 fails, the key is removed from L1 so it cannot serve a
+// value L2 does not have.
+func (c *LayeredCache) Set(key, value []byte, ttl time.Duration) error {
+	if err := c.l2.Set(key, value, ttl); err != nil {
+		_ = c.l1.Delete(key)
+		return err
+	}
+	return c.l1.Set(key, value, c.l1TTL(ttl))
+}
+
+// Touch resets the expiration in L2, and in L1 if it is a Toucher.
+// An error is returned if L2 is not a Toucher.
+func (c *LayeredCache) Touch(key []byte, ttl time.Duration) error {
+	t, ok := c.l2.(Toucher)
+	if !ok {
+		return errors.New("layered: L2 does not support touch")
+	}
+	if err := t.Touch(key, ttl); err != nil {
+		_ = c.l1.Delete(key)
+		return err
+	}
+	if t, ok := c.l1.(Toucher); ok {
+		_ = t.Touch(key, c.l1TTL(ttl))
+	}
+	return nil
+}
+
+// Has checks L1 and then L2 for the key.
+func (c *LayeredCache) Has(key []byte) bool {
+	return c.l1.Has(key) || c.l2.Has(key)
+}
+
+// Delete removes the key from both levels.
+func (c *LayeredCache) Delete(key []byte) error {
+	_ = c.l1.Delete(key)
+	return c.l2.Delete(key)
+}
+
+// Close stops watching L2 for changes. It does not close either level.
+func (c *LayeredCache) Close() error {
+	if c.stop != nil {
+		c.stop()
+	}
+	return nil
+}
+
+// l1TTL returns the TTL of an L1 copy: the entry TTL capped at the L1 TTL.
+func (c *LayeredCache) l1TTL(ttl time.Duration) time.Duration {
+	if c.opts.L1TTL > 0 && (ttl <= 0 || ttl > c.opts.L1TTL) {
+		return c.opts.L1TTL
+	}
+	return ttl
+}
@@ -0,0 +1,94 @@
+package ggcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// watchedCache is a Cache that notifies its watchers of every Set, the way a
+// remote L2 reports writes by other nodes.
+type watchedCache struct {
+	*Cache
+	mu       sync.Mutex
+	watchers []func(key []byte)
+}
+
+func (c *watchedCache) Set(key, value []byte, ttl time.Duration) error {
+	if err := c.Cache.Set(key, value, ttl); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fn := range c.watchers {
+		fn(key)
+	}
+	return nil
+}
+
+func (c *watchedCache) Watch(fn func(key []byte)) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watchers = append(c.watchers, fn)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.watchers = nil
+	}
+}
+
+func TestLayered(t *testing.T) {
+	l1, l2 := New(), New()
+	c := Layered(l1, l2, LayeredOptions{L1TTL: time.Minute})
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.True(t, l1.Has([]byte("foo")))
+	assert.True(t, l2.Has([]byte("foo")))
+
+	// A miss in L1 is populated from L2.
+	assert.Nil(t, l2.Set([]byte("l2"), []byte("only"), 0))
+	value, err := c.Get([]byte("l2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("only"), value)
+	assert.True(t, l1.Has([]byte("l2")))
+
+	assert.Nil(t, c.Touch([]byte("foo"), time.Hour))
+	assert.NotNil(t, c.Touch([]byte("missing"), time.Hour))
+
+	assert.Nil(t, c.Delete([]byte("foo")))
+	assert.False(t, l1.Has([]byte("foo")))
+	assert.False(t, c.Has([]byte("foo")))
+}
+
+func TestLayeredL1TTL(t *testing.T) {
+	l1, l2 := New(), New()
+	c := Layered(l1, l2, LayeredOptions{L1TTL: 20 * time.Millisecond})
+
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	time.Sleep(40 * time.Millisecond)
+
+	// The L1 copy expired, the entry itself did not.
+	assert.False(t, l1.Has([]byte("foo")))
+	assert.True(t, c.Has([]byte("foo")))
+}
+
+func TestLayeredInvalidation(t *testing.T) {
+	l1, l2 := New(), &watchedCache{Cache: New()}
+	c := Layered(l1, l2, LayeredOptions{})
+	defer c.Close()
+
+	assert.Nil(t, l1.Set([]byte("foo"), []byte("stale"), 0))
+
+	// Another writer changes L2, which invalidates the L1 copy.
+	assert.Nil(t, l2.Set([]byte("foo"), []byte("fresh"), 0))
+	assert.False(t, l1.Has([]byte("foo")))
+
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("fresh"), value)
+
+	assert.Nil(t, c.Close())
+	assert.Empty(t, l2.watchers)
+}
@@ -0,0 +1,183 @@
+package ggcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// LoadFunc produces the value for a key that is missing from the cache,
+// typically by querying the system of record.
+type LoadFunc func(ctx context.Context) ([]byte, error)
+
+// FailurePolicy is what a Loader does when its cache fails rather than
+// misses the key, e.g. while a remote cache is unreachable.
+type FailurePolicy int
+
+const (
+	// FailOpen bypasses the cache: the value is loaded as on a miss, and
+	// returned even if it cannot be stored.
+	FailOpen FailurePolicy = iota
+	// FailClosed returns the error of the cache without loading the value,
+	// sparing the system of record the load of every request during a
+	// cache outage.
+	FailClosed
+)
+
+// LoaderOption configures a Loader created with NewLoader.
+type LoaderOption func(*Loader)
+
+// WithFailurePolicy sets what the Loader does when its cache fails, FailOpen
+// by default.
+func WithFailurePolicy(p FailurePolicy) LoaderOption {
+	return func(l *Loader) {
+		l.policy = p
+	}
+}
+
+// WithRefreshAhead makes the Loader reload an entry in the background when
+// it is read with less than the fraction of its TTL left, so hot entries are
+// replaced before they expire instead of missing under load. It only applies
+// to Cachers implementing ExpiryGetter, and to entries loaded with a TTL.
+func WithRefreshAhead(fraction float64) LoaderOption {
+	return func(l *Loader) {
+		l.refreshAhead = fraction
+	}
+}
+
+// Loader adds read-through loading on top of any Cacher.
+// Concurrent misses for the same key are coalesced so that only one of them
+// runs the LoadFunc while the others wait for its result.
+type Loader struct {
+	// cache is the Cacher values are read from and stored into.
+	cache Cacher
+
+	// lock guards calls.
+	lock sync.Mutex
+
+	// calls tracks the loads in flight, keyed by cache key.
+	calls map[string]*call
+
+	// policy is what GetOrLoad does when the cache fails.
+	policy FailurePolicy
+
+	// refreshAhead is the fraction of the TTL left below which a hit is
+	// reloaded in the background, 0 to never reload.
+	refreshAhead float64
+}
+
+// call is a load in flight that other callers can wait on.
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// NewLoader creates a Loader reading through the specified Cacher.
+func NewLoader(c Cacher, opts ...LoaderOption) *Loader {
+	l := &Loader{
+		cache: c,
+		calls: make(map[string]*call),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Cacher returns the Cacher the Loader reads through.
+func (l *Loader) Cacher() Cacher {
+	return l.cache
+}
+
+// GetOrLoad returns the cached value for the key. On a miss it calls load,
+// stores the result with the specified TTL and returns it.
+// Errors returned by load are passed to every waiting caller and are not cached.
+// Errors of the cache other than ErrKeyNotFound are handled according to the
+// FailurePolicy of the Loader.
+// With WithRefreshAhead, a hit that is about to expire is returned while its
+// value is reloaded in the background with the same TTL.
+func (l *Loader) GetOrLoad(ctx context.Context, key []byte, ttl time.Duration, load LoadFunc) ([]byte, error) {
+	value, err := l.cache.Get(key)
+	if err == nil {
+		if l.refreshDue(key, ttl) {
+			l.refresh(ctx, key, ttl, load)
+		}
+		return value, nil
+	}
+	if l.policy == FailClosed && !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+
+	keyStr := string(key)
+
+	l.lock.Lock()
+	if c, ok := l.calls[keyStr]; ok {
+		// Someone else is already loading this key, wait for their result.
+		l.lock.Unlock()
+		select {
+		case <-c.done:
+			return c.value, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c := &call{done: make(chan struct{})}
+	l.calls[keyStr] = c
+	l.lock.Unlock()
+
+	l.load(ctx, key, ttl, load, c)
+	return c.value, c.err
+}
+
+// load runs the LoadFunc of the call, stores its result and releases the
+// callers waiting on it.
+func (l *Loader) load(ctx context.Context, key []byte, ttl time.Duration, load LoadFunc, c *call) {
+	c.value, c.err = load(ctx)
+	if c.err == nil {
+		if err := l.cache.Set(key, c.value, ttl); err != nil && l.policy == FailClosed {
+			c.err = err
+		}
+	}
+
+	l.lock.Lock()
+	delete(l.calls, string(key))
+	l.lock.Unlock()
+	close(c.done)
+}
+
+// refreshDue reports whether the entry of the key has less than the
+// refresh-ahead fraction of its TTL left.
+func (l *Loader) refreshDue(key []byte, ttl time.Duration) bool {
+	if l.refreshAhead <= 0 || ttl <= 0 {
+		return false
+	}
+	eg, ok := l.cache.(ExpiryGetter)
+	if !ok {
+		return false
+	}
+	expiry, err := eg.Expiry(key)
+	if err != nil || expiry.IsZero() {
+		return false
+	}
+	return time.Until(expiry) < time.Duration(l.refreshAhead*float64(ttl))
+}
+
+// refresh reloads the key in the background, unless it is being loaded
+// already. The load outlives the read that triggered it, so it does not
+// inherit its cancellation.
+func (l *Loader) refresh(ctx context.Context, key []byte, ttl time.Duration, load LoadFunc) {
+	keyStr := string(key)
+
+	l.lock.Lock()
+	if _, ok := l.calls[keyStr]; ok {
+		l.lock.Unlock()
+		return
+	}
+	c := &call{done: make(chan struct{})}
+	l.calls[keyStr] = c
+	l.lock.Unlock()
+
+	go l.load(context.WithoutCancel(ctx), []byte(keyStr), ttl, load, c)
+}
@@ -0,0 +1,158 @@
+package ggcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLoader_GetOrLoad tests that misses are loaded once and then served from the cache.
+func TestLoader_GetOrLoad(t *testing.T) {
+	loader := NewLoader(New())
+
+	var loads atomic.Int32
+	load := func(context.Context) ([]byte, error) {
+		loads.Add(1)
+		return []byte("loaded"), nil
+	}
+
+	// Test Case 1: Miss is loaded and stored
+	value, err := loader.GetOrLoad(context.Background(), []byte("key"), 0, load)
+	if err != nil || string(value) != "loaded" {
+		t.Errorf("Expected loaded value, but got %s (%v)", value, err)
+	}
+
+	// Test Case 2: Hit does not load again
+	_, _ = loader.GetOrLoad(context.Background(), []byte("key"), 0, load)
+	if loads.Load() != 1 {
+		t.Errorf("Expected 1 load, but got %d", loads.Load())
+	}
+
+	// Test Case 3: Errors are returned and not cached
+	_, err = loader.GetOrLoad(context.Background(), []byte("other"), 0, func(context.Context) ([]byte, error) {
+		return nil, errors.New("backend down")
+	})
+	if err == nil || loader.Cacher().Has([]byte("other")) {
+		t.Error("Expected the load error to be returned and nothing cached")
+	}
+}
+
+// TestLoader_Coalescing tests that concurrent misses for a key share a single load.
+func TestLoader_Coalescing(t *testing.T) {
+	loader := NewLoader(New())
+
+	var (
+		loads   atomic.Int32
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	load := func(context.Context) ([]byte, error) {
+		loads.Add(1)
+		<-release
+		return []byte("loaded"), nil
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := loader.GetOrLoad(context.Background(), []byte("key"), 0, load)
+			if err != nil || string(value) != "loaded" {
+				t.Errorf("Expected loaded value, but got %s (%v)", value, err)
+			}
+		}()
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	wg.Wait()
+
+	if loads.Load() != 1 {
+		t.Errorf("Expected 1 load, but got %d", loads.Load())
+	}
+}
+
+// unreachableCache is a Cacher whose reads and writes fail, like a remote
+// cache during an outage.
+type unreachableCache struct {
+	Cacher
+}
+
+func (unreachableCache) Get(key []byte) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (unreachableCache) Set(key, value []byte, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+
+// TestLoader_FailurePolicy tests that a failing cache is bypassed or its error returned.
+func TestLoader_FailurePolicy(t *testing.T) {
+	var loads atomic.Int32
+	load := func(context.Context) ([]byte, error) {
+		loads.Add(1)
+		return []byte("loaded"), nil
+	}
+
+	// Test Case 1: Fail open loads the value and returns it though it is not stored
+	loader := NewLoader(unreachableCache{New()})
+	value, err := loader.GetOrLoad(context.Background(), []byte("key"), 0, load)
+	if err != nil || string(value) != "loaded" {
+		t.Errorf("Expected loaded value, but got %s (%v)", value, err)
+	}
+
+	// Test Case 2: Fail closed returns the error without loading
+	loader = NewLoader(unreachableCache{New()}, WithFailurePolicy(FailClosed))
+	if _, err := loader.GetOrLoad(context.Background(), []byte("key"), 0, load); err == nil {
+		t.Error("Expected the cache error to be returned")
+	}
+	if loads.Load() != 1 {
+		t.Errorf("Expected 1 load, but got %d", loads.Load())
+	}
+
+	// Test Case 3: Fail closed still loads misses of a healthy cache
+	loader = NewLoader(New(), WithFailurePolicy(FailClosed))
+	value, err = loader.GetOrLoad(context.Background(), []byte("key"), 0, load)
+	if err != nil || string(value) != "loaded" {
+		t.Errorf("Expected loaded value, but got %s (%v)", value, err)
+	}
+}
+
+// TestLoader_RefreshAhead tests that hits close to their expiry are reloaded in the background.
+func TestLoader_RefreshAhead(t *testing.T) {
+	loader := NewLoader(New(), WithRefreshAhead(0.5))
+
+	var loads atomic.Int32
+	load := func(context.Context) ([]byte, error) {
+		return []byte{byte('0' + loads.Add(1))}, nil
+	}
+	ttl := 200 * time.Millisecond
+
+	// Test Case 1: A fresh hit is not reloaded
+	_, _ = loader.GetOrLoad(context.Background(), []byte("key"), ttl, load)
+	value, _ := loader.GetOrLoad(context.Background(), []byte("key"), ttl, load)
+	if string(value) != "1" || loads.Load() != 1 {
+		t.Errorf("Expected the first value and 1 load, but got %s and %d", value, loads.Load())
+	}
+
+	// Test Case 2: A hit past half its TTL returns the value and reloads it
+	time.Sleep(120 * time.Millisecond)
+	value, _ = loader.GetOrLoad(context.Background(), []byte("key"), ttl, load)
+	if string(value) != "1" {
+		t.Errorf("Expected the current value, but got %s", value)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if loads.Load() != 2 {
+		t.Errorf("Expected 2 loads, but got %d", loads.Load())
+	}
+
+	// Test Case 3: The reloaded entry outlives the original TTL
+	time.Sleep(120 * time.Millisecond)
+	value, err := loader.GetOrLoad(context.Background(), []byte("key"), ttl, load)
+	if err != nil || string(value) != "2" {
+		t.Errorf("Expected the reloaded value, but got %s (%v)", value, err)
+	}
+}
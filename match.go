@@ -0,0 +1,46 @@
+package ggcache
+
+// MatchKey reports whether the key matches the glob pattern, in which '*'
+// matches any run of bytes, ':' included, '?' any single byte, and '\'
+// escapes the byte following it. The rest of the pattern matches itself.
+func MatchKey(pattern, key []byte) bool {
+	// The position of the last '*' and of the key byte it was retried at, so
+	// it can take one more byte on a mismatch.
+	star, retry := -1, 0
+
+	p, k := 0, 0
+	for k < len(key) {
+		if p < len(pattern) {
+			switch c := pattern[p]; {
+			case c == '*':
+				star, retry = p, k
+				p++
+				continue
+			case c == '?':
+				p++
+				k++
+				continue
+			case c == '\\' && p+1 < len(pattern):
+				if pattern[p+1] == key[k] {
+					p += 2
+					k++
+					continue
+				}
+			case c == key[k]:
+				p++
+				k++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		retry++
+		p, k = star+1, retry
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
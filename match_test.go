@@ -0,0 +1,60 @@
+package ggcache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchKey(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "user:1:session", true},
+		{"user:*:session", "user:1:session", true},
+		{"user:*:session", "user:1:2:session", true},
+		{"user:*:session", "user::session", true},
+		{"user:*:session", "user:1:sessions", false},
+		{"user:*:session", "users:1:session", false},
+		{"user:?", "user:1", true},
+		{"user:?", "user:12", false},
+		{"*a*b", "xaxxbxb", true},
+		{"*a*b", "xaxxbx", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\?`, "a?", true},
+		{`a\`, `a\`, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, MatchKey([]byte(tt.pattern), []byte(tt.key)), "%q %q", tt.pattern, tt.key)
+	}
+}
+
+func TestCacheScan(t *testing.T) {
+	cache := New()
+	for i := 0; i < 10; i++ {
+		_ = cache.Set([]byte(fmt.Sprintf("user:%d:session", i)), nil, 0)
+		_ = cache.Set([]byte(fmt.Sprintf("user:%d:profile", i)), nil, 0)
+	}
+
+	var keys []string
+	cache.Scan([]byte("user:*:session"), func(key []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Len(t, keys, 10)
+	assert.Contains(t, keys, "user:3:session")
+
+	n := 0
+	cache.Scan([]byte("*"), func([]byte) bool {
+		n++
+		return n < 5
+	})
+	assert.Equal(t, 5, n)
+}
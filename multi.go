@@ -0,0 +1,38 @@
+package ggcache
+
+import "time"
+
+// Write is a write applied by WriteMulti: a set of the key to the value with
+// the TTL, or its deletion if Delete is set.
+type Write struct {
+	Key    []byte
+	Value  []byte
+	TTL    time.Duration
+	Delete bool
+}
+
+// MultiWriter is implemented by Cachers that can apply several writes at
+// once, so that no reader sees some of them applied and not the others.
+type MultiWriter interface {
+	// WriteMulti applies the writes in order, atomically.
+	WriteMulti(writes []Write) error
+}
+
+// WriteMulti applies the writes in order under a single write lock, so
+// readers see either none or all of them.
+func (c *Cache) WriteMulti(writes []Write) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, w := range writes {
+		if w.Delete {
+			if c.remove(string(w.Key)) {
+				c.stats.deletes.Add(1)
+			}
+			continue
+		}
+		c.store(string(w.Key), w.Value, w.TTL)
+		c.stats.sets.Add(1)
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+package ggcache
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultNamespaceSeparator splits the namespace from the rest of a key if
+// NamespacedOptions.Separator is not set.
+const DefaultNamespaceSeparator = ":"
+
+// ErrNamespaceFull is returned when a value is larger than the MaxBytes of
+// its namespace, so no amount of eviction makes room for it.
+var ErrNamespaceFull = errors.New("value exceeds the max bytes of its namespace")
+
+// EvictionPolicy picks the entries a namespace evicts once it is full.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entries.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used entries, the least recently
+	// used of them first. Frequencies are not aged.
+	EvictLFU
+)
+
+// NamespacePolicy is applied to the keys of a namespace whatever their
+// writers ask for.
+type NamespacePolicy struct {
+	// DefaultTTL is the expiration of the entries set or touched without
+	// one. Zero keeps them until they are evicted or deleted.
+	DefaultTTL time.Duration
+
+	// MinTTL and MaxTTL bound the TTLs the entries are set or touched with,
+	// correcting the writers that would hammer their backend with very short
+	// TTLs or keep entries forever: shorter TTLs are raised to MinTTL, and
+	// longer ones, as well as no TTL at all, are lowered to MaxTTL. Each
+	// correction is counted, see ClampedTTLs. Zero leaves them unbounded.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// MaxBytes bounds the size of the keys and values of the namespace.
+	// Writes over it evict entries according to Eviction. Zero is unbounded.
+	MaxBytes int64
+	Eviction EvictionPolicy
+}
+
+// NamespacedOptions configure a NamespacedCache.
+type NamespacedOptions struct {
+	// Separator splits keys into a namespace and a name at its first
+	// occurrence, e.g. ":" for "sessions:42". Zero uses
+	// DefaultNamespaceSeparator.
+	Separator string
+
+	// Policies are the policies of the namespaces by name. Keys of other
+	// namespaces are passed through unchanged.
+	Policies map[string]NamespacePolicy
+}
+
+// NamespacedCache is a Cacher applying a NamespacePolicy to the keys of each
+// configured namespace, so a cache shared by several applications can give
+// each one its own size, eviction policy and default TTL.
+//
+// Sizes and recency are tracked here, so the wrapped Cacher must only be
+// written through the NamespacedCache. Entries that expire in the wrapped
+// Cacher are only dropped from their namespace size when they are next read
+// or evicted, so a namespace may evict before it is actually full.
+type NamespacedCache struct {
+	// c is the wrapped Cacher holding the entries.
+	c Cacher
+
+	// opts are the options the cache was created with.
+	opts NamespacedOptions
+
+	// namespaces are the tracked namespaces by name, fixed once created.
+	namespaces map[string]*namespace
+
+	// onRemoval is the fn of WatchRemovals.
+	onRemoval atomic.Pointer[func(key []byte, reason RemovalReason)]
+}
+
+// Namespaced creates a NamespacedCache storing its entries in c.
+func Namespaced(c Cacher, opts NamespacedOptions) *NamespacedCache {
+	if len(opts.Separator) == 0 {
+		opts.Separator = DefaultNamespaceSeparator
+	}
+	nc := &NamespacedCache{
+		c:          c,
+		opts:       opts,
+		namespaces: make(map[string]*namespace, len(opts.Policies)),
+	}
+	for name, policy := range opts.Policies {
+		nc.namespaces[name] = &namespace{
+			policy:  policy,
+			entries: make(map[string]*trackedEntry),
+			heap:    evictionHeap{policy: policy.Eviction},
+		}
+	}
+	return nc
+}
+
+// namespace returns the namespace of the key, or nil if it has no policy.
+func (c *NamespacedCache) namespace(key []byte) *namespace {
+	i := bytes.Index(key, []byte(c.opts.Separator))
+	if i <= 0 {
+		return nil
+	}
+	return c.namespaces[string(key[:i])]
+}
+
+// Get returns the value of the key, counting the access for eviction.
+func (c *NamespacedCache) Get(key []byte) ([]byte, error) {
+	ns := c.namespace(key)
+	if ns == nil || ns.policy.MaxBytes <= 0 {
+		return c.c.Get(key)
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	value, err := c.c.Get(key)
+	if err != nil {
+		ns.untrack(string(key))
+		return nil, err
+	}
+	ns.access(string(key))
+	return value, nil
+}
+
+// Set stores the value with the default TTL of its namespace if ttl is zero,
+// and evicts entries of the namespace until it fits in its max bytes.
+func (c *NamespacedCache) Set(key, value []byte, ttl time.Duration) error {
+	ns := c.namespace(key)
+	if ns == nil {
+		return c.c.Set(key, value, ttl)
+	}
+	ttl = ns.ttl(ttl)
+	if ns.policy.MaxBytes <= 0 {
+		return c.c.Set(key, value, ttl)
+	}
+
+	size := int64(len(key) + len(value))
+	if size > ns.policy.MaxBytes {
+		return ErrNamespaceFull
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if err := c.c.Set(key, value, ttl); err != nil {
+		return err
+	}
+	ns.track(string(key), size)
+	c.evict(ns, string(key))
+	return nil
+}
+
+// SetIf stores the value like Set if the condition holds. An error is
+// returned if the wrapped Cacher is not a ConditionalSetter.
+func (c *NamespacedCache) SetIf(key, value []byte, ttl time.Duration, cond Condition) error {
+	setter, ok := c.c.(ConditionalSetter)
+	if !ok {
+		return errors.New("namespaced: the cache does not support conditional set")
+	}
+	ns := c.namespace(key)
+	if ns == nil {
+		return setter.SetIf(key, value, ttl, cond)
+	}
+	ttl = ns.ttl(ttl)
+	if ns.policy.MaxBytes <= 0 {
+		return setter.SetIf(key, value, ttl, cond)
+	}
+
+	size := int64(len(key) + len(value))
+	if size > ns.policy.MaxBytes {
+		return ErrNamespaceFull
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if err := setter.SetIf(key, value, ttl, cond); err != nil {
+		return err
+	}
+	ns.track(string(key), size)
+	c.evict(ns, string(key))
+	return nil
+}
+
+// Append appends to the value of the key and evicts entries of its
+// namespace until it fits in its max bytes. An error is returned if the
+// wrapped Cacher is not an Appender.
+func (c *NamespacedCache) Append(key, data []byte) error {
+	appender, ok := c.c.(Appender)
+	if !ok {
+		return errors.New("namespaced: the cache does not support append")
+	}
+	ns := c.namespace(key)
+	if ns == nil || ns.policy.MaxBytes <= 0 {
+		return appender.Append(key, data)
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	e, tracked := ns.entries[string(key)]
+	if tracked && e.size+int64(len(data)) > ns.policy.MaxBytes {
+		return ErrNamespaceFull
+	}
+	if err := appender.Append(key, data); err != nil {
+		ns.untrack(string(key))
+		return err
+	}
+	if tracked {
+		ns.track(string(key), e.size+int64(len(data)))
+		c.evict(ns, string(key))
+	}
+	return nil
+}
+
+// Touch resets the expiration of the key, to the default TTL of its
+// namespace if ttl is zero. An error is returned if the wrapped Cacher is
+// not a Toucher.
+func (c *NamespacedCache) Touch(key []byte, ttl time.Duration) error {
+	t, ok := c.c.(Toucher)
+	if !ok {
+		return errors.New("namespaced: the cache does not support touch")
+	}
+	ns := c.namespace(key)
+	if ns == nil {
+		return t.Touch(key, ttl)
+	}
+	return t.Touch(key, ns.ttl(ttl))
+}
+
+// Has checks whether the key is present. It does not count as an access.
+func (c *NamespacedCache) Has(key []byte) bool {
+	return c.c.Has(key)
+}
+
+// Delete removes the key.
+func (c *NamespacedCache) Delete(key []byte) error {
+	ns := c.namespace(key)
+	if ns == nil || ns.policy.MaxBytes <= 0 {
+		return c.c.Delete(key)
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.untrack(string(key))
+	return c.c.Delete(key)
+}
+
+// DeleteIf deletes the key like Delete if the condition holds. An error is
+// returned if the wrapped Cacher is not a ConditionalDeleter.
+func (c *NamespacedCache) DeleteIf(key []byte, cond Condition) error {
+	deleter, ok := c.c.(ConditionalDeleter)
+	if !ok {
+		return errors.New("namespaced: the cache does not support conditional delete")
+	}
+	ns := c.namespace(key)
+	if ns == nil || ns.policy.MaxBytes <= 0 {
+		return deleter.DeleteIf(key, cond)
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if err := deleter.DeleteIf(key, cond); err != nil {
+		return err
+	}
+	ns.untrack(string(key))
+	return nil
+}
+
+// Stats returns the stats of the wrapped Cacher, or zero stats if it is not
+// a StatsProvider.
+func (c *NamespacedCache) Stats() Stats {
+	if p, ok := c.c.(StatsProvider); ok {
+		return p.Stats()
+	}
+	return Stats{}
+}
+
+// ClampedTTLs returns the number of writes whose TTL was raised to the
+// MinTTL or lowered to the MaxTTL of their namespace, by namespace, for the
+// namespaces with either.
+func (c *NamespacedCache) ClampedTTLs() map[string]uint64 {
+	clamped := make(map[string]uint64)
+	for name, ns := range c.namespaces {
+		if ns.policy.MinTTL > 0 || ns.policy.MaxTTL > 0 {
+			clamped[name] = ns.clamped.Load()
+		}
+	}
+	return clamped
+}
+
+// WatchRemovals has fn called with the key of every entry evicted to keep
+// its namespace within its max bytes, and passes it on to the wrapped Cacher
+// if it is a RemovalWatcher, which reports the entries that expire.
+func (c *NamespacedCache) WatchRemovals(fn func(key []byte, reason RemovalReason)) {
+	if w, ok := c.c.(RemovalWatcher); ok {
+		w.WatchRemovals(fn)
+	}
+	if fn == nil {
+		c.onRemoval.Store(nil)
+		return
+	}
+	c.onRemoval.Store(&fn)
+}
+
+// evict deletes the entries of the namespace picked by its policy until it
+// fits in its max bytes, sparing keep, the key just written. The caller must
+// hold ns.mu.
+func (c *NamespacedCache) evict(ns *namespace, keep string) {
+	var kept *trackedEntry
+	for ns.bytes > ns.policy.MaxBytes && ns.heap.Len() > 0 {
+		e := heap.Pop(&ns.heap).(*trackedEntry)
+		if e.key == keep {
+			kept = e
+			continue
+		}
+		delete(ns.entries, e.key)
+		ns.bytes -= e.size
+		if err := c.c.Delete([]byte(e.key)); err == nil {
+			if fn := c.onRemoval.Load(); fn != nil {
+				(*fn)([]byte(e.key), RemovalEvicted)
+			}
+		}
+	}
+	if kept != nil {
+		heap.Push(&ns.heap, kept)
+	}
+}
+
+// namespace holds the entries of a namespace with a policy.
+type namespace struct {
+	policy NamespacePolicy
+
+	mu      sync.Mutex
+	entries map[string]*trackedEntry
+	heap    evictionHeap
+	bytes   int64
+
+	// tick orders the accesses for recency.
+	tick uint64
+
+	// clamped counts the TTLs out of the MinTTL and MaxTTL of the policy.
+	clamped atomic.Uint64
+}
+
+// ttl returns the TTL of an entry set or touched with ttl, counting it if
+// it is out of the bounds of the namespace.
+func (ns *namespace) ttl(ttl time.Duration) time.Duration {
+	if ttl <= 0 && ns.policy.DefaultTTL > 0 {
+		ttl = ns.policy.DefaultTTL
+	}
+	switch {
+	case ns.policy.MaxTTL > 0 && (ttl <= 0 || ttl > ns.policy.MaxTTL):
+		ns.clamped.Add(1)
+		return ns.policy.MaxTTL
+	case ns.policy.MinTTL > 0 && ttl > 0 && ttl < ns.policy.MinTTL:
+		ns.clamped.Add(1)
+		return ns.policy.MinTTL
+	}
+	return ttl
+}
+
+// track records the key as written with the given size, counting it as an
+// access. The caller must hold ns.mu.
+func (ns *namespace) track(key string, size int64) {
+	e, ok := ns.entries[key]
+	if !ok {
+		e = &trackedEntry{key: key}
+		ns.entries[key] = e
+		heap.Push(&ns.heap, e)
+	}
+	ns.bytes += size - e.size
+	e.size = size
+	ns.access(key)
+}
+
+// access counts an access to the key. The caller must hold ns.mu.
+func (ns *namespace) access(key string) {
+	e, ok := ns.entries[key]
+	if !ok {
+		return
+	}
+	ns.tick++
+	e.tick = ns.tick
+	e.hits++
+	heap.Fix(&ns.heap, e.index)
+}
+
+// untrack forgets the key. The caller must hold ns.mu.
+func (ns *namespace) untrack(key string) {
+	e, ok := ns.entries[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&ns.heap, e.index)
+	delete(ns.entries, key)
+	ns.bytes -= e.size
+}
+
+// trackedEntry is an entry of a namespace with a max bytes.
+type trackedEntry struct {
+	key  string
+	size int64
+
+	// tick is the last access and hits the number of accesses.
+	tick uint64
+	hits uint64
+
+	// index is the position of the entry in the eviction heap.
+	index int
+}
+
+// evictionHeap orders the entries of a namespace with the next one to evict
+// first.
+type evictionHeap struct {
+	entries []*trackedEntry
+	policy  EvictionPolicy
+}
+
+func (h *evictionHeap) Len() int { return len(h.entries) }
+
+func (h *evictionHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if h.policy == EvictLFU && a.hits != b.hits {
+		return a.hits < b.hits
+	}
+	return a.tick < b.tick
+}
+
+func (h *evictionHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *evictionHeap) Push(x any) {
+	e := x.(*trackedEntry)
+	e.index = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *evictionHeap) Pop() any {
+	n := len(h.entries) - 1
+	e := h.entries[n]
+	h.entries[n] = nil
+	h.entries = h.entries[:n]
+	return e
+}
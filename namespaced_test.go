@@ -0,0 +1,164 @@
+package ggcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ Toucher           = (*NamespacedCache)(nil)
+	_ Appender          = (*NamespacedCache)(nil)
+	_ ConditionalSetter = (*NamespacedCache)(nil)
+	_ StatsProvider     = (*NamespacedCache)(nil)
+	_ RemovalWatcher    = (*NamespacedCache)(nil)
+)
+
+func TestNamespacedLRU(t *testing.T) {
+	// Each entry below is 10 bytes of key and value.
+	c := Namespaced(New(), NamespacedOptions{
+		Policies: map[string]NamespacePolicy{
+			"s": {MaxBytes: 30},
+		},
+	})
+
+	assert.Nil(t, c.Set([]byte("s:1"), []byte("1234567"), 0))
+	assert.Nil(t, c.Set([]byte("s:2"), []byte("1234567"), 0))
+	assert.Nil(t, c.Set([]byte("s:3"), []byte("1234567"), 0))
+	_, err := c.Get([]byte("s:1"))
+	assert.Nil(t, err)
+
+	// s:2 is the least recently used.
+	assert.Nil(t, c.Set([]byte("s:4"), []byte("1234567"), 0))
+	assert.False(t, c.Has([]byte("s:2")))
+	assert.True(t, c.Has([]byte("s:1")))
+
+	// Growing an entry evicts the others, never itself.
+	assert.Nil(t, c.Append([]byte("s:4"), []byte("1234567890")))
+	assert.True(t, c.Has([]byte("s:4")))
+	assert.False(t, c.Has([]byte("s:3")))
+	assert.True(t, c.Has([]byte("s:1")))
+
+	// Deleted entries free their bytes.
+	assert.Nil(t, c.Delete([]byte("s:1")))
+	assert.Nil(t, c.Set([]byte("s:5"), []byte("1234567"), 0))
+	assert.True(t, c.Has([]byte("s:4")))
+
+	assert.Equal(t, ErrNamespaceFull, c.Set([]byte("s:6"), make([]byte, 28), 0))
+
+	// Other keys are not bounded.
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Set([]byte("other:1"), make([]byte, 100), 0))
+	}
+	assert.True(t, c.Has([]byte("other:1")))
+}
+
+func TestNamespacedWatchRemovals(t *testing.T) {
+	c := Namespaced(New(), NamespacedOptions{
+		Policies: map[string]NamespacePolicy{
+			"s": {MaxBytes: 20},
+		},
+	})
+
+	removed := make(chan string, 4)
+	c.WatchRemovals(func(key []byte, reason RemovalReason) {
+		removed <- reason.String() + " " + string(key)
+	})
+
+	assert.Nil(t, c.Set([]byte("s:1"), []byte("1234567"), 0))
+	assert.Nil(t, c.Set([]byte("s:2"), []byte("1234567"), 0))
+	assert.Nil(t, c.Set([]byte("s:3"), []byte("1234567"), 0))
+	assert.Equal(t, "evicted s:1", <-removed)
+
+	// The expirations are reported by the wrapped Cache.
+	assert.Nil(t, c.Set([]byte("t:1"), []byte("1"), time.Millisecond))
+	assert.Equal(t, "expired t:1", <-removed)
+}
+
+func TestNamespacedLFU(t *testing.T) {
+	c := Namespaced(New(), NamespacedOptions{
+		Separator: "/",
+		Policies: map[string]NamespacePolicy{
+			"catalog": {MaxBytes: 40, Eviction: EvictLFU},
+		},
+	})
+
+	for _, key := range []string{"catalog/1", "catalog/2", "catalog/3", "catalog/4"} {
+		assert.Nil(t, c.Set([]byte(key), []byte("0"), 0))
+	}
+	for i := 0; i < 3; i++ {
+		_, _ = c.Get([]byte("catalog/1"))
+		_, _ = c.Get([]byte("catalog/3"))
+		_, _ = c.Get([]byte("catalog/4"))
+	}
+	_, _ = c.Get([]byte("catalog/2"))
+
+	// catalog/2 is the least frequently used although it was used last.
+	assert.Nil(t, c.Set([]byte("catalog/5"), []byte("0"), 0))
+	assert.False(t, c.Has([]byte("catalog/2")))
+	assert.True(t, c.Has([]byte("catalog/5")))
+
+	// A new entry is the next to go unless it is read.
+	assert.Nil(t, c.Set([]byte("catalog/6"), []byte("0"), 0))
+	assert.False(t, c.Has([]byte("catalog/5")))
+	assert.True(t, c.Has([]byte("catalog/6")))
+}
+
+func TestNamespacedDefaultTTL(t *testing.T) {
+	c := Namespaced(New(), NamespacedOptions{
+		Policies: map[string]NamespacePolicy{
+			"sessions": {DefaultTTL: 20 * time.Millisecond},
+		},
+	})
+
+	assert.Nil(t, c.Set([]byte("sessions:1"), []byte("a"), 0))
+	assert.Nil(t, c.Set([]byte("sessions:2"), []byte("a"), time.Hour))
+	assert.Nil(t, c.Set([]byte("users:1"), []byte("a"), 0))
+	time.Sleep(30 * time.Millisecond)
+
+	assert.False(t, c.Has([]byte("sessions:1")))
+	assert.True(t, c.Has([]byte("sessions:2")))
+	assert.True(t, c.Has([]byte("users:1")))
+}
+
+func TestNamespacedTTLBounds(t *testing.T) {
+	cache := New()
+	c := Namespaced(cache, NamespacedOptions{
+		Policies: map[string]NamespacePolicy{
+			"pages":    {MinTTL: time.Minute, MaxTTL: time.Hour},
+			"sessions": {DefaultTTL: 10 * time.Minute, MaxTTL: time.Hour},
+			"users":    {MaxBytes: 1 << 20},
+		},
+	})
+
+	expiresIn := func(key string) time.Duration {
+		at, err := cache.Expiry([]byte(key))
+		assert.Nil(t, err)
+		if at.IsZero() {
+			return 0
+		}
+		return time.Until(at).Round(time.Minute)
+	}
+
+	// Too short, too long and no TTL at all are clamped, the others kept.
+	assert.Nil(t, c.Set([]byte("pages:1"), []byte("a"), time.Second))
+	assert.Nil(t, c.Set([]byte("pages:2"), []byte("a"), 24*time.Hour))
+	assert.Nil(t, c.Set([]byte("pages:3"), []byte("a"), 0))
+	assert.Nil(t, c.Set([]byte("pages:4"), []byte("a"), 5*time.Minute))
+	assert.Equal(t, time.Minute, expiresIn("pages:1"))
+	assert.Equal(t, time.Hour, expiresIn("pages:2"))
+	assert.Equal(t, time.Hour, expiresIn("pages:3"))
+	assert.Equal(t, 5*time.Minute, expiresIn("pages:4"))
+
+	// The default TTL is not a correction, and touches are clamped too.
+	assert.Nil(t, c.Set([]byte("sessions:1"), []byte("a"), 0))
+	assert.Equal(t, 10*time.Minute, expiresIn("sessions:1"))
+	assert.Nil(t, c.Touch([]byte("sessions:1"), 2*time.Hour))
+	assert.Equal(t, time.Hour, expiresIn("sessions:1"))
+
+	assert.Nil(t, c.Set([]byte("users:1"), []byte("a"), 0))
+	assert.Equal(t, time.Duration(0), expiresIn("users:1"))
+
+	assert.Equal(t, map[string]uint64{"pages": 3, "sessions": 1}, c.ClampedTTLs())
+}
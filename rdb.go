@@ -0,0 +1,402 @@
+package ggcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// ErrInvalidRDB is returned when reading data that is not an RDB file, has
+// been corrupted, or holds values other than strings.
+var ErrInvalidRDB = errors.New("invalid rdb")
+
+// rdbVersion is the RDB version written by WriteRDB, that of Redis 5, which
+// every RDB tool reads.
+const rdbVersion = 9
+
+// The opcodes of an RDB file, and the value type of a string.
+const (
+	rdbTypeString     = 0x00
+	rdbOpSlotInfo     = 0xf4
+	rdbOpFunction     = 0xf5
+	rdbOpIdle         = 0xf8
+	rdbOpFreq         = 0xf9
+	rdbOpAux          = 0xfa
+	rdbOpResizeDB     = 0xfb
+	rdbOpExpireTimeMs = 0xfc
+	rdbOpExpireTime   = 0xfd
+	rdbOpSelectDB     = 0xfe
+	rdbOpEOF          = 0xff
+)
+
+// The kinds of length, given by the top two bits of its first byte, the
+// lengths of 32 and 64 bits taking the whole byte, and the special
+// encodings of a string.
+const (
+	rdbLen6       = 0
+	rdbLen14      = 1
+	rdbLenEncoded = 3
+	rdbLen32      = 0x80
+	rdbLen64      = 0x81
+	rdbEncInt8    = 0
+	rdbEncInt16   = 1
+	rdbEncInt32   = 2
+	rdbEncLZF     = 3
+)
+
+// rdbChecksumVersion is the first RDB version ending with a checksum.
+const rdbChecksumVersion = 5
+
+// maxLZFPrealloc bounds the buffer allocated up front for a compressed
+// string, so a corrupt length cannot force a huge allocation.
+const maxLZFPrealloc = 1 << 20
+
+// rdbTable is the CRC-64/Jones table of the RDB checksum.
+var rdbTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// rdbCRC is the RDB checksum of the bytes written to it, which unlike
+// crc64.New neither inverts the register before nor after.
+type rdbCRC struct {
+	sum uint64
+}
+
+func (c *rdbCRC) Write(p []byte) (int, error) {
+	c.sum = ^crc64.Update(^c.sum, rdbTable, p)
+	return len(p), nil
+}
+
+// WriteRDB writes the records to w as an RDB file of database 0, as written
+// by Redis, so its tools can inspect the data of a cache, e.g. the records
+// of a snapshot read by ReadSnapshot. Each record is a string key with a
+// string value, expiring at its expiration rounded up to the millisecond.
+// The deleted records are skipped.
+//
+// The keys are written in order, without compression or timestamps, so the
+// same records always give the same file.
+func WriteRDB(w io.Writer, recs []*Record) error {
+	live := make([]*Record, 0, len(recs))
+	expires := 0
+	for _, rec := range recs {
+		if rec.Flags&RecordDeleted != 0 {
+			continue
+		}
+		live = append(live, rec)
+		if rec.ExpiresAt != 0 {
+			expires++
+		}
+	}
+	slices.SortFunc(live, func(a, b *Record) int { return bytes.Compare(a.Key, b.Key) })
+
+	crc := &rdbCRC{}
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	fmt.Fprintf(bw, "REDIS%04d", rdbVersion)
+	b := []byte{rdbOpSelectDB}
+	b = appendRDBLength(b, 0)
+	b = append(b, rdbOpResizeDB)
+	b = appendRDBLength(b, uint64(len(live)))
+	b = appendRDBLength(b, uint64(expires))
+	bw.Write(b)
+
+	for _, rec := range live {
+		b = b[:0]
+		if rec.ExpiresAt != 0 {
+			ms := (rec.ExpiresAt + 999_999) / 1_000_000
+			b = append(b, rdbOpExpireTimeMs)
+			b = binary.LittleEndian.AppendUint64(b, uint64(ms))
+		}
+		b = append(b, rdbTypeString)
+		b = appendRDBString(b, rec.Key)
+		b = appendRDBString(b, rec.Value)
+		bw.Write(b)
+	}
+	bw.WriteByte(rdbOpEOF)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, crc.sum)
+}
+
+func appendRDBLength(b []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(b, byte(n))
+	case n < 1<<14:
+		return append(b, byte(rdbLen14<<6|n>>8), byte(n))
+	case n <= 0xffffffff:
+		b = append(b, rdbLen32)
+		return binary.BigEndian.AppendUint32(b, uint32(n))
+	default:
+		b = append(b, rdbLen64)
+		return binary.BigEndian.AppendUint64(b, n)
+	}
+}
+
+func appendRDBString(b, s []byte) []byte {
+	b = appendRDBLength(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// ReadRDB reads an RDB file written by Redis or WriteRDB and returns the
+// records of its keys, those of every database, with their expiration. It
+// reads the string values only, in any of their encodings, and returns
+// ErrInvalidRDB for a file holding other types. The checksum, if the file
+// has one, is verified.
+func ReadRDB(r io.Reader) ([]*Record, error) {
+	rr := &rdbReader{r: bufio.NewReader(r)}
+
+	header := rr.fixed(9)
+	if rr.err != nil {
+		return nil, rr.error()
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidRDB)
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 {
+		return nil, fmt.Errorf("%w: bad version %q", ErrInvalidRDB, header[5:])
+	}
+
+	var (
+		recs      []*Record
+		expiresAt int64
+	)
+	for {
+		op := rr.byte()
+		if rr.err != nil {
+			return nil, rr.error()
+		}
+
+		switch op {
+		case rdbOpEOF:
+			if version < rdbChecksumVersion {
+				return recs, nil
+			}
+			var want uint64
+			if err := binary.Read(rr.r, binary.LittleEndian, &want); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidRDB, err)
+			}
+			// Redis writes a zero checksum when it is disabled.
+			if want != 0 && want != rr.crc.sum {
+				return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidRDB)
+			}
+			return recs, nil
+		case rdbOpSelectDB:
+			rr.length()
+		case rdbOpResizeDB:
+			rr.length()
+			rr.length()
+		case rdbOpSlotInfo:
+			rr.length()
+			rr.length()
+			rr.length()
+		case rdbOpAux:
+			rr.string()
+			rr.string()
+		case rdbOpFunction:
+			rr.string()
+		case rdbOpIdle:
+			rr.length()
+		case rdbOpFreq:
+			rr.byte()
+		case rdbOpExpireTimeMs:
+			ms := int64(binary.LittleEndian.Uint64(rr.fixed(8)))
+			expiresAt = ms * 1_000_000
+		case rdbOpExpireTime:
+			s := int64(binary.LittleEndian.Uint32(rr.fixed(4)))
+			expiresAt = s * 1_000_000_000
+		case rdbTypeString:
+			key := rr.string()
+			value := rr.string()
+			if rr.err == nil {
+				recs = append(recs, &Record{Key: key, Value: value, ExpiresAt: expiresAt})
+			}
+			expiresAt = 0
+		default:
+			key := rr.string()
+			if rr.err != nil {
+				return nil, rr.error()
+			}
+			return nil, fmt.Errorf("%w: value type %d of key [%s] is not a string", ErrInvalidRDB, op, key)
+		}
+		if rr.err != nil {
+			return nil, rr.error()
+		}
+	}
+}
+
+// rdbReader decodes the fields of an RDB file, keeping the first error,
+// and sums the bytes it decoded.
+type rdbReader struct {
+	r   *bufio.Reader
+	crc rdbCRC
+	err error
+}
+
+func (rr *rdbReader) error() error {
+	if errors.Is(rr.err, ErrInvalidRDB) {
+		return rr.err
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidRDB, rr.err)
+}
+
+// read reads n bytes, in bounded chunks so a corrupt length cannot force a
+// huge allocation.
+func (rr *rdbReader) read(n uint64) []byte {
+	if rr.err != nil {
+		return nil
+	}
+	b, err := io.ReadAll(io.LimitReader(rr.r, int64(n)))
+	if err == nil && uint64(len(b)) != n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		rr.err = err
+		return nil
+	}
+	rr.crc.Write(b)
+	return b
+}
+
+// fixed reads a field of n bytes, zeroed if it cannot be read.
+func (rr *rdbReader) fixed(n int) []byte {
+	if b := rr.read(uint64(n)); b != nil {
+		return b
+	}
+	return make([]byte, n)
+}
+
+func (rr *rdbReader) byte() byte {
+	if rr.err != nil {
+		return 0
+	}
+	b, err := rr.r.ReadByte()
+	if err != nil {
+		rr.err = err
+		return 0
+	}
+	rr.crc.Write([]byte{b})
+	return b
+}
+
+// lengthOrEncoding reads a length, or the special encoding of a string,
+// which encoded reports.
+func (rr *rdbReader) lengthOrEncoding() (n uint64, encoded bool) {
+	b := rr.byte()
+	switch b >> 6 {
+	case rdbLen6:
+		return uint64(b & 0x3f), false
+	case rdbLen14:
+		return uint64(b&0x3f)<<8 | uint64(rr.byte()), false
+	case rdbLenEncoded:
+		return uint64(b & 0x3f), true
+	}
+	switch b {
+	case rdbLen32:
+		return uint64(binary.BigEndian.Uint32(rr.fixed(4))), false
+	case rdbLen64:
+		return binary.BigEndian.Uint64(rr.fixed(8)), false
+	}
+	if rr.err == nil {
+		rr.err = fmt.Errorf("%w: bad length encoding 0x%x", ErrInvalidRDB, b)
+	}
+	return 0, false
+}
+
+func (rr *rdbReader) length() uint64 {
+	n, encoded := rr.lengthOrEncoding()
+	if encoded && rr.err == nil {
+		rr.err = fmt.Errorf("%w: encoded string in place of a length", ErrInvalidRDB)
+	}
+	return n
+}
+
+// string reads a string in any of its encodings.
+func (rr *rdbReader) string() []byte {
+	n, encoded := rr.lengthOrEncoding()
+	if !encoded {
+		return rr.read(n)
+	}
+
+	switch n {
+	case rdbEncInt8:
+		return strconv.AppendInt(nil, int64(int8(rr.byte())), 10)
+	case rdbEncInt16:
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(rr.fixed(2)))), 10)
+	case rdbEncInt32:
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(rr.fixed(4)))), 10)
+	case rdbEncLZF:
+		clen := rr.length()
+		ulen := rr.length()
+		data := rr.read(clen)
+		if rr.err != nil {
+			return nil
+		}
+		b, err := lzfDecompress(data, ulen)
+		if err != nil {
+			rr.err = err
+		}
+		return b
+	}
+	if rr.err == nil {
+		rr.err = fmt.Errorf("%w: bad string encoding %d", ErrInvalidRDB, n)
+	}
+	return nil
+}
+
+// lzfDecompress expands the LZF data Redis compresses long strings with to
+// its size n.
+func lzfDecompress(in []byte, n uint64) ([]byte, error) {
+	out := make([]byte, 0, min(n, maxLZFPrealloc))
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		// A control byte under 32 is followed by a run of ctrl+1 literal
+		// bytes, others are a back reference to copy.
+		if ctrl < 32 {
+			run := ctrl + 1
+			if i+run > len(in) {
+				return nil, fmt.Errorf("%w: lzf literal past the end of the data", ErrInvalidRDB)
+			}
+			out = append(out, in[i:i+run]...)
+			i += run
+		} else {
+			run := ctrl >> 5
+			if run == 7 {
+				if i >= len(in) {
+					return nil, fmt.Errorf("%w: truncated lzf reference", ErrInvalidRDB)
+				}
+				run += int(in[i])
+				i++
+			}
+			run += 2
+			if i >= len(in) {
+				return nil, fmt.Errorf("%w: truncated lzf reference", ErrInvalidRDB)
+			}
+			ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+			i++
+			if ref < 0 {
+				return nil, fmt.Errorf("%w: lzf reference before the start of the data", ErrInvalidRDB)
+			}
+			// The reference may overlap the bytes it copies.
+			for j := 0; j < run; j++ {
+				out = append(out, out[ref+j])
+			}
+		}
+		if uint64(len(out)) > n {
+			return nil, fmt.Errorf("%w: lzf data longer than %d bytes", ErrInvalidRDB, n)
+		}
+	}
+	if uint64(len(out)) != n {
+		return nil, fmt.Errorf("%w: lzf data of %d bytes instead of %d", ErrInvalidRDB, len(out), n)
+	}
+	return out, nil
+}
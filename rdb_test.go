@@ -0,0 +1,106 @@
+package ggcache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRDBChecksum(t *testing.T) {
+	// The check value of CRC-64/Jones as used by Redis.
+	crc := &rdbCRC{}
+	crc.Write([]byte("123456789"))
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc.sum)
+}
+
+func TestRDB(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond).UnixNano()
+	recs := []*Record{
+		{Key: []byte("foo"), Value: []byte("bar")},
+		{Key: []byte("big"), Value: []byte(strings.Repeat("x", 20000))},
+		{Key: []byte("ttl"), Value: []byte("baz"), ExpiresAt: expiresAt},
+		{Key: []byte("gone"), Flags: RecordDeleted},
+		{Key: []byte("empty"), Value: []byte{}},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteRDB(&buf, recs))
+	assert.Equal(t, "REDIS0009", buf.String()[:9])
+
+	// The file does not depend on the order of the records.
+	var reversed bytes.Buffer
+	assert.Nil(t, WriteRDB(&reversed, []*Record{recs[4], recs[3], recs[2], recs[1], recs[0]}))
+	assert.Equal(t, buf.Bytes(), reversed.Bytes())
+
+	got, err := ReadRDB(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, []*Record{recs[1], recs[4], recs[0], recs[2]}, got)
+
+	// The records restore into a cache through a snapshot.
+	var snap bytes.Buffer
+	assert.Nil(t, WriteSnapshot(&snap, got))
+	c := New()
+	assert.Nil(t, c.Restore(&snap))
+	assert.Equal(t, 4, c.Stats().Keys)
+	expiry, err := c.Expiry([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.Equal(t, expiresAt, expiry.UnixNano())
+
+	corrupt := append([]byte(nil), buf.Bytes()...)
+	corrupt[20] ^= 0xff
+	_, err = ReadRDB(bytes.NewReader(corrupt))
+	assert.ErrorIs(t, err, ErrInvalidRDB)
+
+	_, err = ReadRDB(bytes.NewReader(buf.Bytes()[:buf.Len()-12]))
+	assert.ErrorIs(t, err, ErrInvalidRDB)
+}
+
+func TestReadRDBRedis(t *testing.T) {
+	// A dump as Redis writes it, with aux fields, a disabled checksum and
+	// the integer and compressed encodings of strings.
+	b := []byte("REDIS0011")
+	b = append(b, rdbOpAux)
+	b = appendRDBString(b, []byte("redis-ver"))
+	b = appendRDBString(b, []byte("7.2.4"))
+	b = append(b, rdbOpAux)
+	b = appendRDBString(b, []byte("ctime"))
+	b = append(b, 0xc2, 0x10, 0x32, 0x54, 0x66)
+	b = append(b, rdbOpSelectDB, 0x00, rdbOpResizeDB, 0x04, 0x02)
+	// "n" holds -2 as an int8.
+	b = append(b, rdbTypeString)
+	b = appendRDBString(b, []byte("n"))
+	b = append(b, 0xc0, 0xfe)
+	// "i16" holds 1000 as an int16, expiring in seconds.
+	b = append(b, rdbOpExpireTime, 0x00, 0x5e, 0xd0, 0xb2, rdbTypeString)
+	b = appendRDBString(b, []byte("i16"))
+	b = append(b, 0xc1, 0xe8, 0x03)
+	// "lzf" holds 24 "a" compressed as a literal and a back reference.
+	b = append(b, rdbOpFreq, 0x05, rdbTypeString)
+	b = appendRDBString(b, []byte("lzf"))
+	b = append(b, 0xc3, 0x05, 0x18, 0x00, 'a', 0xe0, 0x0e, 0x00)
+	// "ms" expires in milliseconds.
+	b = append(b, rdbOpExpireTimeMs, 0xe8, 0x03, 0, 0, 0, 0, 0, 0, rdbTypeString)
+	b = appendRDBString(b, []byte("ms"))
+	b = appendRDBString(b, []byte("v"))
+	b = append(b, rdbOpEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	recs, err := ReadRDB(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, []*Record{
+		{Key: []byte("n"), Value: []byte("-2")},
+		{Key: []byte("i16"), Value: []byte("1000"), ExpiresAt: 3000000000 * int64(time.Second)},
+		{Key: []byte("lzf"), Value: []byte(strings.Repeat("a", 24))},
+		{Key: []byte("ms"), Value: []byte("v"), ExpiresAt: int64(time.Second)},
+	}, recs)
+
+	// A list is not read.
+	b = []byte("REDIS0009")
+	b = append(b, rdbOpSelectDB, 0x00, 0x01)
+	b = appendRDBString(b, []byte("list"))
+	_, err = ReadRDB(bytes.NewReader(b))
+	assert.ErrorIs(t, err, ErrInvalidRDB)
+	assert.ErrorContains(t, err, "list")
+}
@@ -0,0 +1,200 @@
+package ggcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// recordVersion is the version of the record header written by AppendBytes.
+const recordVersion byte = 1
+
+// recordHeaderSize is the size of the version 1 header: the flags, the
+// expiration and the entry version. Later versions only append fields, so
+// it is also the smallest header a reader accepts.
+const recordHeaderSize = 2 + 8 + 8
+
+// maxRecordHeader bounds the header length read from a record.
+const maxRecordHeader = 4 << 10
+
+// ErrInvalidRecord is returned when reading data that is not a record or has
+// been corrupted.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// RecordFlags describe a Record. Flags below 1<<8 are hints a reader may
+// ignore if it does not know them; flags from 1<<8 up change the meaning of
+// the record, and a reader rejects records with ones it does not know.
+type RecordFlags uint16
+
+const (
+	// RecordDeleted marks the key as deleted; the record has no value.
+	RecordDeleted RecordFlags = 1 << 8
+
+	// knownRecordFlags are the flags this version understands.
+	knownRecordFlags = RecordDeleted
+)
+
+// Record is a cache entry in the versioned, self-describing format shared by
+// snapshots, bulk imports and exports, and replication, so that any of them
+// can read what another wrote, including an older or newer release.
+//
+// A record is encoded as a format version byte, the length of the header as
+// a uvarint, the header (flags as a uint16, the expiration and the entry
+// version as 64-bit integers, little endian), the key and the value each
+// prefixed with its uvarint length, and a CRC32 of everything before it.
+// Newer versions may append fields to the header, which older readers skip.
+type Record struct {
+	Key   []byte
+	Value []byte
+
+	// ExpiresAt is the expiration in unix nanoseconds, zero if the entry does
+	// not expire.
+	ExpiresAt int64
+
+	// Version orders the writes of a key, zero if the writer does not track
+	// it.
+	Version uint64
+
+	Flags RecordFlags
+}
+
+// TTL returns the time left until the record expires relative to now, zero
+// if it does not expire and a negative duration if it has expired.
+func (r *Record) TTL(now time.Time) time.Duration {
+	if r.ExpiresAt == 0 {
+		return 0
+	}
+	if ttl := time.Unix(0, r.ExpiresAt).Sub(now); ttl > 0 {
+		return ttl
+	}
+	return -1
+}
+
+// Bytes returns the encoding of the record.
+func (r *Record) Bytes() []byte {
+	return r.AppendBytes(nil)
+}
+
+// AppendBytes appends the encoding of the record to b.
+func (r *Record) AppendBytes(b []byte) []byte {
+	start := len(b)
+	b = append(b, recordVersion)
+	b = binary.AppendUvarint(b, recordHeaderSize)
+	b = binary.LittleEndian.AppendUint16(b, uint16(r.Flags))
+	b = binary.LittleEndian.AppendUint64(b, uint64(r.ExpiresAt))
+	b = binary.LittleEndian.AppendUint64(b, r.Version)
+	b = binary.AppendUvarint(b, uint64(len(r.Key)))
+	b = append(b, r.Key...)
+	b = binary.AppendUvarint(b, uint64(len(r.Value)))
+	b = append(b, r.Value...)
+	return binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b[start:]))
+}
+
+// WriteRecord writes the encoding of the record to w.
+func WriteRecord(w io.Writer, r *Record) error {
+	_, err := w.Write(r.AppendBytes(nil))
+	return err
+}
+
+// ReadRecord reads a record from r and verifies its checksum. It returns
+// io.EOF if r ends before the record starts. Reading from a buffered reader
+// is recommended, as the lengths are read a byte at a time.
+func ReadRecord(r io.Reader) (*Record, error) {
+	rr := &recordReader{r: r, crc: crc32.NewIEEE()}
+
+	if _, err := rr.ReadByte(); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	// The version is not checked: newer versions only extend the header.
+
+	n, err := binary.ReadUvarint(rr)
+	if err != nil {
+		return nil, invalidRecord(err)
+	}
+	if n < recordHeaderSize || n > maxRecordHeader {
+		return nil, fmt.Errorf("%w: header of %d bytes", ErrInvalidRecord, n)
+	}
+	header := make([]byte, n)
+	if _, err := io.ReadFull(rr, header); err != nil {
+		return nil, invalidRecord(err)
+	}
+
+	rec := &Record{
+		Flags:     RecordFlags(binary.LittleEndian.Uint16(header)),
+		ExpiresAt: int64(binary.LittleEndian.Uint64(header[2:])),
+		Version:   binary.LittleEndian.Uint64(header[10:]),
+	}
+	if unknown := rec.Flags &^ knownRecordFlags; unknown >= 1<<8 {
+		return nil, fmt.Errorf("%w: unsupported flags %#x", ErrInvalidRecord, uint16(unknown))
+	}
+
+	if rec.Key, err = readRecordField(rr); err != nil {
+		return nil, err
+	}
+	if rec.Value, err = readRecordField(rr); err != nil {
+		return nil, err
+	}
+
+	sum := rr.crc.Sum32()
+	var want uint32
+	if err := binary.Read(r, binary.LittleEndian, &want); err != nil {
+		return nil, invalidRecord(err)
+	}
+	if sum != want {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidRecord)
+	}
+	return rec, nil
+}
+
+// recordReader reads from r, one byte at a time where needed, and keeps the
+// checksum of everything read.
+type recordReader struct {
+	r   io.Reader
+	crc hash.Hash32
+	buf [1]byte
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc.Write(p[:n])
+	return n, err
+}
+
+func (r *recordReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(r, r.buf[:]); err != nil {
+		return 0, err
+	}
+	return r.buf[0], nil
+}
+
+func readRecordField(r *recordReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, invalidRecord(err)
+	}
+	// Read in bounded chunks so a corrupt length cannot force a huge allocation.
+	b, err := io.ReadAll(io.LimitReader(r, int64(min(n, 1<<62))))
+	if err != nil {
+		return nil, invalidRecord(err)
+	}
+	if uint64(len(b)) != n {
+		return nil, invalidRecord(io.ErrUnexpectedEOF)
+	}
+	return b, nil
+}
+
+// invalidRecord wraps an error reading a record, reporting a truncated
+// record as io.ErrUnexpectedEOF.
+func invalidRecord(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+}
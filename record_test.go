@@ -0,0 +1,82 @@
+package ggcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	records := []*Record{
+		{Key: []byte("foo"), Value: []byte("bar"), ExpiresAt: time.Now().UnixNano(), Version: 7},
+		{Key: []byte("empty"), Value: []byte{}},
+		{Key: []byte("gone"), Value: []byte{}, Flags: RecordDeleted},
+	}
+
+	buf := new(bytes.Buffer)
+	for _, rec := range records {
+		assert.Nil(t, WriteRecord(buf, rec))
+	}
+
+	r := bufio.NewReader(buf)
+	for _, want := range records {
+		rec, err := ReadRecord(r)
+		assert.Nil(t, err)
+		assert.Equal(t, want, rec)
+	}
+	_, err := ReadRecord(r)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRecordCorrupt(t *testing.T) {
+	b := (&Record{Key: []byte("foo"), Value: []byte("bar")}).Bytes()
+
+	corrupt := append([]byte(nil), b...)
+	corrupt[len(corrupt)-6] ^= 0xff
+
+	for _, b := range [][]byte{b[:1], b[:len(b)-1], corrupt} {
+		_, err := ReadRecord(bytes.NewReader(b))
+		assert.True(t, errors.Is(err, ErrInvalidRecord), "%v", err)
+	}
+}
+
+// futureRecord encodes a record the way a later version could: with a
+// longer header and the given flags.
+func futureRecord(flags RecordFlags) []byte {
+	b := []byte{recordVersion + 1}
+	b = binary.AppendUvarint(b, recordHeaderSize+4)
+	b = binary.LittleEndian.AppendUint16(b, uint16(flags))
+	b = binary.LittleEndian.AppendUint64(b, 0)
+	b = binary.LittleEndian.AppendUint64(b, 3)
+	b = append(b, "next"...)
+	b = binary.AppendUvarint(b, 3)
+	b = append(b, "foo"...)
+	b = binary.AppendUvarint(b, 3)
+	b = append(b, "bar"...)
+	return binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+func TestRecordForwardCompatible(t *testing.T) {
+	// Unknown header fields and hint flags are skipped.
+	rec, err := ReadRecord(bytes.NewReader(futureRecord(1 << 3)))
+	assert.Nil(t, err)
+	assert.Equal(t, &Record{Key: []byte("foo"), Value: []byte("bar"), Version: 3, Flags: 1 << 3}, rec)
+
+	// Unknown flags that change the meaning of the record are not.
+	_, err = ReadRecord(bytes.NewReader(futureRecord(1 << 12)))
+	assert.True(t, errors.Is(err, ErrInvalidRecord), "%v", err)
+}
+
+func TestRecordTTL(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), (&Record{}).TTL(now))
+	assert.Equal(t, time.Minute, (&Record{ExpiresAt: now.Add(time.Minute).UnixNano()}).TTL(now))
+	assert.Less(t, (&Record{ExpiresAt: now.Add(-time.Minute).UnixNano()}).TTL(now), time.Duration(0))
+}
@@ -0,0 +1,51 @@
+package ggcache
+
+// RemovalReason says why a Cacher removed an entry on its own.
+type RemovalReason int
+
+const (
+	// RemovalExpired is the removal of an entry whose TTL ran out.
+	RemovalExpired RemovalReason = iota
+	// RemovalEvicted is the removal of an entry that was idle for too long
+	// or made room for others.
+	RemovalEvicted
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case RemovalExpired:
+		return "expired"
+	case RemovalEvicted:
+		return "evicted"
+	default:
+		return "unknown"
+	}
+}
+
+// RemovalWatcher is implemented by Cachers that can report the entries they
+// remove on their own, so applications can react to the end of the life of
+// an entry without polling for it.
+type RemovalWatcher interface {
+	// WatchRemovals has fn called with the key of every entry expiring or evicted from then on, in place of the previous fn.
+	// A nil fn stops the calls. fn must not call into the cache, and should return quickly as it may hold its lock.
+	WatchRemovals(fn func(key []byte, reason RemovalReason))
+}
+
+// WatchRemovals has fn called with the key of every entry removed by its
+// expiration timer, a sweep of the expiry heap, a read finding it expired or
+// the eviction of idle entries. Expired entries that are replaced or deleted
+// before they are removed are not reported.
+func (c *Cache) WatchRemovals(fn func(key []byte, reason RemovalReason)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.onRemoval = fn
+}
+
+// removed reports the removal of the key to the RemovalWatcher fn, if any.
+// The caller must hold the write lock.
+func (c *Cache) removed(key string, reason RemovalReason) {
+	if c.onRemoval != nil {
+		c.onRemoval([]byte(key), reason)
+	}
+}
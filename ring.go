@@ -0,0 +1,79 @@
+package ggcache
+
+import (
+	"bytes"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// HashRing assigns keys to nodes by consistent hashing, so that adding or
+// removing a node only moves the keys of that node. It is the default Router.
+// A HashRing is not safe for concurrent modification; build a new one when
+// the set of nodes changes.
+type HashRing struct {
+	// replicas is the number of points each node has on the ring.
+	replicas int
+
+	// hashes are the sorted points on the ring.
+	hashes []uint32
+
+	// nodes maps every point to the node it belongs to.
+	nodes map[uint32]string
+}
+
+// NewHashRing creates an empty ring placing each node at the specified
+// number of points. More points spread the keys more evenly; zero uses 50.
+func NewHashRing(replicas int) *HashRing {
+	if replicas <= 0 {
+		replicas = 50
+	}
+	return &HashRing{
+		replicas: replicas,
+		nodes:    make(map[uint32]string),
+	}
+}
+
+// Add places the nodes on the ring.
+func (r *HashRing) Add(nodes ...string) {
+	for _, node := range nodes {
+		for i := 0; i < r.replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + node))
+			r.hashes = append(r.hashes, h)
+			r.nodes[h] = node
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Get returns the node owning the key, or an empty string if the ring is empty.
+// Keys with the same HashTag are owned by the same node.
+func (r *HashRing) Get(key []byte) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := crc32.ChecksumIEEE(HashTag(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
+
+// HashTag returns the part of the key that is hashed to place it: the
+// substring between its first "{" and the first "}" after it, as in Redis
+// Cluster, so keys such as "{user:1}:profile" and "{user:1}:cart" land on the
+// same node. It is the whole key if there is no such substring or it is
+// empty.
+func HashTag(key []byte) []byte {
+	start := bytes.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := bytes.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
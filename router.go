@@ -0,0 +1,114 @@
+package ggcache
+
+import (
+	"sort"
+	"strings"
+)
+
+// Router assigns keys to the nodes owning them. HashRing routes by
+// consistent hashing, Rendezvous by highest random weight, and PrefixRouter
+// pins key prefixes to nodes; other strategies, such as routing by the
+// locality of the nodes, implement it as well.
+type Router interface {
+	// Get returns the node owning the key, or an empty string if there is
+	// none.
+	Get(key []byte) string
+}
+
+// Rendezvous assigns each key to the node scoring highest for it, the score
+// being a hash of the node and the HashTag of the key. Like a HashRing,
+// adding or removing a node only moves the keys of that node, but the keys
+// are spread evenly without placing nodes at many points, at the cost of
+// scoring every node on each Get.
+// A Rendezvous is not safe for concurrent modification; build a new one
+// when the set of nodes changes.
+type Rendezvous struct {
+	nodes []string
+}
+
+// NewRendezvous creates a Rendezvous over the nodes.
+func NewRendezvous(nodes ...string) *Rendezvous {
+	return &Rendezvous{nodes: append([]string(nil), nodes...)}
+}
+
+// Get returns the node owning the key, or an empty string if there are no
+// nodes. Keys with the same HashTag are owned by the same node.
+func (r *Rendezvous) Get(key []byte) string {
+	tag := HashTag(key)
+	var (
+		owner string
+		best  uint64
+	)
+	for _, node := range r.nodes {
+		// The ties go to the lowest node, so the owner does not depend on
+		// the order the nodes were given in.
+		score := rendezvousScore(node, tag)
+		if len(owner) == 0 || score > best || (score == best && node < owner) {
+			owner, best = node, score
+		}
+	}
+	return owner
+}
+
+// rendezvousScore returns the FNV-1a hash of the node followed by the tag,
+// without allocating.
+func rendezvousScore(node string, tag []byte) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for i := 0; i < len(node); i++ {
+		h ^= uint64(node[i])
+		h *= prime64
+	}
+	for _, b := range tag {
+		h ^= uint64(b)
+		h *= prime64
+	}
+	return h
+}
+
+// PrefixRouter pins the keys starting with given prefixes to nodes, the
+// longest prefix winning, and routes the other keys with a default Router.
+// Pinning splits the keys of a HashTag whose keys start with different
+// prefixes.
+type PrefixRouter struct {
+	// routes maps key prefixes to the nodes owning them, and prefixes are
+	// its keys from the longest.
+	routes   map[string]string
+	prefixes []string
+
+	// def routes the keys without a pinned prefix, nil if none is owned.
+	def Router
+}
+
+// NewPrefixRouter creates a PrefixRouter pinning the prefixes of routes to
+// their nodes and leaving the other keys to def, which may be nil.
+func NewPrefixRouter(routes map[string]string, def Router) *PrefixRouter {
+	r := &PrefixRouter{
+		routes:   make(map[string]string, len(routes)),
+		prefixes: make([]string, 0, len(routes)),
+		def:      def,
+	}
+	for prefix, node := range routes {
+		r.routes[prefix] = node
+		r.prefixes = append(r.prefixes, prefix)
+	}
+	sort.Slice(r.prefixes, func(i, j int) bool { return len(r.prefixes[i]) > len(r.prefixes[j]) })
+	return r
+}
+
+// Get returns the node the longest prefix of the key is pinned to, or the
+// one the default Router returns.
+func (r *PrefixRouter) Get(key []byte) string {
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(string(key), prefix) {
+			return r.routes[prefix]
+		}
+	}
+	if r.def == nil {
+		return ""
+	}
+	return r.def.Get(key)
+}
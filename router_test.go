@@ -0,0 +1,64 @@
+package ggcache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRendezvous(t *testing.T) {
+	assert.Equal(t, "", NewRendezvous().Get([]byte("foo")))
+
+	r := NewRendezvous("a", "b", "c")
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		owners[key] = r.Get([]byte(key))
+		counts[owners[key]]++
+	}
+	for _, node := range []string{"a", "b", "c"} {
+		assert.Greater(t, counts[node], 200)
+	}
+
+	// The owners do not depend on the order of the nodes, and removing a
+	// node only moves its keys.
+	shuffled := NewRendezvous("c", "a", "b")
+	removed := NewRendezvous("a", "c")
+	for key, owner := range owners {
+		assert.Equal(t, owner, shuffled.Get([]byte(key)))
+		if owner != "b" {
+			assert.Equal(t, owner, removed.Get([]byte(key)))
+		}
+	}
+
+	owner := r.Get([]byte("{user:1}:profile"))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, owner, r.Get([]byte(fmt.Sprintf("{user:1}:%d", i))))
+	}
+}
+
+func TestPrefixRouter(t *testing.T) {
+	ring := NewHashRing(0)
+	ring.Add("a", "b", "c")
+	r := NewPrefixRouter(map[string]string{
+		"session:":     "a",
+		"session:eu:":  "b",
+		"billing:":     "pinned",
+		"unrelated:x:": "c",
+	}, ring)
+
+	assert.Equal(t, "a", r.Get([]byte("session:1")))
+	assert.Equal(t, "b", r.Get([]byte("session:eu:1")))
+	assert.Equal(t, "pinned", r.Get([]byte("billing:{user:1}")))
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		assert.Equal(t, ring.Get(key), r.Get(key))
+	}
+
+	// Without a default, only the pinned keys are owned.
+	r = NewPrefixRouter(map[string]string{"session:": "a"}, nil)
+	assert.Equal(t, "a", r.Get([]byte("session:1")))
+	assert.Equal(t, "", r.Get([]byte("key")))
+}
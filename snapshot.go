@@ -0,0 +1,223 @@
+package ggcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+)
+
+// snapshotMagic starts every snapshot, followed by the format version.
+var snapshotMagic = []byte("GGSNAP")
+
+// snapshotVersion is the format written by Snapshot. Version 1, with fixed
+// entry fields instead of records, can still be restored.
+const snapshotVersion byte = 2
+
+// ErrInvalidSnapshot is returned when restoring from data that is not a
+// snapshot or has been corrupted.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// Snapshotter is implemented by Cachers that can write their entries to a
+// stream and load them back, e.g. to back up a node or seed a new one.
+type Snapshotter interface {
+	// Snapshot writes every live entry along with its expiration.
+	Snapshot(w io.Writer) error
+
+	// Restore adds the entries of a snapshot written by Snapshot. Entries
+	// that expired in the meantime are skipped.
+	Restore(r io.Reader) error
+}
+
+// PrefixSnapshotter is implemented by Snapshotters that can write the
+// entries of a single namespace, e.g. to move one tenant to another node.
+type PrefixSnapshotter interface {
+	// SnapshotPrefix writes the live entries whose key starts with prefix like Snapshot.
+	SnapshotPrefix(w io.Writer, prefix []byte) error
+}
+
+// Snapshot writes every entry of the cache to w.
+// It acquires a read lock for the duration of the write, so writes to the
+// cache wait until the snapshot is complete.
+//
+// The format is the magic "GGSNAP", a version byte and the number of entries,
+// followed by each entry as a Record, and finally a CRC32 of everything
+// before it.
+func (c *Cache) Snapshot(w io.Writer) error {
+	return c.SnapshotPrefix(w, nil)
+}
+
+// SnapshotPrefix writes the entries of the cache whose key starts with
+// prefix to w in the format of Snapshot, so Restore loads them back.
+// It acquires a read lock for the duration of the write like Snapshot.
+func (c *Cache) SnapshotPrefix(w io.Writer, prefix []byte) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	p := string(prefix)
+	n := len(c.data)
+	if len(p) != 0 {
+		n = 0
+		for key := range c.data {
+			if strings.HasPrefix(key, p) {
+				n++
+			}
+		}
+	}
+
+	sw := newSnapshotWriter(w, n)
+	for key, e := range c.data {
+		if !strings.HasPrefix(key, p) {
+			continue
+		}
+		rec := Record{Key: []byte(key), Value: e.value}
+		if !e.expiresAt.IsZero() {
+			rec.ExpiresAt = e.expiresAt.UnixNano()
+		}
+		sw.write(&rec)
+	}
+	return sw.close()
+}
+
+// WriteSnapshot writes the records to w in the format of Snapshot, so any
+// Snapshotter restores them, e.g. those read from another format.
+func WriteSnapshot(w io.Writer, recs []*Record) error {
+	sw := newSnapshotWriter(w, len(recs))
+	for _, rec := range recs {
+		sw.write(rec)
+	}
+	return sw.close()
+}
+
+// snapshotWriter writes the header of a snapshot of n records, then each
+// record, then the checksum of all of it.
+type snapshotWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	bw  *bufio.Writer
+	buf []byte
+}
+
+func newSnapshotWriter(w io.Writer, n int) *snapshotWriter {
+	crc := crc32.NewIEEE()
+	sw := &snapshotWriter{w: w, crc: crc, bw: bufio.NewWriter(io.MultiWriter(w, crc))}
+	sw.bw.Write(snapshotMagic)
+	sw.bw.WriteByte(snapshotVersion)
+	_ = binary.Write(sw.bw, binary.LittleEndian, uint64(n))
+	return sw
+}
+
+func (sw *snapshotWriter) write(rec *Record) {
+	sw.buf = rec.AppendBytes(sw.buf[:0])
+	sw.bw.Write(sw.buf)
+}
+
+func (sw *snapshotWriter) close() error {
+	if err := sw.bw.Flush(); err != nil {
+		return err
+	}
+	return binary.Write(sw.w, binary.LittleEndian, sw.crc.Sum32())
+}
+
+// Restore reads a snapshot written by Snapshot and stores its entries with
+// their remaining TTL. The snapshot is verified before any entry is stored,
+// so a corrupt snapshot leaves the cache untouched.
+func (c *Cache) Restore(r io.Reader) error {
+	entries, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.storeBulk(entries, time.Now())
+	return nil
+}
+
+// ReadSnapshot reads and verifies a whole snapshot written by Snapshot and
+// returns its records, e.g. to store them some other way than Restore.
+func ReadSnapshot(r io.Reader) ([]*Record, error) {
+	return readSnapshot(r)
+}
+
+// readSnapshot reads and verifies a whole snapshot.
+func readSnapshot(r io.Reader) ([]*Record, error) {
+	crc := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, crc)
+
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(tr, header); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+	}
+	if string(header[:len(snapshotMagic)]) != string(snapshotMagic) {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidSnapshot)
+	}
+	version := header[len(snapshotMagic)]
+	if version != 1 && version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, version)
+	}
+
+	var n uint64
+	if err := binary.Read(tr, binary.LittleEndian, &n); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+	}
+
+	var entries []*Record
+	for i := uint64(0); i < n; i++ {
+		if version != 1 {
+			rec, err := ReadRecord(tr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+			}
+			entries = append(entries, rec)
+			continue
+		}
+
+		key, err := readSnapshotField(tr)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readSnapshotField(tr)
+		if err != nil {
+			return nil, err
+		}
+		var expiresAt int64
+		if err := binary.Read(tr, binary.LittleEndian, &expiresAt); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+		}
+		entries = append(entries, &Record{Key: key, Value: value, ExpiresAt: expiresAt})
+	}
+
+	sum := crc.Sum32()
+	var want uint32
+	if err := binary.Read(br, binary.LittleEndian, &want); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+	}
+	if sum != want {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)
+	}
+	return entries, nil
+}
+
+func readSnapshotField(r io.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+	}
+	// Read in bounded chunks so a corrupt length cannot force a huge allocation.
+	b, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+	}
+	if len(b) != int(n) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshot, io.ErrUnexpectedEOF)
+	}
+	return b, nil
+}
@@ -0,0 +1,185 @@
+package ggcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_Snapshot(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+	assert.Nil(t, c.Set([]byte("ttl"), []byte("baz"), time.Minute))
+	assert.Nil(t, c.Set([]byte("short"), []byte("lived"), 20*time.Millisecond))
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, c.Snapshot(buf))
+	snapshot := buf.Bytes()
+
+	time.Sleep(40 * time.Millisecond)
+
+	restored := New()
+	assert.Nil(t, restored.Restore(bytes.NewReader(snapshot)))
+
+	value, err := restored.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	assert.True(t, restored.Has([]byte("ttl")))
+	assert.False(t, restored.Has([]byte("short")))
+
+	ttl := time.Until(restored.data["ttl"].expiresAt)
+	assert.Greater(t, ttl, 50*time.Second)
+	assert.LessOrEqual(t, ttl, time.Minute)
+}
+
+func TestCache_SnapshotPrefix(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.Set([]byte("users:1"), []byte("alice"), 0))
+	assert.Nil(t, c.Set([]byte("users:2"), []byte("bob"), time.Minute))
+	assert.Nil(t, c.Set([]byte("orders:1"), []byte("book"), 0))
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, c.SnapshotPrefix(buf, []byte("users:")))
+
+	recs, err := ReadSnapshot(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Len(t, recs, 2)
+
+	restored := New()
+	assert.Nil(t, restored.Restore(buf))
+	assert.True(t, restored.Has([]byte("users:1")))
+	assert.True(t, restored.Has([]byte("users:2")))
+	assert.False(t, restored.Has([]byte("orders:1")))
+}
+
+func TestCache_RestoreExpiry(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.Set([]byte("short"), []byte("a"), 20*time.Millisecond))
+	assert.Nil(t, c.Set([]byte("replaced"), []byte("b"), 20*time.Millisecond))
+	assert.Nil(t, c.Set([]byte("long"), []byte("c"), time.Minute))
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, c.Snapshot(buf))
+
+	restored := New()
+	assert.Nil(t, restored.Restore(buf))
+
+	// The restored expirations share the expiry heap instead of a timer each.
+	assert.Nil(t, restored.data["short"].timer)
+	assert.Len(t, restored.expiry, 3)
+
+	assert.Nil(t, restored.Set([]byte("replaced"), []byte("d"), 0))
+	time.Sleep(40 * time.Millisecond)
+
+	assert.False(t, restored.Has([]byte("short")))
+	assert.True(t, restored.Has([]byte("replaced")))
+	assert.True(t, restored.Has([]byte("long")))
+	assert.Equal(t, uint64(1), restored.Stats().Expirations)
+	assert.Len(t, restored.expiry, 1)
+}
+
+func TestCache_ExpiryStats(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.Set([]byte("short"), []byte("a"), 20*time.Millisecond))
+	assert.Nil(t, c.Set([]byte("other"), []byte("b"), 20*time.Millisecond))
+	assert.Nil(t, c.Set([]byte("long"), []byte("c"), time.Minute))
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, c.Snapshot(buf))
+
+	restored := New()
+	assert.Nil(t, restored.Restore(buf))
+	assert.Nil(t, restored.Set([]byte("timer"), []byte("d"), time.Minute))
+
+	stats := restored.ExpiryStats()
+	assert.Equal(t, 3, stats.Heap)
+	assert.Equal(t, 1, stats.Timers)
+	assert.Equal(t, 0, stats.Backlog)
+	assert.WithinDuration(t, time.Now().Add(20*time.Millisecond), stats.NextExpiry, 20*time.Millisecond)
+
+	// Hold off the sweep of the heap, so the expired entries pile up.
+	restored.lock.Lock()
+	restored.expiryTimer.Stop()
+	restored.lock.Unlock()
+	time.Sleep(40 * time.Millisecond)
+
+	stats = restored.ExpiryStats()
+	assert.Equal(t, 2, stats.Backlog)
+	assert.Equal(t, uint64(0), stats.Sweeps)
+
+	// A forced sweep works off the backlog.
+	assert.Equal(t, 2, restored.Sweep())
+	stats = restored.ExpiryStats()
+	assert.Equal(t, 1, stats.Heap)
+	assert.Equal(t, 0, stats.Backlog)
+	assert.Equal(t, uint64(1), stats.Sweeps)
+	assert.Equal(t, uint64(2), stats.Swept)
+	assert.Equal(t, stats.LastSweep, stats.SweepTime)
+	assert.False(t, restored.Has([]byte("short")))
+	assert.True(t, restored.Has([]byte("long")))
+	assert.Equal(t, uint64(2), restored.Stats().Expirations)
+
+	assert.Nil(t, restored.Delete([]byte("timer")))
+	assert.Equal(t, 0, restored.ExpiryStats().Timers)
+}
+
+func BenchmarkCache_Restore(b *testing.B) {
+	c := New()
+	for i := 0; i < 100_000; i++ {
+		key := []byte(strconv.Itoa(i))
+		_ = c.Set(key, key, time.Hour+time.Duration(i)*time.Millisecond)
+	}
+	buf := new(bytes.Buffer)
+	_ = c.Snapshot(buf)
+	snapshot := buf.Bytes()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = New().Restore(bytes.NewReader(snapshot))
+	}
+}
+
+func TestCache_RestoreCorrupt(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.Set([]byte("foo"), []byte("bar"), 0))
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, c.Snapshot(buf))
+	snapshot := buf.Bytes()
+
+	corrupt := append([]byte(nil), snapshot...)
+	corrupt[len(corrupt)-6] ^= 0xff
+
+	restored := New()
+	for _, b := range [][]byte{nil, []byte("not a snapshot"), snapshot[:len(snapshot)-1], corrupt} {
+		err := restored.Restore(bytes.NewReader(b))
+		assert.True(t, errors.Is(err, ErrInvalidSnapshot), "%v", err)
+	}
+	assert.False(t, restored.Has([]byte("foo")))
+}
+
+func TestCache_RestoreVersion1(t *testing.T) {
+	// A snapshot written before entries were encoded as records.
+	var b []byte
+	b = append(b, snapshotMagic...)
+	b = append(b, 1)
+	b = binary.LittleEndian.AppendUint64(b, 1)
+	b = binary.LittleEndian.AppendUint32(b, 3)
+	b = append(b, "foo"...)
+	b = binary.LittleEndian.AppendUint32(b, 3)
+	b = append(b, "bar"...)
+	b = binary.LittleEndian.AppendUint64(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+
+	c := New()
+	assert.Nil(t, c.Restore(bytes.NewReader(b)))
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+}
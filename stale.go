@@ -0,0 +1,99 @@
+package ggcache
+
+import (
+	"errors"
+	"time"
+)
+
+// ExpiredReads is how the reads of a Cache answer for an entry found past its
+// expiration before it was removed in the background.
+type ExpiredReads int
+
+const (
+	// ExpiredDelete removes the entry and answers as if it was not found.
+	// It is the default.
+	ExpiredDelete ExpiredReads = iota
+
+	// ExpiredStale serves the value along with ErrStale. The entry is
+	// removed in the background as usual.
+	ExpiredStale
+
+	// ExpiredExtend stores the value again with the extension as its TTL
+	// and serves it as a hit.
+	ExpiredExtend
+)
+
+// ErrStale is returned, along with the value, by the reads of an entry past
+// its expiration under ExpiredStale.
+var ErrStale = errors.New("stale value")
+
+// WithExpiredReads sets how Get and GetRange answer for an entry that expired
+// but was not removed yet, which otherwise depends on whether its timer
+// already fired. The extension is only used by ExpiredExtend; zero keeps the
+// value without expiration. Has, Expiry and the scans always skip the entry.
+func WithExpiredReads(policy ExpiredReads, extension time.Duration) Option {
+	return func(c *Cache) {
+		c.expiredReads = policy
+		c.extension = extension
+	}
+}
+
+// lookup returns the entry of the key for a read, nil if there is none.
+// An entry past its expiration is handled as set by WithExpiredReads, and
+// stale reports whether it is served anyway.
+// The caller must not hold the lock.
+func (c *Cache) lookup(key string) (e *entry, stale bool) {
+	c.lock.RLock()
+	e, ok := c.data[key]
+	if ok && !e.pastExpiry(time.Now()) {
+		c.accessed(e)
+		c.lock.RUnlock()
+		return e, false
+	}
+	c.lock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	switch c.expiredReads {
+	case ExpiredStale:
+		c.expiredOnRead(e)
+		c.stats.stale.Add(1)
+		return e, true
+	case ExpiredExtend:
+		return c.extend(key), false
+	default:
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		if c.data[key] == e {
+			c.expiredOnRead(e)
+			c.remove(key)
+			c.removed(key, RemovalExpired)
+		}
+		return nil, false
+	}
+}
+
+// extend stores the value of the key again with the extension as its TTL if
+// it expired, and returns its entry, nil if it was removed in the meantime.
+func (c *Cache) extend(key string) *entry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.data[key]
+	if !ok {
+		return nil
+	}
+	if e.pastExpiry(time.Now()) {
+		e = c.restamp(key, e, c.extension)
+		c.stats.extended.Add(1)
+	}
+	c.accessed(e)
+	return e
+}
+
+// pastExpiry reports whether the entry expired by now.
+func (e *entry) pastExpiry(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
@@ -0,0 +1,117 @@
+package ggcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// numTTLBuckets is the number of bounds of the TTL histogram.
+const numTTLBuckets = 8
+
+// TTLBuckets are the upper bounds of the buckets of Stats.TTLs.
+var TTLBuckets = [numTTLBuckets]time.Duration{
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// ttlBucket returns the index in Stats.TTLs of the bucket of a TTL.
+func ttlBucket(ttl time.Duration) int {
+	for i, bound := range TTLBuckets {
+		if ttl <= bound {
+			return i
+		}
+	}
+	return numTTLBuckets
+}
+
+// Stats is a point-in-time snapshot of the cache counters.
+// The counters are cumulative since the cache was created.
+type Stats struct {
+	// Hits and Misses count Get calls that did and did not find their key.
+	Hits   uint64
+	Misses uint64
+
+	// Sets and Deletes count successful writes and removals of present keys.
+	Sets    uint64
+	Deletes uint64
+
+	// Expirations counts entries removed because their TTL ran out. Of
+	// these, LazyExpirations were found expired by a read before they were
+	// removed in the background. Cachers that do not tell them apart leave
+	// LazyExpirations zero.
+	Expirations     uint64
+	LazyExpirations uint64
+
+	// Stale counts the reads that served an expired value with ErrStale,
+	// and Extended the expired entries stored again by a read, both of
+	// which are counted as hits, as set by WithExpiredReads.
+	Stale    uint64
+	Extended uint64
+
+	// Evictions counts entries removed because they were not accessed
+	// within the max idle time.
+	Evictions uint64
+
+	// Corrupted counts the reads that failed with ErrCorrupted.
+	Corrupted uint64
+
+	// Keys is the number of entries currently stored.
+	Keys int
+
+	// Bytes is the total size of the keys and values currently stored.
+	Bytes int
+
+	// TTLs is the histogram of the TTLs the entries currently stored were
+	// set with: TTLs[i] counts those of at most TTLBuckets[i] and more than
+	// the previous bound, and the last bucket those of more than a week.
+	// Entries without a TTL are not counted.
+	TTLs [numTTLBuckets + 1]int
+}
+
+// StatsProvider is implemented by Cachers that can report their Stats.
+type StatsProvider interface {
+	Stats() Stats
+}
+
+// counters groups the atomic operation counters of the cache so they can be
+// updated while holding only the read lock.
+type counters struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	sets        atomic.Uint64
+	deletes     atomic.Uint64
+	expirations atomic.Uint64
+	lazy        atomic.Uint64
+	evictions   atomic.Uint64
+	stale       atomic.Uint64
+	extended    atomic.Uint64
+}
+
+// Stats returns a snapshot of the cache counters along with the current
+// number of keys and their total size.
+func (c *Cache) Stats() Stats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	lazy := c.stats.lazy.Load()
+	return Stats{
+		Hits:            c.stats.hits.Load(),
+		Misses:          c.stats.misses.Load(),
+		Sets:            c.stats.sets.Load(),
+		Deletes:         c.stats.deletes.Load(),
+		Expirations:     c.stats.expirations.Load() + lazy,
+		LazyExpirations: lazy,
+		Stale:           c.stats.stale.Load(),
+		Extended:        c.stats.extended.Load(),
+		Evictions:       c.stats.evictions.Load(),
+		Keys:            len(c.data),
+		Bytes:           c.bytes,
+		TTLs:            c.ttls,
+	}
+}
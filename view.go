@@ -0,0 +1,106 @@
+package ggcache
+
+import (
+	"fmt"
+	"maps"
+	"time"
+)
+
+// View is an immutable point-in-time view of a Cache, for exporters and
+// bulk analytics that walk all of it. It is read without taking the lock of
+// the cache, so a long walk does not hold up its writers, and the loop body
+// may call into the cache.
+//
+// A View shares the entries of the cache, which are never modified once
+// stored. The map holding them is copied on write: the first write to the
+// cache after View copies it, which takes time proportional to the number of
+// keys, so Views are meant to be taken once per job rather than per request.
+type View struct {
+	data map[string]*entry
+	at   time.Time
+}
+
+// View returns a view of the live entries of the cache as of now. The
+// entries that expire after now are still in the view, which does not
+// count hits or mark entries accessed.
+func (c *Cache) View() View {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.shared = true
+	return View{data: c.data, at: time.Now()}
+}
+
+// unshare copies the map of the entries if a View holds it, so that it can
+// be written. The caller must hold the write lock.
+func (c *Cache) unshare() {
+	if c.shared {
+		c.data = maps.Clone(c.data)
+		c.shared = false
+	}
+}
+
+// Time returns when the view was taken.
+func (v View) Time() time.Time {
+	return v.at
+}
+
+// Get returns the value the key had when the view was taken.
+// If the key was not found or had expired, the error wraps ErrKeyNotFound.
+func (v View) Get(key []byte) ([]byte, error) {
+	e, ok := v.data[string(key)]
+	if !ok || e.pastExpiry(v.at) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return e.value[:len(e.value):len(e.value)], nil
+}
+
+// Has reports whether the key was in the cache when the view was taken.
+func (v View) Has(key []byte) bool {
+	e, ok := v.data[string(key)]
+	return ok && !e.pastExpiry(v.at)
+}
+
+// Expiry returns when the entry of the key expires, the zero Time if it does
+// not. If the key is not in the view, the error wraps ErrKeyNotFound.
+func (v View) Expiry(key []byte) (time.Time, error) {
+	e, ok := v.data[string(key)]
+	if !ok || e.pastExpiry(v.at) {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return e.expiresAt, nil
+}
+
+// Len returns the number of entries of the view. It walks all of them.
+func (v View) Len() int {
+	n := 0
+	for _, e := range v.data {
+		if !e.pastExpiry(v.at) {
+			n++
+		}
+	}
+	return n
+}
+
+// Range calls fn with the key and value of the entries of the view, in no
+// particular order, until fn returns false.
+func (v View) Range(fn func(key, value []byte) bool) {
+	for key, e := range v.data {
+		if e.pastExpiry(v.at) {
+			continue
+		}
+		if !fn([]byte(key), e.value[:len(e.value):len(e.value)]) {
+			return
+		}
+	}
+}
+
+// Scan calls fn with the keys of the view matching the pattern, as of
+// MatchKey, until fn returns false.
+func (v View) Scan(pattern []byte, fn func(key []byte) bool) {
+	for key, e := range v.data {
+		if !e.pastExpiry(v.at) && MatchKey(pattern, []byte(key)) && !fn([]byte(key)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ggcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestCache_View tests that a View keeps the entries of the Cache as of when it was taken.
+func TestCache_View(t *testing.T) {
+	cache := New()
+	_ = cache.Set([]byte("a"), []byte("1"), 0)
+	_ = cache.Set([]byte("b"), []byte("2"), time.Hour)
+	_ = cache.Set([]byte("expired"), []byte("3"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	view := cache.View()
+
+	// Test Case 1: The view holds the live entries
+	if n := view.Len(); n != 2 {
+		t.Errorf("Expected 2 entries in the view, but got %d", n)
+	}
+	if value, err := view.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("Expected value 1, but got %q, %v", value, err)
+	}
+	if _, err := view.Get([]byte("expired")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for an expired key, but got %v", err)
+	}
+	if expiry, err := view.Expiry([]byte("b")); err != nil || expiry.IsZero() {
+		t.Errorf("Expected the expiry of b, but got %v, %v", expiry, err)
+	}
+
+	// Test Case 2: Writes made while walking the view do not block nor change it
+	view.Range(func(key, value []byte) bool {
+		_ = cache.Delete(key)
+		_ = cache.Set([]byte("new"), []byte("4"), 0)
+		_ = cache.Set([]byte("a"), []byte("5"), 0)
+		return true
+	})
+	if value, err := view.Get([]byte("a")); err != nil || string(value) != "1" {
+		t.Errorf("Expected the view to keep value 1, but got %q, %v", value, err)
+	}
+	if view.Has([]byte("new")) {
+		t.Error("Expected a key set after the view was taken not to be in it")
+	}
+	if value, err := cache.Get([]byte("a")); err != nil || string(value) != "5" {
+		t.Errorf("Expected the cache to hold value 5, but got %q, %v", value, err)
+	}
+	if cache.Has([]byte("b")) {
+		t.Error("Expected the key deleted from the cache to be gone from it")
+	}
+
+	// Test Case 3: Scan visits the matching keys of the view
+	var keys []string
+	view.Scan([]byte("?"), func(key []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	if len(keys) != 2 {
+		t.Errorf("Expected keys a and b, but got %v", keys)
+	}
+}